@@ -0,0 +1,414 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Micro-benchmarks of the local host, to size a hub's settings
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/capture"
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/ipx"
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+	"github.com/mlapointe/ipxtransporter/internal/peer"
+	"github.com/mlapointe/ipxtransporter/internal/relay"
+	"github.com/spf13/pflag"
+)
+
+// benchPlayerRate is the packets per second one player of a typical IPX
+// game sends, for the estimate of how many a hub carries.
+const benchPlayerRate = 30
+
+// benchResults are the rates measured, 0 where a test was skipped or
+// failed.
+type benchResults struct {
+	dedup1, dedupAll float64 // packets hashed and looked up per second, on one core and on all
+	framing          float64 // packets per second over one TLS peer link
+	handshakes       float64 // TLS handshakes per second, on all cores
+	inject           float64 // packets injected per second
+	size             int     // bytes in each packet
+}
+
+// runBench implements "ipxtransporter bench": it measures how fast this
+// machine hashes packets for deduplication, frames them over a TLS peer
+// link, completes TLS handshakes and, given --interface, injects packets,
+// then suggests settings for a hub on it. The config at --config, if it
+// exists, supplies the current settings and the TLS key.
+func runBench(args []string) error {
+	fs := pflag.NewFlagSet("bench", pflag.ContinueOnError)
+	configPath := fs.String("config", "/etc/ipxtransporter.json", "Config file whose settings are compared and whose TLS key is used")
+	duration := fs.Duration("duration", 2*time.Second, "How long each test runs")
+	size := fs.Int("size", 100, "IPX packet size in bytes, header included")
+	iface := fs.String("interface", "", "Interface to measure injection on; this puts IPX broadcasts on its segment")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *duration <= 0 || *size < 38 || *size > 1500 {
+		return errors.New("need --duration > 0 and --size between 38 and 1500")
+	}
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	// Only failures are worth a log line next to the report.
+	logger.SetLevel("warn")
+	cores := runtime.GOMAXPROCS(0)
+	fmt.Printf("bench: %d CPUs (%s/%s), %d byte packets, %s per test\n\n", cores, runtime.GOOS, runtime.GOARCH, *size, *duration)
+
+	r := benchResults{size: *size}
+	// Each result is printed as soon as it is in, so the column is fixed.
+	report := func(test, result string, err error) {
+		if err != nil {
+			result = "failed: " + err.Error()
+		}
+		fmt.Printf("%-16s%s\n", test, result)
+	}
+	onCores := "on 1 core"
+	if cores > 1 {
+		onCores = fmt.Sprintf("on %d cores", cores)
+	}
+
+	r.dedup1 = benchDedup(cfg, *duration, *size, 1)
+	r.dedupAll = r.dedup1
+	result := formatRate(r.dedup1) + " packets/s on 1 core"
+	if cores > 1 {
+		r.dedupAll = benchDedup(cfg, *duration, *size, cores)
+		result += fmt.Sprintf(", %s %s", formatRate(r.dedupAll), onCores)
+	}
+	report("dedup hashing", result, nil)
+
+	cert, err := benchCert(cfg)
+	if err != nil {
+		report("framing (TLS)", "", err)
+		report("TLS handshakes", "", err)
+	} else {
+		r.framing, err = benchFraming(cert, *duration, *size, cfg.PeerQueueBytes)
+		report("framing (TLS)", fmt.Sprintf("%s packets/s, %.0f Mbit/s", formatRate(r.framing), r.framing*float64(*size)*8/1e6), err)
+		r.handshakes, err = benchHandshakes(cert, *duration, cores)
+		report("TLS handshakes", fmt.Sprintf("%.0f/s %s, both ends", r.handshakes, onCores), err)
+	}
+
+	if *iface == "" {
+		report("pcap injection", "skipped; --interface measures it", nil)
+	} else {
+		var sent, failed uint64
+		r.inject, sent, failed, err = benchInject(*iface, *duration, *size)
+		report("pcap injection", fmt.Sprintf("%s packets/s on %s (%d sent, %d failed)", formatRate(r.inject), *iface, sent, failed), err)
+	}
+
+	fmt.Println()
+	suggestSettings(cfg, r, cores)
+	return nil
+}
+
+// benchDedup hashes distinct packets into one dedup cache from workers
+// goroutines, as the relay's workers share one.
+func benchDedup(cfg *config.Config, d time.Duration, size, workers int) float64 {
+	cache, err := relay.NewDedupCache(max(cfg.DedupCacheSize, 1), cfg.DedupCacheTTL)
+	if err != nil {
+		return 0
+	}
+	var done atomic.Uint64
+	var wg sync.WaitGroup
+	deadline := time.Now().Add(d)
+	start := time.Now()
+	for i := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			node := []byte{0x02, 0x42, 0x45, 0x4e, byte(i >> 8), byte(i)}
+			packet := loadPacket(size, 0, node, 0)
+			n := uint64(0)
+			for ; n%1024 != 0 || time.Now().Before(deadline); n++ {
+				// The sequence number makes every packet distinct, as
+				// most relayed packets are.
+				packet[30], packet[31], packet[32], packet[33] = byte(n>>24), byte(n>>16), byte(n>>8), byte(n)
+				cache.IsDuplicate(packet)
+			}
+			done.Add(n)
+		}()
+	}
+	wg.Wait()
+	return float64(done.Load()) / time.Since(start).Seconds()
+}
+
+// benchCert returns the node's TLS certificate, or a throwaway one like it
+// if the config names none.
+func benchCert(cfg *config.Config) (tls.Certificate, error) {
+	if cfg.TLSCertPath != "" && cfg.TLSKeyPath != "" {
+		return tls.LoadX509KeyPair(cfg.TLSCertPath, cfg.TLSKeyPath)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "ipxtransporter bench"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// benchListener accepts TLS 1.3 links on the loopback interface with cert,
+// as a hub does, and hands each to serve.
+func benchListener(ctx context.Context, cert tls.Certificate, serve func(net.Conn)) (string, error) {
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS13})
+	if err != nil {
+		return "", err
+	}
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+	return l.Addr().String(), nil
+}
+
+// benchFraming sends packets over one TLS peer link on the loopback
+// interface as fast as its send queue takes them, and counts those the
+// far end reads.
+func benchFraming(cert tls.Certificate, d time.Duration, size, queueBytes int) (float64, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var received atomic.Uint64
+	relayed := make(chan []byte, 4096)
+	go func() {
+		for range relayed {
+			received.Add(1)
+		}
+	}()
+	addr, err := benchListener(ctx, cert, func(conn net.Conn) {
+		p := peer.NewPeer("bench-rx", conn, "bench")
+		p.NoLookup = true
+		p.Run(ctx, relayed, func(string) {})
+	})
+	if err != nil {
+		return 0, err
+	}
+	conn, err := dialLoad(addr, false)
+	if err != nil {
+		return 0, err
+	}
+	p := peer.NewPeer("bench-tx", conn, "bench")
+	p.NoLookup = true
+	p.SendBudget = queueBytes
+	go p.Run(ctx, make(chan []byte), func(string) {})
+	for wait := time.Now().Add(5 * time.Second); !p.Authenticated(); time.Sleep(time.Millisecond) {
+		if time.Now().After(wait) {
+			return 0, errors.New("the link did not come up")
+		}
+	}
+
+	frame, err := ipx.EncapEthernetII(loadPacket(size, 0, []byte{0x02, 0x42, 0x45, 0x4e, 0, 1}, 0))
+	if err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	for deadline := start.Add(d); time.Now().Before(deadline); {
+		if !p.Send(frame) {
+			// The queue is full: let the writer drain it.
+			time.Sleep(50 * time.Microsecond)
+		}
+	}
+	return float64(received.Load()) / time.Since(start).Seconds(), nil
+}
+
+// benchHandshakes completes TLS handshakes with a loopback listener from
+// one client per core.
+func benchHandshakes(cert tls.Certificate, d time.Duration, workers int) (float64, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	addr, err := benchListener(ctx, cert, func(conn net.Conn) {
+		conn.(*tls.Conn).Handshake()
+		conn.Close()
+	})
+	if err != nil {
+		return 0, err
+	}
+	var done atomic.Uint64
+	var firstErr atomic.Value
+	var wg sync.WaitGroup
+	deadline := time.Now().Add(d)
+	start := time.Now()
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for time.Now().Before(deadline) {
+				conn, err := dialLoad(addr, false)
+				if err != nil {
+					firstErr.CompareAndSwap(nil, err)
+					return
+				}
+				conn.Close()
+				done.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if err, _ := firstErr.Load().(error); err != nil && done.Load() == 0 {
+		return 0, err
+	}
+	return float64(done.Load()) / time.Since(start).Seconds(), nil
+}
+
+// benchInject injects IPX broadcasts on iface as fast as the handle takes
+// them. Failures, a full socket buffer most often, are counted, not fatal.
+func benchInject(iface string, d time.Duration, size int) (rate float64, sent, failed uint64, err error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	captured := make(chan []byte, 1024)
+	go func() {
+		for range captured {
+		}
+	}()
+	c := capture.NewCapturer(iface)
+	if err := c.Start(ctx, captured); err != nil {
+		return 0, 0, 0, err
+	}
+	node := []byte{0x02, 0x42, 0x45, 0x4e, 0, 2}
+	start := time.Now()
+	for seq := uint64(0); time.Since(start) < d; seq++ {
+		frame, _ := ipx.EncapEthernetII(loadPacket(size, 0, node, seq))
+		if err := c.Inject(frame); err != nil {
+			if capture.ClassifyError(err) != capture.ErrClassTransient {
+				return 0, sent, failed, err
+			}
+			failed++
+			continue
+		}
+		sent++
+	}
+	return float64(sent) / time.Since(start).Seconds(), sent, failed, nil
+}
+
+// suggestSettings prints settings sized for the rates measured, beside
+// the current ones.
+func suggestSettings(cfg *config.Config, r benchResults, cores int) {
+	// The packets per second a hub on this machine relays: each is hashed
+	// once, sent over peer links and, for the local segment, injected.
+	capacity := r.dedupAll
+	for _, v := range []float64{r.framing, r.inject} {
+		if v > 0 {
+			capacity = min(capacity, v)
+		}
+	}
+	mem := totalMemory()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SETTING\tSUGGESTED\tCURRENT\tWHY")
+	suggest := func(name string, value, current int, why string) {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\n", name, value, current, why)
+	}
+
+	// Enough entries to remember dedup_cache_ttl seconds of a quarter of
+	// the capacity, in at most 1/16 of the memory at about 160 bytes each.
+	entries := int(capacity / 4 * float64(max(cfg.DedupCacheTTL, 1)))
+	if mem > 0 {
+		entries = min(entries, int(mem/16/160))
+	}
+	suggest("dedup_cache_size", roundTo(clamp(entries, 16000, 4000000), 1000), cfg.DedupCacheSize,
+		fmt.Sprintf("%ds of a quarter of the %s packets/s relayed", cfg.DedupCacheTTL, formatRate(capacity)))
+
+	suggest("broadcast_workers", clamp(cores, 1, 4), cfg.BroadcastWorkers, "one per core, up to 4")
+	injectWorkers, why := 1, "one handle injects a packet at a time"
+	if cores > 1 && r.inject > 0 && r.inject < capacity*2 {
+		injectWorkers, why = 2, "injection is the bottleneck; a second worker overlaps the rest"
+	}
+	suggest("inject_workers", injectWorkers, cfg.InjectWorkers, why)
+	suggest("writer_workers", 0, cfg.WriterWorkers, fmt.Sprintf("0 picks %d, twice the cores and at least 4", max(4, 2*cores)))
+
+	// A tenth of a second of what one link carries absorbs a burst; every
+	// child may fill its queue, so they share 1/8 of the memory.
+	queue := int(r.framing * float64(r.size) / 10)
+	if mem > 0 {
+		queue = min(queue, int(mem/8/uint64(max(cfg.MaxChildren, 1))))
+	}
+	if queue > 0 {
+		suggest("peer_queue_bytes", roundTo(clamp(queue, 64*1024, 4*1024*1024), 1024), cfg.PeerQueueBytes,
+			fmt.Sprintf("0.1s of a link's %s packets/s", formatRate(r.framing)))
+	}
+	w.Flush()
+
+	if capacity > 0 {
+		// A hub sends each player's packets to every other player.
+		players := int(math.Sqrt(capacity / benchPlayerRate))
+		fmt.Printf("\nThis machine relays about %s packets/s: some %d players at %d packets/s each through one hub.\n",
+			formatRate(capacity), players, benchPlayerRate)
+	}
+	if r.handshakes > 0 {
+		fmt.Printf("After a restart %d peers can relink within a second.\n", int(r.handshakes))
+	}
+}
+
+// totalMemory returns the machine's memory in bytes, or 0 where it is not
+// known.
+func totalMemory() uint64 {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var kb uint64
+		if _, err := fmt.Sscanf(sc.Text(), "MemTotal: %d kB", &kb); err == nil {
+			return kb * 1024
+		}
+	}
+	return 0
+}
+
+func clamp(v, lo, hi int) int {
+	return min(max(v, lo), hi)
+}
+
+func roundTo(v, step int) int {
+	return (v + step/2) / step * step
+}
+
+// formatRate shortens a rate to thousands or millions.
+func formatRate(v float64) string {
+	switch {
+	case v >= 1e6:
+		return strings.TrimSuffix(fmt.Sprintf("%.1f", v/1e6), ".0") + "M"
+	case v >= 1e3:
+		return fmt.Sprintf("%.0fk", v/1e3)
+	}
+	return fmt.Sprintf("%.0f", v)
+}
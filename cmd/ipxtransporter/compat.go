@@ -0,0 +1,84 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Wire compatibility checks against recorded transcripts and remote nodes
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/compat"
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+	"github.com/spf13/pflag"
+)
+
+// runCompat implements "ipxtransporter compat test": it replays the
+// transcripts recorded from earlier protocol generations, and those given
+// with --transcript, against this build's peer links, probes --peer if
+// set, and prints which protocol features interoperate. It fails if one
+// does not.
+func runCompat(args []string) error {
+	fs := pflag.NewFlagSet("compat", pflag.ContinueOnError)
+	remote := fs.String("peer", "", "Remote node to probe, host:port")
+	networkKey := fs.String("network-key", "", "Network key of the remote node")
+	disableSSL := fs.Bool("disable-ssl", false, "Connect to the remote node without TLS")
+	files := fs.StringArray("transcript", nil, "Also replay this transcript file, may be repeated")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || fs.Arg(0) != "test" {
+		return errors.New("usage: ipxtransporter compat test [--peer addr] [--network-key key] [--transcript file]")
+	}
+
+	// Only failures are worth a log line next to the report.
+	logger.SetLevel("warn")
+	transcripts, err := compat.Transcripts()
+	if err != nil {
+		return err
+	}
+	for _, f := range *files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return err
+		}
+		t, err := compat.ParseTranscript(data)
+		if err != nil {
+			return fmt.Errorf("%s: %v", f, err)
+		}
+		transcripts = append(transcripts, t)
+	}
+	var results []compat.Result
+	for _, t := range transcripts {
+		results = append(results, compat.Replay(t)...)
+	}
+	if *remote != "" {
+		conn, err := dialLoad(*remote, *disableSSL)
+		if err != nil {
+			results = append(results, compat.Result{Source: *remote, Feature: "connect", Status: compat.StatusFailed, Detail: err.Error()})
+		} else {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			results = append(results, compat.Probe(ctx, conn, *networkKey)...)
+			cancel()
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SOURCE\tFEATURE\tRESULT\tDETAIL")
+	failed := 0
+	for _, r := range results {
+		if r.Status == compat.StatusFailed {
+			failed++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r.Source, r.Feature, r.Status, r.Detail)
+	}
+	w.Flush()
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(results))
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// --gen-cert: a self-signed certificate for the peer listener
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/peer"
+)
+
+// certValidity is how long a generated certificate lasts. Peers pin its
+// key rather than trust a CA, so there is nothing to gain from renewing it.
+const certValidity = 10 * 365 * 24 * time.Hour
+
+// genCert writes a new key and a self-signed certificate for it to the
+// config's tls_cert_path and tls_key_path and prints the key's
+// fingerprint for peers to pin. With neither set, they are written beside
+// the config file and the paths saved to it if save is set. Existing files
+// are never overwritten: a new key changes the fingerprint peers pinned.
+func genCert(cfg *config.Config, configPath string, save bool) error {
+	certPath, keyPath := cfg.TLSCertPath, cfg.TLSKeyPath
+	newPaths := certPath == "" && keyPath == ""
+	switch {
+	case newPaths:
+		dir, err := filepath.Abs(filepath.Dir(configPath))
+		if err != nil {
+			return err
+		}
+		certPath, keyPath = filepath.Join(dir, "ipxtransporter.crt"), filepath.Join(dir, "ipxtransporter.key")
+	case certPath == "" || keyPath == "":
+		return errors.New("set both tls_cert_path and tls_key_path, or neither")
+	}
+	for _, path := range []string{certPath, keyPath} {
+		if _, err := os.Stat(path); err == nil {
+			return fmt.Errorf("%s exists; move it away to generate a new certificate, and give peers the new fingerprint", path)
+		}
+	}
+
+	fp, notAfter, err := newCert(certPath, keyPath)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Certificate: %s (valid until %s)\nKey:         %s\n", certPath, notAfter.Format(time.DateOnly), keyPath)
+
+	if newPaths {
+		cfg.TLSCertPath, cfg.TLSKeyPath = certPath, keyPath
+		if !save {
+			fmt.Printf("Set tls_cert_path and tls_key_path in %s to use them.\n", configPath)
+		} else if err := config.SaveConfig(configPath, cfg); err != nil {
+			return fmt.Errorf("saving the paths to %s: %v", configPath, err)
+		} else {
+			fmt.Printf("Saved the paths to %s.\n", configPath)
+		}
+	}
+	fmt.Printf("Fingerprint: %s\nPeers pin this node with \"fingerprint\": %q in its entry in their peers.\n", fp, fp)
+	return nil
+}
+
+// newCert writes a new key and a self-signed certificate for it, and
+// returns the key's fingerprint and when the certificate expires.
+func newCert(certPath, keyPath string) (string, time.Time, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	host, _ := os.Hostname()
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: host},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(certValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+	}
+	if host != "" {
+		tmpl.DNSNames = []string{host}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	// The key first, so a certificate is never left without it.
+	if err := writeNew(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return "", time.Time{}, err
+	}
+	if err := writeNew(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		os.Remove(keyPath)
+		return "", time.Time{}, err
+	}
+	fp, err := peer.Fingerprint(key.Public())
+	return fp, tmpl.NotAfter, err
+}
+
+// writeNew writes data to a file that must not exist yet.
+func writeNew(path string, data []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,260 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Load generator opening many synthetic peer links to a hub
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/ipx"
+	"github.com/mlapointe/ipxtransporter/internal/peer"
+	"github.com/spf13/pflag"
+)
+
+// loadgen counters, shared by all synthetic peers.
+type loadCounters struct {
+	links    atomic.Int64
+	failed   atomic.Uint64
+	sent     atomic.Uint64
+	dropped  atomic.Uint64 // frames that did not fit the link's send queue
+	received atomic.Uint64
+}
+
+// runLoadgen implements "ipxtransporter loadgen": it links --peers synthetic
+// nodes to --target and has each send IPX broadcasts at --rate packets per
+// second, printing throughput and link latency every --interval. With
+// --ipxnet it also plays a machine on the hub's LAN whose broadcasts the
+// hub has to fan out to every link.
+func runLoadgen(args []string) error {
+	fs := pflag.NewFlagSet("loadgen", pflag.ContinueOnError)
+	target := fs.String("target", "127.0.0.1:8787", "Hub peer address")
+	peers := fs.Int("peers", 1000, "Number of peer links")
+	rate := fs.Float64("rate", 20, "Packets per second sent on each link")
+	size := fs.Int("size", 100, "IPX packet size in bytes, header included")
+	duration := fs.Duration("duration", time.Minute, "How long to run once links are opened")
+	ramp := fs.Duration("ramp", 10*time.Second, "Time over which links are opened")
+	interval := fs.Duration("interval", 5*time.Second, "Reporting interval")
+	networkKey := fs.String("network-key", "", "Network key of the hub")
+	disableSSL := fs.Bool("disable-ssl", false, "Connect without TLS")
+	ipxnetAddr := fs.String("ipxnet", "", "Hub IPXNET address to send LAN broadcasts to, fanned out to every link")
+	lanRate := fs.Float64("lan-rate", 20, "Packets per second sent through --ipxnet")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *peers < 1 || *rate <= 0 || *size < 38 || *size > 1500 {
+		return fmt.Errorf("need --peers >= 1, --rate > 0 and --size between 38 and 1500")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *ramp+*duration)
+	defer cancel()
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		cancel()
+	}()
+
+	var c loadCounters
+	relayed := make(chan []byte, 4096)
+	go func() {
+		for range relayed {
+			c.received.Add(1)
+		}
+	}()
+
+	if *ipxnetAddr != "" {
+		if err := startLAN(ctx, *ipxnetAddr, *lanRate, *size); err != nil {
+			return err
+		}
+	}
+
+	var (
+		mu    sync.Mutex
+		links []*peer.Peer
+	)
+	fmt.Printf("loadgen: %d links to %s, %.0f pps each, %d byte packets, for %s after a %s ramp\n",
+		*peers, *target, *rate, *size, *duration, *ramp)
+	for i := range *peers {
+		go func() {
+			if !sleepUntil(ctx, time.Duration(i)*(*ramp)/time.Duration(*peers)) {
+				return
+			}
+			conn, err := dialLoad(*target, *disableSSL)
+			if err != nil {
+				if c.failed.Add(1) == 1 {
+					fmt.Fprintf(os.Stderr, "loadgen: %v\n", err)
+				}
+				return
+			}
+			p := peer.NewPeer(fmt.Sprintf("load-%d", i), conn, *networkKey)
+			p.NoLookup = true
+			mu.Lock()
+			links = append(links, p)
+			mu.Unlock()
+			c.links.Add(1)
+			go sendLoad(ctx, p, i, *rate, *size, &c)
+			// Links stay up until the process exits, so the final report
+			// still counts them.
+			p.Run(context.Background(), relayed, func(string) { c.links.Add(-1) })
+		}()
+	}
+
+	start := time.Now()
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	var lastSent, lastRecv uint64
+	last := start
+	report := func(now time.Time) {
+		mu.Lock()
+		var rtts []float64
+		for _, p := range links {
+			if ms := p.GetStats().LatencyMs; ms > 0 {
+				rtts = append(rtts, ms)
+			}
+		}
+		mu.Unlock()
+		sent, recv := c.sent.Load(), c.received.Load()
+		secs := now.Sub(last).Seconds()
+		fmt.Printf("%6.0fs  links %5d  failed %d  tx %8.0f pps  rx %8.0f pps  queue drops %d  rtt p50 %s p99 %s\n",
+			now.Sub(start).Seconds(), c.links.Load(), c.failed.Load(),
+			float64(sent-lastSent)/secs, float64(recv-lastRecv)/secs, c.dropped.Load(),
+			percentile(rtts, 0.5), percentile(rtts, 0.99))
+		lastSent, lastRecv, last = sent, recv, now
+	}
+	for {
+		select {
+		case now := <-ticker.C:
+			report(now)
+		case <-ctx.Done():
+			report(time.Now())
+			fmt.Printf("loadgen: sent %d, received %d, dropped %d, failed links %d\n",
+				c.sent.Load(), c.received.Load(), c.dropped.Load(), c.failed.Load())
+			return nil
+		}
+	}
+}
+
+func dialLoad(addr string, disableSSL bool) (net.Conn, error) {
+	d := &net.Dialer{Timeout: 10 * time.Second}
+	if disableSSL {
+		return d.Dial("tcp", addr)
+	}
+	return tls.DialWithDialer(d, "tcp", addr, &tls.Config{InsecureSkipVerify: true, MinVersion: tls.VersionTLS13})
+}
+
+// sendLoad queues IPX broadcasts from a node address unique to link i.
+// Each frame carries a sequence number so the hub does not drop it as a
+// duplicate.
+func sendLoad(ctx context.Context, p *peer.Peer, i int, rate float64, size int, c *loadCounters) {
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / rate))
+	defer ticker.Stop()
+	node := []byte{0x02, 0x4c, 0x47, byte(i >> 16), byte(i >> 8), byte(i)}
+	for seq := uint64(0); ; seq++ {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		frame, _ := ipx.EncapEthernetII(loadPacket(size, 0, node, seq))
+		if p.Send(frame) {
+			c.sent.Add(1)
+		} else {
+			c.dropped.Add(1)
+		}
+	}
+}
+
+// loadPacket builds an IPX broadcast from network.node carrying seq.
+func loadPacket(size int, network uint32, node []byte, seq uint64) []byte {
+	packet := make([]byte, size)
+	binary.BigEndian.PutUint16(packet[0:2], 0xffff)
+	binary.BigEndian.PutUint16(packet[2:4], uint16(size))
+	packet[5] = 4 // packet exchange
+	binary.BigEndian.PutUint32(packet[6:10], network)
+	copy(packet[10:16], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	binary.BigEndian.PutUint16(packet[16:18], 0x4000)
+	binary.BigEndian.PutUint32(packet[18:22], network)
+	copy(packet[22:28], node)
+	binary.BigEndian.PutUint16(packet[28:30], 0x4000)
+	binary.BigEndian.PutUint64(packet[30:38], seq)
+	return packet
+}
+
+// startLAN registers with the hub's IPXNET server like DOSBox does and
+// sends broadcasts from the assigned address at rate packets per second.
+func startLAN(ctx context.Context, addr string, rate float64, size int) error {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return err
+	}
+	reg := make([]byte, 30)
+	binary.BigEndian.PutUint16(reg[0:2], 0xffff)
+	binary.BigEndian.PutUint16(reg[2:4], 30)
+	binary.BigEndian.PutUint16(reg[16:18], 2) // registration socket
+	if _, err := conn.Write(reg); err != nil {
+		return err
+	}
+	reply := make([]byte, 1500)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(reply)
+	if err != nil || n < 30 {
+		return fmt.Errorf("no IPXNET registration reply from %s: %v", addr, err)
+	}
+	conn.SetReadDeadline(time.Time{})
+	network := binary.BigEndian.Uint32(reply[6:10])
+	node := append([]byte(nil), reply[10:16]...)
+
+	go func() {
+		// Traffic from the links is delivered to us too; discard it.
+		for {
+			if _, err := conn.Read(reply); err != nil {
+				return
+			}
+		}
+	}()
+	go func() {
+		defer conn.Close()
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / rate))
+		defer ticker.Stop()
+		for seq := uint64(0); ; seq++ {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				conn.Write(loadPacket(size, network, node, seq))
+			}
+		}
+	}()
+	return nil
+}
+
+func sleepUntil(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+func percentile(v []float64, q float64) string {
+	if len(v) == 0 {
+		return "-"
+	}
+	sort.Float64s(v)
+	return fmt.Sprintf("%.1fms", v[int(q*float64(len(v)-1))])
+}
@@ -6,31 +6,145 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/mlapointe/ipxtransporter/internal/logger"
 
 	"github.com/mlapointe/ipxtransporter/internal/api"
 	"github.com/mlapointe/ipxtransporter/internal/config"
 	"github.com/mlapointe/ipxtransporter/internal/relay"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
 	"github.com/mlapointe/ipxtransporter/internal/tui"
 	"github.com/spf13/pflag"
+	"golang.org/x/term"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "loadgen" {
+		if err := runLoadgen(os.Args[2:]); err != nil {
+			logger.Fatal("loadgen: %v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		if err := runBench(os.Args[2:]); err != nil {
+			logger.Fatal("bench: %v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "compat" {
+		if err := runCompat(os.Args[2:]); err != nil {
+			logger.Fatal("compat: %v", err)
+		}
+		return
+	}
+
 	configPath := pflag.String("config", "/etc/ipxtransporter.json", "Path to config file")
 	iface := pflag.String("interface", "", "Network interface to capture from")
 	listenAddr := pflag.String("listen", "", "TLS listen address")
 	disableSSL := pflag.Bool("disable-ssl", false, "Disable TLS (debug only)")
-	tuiMode := pflag.Bool("tui", true, "Enable TUI mode")
+	tuiMode := pflag.Bool("tui", true, "Enable TUI mode; off without a terminal")
+	noTUI := pflag.Bool("no-tui", false, "Run in daemon mode, logging a traffic summary every stats_log_interval")
+	accessible := pflag.Bool("accessible", false, "Screen-reader friendly TUI: text instead of colors and graphs")
 	demoMode := pflag.Bool("demo", false, "Enable demo mode with fake traffic")
+	demoPattern := pflag.String("demo-pattern", "flat", "Demo traffic pattern: flat, doom, sap, login or lan-party")
+	exportPath := pflag.String("export-sqlite", "", "Save the running node's SQLite export to this file and exit")
+	logLevel := pflag.String("log-level", "", "Log level: debug, info, warn or error")
+	profile := pflag.String("profile", "", "Settings profile: home, hub or tournament; list shows what each sets")
+	genCertMode := pflag.Bool("gen-cert", false, "Generate a self-signed TLS certificate at tls_cert_path and tls_key_path, print its fingerprint and exit")
+	quickstartMode := pflag.Bool("quickstart", quickstartDefault, "Run a node from the built-in starter config, creating its config, keys and admin password in the state directory on the first run")
+	stateDir := pflag.String("state-dir", "", "Where --quickstart keeps the node's state (default: $STATE_DIRECTORY, /var/lib/ipxtransporter for root, else ~/.local/state/ipxtransporter)")
 	pflag.Parse()
 
+	if pflag.Arg(0) == "dashboards" && pflag.Arg(1) == "export" {
+		dashboard, err := stats.GrafanaDashboard()
+		if err != nil {
+			logger.Fatal("Dashboard export failed: %v", err)
+		}
+		os.Stdout.Write(append(dashboard, '\n'))
+		return
+	}
+
+	if *profile == "list" {
+		for _, p := range config.Profiles() {
+			fmt.Printf("%-12s %s\n%-12s %s\n", p.Name, p.Description, "", p)
+		}
+		return
+	}
+
+	// serve, the default command, runs the node. A quickstart node's state
+	// is all in its state directory, which relative paths start from.
+	if *quickstartMode {
+		path, err := quickstart(*stateDir)
+		if err != nil {
+			logger.Fatal("Quickstart: %v", err)
+		}
+		*configPath = path
+		if err := os.Chdir(filepath.Dir(path)); err != nil {
+			logger.Fatal("Quickstart: %v", err)
+		}
+	}
+
 	cfg, err := config.LoadConfig(*configPath)
+	if cfg == nil {
+		logger.Fatal("Failed to load config: %v", err)
+	}
 	if err != nil {
-		logger.Error("Warning: failed to load config from %s: %v. Using defaults.", *configPath, err)
+		logger.Warn("Failed to load config from %s: %v. Using defaults.", *configPath, err)
+	}
+	// Move plaintext passwords out of the config file, and give a new
+	// deployment its own JWT secret.
+	hashed, herr := cfg.HashPasswords()
+	if herr != nil {
+		logger.Fatal("Hashing the passwords: %v", herr)
+	}
+	generated, gerr := cfg.EnsureJWTSecret()
+	if gerr != nil {
+		logger.Fatal("Generating the JWT secret: %v", gerr)
+	}
+	if (hashed || generated) && err == nil {
+		if err := config.SaveConfig(*configPath, cfg); err != nil {
+			logger.Warn("Could not update %s: %v", *configPath, err)
+		} else {
+			if hashed {
+				logger.Info("Stored the passwords in %s hashed", *configPath)
+			}
+			if generated {
+				logger.Info("Generated a JWT secret and stored it in %s", *configPath)
+			}
+		}
+	}
+
+	// Before the profile and flags are applied, so that saving the paths
+	// saves nothing else.
+	if *genCertMode {
+		loaded := err == nil
+		if err := genCert(cfg, *configPath, loaded); err != nil {
+			logger.Fatal("Generating the certificate: %v", err)
+		}
+		return
+	}
+
+	// The profile fills in what the config file leaves at the defaults.
+	name := cfg.Profile
+	if *profile != "" {
+		name = *profile
+	}
+	if err := cfg.ApplyProfile(name); err != nil {
+		logger.Fatal("Profile: %v", err)
+	}
+	if cfg.Profile != "" {
+		logger.Info("Using the %s profile; settings left at their defaults take its values", cfg.Profile)
 	}
 
 	// Override config with flags if provided
@@ -43,6 +157,42 @@ func main() {
 	if *disableSSL {
 		cfg.DisableSSL = true
 	}
+	if *accessible {
+		cfg.Accessible = true
+	}
+	if *logLevel != "" {
+		cfg.LogLevel = *logLevel
+	}
+	if err := logger.SetLevel(cfg.LogLevel); err != nil {
+		logger.Warn("%v, logging at info", err)
+	}
+	if cfg.LogSyslog != "" {
+		if err := logger.EnableSyslog(cfg.LogSyslog); err != nil {
+			logger.Warn("%v", err)
+		}
+	}
+	if cfg.LogJournald {
+		if err := logger.EnableJournald(); err != nil {
+			logger.Warn("%v", err)
+		}
+	}
+
+	if *exportPath != "" {
+		if err := exportSQLite(cfg, *exportPath); err != nil {
+			logger.Fatal("Export failed: %v", err)
+		}
+		logger.Info("Export saved to %s", *exportPath)
+		return
+	}
+
+	// Every problem at once, rather than one per attempt to start.
+	problems := cfg.Validate()
+	for _, p := range problems.Warnings() {
+		logger.Warn("Config: %s", p)
+	}
+	if err := problems.Err(); err != nil {
+		logger.Fatal("Refusing to start: %v", err)
+	}
 
 	srv, err := relay.NewServer(cfg, *configPath)
 	if err != nil {
@@ -51,6 +201,9 @@ func main() {
 
 	if *demoMode {
 		srv.SetDemoMode(true)
+		if !srv.SetDemoPattern(*demoPattern) {
+			logger.Warn("Unknown demo pattern %q, using flat", *demoPattern)
+		}
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -64,26 +217,141 @@ func main() {
 		cancel()
 	}()
 
-	if err := srv.Start(ctx); err != nil {
+	// Without the listener the node still dials its peers, and the
+	// listener is retried, so it runs on.
+	if err := srv.Start(ctx); errors.Is(err, relay.ErrListenerDown) {
+		logger.Error("%v; dialing peers and retrying the listener", err)
+	} else if err != nil {
 		logger.Fatal("Failed to start server: %v", err)
 	}
 
-	if cfg.EnableHTTP {
+	// A safe restart drains the peer links before the process is replaced.
+	var restarting atomic.Bool
+	go func() {
+		select {
+		case reason := <-srv.RestartRequested():
+			drain := time.Duration(cfg.SelfHeal.RestartDrain) * time.Second
+			logger.Info("Restarting for %s: draining peer links for up to %s", reason, drain)
+			srv.Drain(drain)
+			restarting.Store(true)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	if cfg.EnableHTTP || cfg.GRPCListenAddr != "" || cfg.ControlSocket != "" {
 		apiSrv := api.NewAPI(srv, cfg)
-		go func() {
-			if err := apiSrv.ListenAndServe(cfg.HTTPListenAddr); err != nil {
-				logger.Error("HTTP API error: %v", err)
-			}
-		}()
+		if cfg.EnableHTTP {
+			go func() {
+				if err := apiSrv.ListenAndServe(cfg.HTTPListenAddr); err != nil {
+					logger.Error("HTTP API error: %v", err)
+				}
+			}()
+		}
+		if cfg.GRPCListenAddr != "" {
+			go func() {
+				if err := apiSrv.ListenAndServeGRPC(cfg.GRPCListenAddr); err != nil {
+					logger.Error("gRPC API error: %v", err)
+				}
+			}()
+		}
+		if cfg.ControlSocket != "" {
+			go func() {
+				if err := apiSrv.ListenAndServeUnix(ctx, cfg.ControlSocket); err != nil {
+					logger.Error("Control socket error: %v", err)
+				}
+			}()
+		}
+	}
+
+	if *noTUI {
+		*tuiMode = false
+	}
+	// Under systemd or cron there is no terminal to draw on.
+	if *tuiMode && !(term.IsTerminal(int(os.Stdin.Fd())) && term.IsTerminal(int(os.Stdout.Fd()))) {
+		logger.Info("Not running on a terminal, starting in daemon mode")
+		*tuiMode = false
 	}
 
 	if *tuiMode {
 		tuiApp := tui.NewTUIWithDemo(srv.CollectStats, cfg, *configPath, srv.UpdateDemoProps, srv.DisconnectPeer, srv.BanPeer, srv.AddPeer)
+		tuiApp.SetRules(srv.Rules(), srv.DryRunRule)
+		tuiApp.SetMute(srv.MutePeer)
+		tuiApp.SetLabel(srv.LabelPeers)
+		tuiApp.SetPreflight(srv.PreflightPeer)
+		tuiApp.SetCapture(srv.RestartCapture)
+		tuiApp.SetAddConfig(srv.AddPeerConfig)
+		tuiApp.SetColumns(srv.Columns())
+		tuiApp.SetChanges(srv.Changes())
+		tuiApp.SetHistory(srv.History)
+		tuiApp.SetSchedule(srv.Schedule(), srv.BanPeerFor)
+		tuiApp.SetBans(srv.Bans, srv.UnbanPeer)
+		tuiApp.SetAllowList(srv)
+		tuiApp.SetAudit(srv.ConnAttempts)
+		tuiApp.SetFlags(srv)
+		tuiApp.SetBackups(srv)
+		if cfg.MDNS {
+			tuiApp.SetLANDiscovery(srv.RefreshLAN)
+		}
+		tuiApp.SetRooms(srv)
 		if err := tuiApp.Run(ctx); err != nil {
-			logger.Fatal("TUI error: %v", err)
+			// The screen could not be set up: run on without it.
+			logger.Error("TUI error: %v; continuing in daemon mode", err)
+			runDaemon(ctx, srv, cfg)
 		}
 	} else {
-		logger.Info("Running in daemon mode. Press Ctrl+C to exit.")
-		<-ctx.Done()
+		runDaemon(ctx, srv, cfg)
+	}
+	if restarting.Load() {
+		restart()
+	}
+}
+
+// runDaemon runs the node without the TUI until ctx is done, logging a
+// summary of the traffic every stats_log_interval.
+func runDaemon(ctx context.Context, srv *relay.Server, cfg *config.Config) {
+	logger.Info("Running in daemon mode. Press Ctrl+C to exit.")
+	srv.LogSummaries(ctx, time.Duration(cfg.StatsLogInterval)*time.Second)
+	<-ctx.Done()
+}
+
+// exportSQLite downloads the SQLite export from the node running with this
+// config, with an admin token signed from the same config.
+func exportSQLite(cfg *config.Config, path string) error {
+	host, port, err := net.SplitHostPort(cfg.HTTPListenAddr)
+	if err != nil {
+		return fmt.Errorf("invalid http_listen_addr %q: %w", cfg.HTTPListenAddr, err)
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+	base := "http://" + net.JoinHostPort(host, port)
+
+	token, err := api.NewToken(cfg, time.Minute)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodGet, base+"/api/export.sqlite", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("export: %s", resp.Status)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		_ = f.Close()
+		return err
 	}
+	return f.Close()
 }
@@ -0,0 +1,79 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// --quickstart: a node from nothing but the binary, its state kept in one directory
+
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+)
+
+// quickstartDefault turns --quickstart on without the flag, in release
+// builds made with the quickstart tag.
+var quickstartDefault bool
+
+// passwordFile holds the admin password generated on the first run.
+const passwordFile = "initial-admin-password"
+
+// quickstart prepares dir to run a node from and returns the path of its
+// config. On the first run it writes the starter config built into the
+// binary, with a new key and certificate, a random admin password and a
+// JWT secret, all in dir. Later runs use what is there.
+func quickstart(dir string) (string, error) {
+	if dir == "" {
+		var err error
+		if dir, err = config.StateDir(); err != nil {
+			return "", err
+		}
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, "ipxtransporter.json")
+	if _, err := os.Stat(path); err == nil {
+		logger.Info("Quickstart: using the node in %s", dir)
+		return path, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+
+	cfg, err := config.StarterConfig()
+	if err != nil {
+		return "", err
+	}
+	// A key built into the binary would be every node's key, so each
+	// node makes its own.
+	cfg.TLSCertPath, cfg.TLSKeyPath = filepath.Join(dir, "ipxtransporter.crt"), filepath.Join(dir, "ipxtransporter.key")
+	fp := "kept from an earlier run"
+	if _, err := os.Stat(cfg.TLSKeyPath); errors.Is(err, os.ErrNotExist) {
+		if fp, _, err = newCert(cfg.TLSCertPath, cfg.TLSKeyPath); err != nil {
+			return "", fmt.Errorf("generating the certificate: %v", err)
+		}
+	}
+	pass := rand.Text()
+	cfg.AdminPass = pass
+	if _, err := cfg.EnsureJWTSecret(); err != nil {
+		return "", err
+	}
+	passPath := filepath.Join(dir, passwordFile)
+	if err := os.WriteFile(passPath, []byte(pass+"\n"), 0600); err != nil {
+		return "", err
+	}
+	if err := config.SaveConfig(path, cfg); err != nil {
+		return "", fmt.Errorf("saving %s: %v", path, err)
+	}
+	logger.Info("Quickstart: created a node in %s; peers pin it with fingerprint %s", dir, fp)
+	logger.Info("Quickstart: the dashboard is at http://localhost%s/ui/, log in as %s with the password in %s", cfg.HTTPListenAddr, cfg.AdminUser, passPath)
+	return path, nil
+}
@@ -0,0 +1,11 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Release builds that start as --quickstart
+
+//go:build quickstart
+
+package main
+
+func init() {
+	quickstartDefault = true
+}
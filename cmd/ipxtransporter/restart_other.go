@@ -0,0 +1,19 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Safe restart left to the service manager where exec is unavailable
+
+//go:build !unix
+
+package main
+
+import (
+	"os"
+
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+)
+
+// restart exits with EX_TEMPFAIL for the service manager to start us again.
+func restart() {
+	logger.Info("Exiting for the service manager to restart")
+	os.Exit(75)
+}
@@ -0,0 +1,25 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Safe restart by replacing the process in place
+
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+)
+
+// restart runs the binary afresh with the same arguments and environment,
+// keeping the PID a service manager watches.
+func restart() {
+	exe, err := os.Executable()
+	if err != nil {
+		logger.Fatal("Restart failed: %v", err)
+	}
+	err = syscall.Exec(exe, os.Args, os.Environ())
+	logger.Fatal("Restart failed: %v", err)
+}
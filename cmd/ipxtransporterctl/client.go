@@ -0,0 +1,213 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Client for the running daemon's control socket or HTTP API
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/peer"
+)
+
+// client sends API requests to the daemon, over the control socket when
+// there is one and otherwise over HTTP with a token from logging in.
+type client struct {
+	http  *http.Client
+	base  string
+	token string
+}
+
+// credentials log in to the HTTP API: with a password, a token obtained
+// elsewhere, such as an OIDC provider's access token, or a client
+// certificate listed in the daemon's api_auth.
+type credentials struct {
+	user, pass string
+	token      string
+	cert, key  string // files of the client certificate and its key
+}
+
+// dial connects to the daemon described by cfg, or to socket or url when
+// given: a socket needs no login, url takes credentials.
+func dial(cfg *config.Config, socket, url string, creds credentials) (*client, error) {
+	if socket == "" && url == "" {
+		socket = cfg.ControlSocket
+	}
+	if socket != "" {
+		return &client{
+			base: "http://ipxtransporter",
+			http: &http.Client{Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socket)
+				},
+			}},
+		}, nil
+	}
+
+	if url == "" {
+		if !cfg.EnableHTTP {
+			return nil, errors.New("the config sets neither control_socket nor enable_http; use --socket or --url")
+		}
+		host, port, err := net.SplitHostPort(cfg.HTTPListenAddr)
+		if err != nil {
+			return nil, fmt.Errorf("http_listen_addr: %v", err)
+		}
+		if host == "" || host == "0.0.0.0" || host == "::" {
+			host = "127.0.0.1"
+		}
+		scheme := "http://"
+		if slices.Contains(cfg.APIAuth.Backends("http"), config.AuthClientCert) {
+			scheme = "https://"
+		}
+		url = scheme + net.JoinHostPort(host, port)
+	}
+	c := &client{base: strings.TrimSuffix(url, "/"), http: &http.Client{}, token: creds.token}
+	if creds.cert != "" {
+		tr, err := certTransport(cfg, creds.cert, creds.key)
+		if err != nil {
+			return nil, err
+		}
+		c.http.Transport = tr
+		return c, nil
+	}
+	if creds.token != "" {
+		return c, nil
+	}
+	user, pass := creds.user, creds.pass
+	if user == "" {
+		user = cfg.AdminUser
+	}
+	if pass == "" {
+		return nil, errors.New("the HTTP API needs a password: use --pass or IPXTRANSPORTER_PASS, --token, --cert, or --socket")
+	}
+	var login struct {
+		Success bool   `json:"success"`
+		Token   string `json:"token"`
+	}
+	if err := c.do(http.MethodPost, "/api/login", map[string]string{"user": user, "pass": pass}, &login); err != nil {
+		return nil, err
+	}
+	if !login.Success {
+		return nil, fmt.Errorf("login as %s refused", user)
+	}
+	c.token = login.Token
+	return c, nil
+}
+
+// certTransport presents the client certificate in certFile and keyFile.
+// The daemon's certificate is not checked against a CA but must hold the
+// key of its tls_cert_path, as a pinned peer's must.
+func certTransport(cfg *config.Config, certFile, keyFile string) (*http.Transport, error) {
+	if keyFile == "" {
+		keyFile = certFile
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("client certificate: %v", err)
+	}
+	if cfg == nil || cfg.TLSCertPath == "" {
+		return nil, errors.New("a client certificate needs the daemon's tls_cert_path from --config, to check the daemon's certificate")
+	}
+	want, err := certFingerprint(cfg.TLSCertPath)
+	if err != nil {
+		return nil, fmt.Errorf("tls_cert_path: %v", err)
+	}
+	return &http.Transport{TLSClientConfig: &tls.Config{
+		Certificates:       []tls.Certificate{cert},
+		InsecureSkipVerify: true, // the key is pinned instead
+		MinVersion:         tls.VersionTLS13,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("the daemon presented no certificate")
+			}
+			got, err := peer.Fingerprint(cs.PeerCertificates[0].PublicKey)
+			if err == nil && got != want {
+				err = fmt.Errorf("the daemon's certificate key %.16s… is not that of tls_cert_path, %.16s…", got, want)
+			}
+			return err
+		},
+	}}, nil
+}
+
+// certFingerprint is the fingerprint of the key of the first certificate
+// in the PEM file at path.
+func certFingerprint(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return "", errors.New("no PEM data")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return "", err
+	}
+	return peer.Fingerprint(cert.PublicKey)
+}
+
+// request sends body, if not nil, as JSON and returns the response,
+// failing on any status but 200.
+func (c *client) request(method, path string, body any) (*http.Response, error) {
+	var rd io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		rd = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, c.base+path, rd)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if text := strings.TrimSpace(string(msg)); text != "" {
+			return nil, fmt.Errorf("%s %s: %s", method, path, text)
+		}
+		return nil, fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	return resp, nil
+}
+
+// do sends a request and decodes the JSON response into out, if not nil.
+func (c *client) do(method, path string, body, out any) error {
+	resp, err := c.request(method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		_, err = io.Copy(io.Discard, resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,597 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// ipxtransporterctl: manage a running daemon from the command line
+
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/ipx"
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+	"github.com/spf13/pflag"
+)
+
+const usage = `Usage: ipxtransporterctl [flags] <command> [args]
+
+Commands:
+  status                 Summary of the node and operations in progress
+  peers [list]           Connected peers
+  peers add ADDR [SETTING=VALUE...]
+                         Dial ADDR and add it to the configured peers, with
+                         settings of its peers entry such as name=Upstairs
+                         or compression=true; replaces those of a
+                         configured peer (--preflight: only if a test link
+                         succeeds)
+  peers test ADDR        Try a link to ADDR without adding it
+  peers remove ADDR      Remove a configured peer and close its link
+  peers import FILE|URL  Add the peers of a JSON or CSV relay list ("-" is stdin)
+                         (--preflight, --group G, --dry-run)
+  ban ID|HOST [--hours N]
+                         Ban a peer by ID, or hosts by IP, CIDR range or
+                         *.domain; for N hours if given (IP only)
+  unban ID|IP            Lift a ban, timed or not
+  bans                   Banned IDs and hosts and scheduled bans
+  allow ID|HOST          Let a node or peer ID link, or hosts by IP, CIDR
+                         range or *.domain, when the allow list is on
+  disallow ID|HOST       Remove an entry of the allow list
+  allowlist [on|off]     The allow list, or turn it on or off
+  legacy                 Clients of the legacy IPXNET server and their pseudo-peers
+  flags                  Feature flags and their latest changes
+  flag NAME on|off       Switch a feature flag without a restart
+  sockets                Named IPX sockets, built in and set by users
+  socket SOCKET NAME     Name a socket (hex), e.g. socket 5A00 Raptor;
+                         an empty NAME removes the name set
+  logs [-f]              Recent log lines; -f follows new ones
+  config get [KEY]       Settings, with passwords and secrets masked
+  config set KEY VALUE   Change a setting; VALUE is JSON, or else a string
+  config backups         Earlier versions of the config file, newest first
+  config restore NAME    Put a backup back in place of the config and restart
+  reload                 Drain the links, restart and read the config again
+
+Flags:
+`
+
+type options struct {
+	preflight bool
+	groups    []string
+	dryRun    bool
+	hours     int
+	follow    bool
+	level     string
+	subsystem string
+}
+
+func main() {
+	configPath := pflag.String("config", "/etc/ipxtransporter.json", "Config file of the daemon, to find its control socket or API")
+	socket := pflag.String("socket", "", "Control socket to use instead of the config's control_socket")
+	apiURL := pflag.String("url", "", "HTTP API to use instead of the control socket, e.g. http://127.0.0.1:8080")
+	user := pflag.String("user", "", "HTTP API user, admin_user if empty")
+	pass := pflag.String("pass", os.Getenv("IPXTRANSPORTER_PASS"), "HTTP API password, IPXTRANSPORTER_PASS by default")
+	token := pflag.String("token", os.Getenv("IPXTRANSPORTER_TOKEN"), "HTTP API token to use instead of a password, e.g. an OIDC access token; IPXTRANSPORTER_TOKEN by default")
+	certFile := pflag.String("cert", "", "HTTP API client certificate (PEM) to use instead of a password, listed in the daemon's api_auth client_certs")
+	keyFile := pflag.String("key", "", "Key of --cert, if not in the same file")
+	var opts options
+	pflag.BoolVar(&opts.preflight, "preflight", false, "peers add, peers import: test each address first and add it only if the test passes")
+	pflag.StringSliceVar(&opts.groups, "group", nil, "peers import: only entries in this group, may be repeated")
+	pflag.BoolVar(&opts.dryRun, "dry-run", false, "peers import: validate the list without adding anything")
+	pflag.IntVar(&opts.hours, "hours", 0, "ban: lift the ban after this many hours, 0 is forever")
+	pflag.BoolVarP(&opts.follow, "follow", "f", false, "logs: keep printing new log lines")
+	pflag.StringVar(&opts.level, "level", "", "logs: least level shown, debug, info, warn or error")
+	pflag.StringVar(&opts.subsystem, "subsystem", "", "logs: only this subsystem, e.g. relay or api")
+	pflag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		pflag.PrintDefaults()
+	}
+	pflag.Parse()
+	if pflag.NArg() == 0 {
+		pflag.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil && *socket == "" && *apiURL == "" {
+		fail(fmt.Errorf("reading %s: %v; use --config, --socket or --url", *configPath, err))
+	}
+	c, err := dial(cfg, *socket, *apiURL, credentials{user: *user, pass: *pass, token: *token, cert: *certFile, key: *keyFile})
+	if err != nil {
+		fail(err)
+	}
+	if err := run(c, pflag.Args(), opts); err != nil {
+		fail(err)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "ipxtransporterctl: %v\n", err)
+	os.Exit(1)
+}
+
+// run carries out the command in args.
+func run(c *client, args []string, opts options) error {
+	cmd, args := args[0], args[1:]
+	switch {
+	case cmd == "status" && len(args) == 0:
+		return status(c)
+	case cmd == "peers" && (len(args) == 0 || len(args) == 1 && args[0] == "list"):
+		return listPeers(c)
+	case cmd == "peers" && len(args) > 2 && args[0] == "add":
+		return addPeer(c, args[1], args[2:], opts)
+	case cmd == "peers" && len(args) == 2 && args[0] == "add":
+		if opts.preflight {
+			if err := testPeer(c, args[1]); err != nil {
+				return err
+			}
+		}
+		return c.do(http.MethodPost, "/api/peers/add", map[string]string{"addr": args[1]}, nil)
+	case cmd == "peers" && len(args) == 2 && args[0] == "test":
+		return testPeer(c, args[1])
+	case cmd == "peers" && len(args) == 2 && args[0] == "import":
+		return importPeers(c, args[1], opts)
+	case cmd == "peers" && len(args) == 2 && args[0] == "remove":
+		return c.do(http.MethodPost, "/api/peers/remove", map[string]string{"addr": args[1]}, nil)
+	case cmd == "ban" && len(args) == 1:
+		return ban(c, args[0], opts.hours)
+	case cmd == "unban" && len(args) == 1:
+		return c.do(http.MethodPost, "/api/bans/remove", map[string]string{"target": args[0]}, nil)
+	case cmd == "bans" && len(args) == 0:
+		return listBans(c)
+	case cmd == "allow" && len(args) == 1:
+		kind := "id"
+		if config.IsHostPattern(args[0]) {
+			kind = "host"
+		}
+		return c.do(http.MethodPost, "/api/allowlist/add", map[string]string{"kind": kind, "target": args[0]}, nil)
+	case cmd == "disallow" && len(args) == 1:
+		return c.do(http.MethodPost, "/api/allowlist/remove", map[string]string{"target": args[0]}, nil)
+	case cmd == "allowlist" && len(args) == 0:
+		return listAllowed(c)
+	case cmd == "allowlist" && len(args) == 1 && (args[0] == "on" || args[0] == "off"):
+		return c.do(http.MethodPost, "/api/allowlist/mode", map[string]bool{"enabled": args[0] == "on"}, nil)
+	case cmd == "legacy" && len(args) == 0:
+		return listLegacy(c)
+	case cmd == "flags" && len(args) == 0:
+		return listFlags(c)
+	case cmd == "flag" && len(args) == 2 && (args[1] == "on" || args[1] == "off"):
+		return c.do(http.MethodPost, "/api/flags", map[string]any{"name": args[0], "enabled": args[1] == "on"}, nil)
+	case cmd == "sockets" && len(args) == 0:
+		return listSockets(c)
+	case cmd == "socket" && len(args) >= 2:
+		return c.do(http.MethodPost, "/api/sockets", map[string]string{"socket": args[0], "name": strings.Join(args[1:], " ")}, nil)
+	case cmd == "logs" && len(args) == 0:
+		return logs(c, opts)
+	case cmd == "config" && (len(args) == 1 || len(args) == 2) && args[0] == "get":
+		return configGet(c, args[1:])
+	case cmd == "config" && len(args) == 3 && args[0] == "set":
+		return configSet(c, args[1], args[2])
+	case cmd == "config" && len(args) == 1 && args[0] == "backups":
+		return configBackups(c)
+	case cmd == "config" && len(args) == 2 && args[0] == "restore":
+		if err := c.do(http.MethodPost, "/api/config/backups", map[string]string{"name": args[1]}, nil); err != nil {
+			return err
+		}
+		fmt.Printf("Restored %s: the daemon drains its links and restarts\n", args[1])
+		return nil
+	case cmd == "reload" && len(args) == 0:
+		if err := c.do(http.MethodPost, "/api/reload", nil, nil); err != nil {
+			return err
+		}
+		fmt.Println("Reloading: the daemon drains its links and restarts")
+		return nil
+	}
+	return fmt.Errorf("unknown command %q; see --help", strings.Join(append([]string{cmd}, args...), " "))
+}
+
+func getStats(c *client) (stats.Stats, error) {
+	var s stats.Stats
+	err := c.do(http.MethodGet, "/stats", nil, &s)
+	return s, err
+}
+
+func status(c *client) error {
+	s, err := getStats(c)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Node\t%s\n", s.NodeID)
+	fmt.Fprintf(w, "Uptime\t%s\n", s.UptimeStr)
+	fmt.Fprintf(w, "Peers\t%d\n", s.PeerCount)
+	if s.Hub != "" {
+		fmt.Fprintf(w, "Hub\t%s\n", s.Hub)
+	}
+	fmt.Fprintf(w, "Packets\t%d received, %d forwarded, %d dropped, %d errors\n", s.TotalReceived, s.TotalForwarded, s.TotalDropped, s.TotalErrors)
+	if s.CaptureError != "" {
+		fmt.Fprintf(w, "Capture\t%s\n", s.CaptureError)
+	}
+	if s.ListenError != "" {
+		fmt.Fprintf(w, "Listener\tdown: %s (retrying at %s)\n", s.ListenError, s.ListenRetry.Local().Format(time.TimeOnly))
+	}
+	now := time.Now()
+	for _, op := range s.Operations {
+		state := "running for " + stats.FormatDuration(now.Sub(op.Started))
+		switch {
+		case op.Ended.IsZero():
+		case op.Error != "":
+			state = "failed: " + op.Error
+		default:
+			state = "done"
+		}
+		fmt.Fprintf(w, "Operation\t%s %s: %s\n", op.Kind, op.Target, state)
+	}
+	return w.Flush()
+}
+
+func listPeers(c *client) error {
+	s, err := getStats(c)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tNODE\tHOST\tDIR\tLATENCY\tMETRIC\tSENT\tRECV\tUP")
+	now := time.Now()
+	for _, p := range s.Peers {
+		dir := "out"
+		if p.Inbound {
+			dir = "in"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%.1f ms\t%d\t%s\t%s\t%s\n", p.ID, dash(p.Name), dash(p.NodeID), dash(p.Hostname), dir,
+			p.LatencyMs, p.Metric, formatBytes(p.SentBytes), formatBytes(p.RecvBytes), stats.FormatDuration(now.Sub(p.ConnectedAt)))
+	}
+	return w.Flush()
+}
+
+// testPeer prints the steps of a preflight of addr, failing if one did.
+func testPeer(c *client, addr string) error {
+	var res stats.Preflight
+	if err := c.do(http.MethodPost, "/api/peers/test", map[string]string{"addr": addr}, &res); err != nil {
+		return err
+	}
+	for _, s := range res.Steps {
+		mark := "ok"
+		if !s.OK {
+			mark = "FAILED"
+		}
+		fmt.Printf("%-12s %-7s %s\n", s.Name, mark, s.Detail)
+	}
+	if !res.OK {
+		return fmt.Errorf("%s failed the preflight", res.Addr)
+	}
+	return nil
+}
+
+// importPeers sends a relay list, read from a file or stdin or left for
+// the daemon to fetch from a URL, and prints what became of each entry.
+func importPeers(c *client, source string, opts options) error {
+	req := map[string]any{"preflight": opts.preflight, "groups": opts.groups, "dry_run": opts.dryRun}
+	switch {
+	case strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://"):
+		req["url"] = source
+	case source == "-":
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		req["list"] = string(data)
+	default:
+		data, err := os.ReadFile(source)
+		if err != nil {
+			return err
+		}
+		req["list"] = string(data)
+	}
+	var results []stats.PeerImportResult
+	if err := c.do(http.MethodPost, "/api/peers/import", req, &results); err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ADDR\tLABEL\tSTATUS\tDETAIL")
+	failed := 0
+	for _, r := range results {
+		if r.Status == "failed" {
+			failed++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r.Addr, dash(r.Label), r.Status, r.Error)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d entries failed", failed, len(results))
+	}
+	return nil
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func formatBytes(b uint64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := uint64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(b)/float64(div), "KMGTPE"[exp])
+}
+
+// ban bans target, hosts when it is an IP address, CIDR range or
+// *.domain pattern and a peer ID otherwise.
+func ban(c *client, target string, hours int) error {
+	req := map[string]any{"action": "ban", "hours": hours}
+	if config.IsHostPattern(target) {
+		req["ip"] = target
+	} else {
+		req["id"] = target
+	}
+	return c.do(http.MethodPost, "/api/action", req, nil)
+}
+
+// listBans prints the bans with the links each refused, and when the
+// scheduled ones apply.
+func listBans(c *client) error {
+	var bans []stats.Ban
+	if err := c.do(http.MethodGet, "/api/bans", nil, &bans); err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TARGET\tKIND\tACTIVE\tREFUSED\tWHEN\tREASON")
+	for _, b := range bans {
+		fmt.Fprintf(w, "%s\t%s\t%t\t%d\t%s\t%s\n", b.Target, b.Kind, b.Active, b.Hits, dash(b.When), dash(b.Reason))
+	}
+	return w.Flush()
+}
+
+// listAllowed prints the allow list and whether it is enforced.
+func listAllowed(c *client) error {
+	var al stats.AllowList
+	if err := c.do(http.MethodGet, "/api/allowlist", nil, &al); err != nil {
+		return err
+	}
+	if al.Enabled {
+		fmt.Println("Enforced: only these hosts and IDs and the configured peers may link")
+	} else {
+		fmt.Println("Not enforced: every peer may link")
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TARGET\tKIND")
+	for _, h := range al.Hosts {
+		fmt.Fprintf(w, "%s\thost\n", h)
+	}
+	for _, id := range al.IDs {
+		fmt.Fprintf(w, "%s\tid\n", id)
+	}
+	return w.Flush()
+}
+
+// listLegacy prints the legacy IPXNET server's clients with the
+// pseudo-peers they are listed as, and the proxies of mesh stations.
+func listLegacy(c *client) error {
+	var l stats.LegacyIPXNet
+	if err := c.do(http.MethodGet, "/api/legacy-ipxnet", nil, &l); err != nil {
+		return err
+	}
+	if l.Registered {
+		fmt.Printf("Bridging %s as %s\n", l.Server, l.Address)
+	} else {
+		fmt.Printf("Not registered with %s yet\n", l.Server)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "LEGACY CLIENT\tPSEUDO-PEER\tRX\tTX\tLAST SEEN")
+	now := time.Now()
+	for _, lc := range l.Clients {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s ago\n", lc.Address, lc.PeerID, lc.RxPackets, lc.TxPackets, stats.FormatDuration(now.Sub(lc.LastSeen)))
+	}
+	for _, p := range l.Proxies {
+		fmt.Fprintf(w, "%s\tproxy of %s\t\t\t%s ago\n", p.Proxy, p.Station, stats.FormatDuration(now.Sub(p.LastUsed)))
+	}
+	return w.Flush()
+}
+
+func listFlags(c *client) error {
+	var resp struct {
+		Flags   []stats.FeatureFlag `json:"flags"`
+		Changes []stats.FlagChange  `json:"changes"`
+	}
+	if err := c.do(http.MethodGet, "/api/flags", nil, &resp); err != nil {
+		return err
+	}
+	onOff := func(on bool) string {
+		if on {
+			return "on"
+		}
+		return "off"
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "FLAG\tSTATE\tDESCRIPTION")
+	for _, f := range resp.Flags {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", f.Name, onOff(f.Enabled), f.Description)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	for _, ch := range resp.Changes {
+		fmt.Printf("%s  %s %s by %s\n", ch.Time.Format(time.DateTime), ch.Flag, onOff(ch.Enabled), ch.By)
+	}
+	return nil
+}
+
+func listSockets(c *client) error {
+	var resp struct {
+		Sockets []ipx.SocketName `json:"sockets"`
+	}
+	if err := c.do(http.MethodGet, "/api/sockets", nil, &resp); err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SOCKET\tNAME\tSOURCE")
+	for _, sn := range resp.Sockets {
+		source := "built in"
+		if sn.Custom {
+			source = "user"
+		}
+		fmt.Fprintf(w, "0x%04X\t%s\t%s\n", sn.Socket, sn.Name, source)
+	}
+	return w.Flush()
+}
+
+// logs prints the recent log lines, and with opts.follow those that come
+// after them until interrupted.
+func logs(c *client, opts options) error {
+	show := func(m logger.LogMessage) bool { return true }
+	if opts.level != "" || opts.subsystem != "" {
+		least, err := logger.ParseLevel(cmp.Or(opts.level, "debug"))
+		if err != nil {
+			return err
+		}
+		show = func(m logger.LogMessage) bool {
+			if opts.subsystem != "" && m.Subsystem != opts.subsystem {
+				return false
+			}
+			l, err := logger.ParseLevel(m.Level)
+			return err != nil || l >= least
+		}
+	}
+	if !opts.follow {
+		s, err := getStats(c)
+		if err != nil {
+			return err
+		}
+		for _, m := range s.Logs {
+			if show(m) {
+				printLog(m)
+			}
+		}
+		return nil
+	}
+
+	q := url.Values{}
+	if opts.level != "" {
+		q.Set("level", opts.level)
+	}
+	if opts.subsystem != "" {
+		q.Set("subsystem", opts.subsystem)
+	}
+	resp, err := c.request(http.MethodGet, "/api/logs/stream?"+q.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		data, ok := strings.CutPrefix(sc.Text(), "data: ")
+		if !ok {
+			continue // event names, blank lines and keep-alives
+		}
+		var m logger.LogMessage
+		if err := json.Unmarshal([]byte(data), &m); err != nil {
+			return err
+		}
+		printLog(m)
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	return errors.New("the daemon closed the log stream")
+}
+
+func printLog(m logger.LogMessage) {
+	sub := ""
+	if m.Subsystem != "" {
+		sub = "[" + m.Subsystem + "] "
+	}
+	fmt.Printf("%s %-5s %s%s\n", m.Timestamp.Local().Format("2006-01-02 15:04:05"), m.Level, sub, m.Message)
+}
+
+// configGet prints the whole config, or the setting named in key.
+func configGet(c *client, key []string) error {
+	var settings map[string]json.RawMessage
+	if err := c.do(http.MethodGet, "/api/config", nil, &settings); err != nil {
+		return err
+	}
+	var out any = settings
+	if len(key) == 1 {
+		v, ok := settings[key[0]]
+		if !ok {
+			return fmt.Errorf("no setting %q", key[0])
+		}
+		out = v
+	}
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+// configSet sets key to value, taken as JSON when it parses as such and as
+// a string otherwise, so names need no quoting.
+// addPeer adds addr with the settings of a peers entry, given as
+// SETTING=VALUE with values as config set takes them.
+func addPeer(c *client, addr string, settings []string, opts options) error {
+	body := map[string]any{"addr": addr, "preflight": opts.preflight}
+	for _, s := range settings {
+		key, value, ok := strings.Cut(s, "=")
+		if !ok || key == "" {
+			return fmt.Errorf("%q is not SETTING=VALUE", s)
+		}
+		if key == "addr" || key == "preflight" {
+			return fmt.Errorf("%s is not a peer setting", key)
+		}
+		body[key] = jsonValue(value)
+	}
+	return c.do(http.MethodPost, "/api/peers/add", body, nil)
+}
+
+// jsonValue is value if it is JSON, else value as a JSON string.
+func jsonValue(value string) json.RawMessage {
+	raw := json.RawMessage(value)
+	if !json.Valid(raw) {
+		raw, _ = json.Marshal(value)
+	}
+	return raw
+}
+
+func configSet(c *client, key, value string) error {
+	return c.do(http.MethodPost, "/api/config/set", map[string]any{"key": key, "value": jsonValue(value)}, nil)
+}
+
+func configBackups(c *client) error {
+	var out struct {
+		Backups []config.Backup `json:"backups"`
+	}
+	if err := c.do(http.MethodGet, "/api/config/backups", nil, &out); err != nil {
+		return err
+	}
+	if len(out.Backups) == 0 {
+		fmt.Println("No backups")
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tREPLACED\tSIZE")
+	for _, b := range out.Backups {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", b.Name, b.Time.Format(time.DateTime), formatBytes(uint64(b.Size)))
+	}
+	return w.Flush()
+}
@@ -0,0 +1,195 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Certificates for the peer listener obtained and renewed over ACME
+
+// Package acmecert obtains the certificate of the peer listener from an
+// ACME CA such as Let's Encrypt and renews it before it expires, so a node
+// with a public DNS name needs no certificate management. The http-01 and
+// tls-alpn-01 challenges are answered by autocert; dns-01 publishes its TXT
+// record through a hook command, for nodes the CA cannot reach and for
+// wildcard names. The account key and certificates are kept in a cache
+// directory, and a renewal keeps the certificate's key, so its fingerprint
+// stays the same while the directory is kept.
+package acmecert
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Challenges.
+const (
+	ChallengeHTTP01    = "http-01"
+	ChallengeTLSALPN01 = "tls-alpn-01"
+	ChallengeDNS01     = "dns-01"
+)
+
+const (
+	// renewBefore is how long before it expires a certificate is renewed,
+	// as autocert does.
+	renewBefore   = 30 * 24 * time.Hour
+	checkInterval = 12 * time.Hour
+	retryInterval = time.Hour
+	issueTimeout  = 10 * time.Minute
+)
+
+// Options say which certificate to obtain and how.
+type Options struct {
+	Domains      []string // names the certificate is for; the first is served to peers asking for none of them
+	Email        string   // contact the CA sends expiry notices to, may be empty
+	Challenge    string   // http-01, tls-alpn-01 or dns-01
+	HTTPAddr     string   // http-01: where the CA's requests are answered
+	DNSHook      string   // dns-01: command publishing and removing the TXT record
+	CacheDir     string   // where the account key and certificates are kept
+	DirectoryURL string   // ACME directory, Let's Encrypt's if empty
+
+	Notify func(Status) // called after each time the certificate is checked, may be nil
+}
+
+// Status reports the certificate served, or why there is none.
+type Status struct {
+	Domains   []string  `json:"domains"`
+	Challenge string    `json:"challenge"`
+	Expires   time.Time `json:"expires,omitzero"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Manager serves the certificate for Options.Domains, obtaining it on
+// start and renewing it until Run returns.
+type Manager struct {
+	opts  Options
+	cache autocert.DirCache
+	auto  *autocert.Manager // http-01 and tls-alpn-01
+
+	mu     sync.RWMutex
+	cert   *tls.Certificate // dns-01: the certificate served
+	status Status
+}
+
+func NewManager(opts Options) *Manager {
+	m := &Manager{
+		opts:   opts,
+		cache:  autocert.DirCache(opts.CacheDir),
+		status: Status{Domains: opts.Domains, Challenge: opts.Challenge},
+	}
+	if opts.Challenge != ChallengeDNS01 {
+		m.auto = &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			Cache:      m.cache,
+			HostPolicy: autocert.HostWhitelist(opts.Domains...),
+			Email:      opts.Email,
+			Client:     &acme.Client{DirectoryURL: opts.DirectoryURL, UserAgent: "ipxtransporter"},
+		}
+	}
+	return m
+}
+
+func (m *Manager) Status() Status {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.status
+}
+
+// TLSConfig is the server config of the peer listener.
+func (m *Manager) TLSConfig() *tls.Config {
+	cfg := &tls.Config{GetCertificate: m.getCertificate, MinVersion: tls.VersionTLS13}
+	if m.opts.Challenge == ChallengeTLSALPN01 {
+		cfg.NextProtos = []string{acme.ALPNProto}
+	}
+	return cfg
+}
+
+func (m *Manager) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if m.auto == nil {
+		m.mu.RLock()
+		defer m.mu.RUnlock()
+		if m.cert == nil {
+			return nil, errors.New("no certificate obtained yet")
+		}
+		return m.cert, nil
+	}
+	// Peers dial addresses more often than names; they get the first
+	// domain's certificate. The CA's tls-alpn-01 requests are left alone.
+	challenge := slices.Equal(hello.SupportedProtos, []string{acme.ALPNProto})
+	if !challenge && !m.covers(hello.ServerName) {
+		h := *hello
+		h.ServerName = m.opts.Domains[0]
+		hello = &h
+	}
+	return m.auto.GetCertificate(hello)
+}
+
+// covers reports whether name is one of the domains.
+func (m *Manager) covers(name string) bool {
+	name = strings.TrimSuffix(name, ".")
+	return slices.ContainsFunc(m.opts.Domains, func(d string) bool { return strings.EqualFold(d, name) })
+}
+
+// Run obtains the certificate and keeps it renewed until ctx is done.
+func (m *Manager) Run(ctx context.Context) {
+	if m.opts.Challenge == ChallengeHTTP01 {
+		ln, err := net.Listen("tcp", m.opts.HTTPAddr)
+		if err != nil {
+			m.mu.Lock()
+			m.status.Error = "http-01 listener: " + err.Error()
+			m.mu.Unlock()
+			return
+		}
+		srv := &http.Server{Handler: m.auto.HTTPHandler(http.NotFoundHandler()), ReadHeaderTimeout: 10 * time.Second}
+		go srv.Serve(ln)
+		defer srv.Close()
+	}
+	for {
+		wait := checkInterval
+		expires, err := m.refresh(ctx)
+		m.mu.Lock()
+		m.status.Expires = expires
+		m.status.Error = ""
+		if err != nil {
+			m.status.Error = err.Error()
+			wait = retryInterval
+		}
+		st := m.status
+		m.mu.Unlock()
+		if m.opts.Notify != nil {
+			m.opts.Notify(st)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// refresh obtains the certificate if there is none or it is due for
+// renewal, and returns when the one served expires.
+func (m *Manager) refresh(ctx context.Context) (time.Time, error) {
+	if m.auto == nil {
+		return m.renewDNS(ctx)
+	}
+	// autocert renews the certificates it has loaded by itself; asking for
+	// each loads or obtains it.
+	var expires time.Time
+	for _, d := range m.opts.Domains {
+		cert, err := m.auto.GetCertificate(&tls.ClientHelloInfo{ServerName: d})
+		if err != nil {
+			return expires, err
+		}
+		if expires.IsZero() || cert.Leaf.NotAfter.Before(expires) {
+			expires = cert.Leaf.NotAfter
+		}
+	}
+	return expires, nil
+}
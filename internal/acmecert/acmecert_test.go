@@ -0,0 +1,96 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for ACME certificates
+
+package acmecert
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// selfSigned is a certificate for names, valid for d.
+func selfSigned(t *testing.T, d time.Duration, names ...string) *tls.Certificate {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), DNSNames: names, NotAfter: time.Now().Add(d)}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestNeedsRenewal(t *testing.T) {
+	now := time.Now()
+	cert := selfSigned(t, 60*24*time.Hour, "b.example.net", "a.example.net")
+	if needsRenewal(cert.Leaf, []string{"A.example.net", "b.example.net"}, now) {
+		t.Error("Expected a certificate for the domains, in any order, kept")
+	}
+	if !needsRenewal(cert.Leaf, []string{"a.example.net"}, now) {
+		t.Error("Expected a certificate for other names renewed")
+	}
+	if !needsRenewal(cert.Leaf, []string{"a.example.net", "b.example.net"}, now.Add(31*24*time.Hour)) {
+		t.Error("Expected a certificate expiring within a month renewed")
+	}
+}
+
+func TestCertCache(t *testing.T) {
+	ctx := context.Background()
+	m := NewManager(Options{Domains: []string{"relay.example.net"}, Challenge: ChallengeDNS01, CacheDir: t.TempDir()})
+	if _, err := m.getCertificate(&tls.ClientHelloInfo{}); err == nil {
+		t.Error("Expected no certificate before one is obtained")
+	}
+
+	cert := selfSigned(t, time.Hour, "relay.example.net")
+	if err := m.saveCert(ctx, cert); err != nil {
+		t.Fatal(err)
+	}
+	got, err := m.loadCert(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Leaf.Equal(cert.Leaf) || !cert.PrivateKey.(*ecdsa.PrivateKey).Equal(got.PrivateKey) {
+		t.Error("Expected the certificate and its key back from the cache")
+	}
+
+	// The account key is created once and kept.
+	k1, err := m.accountKey(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k2, _ := m.accountKey(ctx)
+	if !k1.(*ecdsa.PrivateKey).Equal(k2) {
+		t.Error("Expected the same account key on each use")
+	}
+}
+
+func TestDNSHook(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "calls")
+	hook := filepath.Join(dir, "hook.sh")
+	os.WriteFile(hook, []byte("#!/bin/sh\necho \"$@\" >> "+out+"\n[ \"$1\" = present ]\n"), 0700)
+	m := NewManager(Options{Challenge: ChallengeDNS01, DNSHook: hook})
+
+	if err := m.hook(context.Background(), "present", "_acme-challenge.example.net.", "token"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.hook(context.Background(), "cleanup", "_acme-challenge.example.net.", "token"); err == nil {
+		t.Error("Expected a failing hook reported")
+	}
+	calls, _ := os.ReadFile(out)
+	if got := strings.TrimSpace(string(calls)); got != "present _acme-challenge.example.net. token\ncleanup _acme-challenge.example.net. token" {
+		t.Errorf("Expected the action, name and value passed, got %q", got)
+	}
+}
@@ -0,0 +1,253 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// dns-01 issuance: the TXT record is published by a hook command
+
+package acmecert
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+const (
+	// accountKeyName is where autocert keeps the account key too, so
+	// switching challenges keeps the account.
+	accountKeyName = "acme_account+key"
+	// certName is the cache entry of the dns-01 certificate and its key.
+	certName    = "dns-01+cert"
+	hookTimeout = 5 * time.Minute
+)
+
+// renewDNS loads the certificate from the cache, obtains a new one if it
+// is missing, due for renewal or for other domains, and returns when the
+// one served expires.
+func (m *Manager) renewDNS(ctx context.Context) (time.Time, error) {
+	m.mu.RLock()
+	cert := m.cert
+	m.mu.RUnlock()
+	if cert == nil {
+		if c, err := m.loadCert(ctx); err == nil {
+			cert = c
+			m.setCert(cert)
+		}
+	}
+	if cert != nil && !needsRenewal(cert.Leaf, m.opts.Domains, time.Now()) {
+		return cert.Leaf.NotAfter, nil
+	}
+
+	// A renewal keeps the key, so the certificate's fingerprint stays.
+	var key crypto.Signer
+	if cert != nil {
+		key, _ = cert.PrivateKey.(crypto.Signer)
+	}
+	if key == nil {
+		k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return time.Time{}, err
+		}
+		key = k
+	}
+	ictx, cancel := context.WithTimeout(ctx, issueTimeout)
+	defer cancel()
+	issued, err := m.issueDNS(ictx, key)
+	if err != nil {
+		if cert != nil {
+			return cert.Leaf.NotAfter, err
+		}
+		return time.Time{}, err
+	}
+	if err := m.saveCert(ctx, issued); err != nil {
+		return issued.Leaf.NotAfter, err
+	}
+	m.setCert(issued)
+	return issued.Leaf.NotAfter, nil
+}
+
+func (m *Manager) setCert(cert *tls.Certificate) {
+	m.mu.Lock()
+	m.cert = cert
+	m.mu.Unlock()
+}
+
+// needsRenewal reports whether leaf expires within renewBefore of now or
+// is for other names than domains.
+func needsRenewal(leaf *x509.Certificate, domains []string, now time.Time) bool {
+	if leaf.NotAfter.Sub(now) < renewBefore {
+		return true
+	}
+	have := slices.Clone(leaf.DNSNames)
+	want := slices.Clone(domains)
+	for i := range want {
+		want[i] = strings.ToLower(want[i])
+	}
+	slices.Sort(have)
+	slices.Sort(want)
+	return !slices.Equal(have, want)
+}
+
+// issueDNS obtains a certificate for the domains with key, answering the
+// dns-01 challenge of each.
+func (m *Manager) issueDNS(ctx context.Context, key crypto.Signer) (*tls.Certificate, error) {
+	client, err := m.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(m.opts.Domains...))
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range order.AuthzURLs {
+		z, err := client.GetAuthorization(ctx, u)
+		if err != nil {
+			return nil, err
+		}
+		if z.Status == acme.StatusValid {
+			continue
+		}
+		i := slices.IndexFunc(z.Challenges, func(c *acme.Challenge) bool { return c.Type == ChallengeDNS01 })
+		if i < 0 {
+			return nil, fmt.Errorf("%s: the CA offers no dns-01 challenge", z.Identifier.Value)
+		}
+		value, err := client.DNS01ChallengeRecord(z.Challenges[i].Token)
+		if err != nil {
+			return nil, err
+		}
+		// The record of a wildcard name is that of the name below it,
+		// which is what the identifier holds.
+		fqdn := "_acme-challenge." + z.Identifier.Value + "."
+		if err := m.hook(ctx, "present", fqdn, value); err != nil {
+			return nil, err
+		}
+		defer m.hook(context.WithoutCancel(ctx), "cleanup", fqdn, value)
+		if _, err := client.Accept(ctx, z.Challenges[i]); err != nil {
+			return nil, err
+		}
+		if _, err := client.WaitAuthorization(ctx, z.URI); err != nil {
+			return nil, err
+		}
+	}
+	if order, err = client.WaitOrder(ctx, order.URI); err != nil {
+		return nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: m.opts.Domains[0]},
+		DNSNames: m.opts.Domains,
+	}, key)
+	if err != nil {
+		return nil, err
+	}
+	der, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(der[0])
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{Certificate: der, PrivateKey: key, Leaf: leaf}, nil
+}
+
+// hook runs the dns-01 hook the way lego's exec provider does, as
+// "HOOK present|cleanup FQDN VALUE". It returns once the record is
+// published, or removed.
+func (m *Manager) hook(ctx context.Context, action, fqdn, value string) error {
+	ctx, cancel := context.WithTimeout(ctx, hookTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, m.opts.DNSHook, action, fqdn, value).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("dns hook %s %s: %v: %s", action, fqdn, err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// client returns an ACME client with the account key, registering the
+// account on first use.
+func (m *Manager) client(ctx context.Context) (*acme.Client, error) {
+	key, err := m.accountKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client := &acme.Client{Key: key, DirectoryURL: m.opts.DirectoryURL, UserAgent: "ipxtransporter"}
+	acct := &acme.Account{}
+	if m.opts.Email != "" {
+		acct.Contact = []string{"mailto:" + m.opts.Email}
+	}
+	_, err = client.Register(ctx, acct, acme.AcceptTOS)
+	var ae *acme.Error
+	if err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) &&
+		!(errors.As(err, &ae) && ae.StatusCode == http.StatusConflict) {
+		return nil, err
+	}
+	return client, nil
+}
+
+// accountKey loads the account key from the cache, creating it on first
+// use.
+func (m *Manager) accountKey(ctx context.Context) (crypto.Signer, error) {
+	data, err := m.cache.Get(ctx, accountKeyName)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, errors.New("account key: no PEM data")
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	if !errors.Is(err, autocert.ErrCacheMiss) {
+		return nil, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	return key, m.cache.Put(ctx, accountKeyName, pemKey)
+}
+
+// saveCert writes the certificate to the cache: its key, then the chain,
+// in PEM.
+func (m *Manager) saveCert(ctx context.Context, cert *tls.Certificate) error {
+	der, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	pem.Encode(&buf, &pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	for _, c := range cert.Certificate {
+		pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: c})
+	}
+	return m.cache.Put(ctx, certName, buf.Bytes())
+}
+
+func (m *Manager) loadCert(ctx context.Context) (*tls.Certificate, error) {
+	data, err := m.cache.Get(ctx, certName)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.X509KeyPair(data, data)
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
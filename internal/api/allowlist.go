@@ -0,0 +1,68 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Allow list endpoints
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// allowListHandler shows the allow list and whether it is enforced.
+func (a *API) allowListHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(a.srv.AllowList())
+}
+
+// allowListAddHandler adds a host pattern or a peer or node ID:
+// {"kind": "host", "target": "10.1.0.0/16"}.
+func (a *API) allowListAddHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Kind   string `json:"kind"`
+		Target string `json:"target"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Target == "" {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	if err := a.srv.AllowPeer(req.Kind, req.Target); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]any{"success": true})
+}
+
+// allowListRemoveHandler drops an entry: {"target": "10.1.0.0/16"}.
+func (a *API) allowListRemoveHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Target string `json:"target"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Target == "" {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	if err := a.srv.RemoveAllowed(req.Target); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]any{"success": true})
+}
+
+// allowListModeHandler turns the allow list on or off:
+// {"enabled": true}.
+func (a *API) allowListModeHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Enabled == nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	a.srv.SetAllowListMode(*req.Enabled)
+	_ = json.NewEncoder(w).Encode(map[string]any{"success": true})
+}
@@ -9,15 +9,20 @@ import (
 	"encoding/json"
 	"fmt"
 	"html/template"
+	"io"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/mlapointe/ipxtransporter/internal/logger"
 
 	"github.com/mlapointe/ipxtransporter/internal/config"
 	"github.com/mlapointe/ipxtransporter/internal/relay"
+	"github.com/mlapointe/ipxtransporter/internal/rooms"
+	"github.com/mlapointe/ipxtransporter/internal/rules"
 	"github.com/mlapointe/ipxtransporter/internal/stats"
 )
 
@@ -31,71 +36,131 @@ type API struct {
 	adminUser string
 	adminPass string
 	cfg       *config.Config
+	tracker   *rooms.Tracker // nil unless serving a room tracker
+	columns   *stats.Columns // operator-defined peer columns
+	revoked   *revokedTokens
+	limiter   *rateLimiter
+	logins    *loginGuard
+	oidc      *oidcClient // nil unless a listener accepts OIDC
+	counters  apiCounters
 }
 
 func NewAPI(srv *relay.Server, cfg *config.Config) *API {
 	tmpl, err := template.ParseFS(templatesFS, "templates/stats.tmpl")
 	if err != nil {
-		logger.Error("Warning: failed to parse templates/stats.tmpl: %v", err)
+		logger.API.Warn("Failed to parse templates/stats.tmpl: %v", err)
 	}
 
-	return &API{
+	a := &API{
 		srv:       srv,
 		statsFunc: srv.CollectStats,
 		tmpl:      tmpl,
 		cfg:       cfg,
+		revoked:   newRevokedTokens(),
+		limiter:   newRateLimiter(cfg.APIRateLimit, cfg.APIRateBurst),
+		logins:    newLoginGuard(cfg.LoginMaxFailures, time.Duration(cfg.LoginLockout)*time.Second),
 	}
+	srv.SetAPIStats(a.apiStats)
+	if config.CheckPassword(cfg.AdminPass, "admin") {
+		logger.API.Warn("admin_pass is still the default, change it with /api/password")
+	}
+	a.columns = srv.Columns()
+	if cfg.Tracker {
+		a.tracker = rooms.NewTracker()
+	}
+	if cfg.APIAuth.Uses(config.AuthOIDC) {
+		a.oidc = newOIDCClient(cfg.APIAuth.OIDC)
+	}
+	return a
 }
 
+// ListenAndServe serves the HTTP API on addr, over TLS with the peer
+// listener's keys if it accepts client certificates.
 func (a *API) ListenAndServe(addr string) error {
+	backends := a.cfg.APIAuth.Backends("http")
+	srv := &http.Server{Addr: addr, Handler: a.limitRequests(a.routes()), BaseContext: listenerContext(backends)}
+	if !slices.Contains(backends, config.AuthClientCert) {
+		logger.API.Info("HTTP API listening on %s", addr)
+		return srv.ListenAndServe()
+	}
+	tlsCfg, err := a.listenerTLS(backends)
+	if err != nil {
+		return fmt.Errorf("loading TLS keys: %w", err)
+	}
+	srv.TLSConfig = tlsCfg
+	logger.API.Info("HTTP API listening on %s over TLS, accepting client certificates", addr)
+	return srv.ListenAndServeTLS("", "")
+}
+
+func (a *API) routes() *http.ServeMux {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path == "/" {
-			http.Redirect(w, r, "/stats.html", http.StatusTemporaryRedirect)
+			http.Redirect(w, r, "/ui/", http.StatusTemporaryRedirect)
 			return
 		}
 		http.NotFound(w, r)
 	})
+	mux.Handle("/ui/", dashboardHandler())
 	mux.HandleFunc("/stats", a.statsHandler)
 	mux.HandleFunc("/stats.html", a.statsHandler)
-	mux.HandleFunc("/api/action", a.withAuth(a.actionHandler))
+	mux.HandleFunc("/metrics", a.metricsHandler)
+	mux.HandleFunc("/api/history", a.withReadAuth(a.historyHandler))
+	mux.HandleFunc("/api/hosts", a.withReadAuth(a.hostsHandler))
+	mux.HandleFunc("/api/action", a.withAuth(config.RoleAdmin, a.actionHandler))
 	mux.HandleFunc("/api/sort", a.sortHandler)
-	mux.HandleFunc("/api/demo", a.withAuth(a.demoHandler))
+	mux.HandleFunc("/api/demo", a.withAuth(config.RoleAdmin, a.demoHandler))
 	mux.HandleFunc("/api/login", a.loginHandler)
-	mux.HandleFunc("/api/config", a.withAuth(a.configHandler))
-	mux.HandleFunc("/api/peers/add", a.withAuth(a.addPeerHandler))
-
-	logger.Info("HTTP API listening on %s", addr)
-	return http.ListenAndServe(addr, mux)
-}
-
-func (a *API) withAuth(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-
-		tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
-		token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (any, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			return []byte(a.cfg.JWTSecret), nil
-		})
-
-		if err != nil || !token.Valid {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-
-		next.ServeHTTP(w, r)
+	mux.HandleFunc("/api/auth", a.authHandler)
+	mux.HandleFunc("/api/oidc/login", a.oidcLoginHandler)
+	mux.HandleFunc("/api/oidc/callback", a.oidcCallbackHandler)
+	mux.HandleFunc("/api/password", a.withAuth(config.RoleAdmin, a.passwordHandler))
+	mux.HandleFunc("/api/refresh", a.withAuth(config.RoleViewer, a.refreshHandler))
+	mux.HandleFunc("/api/logout", a.withAuth(config.RoleViewer, a.logoutHandler))
+	mux.HandleFunc("/api/jwt/rotate", a.withAuth(config.RoleAdmin, a.rotateSecretHandler))
+	mux.HandleFunc("/api/config", a.withAuth(config.RoleAdmin, a.configHandler))
+	mux.HandleFunc("/api/config/set", a.withAuth(config.RoleAdmin, a.configSetHandler))
+	mux.HandleFunc("/api/config/backups", a.withAuth(config.RoleAdmin, a.backupsHandler))
+	mux.HandleFunc("/api/reload", a.withAuth(config.RoleAdmin, a.reloadHandler))
+	mux.HandleFunc("/api/peers/add", a.withAuth(config.RoleAdmin, a.addPeerHandler))
+	mux.HandleFunc("/api/peers/remove", a.withAuth(config.RoleAdmin, a.removePeerHandler))
+	mux.HandleFunc("/api/peers/test", a.withAuth(config.RoleAdmin, a.testPeerHandler))
+	mux.HandleFunc("/api/peers/import", a.withAuth(config.RoleAdmin, a.importPeersHandler))
+	mux.HandleFunc("/api/filters", a.withReadAuth(a.rulesHandler(rules.KindFilter)))
+	mux.HandleFunc("/api/filters/move", a.withAuth(config.RoleAdmin, a.moveRuleHandler(rules.KindFilter)))
+	mux.HandleFunc("/api/priorities", a.withReadAuth(a.rulesHandler(rules.KindPriority)))
+	mux.HandleFunc("/api/priorities/move", a.withAuth(config.RoleAdmin, a.moveRuleHandler(rules.KindPriority)))
+	mux.HandleFunc("/api/schedules", a.withReadAuth(a.schedulesHandler))
+	mux.HandleFunc("/api/bans", a.withReadAuth(a.bansHandler))
+	mux.HandleFunc("/api/bans/remove", a.withAuth(config.RoleAdmin, a.unbanHandler))
+	mux.HandleFunc("/api/allowlist", a.withReadAuth(a.allowListHandler))
+	mux.HandleFunc("/api/allowlist/add", a.withAuth(config.RoleAdmin, a.allowListAddHandler))
+	mux.HandleFunc("/api/allowlist/remove", a.withAuth(config.RoleAdmin, a.allowListRemoveHandler))
+	mux.HandleFunc("/api/allowlist/mode", a.withAuth(config.RoleAdmin, a.allowListModeHandler))
+	mux.HandleFunc("/api/audit", a.withReadAuth(a.auditHandler))
+	mux.HandleFunc("/api/community", a.withReadAuth(a.communityHandler))
+	mux.HandleFunc("/api/legacy-ipxnet", a.withReadAuth(a.legacyIPXNetHandler))
+	mux.HandleFunc("/api/room", a.withReadAuth(a.roomHandler))
+	mux.HandleFunc("/api/pcap", a.withReadAuth(a.pcapHandler))
+	mux.HandleFunc("/api/pcap/file", a.withAuth(config.RoleAdmin, a.pcapFileHandler))
+	mux.HandleFunc("/api/trace", a.withReadAuth(a.traceHandler))
+	mux.HandleFunc("/api/flags", a.withReadAuth(a.flagsHandler))
+	mux.HandleFunc("/api/sockets", a.withReadAuth(a.socketsHandler))
+	mux.HandleFunc("/api/export.sqlite", a.withAuth(config.RoleViewer, a.exportHandler))
+	mux.HandleFunc("/api/peers.csv", a.withAuth(config.RoleViewer, a.peersCSVHandler))
+	mux.HandleFunc("/api/logs/stream", tokenFromQuery(a.withAuth(config.RoleViewer, a.logStreamHandler)))
+	if a.tracker != nil {
+		mux.HandleFunc("/api/tracker/rooms", a.trackerHandler)
 	}
+	return mux
 }
 
 func (a *API) statsHandler(w http.ResponseWriter, r *http.Request) {
 	s := a.statsFunc()
+	if !a.mayView(r) {
+		// The host inventory is for viewers, as on /api/hosts.
+		s.Hosts = nil
+	}
 
 	if strings.HasSuffix(r.URL.Path, ".html") {
 		if a.tmpl == nil {
@@ -104,9 +169,20 @@ func (a *API) statsHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		w.Header().Set("Content-Type", "text/html")
 		if err := a.tmpl.Execute(w, s); err != nil {
-			logger.Error("Template execute error: %v", err)
+			logger.API.Error("Template execute error: %v", err)
 		}
 	} else {
+		// ?offset=&limit= page through the peers of a busy hub.
+		q := r.URL.Query()
+		if q.Has("offset") || q.Has("limit") {
+			offset, ok1 := countParam(q, "offset")
+			limit, ok2 := countParam(q, "limit")
+			if !ok1 || !ok2 {
+				http.Error(w, "Invalid offset or limit", http.StatusBadRequest)
+				return
+			}
+			s.PagePeers(offset, limit)
+		}
 		w.Header().Set("Content-Type", "application/json")
 		err := json.NewEncoder(w).Encode(s)
 		if err != nil {
@@ -115,6 +191,26 @@ func (a *API) statsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// mayView reports whether the caller of an open endpoint may also see
+// what withReadAuth keeps to viewers.
+func (a *API) mayView(r *http.Request) bool {
+	if isLocal(r) {
+		return true
+	}
+	c, ok := a.authenticate(r)
+	return ok && config.RoleAllows(c.Role, config.RoleViewer)
+}
+
+// countParam returns a non-negative integer query parameter, 0 if absent.
+func countParam(q url.Values, name string) (int, bool) {
+	v := q.Get(name)
+	if v == "" {
+		return 0, true
+	}
+	n, err := strconv.Atoi(v)
+	return n, err == nil && n >= 0
+}
+
 func (a *API) sortHandler(w http.ResponseWriter, r *http.Request) {
 	field := r.URL.Query().Get("field")
 	if field != "" {
@@ -123,7 +219,13 @@ func (a *API) sortHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// loginHandler trades credentials for a token. An address failing
+// login_max_failures times in a row is locked out for a while.
 func (a *API) loginHandler(w http.ResponseWriter, r *http.Request) {
+	if !accepts(r, config.AuthLocal) {
+		http.Error(w, "Password logins are off on this listener", http.StatusForbidden)
+		return
+	}
 	var req struct {
 		User string `json:"user"`
 		Pass string `json:"pass"`
@@ -132,24 +234,22 @@ func (a *API) loginHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Bad request", http.StatusBadRequest)
 		return
 	}
+	addr := clientAddr(r)
+	if wait := a.logins.reserve(addr, time.Now()); wait > 0 {
+		tooManyLogins(w, wait)
+		return
+	}
 
-	if req.User == a.cfg.AdminUser && req.Pass == a.cfg.AdminPass {
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-			"user": req.User,
-			"exp":  time.Now().Add(24 * time.Hour).Unix(),
-		})
-
-		tokenString, err := token.SignedString([]byte(a.cfg.JWTSecret))
+	if role, ok := a.cfg.Authenticate(req.User, req.Pass); ok {
+		a.logins.succeed(addr)
+		tokenString, err := issueToken(a.cfg, req.User, a.tokenTTL(), time.Now())
 		if err != nil {
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 			return
 		}
-
-		_ = json.NewEncoder(w).Encode(map[string]any{
-			"success": true,
-			"token":   tokenString,
-		})
+		writeToken(w, tokenString, role, a.tokenTTL())
 	} else {
+		a.loginFailed(addr, req.User)
 		err := json.NewEncoder(w).Encode(map[string]any{"success": false})
 		if err != nil {
 			return
@@ -157,14 +257,60 @@ func (a *API) loginHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// passwordHandler changes the admin password, and the user name if given,
+// after checking the current password. Every token issued before stops
+// working; the response carries a new one for the caller.
+func (a *API) passwordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Current string `json:"current"`
+		New     string `json:"new"`
+		User    string `json:"user"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	adminUser, adminPass := a.cfg.AdminCredentials()
+	if c := claimsFrom(r); c.User != adminUser || c.Auth != "" {
+		http.Error(w, "Only admin_user changes its password here", http.StatusForbidden)
+		return
+	}
+	if !config.CheckPassword(adminPass, req.Current) {
+		http.Error(w, "Current password is wrong", http.StatusForbidden)
+		return
+	}
+	if req.New == "" {
+		http.Error(w, "New password is required", http.StatusBadRequest)
+		return
+	}
+	if err := a.srv.SetAdminCredentials(req.User, req.New); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	logger.API.Info("Admin credentials changed, existing tokens revoked")
+	tokenString, err := NewToken(a.cfg, a.tokenTTL())
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeToken(w, tokenString, config.RoleAdmin, a.tokenTTL())
+}
+
 func (a *API) actionHandler(w http.ResponseWriter, r *http.Request) {
 	// Simple auth check (mock)
 	// if !a.isAuthorized(r) { http.Error(w, "Unauthorized", http.StatusUnauthorized); return }
 
 	var req struct {
-		Action string `json:"action"`
-		ID     string `json:"id"`
-		IP     string `json:"ip"`
+		Action   string `json:"action"`
+		ID       string `json:"id"`
+		IP       string `json:"ip"`
+		Inbound  bool   `json:"inbound"`  // mute
+		Outbound bool   `json:"outbound"` // mute
+		Hours    int    `json:"hours"`    // ban: expire after this many hours, 0 is forever
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Bad request", http.StatusBadRequest)
@@ -175,7 +321,33 @@ func (a *API) actionHandler(w http.ResponseWriter, r *http.Request) {
 	case "disconnect":
 		a.srv.DisconnectPeer(req.ID)
 	case "ban":
-		a.srv.BanPeer(req.ID, req.IP)
+		if err := checkBanHost(req.IP, req.Hours); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Hours > 0 {
+			a.srv.BanPeerFor(req.ID, req.IP, time.Duration(req.Hours)*time.Hour)
+		} else {
+			a.srv.BanPeer(req.ID, req.IP)
+		}
+	case "mute":
+		a.srv.MutePeer(req.ID, req.Inbound, req.Outbound)
+	case "approve", "reject":
+		// req.ID is a gossiped peer address held for approval
+		approve := a.srv.ApproveDiscovered
+		if req.Action == "reject" {
+			approve = a.srv.RejectDiscovered
+		}
+		if err := approve(req.ID); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case "relay":
+		// req.ID is the node ID to reach through a common peer
+		if err := a.srv.RequestRelay(req.ID); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 	default:
 		http.Error(w, "Unknown action", http.StatusBadRequest)
 		return
@@ -193,7 +365,7 @@ func (a *API) demoHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	a.srv.UpdateDemoProps(req.PacketRate, req.DropRate, req.ErrorRate, req.NumPeers)
+	a.srv.UpdateDemoProps(req.PacketRate, req.DropRate, req.ErrorRate, req.NumPeers, req.Pattern)
 	err := json.NewEncoder(w).Encode(map[string]any{"success": true})
 	if err != nil {
 		return
@@ -201,6 +373,11 @@ func (a *API) demoHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (a *API) configHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(a.cfg.Redacted())
+		return
+	}
 	var req struct {
 		AdminPass         string `json:"admin_pass"`
 		MaxChildren       int    `json:"max_children"`
@@ -220,11 +397,52 @@ func (a *API) configHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// configSetHandler changes one setting: {"key": "max_children", "value": 8}.
+func (a *API) configSetHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Key   string          `json:"key"`
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Key == "" || req.Value == nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	if err := a.srv.SetConfigValue(req.Key, req.Value); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{"success": true})
+}
+
+// reloadHandler restarts the daemon, which drains its links and reads the
+// config file again.
+func (a *API) reloadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	a.srv.RequestRestart("reload")
+	json.NewEncoder(w).Encode(map[string]any{"success": true})
+}
+
+// addPeerHandler adds a peer, with the settings of a peers entry if the
+// request carries any besides "addr"; for a configured peer it replaces
+// them. With "preflight" set the address is tried first and only added if
+// the trial link succeeds; the response carries its steps either way.
 func (a *API) addPeerHandler(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		Addr string `json:"addr"`
+		Addr      string `json:"addr"`
+		Preflight bool   `json:"preflight"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	var pc config.PeerConfig
+	body, err := io.ReadAll(r.Body)
+	if err == nil {
+		err = json.Unmarshal(body, &req)
+	}
+	if err == nil {
+		err = json.Unmarshal(body, &pc)
+	}
+	if err != nil {
 		http.Error(w, "Bad request", http.StatusBadRequest)
 		return
 	}
@@ -233,8 +451,108 @@ func (a *API) addPeerHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Address is required", http.StatusBadRequest)
 		return
 	}
+	add := func() error {
+		if pc.AddrOnly() {
+			a.srv.AddPeer(r.Context(), req.Addr)
+			return nil
+		}
+		return a.srv.AddPeerConfig(r.Context(), pc)
+	}
+	preflight := func() stats.Preflight {
+		if pc.AddrOnly() {
+			return a.srv.Preflight(r.Context(), req.Addr)
+		}
+		return a.srv.PreflightPeer(r.Context(), pc)
+	}
+	if err := pc.Validate(a.cfg.DisableSSL); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	a.srv.AddPeer(r.Context(), req.Addr)
+	if req.Preflight {
+		res := preflight()
+		w.Header().Set("Content-Type", "application/json")
+		if !res.OK {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			json.NewEncoder(w).Encode(map[string]any{"success": false, "preflight": res})
+			return
+		}
+		if err := add(); err != nil {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]any{"success": false, "error": err.Error(), "preflight": res})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"success": true, "preflight": res})
+		return
+	}
+	if err := add(); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	err = json.NewEncoder(w).Encode(map[string]any{"success": true})
+	if err != nil {
+		return
+	}
+}
+
+// testPeerHandler tries a link to a peer address without adding it.
+func (a *API) testPeerHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Addr string `json:"addr"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Addr == "" {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.srv.Preflight(r.Context(), req.Addr))
+}
+
+// importPeersHandler adds the peers of a relay list, given inline as
+// "list" (JSON or CSV) or published at "url", and reports each entry.
+func (a *API) importPeersHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		List      string   `json:"list"`
+		URL       string   `json:"url"`
+		Preflight bool     `json:"preflight"`
+		Groups    []string `json:"groups"`
+		DryRun    bool     `json:"dry_run"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.List == "") == (req.URL == "") {
+		http.Error(w, "Bad request: give either list or url", http.StatusBadRequest)
+		return
+	}
+	data := []byte(req.List)
+	if req.URL != "" {
+		var err error
+		if data, err = relay.FetchPeerList(r.Context(), req.URL); err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+	}
+	entries, err := relay.ParsePeerList(data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts := relay.ImportOptions{Preflight: req.Preflight, Groups: req.Groups, DryRun: req.DryRun}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.srv.ImportPeers(r.Context(), entries, opts))
+}
+
+func (a *API) removePeerHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Addr string `json:"addr"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	if err := a.srv.RemovePeer(req.Addr); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
 	err := json.NewEncoder(w).Encode(map[string]any{"success": true})
 	if err != nil {
 		return
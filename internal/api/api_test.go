@@ -0,0 +1,36 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Test helpers for the API: a server over a relay that is not started
+
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/relay"
+)
+
+func newTestAPI(t *testing.T, cfg *config.Config) *API {
+	t.Helper()
+	srv, err := relay.NewServer(cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewAPI(srv, cfg)
+}
+
+// onListener has r come in on a listener accepting backends.
+func onListener(r *http.Request, backends ...string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), backendsKey{}, backends))
+}
+
+// serve runs r through the API's routes.
+func serve(a *API, r *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	a.routes().ServeHTTP(rec, r)
+	return rec
+}
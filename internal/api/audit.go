@@ -0,0 +1,21 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Connection attempt audit endpoint
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// auditHandler lists the latest inbound connection attempts, newest first;
+// ?outcome=banned shows one outcome only.
+func (a *API) auditHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(a.srv.ConnAttempts(r.URL.Query().Get("outcome")))
+}
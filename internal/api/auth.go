@@ -0,0 +1,123 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Authentication backends of the API listeners: tokens, OIDC and client certificates
+
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/peer"
+)
+
+type backendsKey struct{}
+
+// listenerContext has the requests of a listener carry the backends it
+// accepts, from api_auth.
+func listenerContext(backends []string) func(net.Listener) context.Context {
+	return func(net.Listener) context.Context {
+		return context.WithValue(context.Background(), backendsKey{}, backends)
+	}
+}
+
+// listenerBackends are the backends of the listener r came in on. The
+// control socket has none of its own; its callers are trusted anyway.
+func listenerBackends(r *http.Request) []string {
+	if b, ok := r.Context().Value(backendsKey{}).([]string); ok {
+		return b
+	}
+	return []string{config.AuthLocal}
+}
+
+func accepts(r *http.Request, backend string) bool {
+	return slices.Contains(listenerBackends(r), backend)
+}
+
+// authenticate finds who made a request with the backends of the listener
+// it came in on: by a client certificate, a token this API issued, or an
+// access token the OIDC provider vouches for.
+func (a *API) authenticate(r *http.Request) (*tokenClaims, bool) {
+	if c, ok := a.certClaims(r); ok {
+		return c, true
+	}
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		return nil, false
+	}
+	tok := strings.TrimPrefix(authHeader, "Bearer ")
+	if c, ok := a.verifyToken(tok); ok {
+		return c, accepts(r, c.backend())
+	}
+	if a.oidc != nil && accepts(r, config.AuthOIDC) && !ownToken(tok) {
+		return a.oidc.introspect(r.Context(), tok)
+	}
+	return nil, false
+}
+
+// ownToken reports whether tok looks like a token of this API, expired or
+// not, which the OIDC provider need not be asked about.
+func ownToken(tok string) bool {
+	t, _, err := jwt.NewParser().ParseUnverified(tok, &tokenClaims{})
+	return err == nil && t.Method == jwt.SigningMethodHS256
+}
+
+// certClaims stand in for a token for the holder of a client certificate
+// listed in client_certs.
+func (a *API) certClaims(r *http.Request) (*tokenClaims, bool) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 || !accepts(r, config.AuthClientCert) {
+		return nil, false
+	}
+	fp, err := peer.Fingerprint(r.TLS.PeerCertificates[0].PublicKey)
+	if err != nil {
+		return nil, false
+	}
+	name, role, ok := a.cfg.ClientCertUser(fp)
+	if !ok {
+		return nil, false
+	}
+	now := time.Now()
+	return &tokenClaims{
+		User:     name,
+		Role:     role,
+		Auth:     config.AuthClientCert,
+		AuthTime: now.Unix(),
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(a.tokenTTL())),
+		},
+	}, true
+}
+
+// listenerTLS is the TLS config of an API listener, with the peer
+// listener's keys. A listener accepting client certificates asks for one;
+// they are checked against client_certs, not against a CA.
+func (a *API) listenerTLS(backends []string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(a.cfg.TLSCertPath, a.cfg.TLSKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS13}
+	if slices.Contains(backends, config.AuthClientCert) {
+		cfg.ClientAuth = tls.RequestClientCert
+	}
+	return cfg, nil
+}
+
+// authHandler tells clients how they may log in on this listener, so the
+// dashboard offers single sign-on where the provider is configured.
+func (a *API) authHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"backends": listenerBackends(r),
+		"oidc":     a.oidc != nil && accepts(r, config.AuthOIDC),
+	})
+}
@@ -0,0 +1,112 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for the client certificate backend and listener backends
+
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/peer"
+)
+
+// clientCert is a certificate with a new key, and the key's fingerprint.
+func clientCert(t *testing.T) (*x509.Certificate, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp, err := peer.Fingerprint(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &x509.Certificate{PublicKey: key.Public()}, fp
+}
+
+// withCert has r come over TLS with cert as its client certificate.
+func withCert(r *http.Request, cert *x509.Certificate) *http.Request {
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	return r
+}
+
+// colons writes fp as operators often copy it, in upper case pairs.
+func colons(fp string) string {
+	var pairs []string
+	for i := 0; i < len(fp); i += 2 {
+		pairs = append(pairs, fp[i:i+2])
+	}
+	return strings.ToUpper(strings.Join(pairs, ":"))
+}
+
+func TestClientCertBackend(t *testing.T) {
+	adminCert, adminFP := clientCert(t)
+	viewerCert, viewerFP := clientCert(t)
+	strangerCert, _ := clientCert(t)
+
+	cfg := config.DefaultConfig()
+	cfg.APIAuth.ClientCerts = []config.APIClientCert{
+		{Name: "ops", Fingerprint: colons(adminFP), Role: config.RoleAdmin},
+		{Name: "board", Fingerprint: viewerFP, Role: config.RoleViewer},
+	}
+	a := newTestAPI(t, cfg)
+	token, err := NewToken(cfg, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs := []string{config.AuthClientCert}
+	both := []string{config.AuthLocal, config.AuthClientCert}
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		cert     *x509.Certificate
+		token    string
+		backends []string
+		want     int
+	}{
+		{"admin cert", "GET", "/api/config", adminCert, "", certs, http.StatusOK},
+		{"viewer cert reads", "GET", "/api/bans", viewerCert, "", certs, http.StatusOK},
+		{"viewer cert reads the config", "GET", "/api/config", viewerCert, "", certs, http.StatusForbidden},
+		{"viewer cert writes", "POST", "/api/allowlist", viewerCert, "", certs, http.StatusForbidden},
+		{"cert not listed", "GET", "/api/bans", strangerCert, "", certs, http.StatusUnauthorized},
+		{"cert on a listener without client_cert", "GET", "/api/bans", adminCert, "", []string{config.AuthLocal}, http.StatusUnauthorized},
+		{"token on a listener of certs only", "GET", "/api/bans", nil, token, certs, http.StatusUnauthorized},
+		{"token on a listener of both", "GET", "/api/bans", nil, token, both, http.StatusOK},
+		{"viewer cert beside an admin token", "GET", "/api/config", viewerCert, token, both, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := withToken(tt.method, tt.path, "", tt.token)
+			if tt.cert != nil {
+				r = withCert(r, tt.cert)
+			}
+			rec := serve(a, onListener(r, tt.backends...))
+			if rec.Code != tt.want {
+				t.Errorf("Expected %d, got %d: %s", tt.want, rec.Code, rec.Body)
+			}
+		})
+	}
+}
+
+func TestClientCertNoRefresh(t *testing.T) {
+	cert, fp := clientCert(t)
+	cfg := config.DefaultConfig()
+	cfg.APIAuth.ClientCerts = []config.APIClientCert{{Name: "ops", Fingerprint: fp, Role: config.RoleAdmin}}
+	a := newTestAPI(t, cfg)
+
+	r := onListener(withCert(withToken("POST", "/api/refresh", "", ""), cert), config.AuthClientCert)
+	if rec := serve(a, r); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected a certificate holder to get no token, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,46 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// HTTP API for config backups
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// backupsHandler manages the backups of the config file:
+//
+//	GET   the backups, newest first
+//	POST  {"name": ...} restores a backup in place of the config file and
+//	      restarts the daemon to read it
+func (a *API) backupsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		backups, err := a.srv.ConfigBackups()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"backups": backups})
+
+	case http.MethodPost:
+		var req struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+		if err := a.srv.RestoreConfig(req.Name); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"success": true})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
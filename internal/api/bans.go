@@ -0,0 +1,54 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Ban listing and removal endpoints
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+)
+
+// bansHandler lists banned_ids, banned_hosts and the scheduled bans.
+func (a *API) bansHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(a.srv.Bans())
+}
+
+// unbanHandler lifts every ban of a peer ID, host or address:
+// {"target": "10.0.0.1"}.
+func (a *API) unbanHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Target string `json:"target"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Target == "" {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	if err := a.srv.UnbanPeer(req.Target); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]any{"success": true})
+}
+
+// checkBanHost checks a host to ban: an address, or for a ban without
+// hours a CIDR range or host name pattern as banned_hosts takes.
+func checkBanHost(host string, hours int) error {
+	if host == "" {
+		return nil
+	}
+	if hours > 0 && net.ParseIP(host) == nil {
+		return fmt.Errorf("timed bans take an IP address, not %q", host)
+	}
+	_, err := config.CompileHostPatterns([]string{host})
+	return err
+}
@@ -0,0 +1,22 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Community statistics endpoint
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// communityHandler shows whether community statistics are submitted and
+// the exact report the next submission would send, to be checked before
+// opting in with community_stats.
+func (a *API) communityHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(a.srv.CommunityStatus())
+}
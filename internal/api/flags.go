@@ -0,0 +1,45 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// HTTP API for feature flags
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// flagsHandler manages the feature flags:
+//
+//	GET   the flags with their state and the latest changes
+//	POST  {"name": ..., "enabled": ...} switches a flag at once and
+//	      saves it to the config
+func (a *API) flagsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"flags":   a.srv.Flags(),
+			"changes": a.srv.FlagChanges(),
+		})
+
+	case http.MethodPost:
+		var req struct {
+			Name    string `json:"name"`
+			Enabled *bool  `json:"enabled"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Enabled == nil {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+		if err := a.srv.SetFlag(req.Name, *req.Enabled, claimsFrom(r).User); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"success": true})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
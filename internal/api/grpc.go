@@ -0,0 +1,380 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// gRPC control API mirroring the HTTP control operations
+
+package api
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+// The service is ipxtransporter.v1.Control in examples/ipxtransporter.proto.
+const grpcService = "/ipxtransporter.v1.Control/"
+
+const (
+	grpcMaxMessage = 1 << 20
+	// StreamStats sends every second unless asked otherwise, never more
+	// often than grpcMinInterval.
+	grpcDefaultInterval = time.Second
+	grpcMinInterval     = 250 * time.Millisecond
+)
+
+// gRPC status codes, see https://grpc.io/docs/guides/status-codes/.
+const (
+	grpcOK                = 0
+	grpcInvalidArgument   = 3
+	grpcNotFound          = 5
+	grpcPermissionDenied  = 7
+	grpcResourceExhausted = 8
+	grpcUnimplemented     = 12
+	grpcInternal          = 13
+	grpcUnauthenticated   = 16
+)
+
+type grpcError struct {
+	code int
+	msg  string
+}
+
+func (e *grpcError) Error() string { return e.msg }
+
+func grpcErrorf(code int, format string, args ...any) error {
+	return &grpcError{code: code, msg: fmt.Sprintf(format, args...)}
+}
+
+// grpcMethod is one RPC: the role it needs and what it does with the
+// request. A streaming method sends its replies itself.
+type grpcMethod struct {
+	role   string
+	unary  func(ctx context.Context, req []protoField) (protoBuf, error)
+	stream func(ctx context.Context, req []protoField, send func(protoBuf) error) error
+}
+
+func (a *API) grpcMethods() map[string]grpcMethod {
+	return map[string]grpcMethod{
+		"GetStats":     {role: config.RoleViewer, unary: a.grpcGetStats},
+		"StreamStats":  {role: config.RoleViewer, stream: a.grpcStreamStats},
+		"AddPeer":      {role: config.RoleAdmin, unary: a.grpcAddPeer},
+		"RemovePeer":   {role: config.RoleAdmin, unary: a.grpcRemovePeer},
+		"Ban":          {role: config.RoleAdmin, unary: a.grpcBan},
+		"UpdateConfig": {role: config.RoleAdmin, unary: a.grpcUpdateConfig},
+	}
+}
+
+// ListenAndServeGRPC serves the gRPC control API on addr, over TLS with the
+// peer listener's certificate unless disable_ssl is set. Calls carry an API
+// token as "authorization: Bearer <token>" metadata, as the HTTP API does,
+// or come with a client certificate if api_auth accepts them.
+func (a *API) ListenAndServeGRPC(addr string) error {
+	methods := a.grpcMethods()
+	backends := a.cfg.APIAuth.Backends("grpc")
+	srv := &http.Server{
+		Addr: addr,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			a.grpcHandler(w, r, methods)
+		}),
+		Protocols:   new(http.Protocols),
+		BaseContext: listenerContext(backends),
+	}
+	if a.cfg.DisableSSL {
+		srv.Protocols.SetUnencryptedHTTP2(true)
+		logger.API.Warn("gRPC API listening on %s without TLS", addr)
+		return srv.ListenAndServe()
+	}
+	tlsCfg, err := a.listenerTLS(backends)
+	if err != nil {
+		return fmt.Errorf("gRPC API: loading TLS keys: %w", err)
+	}
+	srv.Protocols.SetHTTP2(true)
+	srv.TLSConfig = tlsCfg
+	logger.API.Info("gRPC API listening on %s", addr)
+	return srv.ListenAndServeTLS("", "")
+}
+
+func (a *API) grpcHandler(w http.ResponseWriter, r *http.Request, methods map[string]grpcMethod) {
+	if r.ProtoMajor != 2 {
+		http.Error(w, "gRPC needs HTTP/2", http.StatusHTTPVersionNotSupported)
+		return
+	}
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "Not a gRPC request", http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+	w.WriteHeader(http.StatusOK)
+	err := a.grpcCall(w, r, methods)
+	code, msg := grpcOK, ""
+	var ge *grpcError
+	switch {
+	case errors.As(err, &ge):
+		code, msg = ge.code, ge.msg
+	case err != nil:
+		code, msg = grpcInternal, err.Error()
+	}
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(code))
+	if msg != "" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", grpcEscape(msg))
+	}
+}
+
+func (a *API) grpcCall(w http.ResponseWriter, r *http.Request, methods map[string]grpcMethod) error {
+	if !a.limiter.allow(clientAddr(r), time.Now()) {
+		a.counters.limited.Add(1)
+		return grpcErrorf(grpcResourceExhausted, "too many requests")
+	}
+	name, ok := strings.CutPrefix(r.URL.Path, grpcService)
+	m, known := methods[name]
+	if !ok || !known {
+		return grpcErrorf(grpcUnimplemented, "unknown method %s", r.URL.Path)
+	}
+	claims, ok := a.authenticate(r)
+	if !ok {
+		return grpcErrorf(grpcUnauthenticated, "a valid API token or client certificate is required")
+	}
+	if !config.RoleAllows(claims.Role, m.role) {
+		return grpcErrorf(grpcPermissionDenied, "%s needs the %s role", name, m.role)
+	}
+
+	msg, err := readGRPCMessage(r.Body)
+	if err != nil {
+		return err
+	}
+	req, err := decodeProto(msg)
+	if err != nil {
+		return grpcErrorf(grpcInvalidArgument, "%v", err)
+	}
+	send := func(reply protoBuf) error {
+		if err := writeGRPCMessage(w, reply); err != nil {
+			return err
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		return nil
+	}
+	if m.stream != nil {
+		return m.stream(r.Context(), req, send)
+	}
+	reply, err := m.unary(r.Context(), req)
+	if err != nil {
+		return err
+	}
+	return send(reply)
+}
+
+// readGRPCMessage reads the one length-prefixed message of a request.
+func readGRPCMessage(r io.Reader) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, grpcErrorf(grpcInvalidArgument, "reading request: %v", err)
+	}
+	if prefix[0] != 0 {
+		return nil, grpcErrorf(grpcUnimplemented, "compressed messages are not supported")
+	}
+	n := binary.BigEndian.Uint32(prefix[1:])
+	if n > grpcMaxMessage {
+		return nil, grpcErrorf(grpcResourceExhausted, "request of %d bytes is too large", n)
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, grpcErrorf(grpcInvalidArgument, "reading request: %v", err)
+	}
+	return msg, nil
+}
+
+func writeGRPCMessage(w io.Writer, msg []byte) error {
+	prefix := [5]byte{}
+	binary.BigEndian.PutUint32(prefix[1:], uint32(len(msg)))
+	if _, err := w.Write(prefix[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(msg)
+	return err
+}
+
+// grpcEscape percent-encodes a status message as grpc-message requires.
+func grpcEscape(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		if c := msg[i]; c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func (a *API) grpcGetStats(ctx context.Context, req []protoField) (protoBuf, error) {
+	return statsProto(a.statsFunc())
+}
+
+// grpcStreamStats sends the stats every interval_ms until the call ends.
+func (a *API) grpcStreamStats(ctx context.Context, req []protoField, send func(protoBuf) error) error {
+	interval := grpcDefaultInterval
+	for _, f := range req {
+		if f.num == 1 && f.n > 0 {
+			interval = max(time.Duration(f.n)*time.Millisecond, grpcMinInterval)
+		}
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		msg, err := statsProto(a.statsFunc())
+		if err != nil {
+			return err
+		}
+		if err := send(msg); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (a *API) grpcAddPeer(ctx context.Context, req []protoField) (protoBuf, error) {
+	var addr string
+	for _, f := range req {
+		if f.num == 1 {
+			addr = f.string()
+		}
+	}
+	if addr == "" {
+		return nil, grpcErrorf(grpcInvalidArgument, "address is required")
+	}
+	a.srv.AddPeer(ctx, addr)
+	return nil, nil
+}
+
+func (a *API) grpcRemovePeer(ctx context.Context, req []protoField) (protoBuf, error) {
+	var addr string
+	for _, f := range req {
+		if f.num == 1 {
+			addr = f.string()
+		}
+	}
+	if err := a.srv.RemovePeer(addr); err != nil {
+		return nil, grpcErrorf(grpcNotFound, "%v", err)
+	}
+	return nil, nil
+}
+
+func (a *API) grpcBan(ctx context.Context, req []protoField) (protoBuf, error) {
+	var id, ip string
+	var hours uint64
+	for _, f := range req {
+		switch f.num {
+		case 1:
+			id = f.string()
+		case 2:
+			ip = f.string()
+		case 3:
+			hours = f.n
+		}
+	}
+	if id == "" && ip == "" {
+		return nil, grpcErrorf(grpcInvalidArgument, "id or ip is required")
+	}
+	if err := checkBanHost(ip, int(hours)); err != nil {
+		return nil, grpcErrorf(grpcInvalidArgument, "%v", err)
+	}
+	if hours > 0 {
+		a.srv.BanPeerFor(id, ip, time.Duration(hours)*time.Hour)
+	} else {
+		a.srv.BanPeer(id, ip)
+	}
+	return nil, nil
+}
+
+func (a *API) grpcUpdateConfig(ctx context.Context, req []protoField) (protoBuf, error) {
+	var adminPass, networkKey string
+	var maxChildren, rebalanceInterval int
+	var rebalanceEnabled bool
+	for _, f := range req {
+		switch f.num {
+		case 1:
+			adminPass = f.string()
+		case 2:
+			maxChildren = int(int32(f.n))
+		case 3:
+			networkKey = f.string()
+		case 4:
+			rebalanceEnabled = f.n != 0
+		case 5:
+			rebalanceInterval = int(int32(f.n))
+		}
+	}
+	a.srv.UpdateConfig(adminPass, maxChildren, networkKey, rebalanceEnabled, rebalanceInterval)
+	return nil, nil
+}
+
+// statsProto encodes the Stats message: the headline figures and peers as
+// fields, and the whole snapshot as served at /stats in json.
+func statsProto(s stats.Stats) (protoBuf, error) {
+	raw, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	var b protoBuf
+	b.string(1, s.NodeID)
+	b.uint(2, s.TotalReceived)
+	b.uint(3, s.TotalForwarded)
+	b.uint(4, s.TotalDropped)
+	b.uint(5, s.TotalErrors)
+	b.int(6, int64(s.Uptime/time.Second))
+	b.int(7, int64(s.PeerCount))
+	for _, p := range s.Peers {
+		b.message(8, peerProto(p))
+	}
+	b.string(9, s.CaptureError)
+	b.string(10, s.Hub)
+	b.uint(11, s.ReceivedBytes)
+	b.uint(12, s.ForwardedBytes)
+	b.bytes(15, raw)
+	return b, nil
+}
+
+func peerProto(p stats.PeerStat) protoBuf {
+	var b protoBuf
+	b.string(1, p.ID)
+	if p.IP != nil {
+		b.string(2, p.IP.String())
+	}
+	b.string(3, p.NodeID)
+	b.string(4, p.Hostname)
+	b.bool(5, p.Inbound)
+	if !p.ConnectedAt.IsZero() {
+		b.int(6, p.ConnectedAt.Unix())
+	}
+	if !p.LastSeen.IsZero() {
+		b.int(7, p.LastSeen.Unix())
+	}
+	b.uint(8, p.SentBytes)
+	b.uint(9, p.RecvBytes)
+	b.uint(10, p.SentPkts)
+	b.uint(11, p.RecvPkts)
+	b.uint(12, p.Errors)
+	b.double(13, p.LatencyMs)
+	b.string(14, p.Trust)
+	b.string(15, p.ParentID)
+	b.bool(16, p.MutedIn)
+	b.bool(17, p.MutedOut)
+	b.uint(18, p.QueuedBytes)
+	return b
+}
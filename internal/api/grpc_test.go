@@ -0,0 +1,132 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for the gRPC control API over h2c
+
+package api
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+)
+
+// newGRPCTestServer serves the gRPC API of a over HTTP/2 without TLS.
+func newGRPCTestServer(t *testing.T, a *API) *httptest.Server {
+	methods := a.grpcMethods()
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.grpcHandler(w, r, methods)
+	}))
+	srv.Config.Protocols = new(http.Protocols)
+	srv.Config.Protocols.SetUnencryptedHTTP2(true)
+	srv.Config.BaseContext = listenerContext([]string{config.AuthLocal})
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// grpcCall makes a unary call and returns the reply and its grpc-status.
+func grpcCall(t *testing.T, srv *httptest.Server, method, token string, req protoBuf) ([]byte, int) {
+	t.Helper()
+	body := make([]byte, 5, 5+len(req))
+	binary.BigEndian.PutUint32(body[1:], uint32(len(req)))
+	body = append(body, req...)
+	r, _ := http.NewRequest(http.MethodPost, srv.URL+grpcService+method, bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/grpc")
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	client := &http.Client{Transport: &http.Transport{Protocols: protocols}}
+	resp, err := client.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	reply, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.ProtoMajor != 2 {
+		t.Fatalf("Expected HTTP/2, got %s", resp.Proto)
+	}
+	code, err := strconv.Atoi(resp.Trailer.Get("Grpc-Status"))
+	if err != nil {
+		t.Fatalf("Expected a grpc-status trailer, got %v", resp.Trailer)
+	}
+	if len(reply) >= 5 {
+		reply = reply[5:]
+	}
+	return reply, code
+}
+
+func TestGRPCAuth(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.APIUsers = []config.APIUser{{Name: "status", Pass: "look", Role: config.RoleViewer}}
+	a := newTestAPI(t, cfg)
+	srv := newGRPCTestServer(t, a)
+	viewer, err := issueToken(cfg, "status", time.Minute, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ban protoBuf
+	ban.string(2, "10.0.0.9")
+
+	if _, code := grpcCall(t, srv, "GetStats", "", nil); code != grpcUnauthenticated {
+		t.Errorf("Expected a call without a token to get %d, got %d", grpcUnauthenticated, code)
+	}
+	if _, code := grpcCall(t, srv, "GetStats", "not-a-token", nil); code != grpcUnauthenticated {
+		t.Errorf("Expected a call with a bad token to get %d, got %d", grpcUnauthenticated, code)
+	}
+	if _, code := grpcCall(t, srv, "Ban", viewer, ban); code != grpcPermissionDenied {
+		t.Errorf("Expected a viewer's Ban to get %d, got %d", grpcPermissionDenied, code)
+	}
+	if len(a.srv.Bans()) != 0 {
+		t.Error("Expected the viewer's ban not to be made")
+	}
+	if _, code := grpcCall(t, srv, "Nope", viewer, nil); code != grpcUnimplemented {
+		t.Errorf("Expected an unknown method to get %d, got %d", grpcUnimplemented, code)
+	}
+}
+
+func TestGRPCGetStats(t *testing.T) {
+	cfg := config.DefaultConfig()
+	a := newTestAPI(t, cfg)
+	srv := newGRPCTestServer(t, a)
+	admin, err := NewToken(cfg, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reply, code := grpcCall(t, srv, "GetStats", admin, nil)
+	if code != grpcOK {
+		t.Fatalf("Expected GetStats to succeed, got %d", code)
+	}
+	fields, err := decodeProto(reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var nodeID string
+	var raw []byte
+	for _, f := range fields {
+		switch f.num {
+		case 1:
+			nodeID = f.string()
+		case 15:
+			raw = f.bytes
+		}
+	}
+	if want := a.statsFunc().NodeID; nodeID == "" || nodeID != want {
+		t.Errorf("Expected node ID %q, got %q", want, nodeID)
+	}
+	if !bytes.HasPrefix(raw, []byte("{")) {
+		t.Errorf("Expected the stats as json in field 15, got %q", raw)
+	}
+}
@@ -0,0 +1,39 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// HTTP API for the traffic history
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+// historyHandler returns the RX, TX and drop rates and the end-stations
+// served over the last range (a Go duration, default 1h) so graphs can be
+// redrawn after reconnecting.
+func (a *API) historyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	span := time.Hour
+	if v := r.URL.Query().Get("range"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			http.Error(w, "Invalid range", http.StatusBadRequest)
+			return
+		}
+		span = d
+	}
+	samples, resolution := a.srv.History(span)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"resolution": resolution.Seconds(),
+		"range":      span.Seconds(),
+		"points":     stats.Series(samples),
+	})
+}
@@ -0,0 +1,21 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// HTTP API for the IPX host inventory
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// hostsHandler lists the IPX hosts seen on the local segment and behind
+// peers. Like /stats, which carries the same list, it needs no login.
+func (a *API) hostsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(a.srv.Hosts())
+}
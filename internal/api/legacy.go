@@ -0,0 +1,27 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Legacy IPXNET bridge endpoint
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// legacyIPXNetHandler shows the clients of the legacy IPXNET server, the
+// pseudo-peers they are listed as and the proxies mesh stations reach them
+// through.
+func (a *API) legacyIPXNetHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	l := a.srv.LegacyIPXNet()
+	if l == nil {
+		http.Error(w, "legacy_ipxnet is not set", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(l)
+}
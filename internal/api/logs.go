@@ -0,0 +1,100 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// HTTP API streaming log messages as Server-Sent Events
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+)
+
+// streamKeepAlive is how often an idle stream gets a comment, so proxies
+// do not time it out.
+const streamKeepAlive = 15 * time.Second
+
+// tokenFromQuery takes the JWT from the access_token parameter when there
+// is no Authorization header: a browser EventSource cannot set one.
+func tokenFromQuery(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if tok := r.URL.Query().Get("access_token"); tok != "" && r.Header.Get("Authorization") == "" {
+			r.Header.Set("Authorization", "Bearer "+tok)
+		}
+		next(w, r)
+	}
+}
+
+// logStreamHandler sends the buffered log messages and then each new one
+// as a "log" event carrying the message as JSON. The level and subsystem
+// parameters select the least severe level and a single subsystem.
+func (a *API) logStreamHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	minLevel := slog.LevelDebug
+	if s := r.URL.Query().Get("level"); s != "" {
+		l, err := logger.ParseLevel(s)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		minLevel = l
+	}
+	subsystem := r.URL.Query().Get("subsystem")
+	send := func(m logger.LogMessage) error {
+		if subsystem != "" && m.Subsystem != subsystem {
+			return nil
+		}
+		if l, err := logger.ParseLevel(m.Level); err == nil && l < minLevel {
+			return nil // FATAL does not parse and always passes
+		}
+		data, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "event: log\ndata: %s\n\n", data)
+		return err
+	}
+
+	backlog, ch, cancel := logger.Subscribe(256)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	for _, m := range backlog {
+		if err := send(m); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case m := <-ch:
+			if err := send(m); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
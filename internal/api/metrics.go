@@ -0,0 +1,21 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Prometheus metrics endpoint
+
+package api
+
+import (
+	"net/http"
+
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+// metricsHandler serves the relay statistics in the Prometheus text format.
+// Like /stats it needs no login, so scrapers can reach it.
+func (a *API) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := stats.WritePrometheus(w, a.statsFunc()); err != nil {
+		logger.API.Error("Metrics write error: %v", err)
+	}
+}
@@ -0,0 +1,401 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// OIDC: dashboard logins by the authorization-code flow, access tokens by introspection
+
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+)
+
+const (
+	oidcMetadataTTL   = time.Hour
+	oidcLoginTimeout  = 10 * time.Minute // from the redirect to the provider to the callback
+	oidcMaxLogins     = 1000             // logins in progress kept, the oldest are dropped
+	oidcIntrospectTTL = time.Minute      // how long an introspected token is trusted before it is asked about again
+	oidcTimeout       = 10 * time.Second
+	// oidcStateCookie binds a login to the browser that started it, so a
+	// callback URL handed to someone else does not log them in.
+	oidcStateCookie = "ipxt_oidc_state"
+)
+
+// oidcClient talks to the OIDC provider of api_auth.
+type oidcClient struct {
+	cfg    config.OIDCConfig
+	client *http.Client
+
+	mu     sync.Mutex
+	meta   *oidcMetadata
+	metaAt time.Time
+	logins map[string]oidcLogin  // state -> login in progress
+	active map[string]oidcActive // SHA-256 of an access token -> its caller
+}
+
+// oidcMetadata is what the provider publishes at
+// /.well-known/openid-configuration.
+type oidcMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	IntrospectionEndpoint string `json:"introspection_endpoint"`
+}
+
+type oidcLogin struct {
+	verifier string // PKCE code verifier
+	nonce    string
+	expires  time.Time
+}
+
+type oidcActive struct {
+	claims *tokenClaims
+	until  time.Time
+}
+
+func newOIDCClient(cfg config.OIDCConfig) *oidcClient {
+	return &oidcClient{
+		cfg:    cfg,
+		client: &http.Client{Timeout: oidcTimeout},
+		logins: make(map[string]oidcLogin),
+		active: make(map[string]oidcActive),
+	}
+}
+
+// metadata fetches the provider's metadata, at most once an hour.
+func (o *oidcClient) metadata(ctx context.Context) (*oidcMetadata, error) {
+	o.mu.Lock()
+	if o.meta != nil && time.Since(o.metaAt) < oidcMetadataTTL {
+		defer o.mu.Unlock()
+		return o.meta, nil
+	}
+	o.mu.Unlock()
+
+	issuer := strings.TrimSuffix(o.cfg.Issuer, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("provider metadata: %s", resp.Status)
+	}
+	meta := &oidcMetadata{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(meta); err != nil {
+		return nil, fmt.Errorf("provider metadata: %v", err)
+	}
+	switch {
+	case strings.TrimSuffix(meta.Issuer, "/") != issuer:
+		return nil, fmt.Errorf("provider metadata is for issuer %q", meta.Issuer)
+	case meta.AuthorizationEndpoint == "" || !strings.HasPrefix(meta.TokenEndpoint, "https://"):
+		return nil, errors.New("provider metadata lacks an authorization endpoint or an https token endpoint")
+	}
+	o.mu.Lock()
+	o.meta, o.metaAt = meta, time.Now()
+	o.mu.Unlock()
+	return meta, nil
+}
+
+// begin records a login sent to the provider under its state.
+func (o *oidcClient) begin(state string, l oidcLogin) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	now := time.Now()
+	for s, old := range o.logins {
+		if now.After(old.expires) {
+			delete(o.logins, s)
+		}
+	}
+	if len(o.logins) >= oidcMaxLogins {
+		var oldest string
+		for s, old := range o.logins {
+			if oldest == "" || old.expires.Before(o.logins[oldest].expires) {
+				oldest = s
+			}
+		}
+		delete(o.logins, oldest)
+	}
+	o.logins[state] = l
+}
+
+// finish returns the login of state, once.
+func (o *oidcClient) finish(state string) (oidcLogin, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	l, ok := o.logins[state]
+	delete(o.logins, state)
+	return l, ok && time.Now().Before(l.expires)
+}
+
+// post sends a form to an endpoint of the provider as the hub's client and
+// decodes the JSON answer into out.
+func (o *oidcClient) post(ctx context.Context, endpoint string, form url.Values, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(o.cfg.ClientID), url.QueryEscape(o.cfg.ClientSecret))
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s: %.200s", endpoint, resp.Status, body)
+	}
+	return json.Unmarshal(body, out)
+}
+
+// exchange trades the code of a login for its ID token and returns the
+// user it names and their role.
+func (o *oidcClient) exchange(ctx context.Context, code string, l oidcLogin) (string, string, error) {
+	meta, err := o.metadata(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	var res struct {
+		IDToken string `json:"id_token"`
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {o.cfg.RedirectURL},
+		"code_verifier": {l.verifier},
+	}
+	if err := o.post(ctx, meta.TokenEndpoint, form, &res); err != nil {
+		return "", "", err
+	}
+	// The ID token comes straight from the token endpoint over TLS, which
+	// OpenID Connect Core (3.1.3.7) accepts in place of its signature.
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(res.IDToken, claims); err != nil {
+		return "", "", fmt.Errorf("ID token: %v", err)
+	}
+	iss, _ := claims.GetIssuer()
+	aud, _ := claims.GetAudience()
+	exp, _ := claims.GetExpirationTime()
+	nonce, _ := claims["nonce"].(string)
+	switch {
+	case iss != meta.Issuer:
+		return "", "", fmt.Errorf("ID token is from issuer %q", iss)
+	case !slices.Contains(aud, o.cfg.ClientID):
+		return "", "", errors.New("ID token is for another client")
+	case exp == nil || time.Now().After(exp.Time):
+		return "", "", errors.New("ID token has expired")
+	case nonce != l.nonce:
+		return "", "", errors.New("ID token is for another login")
+	}
+	return o.cfg.Role(claims)
+}
+
+// introspect asks the provider about an access token and returns its
+// holder. The answer is kept for a minute, or until the token expires.
+func (o *oidcClient) introspect(ctx context.Context, tok string) (*tokenClaims, bool) {
+	sum := sha256.Sum256([]byte(tok))
+	key := hex.EncodeToString(sum[:])
+	now := time.Now()
+	o.mu.Lock()
+	if act, ok := o.active[key]; ok && now.Before(act.until) {
+		o.mu.Unlock()
+		return act.claims, true
+	}
+	o.mu.Unlock()
+
+	meta, err := o.metadata(ctx)
+	if err != nil || meta.IntrospectionEndpoint == "" {
+		logger.API.Debug("OIDC: cannot introspect tokens: %v", err)
+		return nil, false
+	}
+	var res map[string]any
+	form := url.Values{"token": {tok}, "token_type_hint": {"access_token"}}
+	if err := o.post(ctx, meta.IntrospectionEndpoint, form, &res); err != nil {
+		logger.API.Warn("OIDC: introspecting a token: %v", err)
+		return nil, false
+	}
+	if active, _ := res["active"].(bool); !active {
+		return nil, false
+	}
+	if !o.forUs(res) {
+		logger.API.Warn("OIDC: refusing an access token issued to another client")
+		return nil, false
+	}
+	until := now.Add(oidcIntrospectTTL)
+	exp := until
+	if e, ok := res["exp"].(float64); ok {
+		exp = time.Unix(int64(e), 0)
+		if exp.Before(until) {
+			until = exp
+		}
+	}
+	if !now.Before(exp) {
+		return nil, false
+	}
+	user, role, err := o.cfg.Role(res)
+	if err != nil {
+		logger.API.Warn("OIDC: refusing an access token: %v", err)
+		return nil, false
+	}
+	claims := &tokenClaims{
+		User:     user,
+		Role:     role,
+		Auth:     config.AuthOIDC,
+		AuthTime: now.Unix(),
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(exp),
+		},
+	}
+	o.mu.Lock()
+	for k, act := range o.active {
+		if now.After(act.until) {
+			delete(o.active, k)
+		}
+	}
+	o.active[key] = oidcActive{claims: claims, until: until}
+	o.mu.Unlock()
+	return claims, true
+}
+
+// forUs reports whether an introspected token was issued to the hub's
+// client: its audience, client_id or azp is client_id. A token of another
+// application at the same provider is active too, but not ours to accept.
+func (o *oidcClient) forUs(res map[string]any) bool {
+	var aud []string
+	switch v := res["aud"].(type) {
+	case string:
+		aud = []string{v}
+	case []any:
+		for _, a := range v {
+			if s, ok := a.(string); ok {
+				aud = append(aud, s)
+			}
+		}
+	}
+	client, _ := res["client_id"].(string)
+	azp, _ := res["azp"].(string)
+	id := o.cfg.ClientID
+	return slices.Contains(aud, id) || client == id || azp == id
+}
+
+// stateHash is what the state cookie holds: the state, hashed so the
+// cookie alone cannot complete a login.
+func stateHash(state string) string {
+	sum := sha256.Sum256([]byte(state))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// randomString is 32 random bytes, URL-safe.
+func randomString() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// oidcLoginHandler sends the browser to the provider to log in.
+func (a *API) oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if a.oidc == nil || !accepts(r, config.AuthOIDC) {
+		http.NotFound(w, r)
+		return
+	}
+	meta, err := a.oidc.metadata(r.Context())
+	if err != nil {
+		logger.API.Error("OIDC: %v", err)
+		http.Error(w, "The identity provider cannot be reached", http.StatusBadGateway)
+		return
+	}
+	state, l := randomString(), oidcLogin{verifier: randomString(), nonce: randomString(), expires: time.Now().Add(oidcLoginTimeout)}
+	a.oidc.begin(state, l)
+	// Lax, as the provider sends the browser back by a cross-site redirect.
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    stateHash(state),
+		Path:     "/api/oidc/callback",
+		MaxAge:   int(oidcLoginTimeout / time.Second),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	challenge := sha256.Sum256([]byte(l.verifier))
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {a.oidc.cfg.ClientID},
+		"redirect_uri":          {a.oidc.cfg.RedirectURL},
+		"scope":                 {strings.Join(append([]string{"openid"}, a.oidc.cfg.Scopes...), " ")},
+		"state":                 {state},
+		"nonce":                 {l.nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(meta.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	http.Redirect(w, r, meta.AuthorizationEndpoint+sep+q.Encode(), http.StatusFound)
+}
+
+// oidcCallbackHandler is where the provider sends the browser back. The
+// user gets an API token like a local login's, handed to the dashboard in
+// the URL fragment so it is not logged on the way.
+func (a *API) oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if a.oidc == nil || !accepts(r, config.AuthOIDC) {
+		http.NotFound(w, r)
+		return
+	}
+	q := r.URL.Query()
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(stateHash(q.Get("state")))) != 1 {
+		logger.API.Warn("OIDC callback from %s refused: the login was not started in this browser", clientAddr(r))
+		http.Error(w, "This login was not started in this browser, log in again", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/api/oidc/callback", MaxAge: -1, HttpOnly: true, Secure: r.TLS != nil, SameSite: http.SameSiteLaxMode})
+	l, ok := a.oidc.finish(q.Get("state"))
+	if !ok {
+		http.Error(w, "Unknown or expired login, log in again", http.StatusBadRequest)
+		return
+	}
+	if e := q.Get("error"); e != "" {
+		http.Error(w, "The identity provider refused the login: "+e+" "+q.Get("error_description"), http.StatusForbidden)
+		return
+	}
+	user, role, err := a.oidc.exchange(r.Context(), q.Get("code"), l)
+	if err != nil {
+		logger.API.Warn("OIDC login from %s refused: %v", clientAddr(r), err)
+		http.Error(w, "Login refused: "+err.Error(), http.StatusForbidden)
+		return
+	}
+	token, err := issueOIDCToken(a.cfg, user, role, a.tokenTTL(), time.Now())
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	logger.API.Info("%s logged in over OIDC as %s", user, role)
+	http.Redirect(w, r, "/ui/#sso="+token, http.StatusFound)
+}
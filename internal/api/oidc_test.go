@@ -0,0 +1,130 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for OIDC logins and token introspection
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/mlapointe/ipxtransporter/internal/config"
+)
+
+// fakeProvider is an OIDC provider issuing ID tokens with nonce to the
+// client hub, and introspecting the access tokens in tokens.
+type fakeProvider struct {
+	*httptest.Server
+	nonce  string
+	tokens map[string]map[string]any
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	p := &fakeProvider{tokens: make(map[string]map[string]any)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcMetadata{
+			Issuer:                p.URL,
+			AuthorizationEndpoint: p.URL + "/authorize",
+			TokenEndpoint:         p.URL + "/token",
+			IntrospectionEndpoint: p.URL + "/introspect",
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		id, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"iss": p.URL, "aud": "hub", "sub": "alice", "groups": []string{"ops"},
+			"nonce": p.nonce, "exp": time.Now().Add(time.Minute).Unix(),
+		}).SignedString([]byte("unchecked"))
+		json.NewEncoder(w).Encode(map[string]string{"id_token": id})
+	})
+	mux.HandleFunc("/introspect", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		res, ok := p.tokens[r.PostForm.Get("token")]
+		if !ok {
+			res = map[string]any{"active": false}
+		}
+		json.NewEncoder(w).Encode(res)
+	})
+	p.Server = httptest.NewTLSServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+func newOIDCTestAPI(t *testing.T) (*API, *fakeProvider) {
+	provider := newFakeProvider(t)
+	cfg := config.DefaultConfig()
+	cfg.APIAuth.HTTP = []string{config.AuthLocal, config.AuthOIDC}
+	cfg.APIAuth.OIDC = config.OIDCConfig{
+		Issuer: provider.URL, ClientID: "hub", ClientSecret: "s", RedirectURL: "https://hub/api/oidc/callback",
+		RoleClaim: "groups", AdminValues: []string{"ops"},
+	}
+	a := newTestAPI(t, cfg)
+	a.oidc.client = provider.Client()
+	return a, provider
+}
+
+func TestOIDCLoginBoundToBrowser(t *testing.T) {
+	a, provider := newOIDCTestAPI(t)
+
+	// begin starts a login and returns its state and cookie.
+	begin := func() (string, *http.Cookie) {
+		rec := serve(a, onListener(httptest.NewRequest(http.MethodGet, "/api/oidc/login", nil), config.AuthOIDC))
+		if rec.Code != http.StatusFound {
+			t.Fatalf("Expected a redirect to the provider, got %d", rec.Code)
+		}
+		loc, _ := url.Parse(rec.Header().Get("Location"))
+		cookies := rec.Result().Cookies()
+		if len(cookies) != 1 || !cookies[0].HttpOnly || cookies[0].SameSite != http.SameSiteLaxMode {
+			t.Fatalf("Expected an HttpOnly SameSite state cookie, got %v", cookies)
+		}
+		return loc.Query().Get("state"), cookies[0]
+	}
+	callback := func(state string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/api/oidc/callback?code=c&state="+url.QueryEscape(state), nil)
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+		return serve(a, onListener(r, config.AuthOIDC))
+	}
+
+	attacker, attackerCookie := begin()
+	_, victimCookie := begin()
+	if rec := callback(attacker, nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected a callback without the state cookie refused, got %d", rec.Code)
+	}
+	if rec := callback(attacker, victimCookie); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected a callback with the cookie of another login refused, got %d", rec.Code)
+	}
+
+	provider.nonce = a.oidc.logins[attacker].nonce
+	rec := callback(attacker, attackerCookie)
+	if rec.Code != http.StatusFound || !strings.HasPrefix(rec.Header().Get("Location"), "/ui/#sso=") {
+		t.Fatalf("Expected the login completed in its own browser, got %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestOIDCIntrospectAudience(t *testing.T) {
+	a, provider := newOIDCTestAPI(t)
+	exp := float64(time.Now().Add(time.Hour).Unix())
+	provider.tokens["ours"] = map[string]any{"active": true, "sub": "alice", "groups": []any{"ops"}, "aud": []any{"hub"}, "exp": exp}
+	provider.tokens["by-client"] = map[string]any{"active": true, "sub": "bob", "groups": []any{"ops"}, "client_id": "hub", "exp": exp}
+	provider.tokens["theirs"] = map[string]any{"active": true, "sub": "eve", "groups": []any{"ops"}, "aud": "wiki", "client_id": "wiki", "exp": exp}
+	provider.tokens["no-audience"] = map[string]any{"active": true, "sub": "eve", "groups": []any{"ops"}, "exp": exp}
+
+	for tok, want := range map[string]bool{"ours": true, "by-client": true, "theirs": false, "no-audience": false, "unknown": false} {
+		c, ok := a.oidc.introspect(context.Background(), tok)
+		if ok != want {
+			t.Errorf("Expected token %s accepted=%v, got %v", tok, want, ok)
+		}
+		if ok && c.Role != config.RoleAdmin {
+			t.Errorf("Expected token %s to hold admin, got %s", tok, c.Role)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// HTTP API for mesh-wide captures
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+	"github.com/mlapointe/ipxtransporter/internal/relay"
+)
+
+// pcapHandler manages mesh captures:
+//
+//	GET   the captures this node started or took part in
+//	POST  {"nodes": [...], "duration": ..., "max_packets": ..., "pull": ...}
+//	      starts one, on every node if nodes is empty
+func (a *API) pcapHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		_ = json.NewEncoder(w).Encode(a.srv.MeshCaptures())
+
+	case http.MethodPost:
+		var req relay.CaptureRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+		c, err := a.srv.StartMeshCapture(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		logger.API.Info("Mesh capture %s started by %s", c.ID, claimsFrom(r).User)
+		_ = json.NewEncoder(w).Encode(map[string]any{"success": true, "capture": c})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// pcapFileHandler downloads a finished capture file, this node's or one
+// pulled back from another: GET ?id=<capture>&node=<node ID>.
+func (a *API) pcapFileHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	path, ok := a.srv.MeshCaptureFile(r.URL.Query().Get("id"), r.URL.Query().Get("node"))
+	if !ok {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-pcapng")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filepath.Base(path)+`"`)
+	http.ServeFile(w, r, path)
+}
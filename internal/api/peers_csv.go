@@ -0,0 +1,26 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// HTTP API for the CSV export of the peer list
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+// peersCSVHandler downloads the peer list as CSV with the custom columns
+// of peer_columns. It needs a login as the columns can show peer notes.
+func (a *API) peersCSVHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := fmt.Sprintf("ipxtransporter-peers-%s.csv", time.Now().UTC().Format("20060102-150405"))
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	_ = stats.WritePeersCSV(w, a.statsFunc().Peers, a.columns)
+}
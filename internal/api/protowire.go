@@ -0,0 +1,131 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Protocol Buffers wire format for the messages of the gRPC API
+
+package api
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// Wire types, see https://protobuf.dev/programming-guides/encoding/.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errBadProto = errors.New("malformed protobuf message")
+
+// protoBuf appends the fields of one message. Zero values are left out, as
+// proto3 does.
+type protoBuf []byte
+
+func (b *protoBuf) tag(field, wire int) {
+	*b = binary.AppendUvarint(*b, uint64(field)<<3|uint64(wire))
+}
+
+func (b *protoBuf) uint(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	b.tag(field, wireVarint)
+	*b = binary.AppendUvarint(*b, v)
+}
+
+func (b *protoBuf) int(field int, v int64) {
+	b.uint(field, uint64(v))
+}
+
+func (b *protoBuf) bool(field int, v bool) {
+	if v {
+		b.uint(field, 1)
+	}
+}
+
+func (b *protoBuf) double(field int, v float64) {
+	if v == 0 {
+		return
+	}
+	b.tag(field, wireFixed64)
+	*b = binary.LittleEndian.AppendUint64(*b, math.Float64bits(v))
+}
+
+func (b *protoBuf) bytes(field int, v []byte) {
+	if len(v) == 0 {
+		return
+	}
+	b.tag(field, wireBytes)
+	*b = binary.AppendUvarint(*b, uint64(len(v)))
+	*b = append(*b, v...)
+}
+
+func (b *protoBuf) string(field int, v string) {
+	b.bytes(field, []byte(v))
+}
+
+// message appends a nested message, also when it is empty, as repeated
+// fields need every element.
+func (b *protoBuf) message(field int, m protoBuf) {
+	b.tag(field, wireBytes)
+	*b = binary.AppendUvarint(*b, uint64(len(m)))
+	*b = append(*b, m...)
+}
+
+// protoField is one field read from a message: the number, and the value
+// as an integer or as bytes by wire type.
+type protoField struct {
+	num   int
+	wire  int
+	n     uint64
+	bytes []byte
+}
+
+func (f protoField) string() string { return string(f.bytes) }
+
+// decodeProto splits a message into its fields. Fields the caller does not
+// know are simply not looked at.
+func decodeProto(msg []byte) ([]protoField, error) {
+	var out []protoField
+	for len(msg) > 0 {
+		key, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return nil, errBadProto
+		}
+		msg = msg[n:]
+		f := protoField{num: int(key >> 3), wire: int(key & 7)}
+		switch f.wire {
+		case wireVarint:
+			if f.n, n = binary.Uvarint(msg); n <= 0 {
+				return nil, errBadProto
+			}
+			msg = msg[n:]
+		case wireFixed64:
+			if len(msg) < 8 {
+				return nil, errBadProto
+			}
+			f.n, msg = binary.LittleEndian.Uint64(msg), msg[8:]
+		case wireFixed32:
+			if len(msg) < 4 {
+				return nil, errBadProto
+			}
+			f.n, msg = uint64(binary.LittleEndian.Uint32(msg)), msg[4:]
+		case wireBytes:
+			l, n := binary.Uvarint(msg)
+			if n <= 0 || l > uint64(len(msg)-n) {
+				return nil, errBadProto
+			}
+			f.bytes, msg = msg[n:n+int(l)], msg[n+int(l):]
+		default:
+			return nil, errBadProto
+		}
+		if f.num == 0 {
+			return nil, errBadProto
+		}
+		out = append(out, f)
+	}
+	return out, nil
+}
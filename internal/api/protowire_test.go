@@ -0,0 +1,84 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for the Protocol Buffers wire format
+
+package api
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestProtoRoundTrip(t *testing.T) {
+	var inner protoBuf
+	inner.string(1, "peer")
+	var b protoBuf
+	b.string(1, "node")
+	b.uint(2, 300)
+	b.int(3, -1)
+	b.bool(4, true)
+	b.double(5, 1.5)
+	b.bytes(6, []byte{0, 1, 2})
+	b.message(7, inner)
+	b.message(7, nil)
+	b.uint(8, 0)
+	b.bool(9, false)
+	b.string(10, "")
+
+	fields, err := decodeProto(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fields) != 8 {
+		t.Fatalf("Expected zero values left out, got %d fields: %+v", len(fields), fields)
+	}
+	for i, want := range []protoField{
+		{num: 1, wire: wireBytes, bytes: []byte("node")},
+		{num: 2, wire: wireVarint, n: 300},
+		{num: 3, wire: wireVarint, n: math.MaxUint64},
+		{num: 4, wire: wireVarint, n: 1},
+		{num: 5, wire: wireFixed64, n: math.Float64bits(1.5)},
+		{num: 6, wire: wireBytes, bytes: []byte{0, 1, 2}},
+		{num: 7, wire: wireBytes, bytes: inner},
+		{num: 7, wire: wireBytes, bytes: []byte{}},
+	} {
+		f := fields[i]
+		if f.num != want.num || f.wire != want.wire || f.n != want.n || !bytes.Equal(f.bytes, want.bytes) {
+			t.Errorf("Field %d: expected %+v, got %+v", i, want, f)
+		}
+	}
+	nested, err := decodeProto(fields[6].bytes)
+	if err != nil || len(nested) != 1 || nested[0].string() != "peer" {
+		t.Errorf("Expected the nested message back, got %+v, %v", nested, err)
+	}
+}
+
+func TestProtoTruncated(t *testing.T) {
+	var b protoBuf
+	b.string(1, "node")
+	b.uint(2, 300)
+	b.double(3, 2.5)
+	// A message cut after a whole field decodes to the fields before it;
+	// cut inside one, it is refused.
+	ends := map[int]bool{0: true, 6: true, 9: true, len(b): true}
+	for n := 0; n <= len(b); n++ {
+		_, err := decodeProto(b[:n])
+		if ends[n] != (err == nil) {
+			t.Errorf("Cut at %d of %d: expected error=%v, got %v", n, len(b), !ends[n], err)
+		}
+	}
+
+	for _, bad := range [][]byte{
+		{0x0a, 0xff, 0xff, 0xff, 0xff, 0x0f}, // length far past the end
+		{0x0b},                               // wire type 3, a group
+		{0x02, 0x01},                         // field 0
+		{0x08, 0x80},                         // varint cut short
+		{0x80},                               // key cut short
+		{0x0d, 0x01, 0x02},                   // fixed32 cut short
+	} {
+		if _, err := decodeProto(bad); err == nil {
+			t.Errorf("Expected % x refused", bad)
+		}
+	}
+}
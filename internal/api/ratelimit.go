@@ -0,0 +1,237 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Request rate limit and login lockout by client address
+
+package api
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+const (
+	// maxLoginLockout caps the doubling lockout; failures older than this
+	// are forgotten.
+	maxLoginLockout = time.Hour
+	// limiterPruneInterval is how often idle entries are dropped.
+	limiterPruneInterval = time.Minute
+)
+
+// rateLimiter is a token bucket per client address: rate requests per
+// second on average, with bursts up to burst.
+type rateLimiter struct {
+	rate, burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastPrune time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{rate: rate, burst: float64(burst), buckets: make(map[string]*bucket)}
+}
+
+func (l *rateLimiter) allow(addr string, now time.Time) bool {
+	if l.rate <= 0 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastPrune) > limiterPruneInterval {
+		// A bucket that has refilled is the same as none.
+		full := time.Duration(l.burst / l.rate * float64(time.Second))
+		for a, b := range l.buckets {
+			if now.Sub(b.last) > full {
+				delete(l.buckets, a)
+			}
+		}
+		l.lastPrune = now
+	}
+	b, ok := l.buckets[addr]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[addr] = b
+	}
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// loginGuard locks an address out of /api/login after maxFailures failed
+// attempts in a row, for lockout at first and twice as long with each
+// further failure. Attempts are reserved before the password is hashed, so
+// concurrent ones cannot slip past the limit while earlier ones are still
+// being checked.
+type loginGuard struct {
+	maxFailures int
+	lockout     time.Duration
+
+	mu     sync.Mutex
+	byAddr map[string]*loginFailures
+}
+
+type loginFailures struct {
+	count    int
+	inFlight int // attempts reserved and not yet failed or succeeded
+	last     time.Time
+	until    time.Time
+}
+
+func newLoginGuard(maxFailures int, lockout time.Duration) *loginGuard {
+	return &loginGuard{maxFailures: maxFailures, lockout: lockout, byAddr: make(map[string]*loginFailures)}
+}
+
+// reserve books a login attempt of addr, to be ended by fail or succeed.
+// If addr is locked out, or the attempts in flight could already take it
+// to the limit, nothing is booked and reserve returns how long to wait.
+func (g *loginGuard) reserve(addr string, now time.Time) time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	f, ok := g.byAddr[addr]
+	if !ok {
+		f = &loginFailures{}
+		g.byAddr[addr] = f
+	}
+	if wait := f.until.Sub(now); wait > 0 {
+		return wait
+	}
+	if f.inFlight > 0 && f.count+f.inFlight >= g.maxFailures {
+		// The attempts in flight decide whether this one would be over the
+		// limit; retry once they are done. Past a lockout, that is one
+		// attempt at a time.
+		return time.Second
+	}
+	f.inFlight++
+	return 0
+}
+
+// fail ends a failed attempt of addr and returns the lockout it starts, 0
+// if none.
+func (g *loginGuard) fail(addr string, now time.Time) time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for a, f := range g.byAddr {
+		if f.inFlight == 0 && now.Sub(f.last) > maxLoginLockout && now.After(f.until) {
+			delete(g.byAddr, a)
+		}
+	}
+	f, ok := g.byAddr[addr]
+	if !ok {
+		f = &loginFailures{}
+		g.byAddr[addr] = f
+	}
+	f.inFlight = max(f.inFlight-1, 0)
+	f.count++
+	f.last = now
+	if f.count < g.maxFailures {
+		return 0
+	}
+	d := maxLoginLockout
+	if n := f.count - g.maxFailures; n < 32 {
+		d = min(g.lockout<<n, maxLoginLockout)
+	}
+	f.until = now.Add(d)
+	return d
+}
+
+// succeed ends a successful attempt of addr and forgets its failures.
+func (g *loginGuard) succeed(addr string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	f, ok := g.byAddr[addr]
+	if !ok {
+		return
+	}
+	f.inFlight = max(f.inFlight-1, 0)
+	if f.inFlight == 0 {
+		delete(g.byAddr, addr)
+		return
+	}
+	f.count, f.until = 0, time.Time{}
+}
+
+func (g *loginGuard) lockedOut(now time.Time) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	n := 0
+	for _, f := range g.byAddr {
+		if now.Before(f.until) {
+			n++
+		}
+	}
+	return n
+}
+
+// apiCounters are the API's share of the stats.
+type apiCounters struct {
+	limited       atomic.Uint64
+	loginFailures atomic.Uint64
+	lockouts      atomic.Uint64
+}
+
+func (a *API) apiStats() stats.APIStats {
+	return stats.APIStats{
+		Limited:       a.counters.limited.Load(),
+		LoginFailures: a.counters.loginFailures.Load(),
+		Lockouts:      a.counters.lockouts.Load(),
+		LockedOut:     a.logins.lockedOut(time.Now()),
+	}
+}
+
+// clientAddr is the address limits apply to: the peer of the connection,
+// as forwarding headers are easily forged.
+func clientAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// limitRequests answers 429 to a client over api_rate_limit.
+func (a *API) limitRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.limiter.allow(clientAddr(r), time.Now()) {
+			a.counters.limited.Add(1)
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// tooManyLogins answers 429 to a client locked out of /api/login.
+func tooManyLogins(w http.ResponseWriter, wait time.Duration) {
+	secs := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+	http.Error(w, fmt.Sprintf("Too many failed logins, try again in %ds", secs), http.StatusTooManyRequests)
+}
+
+// loginFailed counts a failed login and locks the client out once it has
+// failed too often.
+func (a *API) loginFailed(addr, user string) {
+	a.counters.loginFailures.Add(1)
+	if d := a.logins.fail(addr, time.Now()); d > 0 {
+		a.counters.lockouts.Add(1)
+		logger.API.Warn("Login: %s locked out for %s after failing as %q", addr, d, user)
+	}
+}
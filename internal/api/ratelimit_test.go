@@ -0,0 +1,124 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for the request rate limit and the login lockout
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(2, 3)
+	now := time.Unix(1000, 0)
+	for i := range 3 {
+		if !l.allow("10.0.0.1", now) {
+			t.Fatalf("Expected request %d of the burst allowed", i+1)
+		}
+	}
+	if l.allow("10.0.0.1", now) {
+		t.Error("Expected a request over the burst refused")
+	}
+	if !l.allow("10.0.0.2", now) {
+		t.Error("Expected other addresses limited apart")
+	}
+	if !l.allow("10.0.0.1", now.Add(500*time.Millisecond)) || l.allow("10.0.0.1", now.Add(500*time.Millisecond)) {
+		t.Error("Expected one request back after half a second at 2/s")
+	}
+	if off := newRateLimiter(0, 0); !off.allow("10.0.0.1", now) {
+		t.Error("Expected a rate of 0 to allow everything")
+	}
+}
+
+func TestLoginGuard(t *testing.T) {
+	g := newLoginGuard(2, time.Minute)
+	now := time.Unix(1000, 0)
+	attempt := func(ok bool) time.Duration {
+		if wait := g.reserve("10.0.0.1", now); wait > 0 {
+			return wait
+		}
+		if ok {
+			g.succeed("10.0.0.1")
+			return 0
+		}
+		return g.fail("10.0.0.1", now)
+	}
+
+	if attempt(false) != 0 || attempt(true) != 0 {
+		t.Fatal("Expected no lockout below the limit")
+	}
+	if attempt(false) != 0 {
+		t.Fatal("Expected a success to forget the failures before")
+	}
+	if d := attempt(false); d != time.Minute {
+		t.Fatalf("Expected a minute's lockout at the limit, got %v", d)
+	}
+	if wait := g.reserve("10.0.0.1", now.Add(10*time.Second)); wait != 50*time.Second {
+		t.Errorf("Expected 50s left of the lockout, got %v", wait)
+	}
+	now = now.Add(time.Minute)
+	if d := attempt(false); d != 2*time.Minute {
+		t.Errorf("Expected the lockout doubled, got %v", d)
+	}
+	if g.lockedOut(now) != 1 {
+		t.Errorf("Expected one address locked out, got %d", g.lockedOut(now))
+	}
+}
+
+func TestLoginGuardConcurrent(t *testing.T) {
+	g := newLoginGuard(5, time.Minute)
+	now := time.Unix(1000, 0)
+	var mu sync.Mutex
+	reserved := 0
+	var wg sync.WaitGroup
+	for range 60 {
+		wg.Go(func() {
+			if g.reserve("10.0.0.1", now) == 0 {
+				mu.Lock()
+				reserved++
+				mu.Unlock()
+			}
+		})
+	}
+	wg.Wait()
+	if reserved != 5 {
+		t.Errorf("Expected 5 attempts let through at once, got %d", reserved)
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.LoginMaxFailures = 3
+	a := newTestAPI(t, cfg)
+
+	// Attempts sent together are all answered, but only as many are
+	// checked as the lockout allows.
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	codes := map[int]int{}
+	for range 20 {
+		wg.Go(func() {
+			r := httptest.NewRequest(http.MethodPost, "/api/login", strings.NewReader(`{"user":"admin","pass":"wrong"}`))
+			rec := serve(a, r)
+			mu.Lock()
+			codes[rec.Code]++
+			mu.Unlock()
+		})
+	}
+	wg.Wait()
+	if codes[http.StatusOK] > cfg.LoginMaxFailures || codes[http.StatusOK]+codes[http.StatusTooManyRequests] != 20 {
+		t.Errorf("Expected at most %d attempts checked, the others refused, got %v", cfg.LoginMaxFailures, codes)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/api/login", strings.NewReader(`{"user":"admin","pass":"admin"}`))
+	if rec := serve(a, r); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected the right password refused while locked out, got %d", rec.Code)
+	}
+}
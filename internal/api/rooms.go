@@ -0,0 +1,96 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// HTTP API for rooms and the public room tracker
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/rooms"
+)
+
+// roomHandler manages this node's room:
+//
+//	GET   the current room, its members and an invite token
+//	POST  {"action": "create", "name": ..., "public": ...}
+//	      {"action": "join", "token": ...}
+//	      {"action": "leave"}
+func (a *API) roomHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"room":   a.statsFunc().Room,
+			"invite": a.srv.RoomInvite(),
+		})
+
+	case http.MethodPost:
+		var req struct {
+			Action string `json:"action"`
+			Name   string `json:"name"`
+			Public bool   `json:"public"`
+			Token  string `json:"token"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+		switch req.Action {
+		case "create":
+			invite, err := a.srv.CreateRoom(req.Name, req.Public)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]any{"success": true, "invite": invite})
+			return
+		case "join":
+			if err := a.srv.JoinRoom(req.Token); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		case "leave":
+			a.srv.LeaveRoom()
+		default:
+			http.Error(w, "Unknown action", http.StatusBadRequest)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"success": true})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// trackerHandler serves the public room tracker. It is unauthenticated:
+// any node may announce a public room and anyone may list them.
+//
+//	GET   list public rooms with invite tokens
+//	POST  announce a room (rooms.Listing)
+func (a *API) trackerHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		_ = json.NewEncoder(w).Encode(a.tracker.List(time.Now()))
+
+	case http.MethodPost:
+		var l rooms.Listing
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16<<10)).Decode(&l); err != nil {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+		if err := a.tracker.Announce(l, time.Now()); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"success": true})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,101 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// HTTP API for filter and priority rules
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/mlapointe/ipxtransporter/internal/rules"
+)
+
+// rulesHandler serves CRUD for one rule kind:
+//
+//	GET    list rules in evaluation order
+//	POST   create a rule (?dry_run=1 only counts matches against recent traffic)
+//	PUT    replace the rule with the given id
+//	DELETE remove the rule given by ?id=
+func (a *API) rulesHandler(kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		engine := a.srv.Rules()
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.Method {
+		case http.MethodGet:
+			list, err := engine.List(kind)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(list)
+
+		case http.MethodPost:
+			var req rules.Rule
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Bad request", http.StatusBadRequest)
+				return
+			}
+			if r.URL.Query().Get("dry_run") != "" {
+				matches, samples, err := a.srv.DryRunRule(kind, req)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				_ = json.NewEncoder(w).Encode(map[string]any{
+					"success": true,
+					"matches": matches,
+					"samples": samples,
+				})
+				return
+			}
+			rule, err := engine.Add(kind, req)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]any{"success": true, "rule": rule})
+
+		case http.MethodPut:
+			var req rules.Rule
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Bad request", http.StatusBadRequest)
+				return
+			}
+			if err := engine.Update(kind, req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]any{"success": true})
+
+		case http.MethodDelete:
+			if err := engine.Delete(kind, r.URL.Query().Get("id")); err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]any{"success": true})
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func (a *API) moveRuleHandler(kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID    string `json:"id"`
+			Index int    `json:"index"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+		if err := a.srv.Rules().Move(kind, req.ID, req.Index); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"success": true})
+	}
+}
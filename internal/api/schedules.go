@@ -0,0 +1,57 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// HTTP API for scheduled bans and peer access windows
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/schedule"
+)
+
+// schedulesHandler serves the schedule list:
+//
+//	GET    list entries with their state and next transition
+//	POST   add an entry
+//	DELETE remove the entry given by ?id=
+func (a *API) schedulesHandler(w http.ResponseWriter, r *http.Request) {
+	sched := a.srv.Schedule()
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		_ = json.NewEncoder(w).Encode(a.statsFunc().Schedules)
+
+	case http.MethodPost:
+		var req struct {
+			schedule.Entry
+			Hours int `json:"hours"` // alternative to until: expire this many hours from now
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+		if req.Hours > 0 {
+			req.Until = time.Now().Add(time.Duration(req.Hours) * time.Hour)
+		}
+		e, err := sched.Add(req.Entry)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"success": true, "entry": e})
+
+	case http.MethodDelete:
+		if err := sched.Delete(r.URL.Query().Get("id")); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"success": true})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
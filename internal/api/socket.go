@@ -0,0 +1,103 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Control API on a unix socket, guarded by filesystem permissions
+
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+)
+
+type localConnKey struct{}
+
+// isLocal reports whether the request came in on the control socket.
+func isLocal(r *http.Request) bool {
+	local, _ := r.Context().Value(localConnKey{}).(bool)
+	return local
+}
+
+// localClaims stand in for a token on the control socket: whoever may open
+// the socket acts as admin_user.
+func (a *API) localClaims() *tokenClaims {
+	now := time.Now()
+	user, _ := a.cfg.AdminCredentials()
+	return &tokenClaims{
+		User:     user,
+		Role:     config.RoleAdmin,
+		AuthTime: now.Unix(),
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(a.tokenTTL())),
+		},
+	}
+}
+
+// ListenAndServeUnix serves the HTTP API on the unix socket at path until
+// ctx is done, without tokens or rate limits: the socket's permissions,
+// control_socket_mode, decide who may use it. The socket is removed on
+// return.
+func (a *API) ListenAndServeUnix(ctx context.Context, path string) error {
+	mode, err := strconv.ParseUint(a.cfg.ControlSocketMode, 8, 32)
+	if err != nil || mode&^0o777 != 0 {
+		return fmt.Errorf("control_socket_mode %q is not an octal file mode", a.cfg.ControlSocketMode)
+	}
+	if err := removeStaleSocket(path); err != nil {
+		return err
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(path)
+	if err := os.Chmod(path, os.FileMode(mode)); err != nil {
+		l.Close()
+		return err
+	}
+
+	srv := &http.Server{
+		Handler: a.routes(),
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			return context.WithValue(ctx, localConnKey{}, true)
+		},
+	}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	logger.API.Info("Control socket listening on %s", path)
+	if err := srv.Serve(l); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// removeStaleSocket clears a socket left by a daemon that did not shut
+// down, but not one a running daemon still answers on, nor another file.
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("control_socket %s exists and is not a socket", path)
+	}
+	if c, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		c.Close()
+		return fmt.Errorf("control_socket %s is in use by another process", path)
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,132 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Tests for the control socket: callers act as admin without a token
+
+package api
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+)
+
+// serveUnix serves a on a control socket in a temporary directory until
+// the test ends, returning the socket's path and a client for it.
+func serveUnix(t *testing.T, a *API) (string, *http.Client) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ctl.sock")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- a.ListenAndServeUnix(ctx, path) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	})
+	for i := 0; ; i++ {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+		if i == 100 {
+			t.Fatal("The control socket did not come up")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	t.Cleanup(client.CloseIdleConnections)
+	return path, client
+}
+
+func TestControlSocketAdmin(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.ControlSocketMode = "0600"
+	a := newTestAPI(t, cfg)
+	path, client := serveUnix(t, a)
+
+	tests := []struct {
+		method, path, body string
+		want               int
+	}{
+		{"GET", "/api/config", "", http.StatusOK},
+		{"GET", "/api/bans", "", http.StatusOK},
+		{"POST", "/api/bans/remove", `{"target": "10.0.0.1"}`, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest(tt.method, "http://ctl"+tt.path, strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s %s: expected %d without a token, got %d", tt.method, tt.path, tt.want, resp.StatusCode)
+		}
+	}
+
+	// The socket answers only once its mode is set.
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0o600 {
+		t.Errorf("Expected the socket to have mode 0600, got %o", fi.Mode().Perm())
+	}
+
+	// The same routes off the socket still want a token.
+	if rec := serve(a, withToken("GET", "/api/config", "", "")); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a request off the socket to need a token, got %d", rec.Code)
+	}
+}
+
+func TestControlSocketStale(t *testing.T) {
+	dir := t.TempDir()
+
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := removeStaleSocket(file); err == nil {
+		t.Error("Expected a file that is not a socket to be left alone")
+	}
+
+	stale := filepath.Join(dir, "stale.sock")
+	l, err := net.Listen("unix", stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := removeStaleSocket(stale); err == nil {
+		t.Error("Expected a socket in use to be left alone")
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+	if err := removeStaleSocket(stale); err != nil {
+		t.Errorf("Expected a stale socket to be removed, got %v", err)
+	}
+	if _, err := os.Lstat(stale); !os.IsNotExist(err) {
+		t.Error("Expected the stale socket to be gone")
+	}
+}
+
+func TestControlSocketMode(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.ControlSocketMode = "rw-rw----"
+	a := newTestAPI(t, cfg)
+	if err := a.ListenAndServeUnix(context.Background(), filepath.Join(t.TempDir(), "ctl.sock")); err == nil {
+		t.Error("Expected a mode that is not octal to be refused")
+	}
+}
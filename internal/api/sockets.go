@@ -0,0 +1,49 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// HTTP API for the socket name database
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/mlapointe/ipxtransporter/internal/ipx"
+)
+
+// socketsHandler manages the names of IPX sockets:
+//
+//	GET   the named sockets, built in and set by users
+//	POST  {"socket": "0x5A00", "name": ...} names a socket, over its
+//	      built-in name if it has one, and saves it to the config; an
+//	      empty name removes the one a user set
+func (a *API) socketsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		_ = json.NewEncoder(w).Encode(map[string]any{"sockets": a.srv.SocketNames()})
+
+	case http.MethodPost:
+		var req struct {
+			Socket string `json:"socket"`
+			Name   string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+		socket, err := ipx.ParseSocketNumber(req.Socket)
+		if err == nil {
+			err = a.srv.NameSocket(socket, req.Name)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"success": true})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
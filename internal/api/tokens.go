@@ -0,0 +1,271 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// API tokens: issue, refresh and revoke
+
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+)
+
+// tokenClaims are the claims of an API token. AuthTime is when the user
+// logged in; refreshing keeps it, so a session cannot outlive session_ttl.
+// Auth is the backend the user logged in with, empty for local users.
+type tokenClaims struct {
+	User     string `json:"user"`
+	Role     string `json:"role"`
+	Auth     string `json:"auth,omitempty"`
+	AuthTime int64  `json:"auth_time"`
+	jwt.RegisteredClaims
+}
+
+// backend is the authentication backend the claims come from.
+func (c *tokenClaims) backend() string {
+	if c.Auth == "" {
+		return config.AuthLocal
+	}
+	return c.Auth
+}
+
+type claimsKey struct{}
+
+// claimsFrom returns the claims withAuth verified for the request.
+func claimsFrom(r *http.Request) *tokenClaims {
+	c, _ := r.Context().Value(claimsKey{}).(*tokenClaims)
+	return c
+}
+
+// NewToken issues an admin token valid for ttl, or until it is revoked or
+// the admin credentials or JWT secret change.
+func NewToken(cfg *config.Config, ttl time.Duration) (string, error) {
+	user, _ := cfg.AdminCredentials()
+	return issueToken(cfg, user, ttl, time.Now())
+}
+
+func issueToken(cfg *config.Config, user string, ttl time.Duration, authTime time.Time) (string, error) {
+	key, role, ok := cfg.UserTokenKey(user)
+	if !ok {
+		return "", fmt.Errorf("no API user %q", user)
+	}
+	return signToken(key, tokenClaims{User: user, Role: role, AuthTime: authTime.Unix()}, ttl)
+}
+
+// issueOIDCToken issues a token to a user who logged in at the OIDC
+// provider with role.
+func issueOIDCToken(cfg *config.Config, user, role string, ttl time.Duration, authTime time.Time) (string, error) {
+	claims := tokenClaims{User: user, Role: role, Auth: config.AuthOIDC, AuthTime: authTime.Unix()}
+	return signToken(cfg.OIDCTokenKey(user, role), claims, ttl)
+}
+
+func signToken(key []byte, claims tokenClaims, ttl time.Duration) (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ID:        hex.EncodeToString(id),
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(key)
+}
+
+// reissue issues a new token to the caller c, for a refresh or after the
+// JWT secret changed.
+func (a *API) reissue(c *tokenClaims, ttl time.Duration, authTime time.Time) (string, error) {
+	switch c.backend() {
+	case config.AuthLocal:
+		return issueToken(a.cfg, c.User, ttl, authTime)
+	case config.AuthOIDC:
+		return issueOIDCToken(a.cfg, c.User, c.Role, ttl, authTime)
+	}
+	return "", fmt.Errorf("%s callers need no token", c.Auth)
+}
+
+// tokenTTL is the lifetime of a new token.
+func (a *API) tokenTTL() time.Duration {
+	return time.Duration(a.cfg.TokenTTL) * time.Second
+}
+
+// writeToken answers with a new token, the user's role and the token's
+// lifetime in seconds, so clients know when to refresh it.
+func writeToken(w http.ResponseWriter, token, role string, ttl time.Duration) {
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"success":    true,
+		"token":      token,
+		"role":       role,
+		"expires_in": int(ttl / time.Second),
+	})
+}
+
+// revokedTokens is the denylist of token IDs logged out or refreshed before
+// they expired. An entry is kept until its token would have expired anyway.
+type revokedTokens struct {
+	mu  sync.Mutex
+	ids map[string]time.Time // token ID -> expiry
+}
+
+func newRevokedTokens() *revokedTokens {
+	return &revokedTokens{ids: make(map[string]time.Time)}
+}
+
+func (rt *revokedTokens) revoke(id string, exp time.Time) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	now := time.Now()
+	for old, e := range rt.ids {
+		if now.After(e) {
+			delete(rt.ids, old)
+		}
+	}
+	rt.ids[id] = exp
+}
+
+func (rt *revokedTokens) isRevoked(id string) bool {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	_, ok := rt.ids[id]
+	return ok
+}
+
+// verifyToken checks the signature, expiry and denylist of a token. The
+// key depends on the user, so the role claimed is the one the key was
+// derived with.
+func (a *API) verifyToken(tokenStr string) (*tokenClaims, bool) {
+	claims := &tokenClaims{}
+	var role string
+	token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (any, error) {
+		c := token.Claims.(*tokenClaims)
+		switch c.backend() {
+		case config.AuthLocal:
+			key, r, ok := a.cfg.UserTokenKey(c.User)
+			if !ok {
+				return nil, errors.New("unknown user")
+			}
+			role = r
+			return key, nil
+		case config.AuthOIDC:
+			// The role is part of the key, so it cannot be changed.
+			role = c.Role
+			return a.cfg.OIDCTokenKey(c.User, c.Role), nil
+		}
+		return nil, errors.New("unknown backend")
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil || !token.Valid || claims.ID == "" || a.revoked.isRevoked(claims.ID) {
+		return nil, false
+	}
+	claims.Role = role
+	return claims, true
+}
+
+// withAuth lets a request through if its caller, as authenticate finds
+// them, has a role that allows need, or it came in on the control socket.
+func (a *API) withAuth(need string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if isLocal(r) {
+			next.ServeHTTP(w, withClaims(r, a.localClaims()))
+			return
+		}
+		claims, ok := a.authenticate(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !config.RoleAllows(claims.Role, need) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, withClaims(r, claims))
+	}
+}
+
+// withReadAuth lets viewers read through a handler and admins change
+// things through it.
+func (a *API) withReadAuth(next http.HandlerFunc) http.HandlerFunc {
+	viewer, admin := a.withAuth(config.RoleViewer, next), a.withAuth(config.RoleAdmin, next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			viewer(w, r)
+			return
+		}
+		admin(w, r)
+	}
+}
+
+func withClaims(r *http.Request, c *tokenClaims) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), claimsKey{}, c))
+}
+
+// refreshHandler trades a valid token for a new one, up to session_ttl after
+// the login. The old token is revoked.
+func (a *API) refreshHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	c := claimsFrom(r)
+	authTime := time.Unix(c.AuthTime, 0)
+	left := time.Until(authTime.Add(time.Duration(a.cfg.SessionTTL) * time.Second))
+	if left <= 0 {
+		http.Error(w, "Session expired, log in again", http.StatusUnauthorized)
+		return
+	}
+	ttl := min(a.tokenTTL(), left)
+	token, err := a.reissue(c, ttl, authTime)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	a.revoked.revoke(c.ID, c.ExpiresAt.Time)
+	writeToken(w, token, c.Role, ttl)
+}
+
+// logoutHandler revokes the token the request carries.
+func (a *API) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	c := claimsFrom(r)
+	a.revoked.revoke(c.ID, c.ExpiresAt.Time)
+	_ = json.NewEncoder(w).Encode(map[string]any{"success": true})
+}
+
+// rotateSecretHandler replaces the JWT secret, revoking every token issued
+// so far, and returns a new token for the caller unless they hold a client
+// certificate rather than a token.
+func (a *API) rotateSecretHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := a.srv.RotateJWTSecret(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	c := claimsFrom(r)
+	logger.API.Info("JWT secret rotated by %s, existing tokens revoked", c.User)
+	if c.backend() == config.AuthClientCert {
+		_ = json.NewEncoder(w).Encode(map[string]any{"success": true})
+		return
+	}
+	token, err := a.reissue(c, a.tokenTTL(), time.Now())
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeToken(w, token, c.Role, a.tokenTTL())
+}
@@ -0,0 +1,218 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Tests for the roles, refresh and revocation of API tokens
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+// withToken is a request with tok as its bearer token, if any.
+func withToken(method, path, body, tok string) *http.Request {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if tok != "" {
+		r.Header.Set("Authorization", "Bearer "+tok)
+	}
+	return r
+}
+
+func newRolesAPI(t *testing.T) (*API, string, string) {
+	t.Helper()
+	cfg := config.DefaultConfig()
+	cfg.APIUsers = []config.APIUser{{Name: "status", Pass: "look", Role: config.RoleViewer}}
+	a := newTestAPI(t, cfg)
+	viewer, err := issueToken(cfg, "status", time.Minute, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, err := NewToken(cfg, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a, viewer, admin
+}
+
+func TestRoleGating(t *testing.T) {
+	a, viewer, admin := newRolesAPI(t)
+	tests := []struct {
+		name         string
+		method, path string
+		body         string
+		token        string
+		want         int
+	}{
+		{"read without a token", "GET", "/api/bans", "", "", http.StatusUnauthorized},
+		{"read with a bad token", "GET", "/api/bans", "", "not-a-token", http.StatusUnauthorized},
+		{"viewer reads", "GET", "/api/bans", "", viewer, http.StatusOK},
+		{"admin reads", "GET", "/api/bans", "", admin, http.StatusOK},
+		{"viewer writes through a read handler", "POST", "/api/allowlist", `{}`, viewer, http.StatusForbidden},
+		{"viewer unbans", "POST", "/api/bans/remove", `{"target": "10.0.0.1"}`, viewer, http.StatusForbidden},
+		{"admin unbans what is not banned", "POST", "/api/bans/remove", `{"target": "10.0.0.1"}`, admin, http.StatusNotFound},
+		{"viewer reads the config", "GET", "/api/config", "", viewer, http.StatusForbidden},
+		{"admin reads the config", "GET", "/api/config", "", admin, http.StatusOK},
+		{"viewer acts", "POST", "/api/action", `{}`, viewer, http.StatusForbidden},
+		{"viewer exports", "GET", "/api/peers.csv", "", viewer, http.StatusOK},
+		{"export without a token", "GET", "/api/peers.csv", "", "", http.StatusUnauthorized},
+		{"hosts without a token", "GET", "/api/hosts", "", "", http.StatusUnauthorized},
+		{"viewer lists the hosts", "GET", "/api/hosts", "", viewer, http.StatusOK},
+		{"history without a token", "GET", "/api/history", "", "", http.StatusUnauthorized},
+		{"viewer reads the history", "GET", "/api/history?range=6h", "", viewer, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(a, withToken(tt.method, tt.path, tt.body, tt.token))
+			if rec.Code != tt.want {
+				t.Errorf("Expected %d, got %d: %s", tt.want, rec.Code, rec.Body)
+			}
+		})
+	}
+
+	// /stats is open, but the host inventory in it is for viewers.
+	a.statsFunc = func() stats.Stats {
+		return stats.Stats{Hosts: []stats.IPXHost{{Node: "00:11:22:33:44:55"}}}
+	}
+	for _, tt := range []struct {
+		name  string
+		token string
+		hosts int
+	}{
+		{"stats without a token", "", 0},
+		{"stats with a bad token", "not-a-token", 0},
+		{"viewer reads the stats", viewer, 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(a, withToken("GET", "/stats", "", tt.token))
+			var s stats.Stats
+			if err := json.NewDecoder(rec.Body).Decode(&s); err != nil {
+				t.Fatal(err)
+			}
+			if len(s.Hosts) != tt.hosts {
+				t.Errorf("Expected %d hosts, got %d", tt.hosts, len(s.Hosts))
+			}
+		})
+	}
+}
+
+func TestRoleFromKey(t *testing.T) {
+	a, viewer, _ := newRolesAPI(t)
+	// A viewer whose role is raised to admin later has their old token
+	// rejected rather than trusted with the new role.
+	a.cfg.APIUsers[0].Role = config.RoleAdmin
+	if rec := serve(a, withToken("GET", "/api/bans", "", viewer)); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a token issued under another role to be refused, got %d", rec.Code)
+	}
+	// So is the token of a user whose password changed.
+	a, viewer, _ = newRolesAPI(t)
+	a.cfg.APIUsers[0].Pass = "other"
+	if rec := serve(a, withToken("GET", "/api/bans", "", viewer)); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a token issued before a password change to be refused, got %d", rec.Code)
+	}
+}
+
+// refresh trades tok for a new token, returning it and the status.
+func refresh(t *testing.T, a *API, tok string) (string, int) {
+	t.Helper()
+	rec := serve(a, withToken("POST", "/api/refresh", "", tok))
+	var out struct {
+		Token string `json:"token"`
+		Role  string `json:"role"`
+	}
+	if rec.Code == http.StatusOK {
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return out.Token, rec.Code
+}
+
+func TestRefreshRevokesOld(t *testing.T) {
+	a, viewer, _ := newRolesAPI(t)
+	fresh, code := refresh(t, a, viewer)
+	if code != http.StatusOK || fresh == "" {
+		t.Fatalf("Expected a refresh to give a token, got %d", code)
+	}
+	if rec := serve(a, withToken("GET", "/api/bans", "", viewer)); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the refreshed token to be revoked, got %d", rec.Code)
+	}
+	if _, code := refresh(t, a, viewer); code != http.StatusUnauthorized {
+		t.Errorf("Expected the refreshed token not to refresh again, got %d", code)
+	}
+	if rec := serve(a, withToken("GET", "/api/config", "", fresh)); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected the new token to keep the viewer role, got %d", rec.Code)
+	}
+	if rec := serve(a, withToken("GET", "/api/bans", "", fresh)); rec.Code != http.StatusOK {
+		t.Errorf("Expected the new token to work, got %d", rec.Code)
+	}
+}
+
+func TestRefreshSessionTTL(t *testing.T) {
+	a, _, _ := newRolesAPI(t)
+	a.cfg.SessionTTL = 3600
+
+	late, err := issueToken(a.cfg, "status", time.Minute, time.Now().Add(-2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, code := refresh(t, a, late); code != http.StatusUnauthorized {
+		t.Errorf("Expected a refresh past session_ttl to be refused, got %d", code)
+	}
+
+	// Near the end of the session the new token lasts only what is left,
+	// and keeps the time of the login.
+	login := time.Now().Add(-3590 * time.Second)
+	near, err := issueToken(a.cfg, "status", time.Minute, login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fresh, code := refresh(t, a, near)
+	if code != http.StatusOK {
+		t.Fatalf("Expected a refresh within session_ttl to work, got %d", code)
+	}
+	c, ok := a.verifyToken(fresh)
+	if !ok {
+		t.Fatal("Expected the new token to verify")
+	}
+	if c.AuthTime != login.Unix() {
+		t.Errorf("Expected the login time %d to be kept, got %d", login.Unix(), c.AuthTime)
+	}
+	if end := login.Add(time.Hour); c.ExpiresAt.After(end.Add(time.Second)) {
+		t.Errorf("Expected the new token to expire by %v, got %v", end, c.ExpiresAt.Time)
+	}
+}
+
+func TestLogoutRevokes(t *testing.T) {
+	a, viewer, admin := newRolesAPI(t)
+	if rec := serve(a, withToken("POST", "/api/logout", "", viewer)); rec.Code != http.StatusOK {
+		t.Fatalf("Expected a logout to work, got %d", rec.Code)
+	}
+	if rec := serve(a, withToken("GET", "/api/bans", "", viewer)); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a logged out token to be refused, got %d", rec.Code)
+	}
+	if rec := serve(a, withToken("GET", "/api/bans", "", admin)); rec.Code != http.StatusOK {
+		t.Errorf("Expected other tokens to keep working, got %d", rec.Code)
+	}
+	if rec := serve(a, withToken("GET", "/api/logout", "", admin)); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected a GET logout to be refused, got %d", rec.Code)
+	}
+}
+
+func TestRevokedPruned(t *testing.T) {
+	rt := newRevokedTokens()
+	rt.revoke("old", time.Now().Add(-time.Second))
+	rt.revoke("new", time.Now().Add(time.Minute))
+	if rt.isRevoked("old") {
+		t.Error("Expected an entry past its token's expiry to be pruned")
+	}
+	if !rt.isRevoked("new") {
+		t.Error("Expected a live entry to be kept")
+	}
+}
@@ -0,0 +1,48 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// HTTP API for relay traces
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+)
+
+// traceHandler manages packet provenance tracing:
+//
+//	GET   the traced flows and the traced packets received lately
+//	POST  {"flows": [{"socket": ..., "node": ...}, ...]} replaces the
+//	      traced flows until the next restart; [] stops tracing
+func (a *API) traceHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"flows":  a.srv.TraceFlows(),
+			"traces": a.srv.Traces(),
+		})
+
+	case http.MethodPost:
+		var req struct {
+			Flows []config.TraceFlow `json:"flows"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+		if err := a.srv.SetTraceFlows(req.Flows); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		logger.API.Info("Tracing %d flows, set by %s", len(req.Flows), claimsFrom(r).User)
+		_ = json.NewEncoder(w).Encode(map[string]any{"success": true})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,25 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Web dashboard served from the binary
+
+package api
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed web
+var webFS embed.FS
+
+// dashboardHandler serves the single-page dashboard under /ui/. The page
+// itself is static; everything it shows comes from /stats, /api/history and
+// the JWT-protected action endpoints.
+func dashboardHandler() http.Handler {
+	web, err := fs.Sub(webFS, "web")
+	if err != nil {
+		panic(err) // the directory is embedded above
+	}
+	return http.StripPrefix("/ui/", http.FileServer(http.FS(web)))
+}
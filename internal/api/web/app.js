@@ -0,0 +1,465 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Web dashboard: views, polling and admin actions
+
+'use strict';
+
+const pollInterval = 2000;
+const tokenKey = 'ipx_jwt_token'; // shared with /stats.html
+
+let stats = null;
+let traffic = [];  // {time, rx, tx, dropped} in packets/s, oldest first
+let lastTotals = null;
+let sortKey = 'id';
+let sortAsc = true;
+
+// ---- helpers ----
+
+function $(id) {
+    return document.getElementById(id);
+}
+
+function el(tag, props, ...children) {
+    const e = document.createElement(tag);
+    Object.assign(e, props || {});
+    e.append(...children);
+    return e;
+}
+
+function svg(tag, attrs) {
+    const e = document.createElementNS('http://www.w3.org/2000/svg', tag);
+    for (const [k, v] of Object.entries(attrs || {})) e.setAttribute(k, v);
+    return e;
+}
+
+function formatBytes(b) {
+    if (b < 1024) return b + ' B';
+    if (b < 1024 * 1024) return (b / 1024).toFixed(1) + ' KB';
+    if (b < 1024 * 1024 * 1024) return (b / (1024 * 1024)).toFixed(1) + ' MB';
+    return (b / (1024 * 1024 * 1024)).toFixed(2) + ' GB';
+}
+
+function formatSince(dateStr) {
+    const secs = Math.max(0, Math.round((Date.now() - Date.parse(dateStr)) / 1000));
+    if (secs < 60) return secs + 's';
+    if (secs < 3600) return Math.floor(secs / 60) + 'm';
+    if (secs < 86400) return Math.floor(secs / 3600) + 'h ' + Math.floor(secs % 3600 / 60) + 'm';
+    return Math.floor(secs / 86400) + 'd ' + Math.floor(secs % 86400 / 3600) + 'h';
+}
+
+function toast(message, type) {
+    const t = $('toast');
+    t.textContent = message;
+    t.className = type;
+    clearTimeout(toast.timer);
+    toast.timer = setTimeout(() => { t.className = ''; }, 4000);
+}
+
+// ---- auth ----
+
+function token() {
+    return localStorage.getItem(tokenKey);
+}
+
+// tokenClaims decodes the claims of a token; the server checks them.
+function tokenClaims(tok) {
+    try {
+        const payload = tok.split('.')[1].replace(/-/g, '+').replace(/_/g, '/');
+        return JSON.parse(atob(payload));
+    } catch (e) {
+        return {};
+    }
+}
+
+// tokenExpiry returns when a token expires, in milliseconds since the epoch.
+function tokenExpiry(tok) {
+    return (tokenClaims(tok).exp || 0) * 1000;
+}
+
+// Tokens are short-lived: trade them for a new one a minute before they
+// expire, until the server ends the session.
+let refreshTimer = null;
+
+function scheduleRefresh(tok) {
+    clearTimeout(refreshTimer);
+    if (!tok) return;
+    const wait = tokenExpiry(tok) - Date.now() - 60000;
+    refreshTimer = setTimeout(async () => {
+        try {
+            const res = await post('/api/refresh', {});
+            setToken(res.token);
+        } catch (e) {
+            setToken(null);
+            toast('Session expired, log in again', 'error');
+        }
+    }, Math.max(wait, 0));
+}
+
+function setToken(tok) {
+    if (tok) {
+        localStorage.setItem(tokenKey, tok);
+    } else {
+        localStorage.removeItem(tokenKey);
+    }
+    scheduleRefresh(tok);
+    // Viewers are logged in too, but only admins get the buttons.
+    const claims = tok ? tokenClaims(tok) : {};
+    document.body.classList.toggle('admin', claims.role === 'admin');
+    $('login-btn').style.display = tok ? 'none' : 'inline';
+    $('admin-status').style.display = tok ? 'inline' : 'none';
+    $('who').textContent = tok ? claims.user + ' (' + claims.role + ')' : '';
+    if (stats) renderPeers();
+}
+
+// post sends an admin request, logging out when the token is refused.
+async function post(url, body) {
+    const resp = await fetch(url, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json', 'Authorization': 'Bearer ' + token() },
+        body: JSON.stringify(body)
+    });
+    if (resp.status === 401) {
+        setToken(null);
+        throw new Error('login expired');
+    }
+    if (!resp.ok) throw new Error((await resp.text()).trim() || resp.statusText);
+    return resp.json();
+}
+
+$('login-btn').onclick = () => {
+    $('login-modal').style.display = 'block';
+    $('username').focus();
+};
+document.querySelectorAll('.modal-close').forEach(b => {
+    b.onclick = () => { b.closest('.modal').style.display = 'none'; };
+});
+$('do-login').onclick = async () => {
+    const resp = await fetch('/api/login', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ user: $('username').value, pass: $('password').value })
+    });
+    if (resp.status === 429) {
+        toast(await resp.text(), 'error');
+        return;
+    }
+    const res = await resp.json();
+    if (!res.success) {
+        toast('Login failed', 'error');
+        return;
+    }
+    $('password').value = '';
+    $('login-modal').style.display = 'none';
+    setToken(res.token);
+    loadHistory();
+};
+$('password').onkeydown = e => { if (e.key === 'Enter') $('do-login').click(); };
+$('sso-login').onclick = () => { location.href = '/api/oidc/login'; };
+
+// loadAuth offers the logins this listener accepts: passwords, single
+// sign-on at the OIDC provider, or both.
+async function loadAuth() {
+    try {
+        const auth = await (await fetch('/api/auth')).json();
+        const local = auth.backends.includes('local');
+        ['username', 'password', 'do-login'].forEach(id => { $(id).style.display = local ? '' : 'none'; });
+        $('sso-login').style.display = auth.oidc ? '' : 'none';
+    } catch (e) {
+        console.error('Failed to load the login methods', e);
+    }
+}
+$('logout-btn').onclick = async () => {
+    try {
+        await post('/api/logout', {});
+    } catch (e) {
+        // Already expired or revoked.
+    }
+    setToken(null);
+};
+
+// ---- views ----
+
+function route() {
+    const view = location.hash.slice(1) || 'overview';
+    document.querySelectorAll('.view').forEach(v => v.classList.toggle('active', v.id === view));
+    document.querySelectorAll('nav a').forEach(a => a.classList.toggle('active', a.hash === '#' + view));
+    render();
+}
+
+window.addEventListener('hashchange', route);
+
+function render() {
+    if (!stats) return;
+    renderOverview();
+    renderPeers();
+    renderTopology();
+    renderMap();
+}
+
+// ---- overview and traffic graph ----
+
+function renderOverview() {
+    $('total-received').textContent = stats.total_received;
+    $('total-forwarded').textContent = stats.total_forwarded;
+    $('total-dropped').textContent = stats.total_dropped;
+    $('total-errors').textContent = stats.total_errors;
+    $('peer-count').textContent = stats.peer_count;
+    $('uptime').textContent = stats.uptime_str;
+    drawTraffic();
+}
+
+// rangeMs is the span of the traffic graph.
+function rangeMs() {
+    const v = $('history-range').value;
+    const n = parseInt(v);
+    return n * (v.endsWith('h') ? 3600e3 : 60e3);
+}
+
+// loadHistory fills the graph from the server's history, so it is not empty
+// after a reload; live samples are appended to it from then on. The history
+// needs a login; until then the graph starts empty.
+async function loadHistory() {
+    try {
+        const headers = token() ? { 'Authorization': 'Bearer ' + token() } : {};
+        const resp = await fetch('/api/history?range=' + $('history-range').value, { headers });
+        if (!resp.ok) return;
+        const data = await resp.json();
+        traffic = (data.points || []).map(p => ({ time: Date.parse(p.time), rx: p.rx, tx: p.tx, dropped: p.dropped }));
+        drawTraffic();
+    } catch (e) {
+        console.error('Failed to load history', e);
+    }
+}
+
+$('history-range').onchange = loadHistory;
+
+// sampleTraffic turns the totals of two polls into a live rate sample.
+function sampleTraffic(s) {
+    const now = Date.now();
+    const totals = { time: now, rx: s.total_received, tx: s.total_forwarded, dropped: s.total_dropped };
+    if (lastTotals && totals.rx >= lastTotals.rx) {
+        const secs = (now - lastTotals.time) / 1000;
+        traffic.push({
+            time: now,
+            rx: (totals.rx - lastTotals.rx) / secs,
+            tx: (totals.tx - lastTotals.tx) / secs,
+            dropped: (totals.dropped - lastTotals.dropped) / secs
+        });
+        const cutoff = now - rangeMs();
+        while (traffic.length && traffic[0].time < cutoff) traffic.shift();
+    }
+    lastTotals = totals;
+}
+
+function drawTraffic() {
+    const canvas = $('traffic-graph');
+    if (!canvas.clientWidth) return; // view hidden
+    canvas.width = canvas.clientWidth;
+    canvas.height = canvas.clientHeight;
+    const ctx = canvas.getContext('2d');
+    ctx.clearRect(0, 0, canvas.width, canvas.height);
+    if (traffic.length < 2) {
+        ctx.fillStyle = '#95a5a6';
+        ctx.fillText('Not enough traffic samples yet', 10, 20);
+        return;
+    }
+    const peak = Math.max(1, ...traffic.map(p => Math.max(p.rx, p.tx, p.dropped)));
+    const t1 = traffic[traffic.length - 1].time;
+    const t0 = t1 - rangeMs();
+    const top = 20;
+    [['rx', '#27ae60'], ['tx', '#3498db'], ['dropped', '#e74c3c']].forEach(([key, color]) => {
+        ctx.strokeStyle = color;
+        ctx.beginPath();
+        traffic.forEach((p, i) => {
+            const x = (p.time - t0) / (t1 - t0) * canvas.width;
+            const y = canvas.height - p[key] / peak * (canvas.height - top);
+            i === 0 ? ctx.moveTo(x, y) : ctx.lineTo(x, y);
+        });
+        ctx.stroke();
+    });
+    const last = traffic[traffic.length - 1];
+    ctx.fillStyle = '#666';
+    ctx.fillText('peak ' + peak.toFixed(1) + ' pps   now rx ' + last.rx.toFixed(1) + ' tx ' + last.tx.toFixed(1), 5, 12);
+}
+
+window.addEventListener('resize', drawTraffic);
+
+// ---- peer table ----
+
+document.querySelectorAll('#peer-table th[data-key]').forEach(th => {
+    th.onclick = () => {
+        sortAsc = th.dataset.key === sortKey ? !sortAsc : true;
+        sortKey = th.dataset.key;
+        renderPeers();
+    };
+});
+
+function comparePeers(a, b) {
+    let x = a[sortKey], y = b[sortKey];
+    if (typeof x === 'string' && typeof y === 'string') {
+        x = x.toLowerCase();
+        y = y.toLowerCase();
+    }
+    const c = x < y ? -1 : x > y ? 1 : 0;
+    return sortAsc ? c : -c;
+}
+
+function renderPeers() {
+    document.querySelectorAll('#peer-table th[data-key]').forEach(th => {
+        th.classList.toggle('sorted-asc', th.dataset.key === sortKey && sortAsc);
+        th.classList.toggle('sorted-desc', th.dataset.key === sortKey && !sortAsc);
+    });
+    const tbody = document.querySelector('#peer-table tbody');
+    const peers = (stats.peers || []).slice().sort(comparePeers);
+    if (peers.length === 0) {
+        tbody.replaceChildren(el('tr', {}, el('td', { colSpan: 12, textContent: 'No peers connected.' })));
+        return;
+    }
+    tbody.replaceChildren(...peers.map(p => {
+        const where = [p.city, p.country].filter(Boolean).join(', ') || '-';
+        const cells = [
+            p.id, p.hostname || '-', p.ip || '-', where,
+            p.latency_ms.toFixed(1) + ' ms', formatSince(p.connected_at),
+            p.num_children + '/' + p.max_children, p.stations,
+            formatBytes(p.sent_bytes), formatBytes(p.recv_bytes), p.errors
+        ].map(v => el('td', { textContent: v }));
+        const actions = el('td', { className: 'actions admin-only' },
+            el('button', { className: 'btn', textContent: 'Disconnect', onclick: () => peerAction('disconnect', p) }), ' ',
+            el('button', { className: 'btn btn-danger', textContent: 'Ban', onclick: () => peerAction('ban', p) }));
+        return el('tr', {}, ...cells, actions);
+    }));
+}
+
+async function peerAction(action, p) {
+    const name = p.hostname || p.id;
+    if (action === 'ban' && !confirm('Ban ' + name + ' (' + p.ip + ')?')) return;
+    try {
+        await post('/api/action', { action, id: p.id, ip: p.ip });
+        toast((action === 'ban' ? 'Banned ' : 'Disconnected ') + name, 'info');
+        poll();
+    } catch (e) {
+        toast(action + ' failed: ' + e.message, 'error');
+    }
+}
+
+$('add-peer-btn').onclick = async () => {
+    const addr = $('add-peer-addr').value.trim();
+    if (!addr) return;
+    try {
+        await post('/api/peers/add', { addr });
+        $('add-peer-addr').value = '';
+        toast('Connecting to ' + addr, 'info');
+    } catch (e) {
+        toast('Add peer failed: ' + e.message, 'error');
+    }
+};
+
+// ---- topology tree ----
+
+// topologyNodes is the overlay tree from the stats, or, before any
+// topology report has arrived, just our direct peers.
+function topologyNodes() {
+    if (stats.topology && stats.topology.length) return stats.topology;
+    const nodes = [{ id: 'Local', parent_id: '', hostname: 'this node', hops: 0 }];
+    (stats.peers || []).forEach(p => nodes.push({
+        id: p.id, parent_id: 'Local', hostname: p.hostname, peer_id: p.id,
+        num_children: p.num_children, max_children: p.max_children, stations: p.stations, metric: p.metric, hops: 1
+    }));
+    return nodes;
+}
+
+// segmentText describes the local segment a node reported upstream.
+function segmentText(seg) {
+    let text = seg.stations + ' stations';
+    if (seg.interface) text += ' on ' + seg.interface;
+    if (seg.received || seg.forwarded) text += ', RX ' + seg.received + ' TX ' + seg.forwarded;
+    if (!seg.capture_ok) text += ', capture ' + (seg.capture_error ? 'error: ' + seg.capture_error : 'failing');
+    return text;
+}
+
+function renderTopology() {
+    const nodes = topologyNodes();
+    const ids = new Set(nodes.map(n => n.id));
+    const children = new Map();
+    nodes.forEach(n => {
+        const parent = ids.has(n.parent_id) && n.parent_id !== n.id ? n.parent_id : '';
+        if (!children.has(parent)) children.set(parent, []);
+        children.get(parent).push(n);
+    });
+    const seen = new Set();
+    const branch = parent => {
+        const ul = el('ul');
+        (children.get(parent) || []).forEach(n => {
+            if (seen.has(n.id)) return; // a loop while the tree is re-forming
+            seen.add(n.id);
+            const cls = n.hops === 0 ? 'node-local' : n.peer_id ? 'node-direct' : 'node-remote';
+            let meta = n.max_children ? n.num_children + '/' + n.max_children + ' children' : '';
+            if (n.relayed_via) meta += (meta ? ', ' : '') + 'relayed via ' + n.relayed_via;
+            if (stats.hub === n.id) meta += (meta ? ', ' : '') + 'hub';
+            if (n.metric && parent) meta += (meta ? ', ' : '') + 'metric ' + n.metric;
+            if (n.segment) meta += (meta ? ', ' : '') + segmentText(n.segment);
+            else if (n.stations) meta += (meta ? ', ' : '') + n.stations + ' stations';
+            const li = el('li', {},
+                el('span', { className: cls, textContent: n.hostname || n.id }),
+                el('span', { className: 'node-meta', textContent: (n.hostname ? n.id + ' ' : '') + (meta ? '(' + meta + ')' : '') }));
+            if (children.has(n.id)) li.append(branch(n.id));
+            ul.append(li);
+        });
+        return ul;
+    };
+    $('topology-tree').replaceChildren(branch(''));
+}
+
+// ---- geo map ----
+
+// renderMap plots the peers with a GeoIP location on an equirectangular
+// grid: x is longitude + 180, y is 90 - latitude.
+function renderMap() {
+    const map = $('geo-map');
+    const parts = [];
+    for (let lon = -180; lon <= 180; lon += 30) parts.push(svg('line', { class: 'graticule', x1: lon + 180, y1: 0, x2: lon + 180, y2: 180 }));
+    for (let lat = -60; lat <= 60; lat += 30) parts.push(svg('line', { class: lat ? 'graticule' : 'equator', x1: 0, y1: 90 - lat, x2: 360, y2: 90 - lat }));
+
+    const located = (stats.peers || []).filter(p => p.lat || p.lon);
+    const byID = new Map(located.map(p => [p.id, p]));
+    located.forEach(p => {
+        const parent = byID.get(p.parent_id);
+        if (parent) parts.push(svg('line', { class: 'link', x1: parent.lon + 180, y1: 90 - parent.lat, x2: p.lon + 180, y2: 90 - p.lat }));
+    });
+    located.forEach(p => {
+        const dot = svg('circle', { class: 'peer', cx: p.lon + 180, cy: 90 - p.lat, r: 1.5 });
+        const title = svg('title');
+        title.textContent = (p.hostname || p.id) + ' – ' + [p.city, p.country].filter(Boolean).join(', ');
+        dot.append(title);
+        const label = svg('text', { x: p.lon + 182, y: 90 - p.lat + 1 });
+        label.textContent = p.hostname || p.id;
+        parts.push(dot, label);
+    });
+    map.replaceChildren(...parts);
+    const missing = (stats.peers || []).length - located.length;
+    $('map-note').textContent = missing > 0 ? missing + ' peer(s) without a GeoIP location are not shown.' : '';
+}
+
+// ---- polling ----
+
+async function poll() {
+    try {
+        const resp = await fetch('/stats');
+        stats = await resp.json();
+        sampleTraffic(stats);
+        render();
+    } catch (e) {
+        console.error('Failed to load stats', e);
+    }
+}
+
+// The OIDC callback hands over the token in the fragment.
+if (location.hash.startsWith('#sso=')) {
+    localStorage.setItem(tokenKey, location.hash.slice(5));
+    history.replaceState(null, '', '#overview');
+}
+setToken(token());
+loadAuth();
+route();
+loadHistory().then(poll);
+setInterval(poll, pollInterval);
@@ -6,15 +6,41 @@ package capture
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
 	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/pcap"
 	"github.com/mlapointe/ipxtransporter/internal/logger"
 )
 
+// Injection error classes reported by ClassifyError.
+const (
+	ErrClassHandleClosed = "handle_closed"
+	ErrClassTransient    = "transient"
+	ErrClassOther        = "other"
+)
+
+var ErrHandleClosed = errors.New("capture handle is closed")
+
+// DefaultFilter is the BPF filter captures use unless given another.
+// The "ipx" primitive matches every IPX encapsulation: Ethernet_II
+// (EtherType 0x8137), raw 802.3, 802.2 LLC and SNAP.
+const DefaultFilter = "ipx"
+
 type Capturer struct {
-	iface  string
-	handle *pcap.Handle
+	iface      string
+	filter     string
+	mu         sync.Mutex
+	handle     *pcap.Handle
+	ctx        context.Context
+	packetChan chan<- []byte
 }
 
 func NewCapturer(iface string) *Capturer {
@@ -24,12 +50,70 @@ func NewCapturer(iface string) *Capturer {
 }
 
 func (c *Capturer) Start(ctx context.Context, packetChan chan<- []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ctx = ctx
+	c.packetChan = packetChan
+	return c.open()
+}
+
+// SetFilter sets the BPF filter of the captures opened from now on, the
+// DefaultFilter if empty.
+func (c *Capturer) SetFilter(filter string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.filter = filter
+}
+
+// Reopen closes the current handle, if any, and opens a fresh one on the
+// same interface. It is used to recover from a handle that keeps failing.
+func (c *Capturer) Reopen() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ctx == nil {
+		return fmt.Errorf("capturer not started")
+	}
+	if c.handle != nil {
+		c.handle.Close()
+		c.handle = nil
+	}
+	return c.open()
+}
+
+// Restart moves the capture to iface with filter without stopping the
+// relay. If the new handle does not open, the capture goes back to the
+// interface and filter it had, and the error is returned.
+func (c *Capturer) Restart(iface, filter string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ctx == nil {
+		return fmt.Errorf("capturer not started")
+	}
+	if c.handle != nil {
+		c.handle.Close()
+		c.handle = nil
+	}
+	prevIface, prevFilter := c.iface, c.filter
+	c.iface, c.filter = iface, filter
+	err := c.open()
+	if err != nil {
+		c.iface, c.filter = prevIface, prevFilter
+		if rerr := c.open(); rerr != nil {
+			logger.Capture.Error("Failed to reopen %s after a failed restart: %v", prevIface, rerr)
+		}
+	}
+	return err
+}
+
+// open must be called with c.mu held.
+func (c *Capturer) open() error {
 	if c.iface == "" {
 		return fmt.Errorf("no interface specified")
 	}
-	// IPX EtherType is 0x8137. Also sometimes 0x8003 (older).
-	// We use a BPF filter to capture only IPX packets.
-	filter := "ether proto 0x8137"
+	filter := c.filter
+	if filter == "" {
+		filter = DefaultFilter
+	}
 
 	handle, err := pcap.OpenLive(c.iface, 1600, true, pcap.BlockForever)
 	if err != nil {
@@ -38,10 +122,17 @@ func (c *Capturer) Start(ctx context.Context, packetChan chan<- []byte) error {
 	c.handle = handle
 
 	if err := handle.SetBPFFilter(filter); err != nil {
-		logger.Info("Warning: failed to set BPF filter: %v", err)
+		logger.Capture.Warn("Failed to set BPF filter %q: %v", filter, err)
+		if filter != DefaultFilter {
+			// Rather IPX than every frame on the segment.
+			if err := handle.SetBPFFilter(DefaultFilter); err != nil {
+				logger.Capture.Warn("Failed to set BPF filter: %v", err)
+			}
+		}
 	}
 
 	packetSource := gopacket.NewPacketSource(handle, handle.LinkType())
+	ctx, packetChan := c.ctx, c.packetChan
 
 	go func() {
 		defer handle.Close()
@@ -61,13 +152,67 @@ func (c *Capturer) Start(ctx context.Context, packetChan chan<- []byte) error {
 	return nil
 }
 
+// Stats are the capture handle's packet counters. They restart when the
+// handle is reopened.
+type Stats struct {
+	Received  uint64
+	Dropped   uint64 // dropped by the kernel: buffer full
+	IfDropped uint64 // dropped by the interface or its driver
+}
+
+// Stats returns the current handle's packet counters.
+func (c *Capturer) Stats() (Stats, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.handle == nil {
+		return Stats{}, ErrHandleClosed
+	}
+	st, err := c.handle.Stats()
+	if err != nil {
+		return Stats{}, err
+	}
+	return Stats{
+		Received:  uint64(st.PacketsReceived),
+		Dropped:   uint64(st.PacketsDropped),
+		IfDropped: uint64(st.PacketsIfDropped),
+	}, nil
+}
+
 func (c *Capturer) Inject(data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	if c.handle == nil {
-		return fmt.Errorf("capturer handle is nil")
+		return ErrHandleClosed
 	}
 	return c.handle.WritePacketData(data)
 }
 
+// ClassifyError sorts an Inject error into one of the ErrClass* buckets.
+// Transient errors (full socket buffers) are worth retrying; a closed
+// handle needs to be reopened.
+func ClassifyError(err error) string {
+	if errors.Is(err, ErrHandleClosed) || errors.Is(err, syscall.EBADF) || errors.Is(err, syscall.ENODEV) {
+		return ErrClassHandleClosed
+	}
+	if errors.Is(err, syscall.ENOBUFS) || errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EINTR) {
+		return ErrClassTransient
+	}
+
+	// libpcap reports send failures as plain strings from pcap_geterr.
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "No buffer space available"),
+		strings.Contains(msg, "Resource temporarily unavailable"),
+		strings.Contains(msg, "Interrupted system call"):
+		return ErrClassTransient
+	case strings.Contains(msg, "Bad file descriptor"),
+		strings.Contains(msg, "No such device"),
+		strings.Contains(msg, "Network is down"):
+		return ErrClassHandleClosed
+	}
+	return ErrClassOther
+}
+
 func ListInterfaces() ([]string, error) {
 	devices, err := pcap.FindAllDevs()
 	if err != nil {
@@ -80,3 +225,56 @@ func ListInterfaces() ([]string, error) {
 	}
 	return names, nil
 }
+
+// CheckFilter reports whether filter compiles as a BPF filter for
+// Ethernet, as a capture would set it.
+func CheckFilter(filter string) error {
+	_, err := pcap.CompileBPFFilter(layers.LinkTypeEthernet, 1600, filter)
+	return err
+}
+
+// Probe counts the IPX frames seen on iface for d, on a handle of its own,
+// so it can be run on any interface, captured or not.
+func Probe(iface string, d time.Duration) (int, error) {
+	handle, err := pcap.OpenLive(iface, 1600, true, 100*time.Millisecond)
+	if err != nil {
+		return 0, err
+	}
+	defer handle.Close()
+	if err := handle.SetBPFFilter(DefaultFilter); err != nil {
+		return 0, err
+	}
+	n := 0
+	for deadline := time.Now().Add(d); time.Now().Before(deadline); {
+		_, _, err := handle.ReadPacketData()
+		switch {
+		case err == nil:
+			n++
+		case errors.Is(err, pcap.NextErrorTimeoutExpired):
+		default:
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Link is the state of a network interface as the system reports it.
+type Link struct {
+	MAC     string // empty if it has none, as the loopback
+	Up      bool   // administratively up
+	Running bool   // up with a carrier
+}
+
+// LinkState is the state of the interface named name. Capture devices
+// that are not network interfaces, such as "any", have none.
+func LinkState(name string) (Link, error) {
+	ifi, err := net.InterfaceByName(name)
+	if err != nil {
+		return Link{}, err
+	}
+	return Link{
+		MAC:     ifi.HardwareAddr.String(),
+		Up:      ifi.Flags&net.FlagUp != 0,
+		Running: ifi.Flags&net.FlagRunning != 0,
+	}, nil
+}
@@ -0,0 +1,30 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for injection error classification
+
+package capture
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+)
+
+func TestClassifyError(t *testing.T) {
+	cases := []struct {
+		err  error
+		want string
+	}{
+		{ErrHandleClosed, ErrClassHandleClosed},
+		{fmt.Errorf("write: %w", syscall.ENOBUFS), ErrClassTransient},
+		{errors.New("send: No buffer space available"), ErrClassTransient},
+		{errors.New("send: Network is down"), ErrClassHandleClosed},
+		{errors.New("send: Message too long"), ErrClassOther},
+	}
+	for _, c := range cases {
+		if got := ClassifyError(c.err); got != c.want {
+			t.Errorf("ClassifyError(%v) = %s, want %s", c.err, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Minimal pcapng writer for recorded Ethernet frames
+
+package capture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// pcapng block types and options, see draft-ietf-opsawg-pcapng.
+const (
+	blockSection   = 0x0A0D0D0A
+	blockInterface = 0x00000001
+	blockPacket    = 0x00000006
+	byteOrderMagic = 0x1A2B3C4D
+
+	optEnd       = 0
+	optComment   = 1
+	optIfName    = 2
+	optIfDesc    = 3
+	optIfTSResol = 9
+
+	linkTypeEthernet = 1
+)
+
+// PcapngWriter writes Ethernet frames as a pcapng file with nanosecond
+// timestamps. Each source of frames is an interface of its own.
+type PcapngWriter struct {
+	w       *bufio.Writer
+	written int64
+}
+
+// NewPcapngWriter writes the section header, carrying comment if not empty.
+func NewPcapngWriter(w io.Writer, comment string) (*PcapngWriter, error) {
+	p := &PcapngWriter{w: bufio.NewWriter(w)}
+	body := binary.LittleEndian.AppendUint32(nil, byteOrderMagic)
+	body = binary.LittleEndian.AppendUint16(body, 1) // version 1.0
+	body = binary.LittleEndian.AppendUint16(body, 0)
+	body = binary.LittleEndian.AppendUint64(body, ^uint64(0)) // section length unknown
+	body = appendOptions(body, option{optComment, []byte(comment)})
+	return p, p.writeBlock(blockSection, body)
+}
+
+// AddInterface declares an interface. Interfaces are numbered from 0 in the
+// order they are added, which is the index WritePacket takes.
+func (p *PcapngWriter) AddInterface(name, description string) error {
+	body := binary.LittleEndian.AppendUint16(nil, linkTypeEthernet)
+	body = binary.LittleEndian.AppendUint16(body, 0)
+	body = binary.LittleEndian.AppendUint32(body, 0) // no snap length
+	body = appendOptions(body,
+		option{optIfName, []byte(name)},
+		option{optIfDesc, []byte(description)},
+		option{optIfTSResol, []byte{9}}) // nanoseconds
+	return p.writeBlock(blockInterface, body)
+}
+
+// WritePacket records a frame seen on interface iface at ts.
+func (p *PcapngWriter) WritePacket(iface int, ts time.Time, data []byte) error {
+	nanos := uint64(ts.UnixNano())
+	body := binary.LittleEndian.AppendUint32(nil, uint32(iface))
+	body = binary.LittleEndian.AppendUint32(body, uint32(nanos>>32))
+	body = binary.LittleEndian.AppendUint32(body, uint32(nanos))
+	body = binary.LittleEndian.AppendUint32(body, uint32(len(data)))
+	body = binary.LittleEndian.AppendUint32(body, uint32(len(data)))
+	body = append(body, data...)
+	body = pad4(body)
+	return p.writeBlock(blockPacket, body)
+}
+
+// Written returns the size of the file so far.
+func (p *PcapngWriter) Written() int64 {
+	return p.written
+}
+
+// Flush writes out buffered blocks.
+func (p *PcapngWriter) Flush() error {
+	return p.w.Flush()
+}
+
+func (p *PcapngWriter) writeBlock(typ uint32, body []byte) error {
+	total := uint32(12 + len(body))
+	b := binary.LittleEndian.AppendUint32(nil, typ)
+	b = binary.LittleEndian.AppendUint32(b, total)
+	b = append(b, body...)
+	b = binary.LittleEndian.AppendUint32(b, total)
+	n, err := p.w.Write(b)
+	p.written += int64(n)
+	return err
+}
+
+type option struct {
+	code  uint16
+	value []byte
+}
+
+// appendOptions appends the non-empty options and the end of options.
+func appendOptions(b []byte, opts ...option) []byte {
+	some := false
+	for _, o := range opts {
+		if len(o.value) == 0 {
+			continue
+		}
+		b = binary.LittleEndian.AppendUint16(b, o.code)
+		b = binary.LittleEndian.AppendUint16(b, uint16(len(o.value)))
+		b = pad4(append(b, o.value...))
+		some = true
+	}
+	if some {
+		b = binary.LittleEndian.AppendUint32(b, optEnd)
+	}
+	return b
+}
+
+func pad4(b []byte) []byte {
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
@@ -0,0 +1,71 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for the pcapng writer
+
+package capture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestPcapngWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewPcapngWriter(&buf, "test capture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AddInterface("segment", "eth0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AddInterface("mesh", ""); err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Unix(1700000000, 123456789)
+	frame := []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 1, 2, 3, 4, 5, 6, 0x81, 0x37, 0xaa}
+	if err := w.WritePacket(1, ts, frame); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if w.Written() != int64(buf.Len()) {
+		t.Errorf("Expected %d bytes written, got %d", buf.Len(), w.Written())
+	}
+
+	// Walk the blocks: each starts and ends with its total length, a
+	// multiple of 4.
+	data := buf.Bytes()
+	var types []uint32
+	var packet []byte
+	for len(data) > 0 {
+		if len(data) < 12 {
+			t.Fatalf("Expected a whole block, %d bytes left", len(data))
+		}
+		typ := binary.LittleEndian.Uint32(data[0:4])
+		total := binary.LittleEndian.Uint32(data[4:8])
+		if total%4 != 0 || int(total) > len(data) || binary.LittleEndian.Uint32(data[total-4:total]) != total {
+			t.Fatalf("Expected a consistent length in block %x, got %d", typ, total)
+		}
+		types = append(types, typ)
+		if typ == blockPacket {
+			packet = data[8 : total-4]
+		}
+		data = data[total:]
+	}
+	if len(types) != 4 || types[0] != blockSection || types[1] != blockInterface || types[2] != blockInterface || types[3] != blockPacket {
+		t.Fatalf("Expected a section, two interfaces and a packet, got %x", types)
+	}
+	if binary.LittleEndian.Uint32(buf.Bytes()[8:12]) != byteOrderMagic {
+		t.Error("Expected the byte-order magic in the section header")
+	}
+
+	iface := binary.LittleEndian.Uint32(packet[0:4])
+	nanos := uint64(binary.LittleEndian.Uint32(packet[4:8]))<<32 | uint64(binary.LittleEndian.Uint32(packet[8:12]))
+	capLen := binary.LittleEndian.Uint32(packet[12:16])
+	if iface != 1 || int64(nanos) != ts.UnixNano() || int(capLen) != len(frame) || !bytes.Equal(packet[20:20+capLen], frame) {
+		t.Errorf("Expected the frame on interface 1 at %d, got interface %d at %d, %d bytes", ts.UnixNano(), iface, nanos, capLen)
+	}
+}
@@ -0,0 +1,372 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Wire compatibility: replaying recorded peer link transcripts
+
+// Package compat checks that this build still speaks the peer protocol of
+// earlier releases. A transcript records, frame by frame, what a node of
+// one protocol generation sent on a link and what it expected back; Replay
+// plays the remote's side of it against a peer link of this build. The
+// transcripts in transcripts/ were recorded once and are never edited: a
+// protocol change that breaks one breaks links to nodes still running
+// that generation. A new generation gets a transcript of its own.
+package compat
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"reflect"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/peer"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+//go:embed transcripts/*.json
+var recorded embed.FS
+
+// stepTimeout bounds how long a step waits for the link to react.
+const stepTimeout = 2 * time.Second
+
+// Result statuses.
+const (
+	StatusOK     = "ok"
+	StatusFailed = "failed"
+	StatusAbsent = "absent" // the remote does not offer the feature
+)
+
+// Result is how one protocol feature fared against a transcript or a
+// remote node.
+type Result struct {
+	Source  string `json:"source"` // transcript name or remote address
+	Feature string `json:"feature"`
+	Status  string `json:"status"`
+	Detail  string `json:"detail,omitempty"`
+}
+
+// Hex is bytes written as a hex string, so transcripts can be read.
+type Hex []byte
+
+func (h Hex) MarshalText() ([]byte, error) {
+	return hex.AppendEncode(nil, h), nil
+}
+
+func (h *Hex) UnmarshalText(b []byte) error {
+	d, err := hex.AppendDecode(nil, b)
+	*h = d
+	return err
+}
+
+// Transcript is a link as a node of an earlier protocol generation played
+// it. HandshakeOut is the key exchange it expected from us, HandshakeIn
+// its own; the steps follow in order.
+type Transcript struct {
+	Name         string `json:"name"`
+	Description  string `json:"description"`
+	NetworkKey   string `json:"network_key,omitempty"`
+	Padding      string `json:"padding,omitempty"` // our padding mode on the link
+	HandshakeOut Hex    `json:"handshake_out"`
+	HandshakeIn  Hex    `json:"handshake_in"`
+	Steps        []Step `json:"steps"`
+}
+
+// Step is one exchange on the link: the remote sends In, or we send the
+// packet Send, then whatever of the other fields is set must follow.
+type Step struct {
+	Feature string `json:"feature"`
+	In      Hex    `json:"in,omitempty"`   // frame the remote sends, length prefix included
+	Send    Hex    `json:"send,omitempty"` // packet we send
+
+	Out      Hex    `json:"out,omitempty"`      // next frame the remote must read, pings aside
+	Packet   Hex    `json:"packet,omitempty"`   // packet In must hand to the relay
+	Hops     int    `json:"hops,omitempty"`     // relay trace hops In carries
+	NodeID   string `json:"node_id,omitempty"`  // node ID a hello must record
+	Signed   bool   `json:"signed,omitempty"`   // a hello must pin the remote's key
+	Children []int  `json:"children,omitempty"` // children and maximum a status must record
+	Topology int    `json:"topology,omitempty"` // nodes a topology frame must record
+}
+
+// Transcripts returns the transcripts recorded from earlier releases, in
+// the order of their names.
+func Transcripts() ([]Transcript, error) {
+	names, err := recorded.ReadDir("transcripts")
+	if err != nil {
+		return nil, err
+	}
+	var out []Transcript
+	for _, e := range names {
+		data, err := recorded.ReadFile(path.Join("transcripts", e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		t, err := ParseTranscript(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", e.Name(), err)
+		}
+		out = append(out, t)
+	}
+	return out, nil
+}
+
+// ParseTranscript decodes a transcript from JSON.
+func ParseTranscript(data []byte) (Transcript, error) {
+	var t Transcript
+	if err := json.Unmarshal(data, &t); err != nil {
+		return t, err
+	}
+	if t.Name == "" || len(t.HandshakeOut) == 0 {
+		return t, errors.New("a transcript needs a name and handshake_out")
+	}
+	return t, nil
+}
+
+// frame is a frame read off the link, flags split from the length.
+type frame struct {
+	flags   uint32
+	payload []byte
+}
+
+// The flags of a length prefix, as package peer sets them.
+const (
+	controlFlag = uint32(1) << 31
+	frameFlags  = uint32(7) << 29 // control, pad and trace
+)
+
+func readFrame(r io.Reader) (frame, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return frame{}, err
+	}
+	n := binary.BigEndian.Uint32(hdr[:])
+	f := frame{flags: n & frameFlags, payload: make([]byte, n&^frameFlags)}
+	_, err := io.ReadFull(r, f.payload)
+	return f, err
+}
+
+// control decodes a control frame's JSON for comparison, nil if f is not
+// one.
+func (f frame) control() map[string]any {
+	if f.flags&controlFlag == 0 {
+		return nil
+	}
+	var m map[string]any
+	if json.Unmarshal(f.payload, &m) != nil {
+		return nil
+	}
+	return m
+}
+
+// matches compares frames, control frames by their decoded JSON so that
+// the order of members does not matter.
+func (f frame) matches(want frame) bool {
+	if f.flags != want.flags {
+		return false
+	}
+	if m := want.control(); m != nil {
+		return reflect.DeepEqual(f.control(), m)
+	}
+	return bytes.Equal(f.payload, want.payload)
+}
+
+// String shows a control frame as its JSON, any other as its flags and
+// bytes.
+func (f frame) String() string {
+	if f.control() != nil {
+		return string(f.payload)
+	}
+	return fmt.Sprintf("frame %#x % x", f.flags, f.payload)
+}
+
+// replay is a transcript being played against a link of this build.
+type replay struct {
+	p       *peer.Peer
+	remote  net.Conn
+	relayed chan []byte
+	hops    chan int
+	frames  chan frame // read by the remote, pings left out
+}
+
+// Replay plays the remote's side of t against a peer link of this build
+// and reports each feature the transcript exercises. Steps after a failed
+// key exchange are reported as not run.
+func Replay(t Transcript) []Result {
+	local, remote := net.Pipe()
+	r := &replay{
+		p:       peer.NewPeer("compat:"+t.Name, local, t.NetworkKey),
+		remote:  remote,
+		relayed: make(chan []byte, 16),
+		hops:    make(chan int, 16),
+		frames:  make(chan frame, 16),
+	}
+	r.p.NoLookup = true
+	r.p.SetPadding(t.Padding)
+	r.p.SetFrameHandler(func(_ *peer.Peer, _ []byte, hops []stats.TraceHop) {
+		if hops != nil {
+			r.hops <- len(hops)
+		}
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go r.p.Run(ctx, r.relayed, func(string) { close(done) })
+	defer func() {
+		cancel()
+		remote.Close()
+		<-done
+	}()
+
+	results := make([]Result, 0, 1+len(t.Steps))
+	add := func(feature string, err error) {
+		res := Result{Source: t.Name, Feature: feature, Status: StatusOK}
+		if err != nil {
+			res.Status, res.Detail = StatusFailed, err.Error()
+		}
+		results = append(results, res)
+	}
+	err := r.handshake(t)
+	add("key exchange", err)
+	if err != nil {
+		for _, s := range t.Steps {
+			add(s.Feature, errors.New("not run: the key exchange failed"))
+		}
+		return results
+	}
+	go r.read()
+	for _, s := range t.Steps {
+		add(s.Feature, r.step(s))
+	}
+	return results
+}
+
+func (r *replay) handshake(t Transcript) error {
+	r.remote.SetDeadline(time.Now().Add(stepTimeout))
+	defer r.remote.SetDeadline(time.Time{})
+	got := make([]byte, len(t.HandshakeOut))
+	if _, err := io.ReadFull(r.remote, got); err != nil {
+		return fmt.Errorf("reading our key exchange: %v", err)
+	}
+	if !bytes.Equal(got, t.HandshakeOut) {
+		return fmt.Errorf("we sent % x, want % x", got, []byte(t.HandshakeOut))
+	}
+	if _, err := r.remote.Write(t.HandshakeIn); err != nil {
+		return fmt.Errorf("the link closed on the remote's key: %v", err)
+	}
+	return waitFor(context.Background(), func() error {
+		if !r.p.Authenticated() {
+			return errors.New("the link did not authenticate")
+		}
+		return nil
+	})
+}
+
+// read hands the frames we send to the remote to step, until the link
+// closes. Our pings come at any time and are left out.
+func (r *replay) read() {
+	defer close(r.frames)
+	for {
+		f, err := readFrame(r.remote)
+		if err != nil {
+			return
+		}
+		if m := f.control(); m != nil && m["type"] == peer.ControlPing {
+			continue
+		}
+		r.frames <- f
+	}
+}
+
+func (r *replay) step(s Step) error {
+	if len(s.In) > 0 {
+		r.remote.SetWriteDeadline(time.Now().Add(stepTimeout))
+		if _, err := r.remote.Write(s.In); err != nil {
+			return fmt.Errorf("the link closed on the frame: %v", err)
+		}
+	}
+	if len(s.Send) > 0 && !r.p.Send(s.Send) {
+		return errors.New("the packet was not queued")
+	}
+	timeout := time.After(stepTimeout)
+	if s.Hops > 0 {
+		select {
+		case n := <-r.hops:
+			if n != s.Hops {
+				return fmt.Errorf("trace of %d hops, want %d", n, s.Hops)
+			}
+		case <-timeout:
+			return errors.New("no relay trace")
+		}
+	}
+	if len(s.Packet) > 0 {
+		select {
+		case got := <-r.relayed:
+			if !bytes.Equal(got, s.Packet) {
+				return fmt.Errorf("relayed % x, want % x", got, []byte(s.Packet))
+			}
+		case <-timeout:
+			return errors.New("no packet relayed")
+		}
+	}
+	if len(s.Out) > 0 {
+		want, err := readFrame(bytes.NewReader(s.Out))
+		if err != nil {
+			return fmt.Errorf("transcript: bad frame: %v", err)
+		}
+		select {
+		case got, ok := <-r.frames:
+			if !ok {
+				return errors.New("the link closed")
+			}
+			if !got.matches(want) {
+				return fmt.Errorf("we sent %s, want %s", got, want)
+			}
+		case <-timeout:
+			return fmt.Errorf("we sent nothing, want %s", want)
+		}
+	}
+	return waitFor(context.Background(), func() error { return r.recorded(s) })
+}
+
+// recorded checks what the step's frame should have recorded on the link.
+func (r *replay) recorded(s Step) error {
+	if s.NodeID != "" && r.p.NodeID() != s.NodeID {
+		return fmt.Errorf("node ID %q, want %q", r.p.NodeID(), s.NodeID)
+	}
+	if s.Signed && r.p.KeyFingerprint() == "" {
+		return errors.New("the remote's key was not pinned")
+	}
+	if s.Topology > 0 && len(r.p.Topology()) != s.Topology {
+		return fmt.Errorf("%d topology nodes, want %d", len(r.p.Topology()), s.Topology)
+	}
+	if len(s.Children) == 2 {
+		st := r.p.GetStats()
+		if st.NumChildren != s.Children[0] || st.MaxChildren != s.Children[1] {
+			return fmt.Errorf("%d of %d children, want %d of %d", st.NumChildren, st.MaxChildren, s.Children[0], s.Children[1])
+		}
+	}
+	return nil
+}
+
+// waitFor polls check until it passes, stepTimeout is up or ctx is done,
+// returning its last error.
+func waitFor(ctx context.Context, check func() error) error {
+	ctx, cancel := context.WithTimeout(ctx, stepTimeout)
+	defer cancel()
+	for {
+		err := check()
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
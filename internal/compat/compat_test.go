@@ -0,0 +1,110 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for wire compatibility with recorded transcripts
+
+package compat
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+
+	"github.com/mlapointe/ipxtransporter/internal/peer"
+)
+
+func TestTranscripts(t *testing.T) {
+	transcripts, err := Transcripts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transcripts) == 0 {
+		t.Fatal("Expected recorded transcripts")
+	}
+	for _, tr := range transcripts {
+		t.Run(tr.Name, func(t *testing.T) {
+			for _, r := range Replay(tr) {
+				if r.Status != StatusOK {
+					t.Errorf("%s: %s: %s", r.Feature, r.Status, r.Detail)
+				}
+			}
+		})
+	}
+}
+
+func TestReplayDetectsBreakage(t *testing.T) {
+	transcripts, err := Transcripts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := transcripts[0]
+	tr.Steps = append([]Step(nil), tr.Steps...)
+	for i, s := range tr.Steps {
+		if len(s.Packet) > 0 {
+			s.Packet = bytes.Clone(s.Packet)
+			s.Packet[len(s.Packet)-1] ^= 0xff
+			tr.Steps[i] = s
+		}
+	}
+	failed := 0
+	for _, r := range Replay(tr) {
+		if r.Status == StatusFailed {
+			failed++
+		}
+	}
+	if failed != 1 {
+		t.Errorf("Expected the altered packet step to fail alone, got %d failures", failed)
+	}
+
+	tr.HandshakeIn = Hex("\x00\x00\x00\x04mash")
+	results := Replay(tr)
+	for _, r := range results {
+		if r.Status != StatusFailed {
+			t.Errorf("Expected every step to fail after a key mismatch, %s did", r.Feature)
+		}
+	}
+}
+
+func TestProbe(t *testing.T) {
+	// Both ends send their key first, which needs a buffered link.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	local, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := peer.NewPeer("remote", remote, "mesh")
+	p.NoLookup = true
+	p.SetControlHandler(func(p *peer.Peer, c peer.Control) {
+		if c.Type == peer.ControlHello && c.Probe {
+			p.SendControl(peer.Control{Type: peer.ControlHello, NodeID: "node-r", Role: peer.RoleParent, Traces: true})
+		}
+	})
+	done := make(chan struct{})
+	go p.Run(context.Background(), make(chan []byte, 1), func(string) { close(done) })
+	defer func() {
+		remote.Close()
+		<-done
+	}()
+
+	status := make(map[string]string)
+	for _, r := range Probe(context.Background(), local, "mesh") {
+		status[r.Feature] = r.Status
+	}
+	want := map[string]string{
+		"key exchange": StatusOK, "hello": StatusOK, "signed metadata": StatusAbsent,
+		"padding": StatusAbsent, "traces": StatusOK, "declared networks": StatusAbsent, "ping": StatusOK,
+	}
+	for f, s := range want {
+		if status[f] != s {
+			t.Errorf("Expected %s to be %s, got %q", f, s, status[f])
+		}
+	}
+}
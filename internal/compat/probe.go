@@ -0,0 +1,100 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Probing which protocol features a remote node offers
+
+package compat
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/mlapointe/ipxtransporter/internal/peer"
+)
+
+// Probe runs a link to a remote node over conn, which must be dialed and
+// past TLS, and reports the protocol features the remote's hello offers.
+// Our hello marks the link as a probe, so the remote relays no traffic
+// over it; the link is closed when Probe returns.
+func Probe(ctx context.Context, conn net.Conn, networkKey string) []Result {
+	source := conn.RemoteAddr().String()
+	p := peer.NewPeer(source, conn, networkKey)
+	p.NoLookup = true
+	p.SetMute(true, true)
+	hellos := make(chan peer.Control, 1)
+	p.SetControlHandler(func(_ *peer.Peer, c peer.Control) {
+		if c.Type == peer.ControlHello {
+			select {
+			case hellos <- c:
+			default:
+			}
+		}
+	})
+	id := make([]byte, 4)
+	rand.Read(id)
+	p.SendControl(peer.Control{
+		Type:     peer.ControlHello,
+		NodeID:   "compat-" + hex.EncodeToString(id),
+		Role:     peer.RoleChild,
+		Protocol: peer.ProtocolVersion,
+		Padding:  true,
+		Traces:   true,
+		Probe:    true,
+	})
+	done := make(chan struct{})
+	go p.Run(ctx, make(chan []byte), func(string) { close(done) })
+	defer func() {
+		p.Conn.Close()
+		<-done
+	}()
+
+	var results []Result
+	add := func(feature, status, detail string) {
+		results = append(results, Result{Source: source, Feature: feature, Status: status, Detail: detail})
+	}
+	var hello peer.Control
+	select {
+	case hello = <-hellos:
+	case <-done:
+	case <-ctx.Done():
+	}
+	if !p.Authenticated() {
+		add("key exchange", StatusFailed, "the remote closed the link: its network_key differs or it refused us")
+		return results
+	}
+	add("key exchange", StatusOK, "")
+	if hello.Type == "" {
+		add("hello", StatusFailed, "no hello: the remote may have banned us, be full or not allow us")
+		return results
+	}
+	protocol, _ := p.Negotiated()
+	add("hello", StatusOK, fmt.Sprintf("node %s, protocol %d", hello.NodeID, protocol))
+
+	offered := func(feature string, ok bool, detail string) {
+		if ok {
+			add(feature, StatusOK, "")
+		} else {
+			add(feature, StatusAbsent, detail)
+		}
+	}
+	offered("signed metadata", p.KeyFingerprint() != "", "its metadata is unsigned and its key cannot be pinned")
+	offered("padding", hello.Padding, "packets to it are sent unpadded")
+	offered("traces", hello.Traces, "relay traces stop at it")
+	offered("declared networks", hello.Declares, "its networks are learned from its traffic")
+
+	err := waitFor(ctx, func() error {
+		if p.GetStats().LatencyMs == 0 {
+			return errors.New("no answer to our ping")
+		}
+		return nil
+	})
+	if err != nil {
+		add("ping", StatusFailed, err.Error())
+	} else {
+		add("ping", StatusOK, fmt.Sprintf("%.1f ms", p.GetStats().LatencyMs))
+	}
+	return results
+}
@@ -0,0 +1,198 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// API authentication backends: local users, OIDC and client certificates
+
+package config
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// API authentication backends.
+const (
+	AuthLocal      = "local"       // admin_user and api_users, logging in with a password
+	AuthOIDC       = "oidc"        // an OpenID Connect provider
+	AuthClientCert = "client_cert" // TLS client certificates listed in client_certs
+)
+
+var authBackends = []string{AuthLocal, AuthOIDC, AuthClientCert}
+
+// APIAuthConfig selects how the callers of each API listener prove who
+// they are. The control socket needs none of it: its permissions decide.
+type APIAuthConfig struct {
+	HTTP        []string        `json:"http"` // backends the HTTP API accepts, local if empty
+	GRPC        []string        `json:"grpc"` // backends the gRPC API accepts, local if empty
+	OIDC        OIDCConfig      `json:"oidc"`
+	ClientCerts []APIClientCert `json:"client_certs"`
+}
+
+// OIDCConfig logs dashboard users in at an OpenID Connect provider with
+// the authorization-code flow, and accepts the provider's access tokens on
+// API calls by introspecting them. The user's role comes from a claim
+// listing their groups or roles.
+type OIDCConfig struct {
+	Issuer       string   `json:"issuer"`        // provider URL, its metadata is at /.well-known/openid-configuration
+	ClientID     string   `json:"client_id"`     // the hub's client at the provider
+	ClientSecret string   `json:"client_secret"` // may be ${NAME}, see loadSecrets
+	RedirectURL  string   `json:"redirect_url"`  // /api/oidc/callback as browsers reach the hub
+	Scopes       []string `json:"scopes"`        // requested beside openid
+	UserClaim    string   `json:"user_claim"`    // claim naming the user, sub if it is missing
+	RoleClaim    string   `json:"role_claim"`    // claim listing groups or roles; dots reach into objects, e.g. realm_access.roles
+	AdminValues  []string `json:"admin_values"`  // values of role_claim that make a user admin
+	ViewerValues []string `json:"viewer_values"` // values of role_claim that make a user viewer
+}
+
+// APIClientCert lets the holder of a TLS client certificate call the API
+// as Name without a token. The certificate is pinned by its key, as peers
+// are, so it may be self-signed.
+type APIClientCert struct {
+	Name        string `json:"name"`
+	Fingerprint string `json:"fingerprint"` // SHA-256 of the certificate's public key
+	Role        string `json:"role"`
+}
+
+// Backends returns the backends of the listener, http or grpc.
+func (a APIAuthConfig) Backends(listener string) []string {
+	b := a.HTTP
+	if listener == "grpc" {
+		b = a.GRPC
+	}
+	if len(b) == 0 {
+		return []string{AuthLocal}
+	}
+	return b
+}
+
+// Uses reports whether any listener accepts backend.
+func (a APIAuthConfig) Uses(backend string) bool {
+	return slices.Contains(a.Backends("http"), backend) || slices.Contains(a.Backends("grpc"), backend)
+}
+
+// ValidateAuth checks api_auth: the backends named, the OIDC settings if
+// a listener uses OIDC and the client certificates.
+func (c *Config) ValidateAuth() error {
+	a := c.APIAuth
+	for _, l := range []string{"http", "grpc"} {
+		for _, b := range a.Backends(l) {
+			if !slices.Contains(authBackends, b) {
+				return fmt.Errorf("%s: unknown backend %q, want local, oidc or client_cert", l, b)
+			}
+		}
+	}
+	// Client certificates need TLS, with the peer listener's keys.
+	if slices.Contains(a.Backends("http"), AuthClientCert) && c.TLSCertPath == "" {
+		return errors.New("http: client_cert serves the HTTP API over TLS, which needs tls_cert_path and tls_key_path")
+	}
+	if slices.Contains(a.Backends("grpc"), AuthClientCert) && c.DisableSSL {
+		return errors.New("grpc: client_cert needs TLS, but disable_ssl is on")
+	}
+	if a.Uses(AuthOIDC) {
+		if err := a.OIDC.validate(); err != nil {
+			return fmt.Errorf("oidc: %v", err)
+		}
+	}
+	if a.Uses(AuthClientCert) && len(a.ClientCerts) == 0 {
+		return errors.New("client_cert is accepted but client_certs is empty")
+	}
+	seen := make(map[string]bool)
+	for i := range a.ClientCerts {
+		cc := &a.ClientCerts[i]
+		switch {
+		case cc.Name == "":
+			return fmt.Errorf("client_certs: certificate %d has no name", i+1)
+		case seen[cc.Name]:
+			return fmt.Errorf("client_certs: %q is defined twice", cc.Name)
+		case roleRank[cc.Role] == 0:
+			return fmt.Errorf("client_certs: %q has unknown role %q, want viewer or admin", cc.Name, cc.Role)
+		}
+		seen[cc.Name] = true
+		if b, err := hex.DecodeString(NormalFingerprint(cc.Fingerprint)); err != nil || len(b) != 32 {
+			return fmt.Errorf("client_certs: %q: fingerprint %q is not a SHA-256 in hex", cc.Name, cc.Fingerprint)
+		}
+	}
+	return nil
+}
+
+func (o OIDCConfig) validate() error {
+	u, err := url.Parse(o.Issuer)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("issuer %q is not an https URL", o.Issuer)
+	}
+	if o.ClientID == "" {
+		return errors.New("client_id is empty")
+	}
+	if u, err := url.Parse(o.RedirectURL); err != nil || u.Host == "" || !strings.HasSuffix(u.Path, "/api/oidc/callback") {
+		return fmt.Errorf("redirect_url %q is not the URL of /api/oidc/callback", o.RedirectURL)
+	}
+	if o.RoleClaim == "" || len(o.AdminValues)+len(o.ViewerValues) == 0 {
+		return errors.New("role_claim and admin_values or viewer_values are needed to give users a role")
+	}
+	return nil
+}
+
+// Role returns the user named by the claims of an ID token or an
+// introspected access token, and the role they hold. A user with none of
+// the values of role_claim is refused.
+func (o OIDCConfig) Role(claims map[string]any) (user, role string, err error) {
+	user, _ = claims[o.UserClaim].(string)
+	if user == "" {
+		user, _ = claims["sub"].(string)
+	}
+	if user == "" {
+		return "", "", errors.New("the token names no user")
+	}
+	var values []string
+	var v any = claims
+	for _, name := range strings.Split(o.RoleClaim, ".") {
+		obj, _ := v.(map[string]any)
+		v = obj[name]
+	}
+	switch v := v.(type) {
+	case string:
+		values = strings.Fields(v)
+	case []any:
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+	has := func(want []string) bool {
+		return slices.ContainsFunc(values, func(s string) bool { return slices.Contains(want, s) })
+	}
+	switch {
+	case has(o.AdminValues):
+		return user, RoleAdmin, nil
+	case has(o.ViewerValues):
+		return user, RoleViewer, nil
+	}
+	return user, "", fmt.Errorf("%s has none of the %s that give a role", user, o.RoleClaim)
+}
+
+// OIDCTokenKey returns the key the API tokens of an OIDC user with role
+// are signed with. It changes with the role and the JWT secret.
+func (c *Config) OIDCTokenKey(user, role string) []byte {
+	authMu.RLock()
+	defer authMu.RUnlock()
+	mac := hmac.New(sha256.New, []byte(c.JWTSecret))
+	mac.Write([]byte(AuthOIDC + "\x00" + user + "\x00" + role))
+	return mac.Sum(nil)
+}
+
+// ClientCertUser returns the name and role the client certificate whose
+// key has fingerprint fp calls the API as.
+func (c *Config) ClientCertUser(fp string) (string, string, bool) {
+	for _, cc := range c.APIAuth.ClientCerts {
+		if NormalFingerprint(cc.Fingerprint) == fp {
+			return cc.Name, cc.Role, true
+		}
+	}
+	return "", "", false
+}
@@ -0,0 +1,110 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for the API authentication backends
+
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func oidcConfig() OIDCConfig {
+	o := DefaultConfig().APIAuth.OIDC
+	o.Issuer = "https://login.example.org/realms/ops"
+	o.ClientID = "ipxtransporter"
+	o.RedirectURL = "https://hub.example.org:8080/api/oidc/callback"
+	o.AdminValues = []string{"hub-admins"}
+	o.ViewerValues = []string{"staff"}
+	return o
+}
+
+func TestValidateAuth(t *testing.T) {
+	fp := strings.Repeat("ab", 32)
+	for name, tc := range map[string]struct {
+		change func(c *Config)
+		ok     bool
+	}{
+		"default":           {func(c *Config) {}, true},
+		"empty means local": {func(c *Config) { c.APIAuth.HTTP = nil }, true},
+		"unknown backend":   {func(c *Config) { c.APIAuth.GRPC = []string{"ldap"} }, false},
+		"oidc": {func(c *Config) {
+			c.APIAuth.HTTP = []string{AuthLocal, AuthOIDC}
+			c.APIAuth.OIDC = oidcConfig()
+		}, true},
+		"oidc over http": {func(c *Config) {
+			c.APIAuth.HTTP = []string{AuthOIDC}
+			c.APIAuth.OIDC = oidcConfig()
+			c.APIAuth.OIDC.Issuer = "http://login.example.org"
+		}, false},
+		"oidc without roles": {func(c *Config) {
+			c.APIAuth.HTTP = []string{AuthOIDC}
+			c.APIAuth.OIDC = oidcConfig()
+			c.APIAuth.OIDC.AdminValues, c.APIAuth.OIDC.ViewerValues = nil, nil
+		}, false},
+		"oidc settings unused": {func(c *Config) { c.APIAuth.OIDC.Issuer = "not a URL" }, true},
+		"client certs": {func(c *Config) {
+			c.APIAuth.GRPC = []string{AuthClientCert}
+			c.APIAuth.ClientCerts = []APIClientCert{{Name: "deploy", Fingerprint: fp, Role: RoleAdmin}}
+		}, true},
+		"no client certs": {func(c *Config) { c.APIAuth.GRPC = []string{AuthClientCert} }, false},
+		"client certs without TLS": {func(c *Config) {
+			c.DisableSSL = true
+			c.APIAuth.GRPC = []string{AuthClientCert}
+			c.APIAuth.ClientCerts = []APIClientCert{{Name: "deploy", Fingerprint: fp, Role: RoleAdmin}}
+		}, false},
+		"HTTP client certs without a certificate": {func(c *Config) {
+			c.APIAuth.HTTP = []string{AuthClientCert}
+			c.APIAuth.ClientCerts = []APIClientCert{{Name: "deploy", Fingerprint: fp, Role: RoleAdmin}}
+		}, false},
+		"bad fingerprint": {func(c *Config) {
+			c.APIAuth.ClientCerts = []APIClientCert{{Name: "deploy", Fingerprint: "abc", Role: RoleAdmin}}
+		}, false},
+		"unknown role": {func(c *Config) {
+			c.APIAuth.ClientCerts = []APIClientCert{{Name: "deploy", Fingerprint: fp, Role: "root"}}
+		}, false},
+	} {
+		cfg := DefaultConfig()
+		tc.change(cfg)
+		if err := cfg.ValidateAuth(); (err == nil) != tc.ok {
+			t.Errorf("%s: expected ok %v, got %v", name, tc.ok, err)
+		}
+	}
+}
+
+func TestOIDCRole(t *testing.T) {
+	o := oidcConfig()
+	for _, tc := range []struct {
+		claims     map[string]any
+		user, role string
+	}{
+		{map[string]any{"sub": "1234", "preferred_username": "alice", "groups": []any{"staff", "hub-admins"}}, "alice", RoleAdmin},
+		{map[string]any{"sub": "1234", "groups": []any{"staff"}}, "1234", RoleViewer},
+		{map[string]any{"preferred_username": "bob", "groups": "staff other"}, "bob", RoleViewer},
+		{map[string]any{"preferred_username": "eve", "groups": []any{"guests"}}, "eve", ""},
+		{map[string]any{"preferred_username": "eve"}, "eve", ""},
+	} {
+		user, role, err := o.Role(tc.claims)
+		if user != tc.user || role != tc.role || (err == nil) != (tc.role != "") {
+			t.Errorf("Expected %v to be %s as %q, got %s as %q (%v)", tc.claims, tc.user, tc.role, user, role, err)
+		}
+	}
+
+	o.RoleClaim = "realm_access.roles"
+	claims := map[string]any{"preferred_username": "carol", "realm_access": map[string]any{"roles": []any{"hub-admins"}}}
+	if _, role, err := o.Role(claims); role != RoleAdmin {
+		t.Errorf("Expected a nested role claim read, got %q (%v)", role, err)
+	}
+}
+
+func TestClientCertUser(t *testing.T) {
+	cfg := DefaultConfig()
+	fp := strings.Repeat("ab", 32)
+	cfg.APIAuth.ClientCerts = []APIClientCert{{Name: "deploy", Fingerprint: strings.ToUpper(fp), Role: RoleViewer}}
+	if name, role, ok := cfg.ClientCertUser(fp); !ok || name != "deploy" || role != RoleViewer {
+		t.Errorf("Expected the certificate's user, got %q %q", name, role)
+	}
+	if _, _, ok := cfg.ClientCertUser(strings.Repeat("cd", 32)); ok {
+		t.Error("Expected an unlisted key refused")
+	}
+}
@@ -0,0 +1,165 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Atomic config writes and the backups kept beside the config file
+
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// backupStamp names a backup by when it was replaced, to the millisecond
+// so saves in quick succession each keep theirs.
+const backupStamp = "20060102-150405.000"
+
+// Backup is an earlier version of the config file.
+type Backup struct {
+	Name string    `json:"name"` // file name, in the config's directory
+	Time time.Time `json:"time"` // when it was replaced
+	Size int64     `json:"size"`
+}
+
+// writeConfigFile replaces the file at path with data without a moment
+// where it is missing or half written: data goes to a temporary file in
+// the same directory, which is renamed over path. The file it replaces is
+// kept as a backup, and the oldest backups beyond keep are removed.
+func writeConfigFile(path string, data []byte, keep int) error {
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := os.CreateTemp(dir, "."+base+".tmp-*")
+	if err != nil {
+		return err
+	}
+	// The file holds the JWT secret and API passwords.
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if _, err := tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = backupConfig(path, data, keep)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	// The rename lasts a crash once the directory is on disk; not every
+	// system can sync a directory, so it is best effort.
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// backupConfig copies the file at path aside before data replaces it,
+// unless nothing would change or the newest backup is the same already.
+func backupConfig(path string, data []byte, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	old, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(old, data) {
+		return nil
+	}
+	backups, err := ListBackups(path)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	if len(backups) > 0 {
+		if newest, err := os.ReadFile(filepath.Join(dir, backups[0].Name)); err == nil && bytes.Equal(newest, old) {
+			return nil
+		}
+	}
+	name := path + "." + time.Now().Format(backupStamp) + ".bak"
+	if err := os.WriteFile(name, old, 0600); err != nil {
+		return err
+	}
+	for _, b := range backups[min(len(backups), keep-1):] {
+		os.Remove(filepath.Join(dir, b.Name))
+	}
+	return nil
+}
+
+// ListBackups returns the backups of the config file at path, newest
+// first.
+func ListBackups(path string) ([]Backup, error) {
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var out []Backup
+	for _, e := range entries {
+		stamp, ok := strings.CutPrefix(e.Name(), base+".")
+		if !ok || e.IsDir() {
+			continue
+		}
+		if stamp, ok = strings.CutSuffix(stamp, ".bak"); !ok {
+			continue
+		}
+		when, err := time.ParseInLocation(backupStamp, stamp, time.Local)
+		if err != nil {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		out = append(out, Backup{Name: e.Name(), Time: when, Size: info.Size()})
+	}
+	slices.SortFunc(out, func(a, b Backup) int { return b.Time.Compare(a.Time) })
+	return out, nil
+}
+
+// RestoreBackup puts the backup name of the config file at path back in
+// its place. The backup must load and validate; the config it replaces
+// is backed up in turn, so a restore can be undone.
+func RestoreBackup(path, name string, keep int) error {
+	backups, err := ListBackups(path)
+	if err != nil {
+		return err
+	}
+	if !slices.ContainsFunc(backups, func(b Backup) bool { return b.Name == name }) {
+		return fmt.Errorf("no backup %q of %s", name, path)
+	}
+	data, err := os.ReadFile(filepath.Join(filepath.Dir(path), name))
+	if err != nil {
+		return err
+	}
+	cfg, err := parseConfig(name, formatOf(path), data)
+	if err != nil {
+		return err
+	}
+	if err := cfg.Validate().Err(); err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	return writeConfigFile(path, data, keep)
+}
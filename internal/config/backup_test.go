@@ -0,0 +1,129 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for atomic config writes and backups
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSaveKeepsBackups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	cfg := DefaultConfig()
+	cfg.ConfigBackups = 2
+	for i := 1; i <= 4; i++ {
+		cfg.MaxChildren = i
+		if err := SaveConfig(path, cfg); err != nil {
+			t.Fatal(err)
+		}
+		// Backups are named to the millisecond.
+		time.Sleep(2 * time.Millisecond)
+	}
+	// Saving the same config again makes no backup.
+	if err := SaveConfig(path, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	backups, err := ListBackups(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("Expected 2 backups, got %+v", backups)
+	}
+	if !backups[0].Time.After(backups[1].Time) {
+		t.Errorf("Expected the newest backup first, got %+v", backups)
+	}
+	for i, want := range []int{3, 2} {
+		got, err := LoadConfig(filepath.Join(filepath.Dir(path), backups[i].Name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.MaxChildren != want {
+			t.Errorf("Expected backup %d to hold max_children %d, got %d", i, want, got.MaxChildren)
+		}
+	}
+
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	for _, e := range entries {
+		if strings.Contains(e.Name(), ".tmp-") {
+			t.Errorf("Expected no temporary file left, found %s", e.Name())
+		}
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the config mode 0600, got %v", info.Mode().Perm())
+	}
+}
+
+func TestSaveWithoutBackups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	cfg := DefaultConfig()
+	cfg.ConfigBackups = 0
+	for i := 1; i <= 2; i++ {
+		cfg.MaxChildren = i
+		if err := SaveConfig(path, cfg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if backups, _ := ListBackups(path); len(backups) != 0 {
+		t.Errorf("Expected no backups, got %+v", backups)
+	}
+}
+
+func TestRestoreBackup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "node.yaml")
+	cfg := DefaultConfig()
+	cfg.MaxChildren = 7
+	if err := SaveConfig(path, cfg); err != nil {
+		t.Fatal(err)
+	}
+	cfg.MaxChildren = 9
+	if err := SaveConfig(path, cfg); err != nil {
+		t.Fatal(err)
+	}
+	backups, _ := ListBackups(path)
+	if len(backups) != 1 {
+		t.Fatalf("Expected 1 backup, got %+v", backups)
+	}
+
+	time.Sleep(2 * time.Millisecond)
+	if err := RestoreBackup(path, backups[0].Name, 5); err != nil {
+		t.Fatal(err)
+	}
+	got, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.MaxChildren != 7 {
+		t.Errorf("Expected the restored max_children 7, got %d", got.MaxChildren)
+	}
+	// The config the restore replaced is kept, so it can be undone.
+	backups, _ = ListBackups(path)
+	if len(backups) != 2 {
+		t.Fatalf("Expected 2 backups after the restore, got %+v", backups)
+	}
+	data, _ := os.ReadFile(filepath.Join(filepath.Dir(path), backups[0].Name))
+	undo, _ := parseConfig(backups[0].Name, formatYAML, data)
+	if undo == nil || undo.MaxChildren != 9 {
+		t.Errorf("Expected the replaced config backed up, got %+v", undo)
+	}
+
+	if err := RestoreBackup(path, "../config.json", 5); err == nil {
+		t.Error("Expected an unknown backup refused")
+	}
+	bad := path + "." + time.Now().Format(backupStamp) + ".bak"
+	if err := os.WriteFile(bad, []byte("max_children: many\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := RestoreBackup(path, filepath.Base(bad), 5); err == nil {
+		t.Error("Expected a backup that does not load refused")
+	}
+	if got, _ := LoadConfig(path); got == nil || got.MaxChildren != 7 {
+		t.Errorf("Expected the config untouched by a failed restore, got %+v", got)
+	}
+}
@@ -5,76 +5,393 @@
 package config
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
+
+	"github.com/mlapointe/ipxtransporter/internal/rooms"
+	"github.com/mlapointe/ipxtransporter/internal/rules"
+	"github.com/mlapointe/ipxtransporter/internal/schedule"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
 )
 
 type Config struct {
-	Interface         string   `json:"interface"`
-	ListenAddr        string   `json:"listen_addr"`
-	Peers             []string `json:"peers"`
-	TLSCertPath       string   `json:"tls_cert_path"`
-	TLSKeyPath        string   `json:"tls_key_path"`
-	DisableSSL        bool     `json:"disable_ssl"`
-	HTTPListenAddr    string   `json:"http_listen_addr"`
-	EnableHTTP        bool     `json:"enable_http"`
-	LogLevel          string   `json:"log_level"`
-	DedupCacheSize    int      `json:"dedup_cache_size"`
-	DedupCacheTTL     int      `json:"dedup_cache_ttl"`
-	SortField         string   `json:"sort_field"`
-	SortReverse       bool     `json:"sort_reverse"`
-	BannedHosts       []string `json:"banned_hosts"`
-	BannedIDs         []string `json:"banned_ids"`
-	AdminUser         string   `json:"admin_user"`
-	AdminPass         string   `json:"admin_pass"`
-	MaxChildren       int      `json:"max_children"`
-	NetworkKey        string   `json:"network_key"`
-	RebalanceEnabled  bool     `json:"rebalance_enabled"`
-	RebalanceInterval int      `json:"rebalance_interval"` // in seconds
-	JWTSecret         string   `json:"jwt_secret"`
+	Profile           string            `json:"profile"` // bundle of settings for the node's role, see Profiles
+	Interface         string            `json:"interface"`
+	CaptureFilter     string            `json:"capture_filter"` // BPF filter of the capture, empty for ipx
+	ListenAddr        string            `json:"listen_addr"`
+	Peers             []PeerConfig      `json:"peers"`
+	TLSCertPath       string            `json:"tls_cert_path"`
+	TLSKeyPath        string            `json:"tls_key_path"`
+	DisableSSL        bool              `json:"disable_ssl"`
+	ACME              ACMEConfig        `json:"acme"` // certificates for the peer listener from an ACME CA, instead of tls_cert_path
+	HTTPListenAddr    string            `json:"http_listen_addr"`
+	EnableHTTP        bool              `json:"enable_http"`
+	LogLevel          string            `json:"log_level"`
+	LogSyslog         string            `json:"log_syslog"`
+	LogJournald       bool              `json:"log_journald"`
+	DedupCacheSize    int               `json:"dedup_cache_size"`
+	DedupCacheTTL     int               `json:"dedup_cache_ttl"`
+	SortField         string            `json:"sort_field"`
+	SortReverse       bool              `json:"sort_reverse"`
+	BannedHosts       []string          `json:"banned_hosts"` // IP addresses, CIDR ranges and host names, *.example.net for a domain
+	BannedIDs         []string          `json:"banned_ids"`
+	AllowList         bool              `json:"allow_list"`    // only allowed_hosts, allowed_ids and configured peers may link
+	AllowedHosts      []string          `json:"allowed_hosts"` // patterns as banned_hosts takes
+	AllowedIDs        []string          `json:"allowed_ids"`   // peer IDs or node IDs
+	AdminUser         string            `json:"admin_user"`
+	AdminPass         string            `json:"admin_pass"`
+	AdminPassFile     string            `json:"admin_pass_file"` // file holding admin_pass, see loadSecrets
+	APIUsers          []APIUser         `json:"api_users"`       // logins beside admin_user, with roles
+	APIAuth           APIAuthConfig     `json:"api_auth"`        // how callers of the HTTP and gRPC APIs authenticate
+	MaxChildren       int               `json:"max_children"`
+	NetworkKey        string            `json:"network_key"`
+	NetworkKeyFile    string            `json:"network_key_file"`
+	RebalanceEnabled  bool              `json:"rebalance_enabled"`
+	RebalanceInterval int               `json:"rebalance_interval"` // in seconds
+	JWTSecret         string            `json:"jwt_secret"`         // generated on first run if empty
+	JWTSecretFile     string            `json:"jwt_secret_file"`
+	TokenTTL          int               `json:"token_ttl"`   // in seconds
+	SessionTTL        int               `json:"session_ttl"` // in seconds, how long tokens can be refreshed
+	InjectWorkers     int               `json:"inject_workers"`
+	BroadcastWorkers  int               `json:"broadcast_workers"`
+	WriterWorkers     int               `json:"writer_workers"`   // 0 picks from the CPU count
+	PeerQueueBytes    int               `json:"peer_queue_bytes"` // send queue budget per peer
+	FilterRules       []rules.Rule      `json:"filter_rules"`
+	PriorityRules     []rules.Rule      `json:"priority_rules"`
+	EchoSuppression   bool              `json:"echo_suppression"`
+	MACTableTTL       int               `json:"mac_table_ttl"` // in seconds
+	HubElection       bool              `json:"hub_election"`
+	HubPriority       int               `json:"hub_priority"`       // 0 never becomes hub
+	HubFailoverDelay  int               `json:"hub_failover_delay"` // in seconds
+	Gossip            bool              `json:"gossip"`
+	Advertise         bool              `json:"advertise"` // let peers gossip our address
+	MaxAutoPeers      int               `json:"max_auto_peers"`
+	GossipApproval    bool              `json:"gossip_approval"`  // hold gossiped addresses for manual approval
+	GossipTrust       map[string]string `json:"gossip_trust"`     // source node ID or IP -> auto, approve or ignore
+	PeerTrust         map[string]string `json:"peer_trust"`       // key fingerprint or IP -> unknown, known or trusted
+	TrustDefaults     map[string]string `json:"trust_defaults"`   // configured, auto or inbound -> trust level
+	PadPolicy         map[string]string `json:"pad_policy"`       // configured, auto or inbound -> off, buckets or fixed
+	HandshakePolicy   HandshakePolicies `json:"handshake_policy"` // configured, auto or inbound -> what their hellos must negotiate
+	RelayAssist       bool              `json:"relay_assist"`     // forward traffic between peers that cannot link directly
+	Schedules         []schedule.Entry  `json:"schedules"`
+	IPXNetListenAddr  string            `json:"ipxnet_listen_addr"` // UDP, DOSBox IPXNET protocol
+	AssignAddresses   bool              `json:"assign_addresses"`
+	VirtualNetwork    string            `json:"virtual_network"`    // hex, assigned to virtual clients
+	LegacyIPXNet      string            `json:"legacy_ipxnet"`      // DOSBox IPXNET server to bridge in as a client
+	MDNS              bool              `json:"mdns"`               // advertise and discover nodes on the LAN
+	SocketBridgeAddr  string            `json:"socket_bridge_addr"` // TCP and UDP, QEMU socket networking
+	PortMapping       bool              `json:"port_mapping"`       // map the listen port via UPnP/NAT-PMP
+	Room              *rooms.Room       `json:"room,omitzero"`      // current room, replaces peers and network key
+	RoomTracker       string            `json:"room_tracker"`       // URL of a tracker to list public rooms on
+	Tracker           bool              `json:"tracker"`            // serve a room tracker on the HTTP API
+	ExportPath        string            `json:"export_path"`        // SQLite export written periodically
+	ExportInterval    int               `json:"export_interval"`    // in minutes
+	HistoryResolution int               `json:"history_resolution"` // in seconds
+	HistoryRetention  int               `json:"history_retention"`  // in minutes
+	StatsLogInterval  int               `json:"stats_log_interval"` // seconds between traffic summaries logged without the TUI, 0 never
+	PeerTable         []string          `json:"peer_table"`         // built-in columns of the TUI peer table in order, empty for DefaultPeerTable
+	PeerRateWarn      int               `json:"peer_rate_warn"`     // bytes per second a peer's live rate shows yellow from, 0 never
+	PeerRateAlert     int               `json:"peer_rate_alert"`    // bytes per second it shows red from, 0 never
+	PeerColumns       []stats.Column    `json:"peer_columns"`       // custom columns of the peer table
+	PeerNotes         map[string]string `json:"peer_notes"`         // peer ID, node ID or IP -> note
+	SocketNames       map[string]string `json:"socket_names"`       // hex IPX socket -> protocol or game, over the built-in names
+	Accessible        bool              `json:"accessible"`         // screen-reader friendly TUI
+	Theme             string            `json:"theme"`              // TUI colors, one of Themes; empty is the default
+	Beacon            bool              `json:"beacon"`             // announce the relay on the local segment
+	BeaconInterval    int               `json:"beacon_interval"`    // in seconds
+	SegmentReport     string            `json:"segment_report"`     // off, summary or full
+	RemoteCapture     bool              `json:"remote_capture"`     // record when another node starts a mesh capture
+	CaptureDir        string            `json:"capture_dir"`        // where capture files go, "" for the working directory
+	CaptureMaxBytes   int               `json:"capture_max_bytes"`  // size limit of one capture file
+	APIRateLimit      float64           `json:"api_rate_limit"`     // requests per second per client address, 0 for no limit
+	APIRateBurst      int               `json:"api_rate_burst"`
+	LoginMaxFailures  int               `json:"login_max_failures"` // failed logins before an address is locked out
+	LoginLockout      int               `json:"login_lockout"`      // seconds of the first lockout, doubling with each further failure
+	AuthFailMax       int               `json:"auth_fail_max"`      // failed peer handshakes from an address before it is banned, 0 never bans
+	AuthFailWindow    int               `json:"auth_fail_window"`   // seconds the failures are counted over
+	AuthFailBan       int               `json:"auth_fail_ban"`      // seconds the address is banned for
+	Trace             []TraceFlow       `json:"trace"`              // frames sent with a relay trace, for debugging
+	Tracing           bool              `json:"tracing"`            // send the frames of the trace flows with a relay trace
+	ReplicateConfig   bool              `json:"replicate_config"`   // mirror peers, bans, labels and rules to trusted standby hubs
+	StandbyOf         string            `json:"standby_of"`         // address of the primary hub this node is a warm standby for
+	ReplicaPeers      []string          `json:"replica_peers"`      // standby: the primary's peers, dialed once it is lost
+	SelfHeal          SelfHealPolicy    `json:"self_heal"`
+	LocalNetworks     []string          `json:"local_networks"`      // hex IPX networks declared to peers beside those learned
+	StrictNetworks    bool              `json:"strict_networks"`     // drop frames from networks a peer did not declare
+	GRPCListenAddr    string            `json:"grpc_listen_addr"`    // gRPC control API, empty disables it
+	ControlSocket     string            `json:"control_socket"`      // unix socket serving the HTTP API without tokens
+	ControlSocketMode string            `json:"control_socket_mode"` // octal permissions of ControlSocket
+	AuditLog          string            `json:"audit_log"`           // file inbound connection attempts are appended to, as JSON lines
+	CommunityStats    bool              `json:"community_stats"`     // opt in to submitting coarse usage aggregates daily
+	CommunityStatsURL string            `json:"community_stats_url"` // endpoint they are posted to
+	CommunityCountry  string            `json:"community_country"`   // ISO country code reported, empty for none
+	Resolver          ResolverConfig    `json:"resolver"`            // how peer host names are resolved
+	FlowExport        FlowExportConfig  `json:"flow_export"`         // flow records sent to an IPFIX or NetFlow v9 collector
+	ConfigBackups     int               `json:"config_backups"`      // earlier versions of the config file kept beside it
+
+	external map[string]string // secrets read from elsewhere -> what the file keeps, see loadSecrets
 }
 
+// TraceFlow selects the frames sent with a relay trace: those to or from
+// Socket and station Node (a MAC address). An empty field matches any.
+type TraceFlow struct {
+	Socket uint16 `json:"socket"`
+	Node   string `json:"node"`
+}
+
+// SelfHealPolicy says when the relay repairs itself: restarting capture
+// after injection keeps failing, restarting dialers that cannot link, and
+// a daily restart at a quiet hour. Zero or empty turns an action off.
+type SelfHealPolicy struct {
+	InjectFailures int    `json:"inject_failures"` // consecutive injection failures before capture is restarted
+	DialerStuck    int    `json:"dialer_stuck"`    // seconds a dialer may go without a link before it is restarted
+	RestartAt      string `json:"restart_at"`      // local time of day, HH:MM
+	RestartDrain   int    `json:"restart_drain"`   // seconds links get to flush before the restart
+}
+
+// HandshakePolicy is what the hellos of a link must negotiate for the
+// link to be kept.
+type HandshakePolicy struct {
+	MinProtocol int      `json:"min_protocol"` // lowest protocol version, 0 for any
+	Require     []string `json:"require"`      // features: padding, traces, declares or signed
+}
+
+// HandshakePolicies holds the HandshakePolicy of each peer group.
+type HandshakePolicies map[string]HandshakePolicy
+
+// ResolverConfig selects how peer host names are resolved: by the system
+// resolver, by the DNS servers listed, or over DNS-over-HTTPS. Hosts maps
+// names to addresses ahead of any of them.
+type ResolverConfig struct {
+	Mode    string              `json:"mode"`    // system, dns or doh
+	Servers []string            `json:"servers"` // dns: host:port of each server, tried in order
+	DoHURL  string              `json:"doh_url"` // doh: RFC 8484 endpoint, e.g. https://dns.quad9.net/dns-query
+	Hosts   map[string][]string `json:"hosts"`   // name -> addresses
+}
+
+// ACMEConfig obtains the certificate of the peer listener for Domains
+// from an ACME CA, Let's Encrypt unless DirectoryURL names another, and
+// renews it. Setting Domains accepts the CA's terms of service.
+type ACMEConfig struct {
+	Domains      []string `json:"domains"`       // public DNS names of the node, empty disables ACME
+	Email        string   `json:"email"`         // contact for expiry notices
+	Challenge    string   `json:"challenge"`     // http-01, tls-alpn-01 or dns-01
+	HTTPAddr     string   `json:"http_addr"`     // http-01: where the CA's requests are answered, reached on port 80
+	DNSHook      string   `json:"dns_hook"`      // dns-01: command run as HOOK present|cleanup FQDN VALUE
+	CacheDir     string   `json:"cache_dir"`     // where the account key and certificates are kept
+	DirectoryURL string   `json:"directory_url"` // ACME directory, empty for Let's Encrypt
+}
+
+// FlowExportConfig sends a flow record for each IPX source and destination
+// pair and the link its packets came in on to Collector over UDP. A flow
+// is exported once it has been idle IdleTimeout seconds, and every
+// ActiveTimeout seconds while it lasts.
+type FlowExportConfig struct {
+	Collector     string `json:"collector"`      // host:port, empty disables export
+	Protocol      string `json:"protocol"`       // ipfix or netflow9
+	ActiveTimeout int    `json:"active_timeout"` // in seconds
+	IdleTimeout   int    `json:"idle_timeout"`   // in seconds
+	DomainID      uint32 `json:"domain_id"`      // IPFIX observation domain or NetFlow v9 source ID
+}
+
+// Themes are the color schemes of the TUI, for theme.
+var Themes = []string{"default", "light", "monochrome", "high-contrast"}
+
+// PeerTableColumns are the built-in columns of the TUI peer table, for
+// peer_table, and DefaultPeerTable those it shows unless that is set.
+var (
+	PeerTableColumns = []string{"id", "ip", "hostname", "connected", "last_seen", "sent_bytes", "recv_bytes",
+		"sent_pkts", "recv_pkts", "errors", "muted", "stations", "latency", "country", "rx_rate", "tx_rate"}
+	DefaultPeerTable = []string{"id", "ip", "hostname", "connected", "last_seen", "tx_rate", "rx_rate",
+		"sent_bytes", "recv_bytes", "sent_pkts", "recv_pkts", "errors", "muted", "stations"}
+)
+
 func DefaultConfig() *Config {
 	return &Config{
-		Interface:         "",
-		ListenAddr:        ":8787",
-		Peers:             []string{},
-		DisableSSL:        false,
-		HTTPListenAddr:    ":8080",
-		EnableHTTP:        true,
-		LogLevel:          "info",
-		DedupCacheSize:    64000,
-		DedupCacheTTL:     30,
-		SortField:         "id",
-		SortReverse:       false,
-		BannedHosts:       []string{},
-		BannedIDs:         []string{},
-		AdminUser:         "admin",
-		AdminPass:         "admin",
+		Profile:        "",
+		Interface:      "",
+		CaptureFilter:  "ipx",
+		ListenAddr:     ":8787",
+		Peers:          []PeerConfig{},
+		DisableSSL:     false,
+		ACME:           ACMEConfig{Domains: []string{}, Challenge: "http-01", HTTPAddr: ":80", CacheDir: "acme"},
+		HTTPListenAddr: ":8080",
+		EnableHTTP:     true,
+		LogLevel:       "info",
+		DedupCacheSize: 64000,
+		DedupCacheTTL:  30,
+		SortField:      "id",
+		SortReverse:    false,
+		BannedHosts:    []string{},
+		BannedIDs:      []string{},
+		AllowedHosts:   []string{},
+		AllowedIDs:     []string{},
+		AdminUser:      "admin",
+		AdminPass:      "admin",
+		APIUsers:       []APIUser{},
+		APIAuth: APIAuthConfig{
+			HTTP:        []string{AuthLocal},
+			GRPC:        []string{AuthLocal},
+			OIDC:        OIDCConfig{Scopes: []string{"profile"}, UserClaim: "preferred_username", RoleClaim: "groups", AdminValues: []string{}, ViewerValues: []string{}},
+			ClientCerts: []APIClientCert{},
+		},
 		MaxChildren:       5,
 		NetworkKey:        "",
 		RebalanceEnabled:  true,
 		RebalanceInterval: 30,
-		JWTSecret:         "secret-jwt-key",
+		JWTSecret:         "",
+		TokenTTL:          900,
+		SessionTTL:        86400,
+		InjectWorkers:     1,
+		BroadcastWorkers:  2,
+		PeerQueueBytes:    256 * 1024,
+		FilterRules:       []rules.Rule{},
+		PriorityRules:     []rules.Rule{},
+		EchoSuppression:   true,
+		MACTableTTL:       300,
+		HubElection:       false,
+		HubPriority:       100,
+		HubFailoverDelay:  30,
+		Gossip:            false,
+		Advertise:         true,
+		MaxAutoPeers:      3,
+		GossipApproval:    false,
+		GossipTrust:       map[string]string{},
+		PeerTrust:         map[string]string{},
+		TrustDefaults:     map[string]string{"configured": "known", "auto": "known", "inbound": "known"},
+		PadPolicy:         map[string]string{},
+		HandshakePolicy:   HandshakePolicies{},
+		RelayAssist:       false,
+		Schedules:         []schedule.Entry{},
+		IPXNetListenAddr:  "",
+		AssignAddresses:   false,
+		VirtualNetwork:    "",
+		LegacyIPXNet:      "",
+		MDNS:              false,
+		SocketBridgeAddr:  "",
+		PortMapping:       false,
+		RoomTracker:       "",
+		Tracker:           false,
+		ExportPath:        "",
+		ExportInterval:    60,
+		HistoryResolution: 60,
+		HistoryRetention:  24 * 60,
+		StatsLogInterval:  60,
+		PeerTable:         []string{},
+		PeerRateWarn:      256 * 1024,
+		PeerRateAlert:     1024 * 1024,
+		PeerColumns:       []stats.Column{},
+		PeerNotes:         map[string]string{},
+		SocketNames:       map[string]string{},
+		Accessible:        false,
+		Theme:             "default",
+		Beacon:            false,
+		BeaconInterval:    30,
+		SegmentReport:     "off",
+		RemoteCapture:     false,
+		CaptureDir:        "",
+		CaptureMaxBytes:   8 << 20,
+		APIRateLimit:      20,
+		APIRateBurst:      60,
+		LoginMaxFailures:  5,
+		LoginLockout:      60,
+		AuthFailMax:       5,
+		AuthFailWindow:    600,
+		AuthFailBan:       3600,
+		Trace:             []TraceFlow{},
+		Tracing:           true,
+		ReplicateConfig:   false,
+		StandbyOf:         "",
+		ReplicaPeers:      []string{},
+		SelfHeal:          SelfHealPolicy{InjectFailures: 20, RestartDrain: 10},
+		LocalNetworks:     []string{},
+		StrictNetworks:    false,
+		GRPCListenAddr:    "",
+		ControlSocket:     "",
+		ControlSocketMode: "0660",
+		AuditLog:          "",
+		CommunityStats:    false,
+		CommunityStatsURL: "",
+		CommunityCountry:  "",
+		Resolver:          ResolverConfig{Mode: "system", Servers: []string{}, Hosts: map[string][]string{}},
+		FlowExport:        FlowExportConfig{Protocol: "ipfix", ActiveTimeout: 60, IdleTimeout: 15},
+		ConfigBackups:     5,
 	}
 }
 
 func LoadConfig(path string) (*Config, error) {
-	cfg := DefaultConfig()
 	data, err := os.ReadFile(path)
 	if err != nil {
-		return cfg, err
+		return DefaultConfig(), err
+	}
+	return parseConfig(path, formatOf(path), data)
+}
+
+// parseConfig reads the config in data, of format f, from the file name.
+func parseConfig(name string, f format, data []byte) (*Config, error) {
+	cfg := DefaultConfig()
+	var err error
+	if f != formatJSON {
+		err = f.decode(name, data, cfg)
+	} else if err = json.Unmarshal(data, cfg); err != nil {
+		err = jsonError(name, data, err)
 	}
-	if err := json.Unmarshal(data, cfg); err != nil {
+	if err != nil {
 		return nil, err
 	}
+	if err := cfg.loadSecrets(); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
 	return cfg, nil
 }
 
+// jsonError says where in the file a decoding error is, and which setting
+// has the wrong type.
+func jsonError(path string, data []byte, err error) error {
+	var offset int64
+	var syntax *json.SyntaxError
+	var typ *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntax):
+		offset = syntax.Offset
+	case errors.As(err, &typ):
+		offset = typ.Offset
+		err = fmt.Errorf("%s: %s given, %s wanted", typ.Field, typ.Value, typ.Type)
+	default:
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	before := data[:min(int(offset), len(data))]
+	line := bytes.Count(before, []byte("\n")) + 1
+	col := len(before) - bytes.LastIndexByte(before, '\n')
+	return fmt.Errorf("%s:%d:%d: %v", path, line, col, err)
+}
+
 func SaveConfig(path string, cfg *Config) error {
-	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err := cfg.Validate().Err(); err != nil {
+		return err
+	}
+	// Passwords are never written in the clear.
+	if _, err := cfg.HashPasswords(); err != nil {
+		return err
+	}
+	// Secrets read from elsewhere are written as where they came from.
+	authMu.RLock()
+	out := *cfg.withoutProfile()
+	authMu.RUnlock()
+	out.hideSecrets()
+	data, err := json.MarshalIndent(&out, "", "  ")
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(path, data, 0644)
+	if f := formatOf(path); f != formatJSON {
+		// The comments of the file written over are kept.
+		old, _ := os.ReadFile(path)
+		if data, err = f.encode(data, old); err != nil {
+			return err
+		}
+	}
+	return writeConfigFile(path, data, cfg.ConfigBackups)
 }
@@ -0,0 +1,477 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Config file formats: JSON, YAML and TOML, told apart by extension
+
+package config
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// format is the syntax of a config file. YAML and TOML files are read into
+// a document of nodes, turned into JSON against the Config type and
+// decoded as JSON files are, so every format takes the same settings.
+type format int
+
+const (
+	formatJSON format = iota
+	formatYAML
+	formatTOML
+)
+
+// formatOf tells the format of path from its extension; anything but
+// .yaml, .yml and .toml is JSON.
+func formatOf(path string) format {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return formatYAML
+	case ".toml":
+		return formatTOML
+	}
+	return formatJSON
+}
+
+// A document is made of nodes: *object, []any, scalar or nil for null.
+type object struct {
+	members []member
+}
+
+type member struct {
+	key string
+	val any
+}
+
+func (o *object) get(key string) (any, bool) {
+	for _, m := range o.members {
+		if m.key == key {
+			return m.val, true
+		}
+	}
+	return nil, false
+}
+
+// set replaces the value of key, or adds it.
+func (o *object) set(key string, v any) {
+	for i := range o.members {
+		if o.members[i].key == key {
+			o.members[i].val = v
+			return
+		}
+	}
+	o.members = append(o.members, member{key, v})
+}
+
+// scalar is a value as written. Quoted scalars are always strings; the
+// type of the others depends on the setting they are given for, so
+// "network: 12345" works for a string setting as for a number.
+type scalar struct {
+	text   string
+	quoted bool
+	line   int
+}
+
+// withoutBOM drops the byte order mark some Windows editors start a file
+// with, which is not part of its first line.
+func withoutBOM(data []byte) string {
+	return strings.TrimPrefix(string(data), "\ufeff")
+}
+
+// syntaxError is a mistake at a line of a YAML or TOML file.
+type syntaxError struct {
+	line int
+	msg  string
+}
+
+func (e *syntaxError) Error() string {
+	return fmt.Sprintf("%d: %s", e.line, e.msg)
+}
+
+func errorAt(line int, format string, args ...any) error {
+	return &syntaxError{line, fmt.Sprintf(format, args...)}
+}
+
+// decode reads a YAML or TOML file into cfg.
+func (f format) decode(path string, data []byte, cfg *Config) error {
+	var doc any
+	var err error
+	if f == formatYAML {
+		doc, err = parseYAML(data)
+	} else {
+		doc, err = parseTOML(data)
+	}
+	if err == nil {
+		if _, ok := doc.(*object); !ok && doc != nil {
+			err = errorAt(1, "the config must be a mapping of settings")
+		}
+	}
+	var v any
+	if err == nil {
+		v, err = plain(doc, reflect.TypeOf(cfg).Elem(), "")
+	}
+	if err != nil {
+		return fmt.Errorf("%s:%v", path, err)
+	}
+	raw, err := json.Marshal(v)
+	if err == nil {
+		err = json.Unmarshal(raw, cfg)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
+
+// encode turns the JSON of a config into f, keeping the comments of old,
+// the file it replaces.
+func (f format) encode(data, old []byte) ([]byte, error) {
+	doc, err := tree(json.NewDecoder(bytes.NewReader(data)))
+	if err != nil {
+		return nil, err
+	}
+	root, ok := doc.(*object)
+	if !ok {
+		return nil, fmt.Errorf("config is not an object")
+	}
+	if f == formatYAML {
+		return encodeYAML(root, scanComments(old, yamlTopKey)), nil
+	}
+	return encodeTOML(root, scanComments(old, tomlTopKey)), nil
+}
+
+// tree reads JSON into nodes, keeping the order of the keys.
+func tree(dec *json.Decoder) (any, error) {
+	dec.UseNumber()
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok := tok.(type) {
+	case json.Delim:
+		if tok == '{' {
+			o := &object{}
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				v, err := tree(dec)
+				if err != nil {
+					return nil, err
+				}
+				o.members = append(o.members, member{key.(string), v})
+			}
+			_, err := dec.Token()
+			return o, err
+		}
+		list := []any{}
+		for dec.More() {
+			v, err := tree(dec)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		_, err := dec.Token()
+		return list, err
+	case string:
+		return scalar{text: tok, quoted: true}, nil
+	case json.Number:
+		return scalar{text: tok.String()}, nil
+	case bool:
+		return scalar{text: strconv.FormatBool(tok)}, nil
+	}
+	return nil, nil
+}
+
+var textUnmarshaler = reflect.TypeFor[encoding.TextUnmarshaler]()
+
+// plain turns a node into what encoding/json makes of JSON, typing its
+// scalars by t, the type of the setting it is for (nil if unknown).
+func plain(n any, t reflect.Type, name string) (any, error) {
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch n := n.(type) {
+	case scalar:
+		return n.value(t, name)
+	case []any:
+		var elem reflect.Type
+		if t != nil && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+			elem = t.Elem()
+		}
+		out := make([]any, len(n))
+		for i, v := range n {
+			var err error
+			if out[i], err = plain(v, elem, fmt.Sprintf("%s[%d]", name, i)); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	case *object:
+		out := make(map[string]any, len(n.members))
+		for _, m := range n.members {
+			key := m.key
+			if name != "" {
+				key = name + "." + m.key
+			}
+			var err error
+			if out[m.key], err = plain(m.val, fieldType(t, m.key), key); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	}
+	return nil, nil
+}
+
+// fieldType is the type of the value key holds in a t.
+func fieldType(t reflect.Type, key string) reflect.Type {
+	if t == nil {
+		return nil
+	}
+	switch t.Kind() {
+	case reflect.Map:
+		return t.Elem()
+	case reflect.Struct:
+		for i := range t.NumField() {
+			f := t.Field(i)
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "" {
+				name = f.Name
+			}
+			if name != "-" && strings.EqualFold(name, key) {
+				return f.Type
+			}
+		}
+	}
+	return nil
+}
+
+var jsonNumber = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+
+// number reads an integer or float as YAML and TOML write them, with
+// underscores and 0x, 0o and 0b prefixes, into a JSON number.
+func number(s string) (json.Number, bool) {
+	s = strings.ReplaceAll(strings.TrimPrefix(s, "+"), "_", "")
+	if i, err := strconv.ParseInt(s, 0, 64); err == nil {
+		return json.Number(strconv.FormatInt(i, 10)), true
+	}
+	if u, err := strconv.ParseUint(s, 0, 64); err == nil {
+		return json.Number(strconv.FormatUint(u, 10)), true
+	}
+	if jsonNumber.MatchString(s) {
+		return json.Number(s), true
+	}
+	return "", false
+}
+
+// boolean reads true and false, and the yes, no, on and off of YAML 1.1
+// that Ansible still writes.
+func boolean(s string) (value, ok bool) {
+	switch strings.ToLower(s) {
+	case "true", "yes", "on":
+		return true, true
+	case "false", "no", "off":
+		return false, true
+	}
+	return false, false
+}
+
+func isNull(s string) bool {
+	switch s {
+	case "", "~", "null", "Null", "NULL":
+		return true
+	}
+	return false
+}
+
+func (s scalar) value(t reflect.Type, name string) (any, error) {
+	if s.quoted {
+		return s.text, nil
+	}
+	if isNull(s.text) {
+		return nil, nil
+	}
+	if t == nil {
+		if s.text == "true" || s.text == "false" {
+			return s.text == "true", nil
+		}
+		if n, ok := number(s.text); ok {
+			return n, nil
+		}
+		return s.text, nil
+	}
+	if t.Kind() == reflect.String || reflect.PointerTo(t).Implements(textUnmarshaler) {
+		return s.text, nil
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		if b, ok := boolean(s.text); ok {
+			return b, nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		if n, ok := number(s.text); ok {
+			return n, nil
+		}
+	default:
+		return s.text, nil
+	}
+	return nil, errorAt(s.line, "%s: %s given, %s wanted", name, s.text, t)
+}
+
+// quote writes s as a double-quoted string, which YAML and TOML escape
+// alike.
+func quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&b, `\u%04X`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// unescape decodes the escapes of a double-quoted YAML or TOML string.
+func unescape(s string, line int) (string, error) {
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		if i == len(s) {
+			return "", errorAt(line, "string ends in a backslash")
+		}
+		switch c := s[i]; c {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case 'r':
+			b.WriteByte('\r')
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'e':
+			b.WriteByte(0x1b)
+		case '0':
+			b.WriteByte(0)
+		case '"', '\\', '/', '\'', ' ':
+			b.WriteByte(c)
+		case 'x', 'u', 'U':
+			n := map[byte]int{'x': 2, 'u': 4, 'U': 8}[c]
+			if i+n >= len(s) {
+				return "", errorAt(line, "short \\%c escape", c)
+			}
+			r, err := strconv.ParseUint(s[i+1:i+1+n], 16, 32)
+			if err != nil {
+				return "", errorAt(line, "bad \\%c escape", c)
+			}
+			b.WriteRune(rune(r))
+			i += n
+		default:
+			return "", errorAt(line, "unknown escape \\%c", c)
+		}
+	}
+	return b.String(), nil
+}
+
+// comments are the comment lines of a YAML or TOML file, kept when the
+// config is saved over it: those at the top, those above each top-level
+// setting and those at the end. Comments within a setting are not kept.
+type comments struct {
+	header   []string
+	above    map[string][]string
+	trailing []string
+}
+
+// scanComments collects the comments of data, topKey telling which lines
+// start a top-level setting and of which name.
+func scanComments(data []byte, topKey func(line string) (string, bool)) comments {
+	c := comments{above: make(map[string][]string)}
+	var block []string
+	seenKey := false
+	for line := range strings.Lines(withoutBOM(data)) {
+		t := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(t, "#"):
+			block = append(block, t)
+			continue
+		case t == "":
+			// Comments set off from the first setting head the file.
+			if !seenKey && len(block) > 0 {
+				if len(c.header) > 0 {
+					c.header = append(c.header, "")
+				}
+				c.header = append(c.header, block...)
+			}
+		default:
+			if key, ok := topKey(strings.TrimRight(line, "\r\n")); ok {
+				seenKey = true
+				if _, done := c.above[key]; !done && len(block) > 0 {
+					c.above[key] = block
+				}
+			}
+		}
+		block = nil
+	}
+	c.trailing = block
+	return c
+}
+
+func (c comments) writeHeader(b *bytes.Buffer) {
+	if len(c.header) > 0 {
+		writeLines(b, c.header)
+		b.WriteByte('\n')
+	}
+}
+
+func (c comments) writeAbove(b *bytes.Buffer, key string) {
+	writeLines(b, c.above[key])
+}
+
+func (c comments) writeTrailing(b *bytes.Buffer) {
+	if len(c.trailing) > 0 {
+		b.WriteByte('\n')
+		writeLines(b, c.trailing)
+	}
+}
+
+func writeLines(b *bytes.Buffer, lines []string) {
+	for _, l := range lines {
+		b.WriteString(l)
+		b.WriteByte('\n')
+	}
+}
@@ -0,0 +1,331 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for YAML and TOML config files
+
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/mlapointe/ipxtransporter/internal/rules"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// checkLoaded checks the settings both TestLoadYAML and TestLoadTOML give.
+func checkLoaded(t *testing.T, cfg *Config) {
+	t.Helper()
+	if cfg.ListenAddr != ":9000" || cfg.NetworkKey != "12345" || cfg.MaxChildren != 16 {
+		t.Errorf("Expected the scalars read, got %q, %q and %d", cfg.ListenAddr, cfg.NetworkKey, cfg.MaxChildren)
+	}
+	if !cfg.AllowList || cfg.Gossip {
+		t.Errorf("Expected allow_list on and gossip off, got %v and %v", cfg.AllowList, cfg.Gossip)
+	}
+	// The old form of a peer, its address alone, and a block.
+	want := []PeerConfig{{Addr: "203.0.113.5:8787"}, {Addr: "relay.example.net:8787", Name: "Relay", Compression: true}}
+	if !reflect.DeepEqual(cfg.Peers, want) {
+		t.Errorf("Expected two peers, got %+v", cfg.Peers)
+	}
+	if len(cfg.APIUsers) != 2 || cfg.APIUsers[1].Name != "ops" || cfg.APIUsers[1].Role != "viewer" {
+		t.Errorf("Expected two API users, got %+v", cfg.APIUsers)
+	}
+	if cfg.PeerNotes["203.0.113.5"] != "Mark's node # upstairs" {
+		t.Errorf("Expected the note with its hash, got %q", cfg.PeerNotes)
+	}
+	if cfg.APIRateLimit != 2.5 {
+		t.Errorf("Expected a rate limit of 2.5, got %v", cfg.APIRateLimit)
+	}
+	// Unset settings keep their defaults.
+	if cfg.DedupCacheSize != DefaultConfig().DedupCacheSize {
+		t.Errorf("Expected the default dedup cache size, got %d", cfg.DedupCacheSize)
+	}
+}
+
+func TestLoadYAML(t *testing.T) {
+	path := writeFile(t, "node.yaml", `---
+# Managed by Ansible
+listen_addr: ":9000"
+network_key: 12345   # a string setting given a number
+max_children: 0x10
+allow_list: yes
+gossip: off
+api_rate_limit: 2.5
+peers:
+- 203.0.113.5:8787
+- addr: relay.example.net:8787
+  name: Relay
+  compression: yes
+api_users:
+  - name: admin2
+    pass: secret
+    role: admin
+  - {name: ops, pass: "p#ss", role: viewer}
+peer_notes:
+  203.0.113.5: "Mark's node # upstairs"
+banned_ids: []
+log_syslog: |-
+  local0
+`)
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkLoaded(t, cfg)
+	if cfg.APIUsers[1].Pass != "p#ss" || cfg.LogSyslog != "local0" {
+		t.Errorf("Expected quoted and block scalars read, got %q and %q", cfg.APIUsers[1].Pass, cfg.LogSyslog)
+	}
+}
+
+func TestLoadTOML(t *testing.T) {
+	path := writeFile(t, "node.toml", `# Managed by Ansible
+listen_addr = ":9000"
+network_key = "12345"
+max_children = 16
+allow_list = true
+gossip = false
+api_rate_limit = 2.5
+peers = [
+  "203.0.113.5:8787", # home
+  {addr = "relay.example.net:8787", name = "Relay", compression = true},
+]
+
+[peer_notes]
+"203.0.113.5" = "Mark's node # upstairs"
+
+[[api_users]]
+name = "admin2"
+pass = 'secret'
+role = "admin"
+
+[[api_users]]
+name = "ops"
+pass = "p#ss"
+role = "viewer"
+`)
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkLoaded(t, cfg)
+}
+
+func TestLoadFormatErrors(t *testing.T) {
+	for name, content := range map[string]string{
+		"bad-type.yaml":  "listen_addr: \":9000\"\nmax_children: many\n",
+		"indent.yaml":    "peers:\n  - a\n   - b\n",
+		"duplicate.toml": "gossip = true\n\ngossip = false\n",
+		"unclosed.toml":  "peers = [\"a\"\nlisten_addr = \"b\n",
+	} {
+		_, err := LoadConfig(writeFile(t, name, content))
+		if err == nil {
+			t.Errorf("%s: expected an error", name)
+			continue
+		}
+		if !strings.Contains(err.Error(), name+":2:") && !strings.Contains(err.Error(), name+":3:") {
+			t.Errorf("%s: expected the line in the error, got %v", name, err)
+		}
+	}
+}
+
+func TestSaveFormats(t *testing.T) {
+	for _, ext := range []string{".yaml", ".toml"} {
+		cfg := DefaultConfig()
+		off := false
+		cfg.Peers = []PeerConfig{{Addr: "203.0.113.5:8787"}, {Addr: "relay.example.net:8787", Transport: "tcp", RateLimit: 65536,
+			Filters: []rules.Rule{{Socket: 0x869c, Direction: "any", Action: "drop"}}, Advertise: &off}}
+		cfg.NetworkKey = "true"
+		cfg.APIUsers = []APIUser{{Name: "ops", Pass: "secret", Role: "viewer"}}
+		cfg.PeerNotes = map[string]string{"203.0.113.5": "line one\nline \"two\""}
+		path := filepath.Join(t.TempDir(), "node"+ext)
+		if err := SaveConfig(path, cfg); err != nil {
+			t.Fatal(err)
+		}
+		loaded, err := LoadConfig(path)
+		if err != nil {
+			data, _ := os.ReadFile(path)
+			t.Fatalf("%s: %v\n%s", ext, err, data)
+		}
+		want, _ := json.Marshal(cfg)
+		got, _ := json.Marshal(loaded)
+		if string(got) != string(want) {
+			t.Errorf("%s: expected the config back\n%s\ngot\n%s", ext, want, got)
+		}
+	}
+}
+
+func TestSaveKeepsComments(t *testing.T) {
+	for name, content := range map[string]string{
+		"node.yaml": "# Managed by Ansible\n\n# Where peers link in\nlisten_addr: \":9000\"\npeers:\n  # nested comments go\n  - a:1\n# the end\n",
+		"node.toml": "# Managed by Ansible\n\n# Where peers link in\nlisten_addr = \":9000\"\n\n# the end\n",
+	} {
+		path := writeFile(t, name, content)
+		cfg, err := LoadConfig(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.MaxChildren = 3
+		if err := SaveConfig(path, cfg); err != nil {
+			t.Fatal(err)
+		}
+		data, _ := os.ReadFile(path)
+		out := string(data)
+		if !strings.HasPrefix(out, "# Managed by Ansible\n\n") || !strings.HasSuffix(out, "\n# the end\n") {
+			t.Errorf("%s: expected the header and trailing comments kept, got\n%s", name, out)
+		}
+		sep := ": "
+		if strings.HasSuffix(name, ".toml") {
+			sep = " = "
+		}
+		if !strings.Contains(out, "# Where peers link in\nlisten_addr"+sep+"\":9000\"\n") {
+			t.Errorf("%s: expected the comment above listen_addr kept, got\n%s", name, out)
+		}
+		if strings.Contains(out, "nested") {
+			t.Errorf("%s: expected comments within a setting dropped, got\n%s", name, out)
+		}
+	}
+}
+
+func TestSaveAwkwardStrings(t *testing.T) {
+	awkward := []string{"", " lead", "trail ", "tab\there", "# hash", "a: b", "- item", "null", "~", "yes", "0x10",
+		"1e3", "[x]", "{y}", "'q'", `back\slash`, "ünïcödé", "ctl\x01", "\ufeffbom"}
+	for _, ext := range []string{".yaml", ".toml"} {
+		cfg := DefaultConfig()
+		cfg.PeerTrust = map[string]string{}
+		cfg.PeerNotes = map[string]string{}
+		for i, s := range awkward {
+			cfg.PeerTrust[s] = "trusted"
+			cfg.PeerNotes[fmt.Sprintf("note %d", i)] = s
+		}
+		path := filepath.Join(t.TempDir(), "node"+ext)
+		if err := SaveConfig(path, cfg); err != nil {
+			t.Fatal(err)
+		}
+		loaded, err := LoadConfig(path)
+		if err != nil {
+			data, _ := os.ReadFile(path)
+			t.Fatalf("%s: %v\n%s", ext, err, data)
+		}
+		want, _ := json.Marshal(cfg)
+		got, _ := json.Marshal(loaded)
+		if string(got) != string(want) {
+			t.Errorf("%s: expected the config back\n%s\ngot\n%s", ext, want, got)
+		}
+	}
+}
+
+func TestLoadBOMAndTabs(t *testing.T) {
+	for name, content := range map[string]string{
+		"bom.yaml":  "\ufeffmax_children: 16\nlisten_addr: \":9000\"\n",
+		"tabs.yaml": "max_children:\t16\nlisten_addr:\t\":9000\"\t# a comment\npeer_notes: {a:\tb}\n",
+		"bom.toml":  "\ufeffmax_children = 16\nlisten_addr = \":9000\"\n",
+	} {
+		cfg, err := LoadConfig(writeFile(t, name, content))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if cfg.MaxChildren != 16 || cfg.ListenAddr != ":9000" {
+			t.Errorf("%s: expected both settings read, got %d and %q", name, cfg.MaxChildren, cfg.ListenAddr)
+		}
+	}
+
+	path := writeFile(t, "node.yaml", "\ufeff# Managed by Ansible\n\nmax_children: 16\n")
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveConfig(path, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); !strings.HasPrefix(string(data), "# Managed by Ansible\n\n") {
+		t.Errorf("Expected the header comment kept without the mark, got\n%s", data)
+	}
+}
+
+// checkRoundTrip has data, if it reads as a mapping in f, written back
+// and read again the same. Config files are UTF-8; other bytes come back
+// as U+FFFD.
+func checkRoundTrip(t *testing.T, f format, data []byte) {
+	if !utf8.Valid(data) {
+		return
+	}
+	parse := parseYAML
+	if f == formatTOML {
+		parse = parseTOML
+	}
+	doc, err := parse(data)
+	root, ok := doc.(*object)
+	if err != nil || !ok {
+		return
+	}
+	v, err := plain(root, nil, "")
+	if err != nil {
+		return
+	}
+	want, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := f.encode(want, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err = parse(out)
+	if err != nil {
+		t.Fatalf("%q reads as %s, written as\n%s\nwhich does not read back: %v", data, want, out, err)
+	}
+	if doc == nil {
+		doc = &object{}
+	}
+	v, err = plain(doc, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := json.Marshal(v)
+	if !bytes.Equal(got, want) {
+		t.Fatalf("%q reads as %s, written as\n%s\nwhich reads back as %s", data, want, out, got)
+	}
+}
+
+func FuzzYAML(f *testing.F) {
+	for _, s := range []string{
+		"a: 1\nb: [x, \"y\", {c: d}]\n",
+		"peers:\n  - addr: a:1\n    name: \"Mark's\"\n  - b\nnote: |\n  one\n  two\n",
+		"\ufeffa:\tb # c\n'k''s': ~\n\"\": ''\n",
+		"folded: >-\n  one\n\n  two\nlist:\n- 0x10\n- 1_000\n- yes\n",
+	} {
+		f.Add([]byte(s))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		checkRoundTrip(t, formatYAML, data)
+	})
+}
+
+func FuzzTOML(f *testing.F) {
+	for _, s := range []string{
+		"a = 1\nb = [\"x\", 'y', { c = \"d\" }]\n",
+		"[peer_trust]\n\"\" = \"trusted\"\n\n[[api_users]]\nname = \"ops\"\n\n[[api_users]]\nname = \"x\"\n",
+		"\ufeffs = \"\"\"\none \\\n  two\"\"\"\nt.u = 0x10 # c\n",
+		"[a.\"b.c\"]\nd = 1979-05-27\ne = [[1, 2], []]\n",
+	} {
+		f.Add([]byte(s))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		checkRoundTrip(t, formatTOML, data)
+	})
+}
@@ -0,0 +1,130 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Matching hosts against banned_hosts and allowed_hosts patterns
+
+package config
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// HostMatcher matches hosts against compiled banned_hosts or allowed_hosts
+// patterns: an IP address, a CIDR range such as 203.0.113.0/24, a host
+// name, or a name suffix such as *.example.net, which matches the names
+// below example.net.
+type HostMatcher struct {
+	exact    map[string]string // address or name -> pattern
+	prefixes []netip.Prefix
+	ranges   []string // pattern of each prefix
+	suffixes []string // ".example.net" of "*.example.net"
+	names    []string // pattern of each suffix
+}
+
+// IsHostPattern reports whether s is written as a host pattern rather
+// than a peer ID: an IP address, a CIDR range or a name suffix.
+func IsHostPattern(s string) bool {
+	if _, err := netip.ParseAddr(s); err == nil {
+		return true
+	}
+	return strings.Contains(s, "/") || strings.HasPrefix(s, "*.")
+}
+
+// CompileHostPatterns compiles patterns, failing on the first that is
+// neither an address, a range nor a host name.
+func CompileHostPatterns(patterns []string) (*HostMatcher, error) {
+	m := &HostMatcher{exact: make(map[string]string)}
+	for _, p := range patterns {
+		switch {
+		case strings.Contains(p, "/"):
+			prefix, err := netip.ParsePrefix(p)
+			if err != nil {
+				return nil, fmt.Errorf("%q is not a CIDR range: %v", p, err)
+			}
+			m.prefixes = append(m.prefixes, prefix.Masked())
+			m.ranges = append(m.ranges, p)
+		case strings.HasPrefix(p, "*."):
+			if !validHostName(p[2:]) {
+				return nil, fmt.Errorf("%q is not a host name pattern", p)
+			}
+			m.suffixes = append(m.suffixes, strings.ToLower(p[1:]))
+			m.names = append(m.names, p)
+		default:
+			if addr, err := netip.ParseAddr(p); err == nil {
+				m.exact[addr.Unmap().String()] = p
+			} else if validHostName(p) {
+				m.exact[strings.ToLower(p)] = p
+			} else {
+				return nil, fmt.Errorf("%q is not an IP address, CIDR range or host name", p)
+			}
+		}
+	}
+	return m, nil
+}
+
+// HasNames reports whether m holds name patterns, which an address only
+// matches through its reverse lookup.
+func (m *HostMatcher) HasNames() bool {
+	if m == nil {
+		return false
+	}
+	if len(m.suffixes) > 0 {
+		return true
+	}
+	for k := range m.exact {
+		if _, err := netip.ParseAddr(k); err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Match returns the pattern matched by the first of hosts that matches.
+// Hosts are addresses or names.
+func (m *HostMatcher) Match(hosts ...string) (string, bool) {
+	if m == nil {
+		return "", false
+	}
+	for _, h := range hosts {
+		h = strings.TrimSuffix(strings.ToLower(h), ".")
+		if addr, err := netip.ParseAddr(strings.Trim(h, "[]")); err == nil {
+			addr = addr.Unmap()
+			if p, ok := m.exact[addr.String()]; ok {
+				return p, true
+			}
+			for i, prefix := range m.prefixes {
+				if prefix.Contains(addr) {
+					return m.ranges[i], true
+				}
+			}
+			continue
+		}
+		if p, ok := m.exact[h]; ok {
+			return p, true
+		}
+		for i, s := range m.suffixes {
+			if strings.HasSuffix(h, s) {
+				return m.names[i], true
+			}
+		}
+	}
+	return "", false
+}
+
+func validHostName(name string) bool {
+	if name == "" || len(name) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
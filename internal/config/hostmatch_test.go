@@ -0,0 +1,70 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for banned_hosts patterns
+
+package config
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+)
+
+func TestHostMatcher(t *testing.T) {
+	m, err := CompileHostPatterns([]string{"10.0.0.1", "203.0.113.0/24", "2001:db8::/32", "*.Example.net", "cheater.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for host, want := range map[string]string{
+		"10.0.0.1":          "10.0.0.1",
+		"::ffff:10.0.0.1":   "10.0.0.1",
+		"203.0.113.77":      "203.0.113.0/24",
+		"[2001:db8::5]":     "2001:db8::/32",
+		"lan.example.net.":  "*.Example.net",
+		"a.b.EXAMPLE.net":   "*.Example.net",
+		"cheater.org":       "cheater.org",
+		"example.net":       "",
+		"notexample.net":    "",
+		"203.0.114.1":       "",
+		"sub.cheater.org":   "",
+		"2001:db9::1":       "",
+		"10.0.0.10":         "",
+		"203.0.113.example": "",
+	} {
+		got, ok := m.Match(host)
+		if got != want || ok != (want != "") {
+			t.Errorf("%s: expected %q, got %q (%v)", host, want, got, ok)
+		}
+	}
+	if !m.HasNames() {
+		t.Error("Expected name patterns reported")
+	}
+	if m, _ := CompileHostPatterns([]string{"10.0.0.0/8"}); m.HasNames() {
+		t.Error("Expected no name patterns in a range")
+	}
+
+	for _, bad := range []string{"10.0.0.0/33", "*.", "bad host", "10.0.0.1:8787", "-x.org"} {
+		if _, err := CompileHostPatterns([]string{bad}); err == nil {
+			t.Errorf("Expected %q to be refused", bad)
+		}
+	}
+}
+
+func TestBannedHostsValidated(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.BannedHosts = []string{"10.0.0.0/99"}
+	if err := SaveConfig(filepath.Join(t.TempDir(), "config.json"), cfg); err == nil {
+		t.Error("Expected saving an invalid banned_hosts to fail")
+	}
+
+	cfg = DefaultConfig()
+	if err := cfg.SetSetting("banned_hosts", json.RawMessage(`["*.example.net", "nope/8"]`)); err == nil {
+		t.Error("Expected setting an invalid banned_hosts to fail")
+	}
+	if len(cfg.BannedHosts) != 0 {
+		t.Errorf("Expected banned_hosts untouched, got %v", cfg.BannedHosts)
+	}
+	if err := cfg.SetSetting("allowed_hosts", json.RawMessage(`["10.1.0.0/16", "bad host"]`)); err == nil {
+		t.Error("Expected setting an invalid allowed_hosts to fail")
+	}
+}
@@ -0,0 +1,204 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Hashed admin password and the JWT signing key derived from it
+
+package config
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// hashPrefix starts a hashed password, in the PHC string format:
+// $argon2id$v=19$m=<KiB>,t=<passes>,p=<lanes>$<salt>$<key>, salt and key in
+// unpadded base64.
+const hashPrefix = "$argon2id$"
+
+// Argon2id parameters, the first of the OWASP recommendations: 19 MiB
+// and two passes. The memory cost is kept modest, as logins may run
+// side by side.
+var (
+	argonMemory  uint32 = 19 * 1024
+	argonTime    uint32 = 2
+	argonThreads uint8  = 1
+)
+
+// HashPassword returns the Argon2id hash of pass with a random salt.
+func HashPassword(pass string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(pass), salt, argonTime, argonMemory, argonThreads, 32)
+	enc := base64.RawStdEncoding
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", hashPrefix, argon2.Version, argonMemory, argonTime, argonThreads,
+		enc.EncodeToString(salt), enc.EncodeToString(key)), nil
+}
+
+// IsPasswordHash reports whether s is a hash from HashPassword rather than
+// a plaintext password.
+func IsPasswordHash(s string) bool {
+	return strings.HasPrefix(s, hashPrefix)
+}
+
+// CheckPassword reports whether pass matches stored, a hash or, in a config
+// not yet migrated, the plaintext password. Comparisons are constant-time.
+func CheckPassword(stored, pass string) bool {
+	if strings.HasPrefix(stored, hashPrefix) {
+		return checkArgon2(strings.TrimPrefix(stored, hashPrefix), pass)
+	}
+	return subtle.ConstantTimeCompare([]byte(stored), []byte(pass)) == 1
+}
+
+// maxArgonMemory bounds the memory a stored hash may ask for, in KiB, so
+// a mangled config cannot make a login allocate gigabytes.
+const maxArgonMemory = 1 << 20
+
+func checkArgon2(hash, pass string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != fmt.Sprintf("v=%d", argon2.Version) {
+		return false
+	}
+	var memory, passes uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[1], "m=%d,t=%d,p=%d", &memory, &passes, &threads); err != nil {
+		return false
+	}
+	if memory == 0 || memory > maxArgonMemory || passes == 0 || threads == 0 {
+		return false
+	}
+	enc := base64.RawStdEncoding
+	salt, err1 := enc.DecodeString(parts[2])
+	want, err2 := enc.DecodeString(parts[3])
+	if err1 != nil || err2 != nil || len(want) == 0 {
+		return false
+	}
+	key := argon2.IDKey([]byte(pass), salt, passes, memory, threads, uint32(len(want)))
+	return subtle.ConstantTimeCompare(key, want) == 1
+}
+
+// authMu guards the credentials of a Config: admin_user, admin_pass,
+// api_users and jwt_secret. The API derives token keys from them on every
+// request while an admin may be changing them.
+var authMu sync.RWMutex
+
+// AdminCredentials returns admin_user and the stored admin_pass.
+func (c *Config) AdminCredentials() (user, pass string) {
+	authMu.RLock()
+	defer authMu.RUnlock()
+	return c.AdminUser, c.AdminPass
+}
+
+// SetAdminPass stores the hash of a new admin password.
+func (c *Config) SetAdminPass(pass string) error {
+	if err := c.externalSecret("admin_pass"); err != nil {
+		return err
+	}
+	hash, err := HashPassword(pass)
+	if err != nil {
+		return err
+	}
+	authMu.Lock()
+	c.AdminPass = hash
+	authMu.Unlock()
+	return nil
+}
+
+// SetAdminUser renames admin_user.
+func (c *Config) SetAdminUser(user string) {
+	authMu.Lock()
+	c.AdminUser = user
+	authMu.Unlock()
+}
+
+// HashPasswords replaces the plaintext passwords of the admin and the API
+// users by their hashes and reports whether there were any.
+func (c *Config) HashPasswords() (bool, error) {
+	hashed := false
+	authMu.RLock()
+	passes := append([]*string{&c.AdminPass}, c.apiUserPasses()...)
+	plain := make([]string, len(passes))
+	for i, pass := range passes {
+		plain[i] = *pass
+	}
+	authMu.RUnlock()
+	for i, pass := range passes {
+		if IsPasswordHash(plain[i]) {
+			continue
+		}
+		hash, err := HashPassword(plain[i])
+		if err != nil {
+			return hashed, err
+		}
+		authMu.Lock()
+		if *pass == plain[i] {
+			*pass = hash
+		}
+		authMu.Unlock()
+		hashed = true
+	}
+	return hashed, nil
+}
+
+func (c *Config) apiUserPasses() []*string {
+	out := make([]*string, len(c.APIUsers))
+	for i := range c.APIUsers {
+		out[i] = &c.APIUsers[i].Pass
+	}
+	return out
+}
+
+// RotateJWTSecret replaces the JWT secret by a new random one, which
+// invalidates every token issued before.
+func (c *Config) RotateJWTSecret() error {
+	if err := c.externalSecret("jwt_secret"); err != nil {
+		return err
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return err
+	}
+	authMu.Lock()
+	c.JWTSecret = hex.EncodeToString(secret)
+	authMu.Unlock()
+	return nil
+}
+
+// EnsureJWTSecret generates a JWT secret if none is set, so deployments do
+// not share a well-known signing key, and reports whether it did.
+func (c *Config) EnsureJWTSecret() (bool, error) {
+	authMu.RLock()
+	secret := c.JWTSecret
+	authMu.RUnlock()
+	if secret != "" {
+		return false, nil
+	}
+	return true, c.RotateJWTSecret()
+}
+
+// TokenKey is the key API tokens are signed with. It is derived from the
+// JWT secret and the admin credentials, so changing the password or user
+// invalidates every token issued before.
+func (c *Config) TokenKey() []byte {
+	authMu.RLock()
+	defer authMu.RUnlock()
+	return c.tokenKeyLocked()
+}
+
+// tokenKeyLocked is TokenKey with authMu held.
+func (c *Config) tokenKeyLocked() []byte {
+	mac := hmac.New(sha256.New, []byte(c.JWTSecret))
+	mac.Write([]byte(c.AdminUser))
+	mac.Write([]byte{0})
+	mac.Write([]byte(c.AdminPass))
+	return mac.Sum(nil)
+}
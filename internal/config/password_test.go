@@ -0,0 +1,156 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for admin password hashing
+
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestHashPassword(t *testing.T) {
+	hash, err := HashPassword("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsPasswordHash(hash) || strings.Contains(hash, "hunter2") {
+		t.Errorf("Expected a hash, got %q", hash)
+	}
+	if !CheckPassword(hash, "hunter2") {
+		t.Error("Expected the password to match its hash")
+	}
+	if CheckPassword(hash, "hunter3") {
+		t.Error("Expected another password not to match")
+	}
+	if again, _ := HashPassword("hunter2"); again == hash {
+		t.Error("Expected a fresh salt for every hash")
+	}
+	for _, bad := range []string{hashPrefix, hashPrefix + "x$a$b", hashPrefix + "1$!!$!!", hash[:len(hash)-1] + "$"} {
+		if CheckPassword(bad, "hunter2") {
+			t.Errorf("Expected malformed hash %q not to match", bad)
+		}
+	}
+	// A hash asking for more memory than a login may take is refused.
+	huge := strings.Replace(hash, fmt.Sprintf("m=%d,", argonMemory), "m=4294967295,", 1)
+	if CheckPassword(huge, "hunter2") {
+		t.Error("Expected a hash with a huge memory cost refused")
+	}
+	// A config not yet migrated still holds the plaintext.
+	if !CheckPassword("admin", "admin") || CheckPassword("admin", "admin2") {
+		t.Error("Expected plaintext passwords to be compared as is")
+	}
+}
+
+func TestSaveConfigHashesAdminPass(t *testing.T) {
+	cfg := DefaultConfig()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := SaveConfig(path, cfg); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved struct {
+		AdminPass string `json:"admin_pass"`
+	}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if !IsPasswordHash(saved.AdminPass) || !CheckPassword(saved.AdminPass, "admin") {
+		t.Errorf("Expected the default password saved hashed, got %q", saved.AdminPass)
+	}
+	if cfg.AdminPass != saved.AdminPass {
+		t.Error("Expected the config in memory to hold the saved hash")
+	}
+
+	// Saving again keeps the hash rather than hashing it twice.
+	if err := SaveConfig(path, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if !CheckPassword(cfg.AdminPass, "admin") {
+		t.Error("Expected the password to survive a second save")
+	}
+}
+
+func TestTokenKey(t *testing.T) {
+	cfg := DefaultConfig()
+	if err := cfg.SetAdminPass("one"); err != nil {
+		t.Fatal(err)
+	}
+	key := cfg.TokenKey()
+	if !bytes.Equal(key, cfg.TokenKey()) {
+		t.Error("Expected the same key for the same credentials")
+	}
+	if err := cfg.SetAdminPass("two"); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(key, cfg.TokenKey()) {
+		t.Error("Expected a new key after a password change")
+	}
+	key = cfg.TokenKey()
+	cfg.AdminUser = "root"
+	if bytes.Equal(key, cfg.TokenKey()) {
+		t.Error("Expected a new key after a user change")
+	}
+}
+
+func TestEnsureJWTSecret(t *testing.T) {
+	cfg := DefaultConfig()
+	if cfg.JWTSecret != "" {
+		t.Fatalf("Expected no default JWT secret, got %q", cfg.JWTSecret)
+	}
+	made, err := cfg.EnsureJWTSecret()
+	if err != nil || !made || len(cfg.JWTSecret) != 64 {
+		t.Fatalf("Expected a 256-bit secret generated, got %q (%v, %v)", cfg.JWTSecret, made, err)
+	}
+	secret := cfg.JWTSecret
+	if made, _ := cfg.EnsureJWTSecret(); made || cfg.JWTSecret != secret {
+		t.Error("Expected an existing secret kept")
+	}
+
+	key := cfg.TokenKey()
+	if err := cfg.RotateJWTSecret(); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.JWTSecret == secret || bytes.Equal(cfg.TokenKey(), key) {
+		t.Error("Expected rotation to change the secret and the token key")
+	}
+}
+
+func TestCredentialsConcurrent(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.APIUsers = []APIUser{{Name: "status", Pass: "look", Role: RoleViewer}}
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		for range 20 {
+			if err := cfg.RotateJWTSecret(); err != nil {
+				t.Error(err)
+			}
+			cfg.SetAdminUser("admin")
+		}
+	})
+	wg.Go(func() {
+		if err := cfg.SetAdminPass("new"); err != nil {
+			t.Error(err)
+		}
+		if _, err := cfg.HashPasswords(); err != nil {
+			t.Error(err)
+		}
+	})
+	for range 50 {
+		cfg.TokenKey()
+		cfg.UserTokenKey("status")
+		cfg.OIDCTokenKey("alice", RoleViewer)
+		cfg.AdminCredentials()
+		cfg.Redacted()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,176 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Configured peers and the settings each can carry
+
+package config
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/mlapointe/ipxtransporter/internal/rules"
+)
+
+// Peer transports. A peer without one links the way disable_ssl says.
+const (
+	TransportTLS = "tls"
+	TransportTCP = "tcp"
+
+	// MaxLinkCost bounds the cost of a link, so metrics cannot overflow.
+	MaxLinkCost = 1 << 20
+
+	// DefaultPeerPort is the port of a peer address given without one.
+	DefaultPeerPort = "8787"
+)
+
+// PeerConfig is a configured peer and how its link is made. In the config
+// it is an object, or the address alone as peers were listed before they
+// had settings; one with nothing but an address is written that way.
+type PeerConfig struct {
+	Addr        string       `json:"addr"`
+	Name        string       `json:"name,omitempty"`        // shown for the peer instead of its address
+	Transport   string       `json:"transport,omitempty"`   // tls or tcp, empty to follow disable_ssl
+	Fingerprint string       `json:"fingerprint,omitempty"` // SHA-256 of the key the peer's certificate must hold
+	Cost        int          `json:"cost,omitempty"`        // administrative cost of the link, added to its latency in ms for its metric
+	Compression bool         `json:"compression,omitempty"` // deflate packets sent to the peer if it accepts them
+	RateLimit   int          `json:"rate_limit,omitempty"`  // bytes per second sent to the peer, 0 for no limit
+	Filters     []rules.Rule `json:"filters,omitempty"`     // filter rules for packets to and from the peer
+	Advertise   *bool        `json:"advertise,omitempty"`   // gossip the peer's address, true if unset
+}
+
+// peerConfig has the fields of PeerConfig without its JSON methods.
+type peerConfig PeerConfig
+
+func (p *PeerConfig) UnmarshalJSON(data []byte) error {
+	var addr string
+	if err := json.Unmarshal(data, &addr); err == nil {
+		*p = PeerConfig{Addr: addr}
+		return nil
+	}
+	var pc peerConfig
+	if err := json.Unmarshal(data, &pc); err != nil {
+		return fmt.Errorf("a peer is an address or an object with addr: %v", err)
+	}
+	*p = PeerConfig(pc)
+	return nil
+}
+
+func (p PeerConfig) MarshalJSON() ([]byte, error) {
+	if p.AddrOnly() {
+		return json.Marshal(p.Addr)
+	}
+	return json.Marshal(peerConfig(p))
+}
+
+// AddrOnly reports whether p holds nothing but its address.
+func (p PeerConfig) AddrOnly() bool {
+	return p.Name == "" && p.Transport == "" && p.Fingerprint == "" && p.Cost == 0 && !p.Compression &&
+		p.RateLimit == 0 && len(p.Filters) == 0 && p.Advertise == nil
+}
+
+// MayAdvertise reports whether the peer's address may be gossiped.
+func (p PeerConfig) MayAdvertise() bool {
+	return p.Advertise == nil || *p.Advertise
+}
+
+// TLS reports whether the link to the peer is made over TLS, given the
+// node's disable_ssl.
+func (p PeerConfig) TLS(disableSSL bool) bool {
+	if p.Transport == "" {
+		return !disableSSL
+	}
+	return p.Transport == TransportTLS
+}
+
+// NormalFingerprint returns fp as a PeerConfig holds it: lower case hex
+// without the colons some tools print between bytes.
+func NormalFingerprint(fp string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(fp), ":", ""))
+}
+
+// PeerAddr checks a peer address as typed by an operator, a host name or
+// IP address with an optional port, IPv6 in brackets if it has one, and
+// returns it with DefaultPeerPort if it has none.
+func PeerAddr(addr string) (string, error) {
+	addr = strings.TrimSpace(addr)
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		if strings.Contains(addr, "]") || strings.Count(addr, ":") == 1 {
+			return "", fmt.Errorf("%q is not host:port", addr)
+		}
+		host, port = addr, DefaultPeerPort
+	}
+	if n, err := strconv.ParseUint(port, 10, 16); err != nil || n == 0 {
+		return "", fmt.Errorf("bad port %q in %q", port, addr)
+	}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		if ip.Is6() && !strings.HasPrefix(addr, "[") {
+			return "", fmt.Errorf("put the IPv6 address %s in brackets, as [%s]:%s", host, host, port)
+		}
+		return net.JoinHostPort(host, port), nil
+	}
+	if !validHostName(host) {
+		return "", fmt.Errorf("%q is not a host name or IP address", host)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// PeerAddrs returns the addresses of peers.
+func PeerAddrs(peers []PeerConfig) []string {
+	out := make([]string, len(peers))
+	for i, p := range peers {
+		out[i] = p.Addr
+	}
+	return out
+}
+
+// FindPeer returns the configured peer with address addr.
+func FindPeer(peers []PeerConfig, addr string) (PeerConfig, bool) {
+	i := slices.IndexFunc(peers, func(p PeerConfig) bool { return p.Addr == addr })
+	if i < 0 {
+		return PeerConfig{}, false
+	}
+	return peers[i], true
+}
+
+// Validate checks the settings of p, normalizing its transport,
+// fingerprint and filters. disableSSL is the node's.
+func (p *PeerConfig) Validate(disableSSL bool) error {
+	if p.Addr == "" {
+		return errors.New("no addr")
+	}
+	p.Transport = strings.ToLower(p.Transport)
+	switch p.Transport {
+	case "", TransportTLS, TransportTCP:
+	default:
+		return fmt.Errorf("unknown transport %q, want tls or tcp", p.Transport)
+	}
+	if p.Fingerprint != "" {
+		p.Fingerprint = NormalFingerprint(p.Fingerprint)
+		if b, err := hex.DecodeString(p.Fingerprint); err != nil || len(b) != 32 {
+			return fmt.Errorf("fingerprint %q is not a SHA-256 in hex", p.Fingerprint)
+		}
+		if !p.TLS(disableSSL) {
+			return errors.New("a fingerprint needs the link over tls")
+		}
+	}
+	if p.Cost < 0 || p.Cost > MaxLinkCost {
+		return fmt.Errorf("cost must be from 0 to %d, got %d", MaxLinkCost, p.Cost)
+	}
+	if p.RateLimit < 0 {
+		return fmt.Errorf("rate_limit must not be negative, got %d", p.RateLimit)
+	}
+	for i := range p.Filters {
+		if err := rules.Validate(rules.KindFilter, &p.Filters[i]); err != nil {
+			return fmt.Errorf("filters[%d]: %v", i, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for configured peers and their settings
+
+package config
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mlapointe/ipxtransporter/internal/rules"
+)
+
+func TestPeerConfigMigration(t *testing.T) {
+	path := writeFile(t, "config.json", `{"listen_addr": ":8787", "disable_ssl": true,
+		"peers": ["10.0.0.1:8787", {"addr": "10.0.0.2:8787", "name": "Upstairs", "rate_limit": 1000}]}`)
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Peers) != 2 || cfg.Peers[0].Addr != "10.0.0.1:8787" || cfg.Peers[1].Name != "Upstairs" || cfg.Peers[1].RateLimit != 1000 {
+		t.Fatalf("Expected an address and a block read, got %+v", cfg.Peers)
+	}
+
+	// A peer with nothing but its address is still written the old way,
+	// so a config keeps loading on nodes from before blocks.
+	out := filepath.Join(t.TempDir(), "config.json")
+	if err := SaveConfig(out, cfg); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadConfig(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(loaded.Peers)
+	if want := `["10.0.0.1:8787",{"addr":"10.0.0.2:8787","name":"Upstairs","rate_limit":1000}]`; string(data) != want {
+		t.Errorf("Expected peers written as %s, got %s", want, data)
+	}
+}
+
+func TestPeerConfigValidate(t *testing.T) {
+	const fp = "AB:" + "00112233445566778899aabbccddeeff00112233445566778899aabbccddee"
+	for _, tc := range []struct {
+		peer PeerConfig
+		ssl  bool
+		err  string
+	}{
+		{PeerConfig{Addr: "10.0.0.1:8787", Fingerprint: fp}, true, ""},
+		{PeerConfig{Addr: "10.0.0.1:8787", Transport: "TCP"}, true, ""},
+		{PeerConfig{Addr: "10.0.0.1:8787", Transport: "quic"}, true, "unknown transport"},
+		{PeerConfig{Addr: "10.0.0.1:8787", Fingerprint: "abc"}, true, "not a SHA-256"},
+		{PeerConfig{Addr: "10.0.0.1:8787", Fingerprint: fp}, false, "needs the link over tls"},
+		{PeerConfig{Addr: "10.0.0.1:8787", Fingerprint: fp, Transport: "tls"}, false, ""},
+		{PeerConfig{Addr: "10.0.0.1:8787", RateLimit: -1}, true, "rate_limit"},
+		{PeerConfig{Addr: "10.0.0.1:8787", Filters: []rules.Rule{{Action: "reject"}}}, true, "filters[0]"},
+		{PeerConfig{Name: "nowhere"}, true, "no addr"},
+	} {
+		p := tc.peer
+		err := p.Validate(!tc.ssl)
+		switch {
+		case tc.err == "" && err != nil:
+			t.Errorf("%+v: %v", tc.peer, err)
+		case tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)):
+			t.Errorf("%+v: expected an error with %q, got %v", tc.peer, tc.err, err)
+		}
+		if err == nil && p.Fingerprint != "" && strings.ContainsAny(p.Fingerprint, ":AB") {
+			t.Errorf("Expected the fingerprint normalized, got %s", p.Fingerprint)
+		}
+	}
+}
+
+func TestPeerAddr(t *testing.T) {
+	for _, tc := range []struct {
+		addr, want string
+	}{
+		{"10.0.0.1", "10.0.0.1:8787"},
+		{" hub.example.org:9000 ", "hub.example.org:9000"},
+		{"[2001:db8::1]:8787", "[2001:db8::1]:8787"},
+		{"2001:db8::1", ""},
+		{"[2001:db8::1]", ""},
+		{"hub.example.org:", ""},
+		{"hub.example.org:70000", ""},
+		{"hub.example.org:0", ""},
+		{"hub example.org", ""},
+		{"", ""},
+	} {
+		got, err := PeerAddr(tc.addr)
+		if got != tc.want || (err == nil) != (tc.want != "") {
+			t.Errorf("%q: expected %q, got %q (%v)", tc.addr, tc.want, got, err)
+		}
+	}
+}
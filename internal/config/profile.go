@@ -0,0 +1,197 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Configuration profiles: bundles of settings for common roles
+
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Profile is a named bundle of settings for a common role. A setting the
+// config file leaves at its default takes the profile's value; one set to
+// anything else is kept. Profiles only change scalar settings, so a
+// shallow copy of a Config can be reset to the defaults.
+type Profile struct {
+	Name        string
+	Description string
+	apply       func(c *Config)
+}
+
+var profiles = []Profile{
+	{
+		Name:        "home",
+		Description: "a leaf on a home network: few links, modest buffers, never the hub",
+		apply: func(c *Config) {
+			c.MaxChildren = 2
+			c.PeerQueueBytes = 128 * 1024
+			c.BroadcastWorkers = 1
+			c.DedupCacheSize = 16000
+			c.RebalanceEnabled = false
+			c.HubPriority = 0
+			c.APIRateLimit = 10
+			c.APIRateBurst = 30
+		},
+	},
+	{
+		Name:        "hub",
+		Description: "a community hub: many links, large buffers and dedup cache, quieter logs",
+		apply: func(c *Config) {
+			c.MaxChildren = 32
+			c.PeerQueueBytes = 1024 * 1024
+			c.InjectWorkers = 2
+			c.BroadcastWorkers = 4
+			c.DedupCacheSize = 256000
+			c.DedupCacheTTL = 60
+			c.HubPriority = 200
+			c.APIRateLimit = 50
+			c.APIRateBurst = 120
+			c.LogLevel = "warn"
+		},
+	},
+	{
+		Name:        "tournament",
+		Description: "a LAN-tournament bridge: busy local segment, short dedup and MAC memory, dashboards polling often",
+		apply: func(c *Config) {
+			c.MaxChildren = 16
+			c.PeerQueueBytes = 512 * 1024
+			c.InjectWorkers = 2
+			c.BroadcastWorkers = 4
+			c.DedupCacheSize = 128000
+			c.DedupCacheTTL = 10
+			c.MACTableTTL = 120
+			c.APIRateLimit = 100
+			c.APIRateBurst = 200
+			c.LogLevel = "warn"
+		},
+	},
+}
+
+// Profiles returns the profiles in the order they are documented.
+func Profiles() []Profile {
+	return slices.Clone(profiles)
+}
+
+// Settings returns the settings p changes from the defaults, as JSON by key.
+func (p Profile) Settings() map[string]json.RawMessage {
+	base, _ := settingsJSON(DefaultConfig())
+	c := DefaultConfig()
+	p.apply(c)
+	set, _ := settingsJSON(c)
+	out := make(map[string]json.RawMessage)
+	for k, v := range set {
+		if !bytes.Equal(v, base[k]) {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// String lists the settings of p as key=value.
+func (p Profile) String() string {
+	settings := p.Settings()
+	var parts []string
+	for _, k := range slices.Sorted(maps.Keys(settings)) {
+		parts = append(parts, k+"="+string(settings[k]))
+	}
+	return strings.Join(parts, " ")
+}
+
+func profileSettings(name string) (map[string]json.RawMessage, error) {
+	if name == "" {
+		return nil, nil
+	}
+	for _, p := range profiles {
+		if p.Name == name {
+			return p.Settings(), nil
+		}
+	}
+	return nil, fmt.Errorf("unknown profile %q", name)
+}
+
+func settingsJSON(c *Config) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]json.RawMessage
+	err = json.Unmarshal(data, &m)
+	return m, err
+}
+
+// ApplyProfile switches to the named profile, "" for none: each setting
+// still at its default or at the value of the current profile takes the
+// new profile's value.
+func (c *Config) ApplyProfile(name string) error {
+	next, err := profileSettings(name)
+	if err != nil {
+		return err
+	}
+	cur, _ := profileSettings(c.Profile)
+	def, err := settingsJSON(DefaultConfig())
+	if err != nil {
+		return err
+	}
+	have, err := settingsJSON(c)
+	if err != nil {
+		return err
+	}
+	patch := make(map[string]json.RawMessage)
+	for _, k := range slices.Concat(slices.Collect(maps.Keys(cur)), slices.Collect(maps.Keys(next))) {
+		if !bytes.Equal(have[k], def[k]) && !bytes.Equal(have[k], cur[k]) {
+			continue // set in the config file
+		}
+		v, ok := next[k]
+		if !ok {
+			v = def[k]
+		}
+		patch[k] = v
+	}
+	raw, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw, c); err != nil {
+		return err
+	}
+	c.Profile = name
+	return nil
+}
+
+// withoutProfile returns c with the settings that have the profile's value
+// back at their defaults, as the config file is written: the profile
+// supplies them again on the next start, whichever profile that is.
+func (c *Config) withoutProfile() *Config {
+	set, err := profileSettings(c.Profile)
+	if err != nil || len(set) == 0 {
+		return c
+	}
+	def, err := settingsJSON(DefaultConfig())
+	if err != nil {
+		return c
+	}
+	have, err := settingsJSON(c)
+	if err != nil {
+		return c
+	}
+	patch := make(map[string]json.RawMessage)
+	for k, v := range set {
+		if bytes.Equal(have[k], v) {
+			patch[k] = def[k]
+		}
+	}
+	raw, err := json.Marshal(patch)
+	if err != nil {
+		return c
+	}
+	out := *c
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return c
+	}
+	return &out
+}
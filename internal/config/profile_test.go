@@ -0,0 +1,71 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for configuration profiles
+
+package config
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestApplyProfile(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxChildren = 8 // set in the config file
+	if err := cfg.ApplyProfile("hub"); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MaxChildren != 8 {
+		t.Errorf("Expected the configured max_children kept, got %d", cfg.MaxChildren)
+	}
+	if cfg.DedupCacheSize != 256000 || cfg.LogLevel != "warn" {
+		t.Errorf("Expected the hub's dedup cache and log level, got %d and %s", cfg.DedupCacheSize, cfg.LogLevel)
+	}
+
+	if err := cfg.ApplyProfile("home"); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.DedupCacheSize != 16000 || cfg.LogLevel != "info" || cfg.HubPriority != 0 {
+		t.Errorf("Expected the hub's settings replaced by home's, got %d, %s and %d", cfg.DedupCacheSize, cfg.LogLevel, cfg.HubPriority)
+	}
+	if cfg.MaxChildren != 8 {
+		t.Errorf("Expected the configured max_children still kept, got %d", cfg.MaxChildren)
+	}
+
+	if err := cfg.ApplyProfile("office"); err == nil {
+		t.Error("Expected an unknown profile to be rejected")
+	}
+	if cfg.Profile != "home" {
+		t.Errorf("Expected the home profile to stay, got %q", cfg.Profile)
+	}
+}
+
+func TestSaveConfigWithoutProfile(t *testing.T) {
+	cfg := DefaultConfig()
+	if err := cfg.ApplyProfile("tournament"); err != nil {
+		t.Fatal(err)
+	}
+	cfg.DedupCacheTTL = 20 // changed while running
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := SaveConfig(path, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MACTableTTL != 120 {
+		t.Errorf("Expected saving to leave the running config alone, got mac_table_ttl %d", cfg.MACTableTTL)
+	}
+
+	saved, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Profile != "tournament" || saved.MACTableTTL != 300 || saved.DedupCacheTTL != 20 {
+		t.Errorf("Expected the profile name, the default mac_table_ttl and the changed dedup_cache_ttl saved, got %q, %d and %d",
+			saved.Profile, saved.MACTableTTL, saved.DedupCacheTTL)
+	}
+	if err := saved.ApplyProfile("hub"); err != nil {
+		t.Fatal(err)
+	}
+	if saved.DedupCacheSize != 256000 || saved.MACTableTTL != 300 || saved.DedupCacheTTL != 20 {
+		t.Errorf("Expected the hub's settings over the saved file, got %+v", saved)
+	}
+}
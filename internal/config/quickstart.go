@@ -0,0 +1,44 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Quickstart: the starter config built into the binary and where its state lives
+
+package config
+
+import (
+	_ "embed"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// starterConfig is the config a quickstart node begins with: the home
+// profile, found on the LAN and reachable through the router where UPnP
+// or NAT-PMP allow.
+//
+//go:embed starter.json
+var starterConfig []byte
+
+// StarterConfig returns the built-in starter config over the defaults.
+func StarterConfig() (*Config, error) {
+	return parseConfig("starter.json", formatJSON, starterConfig)
+}
+
+// StateDir is where a quickstart node keeps its config, keys and the rest
+// of its state: $STATE_DIRECTORY when systemd gives one, /var/lib for
+// root, and $XDG_STATE_HOME or ~/.local/state for anyone else.
+func StateDir() (string, error) {
+	if dir := os.Getenv("STATE_DIRECTORY"); dir != "" {
+		return filepath.SplitList(dir)[0], nil
+	}
+	if os.Geteuid() == 0 {
+		return "/var/lib/ipxtransporter", nil
+	}
+	if dir := os.Getenv("XDG_STATE_HOME"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, "ipxtransporter"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", errors.New("no home directory for the state; give one with --state-dir")
+	}
+	return filepath.Join(home, ".local", "state", "ipxtransporter"), nil
+}
@@ -0,0 +1,42 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for the quickstart starter config and state directory
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStarterConfig(t *testing.T) {
+	cfg, err := StarterConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Profile != "home" || !cfg.EnableHTTP || !cfg.MDNS || cfg.PeerQueueBytes != DefaultConfig().PeerQueueBytes {
+		t.Errorf("Expected the starter settings over the defaults, got %+v", cfg)
+	}
+	// Without the certificate quickstart adds, the only complaint is that.
+	for _, p := range cfg.Validate() {
+		if p.Field != "tls_cert_path" {
+			t.Errorf("Unexpected problem with the starter config: %s", p)
+		}
+	}
+}
+
+func TestStateDir(t *testing.T) {
+	t.Setenv("STATE_DIRECTORY", "/var/lib/ipxt"+string(filepath.ListSeparator)+"/var/lib/other")
+	if dir, _ := StateDir(); dir != "/var/lib/ipxt" {
+		t.Errorf("Expected systemd's state directory, got %s", dir)
+	}
+	if os.Geteuid() == 0 {
+		t.Skip("root uses /var/lib otherwise")
+	}
+	t.Setenv("STATE_DIRECTORY", "")
+	t.Setenv("XDG_STATE_HOME", "/srv/state")
+	if dir, _ := StateDir(); dir != filepath.Join("/srv/state", "ipxtransporter") {
+		t.Errorf("Expected the XDG state directory, got %s", dir)
+	}
+}
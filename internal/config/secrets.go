@@ -0,0 +1,117 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Secrets kept out of the config file, in files or the environment
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// A secret setting can be kept out of the config, so the file can be
+// committed without credentials: its _file setting names a file holding
+// the value, as Docker and systemd credentials provide, and a value of
+// ${NAME} is taken from the environment variable NAME. The config file
+// keeps only the reference; the value is never written back.
+type secret struct {
+	name  string
+	value func(c *Config) *string
+	file  func(c *Config) *string // nil if it has no _file setting
+}
+
+var secrets = []secret{
+	{"network_key", func(c *Config) *string { return &c.NetworkKey }, func(c *Config) *string { return &c.NetworkKeyFile }},
+	{"admin_pass", func(c *Config) *string { return &c.AdminPass }, func(c *Config) *string { return &c.AdminPassFile }},
+	{"jwt_secret", func(c *Config) *string { return &c.JWTSecret }, func(c *Config) *string { return &c.JWTSecretFile }},
+	{"tls_key_path", func(c *Config) *string { return &c.TLSKeyPath }, nil},
+	{"api_auth.oidc.client_secret", func(c *Config) *string { return &c.APIAuth.OIDC.ClientSecret }, nil},
+}
+
+var envRef = regexp.MustCompile(`^\$\{([A-Za-z_][A-Za-z0-9_]*)\}$`)
+
+// loadSecrets reads the secrets the config refers to. A _file setting
+// wins over the value beside it.
+func (c *Config) loadSecrets() error {
+	for _, s := range secrets {
+		v := s.value(c)
+		if ref, ok := c.external[s.name]; ok && ref == "" && s.file != nil && *s.file(c) == "" {
+			// Its _file setting was cleared; the value is the config's again.
+			delete(c.external, s.name)
+		}
+		if s.file != nil && *s.file(c) != "" {
+			path := *s.file(c)
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("%s_file: %v", s.name, err)
+			}
+			// Files written by echo or an editor end in a newline.
+			*v = strings.TrimRight(string(data), "\r\n")
+			if *v == "" {
+				return fmt.Errorf("%s_file: %s is empty", s.name, path)
+			}
+			c.setExternal(s.name, "")
+			continue
+		}
+		if m := envRef.FindStringSubmatch(*v); m != nil {
+			val, ok := os.LookupEnv(m[1])
+			if !ok || val == "" {
+				return fmt.Errorf("%s: environment variable %s is not set", s.name, m[1])
+			}
+			c.setExternal(s.name, *v)
+			*v = val
+		}
+	}
+	return nil
+}
+
+func (c *Config) setExternal(name, ref string) {
+	if c.external == nil {
+		c.external = make(map[string]string)
+	}
+	c.external[name] = ref
+}
+
+// hideSecrets puts back the references of the secrets read from
+// elsewhere, in a copy about to be saved.
+func (c *Config) hideSecrets() {
+	for _, s := range secrets {
+		if s.file != nil && *s.file(c) != "" {
+			*s.value(c) = ""
+		} else if ref, ok := c.external[s.name]; ok {
+			*s.value(c) = ref
+		}
+	}
+}
+
+// externalSecret refuses to change a secret read from elsewhere, which
+// saving the config would not keep.
+func (c *Config) externalSecret(key string) error {
+	// Setting an object replaces the secrets inside it too.
+	name, ref, ok := key, "", false
+	for n, r := range c.external {
+		if n == key || strings.HasPrefix(n, key+".") {
+			name, ref, ok = n, r, true
+			break
+		}
+	}
+	if !ok {
+		return nil
+	}
+	from := name + "_file " + c.secretFile(name)
+	if ref != "" {
+		from = "environment variable " + envRef.FindStringSubmatch(ref)[1]
+	}
+	return fmt.Errorf("%s comes from %s; change it there", name, from)
+}
+
+func (c *Config) secretFile(name string) string {
+	for _, s := range secrets {
+		if s.name == name && s.file != nil {
+			return *s.file(c)
+		}
+	}
+	return ""
+}
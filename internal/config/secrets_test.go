@@ -0,0 +1,96 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for secrets kept out of the config file
+
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSecretsFromFilesAndEnv(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "ipx_key")
+	passFile := filepath.Join(dir, "admin_pass")
+	os.WriteFile(keyFile, []byte("mesh-secret\n"), 0600)
+	os.WriteFile(passFile, []byte("hunter2"), 0600)
+	t.Setenv("IPX_JWT", "jwt-from-env")
+
+	path := writeFile(t, "node.json", `{
+		"network_key_file": "`+keyFile+`",
+		"admin_pass_file": "`+passFile+`",
+		"jwt_secret": "${IPX_JWT}"
+	}`)
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.NetworkKey != "mesh-secret" || cfg.AdminPass != "hunter2" || cfg.JWTSecret != "jwt-from-env" {
+		t.Errorf("Expected the secrets read, got %q, %q and %q", cfg.NetworkKey, cfg.AdminPass, cfg.JWTSecret)
+	}
+
+	if err := cfg.SetSetting("network_key", json.RawMessage(`"other"`)); err == nil || !strings.Contains(err.Error(), "network_key_file") {
+		t.Errorf("Expected a secret from a file not to be set, got %v", err)
+	}
+	if err := cfg.RotateJWTSecret(); err == nil || !strings.Contains(err.Error(), "IPX_JWT") {
+		t.Errorf("Expected a secret from the environment not to be rotated, got %v", err)
+	}
+
+	cfg.MaxChildren = 7
+	if err := SaveConfig(path, cfg); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	for _, leak := range []string{"mesh-secret", "hunter2", "jwt-from-env", "argon2id"} {
+		if strings.Contains(string(data), leak) {
+			t.Errorf("Expected %s not to be saved, got\n%s", leak, data)
+		}
+	}
+	if !strings.Contains(string(data), `"jwt_secret": "${IPX_JWT}"`) {
+		t.Errorf("Expected the reference saved, got\n%s", data)
+	}
+	again, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.NetworkKey != "mesh-secret" || again.JWTSecret != "jwt-from-env" || again.MaxChildren != 7 {
+		t.Errorf("Expected the saved config to read the same, got %q, %q and %d", again.NetworkKey, again.JWTSecret, again.MaxChildren)
+	}
+}
+
+func TestSecretErrors(t *testing.T) {
+	empty := filepath.Join(t.TempDir(), "empty")
+	os.WriteFile(empty, []byte("\n"), 0600)
+	for name, content := range map[string]string{
+		"missing file": `{"network_key_file": "/nonexistent/ipx_key"}`,
+		"empty file":   `{"admin_pass_file": "` + empty + `"}`,
+		"unset env":    `{"tls_key_path": "${IPX_UNSET_FOR_TEST}"}`,
+	} {
+		if _, err := LoadConfig(writeFile(t, "node.json", content)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	// A _file setting given later is read at once and hides the value.
+	cfg := DefaultConfig()
+	cfg.NetworkKey = "inline"
+	key := filepath.Join(t.TempDir(), "key")
+	os.WriteFile(key, []byte("from-file"), 0600)
+	if err := cfg.SetSetting("network_key_file", json.RawMessage(`"`+key+`"`)); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.NetworkKey != "from-file" {
+		t.Errorf("Expected the key read from the file, got %q", cfg.NetworkKey)
+	}
+	path := filepath.Join(t.TempDir(), "node.json")
+	if err := SaveConfig(path, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); strings.Contains(string(data), "from-file") || strings.Contains(string(data), "inline") {
+		t.Errorf("Expected no network key saved, got\n%s", data)
+	}
+}
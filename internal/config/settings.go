@@ -0,0 +1,103 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Reading and changing single settings by their config file key
+
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+const redacted = "********"
+
+// guardedSettings have their own way to be changed, which hashes or
+// generates what the config file stores.
+var guardedSettings = map[string]string{
+	"admin_pass": "/api/password",
+	"api_users":  "the config file",
+	"jwt_secret": "/api/jwt/rotate",
+	"peers":      "/api/peers/add and /api/peers/remove",
+	"room":       "/api/room",
+}
+
+// SetSetting sets the setting with config file key to value, JSON of the
+// setting's type. A list or map is replaced, not merged into. profile
+// switches profiles as ApplyProfile does.
+func (c *Config) SetSetting(key string, value json.RawMessage) error {
+	if how, ok := guardedSettings[key]; ok {
+		return fmt.Errorf("%s cannot be set directly, use %s", key, how)
+	}
+	if err := c.externalSecret(key); err != nil {
+		return err
+	}
+	if key == "profile" {
+		var name string
+		if err := json.Unmarshal(value, &name); err != nil {
+			return fmt.Errorf("profile: %v", err)
+		}
+		return c.ApplyProfile(name)
+	}
+	raw, err := json.Marshal(map[string]json.RawMessage{key: value})
+	if err != nil {
+		return fmt.Errorf("%s: %v", key, err)
+	}
+	// Check the key and the value's type before touching c.
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	var check Config
+	if err := dec.Decode(&check); err != nil {
+		return fmt.Errorf("unknown setting or wrong type: %v", err)
+	}
+	// Set it on a copy and validate that, so c never holds a setting
+	// the node would refuse to start with. null clears a list or map
+	// first, so the value replaces it.
+	authMu.RLock()
+	next := *c
+	authMu.RUnlock()
+	reset, _ := json.Marshal(map[string]any{key: nil})
+	if err := json.Unmarshal(reset, &next); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw, &next); err != nil {
+		return err
+	}
+	// A new _file setting is read at once.
+	next.external = maps.Clone(c.external)
+	if err := next.loadSecrets(); err != nil {
+		return err
+	}
+	if err := next.Validate().Err(); err != nil {
+		return err
+	}
+	authMu.Lock()
+	*c = next
+	authMu.Unlock()
+	return nil
+}
+
+// Redacted returns a copy of c with its passwords and secrets masked, to
+// be shown over the API.
+func (c *Config) Redacted() *Config {
+	authMu.RLock()
+	defer authMu.RUnlock()
+	out := *c
+	for _, s := range []*string{&out.AdminPass, &out.JWTSecret, &out.NetworkKey, &out.APIAuth.OIDC.ClientSecret} {
+		if *s != "" {
+			*s = redacted
+		}
+	}
+	out.APIUsers = slices.Clone(c.APIUsers)
+	for i := range out.APIUsers {
+		out.APIUsers[i].Pass = redacted
+	}
+	if c.Room != nil {
+		room := *c.Room
+		room.Key = redacted
+		out.Room = &room
+	}
+	return &out
+}
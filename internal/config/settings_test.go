@@ -0,0 +1,68 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for reading and changing single settings
+
+package config
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/mlapointe/ipxtransporter/internal/rooms"
+)
+
+func TestSetSetting(t *testing.T) {
+	cfg := DefaultConfig()
+	if err := cfg.SetSetting("max_children", json.RawMessage(`12`)); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MaxChildren != 12 {
+		t.Errorf("Expected max_children 12, got %d", cfg.MaxChildren)
+	}
+
+	cfg.PeerNotes = map[string]string{"10.0.0.1": "old"}
+	if err := cfg.SetSetting("peer_notes", json.RawMessage(`{"10.0.0.2":"new"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.PeerNotes) != 1 || cfg.PeerNotes["10.0.0.2"] != "new" {
+		t.Errorf("Expected peer_notes replaced, got %v", cfg.PeerNotes)
+	}
+
+	for key, value := range map[string]string{
+		"max_childs":   `12`,
+		"max_children": `"twelve"`,
+		"admin_pass":   `"secret"`,
+		"profile":      `"office"`,
+	} {
+		if err := cfg.SetSetting(key, json.RawMessage(value)); err == nil {
+			t.Errorf("Expected %s=%s to be rejected", key, value)
+		}
+	}
+	if cfg.MaxChildren != 12 {
+		t.Errorf("Expected a rejected value to leave max_children alone, got %d", cfg.MaxChildren)
+	}
+
+	if err := cfg.SetSetting("profile", json.RawMessage(`"hub"`)); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Profile != "hub" || cfg.LogLevel != "warn" {
+		t.Errorf("Expected the hub profile applied, got %q and %s", cfg.Profile, cfg.LogLevel)
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.NetworkKey = "lan-party"
+	cfg.APIUsers = []APIUser{{Name: "ops", Pass: "hash", Role: RoleViewer}}
+	cfg.Room = &rooms.Room{Name: "doom", Key: "room-key"}
+	out := cfg.Redacted()
+	if out.NetworkKey != redacted || out.AdminPass != redacted || out.APIUsers[0].Pass != redacted || out.Room.Key != redacted {
+		t.Errorf("Expected the secrets masked, got %+v", out)
+	}
+	if out.JWTSecret != "" {
+		t.Errorf("Expected an empty jwt_secret left empty, got %q", out.JWTSecret)
+	}
+	if cfg.NetworkKey != "lan-party" || cfg.APIUsers[0].Pass != "hash" || cfg.Room.Key != "room-key" {
+		t.Errorf("Expected the config itself left alone, got %+v", cfg)
+	}
+}
@@ -0,0 +1,31 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// User names for IPX sockets, over the built-in database
+
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mlapointe/ipxtransporter/internal/ipx"
+)
+
+// SocketNameMap returns socket_names by socket number.
+func (c *Config) SocketNameMap() (map[uint16]string, error) {
+	names := make(map[uint16]string, len(c.SocketNames))
+	for key, name := range c.SocketNames {
+		socket, err := ipx.ParseSocketNumber(key)
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("socket %s has an empty name", key)
+		}
+		if _, ok := names[socket]; ok {
+			return nil, fmt.Errorf("socket 0x%04X is named twice", socket)
+		}
+		names[socket] = strings.TrimSpace(name)
+	}
+	return names, nil
+}
@@ -0,0 +1,446 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// TOML config files
+
+package config
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+)
+
+// The TOML read is TOML 1.0 but for dates and times, which are read as
+// strings, and inf and nan, which JSON has no place for.
+
+type tomlParser struct {
+	text    string
+	pos     int
+	line    int
+	defined map[*object]bool // tables given a [header]
+}
+
+func parseTOML(data []byte) (any, error) {
+	p := &tomlParser{text: strings.ReplaceAll(withoutBOM(data), "\r\n", "\n"), line: 1, defined: make(map[*object]bool)}
+	root := &object{}
+	cur := root
+	for {
+		p.skip(true)
+		if p.pos == len(p.text) {
+			return root, nil
+		}
+		var err error
+		if p.text[p.pos] == '[' {
+			cur, err = p.header(root)
+		} else {
+			err = p.keyValue(cur)
+		}
+		if err != nil {
+			return nil, err
+		}
+		p.skip(false)
+		if p.pos < len(p.text) && p.text[p.pos] != '\n' {
+			return nil, errorAt(p.line, "expected the end of the line")
+		}
+	}
+}
+
+// skip moves past spaces and comments, and newlines if lines is set.
+func (p *tomlParser) skip(lines bool) {
+	for p.pos < len(p.text) {
+		switch p.text[p.pos] {
+		case ' ', '\t':
+		case '\n':
+			if !lines {
+				return
+			}
+			p.line++
+		case '#':
+			for p.pos < len(p.text) && p.text[p.pos] != '\n' {
+				p.pos++
+			}
+			continue
+		default:
+			return
+		}
+		p.pos++
+	}
+}
+
+func (p *tomlParser) peek(s string) bool {
+	return strings.HasPrefix(p.text[p.pos:], s)
+}
+
+// header reads a [table] or [[array of tables]] line and returns the
+// table the following keys go in.
+func (p *tomlParser) header(root *object) (*object, error) {
+	array := p.peek("[[")
+	if array {
+		p.pos += 2
+	} else {
+		p.pos++
+	}
+	path, err := p.key()
+	if err != nil {
+		return nil, err
+	}
+	p.skip(false)
+	closing := "]"
+	if array {
+		closing = "]]"
+	}
+	if !p.peek(closing) {
+		return nil, errorAt(p.line, "expected %s", closing)
+	}
+	p.pos += len(closing)
+
+	parent, err := p.table(root, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	last := path[len(path)-1]
+	v, ok := parent.get(last)
+	if array {
+		list, isList := v.([]any)
+		if ok && !isList {
+			return nil, errorAt(p.line, "%s is not an array of tables", strings.Join(path, "."))
+		}
+		t := &object{}
+		parent.set(last, append(list, t))
+		return t, nil
+	}
+	t, isTable := v.(*object)
+	switch {
+	case !ok:
+		t = &object{}
+		parent.set(last, t)
+	case !isTable || p.defined[t]:
+		return nil, errorAt(p.line, "%s is given twice", strings.Join(path, "."))
+	}
+	p.defined[t] = true
+	return t, nil
+}
+
+// table walks path from t, making the tables that are missing; an array
+// of tables stands for its last table.
+func (p *tomlParser) table(t *object, path []string) (*object, error) {
+	for _, k := range path {
+		v, ok := t.get(k)
+		if !ok {
+			next := &object{}
+			t.set(k, next)
+			t = next
+			continue
+		}
+		if list, isList := v.([]any); isList && len(list) > 0 {
+			v = list[len(list)-1]
+		}
+		next, isTable := v.(*object)
+		if !isTable {
+			return nil, errorAt(p.line, "%s is not a table", k)
+		}
+		t = next
+	}
+	return t, nil
+}
+
+func (p *tomlParser) keyValue(t *object) error {
+	path, err := p.key()
+	if err != nil {
+		return err
+	}
+	p.skip(false)
+	if !p.peek("=") {
+		return errorAt(p.line, "expected = after %s", strings.Join(path, "."))
+	}
+	p.pos++
+	p.skip(false)
+	v, err := p.value()
+	if err != nil {
+		return err
+	}
+	parent, err := p.table(t, path[:len(path)-1])
+	if err != nil {
+		return err
+	}
+	last := path[len(path)-1]
+	if _, dup := parent.get(last); dup {
+		return errorAt(p.line, "%s is given twice", strings.Join(path, "."))
+	}
+	parent.set(last, v)
+	return nil
+}
+
+var bareKey = regexp.MustCompile(`^[A-Za-z0-9_-]+`)
+
+// key reads a key, dotted into its parts.
+func (p *tomlParser) key() ([]string, error) {
+	var path []string
+	for {
+		p.skip(false)
+		if p.pos == len(p.text) {
+			return nil, errorAt(p.line, "expected a key")
+		}
+		switch c := p.text[p.pos]; c {
+		case '"', '\'':
+			s, err := p.str(c)
+			if err != nil {
+				return nil, err
+			}
+			path = append(path, s.text)
+		default:
+			k := bareKey.FindString(p.text[p.pos:])
+			if k == "" {
+				return nil, errorAt(p.line, "expected a key")
+			}
+			p.pos += len(k)
+			path = append(path, k)
+		}
+		p.skip(false)
+		if !p.peek(".") {
+			return path, nil
+		}
+		p.pos++
+	}
+}
+
+func (p *tomlParser) value() (any, error) {
+	if p.pos == len(p.text) {
+		return nil, errorAt(p.line, "expected a value")
+	}
+	switch c := p.text[p.pos]; c {
+	case '"', '\'':
+		return p.str(c)
+	case '[':
+		p.pos++
+		list := []any{}
+		for {
+			p.skip(true)
+			if p.peek("]") {
+				p.pos++
+				return list, nil
+			}
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+			p.skip(true)
+			if p.peek(",") {
+				p.pos++
+			} else if !p.peek("]") {
+				return nil, errorAt(p.line, "expected , or ]")
+			}
+		}
+	case '{':
+		p.pos++
+		t := &object{}
+		for {
+			p.skip(false)
+			if p.peek("}") {
+				p.pos++
+				return t, nil
+			}
+			if err := p.keyValue(t); err != nil {
+				return nil, err
+			}
+			p.skip(false)
+			if p.peek(",") {
+				p.pos++
+			} else if !p.peek("}") {
+				return nil, errorAt(p.line, "expected , or }")
+			}
+		}
+	}
+	start, line := p.pos, p.line
+	for p.pos < len(p.text) && !strings.ContainsRune(" \t\n,]}#", rune(p.text[p.pos])) {
+		p.pos++
+	}
+	word := p.text[start:p.pos]
+	if word == "true" || word == "false" {
+		return scalar{text: word, line: line}, nil
+	}
+	if _, ok := number(word); ok {
+		return scalar{text: word, line: line}, nil
+	}
+	if date.MatchString(word) {
+		return scalar{text: word, quoted: true, line: line}, nil
+	}
+	return nil, errorAt(line, "unknown value %q", word)
+}
+
+var date = regexp.MustCompile(`^[0-9]{4}-[0-9]{2}-[0-9]{2}|^[0-9]{2}:[0-9]{2}:[0-9]{2}`)
+
+// str reads a basic or literal string, on one line or several.
+func (p *tomlParser) str(q byte) (scalar, error) {
+	line := p.line
+	delim := string(q)
+	if p.peek(strings.Repeat(delim, 3)) {
+		delim = strings.Repeat(delim, 3)
+	}
+	p.pos += len(delim)
+	multi := len(delim) == 3
+	if multi && p.peek("\n") {
+		// A newline right after the opening quotes is not part of it.
+		p.pos++
+		p.line++
+	}
+	start := p.pos
+	for {
+		if p.pos >= len(p.text) || (!multi && p.text[p.pos] == '\n') {
+			return scalar{}, errorAt(line, "unterminated string")
+		}
+		switch c := p.text[p.pos]; {
+		case c == '\\' && q == '"':
+			if strings.HasPrefix(p.text[p.pos+1:], "\n") {
+				p.line++
+			}
+			p.pos += 2
+			continue
+		case c == '\n':
+			p.line++
+		case p.peek(delim):
+			// Up to two quotes may end a multi-line string's contents.
+			for multi && strings.HasPrefix(p.text[p.pos+1:], delim) {
+				p.pos++
+			}
+			s := p.text[start:p.pos]
+			p.pos += len(delim)
+			if q == '\'' {
+				return scalar{text: s, quoted: true, line: line}, nil
+			}
+			if multi {
+				// A backslash at the end of a line trims the line
+				// break and the whitespace after it.
+				s = lineContinuation.ReplaceAllString(s, "")
+			}
+			s, err := unescape(s, line)
+			return scalar{text: s, quoted: true, line: line}, err
+		}
+		p.pos++
+	}
+}
+
+var lineContinuation = regexp.MustCompile(`\\[ \t]*\n[ \t\n]*`)
+
+var tomlTable = regexp.MustCompile(`^\[\[?\s*([A-Za-z0-9_-]+)`)
+
+// tomlTopKey tells the name of the top-level setting line starts: a key
+// before the first table, or the first part of a table header.
+func tomlTopKey(line string) (string, bool) {
+	if m := tomlTable.FindStringSubmatch(line); m != nil {
+		return m[1], true
+	}
+	if line == "" || line[0] == ' ' || line[0] == '\t' {
+		return "", false
+	}
+	if k := bareKey.FindString(line); k != "" {
+		if rest := strings.TrimLeft(line[len(k):], " \t"); strings.HasPrefix(rest, "=") || strings.HasPrefix(rest, ".") {
+			return k, true
+		}
+	}
+	return "", false
+}
+
+// encodeTOML writes the values of each table before its subtables, as
+// TOML wants; tables are written as [headers] and arrays of them as
+// [[headers]], so peer_notes and api_users read as they would by hand.
+func encodeTOML(doc *object, c comments) []byte {
+	var b bytes.Buffer
+	c.writeHeader(&b)
+	tomlTableBody(&b, nil, doc, &c)
+	c.writeTrailing(&b)
+	return bytes.TrimLeft(b.Bytes(), "\n")
+}
+
+// tomlTableBody writes t, at path; c is set for the top level only.
+func tomlTableBody(b *bytes.Buffer, path []string, t *object, c *comments) {
+	above := func(key string) {
+		if c != nil {
+			c.writeAbove(b, key)
+		}
+	}
+	for _, m := range t.members {
+		if m.val == nil || isTOMLTable(m.val) || isTOMLTableArray(m.val) {
+			continue
+		}
+		above(m.key)
+		b.WriteString(tomlKey(m.key) + " = " + tomlValue(m.val) + "\n")
+	}
+	for _, m := range t.members {
+		sub := append(path[:len(path):len(path)], tomlKey(m.key))
+		switch {
+		case isTOMLTable(m.val):
+			b.WriteByte('\n')
+			above(m.key)
+			b.WriteString("[" + strings.Join(sub, ".") + "]\n")
+			tomlTableBody(b, sub, m.val.(*object), nil)
+		case isTOMLTableArray(m.val):
+			for i, v := range m.val.([]any) {
+				b.WriteByte('\n')
+				if i == 0 {
+					above(m.key)
+				}
+				b.WriteString("[[" + strings.Join(sub, ".") + "]]\n")
+				tomlTableBody(b, sub, v.(*object), nil)
+			}
+		}
+	}
+}
+
+func isTOMLTable(v any) bool {
+	_, ok := v.(*object)
+	return ok
+}
+
+func isTOMLTableArray(v any) bool {
+	list, ok := v.([]any)
+	if !ok || len(list) == 0 {
+		return false
+	}
+	for _, item := range list {
+		if !isTOMLTable(item) {
+			return false
+		}
+	}
+	return true
+}
+
+func tomlKey(k string) string {
+	if k != "" && bareKey.FindString(k) == k {
+		return k
+	}
+	return quote(k)
+}
+
+// tomlValue writes v inline. TOML has no null, so nulls in arrays and
+// inline tables are left out.
+func tomlValue(v any) string {
+	switch v := v.(type) {
+	case scalar:
+		if v.quoted {
+			return quote(v.text)
+		}
+		return v.text
+	case []any:
+		parts := make([]string, 0, len(v))
+		for _, item := range v {
+			if item != nil {
+				parts = append(parts, tomlValue(item))
+			}
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	case *object:
+		parts := make([]string, 0, len(v.members))
+		for _, m := range v.members {
+			if m.val != nil {
+				parts = append(parts, tomlKey(m.key)+" = "+tomlValue(m.val))
+			}
+		}
+		if len(parts) == 0 {
+			return "{}"
+		}
+		return "{ " + strings.Join(parts, ", ") + " }"
+	}
+	return `""`
+}
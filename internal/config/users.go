@@ -0,0 +1,123 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// API users beside the admin and the roles they hold
+
+package config
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+)
+
+// API roles. Viewers read stats, logs and exports; admins also ban,
+// disconnect and change the configuration.
+const (
+	RoleViewer = "viewer"
+	RoleAdmin  = "admin"
+)
+
+// roleRank orders the roles so a higher one includes a lower one.
+var roleRank = map[string]int{RoleViewer: 1, RoleAdmin: 2}
+
+// RoleAllows reports whether role may do what need requires.
+func RoleAllows(role, need string) bool {
+	return roleRank[role] > 0 && roleRank[role] >= roleRank[need]
+}
+
+// APIUser is an API login beside admin_user. Pass is hashed like
+// admin_pass.
+type APIUser struct {
+	Name string `json:"name"`
+	Pass string `json:"pass"`
+	Role string `json:"role"`
+}
+
+// ValidateUsers checks api_users for missing names, unknown roles and names
+// taken twice or by admin_user.
+func (c *Config) ValidateUsers() error {
+	seen := map[string]bool{c.AdminUser: true}
+	for i, u := range c.APIUsers {
+		if u.Name == "" {
+			return fmt.Errorf("api_users: user %d has no name", i+1)
+		}
+		if seen[u.Name] {
+			return fmt.Errorf("api_users: user %q is defined twice", u.Name)
+		}
+		seen[u.Name] = true
+		if roleRank[u.Role] == 0 {
+			return fmt.Errorf("api_users: user %q has unknown role %q, want viewer or admin", u.Name, u.Role)
+		}
+	}
+	return nil
+}
+
+// Authenticate checks a login against admin_user and api_users and returns
+// the user's role. Only the password of the named user is hashed, so a
+// login costs one hash whatever the number of users; an unknown name is
+// checked against a dummy hash, so the time taken does not tell which
+// user names exist.
+func (c *Config) Authenticate(user, pass string) (string, bool) {
+	stored, role, ok := c.lookupUser(user)
+	if !ok {
+		checkPassword(dummyHash(), pass)
+		return "", false
+	}
+	if !checkPassword(stored, pass) {
+		return "", false
+	}
+	return role, true
+}
+
+// lookupUser returns the stored password and role of user.
+func (c *Config) lookupUser(user string) (string, string, bool) {
+	authMu.RLock()
+	defer authMu.RUnlock()
+	if user == c.AdminUser {
+		return c.AdminPass, RoleAdmin, true
+	}
+	for _, u := range c.APIUsers {
+		if u.Name == user {
+			return u.Pass, u.Role, true
+		}
+	}
+	return "", "", false
+}
+
+// checkPassword is CheckPassword, replaced in tests to count the hashes.
+var checkPassword = CheckPassword
+
+var (
+	dummyOnce sync.Once
+	dummy     string
+)
+
+// dummyHash is a hash no password is known to match, to spend on logins
+// of unknown users the time a real check takes.
+func dummyHash() string {
+	dummyOnce.Do(func() {
+		dummy, _ = HashPassword(rand.Text())
+	})
+	return dummy
+}
+
+// UserTokenKey returns the key a user's tokens are signed with and the
+// user's role. Like TokenKey, it changes with the user's password and role,
+// revoking the tokens issued before.
+func (c *Config) UserTokenKey(user string) ([]byte, string, bool) {
+	authMu.RLock()
+	defer authMu.RUnlock()
+	if user == c.AdminUser {
+		return c.tokenKeyLocked(), RoleAdmin, true
+	}
+	for _, u := range c.APIUsers {
+		if u.Name == user {
+			mac := hmac.New(sha256.New, []byte(c.JWTSecret))
+			mac.Write([]byte(u.Name + "\x00" + u.Pass + "\x00" + u.Role))
+			return mac.Sum(nil), u.Role, true
+		}
+	}
+	return nil, "", false
+}
@@ -0,0 +1,99 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for API users and roles
+
+package config
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestAuthenticate(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.APIUsers = []APIUser{{Name: "status", Pass: "look", Role: RoleViewer}, {Name: "ops", Pass: "fix", Role: RoleAdmin}}
+	if _, err := cfg.HashPasswords(); err != nil {
+		t.Fatal(err)
+	}
+	if !IsPasswordHash(cfg.APIUsers[0].Pass) {
+		t.Errorf("Expected API user passwords hashed, got %q", cfg.APIUsers[0].Pass)
+	}
+
+	for _, tc := range []struct {
+		user, pass, role string
+	}{
+		{"admin", "admin", RoleAdmin},
+		{"status", "look", RoleViewer},
+		{"ops", "fix", RoleAdmin},
+		{"status", "fix", ""},
+		{"nobody", "look", ""},
+	} {
+		role, ok := cfg.Authenticate(tc.user, tc.pass)
+		if role != tc.role || ok != (tc.role != "") {
+			t.Errorf("Expected %s/%s to log in as %q, got %q", tc.user, tc.pass, tc.role, role)
+		}
+	}
+}
+
+func TestAuthenticateHashesOnce(t *testing.T) {
+	cfg := DefaultConfig()
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		cfg.APIUsers = append(cfg.APIUsers, APIUser{Name: name, Pass: "pw", Role: RoleViewer})
+	}
+	if _, err := cfg.HashPasswords(); err != nil {
+		t.Fatal(err)
+	}
+	dummyHash()
+	hashes := 0
+	checkPassword = func(stored, pass string) bool {
+		hashes++
+		return CheckPassword(stored, pass)
+	}
+	defer func() { checkPassword = CheckPassword }()
+
+	for _, user := range []string{"c", "nobody", "admin"} {
+		hashes = 0
+		cfg.Authenticate(user, "wrong")
+		if hashes != 1 {
+			t.Errorf("Expected one hash for a login as %q, got %d", user, hashes)
+		}
+	}
+}
+
+func TestUserTokenKey(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.APIUsers = []APIUser{{Name: "status", Pass: "look", Role: RoleViewer}}
+
+	if key, role, ok := cfg.UserTokenKey("admin"); !ok || role != RoleAdmin || !bytes.Equal(key, cfg.TokenKey()) {
+		t.Errorf("Expected the admin key and role, got %q", role)
+	}
+	key, role, ok := cfg.UserTokenKey("status")
+	if !ok || role != RoleViewer {
+		t.Fatalf("Expected the viewer role, got %q", role)
+	}
+	cfg.APIUsers[0].Role = RoleAdmin
+	if newKey, _, _ := cfg.UserTokenKey("status"); bytes.Equal(key, newKey) {
+		t.Error("Expected a new key after a role change")
+	}
+	if _, _, ok := cfg.UserTokenKey("nobody"); ok {
+		t.Error("Expected no key for an unknown user")
+	}
+}
+
+func TestValidateUsers(t *testing.T) {
+	for name, users := range map[string][]APIUser{
+		"no name":   {{Pass: "x", Role: RoleViewer}},
+		"bad role":  {{Name: "a", Pass: "x", Role: "root"}},
+		"duplicate": {{Name: "a", Role: RoleViewer}, {Name: "a", Role: RoleAdmin}},
+		"admin":     {{Name: "admin", Role: RoleViewer}},
+	} {
+		cfg := DefaultConfig()
+		cfg.APIUsers = users
+		if cfg.ValidateUsers() == nil {
+			t.Errorf("Expected %s to be rejected", name)
+		}
+	}
+	if !RoleAllows(RoleAdmin, RoleViewer) || RoleAllows(RoleViewer, RoleAdmin) || RoleAllows("", RoleViewer) {
+		t.Error("Expected admins to hold the viewer role and not the other way round")
+	}
+}
@@ -0,0 +1,263 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Config validation: every problem at once, by setting
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+)
+
+// Problem is something wrong with one setting and what to do about it. A
+// fatal problem keeps the node from starting and a config holding one
+// from being saved; the others are warnings.
+type Problem struct {
+	Field   string `json:"field"` // config file key
+	Message string `json:"message"`
+	Fatal   bool   `json:"fatal"`
+}
+
+func (p Problem) String() string {
+	return p.Field + ": " + p.Message
+}
+
+// Problems are what Validate found, in the order of the settings.
+type Problems []Problem
+
+// Err returns an error listing the fatal problems, one per line, or nil
+// if there are none.
+func (ps Problems) Err() error {
+	var lines []string
+	for _, p := range ps {
+		if p.Fatal {
+			lines = append(lines, p.String())
+		}
+	}
+	switch len(lines) {
+	case 0:
+		return nil
+	case 1:
+		return errors.New(lines[0])
+	}
+	return fmt.Errorf("%d problems in the config:\n  %s", len(lines), strings.Join(lines, "\n  "))
+}
+
+// Warnings returns the problems that are not fatal.
+func (ps Problems) Warnings() []Problem {
+	var out []Problem
+	for _, p := range ps {
+		if !p.Fatal {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// Validate checks the settings that can be judged without starting the
+// node and returns every problem found, not just the first. Settings the
+// relay parses itself, such as local_networks or self_heal, are checked
+// when it starts.
+func (c *Config) Validate() Problems {
+	var ps Problems
+	fatal := func(field, format string, args ...any) {
+		ps = append(ps, Problem{Field: field, Message: fmt.Sprintf(format, args...), Fatal: true})
+	}
+	warn := func(field, format string, args ...any) {
+		ps = append(ps, Problem{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+	positive := func(field string, v int) {
+		if v <= 0 {
+			fatal(field, "must be positive, got %d", v)
+		}
+	}
+	notNegative := func(field string, v int) {
+		if v < 0 {
+			fatal(field, "must not be negative, got %d", v)
+		}
+	}
+
+	if c.ListenAddr == "" {
+		fatal("listen_addr", "is empty; set the address peers link to, e.g. \":8787\"")
+	} else if err := checkHostPort(c.ListenAddr); err != nil {
+		fatal("listen_addr", "%v", err)
+	}
+	acme := len(c.ACME.Domains) > 0
+	if !c.DisableSSL {
+		switch {
+		case c.TLSCertPath == "" && c.TLSKeyPath == "":
+			if !acme {
+				warn("tls_cert_path", "TLS is on but no certificate is set, so no peer can link in; set tls_cert_path and tls_key_path (ipxtransporter --gen-cert makes them), acme domains, or disable_ssl")
+			}
+		case c.TLSCertPath == "":
+			fatal("tls_cert_path", "is empty but tls_key_path is set; set both or neither")
+		case c.TLSKeyPath == "":
+			fatal("tls_key_path", "is empty but tls_cert_path is set; set both or neither")
+		default:
+			if _, err := os.Stat(c.TLSCertPath); err != nil {
+				fatal("tls_cert_path", "%v", err)
+			}
+			if _, err := os.Stat(c.TLSKeyPath); err != nil {
+				fatal("tls_key_path", "%v", err)
+			}
+		}
+	}
+	if a := c.ACME; acme {
+		if c.DisableSSL {
+			warn("acme", "is set but disable_ssl is on, so the certificate is not used")
+		}
+		switch a.Challenge {
+		case "http-01":
+			if err := checkHostPort(a.HTTPAddr); err != nil {
+				fatal("acme", "http_addr: %v", err)
+			}
+		case "tls-alpn-01":
+			if _, port, _ := net.SplitHostPort(c.ListenAddr); port != "443" {
+				warn("acme", "tls-alpn-01 is answered on listen_addr, but the CA only connects to port 443")
+			}
+		case "dns-01":
+			if a.DNSHook == "" {
+				fatal("acme", "dns-01 needs dns_hook, the command publishing the TXT record")
+			}
+		default:
+			fatal("acme", "challenge must be http-01, tls-alpn-01 or dns-01, got %q", a.Challenge)
+		}
+		for _, d := range a.Domains {
+			switch {
+			case strings.HasPrefix(d, "*.") && a.Challenge != "dns-01":
+				fatal("acme", "%q: a wildcard name needs the dns-01 challenge", d)
+			case net.ParseIP(d) != nil || !strings.Contains(d, "."):
+				fatal("acme", "%q is not a public DNS name", d)
+			}
+		}
+		if a.CacheDir == "" {
+			fatal("acme", "cache_dir is empty; without it every start requests new certificates and runs into the CA's rate limits")
+		}
+	}
+	for i := range c.Peers {
+		p := &c.Peers[i]
+		if err := p.Validate(c.DisableSSL); err != nil {
+			fatal("peers", "%q: %v", p.Addr, err)
+			continue
+		}
+		// A host alone is dialed at the default port.
+		if !strings.Contains(p.Addr, ":") {
+			continue
+		}
+		if err := checkHostPort(p.Addr); err != nil {
+			warn("peers", "%q: %v; it cannot be dialed", p.Addr, err)
+		}
+	}
+	if c.EnableHTTP {
+		if err := checkHostPort(c.HTTPListenAddr); err != nil {
+			fatal("http_listen_addr", "%v; set it or turn enable_http off", err)
+		}
+	}
+	if _, err := logger.ParseLevel(c.LogLevel); err != nil {
+		fatal("log_level", "%v; use debug, info, warn or error", err)
+	}
+	positive("dedup_cache_size", c.DedupCacheSize)
+	positive("dedup_cache_ttl", c.DedupCacheTTL)
+	if _, err := CompileHostPatterns(c.BannedHosts); err != nil {
+		fatal("banned_hosts", "%v", err)
+	}
+	if _, err := CompileHostPatterns(c.AllowedHosts); err != nil {
+		fatal("allowed_hosts", "%v", err)
+	}
+	if c.AllowList && len(c.AllowedHosts) == 0 && len(c.AllowedIDs) == 0 && len(c.Peers) == 0 {
+		warn("allow_list", "is on with nothing allowed, so no peer can link")
+	}
+	if err := c.ValidateUsers(); err != nil {
+		fatal("api_users", "%v", err)
+	}
+	if err := c.ValidateAuth(); err != nil {
+		fatal("api_auth", "%v", err)
+	}
+	notNegative("max_children", c.MaxChildren)
+	positive("rebalance_interval", c.RebalanceInterval)
+	if c.TokenTTL <= 0 || c.SessionTTL < c.TokenTTL {
+		fatal("token_ttl", "must be positive and no longer than session_ttl, got %d and %d", c.TokenTTL, c.SessionTTL)
+	}
+	positive("inject_workers", c.InjectWorkers)
+	positive("broadcast_workers", c.BroadcastWorkers)
+	notNegative("writer_workers", c.WriterWorkers)
+	positive("peer_queue_bytes", c.PeerQueueBytes)
+	positive("mac_table_ttl", c.MACTableTTL)
+	notNegative("hub_priority", c.HubPriority)
+	notNegative("hub_failover_delay", c.HubFailoverDelay)
+	notNegative("max_auto_peers", c.MaxAutoPeers)
+	notNegative("stats_log_interval", c.StatsLogInterval)
+	notNegative("config_backups", c.ConfigBackups)
+	if c.ExportPath != "" {
+		positive("export_interval", c.ExportInterval)
+	}
+	if c.Beacon {
+		positive("beacon_interval", c.BeaconInterval)
+	}
+	positive("capture_max_bytes", c.CaptureMaxBytes)
+	if c.APIRateLimit < 0 || c.APIRateLimit > 0 && c.APIRateBurst < 1 {
+		fatal("api_rate_limit", "must not be negative and api_rate_burst at least 1, got %g and %d", c.APIRateLimit, c.APIRateBurst)
+	}
+	if c.LoginMaxFailures <= 0 || c.LoginLockout <= 0 {
+		fatal("login_max_failures", "and login_lockout must be positive, got %d and %d", c.LoginMaxFailures, c.LoginLockout)
+	}
+	notNegative("auth_fail_max", c.AuthFailMax)
+	if c.AuthFailMax > 0 {
+		positive("auth_fail_window", c.AuthFailWindow)
+		positive("auth_fail_ban", c.AuthFailBan)
+	}
+	if fe := c.FlowExport; fe.Collector != "" {
+		if err := checkHostPort(fe.Collector); err != nil {
+			fatal("flow_export", "collector: %v", err)
+		}
+		if fe.Protocol != "ipfix" && fe.Protocol != "netflow9" {
+			fatal("flow_export", "protocol must be ipfix or netflow9, got %q", fe.Protocol)
+		}
+		if fe.ActiveTimeout <= 0 || fe.IdleTimeout <= 0 {
+			fatal("flow_export", "active_timeout and idle_timeout must be positive, got %d and %d", fe.ActiveTimeout, fe.IdleTimeout)
+		}
+	}
+	for i, col := range c.PeerTable {
+		switch {
+		case !slices.Contains(PeerTableColumns, col):
+			fatal("peer_table", "unknown column %q; the columns are %s", col, strings.Join(PeerTableColumns, ", "))
+		case slices.Index(c.PeerTable, col) < i:
+			fatal("peer_table", "column %q is listed twice", col)
+		}
+	}
+	notNegative("peer_rate_warn", c.PeerRateWarn)
+	notNegative("peer_rate_alert", c.PeerRateAlert)
+	if c.PeerRateAlert > 0 && c.PeerRateWarn > c.PeerRateAlert {
+		fatal("peer_rate_warn", "must not be above peer_rate_alert, got %d and %d", c.PeerRateWarn, c.PeerRateAlert)
+	}
+	if c.Theme != "" && !slices.Contains(Themes, c.Theme) {
+		fatal("theme", "must be default, light, monochrome or high-contrast, got %q", c.Theme)
+	}
+	if _, err := c.SocketNameMap(); err != nil {
+		fatal("socket_names", "%v", err)
+	}
+	if c.ReplicateConfig && c.StandbyOf != "" {
+		fatal("standby_of", "cannot be set with replicate_config: a standby only mirrors its primary")
+	}
+	return ps
+}
+
+// checkHostPort checks addr is host:port with a port number, the host
+// may be empty.
+func checkHostPort(addr string) error {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return fmt.Errorf("bad port %q in %q", port, addr)
+	}
+	return nil
+}
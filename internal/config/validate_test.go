@@ -0,0 +1,147 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for config validation
+
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	cfg := DefaultConfig()
+	ps := cfg.Validate()
+	if err := ps.Err(); err != nil {
+		t.Fatalf("Expected the defaults to be valid, got %v", err)
+	}
+	if w := ps.Warnings(); len(w) != 1 || w[0].Field != "tls_cert_path" {
+		t.Errorf("Expected a warning about the missing certificate, got %v", w)
+	}
+
+	cfg.MaxChildren = -1
+	cfg.ListenAddr = ""
+	cfg.TLSCertPath = "/nonexistent/cert.pem"
+	cfg.BannedHosts = []string{"10.0.0.0/33"}
+	cfg.Peers = []PeerConfig{{Addr: "hub.example.net"}, {Addr: "hub.example.net:port"}}
+	var fields []string
+	for _, p := range cfg.Validate() {
+		fields = append(fields, p.Field)
+	}
+	if got := strings.Join(fields, " "); got != "listen_addr tls_key_path peers banned_hosts max_children" {
+		t.Errorf("Expected every problem in the order of the settings, got %s", got)
+	}
+	err := cfg.Validate().Err()
+	if err == nil || !strings.Contains(err.Error(), "4 problems") || !strings.Contains(err.Error(), "max_children: must not be negative") {
+		t.Errorf("Expected the fatal problems listed, got %v", err)
+	}
+
+	cfg = DefaultConfig()
+	if err := cfg.SetSetting("dedup_cache_ttl", json.RawMessage(`0`)); err == nil || cfg.DedupCacheTTL != 30 {
+		t.Errorf("Expected an invalid value to be refused and not set, got %v and %d", err, cfg.DedupCacheTTL)
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	cfg.LoginLockout = 0
+	if err := SaveConfig(path, cfg); err == nil {
+		t.Error("Expected an invalid config not to be saved")
+	}
+}
+
+func TestValidateACME(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ACME.Domains = []string{"relay.example.net"}
+	ps := cfg.Validate()
+	if err := ps.Err(); err != nil || len(ps.Warnings()) != 0 {
+		t.Errorf("Expected ACME to stand in for the certificate, got %v", ps)
+	}
+
+	cfg.ACME.Domains = []string{"*.example.net", "203.0.113.5"}
+	cfg.ACME.Challenge = "dns-01"
+	var msgs []string
+	for _, p := range cfg.Validate() {
+		msgs = append(msgs, p.String())
+	}
+	got := strings.Join(msgs, "; ")
+	if !strings.Contains(got, "needs dns_hook") || !strings.Contains(got, `"203.0.113.5" is not a public DNS name`) || strings.Contains(got, "wildcard") {
+		t.Errorf("Expected the missing hook and the address refused, a wildcard taken, got %s", got)
+	}
+	cfg.ACME.Challenge = "http-01"
+	if err := cfg.Validate().Err(); err == nil || !strings.Contains(err.Error(), "wildcard name needs the dns-01 challenge") {
+		t.Errorf("Expected a wildcard refused for http-01, got %v", err)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(path, []byte("{\n  \"listen_addr\": \":8787\",\n  \"max_children\": \"five\"\n}\n"), 0600)
+	if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), ":3:") || !strings.Contains(err.Error(), "max_children") {
+		t.Errorf("Expected the line and setting of a wrong type, got %v", err)
+	}
+	os.WriteFile(path, []byte("{\n  \"listen_addr\": \":8787\",\n}\n"), 0600)
+	if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), ":3:") {
+		t.Errorf("Expected the line of a syntax error, got %v", err)
+	}
+}
+
+func TestSocketNames(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.SocketNames = map[string]string{"0x5a00": " Raptor ", "7001": "Blood"}
+	names, err := cfg.SocketNameMap()
+	if err != nil || names[0x5A00] != "Raptor" || names[0x7001] != "Blood" {
+		t.Errorf("Expected the names by socket, got %v, %v", names, err)
+	}
+	cfg.SocketNames = map[string]string{"0x5A00": "Raptor", "5a00": "Blood"}
+	if err := cfg.Validate().Err(); err == nil || !strings.Contains(err.Error(), "named twice") {
+		t.Errorf("Expected a socket named twice refused, got %v", err)
+	}
+	cfg.SocketNames = map[string]string{"doom": "Doom"}
+	if err := cfg.Validate().Err(); err == nil || !strings.Contains(err.Error(), "socket_names") {
+		t.Errorf("Expected a socket that is not hex refused, got %v", err)
+	}
+}
+
+func TestValidateTheme(t *testing.T) {
+	cfg := DefaultConfig()
+	for _, theme := range append(Themes, "") {
+		cfg.Theme = theme
+		if err := cfg.Validate().Err(); err != nil {
+			t.Errorf("Expected theme %q accepted, got %v", theme, err)
+		}
+	}
+	cfg.Theme = "solarized"
+	if err := cfg.Validate().Err(); err == nil || !strings.Contains(err.Error(), "theme") {
+		t.Errorf("Expected an unknown theme refused, got %v", err)
+	}
+}
+
+func TestValidatePeerTable(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.PeerTable = []string{"hostname", "latency", "rx_rate"}
+	if err := cfg.Validate().Err(); err != nil {
+		t.Errorf("Expected a chosen layout accepted, got %v", err)
+	}
+	for _, table := range [][]string{{"id", "whois"}, {"id", "ip", "id"}} {
+		cfg.PeerTable = table
+		if err := cfg.Validate().Err(); err == nil || !strings.Contains(err.Error(), "peer_table") {
+			t.Errorf("Expected %v refused, got %v", table, err)
+		}
+	}
+}
+
+func TestValidatePeerRates(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.PeerRateWarn, cfg.PeerRateAlert = 0, 1000
+	if err := cfg.Validate().Err(); err != nil {
+		t.Errorf("Expected the warning level turned off accepted, got %v", err)
+	}
+	for _, rates := range [][2]int{{-1, 1000}, {2000, 1000}} {
+		cfg.PeerRateWarn, cfg.PeerRateAlert = rates[0], rates[1]
+		if err := cfg.Validate().Err(); err == nil || !strings.Contains(err.Error(), "peer_rate_warn") {
+			t.Errorf("Expected %v refused, got %v", rates, err)
+		}
+	}
+}
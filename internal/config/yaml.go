@@ -0,0 +1,523 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// YAML config files
+
+package config
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+)
+
+// The YAML read is the block style Ansible's to_nice_yaml and hand-written
+// files use: mappings, sequences, plain and quoted scalars, literal and
+// folded block scalars, and flow sequences and mappings on one line.
+// Anchors, aliases, tags and multiple documents are refused.
+
+type yamlLine struct {
+	indent int
+	text   string // without indentation and comment
+	num    int
+}
+
+type yamlParser struct {
+	raw   []string
+	lines []yamlLine
+	pos   int
+}
+
+func parseYAML(data []byte) (any, error) {
+	p := &yamlParser{raw: strings.Split(strings.ReplaceAll(withoutBOM(data), "\r\n", "\n"), "\n")}
+	for i, raw := range p.raw {
+		text := stripComment(raw)
+		trimmed := strings.TrimLeft(text, " ")
+		if strings.TrimSpace(trimmed) == "" || strings.HasPrefix(raw, "%") {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, errorAt(i+1, "tabs cannot indent YAML")
+		}
+		if raw == "---" || strings.HasPrefix(raw, "--- ") {
+			if len(p.lines) > 0 {
+				return nil, errorAt(i+1, "only one YAML document is read")
+			}
+			continue
+		}
+		if raw == "..." {
+			break
+		}
+		p.lines = append(p.lines, yamlLine{len(text) - len(trimmed), strings.TrimRight(trimmed, " \t"), i + 1})
+	}
+	if len(p.lines) == 0 {
+		return nil, nil
+	}
+	v, err := p.node(p.lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.lines) {
+		return nil, errorAt(p.lines[p.pos].num, "unexpected indentation")
+	}
+	return v, nil
+}
+
+// stripComment cuts a comment off a line. A # starts one at the start or
+// after a space, outside quotes.
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			// Only a quote opening a scalar starts a quoted string;
+			// in "Mark's node" it is an apostrophe.
+			before := strings.TrimRight(line[:i], " ")
+			if before == "" || strings.ContainsAny(before[len(before)-1:], ":-[{,") {
+				quote = c
+			}
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func isSeqItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// node reads the block node whose lines are at indent.
+func (p *yamlParser) node(indent int) (any, error) {
+	l := p.lines[p.pos]
+	switch {
+	case isSeqItem(l.text):
+		return p.sequence(indent)
+	case l.text[0] != '[' && l.text[0] != '{' && keySep(l.text) >= 0:
+		return p.mapping(indent)
+	}
+	p.pos++
+	return p.inline(l.text, l.num)
+}
+
+func (p *yamlParser) sequence(indent int) (any, error) {
+	list := []any{}
+	for p.pos < len(p.lines) {
+		l := p.lines[p.pos]
+		if l.indent < indent || (l.indent == indent && !isSeqItem(l.text)) {
+			break
+		}
+		if l.indent > indent {
+			return nil, errorAt(l.num, "unexpected indentation")
+		}
+		rest := strings.TrimLeft(l.text[1:], " ")
+		if rest == "" {
+			p.pos++
+			v, err := p.nested(indent, false)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+			continue
+		}
+		// "- key: value" starts a mapping whose keys line up with key;
+		// read the item as if the dash were a space.
+		p.lines[p.pos] = yamlLine{l.indent + len(l.text) - len(rest), rest, l.num}
+		v, err := p.node(p.lines[p.pos].indent)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+	return list, nil
+}
+
+// nested reads the node under a key or dash at indent with nothing after
+// it, null if there is none. A sequence may sit at the indent of its key.
+func (p *yamlParser) nested(indent int, key bool) (any, error) {
+	if p.pos == len(p.lines) {
+		return nil, nil
+	}
+	l := p.lines[p.pos]
+	if l.indent > indent {
+		return p.node(l.indent)
+	}
+	if key && l.indent == indent && isSeqItem(l.text) {
+		return p.sequence(indent)
+	}
+	return nil, nil
+}
+
+func (p *yamlParser) mapping(indent int) (any, error) {
+	o := &object{}
+	for p.pos < len(p.lines) {
+		l := p.lines[p.pos]
+		if l.indent < indent {
+			break
+		}
+		if l.indent > indent || isSeqItem(l.text) {
+			return nil, errorAt(l.num, "unexpected indentation")
+		}
+		i := keySep(l.text)
+		if i < 0 {
+			return nil, errorAt(l.num, "expected key: value")
+		}
+		k, err := p.inline(strings.TrimSpace(l.text[:i]), l.num)
+		if err != nil {
+			return nil, err
+		}
+		ks, ok := k.(scalar)
+		if !ok {
+			return nil, errorAt(l.num, "keys must be scalars")
+		}
+		key := ks.text
+		if _, dup := o.get(key); dup {
+			return nil, errorAt(l.num, "%s is given twice", key)
+		}
+		rest := strings.TrimSpace(l.text[i+1:])
+		p.pos++
+		var v any
+		switch {
+		case rest == "":
+			v, err = p.nested(indent, true)
+		case rest[0] == '|' || rest[0] == '>':
+			v, err = p.blockScalar(rest, indent, l.num)
+		default:
+			v, err = p.inline(rest, l.num)
+		}
+		if err != nil {
+			return nil, err
+		}
+		o.set(key, v)
+	}
+	return o, nil
+}
+
+// keySep is where the colon ending the key of text is, or -1. A space or
+// tab follows the colon, or nothing.
+func keySep(text string) int {
+	i := 0
+	if text != "" && (text[0] == '"' || text[0] == '\'') {
+		end := closingQuote(text, text[0])
+		if end < 0 {
+			return -1
+		}
+		i = end + 1
+	}
+	for ; i < len(text); i++ {
+		if text[i] == ':' && (i+1 == len(text) || text[i+1] == ' ' || text[i+1] == '\t') {
+			return i
+		}
+	}
+	return -1
+}
+
+// closingQuote is the index of the quote closing the string text opens.
+func closingQuote(text string, q byte) int {
+	for i := 1; i < len(text); i++ {
+		switch {
+		case q == '"' && text[i] == '\\':
+			i++
+		case text[i] == q && q == '\'' && i+1 < len(text) && text[i+1] == '\'':
+			i++
+		case text[i] == q:
+			return i
+		}
+	}
+	return -1
+}
+
+var blockHeader = regexp.MustCompile(`^[|>][+-]?$`)
+
+// blockScalar reads the lines of a | or > scalar from the raw file, as
+// their comments and blank lines are part of it.
+func (p *yamlParser) blockScalar(header string, indent, num int) (any, error) {
+	if !blockHeader.MatchString(header) {
+		return nil, errorAt(num, "unsupported block scalar %s", header)
+	}
+	var lines []string
+	block := -1
+	i := num // raw index of the line after the header
+	for ; i < len(p.raw); i++ {
+		raw := strings.TrimRight(p.raw[i], "\r")
+		trimmed := strings.TrimLeft(raw, " ")
+		if trimmed == "" {
+			lines = append(lines, "")
+			continue
+		}
+		ind := len(raw) - len(trimmed)
+		if block < 0 {
+			block = ind
+		}
+		if ind <= indent || ind < block {
+			break
+		}
+		lines = append(lines, raw[block:])
+	}
+	// The lines read may not be read again as nodes.
+	for p.pos < len(p.lines) && p.lines[p.pos].num <= i {
+		p.pos++
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" && !strings.HasSuffix(header, "+") {
+		lines = lines[:len(lines)-1]
+	}
+	var text string
+	if header[0] == '|' {
+		text = strings.Join(lines, "\n")
+	} else {
+		var b strings.Builder
+		for j, l := range lines {
+			switch {
+			case j == 0:
+			case l == "" || lines[j-1] == "":
+				b.WriteByte('\n')
+			default:
+				b.WriteByte(' ')
+			}
+			b.WriteString(l)
+		}
+		text = b.String()
+	}
+	if !strings.HasSuffix(header, "-") && text != "" {
+		text += "\n"
+	}
+	return scalar{text: text, quoted: true, line: num}, nil
+}
+
+// inline reads a scalar or flow collection written on one line.
+func (p *yamlParser) inline(text string, num int) (any, error) {
+	f := &yamlFlow{text: text, num: num}
+	v, err := f.value(false)
+	if err != nil {
+		return nil, err
+	}
+	f.space()
+	if f.pos < len(f.text) {
+		return nil, errorAt(num, "unexpected %q after the value", f.text[f.pos:])
+	}
+	return v, nil
+}
+
+type yamlFlow struct {
+	text string
+	pos  int
+	num  int
+}
+
+func (f *yamlFlow) space() {
+	for f.pos < len(f.text) && (f.text[f.pos] == ' ' || f.text[f.pos] == '\t') {
+		f.pos++
+	}
+}
+
+// value reads a value at pos; inFlow is set within [] and {}, where
+// commas and brackets end a plain scalar.
+func (f *yamlFlow) value(inFlow bool) (any, error) {
+	f.space()
+	if f.pos == len(f.text) {
+		return scalar{line: f.num}, nil
+	}
+	switch c := f.text[f.pos]; c {
+	case '[':
+		f.pos++
+		list := []any{}
+		for {
+			f.space()
+			if f.pos < len(f.text) && f.text[f.pos] == ']' {
+				f.pos++
+				return list, nil
+			}
+			v, err := f.value(true)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+			if err := f.next(']'); err != nil {
+				return nil, err
+			}
+		}
+	case '{':
+		f.pos++
+		o := &object{}
+		for {
+			f.space()
+			if f.pos < len(f.text) && f.text[f.pos] == '}' {
+				f.pos++
+				return o, nil
+			}
+			k, err := f.value(true)
+			if err != nil {
+				return nil, err
+			}
+			key, ok := k.(scalar)
+			if !ok {
+				return nil, errorAt(f.num, "keys must be scalars")
+			}
+			f.space()
+			var v any = scalar{line: f.num}
+			if f.pos < len(f.text) && f.text[f.pos] == ':' {
+				f.pos++
+				if v, err = f.value(true); err != nil {
+					return nil, err
+				}
+			}
+			o.set(key.text, v)
+			if err := f.next('}'); err != nil {
+				return nil, err
+			}
+		}
+	case '"', '\'':
+		end := closingQuote(f.text[f.pos:], c)
+		if end < 0 {
+			return nil, errorAt(f.num, "unterminated string")
+		}
+		s := f.text[f.pos+1 : f.pos+end]
+		f.pos += end + 1
+		if c == '\'' {
+			return scalar{text: strings.ReplaceAll(s, "''", "'"), quoted: true, line: f.num}, nil
+		}
+		s, err := unescape(s, f.num)
+		return scalar{text: s, quoted: true, line: f.num}, err
+	case '&', '*', '!':
+		return nil, errorAt(f.num, "anchors, aliases and tags are not supported")
+	}
+	start := f.pos
+	for ; f.pos < len(f.text); f.pos++ {
+		c := f.text[f.pos]
+		if inFlow && (c == ',' || c == ']' || c == '}' ||
+			(c == ':' && (f.pos+1 == len(f.text) || strings.ContainsRune(" \t,]}", rune(f.text[f.pos+1]))))) {
+			break
+		}
+	}
+	return scalar{text: strings.TrimSpace(f.text[start:f.pos]), line: f.num}, nil
+}
+
+// next moves past the comma after an item, or stops at end.
+func (f *yamlFlow) next(end byte) error {
+	f.space()
+	if f.pos == len(f.text) {
+		return errorAt(f.num, "missing %c; flow collections must be on one line", end)
+	}
+	switch f.text[f.pos] {
+	case ',':
+		f.pos++
+	case end:
+	default:
+		return errorAt(f.num, "expected , or %c", end)
+	}
+	return nil
+}
+
+// yamlTopKey tells the name of the top-level setting line starts.
+func yamlTopKey(line string) (string, bool) {
+	if line == "" || line[0] == ' ' || line[0] == '#' || isSeqItem(line) {
+		return "", false
+	}
+	i := keySep(line)
+	if i < 0 {
+		return "", false
+	}
+	return strings.Trim(strings.TrimSpace(line[:i]), `"'`), true
+}
+
+func encodeYAML(doc *object, c comments) []byte {
+	var b bytes.Buffer
+	c.writeHeader(&b)
+	for _, m := range doc.members {
+		c.writeAbove(&b, m.key)
+		yamlMember(&b, 0, m.key, m.val)
+	}
+	c.writeTrailing(&b)
+	return b.Bytes()
+}
+
+func yamlMember(b *bytes.Buffer, indent int, key string, v any) {
+	b.WriteString(strings.Repeat(" ", indent))
+	b.WriteString(yamlString(key))
+	b.WriteByte(':')
+	switch v := v.(type) {
+	case *object:
+		if len(v.members) == 0 {
+			b.WriteString(" {}\n")
+			return
+		}
+		b.WriteByte('\n')
+		for _, m := range v.members {
+			yamlMember(b, indent+2, m.key, m.val)
+		}
+	case []any:
+		if len(v) == 0 {
+			b.WriteString(" []\n")
+			return
+		}
+		b.WriteByte('\n')
+		for _, item := range v {
+			yamlItem(b, indent+2, item)
+		}
+	default:
+		b.WriteByte(' ')
+		b.WriteString(yamlScalar(v))
+		b.WriteByte('\n')
+	}
+}
+
+// yamlItem writes a sequence item, the first line of a mapping or
+// sequence in it after the dash.
+func yamlItem(b *bytes.Buffer, indent int, v any) {
+	pad := strings.Repeat(" ", indent)
+	var sub bytes.Buffer
+	switch v := v.(type) {
+	case *object:
+		if len(v.members) == 0 {
+			b.WriteString(pad + "- {}\n")
+			return
+		}
+		for _, m := range v.members {
+			yamlMember(&sub, indent+2, m.key, m.val)
+		}
+	case []any:
+		if len(v) == 0 {
+			b.WriteString(pad + "- []\n")
+			return
+		}
+		for _, item := range v {
+			yamlItem(&sub, indent+2, item)
+		}
+	default:
+		b.WriteString(pad + "- " + yamlScalar(v) + "\n")
+		return
+	}
+	b.WriteString(pad + "- ")
+	b.Write(sub.Bytes()[indent+2:])
+}
+
+func yamlScalar(v any) string {
+	s, ok := v.(scalar)
+	switch {
+	case !ok:
+		return "null"
+	case s.quoted:
+		return yamlString(s.text)
+	}
+	return s.text
+}
+
+var yamlPlain = regexp.MustCompile(`^[A-Za-z0-9_./][A-Za-z0-9_./@:+-]*$`)
+
+// yamlString writes s plain when it reads back as the same string, and
+// quoted otherwise.
+func yamlString(s string) string {
+	_, isBool := boolean(s)
+	_, isNumber := number(s)
+	if !yamlPlain.MatchString(s) || strings.HasSuffix(s, ":") || isBool || isNumber || isNull(s) ||
+		strings.EqualFold(s, "y") || strings.EqualFold(s, "n") {
+		return quote(s)
+	}
+	return s
+}
@@ -0,0 +1,231 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Flow records encoded as IPFIX or NetFlow v9 export messages
+
+// Package flowexport encodes IPX flow records as IPFIX (RFC 7011) or
+// NetFlow v9 (RFC 3954) export messages, for collectors that fold relay
+// traffic into existing flow analysis. There are no information elements
+// for IPX, so a record carries its addresses in the nearest standard ones:
+// the 32-bit network in the IPv4 address fields, the node in the MAC
+// address fields and the socket in the transport port fields. A collector
+// then groups by network, station and socket as it would by subnet, host
+// and port; network 0000000A shows as 0.0.0.10.
+package flowexport
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/ipx"
+)
+
+// Protocols.
+const (
+	ProtocolIPFIX    = "ipfix"
+	ProtocolNetFlow9 = "netflow9"
+)
+
+const (
+	// TemplateID is the ID of the one template records are sent with.
+	TemplateID = 256
+	// LinkNameLen is the fixed length of the link name, padded with zeros
+	// or cut short; NetFlow v9 has no variable-length fields.
+	LinkNameLen = 32
+	// maxMessage keeps a message in one unfragmented UDP datagram.
+	maxMessage = 1400
+	// templateRefresh is how often the template is sent again, so a
+	// collector that restarts learns it.
+	templateRefresh = time.Minute
+)
+
+// Information element IDs, the same in IPFIX and NetFlow v9.
+const (
+	ieOctetDeltaCount          = 1
+	iePacketDeltaCount         = 2
+	ieSourceTransportPort      = 7
+	ieSourceIPv4Address        = 8
+	ieDestinationTransportPort = 11
+	ieDestinationIPv4Address   = 12
+	ieLastSwitched             = 21 // NetFlow v9, sysUptime milliseconds
+	ieFirstSwitched            = 22 // NetFlow v9, sysUptime milliseconds
+	ieSourceMacAddress         = 56
+	ieDestinationMacAddress    = 80
+	ieInterfaceName            = 82
+	ieFlowStartMilliseconds    = 152 // IPFIX
+	ieFlowEndMilliseconds      = 153 // IPFIX
+)
+
+// Record is a flow: the packets from Src to Dst that came in on Link
+// between Start and End.
+type Record struct {
+	Src, Dst ipx.Addr
+	Link     string // peer ID, capture interface or "emulator"
+	Packets  uint64
+	Bytes    uint64
+	Start    time.Time
+	End      time.Time
+}
+
+type field struct{ id, length uint16 }
+
+// Encoder turns records into export messages, keeping the sequence
+// numbers and when the template was last sent. It is not safe for
+// concurrent use.
+type Encoder struct {
+	protocol     string
+	domainID     uint32
+	boot         time.Time // sysUptime zero for NetFlow v9
+	fields       []field
+	recordLen    int
+	sequence     uint32 // IPFIX: data records sent, NetFlow v9: messages sent
+	lastTemplate time.Time
+}
+
+// NewEncoder returns an encoder of protocol messages for observation
+// domain (IPFIX) or source ID (NetFlow v9) domainID, its uptime counted
+// from boot.
+func NewEncoder(protocol string, domainID uint32, boot time.Time) (*Encoder, error) {
+	start, end := field{ieFlowStartMilliseconds, 8}, field{ieFlowEndMilliseconds, 8}
+	switch protocol {
+	case ProtocolIPFIX:
+	case ProtocolNetFlow9:
+		start, end = field{ieFirstSwitched, 4}, field{ieLastSwitched, 4}
+	default:
+		return nil, fmt.Errorf("flow export: unknown protocol %q, use %s or %s", protocol, ProtocolIPFIX, ProtocolNetFlow9)
+	}
+	e := &Encoder{protocol: protocol, domainID: domainID, boot: boot}
+	e.fields = []field{
+		{ieSourceIPv4Address, 4},
+		{ieSourceMacAddress, 6},
+		{ieSourceTransportPort, 2},
+		{ieDestinationIPv4Address, 4},
+		{ieDestinationMacAddress, 6},
+		{ieDestinationTransportPort, 2},
+		{iePacketDeltaCount, 8},
+		{ieOctetDeltaCount, 8},
+		start,
+		end,
+		{ieInterfaceName, LinkNameLen},
+	}
+	for _, f := range e.fields {
+		e.recordLen += int(f.length)
+	}
+	return e, nil
+}
+
+func (e *Encoder) headerLen() int {
+	if e.protocol == ProtocolIPFIX {
+		return 16
+	}
+	return 20
+}
+
+func (e *Encoder) templateLen() int {
+	return 4 + 4 + 4*len(e.fields)
+}
+
+// Encode returns the messages carrying records, as many as it takes to
+// keep each within a datagram. The template leads the first message when
+// it is due.
+func (e *Encoder) Encode(records []Record, now time.Time) [][]byte {
+	var msgs [][]byte
+	for len(records) > 0 {
+		withTemplate := e.lastTemplate.IsZero() || now.Sub(e.lastTemplate) >= templateRefresh
+		room := maxMessage - e.headerLen() - 4
+		if withTemplate {
+			room -= e.templateLen()
+			e.lastTemplate = now
+		}
+		n := min(len(records), room/e.recordLen)
+		msgs = append(msgs, e.message(records[:n], withTemplate, now))
+		records = records[n:]
+	}
+	return msgs
+}
+
+func (e *Encoder) message(records []Record, withTemplate bool, now time.Time) []byte {
+	msg := make([]byte, e.headerLen(), maxMessage)
+	if withTemplate {
+		msg = e.appendTemplate(msg)
+	}
+	set := len(msg)
+	msg = binary.BigEndian.AppendUint16(msg, TemplateID)
+	msg = binary.BigEndian.AppendUint16(msg, 0) // length, set below
+	for _, r := range records {
+		msg = e.appendRecord(msg, r)
+	}
+	binary.BigEndian.PutUint16(msg[set+2:], uint16(len(msg)-set))
+
+	if e.protocol == ProtocolIPFIX {
+		binary.BigEndian.PutUint16(msg[0:], 10)
+		binary.BigEndian.PutUint16(msg[2:], uint16(len(msg)))
+		binary.BigEndian.PutUint32(msg[4:], uint32(now.Unix()))
+		binary.BigEndian.PutUint32(msg[8:], e.sequence)
+		binary.BigEndian.PutUint32(msg[12:], e.domainID)
+		e.sequence += uint32(len(records))
+		return msg
+	}
+	count := len(records)
+	if withTemplate {
+		count++
+	}
+	binary.BigEndian.PutUint16(msg[0:], 9)
+	binary.BigEndian.PutUint16(msg[2:], uint16(count))
+	binary.BigEndian.PutUint32(msg[4:], e.uptime(now))
+	binary.BigEndian.PutUint32(msg[8:], uint32(now.Unix()))
+	binary.BigEndian.PutUint32(msg[12:], e.sequence)
+	binary.BigEndian.PutUint32(msg[16:], e.domainID)
+	e.sequence++
+	return msg
+}
+
+func (e *Encoder) appendTemplate(msg []byte) []byte {
+	setID := uint16(2) // IPFIX template set
+	if e.protocol == ProtocolNetFlow9 {
+		setID = 0
+	}
+	msg = binary.BigEndian.AppendUint16(msg, setID)
+	msg = binary.BigEndian.AppendUint16(msg, uint16(e.templateLen()))
+	msg = binary.BigEndian.AppendUint16(msg, TemplateID)
+	msg = binary.BigEndian.AppendUint16(msg, uint16(len(e.fields)))
+	for _, f := range e.fields {
+		msg = binary.BigEndian.AppendUint16(msg, f.id)
+		msg = binary.BigEndian.AppendUint16(msg, f.length)
+	}
+	return msg
+}
+
+func (e *Encoder) appendRecord(msg []byte, r Record) []byte {
+	for _, a := range []ipx.Addr{r.Src, r.Dst} {
+		msg = binary.BigEndian.AppendUint32(msg, a.Network)
+		msg = append(msg, node(a.Node)...)
+		msg = binary.BigEndian.AppendUint16(msg, a.Socket)
+	}
+	msg = binary.BigEndian.AppendUint64(msg, r.Packets)
+	msg = binary.BigEndian.AppendUint64(msg, r.Bytes)
+	if e.protocol == ProtocolIPFIX {
+		msg = binary.BigEndian.AppendUint64(msg, uint64(r.Start.UnixMilli()))
+		msg = binary.BigEndian.AppendUint64(msg, uint64(r.End.UnixMilli()))
+	} else {
+		msg = binary.BigEndian.AppendUint32(msg, e.uptime(r.Start))
+		msg = binary.BigEndian.AppendUint32(msg, e.uptime(r.End))
+	}
+	var name [LinkNameLen]byte
+	copy(name[:], r.Link)
+	return append(msg, name[:]...)
+}
+
+// uptime is t in milliseconds since boot, wrapping as sysUptime does.
+func (e *Encoder) uptime(t time.Time) uint32 {
+	return uint32(max(t.Sub(e.boot), 0).Milliseconds())
+}
+
+// node returns a 6-byte node address, zeros if n is not one.
+func node(n net.HardwareAddr) []byte {
+	if len(n) != 6 {
+		return make([]byte, 6)
+	}
+	return n
+}
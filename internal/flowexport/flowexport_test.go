@@ -0,0 +1,140 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for IPFIX and NetFlow v9 flow export
+
+package flowexport
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/ipx"
+)
+
+var (
+	boot   = time.Date(2026, 1, 2, 3, 0, 0, 0, time.UTC)
+	record = Record{
+		Src:     ipx.Addr{Network: 0x0A, Node: net.HardwareAddr{2, 0, 0, 0, 0, 1}, Socket: 0x4000},
+		Dst:     ipx.Addr{Network: 0x0B, Node: net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, Socket: 0x869C},
+		Link:    "203.0.113.5:40000",
+		Packets: 3,
+		Bytes:   180,
+		Start:   boot.Add(time.Second),
+		End:     boot.Add(4 * time.Second),
+	}
+)
+
+// sets splits a message after its header into set ID and contents.
+func sets(t *testing.T, msg []byte, header int) map[uint16][]byte {
+	t.Helper()
+	out := map[uint16][]byte{}
+	for off := header; off < len(msg); {
+		id, n := binary.BigEndian.Uint16(msg[off:]), int(binary.BigEndian.Uint16(msg[off+2:]))
+		if n < 4 || off+n > len(msg) {
+			t.Fatalf("Bad set length %d at %d of %d", n, off, len(msg))
+		}
+		out[id] = msg[off+4 : off+n]
+		off += n
+	}
+	return out
+}
+
+func TestIPFIX(t *testing.T) {
+	e, err := NewEncoder(ProtocolIPFIX, 7, boot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := boot.Add(5 * time.Second)
+	msgs := e.Encode([]Record{record}, now)
+	if len(msgs) != 1 {
+		t.Fatalf("Expected one message, got %d", len(msgs))
+	}
+	msg := msgs[0]
+	if v, n := binary.BigEndian.Uint16(msg), int(binary.BigEndian.Uint16(msg[2:])); v != 10 || n != len(msg) {
+		t.Errorf("Expected version 10 and length %d, got %d and %d", len(msg), v, n)
+	}
+	if d := binary.BigEndian.Uint32(msg[12:]); d != 7 {
+		t.Errorf("Expected observation domain 7, got %d", d)
+	}
+	s := sets(t, msg, 16)
+	tmpl, data := s[2], s[TemplateID]
+	if binary.BigEndian.Uint16(tmpl) != TemplateID || binary.BigEndian.Uint16(tmpl[2:]) != 11 {
+		t.Errorf("Expected template %d of 11 fields, got % x", TemplateID, tmpl[:4])
+	}
+	if len(data) != e.recordLen {
+		t.Fatalf("Expected one record of %d bytes, got %d", e.recordLen, len(data))
+	}
+	if binary.BigEndian.Uint32(data) != 0x0A || !bytes.Equal(data[4:10], record.Src.Node) || binary.BigEndian.Uint16(data[10:]) != 0x4000 {
+		t.Errorf("Expected the source address, got % x", data[:12])
+	}
+	if p, b := binary.BigEndian.Uint64(data[24:]), binary.BigEndian.Uint64(data[32:]); p != 3 || b != 180 {
+		t.Errorf("Expected 3 packets and 180 bytes, got %d and %d", p, b)
+	}
+	if ms := binary.BigEndian.Uint64(data[40:]); ms != uint64(record.Start.UnixMilli()) {
+		t.Errorf("Expected the flow start, got %d", ms)
+	}
+	if link := string(bytes.TrimRight(data[56:], "\x00")); link != record.Link {
+		t.Errorf("Expected link %q, got %q", record.Link, link)
+	}
+
+	// The template is not repeated until it is due, and the sequence
+	// counts the records sent before.
+	msg = e.Encode([]Record{record}, now.Add(time.Second))[0]
+	if _, ok := sets(t, msg, 16)[2]; ok {
+		t.Error("Expected no template in the next message")
+	}
+	if seq := binary.BigEndian.Uint32(msg[8:]); seq != 1 {
+		t.Errorf("Expected sequence 1, got %d", seq)
+	}
+	if _, ok := sets(t, e.Encode([]Record{record}, now.Add(2*time.Minute))[0], 16)[2]; !ok {
+		t.Error("Expected the template to be sent again")
+	}
+}
+
+func TestNetFlow9(t *testing.T) {
+	e, err := NewEncoder(ProtocolNetFlow9, 1, boot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := make([]Record, 40)
+	for i := range records {
+		records[i] = record
+	}
+	msgs := e.Encode(records, boot.Add(5*time.Second))
+	if len(msgs) < 3 {
+		t.Fatalf("Expected the records split over several messages, got %d", len(msgs))
+	}
+	total := 0
+	for i, msg := range msgs {
+		if len(msg) > maxMessage || len(msg)%4 != 0 {
+			t.Errorf("Message %d is %d bytes", i, len(msg))
+		}
+		if seq := binary.BigEndian.Uint32(msg[12:]); seq != uint32(i) {
+			t.Errorf("Expected message %d to have sequence %d, got %d", i, i, seq)
+		}
+		s := sets(t, msg, 20)
+		n := len(s[TemplateID]) / e.recordLen
+		total += n
+		count := int(binary.BigEndian.Uint16(msg[2:]))
+		if _, ok := s[0]; ok {
+			n++
+		}
+		if count != n {
+			t.Errorf("Expected a count of %d in message %d, got %d", n, i, count)
+		}
+	}
+	if total != len(records) {
+		t.Errorf("Expected %d records, got %d", len(records), total)
+	}
+	data := sets(t, msgs[0], 20)[TemplateID]
+	if first, last := binary.BigEndian.Uint32(data[40:]), binary.BigEndian.Uint32(data[44:]); first != 1000 || last != 4000 {
+		t.Errorf("Expected the flow at 1000-4000 ms of uptime, got %d-%d", first, last)
+	}
+
+	if _, err := NewEncoder("sflow", 0, boot); err == nil {
+		t.Error("Expected an unknown protocol to be refused")
+	}
+}
@@ -0,0 +1,165 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// IPX header parsing for captured Ethernet frames
+
+package ipx
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+const (
+	EtherTypeIPX = 0x8137
+
+	ethHeaderLen = 14
+	ipxHeaderLen = 30
+	maxFrameLen  = 1500 // 802.3 length fields are at most this; larger values are EtherTypes
+	sapIPX       = 0xE0
+	snapOUILen   = 3
+)
+
+// FrameType identifies how IPX is encapsulated on Ethernet. Stations using
+// different frame types on the same wire cannot see each other.
+type FrameType int
+
+const (
+	FrameUnknown FrameType = iota
+	FrameEthernetII
+	Frame8023Raw
+	Frame8022
+	FrameSNAP
+)
+
+// FrameTypes lists the known encapsulations in display order.
+var FrameTypes = []FrameType{FrameEthernetII, Frame8023Raw, Frame8022, FrameSNAP}
+
+func (f FrameType) String() string {
+	switch f {
+	case FrameEthernetII:
+		return "Ethernet_II"
+	case Frame8023Raw:
+		return "802.3"
+	case Frame8022:
+		return "802.2"
+	case FrameSNAP:
+		return "SNAP"
+	}
+	return "unknown"
+}
+
+// DetectFrameType reports the IPX encapsulation of an Ethernet frame and
+// the offset at which the IPX header starts.
+func DetectFrameType(frame []byte) (FrameType, int) {
+	if len(frame) < ethHeaderLen+2 {
+		return FrameUnknown, 0
+	}
+	typeLen := binary.BigEndian.Uint16(frame[12:14])
+	if typeLen == EtherTypeIPX {
+		return FrameEthernetII, ethHeaderLen
+	}
+	if typeLen > maxFrameLen {
+		return FrameUnknown, 0
+	}
+
+	llc := frame[ethHeaderLen:]
+	switch {
+	case llc[0] == 0xFF && llc[1] == 0xFF:
+		// Novell's raw 802.3: the IPX checksum (always FFFF) follows the length.
+		return Frame8023Raw, ethHeaderLen
+	case llc[0] == sapIPX && llc[1] == sapIPX:
+		return Frame8022, ethHeaderLen + 3
+	case llc[0] == 0xAA && llc[1] == 0xAA:
+		if len(llc) >= 3+snapOUILen+2 && binary.BigEndian.Uint16(llc[3+snapOUILen:]) == EtherTypeIPX {
+			return FrameSNAP, ethHeaderLen + 3 + snapOUILen + 2
+		}
+	}
+	return FrameUnknown, 0
+}
+
+// Addr is an IPX network/node/socket triple.
+type Addr struct {
+	Network uint32           `json:"network"`
+	Node    net.HardwareAddr `json:"node"`
+	Socket  uint16           `json:"socket"`
+}
+
+func (a Addr) String() string {
+	return fmt.Sprintf("%08X:%s:%04X", a.Network, a.Node, a.Socket)
+}
+
+// Header is the decoded Ethernet and IPX header of a relayed frame.
+type Header struct {
+	FrameType  FrameType
+	SrcMAC     net.HardwareAddr
+	DstMAC     net.HardwareAddr
+	Length     uint16
+	PacketType uint8
+	Dst        Addr
+	Src        Addr
+}
+
+// Parse decodes an Ethernet frame carrying IPX in any of the supported
+// encapsulations. The returned header references the frame's memory and
+// must not outlive it.
+func Parse(frame []byte) (*Header, error) {
+	if len(frame) < ethHeaderLen+ipxHeaderLen {
+		return nil, fmt.Errorf("frame too short: %d bytes", len(frame))
+	}
+	ft, off := DetectFrameType(frame)
+	if ft == FrameUnknown {
+		return nil, fmt.Errorf("not an IPX frame: type/length 0x%04x", binary.BigEndian.Uint16(frame[12:14]))
+	}
+	if len(frame) < off+ipxHeaderLen {
+		return nil, fmt.Errorf("frame too short for %s: %d bytes", ft, len(frame))
+	}
+
+	p := frame[off:]
+	return &Header{
+		FrameType:  ft,
+		DstMAC:     net.HardwareAddr(frame[0:6]),
+		SrcMAC:     net.HardwareAddr(frame[6:12]),
+		Length:     binary.BigEndian.Uint16(p[2:4]),
+		PacketType: p[5],
+		Dst: Addr{
+			Network: binary.BigEndian.Uint32(p[6:10]),
+			Node:    net.HardwareAddr(p[10:16]),
+			Socket:  binary.BigEndian.Uint16(p[16:18]),
+		},
+		Src: Addr{
+			Network: binary.BigEndian.Uint32(p[18:22]),
+			Node:    net.HardwareAddr(p[22:28]),
+			Socket:  binary.BigEndian.Uint16(p[28:30]),
+		},
+	}, nil
+}
+
+// Packet returns the IPX packet carried by an Ethernet frame, without the
+// Ethernet/LLC header or trailing padding. It references the frame's memory.
+func Packet(frame []byte) ([]byte, error) {
+	h, err := Parse(frame)
+	if err != nil {
+		return nil, err
+	}
+	_, off := DetectFrameType(frame)
+	end := off + int(h.Length)
+	if h.Length < ipxHeaderLen || end > len(frame) {
+		end = len(frame)
+	}
+	return frame[off:end], nil
+}
+
+// EncapEthernetII wraps a bare IPX packet, as exchanged by emulators, in an
+// Ethernet_II frame addressed by the packet's source and destination nodes.
+func EncapEthernetII(packet []byte) ([]byte, error) {
+	if len(packet) < ipxHeaderLen {
+		return nil, fmt.Errorf("IPX packet too short: %d bytes", len(packet))
+	}
+	frame := make([]byte, ethHeaderLen+len(packet))
+	copy(frame[0:6], packet[10:16])
+	copy(frame[6:12], packet[22:28])
+	binary.BigEndian.PutUint16(frame[12:14], EtherTypeIPX)
+	copy(frame[ethHeaderLen:], packet)
+	return frame, nil
+}
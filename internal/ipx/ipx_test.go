@@ -0,0 +1,191 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for IPX header parsing
+
+package ipx
+
+import (
+	"encoding/binary"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func buildFrame(dstSock, srcSock uint16) []byte {
+	frame := make([]byte, ethHeaderLen+ipxHeaderLen)
+	copy(frame[0:6], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	copy(frame[6:12], []byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x01})
+	binary.BigEndian.PutUint16(frame[12:14], EtherTypeIPX)
+	p := frame[ethHeaderLen:]
+	binary.BigEndian.PutUint16(p[0:2], 0xffff)
+	binary.BigEndian.PutUint16(p[2:4], ipxHeaderLen)
+	p[5] = 4
+	binary.BigEndian.PutUint32(p[6:10], 0x00000001)
+	binary.BigEndian.PutUint16(p[16:18], dstSock)
+	binary.BigEndian.PutUint32(p[18:22], 0x00000002)
+	copy(p[22:28], frame[6:12])
+	binary.BigEndian.PutUint16(p[28:30], srcSock)
+	return frame
+}
+
+func TestParse(t *testing.T) {
+	h, err := Parse(buildFrame(0x869c, 0x4000))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if h.Dst.Socket != 0x869c {
+		t.Errorf("Expected dst socket 0x869c, got 0x%04x", h.Dst.Socket)
+	}
+	if h.Src.Socket != 0x4000 {
+		t.Errorf("Expected src socket 0x4000, got 0x%04x", h.Src.Socket)
+	}
+	if h.Dst.Network != 1 || h.Src.Network != 2 {
+		t.Errorf("Unexpected networks: dst %08X src %08X", h.Dst.Network, h.Src.Network)
+	}
+	if h.PacketType != 4 {
+		t.Errorf("Expected packet type 4, got %d", h.PacketType)
+	}
+	if h.Src.Node.String() != "02:00:00:00:00:01" {
+		t.Errorf("Unexpected src node %s", h.Src.Node)
+	}
+}
+
+func TestParseRejectsNonIPX(t *testing.T) {
+	frame := buildFrame(1, 2)
+	binary.BigEndian.PutUint16(frame[12:14], 0x0800)
+	if _, err := Parse(frame); err == nil {
+		t.Error("Expected error for non-IPX ethertype")
+	}
+	if _, err := Parse(frame[:20]); err == nil {
+		t.Error("Expected error for short frame")
+	}
+}
+
+// encapsulate rewraps an Ethernet II test frame in the given frame type.
+func encapsulate(frame []byte, ft FrameType) []byte {
+	payload := frame[ethHeaderLen:]
+	var llc []byte
+	switch ft {
+	case Frame8022:
+		llc = []byte{sapIPX, sapIPX, 0x03}
+	case FrameSNAP:
+		llc = []byte{0xAA, 0xAA, 0x03, 0x00, 0x00, 0x00, 0x81, 0x37}
+	}
+	out := append([]byte(nil), frame[:12]...)
+	out = binary.BigEndian.AppendUint16(out, uint16(len(llc)+len(payload)))
+	out = append(out, llc...)
+	return append(out, payload...)
+}
+
+func TestParseFrameTypes(t *testing.T) {
+	for _, ft := range FrameTypes {
+		frame := buildFrame(0x869c, 0x4000)
+		if ft != FrameEthernetII {
+			frame = encapsulate(frame, ft)
+		}
+		h, err := Parse(frame)
+		if err != nil {
+			t.Errorf("%s: Parse failed: %v", ft, err)
+			continue
+		}
+		if h.FrameType != ft {
+			t.Errorf("Expected frame type %s, got %s", ft, h.FrameType)
+		}
+		if h.Dst.Socket != 0x869c || h.Src.Network != 2 {
+			t.Errorf("%s: unexpected header %+v", ft, h)
+		}
+	}
+
+	frame := encapsulate(buildFrame(1, 2), Frame8022)
+	frame[14], frame[15] = 0x42, 0x42 // STP, not IPX
+	if ft, _ := DetectFrameType(frame); ft != FrameUnknown {
+		t.Errorf("Expected non-IPX LLC frame to be unknown, got %s", ft)
+	}
+}
+
+func TestEncapEthernetII(t *testing.T) {
+	frame := buildFrame(0x869c, 0x4000)
+	pkt, err := Packet(append(frame, 0, 0, 0)) // trailing padding
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pkt) != ipxHeaderLen {
+		t.Fatalf("Expected %d-byte IPX packet, got %d", ipxHeaderLen, len(pkt))
+	}
+
+	back, err := EncapEthernetII(pkt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := Parse(back)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.FrameType != FrameEthernetII || h.SrcMAC.String() != "02:00:00:00:00:01" || h.Dst.Socket != 0x869c {
+		t.Errorf("Unexpected re-encapsulated header: %+v", h)
+	}
+	if _, err := EncapEthernetII(pkt[:10]); err == nil {
+		t.Error("Expected error for short packet")
+	}
+}
+
+func TestClassifySocket(t *testing.T) {
+	cases := []struct {
+		dst, src, want uint16
+	}{
+		{0x869B, 0x869B, 0x869B}, // Doom to Doom
+		{0x4003, 0x0452, 0x0452}, // SAP reply to a dynamic socket
+		{0x0453, 0x4003, 0x0453}, // RIP request
+		{0x7001, 0x4003, 0x7001}, // unknown game, keep the destination
+	}
+	for _, c := range cases {
+		h, err := Parse(buildFrame(c.dst, c.src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ClassifySocket(h); got != c.want {
+			t.Errorf("Expected socket 0x%04X for %04X<-%04X, got 0x%04X", c.want, c.dst, c.src, got)
+		}
+	}
+	if SocketLabel(0x869B) != "Doom" || SocketLabel(0x7001) != "" {
+		t.Errorf("Unexpected socket labels %q, %q", SocketLabel(0x869B), SocketLabel(0x7001))
+	}
+}
+
+func TestSocketNames(t *testing.T) {
+	if _, err := parseSocketNames("0x5A00\tRaptor\nbogus\n"); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("Expected the bad line reported, got %v", err)
+	}
+
+	SetSocketNames(map[uint16]string{0x5A00: "Raptor", 0x0452: "Service Advertising"})
+	t.Cleanup(func() { SetSocketNames(nil) })
+	if SocketLabel(0x5A00) != "Raptor" || SocketLabel(0x0452) != "Service Advertising" || SocketLabel(0x0453) != "RIP" {
+		t.Errorf("Expected the user's names over the built-in ones, got %q, %q, %q", SocketLabel(0x5A00), SocketLabel(0x0452), SocketLabel(0x0453))
+	}
+	names := SocketNames()
+	i := slices.IndexFunc(names, func(sn SocketName) bool { return sn.Socket == 0x5A00 })
+	if i < 0 || !names[i].Custom || !slices.IsSortedFunc(names, func(a, b SocketName) int { return int(a.Socket) - int(b.Socket) }) {
+		t.Errorf("Expected the user's socket listed in order, got %+v", names)
+	}
+
+	cases := []struct {
+		text string
+		want uint16
+		ok   bool
+	}{
+		{"", 0, true},
+		{"0x869b", 0x869B, true},
+		{"5A00", 0x5A00, true},
+		{"raptor", 0x5A00, true},
+		{"Quake", 0x6590, true},
+		{"Doom", 0, false}, // two sockets
+		{"Blood", 0, false},
+		{"0x10000", 0, false},
+	}
+	for _, c := range cases {
+		got, err := ParseSocket(c.text)
+		if (err == nil) != c.ok || got != c.want {
+			t.Errorf("ParseSocket(%q): expected 0x%04X (ok %v), got 0x%04X, %v", c.text, c.want, c.ok, got, err)
+		}
+	}
+}
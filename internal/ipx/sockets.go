@@ -0,0 +1,142 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Well-known IPX socket numbers and the protocols or games using them
+
+package ipx
+
+import (
+	_ "embed"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// SocketBeacon is where IPXTransporter relays announce themselves on the
+// local segment.
+const SocketBeacon = 0x8787
+
+//go:embed sockets.txt
+var socketsTxt string
+
+// wellKnownSockets maps socket numbers to the protocol or game behind them.
+var wellKnownSockets = mustParseSocketNames(socketsTxt)
+
+// customSockets holds the names set with SetSocketNames, which take
+// precedence over the well-known ones.
+var customSockets atomic.Pointer[map[uint16]string]
+
+// SocketName is an entry of the socket name database.
+type SocketName struct {
+	Socket uint16 `json:"socket"`
+	Name   string `json:"name"`
+	Custom bool   `json:"custom,omitempty"` // set by the user rather than built in
+}
+
+// SocketLabel names a known socket, or returns "" for any other.
+func SocketLabel(socket uint16) string {
+	if custom := customSockets.Load(); custom != nil {
+		if name, ok := (*custom)[socket]; ok {
+			return name
+		}
+	}
+	return wellKnownSockets[socket]
+}
+
+// SetSocketNames names sockets beyond the well-known ones, or renames
+// them; it replaces the names set before.
+func SetSocketNames(names map[uint16]string) {
+	names = maps.Clone(names)
+	customSockets.Store(&names)
+}
+
+// SocketNames lists the known sockets in order, with the names set by
+// SetSocketNames in place of the well-known ones.
+func SocketNames() []SocketName {
+	all := make(map[uint16]SocketName, len(wellKnownSockets))
+	for socket, name := range wellKnownSockets {
+		all[socket] = SocketName{Socket: socket, Name: name}
+	}
+	if custom := customSockets.Load(); custom != nil {
+		for socket, name := range *custom {
+			all[socket] = SocketName{Socket: socket, Name: name, Custom: true}
+		}
+	}
+	out := slices.Collect(maps.Values(all))
+	slices.SortFunc(out, func(a, b SocketName) int { return int(a.Socket) - int(b.Socket) })
+	return out
+}
+
+// ParseSocket reads a socket as hex, with or without 0x, or as the name of
+// a known socket in any case. "" is socket 0, which rules take as any.
+func ParseSocket(text string) (uint16, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return 0, nil
+	}
+	if socket, err := ParseSocketNumber(text); err == nil {
+		return socket, nil
+	}
+	var found []uint16
+	for _, sn := range SocketNames() {
+		if strings.EqualFold(sn.Name, text) {
+			found = append(found, sn.Socket)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return 0, fmt.Errorf("invalid socket %q: expected a hex value up to FFFF or a known name", text)
+	case 1:
+		return found[0], nil
+	}
+	return 0, fmt.Errorf("%q names %d sockets; give the one meant in hex", text, len(found))
+}
+
+// ParseSocketNumber reads a socket in hex, with or without 0x.
+func ParseSocketNumber(text string) (uint16, error) {
+	hex := strings.TrimPrefix(strings.ToLower(text), "0x")
+	v, err := strconv.ParseUint(hex, 16, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid socket %q: expected a hex value up to FFFF", text)
+	}
+	return uint16(v), nil
+}
+
+// parseSocketNames reads a socket name database: lines of a hex socket and
+// its name, blank lines and # comments.
+func parseSocketNames(text string) (map[uint16]string, error) {
+	names := map[uint16]string{}
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		field, name, _ := strings.Cut(line, "\t")
+		socket, err := ParseSocketNumber(field)
+		if err != nil || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("line %d: expected a hex socket and a name", i+1)
+		}
+		names[socket] = strings.TrimSpace(name)
+	}
+	return names, nil
+}
+
+func mustParseSocketNames(text string) map[uint16]string {
+	names, err := parseSocketNames(text)
+	if err != nil {
+		panic("sockets.txt: " + err.Error())
+	}
+	return names
+}
+
+// ClassifySocket picks the socket that identifies a packet's protocol: the
+// destination socket, unless only the source socket is known, as for
+// replies sent back to a client's dynamic socket.
+func ClassifySocket(h *Header) uint16 {
+	if SocketLabel(h.Dst.Socket) == "" && SocketLabel(h.Src.Socket) != "" {
+		return h.Src.Socket
+	}
+	return h.Dst.Socket
+}
@@ -1,62 +1,225 @@
 // SPDX-License-Identifier: BSD-3-Clause
 // IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
-// Log buffering for UI display
+// Levelled logging on log/slog with a buffer for UI display
 
 package logger
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
 	"sync"
 	"time"
 )
 
+// LevelFatal ranks above slog.LevelError; Fatal logs at it and exits.
+const LevelFatal = slog.Level(12)
+
 type LogMessage struct {
 	Timestamp time.Time `json:"timestamp"`
 	Level     string    `json:"level"`
+	Subsystem string    `json:"subsystem,omitempty"`
 	Message   string    `json:"message"`
 }
 
+// Logger logs the messages of one subsystem. The zero value logs without
+// a subsystem.
+type Logger struct {
+	subsystem string
+}
+
+// Per-subsystem loggers.
+var (
+	Capture = New("capture")
+	Relay   = New("relay")
+	Peer    = New("peer")
+	API     = New("api")
+)
+
 var (
 	messages []LogMessage
 	mu       sync.RWMutex
 	maxLogs  = 100
+
+	level  slog.LevelVar
+	output = newHandler(os.Stderr) // nil when standard error is not written
+	sinks  []slog.Handler          // syslog, journald
+
+	subscribers = make(map[chan LogMessage]struct{})
 )
 
-func Info(format string, v ...any) {
-	addLog("INFO", fmt.Sprintf(format, v...))
+func New(subsystem string) *Logger {
+	return &Logger{subsystem: subsystem}
 }
 
-func Error(format string, v ...any) {
-	addLog("ERROR", fmt.Sprintf(format, v...))
+func newHandler(w io.Writer) slog.Handler {
+	return slog.NewTextHandler(w, &slog.HandlerOptions{
+		Level: LevelFatal - 1, // filtering is done by Enabled
+		ReplaceAttr: func(_ []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.LevelKey && a.Value.Any() == LevelFatal {
+				a.Value = slog.StringValue("FATAL")
+			}
+			return a
+		},
+	})
 }
 
-func Fatal(format string, v ...any) {
-	msg := fmt.Sprintf(format, v...)
-	addLog("FATAL", msg)
-	log.Fatalf("FATAL: %s", msg)
+// SetOutput redirects the log to w, stderr by default.
+func SetOutput(w io.Writer) {
+	mu.Lock()
+	defer mu.Unlock()
+	output = newHandler(w)
+}
+
+// addSink forwards every logged message to h as well.
+func addSink(h slog.Handler) {
+	mu.Lock()
+	defer mu.Unlock()
+	sinks = append(sinks, h)
+}
+
+// subsystem returns the subsystem a record was logged by.
+func subsystem(r slog.Record) string {
+	var sub string
+	r.Attrs(func(a slog.Attr) bool {
+		if a.Key == "subsystem" {
+			sub = a.Value.String()
+			return false
+		}
+		return true
+	})
+	return sub
+}
+
+// ParseLevel maps a log_level setting (debug, info, warn or error) to its
+// slog level.
+func ParseLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return slog.LevelInfo, fmt.Errorf("unknown log level %q", s)
+}
+
+// SetLevel drops messages below the named level, both from the log and
+// from the buffer shown by the UI. An unknown name leaves the level as is.
+func SetLevel(s string) error {
+	l, err := ParseLevel(s)
+	if err != nil {
+		return err
+	}
+	level.Set(l)
+	return nil
+}
+
+// Enabled reports whether messages at l are logged.
+func Enabled(l slog.Level) bool {
+	return l >= level.Level()
+}
+
+func (l *Logger) Debug(format string, v ...any) {
+	l.log(slog.LevelDebug, format, v...)
+}
+
+func (l *Logger) Info(format string, v ...any) {
+	l.log(slog.LevelInfo, format, v...)
+}
+
+func (l *Logger) Warn(format string, v ...any) {
+	l.log(slog.LevelWarn, format, v...)
+}
+
+func (l *Logger) Error(format string, v ...any) {
+	l.log(slog.LevelError, format, v...)
 }
 
-func addLog(level, msg string) {
+// Fatal logs regardless of the level and exits.
+func (l *Logger) Fatal(format string, v ...any) {
+	l.log(LevelFatal, format, v...)
+	os.Exit(1)
+}
+
+func (l *Logger) log(lvl slog.Level, format string, v ...any) {
+	if lvl < LevelFatal && !Enabled(lvl) {
+		return
+	}
+	msg := fmt.Sprintf(format, v...)
+	now := time.Now()
+
 	mu.Lock()
 	defer mu.Unlock()
 
-	entry := LogMessage{
-		Timestamp: time.Now(),
-		Level:     level,
+	m := LogMessage{
+		Timestamp: now,
+		Level:     levelName(lvl),
+		Subsystem: l.subsystem,
 		Message:   msg,
 	}
-	messages = append(messages, entry)
+	messages = append(messages, m)
 	if len(messages) > maxLogs {
 		messages = messages[1:]
 	}
+	for ch := range subscribers {
+		select {
+		case ch <- m:
+		default: // the subscriber fell behind
+		}
+	}
 
-	// Also print to standard log for daemon mode visibility
-	log.Printf("%s: %s", level, msg)
+	// Also write to the log for daemon mode visibility
+	r := slog.NewRecord(now, lvl, msg, 0)
+	if l.subsystem != "" {
+		r.AddAttrs(slog.String("subsystem", l.subsystem))
+	}
+	if output != nil {
+		output.Handle(context.Background(), r)
+	}
+	for _, h := range sinks {
+		h.Handle(context.Background(), r)
+	}
 }
 
+func levelName(l slog.Level) string {
+	if l >= LevelFatal {
+		return "FATAL"
+	}
+	return l.String()
+}
+
+var root Logger
+
+func Debug(format string, v ...any) { root.Debug(format, v...) }
+func Info(format string, v ...any)  { root.Info(format, v...) }
+func Warn(format string, v ...any)  { root.Warn(format, v...) }
+func Error(format string, v ...any) { root.Error(format, v...) }
+func Fatal(format string, v ...any) { root.Fatal(format, v...) }
+
 func GetLogs() []LogMessage {
 	mu.RLock()
 	defer mu.RUnlock()
 	return append([]LogMessage(nil), messages...)
 }
+
+// Subscribe returns the buffered messages and a channel receiving every
+// message logged after them, until cancel is called. A subscriber more than
+// buffer messages behind misses messages rather than holding up logging.
+func Subscribe(buffer int) (backlog []LogMessage, ch <-chan LogMessage, cancel func()) {
+	c := make(chan LogMessage, buffer)
+	mu.Lock()
+	defer mu.Unlock()
+	subscribers[c] = struct{}{}
+	return append([]LogMessage(nil), messages...), c, func() {
+		mu.Lock()
+		defer mu.Unlock()
+		delete(subscribers, c)
+	}
+}
@@ -5,6 +5,10 @@
 package logger
 
 import (
+	"bytes"
+	"log/slog"
+	"os"
+	"strings"
 	"testing"
 )
 
@@ -59,3 +63,80 @@ func TestLoggerBufferLimit(t *testing.T) {
 		t.Errorf("Expected last message in buffer to be 'msg 9', got '%s'", logs[4].Message)
 	}
 }
+
+func TestLoggerLevels(t *testing.T) {
+	mu.Lock()
+	messages = nil
+	mu.Unlock()
+	var out bytes.Buffer
+	SetOutput(&out)
+	defer SetOutput(os.Stderr)
+	defer SetLevel("info")
+
+	if err := SetLevel("warn"); err != nil {
+		t.Fatal(err)
+	}
+	Relay.Debug("debug")
+	Relay.Info("info")
+	Relay.Warn("warn %d", 1)
+	Capture.Error("error")
+
+	logs := GetLogs()
+	if len(logs) != 2 {
+		t.Fatalf("Expected 2 logs at warn level, got %d: %+v", len(logs), logs)
+	}
+	if logs[0].Level != "WARN" || logs[0].Subsystem != "relay" || logs[0].Message != "warn 1" {
+		t.Errorf("Unexpected first log: %+v", logs[0])
+	}
+	if logs[1].Level != "ERROR" || logs[1].Subsystem != "capture" {
+		t.Errorf("Unexpected second log: %+v", logs[1])
+	}
+	if s := out.String(); !strings.Contains(s, "level=WARN") || !strings.Contains(s, "subsystem=relay") || strings.Contains(s, "msg=info") {
+		t.Errorf("Unexpected log output: %s", s)
+	}
+
+	if err := SetLevel("debug"); err != nil {
+		t.Fatal(err)
+	}
+	Peer.Debug("debug")
+	if logs := GetLogs(); logs[len(logs)-1].Level != "DEBUG" {
+		t.Errorf("Expected a debug log, got %+v", logs[len(logs)-1])
+	}
+
+	if err := SetLevel("verbose"); err == nil {
+		t.Error("Expected an unknown level to be rejected")
+	}
+	if !Enabled(slog.LevelDebug) {
+		t.Error("Expected an unknown level to leave the level unchanged")
+	}
+}
+
+func TestLoggerSubscribe(t *testing.T) {
+	mu.Lock()
+	messages = nil
+	mu.Unlock()
+
+	Info("before")
+	backlog, ch, cancel := Subscribe(1)
+	if len(backlog) != 1 || backlog[0].Message != "before" {
+		t.Fatalf("Expected the buffered message as backlog, got %+v", backlog)
+	}
+	API.Warn("after %d", 1)
+	API.Warn("after %d", 2) // beyond the subscriber's buffer
+	if m := <-ch; m.Message != "after 1" || m.Subsystem != "api" {
+		t.Errorf("Unexpected streamed message: %+v", m)
+	}
+	select {
+	case m := <-ch:
+		t.Errorf("Expected a message beyond the buffer to be dropped, got %+v", m)
+	default:
+	}
+
+	cancel()
+	Info("gone")
+	select {
+	case m := <-ch:
+		t.Errorf("Expected nothing after cancel, got %+v", m)
+	default:
+	}
+}
@@ -0,0 +1,17 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Syslog and journald log sinks, unavailable on this platform
+
+//go:build !unix
+
+package logger
+
+import "errors"
+
+func EnableSyslog(addr string) error {
+	return errors.New("syslog is not supported on this platform")
+}
+
+func EnableJournald() error {
+	return errors.New("journald is not supported on this platform")
+}
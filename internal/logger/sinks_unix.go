@@ -0,0 +1,144 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Syslog and journald log sinks
+
+//go:build unix
+
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"log/syslog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const identifier = "ipxtransporter"
+
+// journalSocket is where journald takes native protocol datagrams.
+var journalSocket = "/run/systemd/journal/socket"
+
+// EnableSyslog forwards log messages to syslog: the local daemon if addr is
+// "local", otherwise a remote one at udp://host:port or tcp://host:port.
+func EnableSyslog(addr string) error {
+	network, raddr := "", ""
+	if addr != "local" {
+		scheme, hostport, ok := strings.Cut(addr, "://")
+		if !ok || (scheme != "udp" && scheme != "tcp") {
+			return fmt.Errorf("syslog address %q is not local, udp://host:port or tcp://host:port", addr)
+		}
+		network, raddr = scheme, hostport
+	}
+	w, err := syslog.Dial(network, raddr, syslog.LOG_DAEMON|syslog.LOG_INFO, identifier)
+	if err != nil {
+		return fmt.Errorf("connecting to syslog: %w", err)
+	}
+	addSink(&syslogHandler{w: w})
+	return nil
+}
+
+// EnableJournald sends log messages to the systemd journal with the
+// subsystem as a field of its own. When standard error is already connected
+// to the journal, it stops writing there so messages are not logged twice.
+func EnableJournald() error {
+	conn, err := net.Dial("unixgram", journalSocket)
+	if err != nil {
+		return fmt.Errorf("connecting to journald: %w", err)
+	}
+	addSink(&journaldHandler{conn: conn})
+	if os.Getenv("JOURNAL_STREAM") != "" {
+		mu.Lock()
+		output = nil
+		mu.Unlock()
+	}
+	return nil
+}
+
+// sinkHandler holds what the sinks have in common. Records reach them after
+// level filtering, under mu.
+type sinkHandler struct{}
+
+func (sinkHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+type syslogHandler struct {
+	sinkHandler
+	w *syslog.Writer
+}
+
+func (h *syslogHandler) Handle(_ context.Context, r slog.Record) error {
+	msg := r.Message
+	if sub := subsystem(r); sub != "" {
+		msg = sub + ": " + msg
+	}
+	switch {
+	case r.Level >= LevelFatal:
+		return h.w.Crit(msg)
+	case r.Level >= slog.LevelError:
+		return h.w.Err(msg)
+	case r.Level >= slog.LevelWarn:
+		return h.w.Warning(msg)
+	case r.Level >= slog.LevelInfo:
+		return h.w.Info(msg)
+	}
+	return h.w.Debug(msg)
+}
+
+func (h *syslogHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+func (h *syslogHandler) WithGroup(string) slog.Handler      { return h }
+
+type journaldHandler struct {
+	sinkHandler
+	conn net.Conn
+	buf  bytes.Buffer
+}
+
+func (h *journaldHandler) Handle(_ context.Context, r slog.Record) error {
+	h.buf.Reset()
+	journalField(&h.buf, "MESSAGE", r.Message)
+	journalField(&h.buf, "PRIORITY", strconv.Itoa(int(syslogPriority(r.Level))))
+	journalField(&h.buf, "SYSLOG_IDENTIFIER", identifier)
+	if sub := subsystem(r); sub != "" {
+		journalField(&h.buf, "IPXT_SUBSYSTEM", sub)
+	}
+	_, err := h.conn.Write(h.buf.Bytes())
+	return err
+}
+
+func (h *journaldHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+func (h *journaldHandler) WithGroup(string) slog.Handler      { return h }
+
+// journalField appends a field in journald's native format. Values with a
+// newline are sent length-prefixed instead of as NAME=value lines.
+func journalField(b *bytes.Buffer, name, value string) {
+	b.WriteString(name)
+	if !strings.Contains(value, "\n") {
+		b.WriteByte('=')
+		b.WriteString(value)
+		b.WriteByte('\n')
+		return
+	}
+	b.WriteByte('\n')
+	binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value)
+	b.WriteByte('\n')
+}
+
+func syslogPriority(l slog.Level) syslog.Priority {
+	switch {
+	case l >= LevelFatal:
+		return syslog.LOG_CRIT
+	case l >= slog.LevelError:
+		return syslog.LOG_ERR
+	case l >= slog.LevelWarn:
+		return syslog.LOG_WARNING
+	case l >= slog.LevelInfo:
+		return syslog.LOG_INFO
+	}
+	return syslog.LOG_DEBUG
+}
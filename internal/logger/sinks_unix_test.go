@@ -0,0 +1,88 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for the syslog and journald log sinks
+
+//go:build unix
+
+package logger
+
+import (
+	"bytes"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withoutSinks removes the sinks a test added when it ends.
+func withoutSinks(t *testing.T) {
+	t.Cleanup(func() {
+		mu.Lock()
+		sinks = nil
+		mu.Unlock()
+	})
+}
+
+func TestSyslogRemote(t *testing.T) {
+	withoutSinks(t)
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	if err := EnableSyslog("udp://" + pc.LocalAddr().String()); err != nil {
+		t.Fatal(err)
+	}
+	Relay.Warn("peer %s gone", "p1")
+
+	buf := make([]byte, 1024)
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// <28> is the daemon facility at warning severity.
+	msg := string(buf[:n])
+	if !strings.HasPrefix(msg, "<28>") || !strings.Contains(msg, "ipxtransporter") || !strings.Contains(msg, "relay: peer p1 gone") {
+		t.Errorf("Unexpected syslog message: %q", msg)
+	}
+
+	if err := EnableSyslog("http://example.com"); err == nil {
+		t.Error("Expected an unknown syslog scheme to be rejected")
+	}
+}
+
+func TestJournald(t *testing.T) {
+	withoutSinks(t)
+	socket := filepath.Join(t.TempDir(), "journal")
+	pc, err := net.ListenPacket("unixgram", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	defer func(s string) { journalSocket = s }(journalSocket)
+	journalSocket = socket
+
+	if err := EnableJournald(); err != nil {
+		t.Fatal(err)
+	}
+	Peer.Error("first line\nsecond line")
+
+	buf := make([]byte, 1024)
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want bytes.Buffer
+	journalField(&want, "MESSAGE", "first line\nsecond line")
+	want.WriteString("PRIORITY=3\nSYSLOG_IDENTIFIER=ipxtransporter\nIPXT_SUBSYSTEM=peer\n")
+	if !bytes.Equal(buf[:n], want.Bytes()) {
+		t.Errorf("Expected %q, got %q", want.Bytes(), buf[:n])
+	}
+	if !bytes.HasPrefix(want.Bytes(), []byte("MESSAGE\n\x16\x00\x00\x00\x00\x00\x00\x00first")) {
+		t.Errorf("Expected a multi-line message to be length-prefixed, got %q", want.Bytes())
+	}
+}
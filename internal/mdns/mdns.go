@@ -0,0 +1,445 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// mDNS/DNS-SD advertisement and discovery of IPXTransporter nodes
+
+package mdns
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Service is the DNS-SD service type advertised for the peer listener.
+const Service = "_ipxtransporter._tcp.local."
+
+const (
+	typeA   = 1
+	typePTR = 12
+	typeTXT = 16
+	typeSRV = 33
+	typeANY = 255
+
+	classIN    = 1
+	cacheFlush = 0x8000
+
+	recordTTL     = 120 // seconds
+	queryInterval = time.Minute
+)
+
+var group = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// Node is an IPXTransporter instance found on the LAN.
+type Node struct {
+	Instance string    `json:"instance"`
+	ID       string    `json:"id"`
+	Hostname string    `json:"hostname"`
+	Addr     string    `json:"addr"` // host:port of its peer listener
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// Responder advertises this node under Service and collects the other
+// instances that answer our queries or announce themselves.
+type Responder struct {
+	instance string // unqualified instance label
+	id       string
+	hostname string
+	port     int
+
+	mu    sync.RWMutex
+	conn  *net.UDPConn
+	nodes map[string]Node // by instance
+}
+
+func NewResponder(id, hostname string, port int) *Responder {
+	instance := id
+	if hostname != "" {
+		instance = hostname + " (" + id + ")"
+	}
+	return &Responder{
+		instance: instance,
+		id:       id,
+		hostname: hostname,
+		port:     port,
+		nodes:    make(map[string]Node),
+	}
+}
+
+// Run joins the mDNS group, announces us and answers queries until ctx is
+// done. Other instances are queried for at startup and every minute.
+func (r *Responder) Run(ctx context.Context) error {
+	conn, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		return fmt.Errorf("mdns listen: %w", err)
+	}
+	r.mu.Lock()
+	r.conn = conn
+	r.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		_ = conn.Close()
+	}()
+	go func() {
+		ticker := time.NewTicker(queryInterval)
+		defer ticker.Stop()
+		r.send(r.response(recordTTL))
+		r.Query()
+		for {
+			select {
+			case <-ctx.Done():
+				// Goodbye: a zero TTL tells others to forget us.
+				r.send(r.response(0))
+				return
+			case <-ticker.C:
+				r.expire(time.Now())
+				r.Query()
+			}
+		}
+	}()
+
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("mdns read: %w", err)
+		}
+		r.handle(buf[:n], from)
+	}
+}
+
+// Query asks the LAN for other instances.
+func (r *Responder) Query() {
+	msg := header(0, 1, 0)
+	msg = appendName(msg, Service)
+	msg = binary.BigEndian.AppendUint16(msg, typePTR)
+	msg = binary.BigEndian.AppendUint16(msg, classIN)
+	r.send(msg)
+}
+
+// Nodes returns the instances discovered so far, sorted by instance name.
+func (r *Responder) Nodes() []Node {
+	r.mu.RLock()
+	out := make([]Node, 0, len(r.nodes))
+	for _, n := range r.nodes {
+		out = append(out, n)
+	}
+	r.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Instance < out[j].Instance })
+	return out
+}
+
+func (r *Responder) send(msg []byte) {
+	r.mu.RLock()
+	conn := r.conn
+	r.mu.RUnlock()
+	if conn != nil {
+		_, _ = conn.WriteToUDP(msg, group)
+	}
+}
+
+func (r *Responder) expire(now time.Time) {
+	r.mu.Lock()
+	for k, n := range r.nodes {
+		if now.Sub(n.LastSeen) > 3*queryInterval {
+			delete(r.nodes, k)
+		}
+	}
+	r.mu.Unlock()
+}
+
+func (r *Responder) fqdn() string {
+	return escapeLabel(r.instance) + "." + Service
+}
+
+func (r *Responder) target() string {
+	return "ipxtransporter-" + r.id + ".local."
+}
+
+// response builds an announcement of our PTR, SRV, TXT and A records.
+func (r *Responder) response(ttl uint32) []byte {
+	var ips []net.IP
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, a := range addrs {
+			if ipn, ok := a.(*net.IPNet); ok && !ipn.IP.IsLoopback() && ipn.IP.To4() != nil {
+				ips = append(ips, ipn.IP.To4())
+			}
+		}
+	}
+
+	msg := header(0x8400, 0, uint16(3+len(ips)))
+	msg = appendRecord(msg, Service, typePTR, classIN, ttl, appendName(nil, r.fqdn()))
+
+	srv := binary.BigEndian.AppendUint16(nil, 0) // priority
+	srv = binary.BigEndian.AppendUint16(srv, 0)  // weight
+	srv = binary.BigEndian.AppendUint16(srv, uint16(r.port))
+	srv = appendName(srv, r.target())
+	msg = appendRecord(msg, r.fqdn(), typeSRV, classIN|cacheFlush, ttl, srv)
+
+	var txt []byte
+	for _, kv := range []string{"id=" + r.id, "host=" + r.hostname} {
+		txt = append(txt, byte(len(kv)))
+		txt = append(txt, kv...)
+	}
+	msg = appendRecord(msg, r.fqdn(), typeTXT, classIN|cacheFlush, ttl, txt)
+
+	for _, ip := range ips {
+		msg = appendRecord(msg, r.target(), typeA, classIN|cacheFlush, ttl, ip)
+	}
+	return msg
+}
+
+func (r *Responder) handle(msg []byte, from *net.UDPAddr) {
+	if len(msg) < 12 {
+		return
+	}
+	if binary.BigEndian.Uint16(msg[2:4])&0x8000 == 0 {
+		if asksForService(msg) {
+			r.send(r.response(recordTTL))
+		}
+		return
+	}
+	for _, n := range parseResponse(msg, from.IP, time.Now()) {
+		if n.ID == r.id {
+			continue
+		}
+		r.mu.Lock()
+		if n.LastSeen.IsZero() {
+			delete(r.nodes, n.Instance)
+		} else {
+			r.nodes[n.Instance] = n
+		}
+		r.mu.Unlock()
+	}
+}
+
+// asksForService reports whether a query message asks for our service type.
+func asksForService(msg []byte) bool {
+	qd := int(binary.BigEndian.Uint16(msg[4:6]))
+	off := 12
+	for range qd {
+		name, next, err := readName(msg, off)
+		if err != nil || next+4 > len(msg) {
+			return false
+		}
+		qtype := binary.BigEndian.Uint16(msg[next : next+2])
+		off = next + 4
+		if strings.EqualFold(name, Service) && (qtype == typePTR || qtype == typeANY) {
+			return true
+		}
+	}
+	return false
+}
+
+type srvInfo struct {
+	target string
+	port   uint16
+}
+
+// parseResponse extracts the instances announced in an mDNS response. A
+// goodbye (zero TTL) yields a node with a zero LastSeen. Without an A record
+// for the target, the sender's address is used.
+func parseResponse(msg []byte, src net.IP, now time.Time) []Node {
+	qd := int(binary.BigEndian.Uint16(msg[4:6]))
+	total := int(binary.BigEndian.Uint16(msg[6:8])) + int(binary.BigEndian.Uint16(msg[8:10])) + int(binary.BigEndian.Uint16(msg[10:12]))
+
+	off := 12
+	for range qd {
+		_, next, err := readName(msg, off)
+		if err != nil {
+			return nil
+		}
+		off = next + 4
+	}
+
+	instances := map[string]bool{}
+	goodbye := map[string]bool{}
+	srvs := map[string]srvInfo{}
+	txts := map[string]map[string]string{}
+	addrs := map[string]net.IP{}
+	for range total {
+		name, next, err := readName(msg, off)
+		if err != nil || next+10 > len(msg) {
+			break
+		}
+		rtype := binary.BigEndian.Uint16(msg[next : next+2])
+		ttl := binary.BigEndian.Uint32(msg[next+4 : next+8])
+		rdlen := int(binary.BigEndian.Uint16(msg[next+8 : next+10]))
+		rdata := next + 10
+		if rdata+rdlen > len(msg) {
+			break
+		}
+		off = rdata + rdlen
+
+		switch rtype {
+		case typePTR:
+			if !strings.EqualFold(name, Service) {
+				continue
+			}
+			if inst, _, err := readName(msg, rdata); err == nil {
+				instances[inst] = true
+				goodbye[inst] = ttl == 0
+			}
+		case typeSRV:
+			if rdlen < 7 {
+				continue
+			}
+			if target, _, err := readName(msg, rdata+6); err == nil {
+				srvs[name] = srvInfo{target: target, port: binary.BigEndian.Uint16(msg[rdata+4 : rdata+6])}
+			}
+		case typeTXT:
+			kv := map[string]string{}
+			for p := rdata; p < rdata+rdlen; {
+				l := int(msg[p])
+				if p+1+l > rdata+rdlen {
+					break
+				}
+				if k, v, ok := strings.Cut(string(msg[p+1:p+1+l]), "="); ok {
+					kv[k] = v
+				}
+				p += 1 + l
+			}
+			txts[name] = kv
+		case typeA:
+			if rdlen == 4 {
+				addrs[name] = net.IP(append([]byte(nil), msg[rdata:rdata+4]...))
+			}
+		}
+	}
+
+	var out []Node
+	for inst := range instances {
+		label := unescapeLabel(inst[:max(len(inst)-len(Service)-1, 0)])
+		if goodbye[inst] {
+			out = append(out, Node{Instance: label})
+			continue
+		}
+		srv, ok := srvs[inst]
+		if !ok {
+			continue
+		}
+		ip := addrs[srv.target]
+		if ip == nil {
+			ip = src
+		}
+		out = append(out, Node{
+			Instance: label,
+			ID:       txts[inst]["id"],
+			Hostname: txts[inst]["host"],
+			Addr:     net.JoinHostPort(ip.String(), strconv.Itoa(int(srv.port))),
+			LastSeen: now,
+		})
+	}
+	return out
+}
+
+func header(flags, qd, an uint16) []byte {
+	msg := make([]byte, 12)
+	binary.BigEndian.PutUint16(msg[2:4], flags)
+	binary.BigEndian.PutUint16(msg[4:6], qd)
+	binary.BigEndian.PutUint16(msg[6:8], an)
+	return msg
+}
+
+func appendRecord(msg []byte, name string, rtype, class uint16, ttl uint32, rdata []byte) []byte {
+	msg = appendName(msg, name)
+	msg = binary.BigEndian.AppendUint16(msg, rtype)
+	msg = binary.BigEndian.AppendUint16(msg, class)
+	msg = binary.BigEndian.AppendUint32(msg, ttl)
+	msg = binary.BigEndian.AppendUint16(msg, uint16(len(rdata)))
+	return append(msg, rdata...)
+}
+
+// appendName encodes a dotted name; "\." inside a label is a literal dot.
+func appendName(msg []byte, name string) []byte {
+	for _, label := range splitLabels(strings.TrimSuffix(name, ".")) {
+		if len(label) > 63 {
+			label = label[:63]
+		}
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	return append(msg, 0)
+}
+
+func splitLabels(name string) []string {
+	var labels []string
+	var cur strings.Builder
+	for i := 0; i < len(name); i++ {
+		switch {
+		case name[i] == '\\' && i+1 < len(name):
+			i++
+			cur.WriteByte(name[i])
+		case name[i] == '.':
+			labels = append(labels, cur.String())
+			cur.Reset()
+		default:
+			cur.WriteByte(name[i])
+		}
+	}
+	if cur.Len() > 0 {
+		labels = append(labels, cur.String())
+	}
+	return labels
+}
+
+func escapeLabel(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return strings.ReplaceAll(s, ".", `\.`)
+}
+
+func unescapeLabel(s string) string {
+	labels := splitLabels(s)
+	if len(labels) == 0 {
+		return ""
+	}
+	return labels[0]
+}
+
+// readName decodes a possibly compressed name at off and returns it with a
+// trailing dot, along with the offset just past it.
+func readName(msg []byte, off int) (string, int, error) {
+	var b strings.Builder
+	end := -1
+	for jumps := 0; ; {
+		if off >= len(msg) {
+			return "", 0, fmt.Errorf("name out of range")
+		}
+		l := int(msg[off])
+		switch {
+		case l == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			if b.Len() == 0 {
+				b.WriteByte('.')
+			}
+			return b.String(), end, nil
+		case l&0xc0 == 0xc0:
+			if off+1 >= len(msg) || jumps > 16 {
+				return "", 0, fmt.Errorf("bad name pointer")
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:off+2]) & 0x3fff)
+			jumps++
+		default:
+			if off+1+l > len(msg) {
+				return "", 0, fmt.Errorf("label out of range")
+			}
+			b.WriteString(escapeLabel(string(msg[off+1 : off+1+l])))
+			b.WriteByte('.')
+			off += 1 + l
+		}
+	}
+}
@@ -0,0 +1,67 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for mDNS/DNS-SD advertisement and discovery
+
+package mdns
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestResponseRoundTrip(t *testing.T) {
+	r := NewResponder("a1b2c3d4", "lan.party", 8787)
+	now := time.Now()
+
+	nodes := parseResponse(r.response(recordTTL), net.IPv4(192, 168, 1, 20), now)
+	if len(nodes) != 1 {
+		t.Fatalf("Expected 1 node, got %+v", nodes)
+	}
+	n := nodes[0]
+	if n.Instance != "lan.party (a1b2c3d4)" || n.ID != "a1b2c3d4" || n.Hostname != "lan.party" {
+		t.Errorf("Expected instance, ID and hostname from the announcement, got %+v", n)
+	}
+	if _, port, _ := net.SplitHostPort(n.Addr); port != "8787" || !n.LastSeen.Equal(now) {
+		t.Errorf("Expected port 8787 seen now, got %+v", n)
+	}
+
+	gone := parseResponse(r.response(0), net.IPv4(192, 168, 1, 20), now)
+	if len(gone) != 1 || !gone[0].LastSeen.IsZero() {
+		t.Errorf("Expected a goodbye for the instance, got %+v", gone)
+	}
+}
+
+func TestQueryForService(t *testing.T) {
+	q := header(0, 1, 0)
+	q = appendName(q, "_IPXTransporter._tcp.local.")
+	q = append(q, 0, typePTR, 0, classIN)
+	if !asksForService(q) {
+		t.Error("Expected a PTR query for the service to be answered")
+	}
+
+	other := header(0, 1, 0)
+	other = appendName(other, "_http._tcp.local.")
+	other = append(other, 0, typePTR, 0, classIN)
+	if asksForService(other) {
+		t.Error("Expected queries for other services to be ignored")
+	}
+}
+
+func TestHandleIgnoresSelf(t *testing.T) {
+	r := NewResponder("a1b2c3d4", "host", 8787)
+	r.handle(r.response(recordTTL), &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1)})
+	if len(r.Nodes()) != 0 {
+		t.Errorf("Expected our own announcement to be ignored, got %+v", r.Nodes())
+	}
+
+	other := NewResponder("deadbeef", "other", 9000)
+	r.handle(other.response(recordTTL), &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2)})
+	if nodes := r.Nodes(); len(nodes) != 1 || nodes[0].ID != "deadbeef" {
+		t.Errorf("Expected the other node to be discovered, got %+v", nodes)
+	}
+	r.handle(other.response(0), &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2)})
+	if len(r.Nodes()) != 0 {
+		t.Errorf("Expected the node to be forgotten after its goodbye, got %+v", r.Nodes())
+	}
+}
@@ -0,0 +1,182 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Gateway port mapping for the peer listener via NAT-PMP or UPnP IGD
+
+package natmap
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	MethodNATPMP = "nat-pmp"
+	MethodUPnP   = "upnp"
+
+	leaseLifetime = 2 * time.Hour
+	retryInterval = time.Minute
+	ssdpTimeout   = 3 * time.Second
+)
+
+// Status reports the current mapping, or why there is none.
+type Status struct {
+	Method   string    `json:"method,omitempty"`
+	External string    `json:"external,omitempty"` // ip:port reachable from the internet
+	Expires  time.Time `json:"expires,omitzero"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// Mapper keeps a TCP port mapped on the gateway, refreshing the lease
+// halfway through its lifetime and removing it on shutdown.
+type Mapper struct {
+	port int
+
+	mu     sync.RWMutex
+	status Status
+	gw     *net.UDPAddr // NAT-PMP gateway, once one has answered
+	dev    *igd         // UPnP gateway, once one has answered
+}
+
+func NewMapper(port int) *Mapper {
+	return &Mapper{port: port}
+}
+
+func (m *Mapper) Status() Status {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.status
+}
+
+// Run maps the port until ctx is done.
+func (m *Mapper) Run(ctx context.Context) {
+	for {
+		wait := retryInterval
+		if lifetime, err := m.refresh(ctx); err != nil {
+			m.mu.Lock()
+			m.status = Status{Error: err.Error()}
+			m.mu.Unlock()
+		} else {
+			wait = max(lifetime/2, 30*time.Second)
+		}
+
+		select {
+		case <-ctx.Done():
+			unmapCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			m.unmap(unmapCtx)
+			cancel()
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// refresh (re)creates the mapping, preferring whichever protocol worked
+// before, and returns the lease lifetime granted.
+func (m *Mapper) refresh(ctx context.Context) (time.Duration, error) {
+	m.mu.RLock()
+	gw, dev := m.gw, m.dev
+	m.mu.RUnlock()
+
+	var errs []error
+	if dev == nil {
+		if gw == nil {
+			if ip, err := defaultGateway(); err == nil {
+				gw = &net.UDPAddr{IP: ip, Port: natpmpPort}
+			} else {
+				errs = append(errs, err)
+			}
+		}
+		if gw != nil {
+			lifetime, err := m.mapNATPMP(gw)
+			if err == nil {
+				return lifetime, nil
+			}
+			errs = append(errs, err)
+		}
+	}
+
+	if dev == nil {
+		d, err := discoverIGD(ctx, ssdpTimeout)
+		if err != nil {
+			return 0, joinErrors(append(errs, err))
+		}
+		dev = d
+	}
+	lifetime, err := m.mapUPnP(ctx, dev)
+	if err != nil {
+		// Rediscover next time in case the gateway moved or restarted.
+		m.mu.Lock()
+		m.dev = nil
+		m.mu.Unlock()
+		return 0, joinErrors(append(errs, err))
+	}
+	return lifetime, nil
+}
+
+func (m *Mapper) mapNATPMP(gw *net.UDPAddr) (time.Duration, error) {
+	ext, lifetime, err := natpmpMapTCP(gw, m.port, leaseLifetime)
+	if err != nil {
+		return 0, err
+	}
+	ip, err := natpmpExternalIP(gw)
+	if err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	m.gw = gw
+	m.status = Status{
+		Method:   MethodNATPMP,
+		External: net.JoinHostPort(ip.String(), strconv.Itoa(ext)),
+		Expires:  time.Now().Add(lifetime),
+	}
+	m.mu.Unlock()
+	return lifetime, nil
+}
+
+func (m *Mapper) mapUPnP(ctx context.Context, dev *igd) (time.Duration, error) {
+	if err := dev.addPortMapping(ctx, m.port, leaseLifetime); err != nil {
+		return 0, err
+	}
+	ip, err := dev.externalIP(ctx)
+	if err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	m.dev = dev
+	m.status = Status{
+		Method:   MethodUPnP,
+		External: net.JoinHostPort(ip.String(), strconv.Itoa(m.port)),
+		Expires:  time.Now().Add(leaseLifetime),
+	}
+	m.mu.Unlock()
+	return leaseLifetime, nil
+}
+
+func (m *Mapper) unmap(ctx context.Context) {
+	m.mu.Lock()
+	gw, dev := m.gw, m.dev
+	m.status = Status{}
+	m.mu.Unlock()
+
+	switch {
+	case dev != nil:
+		_ = dev.deletePortMapping(ctx, m.port)
+	case gw != nil:
+		_, _, _ = natpmpMapTCP(gw, m.port, 0)
+	}
+}
+
+func joinErrors(errs []error) error {
+	msg := ""
+	for i, err := range errs {
+		if i > 0 {
+			msg += "; "
+		}
+		msg += err.Error()
+	}
+	return fmt.Errorf("port mapping failed: %s", msg)
+}
@@ -0,0 +1,114 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for NAT-PMP and UPnP port mapping
+
+package natmap
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeNATPMP answers address and TCP mapping requests like a gateway.
+func fakeNATPMP(t *testing.T) *net.UDPAddr {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 16)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			switch {
+			case n == 2 && buf[1] == natpmpOpAddr:
+				resp := []byte{0, 128, 0, 0, 0, 0, 0, 1, 203, 0, 113, 7}
+				_, _ = conn.WriteToUDP(resp, from)
+			case n == 12 && buf[1] == natpmpOpTCP:
+				resp := make([]byte, 16)
+				resp[1] = 128 + natpmpOpTCP
+				copy(resp[8:10], buf[4:6])
+				binary.BigEndian.PutUint16(resp[10:12], 18787)
+				copy(resp[12:16], buf[8:12])
+				_, _ = conn.WriteToUDP(resp, from)
+			}
+		}
+	}()
+	return conn.LocalAddr().(*net.UDPAddr)
+}
+
+func TestMapperNATPMP(t *testing.T) {
+	gw := fakeNATPMP(t)
+	m := NewMapper(8787)
+	lifetime, err := m.mapNATPMP(gw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lifetime != leaseLifetime {
+		t.Errorf("Expected lifetime %v, got %v", leaseLifetime, lifetime)
+	}
+	st := m.Status()
+	if st.Method != MethodNATPMP || st.External != "203.0.113.7:18787" {
+		t.Errorf("Expected nat-pmp 203.0.113.7:18787, got %+v", st)
+	}
+}
+
+func TestMapperUPnP(t *testing.T) {
+	var actions []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/desc.xml":
+			io.WriteString(w, `<?xml version="1.0"?><root><device><deviceList><device><serviceList>
+<service><serviceType>urn:schemas-upnp-org:service:Layer3Forwarding:1</serviceType><controlURL>/l3f</controlURL></service>
+<service><serviceType>urn:schemas-upnp-org:service:WANIPConnection:1</serviceType><controlURL>/ctl/IPConn</controlURL></service>
+</serviceList></device></deviceList></device></root>`)
+		case "/ctl/IPConn":
+			action := r.Header.Get("SOAPAction")
+			actions = append(actions, action)
+			body, _ := io.ReadAll(r.Body)
+			if strings.Contains(action, "AddPortMapping") && !strings.Contains(string(body), "<NewInternalPort>8787</NewInternalPort>") {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			io.WriteString(w, `<s:Envelope><s:Body><u:R><NewExternalIPAddress>198.51.100.4</NewExternalIPAddress></u:R></s:Body></s:Envelope>`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	dev, err := describeIGD(ctx, srv.URL+"/desc.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dev.controlURL != srv.URL+"/ctl/IPConn" {
+		t.Fatalf("Expected the WANIPConnection control URL, got %s", dev.controlURL)
+	}
+
+	m := NewMapper(8787)
+	if _, err := m.mapUPnP(ctx, dev); err != nil {
+		t.Fatal(err)
+	}
+	if st := m.Status(); st.Method != MethodUPnP || st.External != "198.51.100.4:8787" || st.Expires.Before(time.Now()) {
+		t.Errorf("Expected upnp 198.51.100.4:8787, got %+v", st)
+	}
+
+	m.unmap(ctx)
+	if len(actions) != 3 || !strings.Contains(actions[2], "#DeletePortMapping") {
+		t.Errorf("Expected add, get external address and delete, got %v", actions)
+	}
+	if st := m.Status(); st.External != "" {
+		t.Errorf("Expected the status to be cleared after unmapping, got %+v", st)
+	}
+}
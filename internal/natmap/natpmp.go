@@ -0,0 +1,110 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// NAT-PMP (RFC 6886) client
+
+package natmap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	natpmpPort    = 5351
+	natpmpOpAddr  = 0
+	natpmpOpTCP   = 2
+	natpmpRetries = 4
+)
+
+// natpmpCall sends a request to the gateway and waits for the matching
+// response, doubling the timeout on each retry as the RFC asks.
+func natpmpCall(gw *net.UDPAddr, req []byte, respLen int) ([]byte, error) {
+	conn, err := net.DialUDP("udp4", nil, gw)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 16)
+	timeout := 250 * time.Millisecond
+	for range natpmpRetries {
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(timeout))
+		n, err := conn.Read(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				timeout *= 2
+				continue
+			}
+			return nil, err
+		}
+		if n < respLen || buf[1] != req[1]+128 {
+			continue
+		}
+		if code := binary.BigEndian.Uint16(buf[2:4]); code != 0 {
+			return nil, fmt.Errorf("nat-pmp: gateway returned result code %d", code)
+		}
+		return buf[:n], nil
+	}
+	return nil, fmt.Errorf("nat-pmp: no response from %s", gw)
+}
+
+func natpmpExternalIP(gw *net.UDPAddr) (net.IP, error) {
+	resp, err := natpmpCall(gw, []byte{0, natpmpOpAddr}, 12)
+	if err != nil {
+		return nil, err
+	}
+	return net.IP(append([]byte(nil), resp[8:12]...)), nil
+}
+
+// natpmpMapTCP maps a TCP port and returns the external port and lifetime
+// granted. A zero lifetime removes the mapping.
+func natpmpMapTCP(gw *net.UDPAddr, port int, lifetime time.Duration) (int, time.Duration, error) {
+	req := make([]byte, 12)
+	req[1] = natpmpOpTCP
+	binary.BigEndian.PutUint16(req[4:6], uint16(port))
+	if lifetime > 0 {
+		binary.BigEndian.PutUint16(req[6:8], uint16(port))
+	}
+	binary.BigEndian.PutUint32(req[8:12], uint32(lifetime/time.Second))
+	resp, err := natpmpCall(gw, req, 16)
+	if err != nil {
+		return 0, 0, err
+	}
+	ext := int(binary.BigEndian.Uint16(resp[10:12]))
+	granted := time.Duration(binary.BigEndian.Uint32(resp[12:16])) * time.Second
+	return ext, granted, nil
+}
+
+// defaultGateway returns the IPv4 default gateway from the kernel routing
+// table. Only Linux exposes it without exec'ing route(8).
+func defaultGateway() (net.IP, error) {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return nil, fmt.Errorf("cannot determine default gateway: %w", err)
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue
+		}
+		b, err := hex.DecodeString(fields[2])
+		if err != nil || len(b) != 4 {
+			continue
+		}
+		// The table is in host (little-endian) byte order.
+		return net.IPv4(b[3], b[2], b[1], b[0]), nil
+	}
+	return nil, fmt.Errorf("no default route")
+}
@@ -0,0 +1,234 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// UPnP Internet Gateway Device client: SSDP discovery and WAN port mapping
+
+package natmap
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const ssdpSearch = "M-SEARCH * HTTP/1.1\r\n" +
+	"HOST: 239.255.255.250:1900\r\n" +
+	"ST: urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\n" +
+	"MAN: \"ssdp:discover\"\r\n" +
+	"MX: 2\r\n\r\n"
+
+var ssdpAddr = &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 1900}
+
+// igd is the WAN connection service of an Internet Gateway Device.
+type igd struct {
+	serviceType string
+	controlURL  string
+	localIP     net.IP // our address on the gateway's LAN
+}
+
+// discoverIGD finds a gateway over SSDP and returns its WAN connection
+// service.
+func discoverIGD(ctx context.Context, timeout time.Duration) (*igd, error) {
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if _, err := conn.WriteToUDP([]byte(ssdpSearch), ssdpAddr); err != nil {
+		return nil, err
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return nil, fmt.Errorf("upnp: no gateway found")
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil {
+			continue
+		}
+		location := resp.Header.Get("Location")
+		if location == "" {
+			continue
+		}
+		if dev, err := describeIGD(ctx, location); err == nil {
+			return dev, nil
+		}
+	}
+}
+
+// describeIGD fetches a device description and picks its WANIPConnection
+// or WANPPPConnection service.
+func describeIGD(ctx context.Context, location string) (*igd, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	base, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	var dev *igd
+	var urlBase string
+	var service struct{ serviceType, controlURL string }
+	var field string
+	dec := xml.NewDecoder(io.LimitReader(resp.Body, 1<<20))
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("upnp: bad description: %w", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			field = t.Name.Local
+			if field == "service" {
+				service.serviceType, service.controlURL = "", ""
+			}
+		case xml.CharData:
+			text := strings.TrimSpace(string(t))
+			switch field {
+			case "URLBase":
+				urlBase = text
+			case "serviceType":
+				service.serviceType += text
+			case "controlURL":
+				service.controlURL += text
+			}
+		case xml.EndElement:
+			field = ""
+			if t.Name.Local == "service" && dev == nil &&
+				(strings.Contains(service.serviceType, ":WANIPConnection:") || strings.Contains(service.serviceType, ":WANPPPConnection:")) {
+				dev = &igd{serviceType: service.serviceType, controlURL: service.controlURL}
+			}
+		}
+	}
+	if dev == nil {
+		return nil, fmt.Errorf("upnp: %s has no WAN connection service", location)
+	}
+
+	if urlBase != "" {
+		if u, err := url.Parse(urlBase); err == nil {
+			base = u
+		}
+	}
+	ctl, err := base.Parse(dev.controlURL)
+	if err != nil {
+		return nil, err
+	}
+	dev.controlURL = ctl.String()
+
+	// The address the gateway sees us on, for NewInternalClient.
+	if c, err := net.Dial("udp4", base.Host); err == nil {
+		dev.localIP = c.LocalAddr().(*net.UDPAddr).IP
+		_ = c.Close()
+	} else if c, err := net.Dial("udp4", net.JoinHostPort(base.Hostname(), "80")); err == nil {
+		dev.localIP = c.LocalAddr().(*net.UDPAddr).IP
+		_ = c.Close()
+	}
+	return dev, nil
+}
+
+// soap invokes action on the service and returns the response arguments.
+func (d *igd) soap(ctx context.Context, action string, args [][2]string) (map[string]string, error) {
+	var body strings.Builder
+	body.WriteString(`<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>`)
+	fmt.Fprintf(&body, `<u:%s xmlns:u="%s">`, action, d.serviceType)
+	for _, a := range args {
+		fmt.Fprintf(&body, "<%s>%s</%s>", a[0], html.EscapeString(a[1]), a[0])
+	}
+	fmt.Fprintf(&body, "</u:%s></s:Body></s:Envelope>", action)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.controlURL, strings.NewReader(body.String()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", fmt.Sprintf(`"%s#%s"`, d.serviceType, action))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	out := make(map[string]string)
+	var field string
+	dec := xml.NewDecoder(io.LimitReader(resp.Body, 1<<16))
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			break
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			field = t.Name.Local
+		case xml.CharData:
+			if field != "" {
+				out[field] += strings.TrimSpace(string(t))
+			}
+		case xml.EndElement:
+			field = ""
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("upnp: %s failed: %s %s", action, resp.Status, out["errorDescription"])
+	}
+	return out, nil
+}
+
+func (d *igd) externalIP(ctx context.Context) (net.IP, error) {
+	out, err := d.soap(ctx, "GetExternalIPAddress", nil)
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(out["NewExternalIPAddress"])
+	if ip == nil {
+		return nil, fmt.Errorf("upnp: gateway returned no external address")
+	}
+	return ip, nil
+}
+
+func (d *igd) addPortMapping(ctx context.Context, port int, lifetime time.Duration) error {
+	if d.localIP == nil {
+		return fmt.Errorf("upnp: cannot determine our LAN address")
+	}
+	_, err := d.soap(ctx, "AddPortMapping", [][2]string{
+		{"NewRemoteHost", ""},
+		{"NewExternalPort", strconv.Itoa(port)},
+		{"NewProtocol", "TCP"},
+		{"NewInternalPort", strconv.Itoa(port)},
+		{"NewInternalClient", d.localIP.String()},
+		{"NewEnabled", "1"},
+		{"NewPortMappingDescription", "IPXTransporter"},
+		{"NewLeaseDuration", strconv.Itoa(int(lifetime / time.Second))},
+	})
+	return err
+}
+
+func (d *igd) deletePortMapping(ctx context.Context, port int) error {
+	_, err := d.soap(ctx, "DeletePortMapping", [][2]string{
+		{"NewRemoteHost", ""},
+		{"NewExternalPort", strconv.Itoa(port)},
+		{"NewProtocol", "TCP"},
+	})
+	return err
+}
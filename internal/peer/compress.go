@@ -0,0 +1,62 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Packets deflated on links whose peers accept it
+
+package peer
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// compressFlag marks a relayed packet deflated on its own: the payload is
+// raw DEFLATE of the packet, without state carried from one packet to the
+// next. It is only sent to peers whose hello says they accept it, and
+// never padded, as the sizes padding hides are what compression shows.
+const compressFlag = uint32(1) << 28
+
+// maxPacketLen caps a relayed packet, as sent and once inflated.
+const maxPacketLen = 2000 // Max IPX packet is around 576-1500
+
+type deflater struct {
+	buf bytes.Buffer
+	w   *flate.Writer
+}
+
+var deflaters = sync.Pool{New: func() any {
+	d := &deflater{}
+	d.w, _ = flate.NewWriter(&d.buf, flate.BestSpeed)
+	return d
+}}
+
+// deflate returns data compressed, or nil if that saves nothing.
+func deflate(data []byte) []byte {
+	d := deflaters.Get().(*deflater)
+	defer deflaters.Put(d)
+	d.buf.Reset()
+	d.w.Reset(&d.buf)
+	if _, err := d.w.Write(data); err != nil {
+		return nil
+	}
+	if err := d.w.Close(); err != nil || d.buf.Len() >= len(data) {
+		return nil
+	}
+	return bytes.Clone(d.buf.Bytes())
+}
+
+// inflate decompresses a packet sent with compressFlag.
+func inflate(data []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+	out, err := io.ReadAll(io.LimitReader(r, maxPacketLen+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > maxPacketLen {
+		return nil, fmt.Errorf("inflates past %d bytes", maxPacketLen)
+	}
+	return out, nil
+}
@@ -0,0 +1,349 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Control frames exchanged between peers alongside relayed traffic
+
+package peer
+
+import (
+	"encoding/json"
+	"net"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+// controlFlag marks a length prefix as belonging to a control frame rather
+// than a relayed packet. Relayed packets are capped well below this.
+const (
+	controlFlag       = uint32(1) << 31
+	maxControlLen     = 64 * 1024
+	pingInterval      = 5 * time.Second
+	controlBufferSize = 32
+)
+
+// Control frame types.
+const (
+	ControlPing     = "ping"
+	ControlPong     = "pong"
+	ControlStatus   = "status"
+	ControlRedirect = "redirect"
+	ControlHello    = "hello"
+	ControlTopology = "topology"
+	ControlGossip   = "gossip"
+	ControlSegments = "segments"
+
+	// Mesh-wide capture: the command floods the tree from the initiator,
+	// recorded files travel back towards it in chunks.
+	ControlCapture     = "capture"
+	ControlCaptureData = "capture_data"
+
+	// Warm standby: a primary hub mirrors its config to a paired standby.
+	ControlReplicate = "replicate"
+
+	// Relay-assisted traversal: a node asks a common peer to forward its
+	// traffic to a node it cannot link to directly.
+	ControlRelayRequest = "relay_request"
+	ControlRelayAccept  = "relay_accept"
+	ControlRelayRefuse  = "relay_refuse"
+)
+
+// ProtocolVersion is the version of the peer protocol this node speaks,
+// announced in its hello. Nodes from before hellos carried a version send
+// none and speak version 1. Version 2 announces the version itself.
+const ProtocolVersion = 2
+
+// Features a link negotiates in the hellos, beyond the protocol version.
+const (
+	FeaturePadding  = "padding"  // the peer accepts padded packets
+	FeatureTraces   = "traces"   // the peer accepts traced packets
+	FeatureDeclares = "declares" // the peer declares the networks reachable through it
+	FeatureSigned   = "signed"   // the peer signs its metadata frames
+
+	FeatureCompression = "compression" // the peer accepts compressed packets
+)
+
+// Features lists every feature a link can negotiate.
+var Features = []string{FeaturePadding, FeatureTraces, FeatureDeclares, FeatureSigned, FeatureCompression}
+
+// Link roles announced in the hello frame. The dialing side is the child.
+const (
+	RoleParent = "parent"
+	RoleChild  = "child"
+)
+
+// TopologyNode describes one node of the overlay tree. ParentID is the node
+// ID of its parent, or empty for the root. Hops counts links from the node
+// that sent the topology frame.
+type TopologyNode struct {
+	ID          string `json:"id"`
+	ParentID    string `json:"parent_id,omitempty"`
+	Hostname    string `json:"hostname,omitempty"`
+	NumChildren int    `json:"num_children"`
+	MaxChildren int    `json:"max_children"`
+	Hops        int    `json:"hops"`
+
+	// Hub election: where the node can be reached, how much it wants to
+	// be hub (0 = never) and when it started, for the uptime tiebreak.
+	ListenAddr  string `json:"listen_addr,omitempty"`
+	HubPriority int    `json:"hub_priority,omitempty"`
+	StartedAt   int64  `json:"started_at,omitempty"`
+
+	Metric int `json:"metric,omitempty"` // of the node's link to its parent, 0 if not measured yet
+}
+
+// CaptureCommand asks nodes to record their traffic over the same period.
+// Times are on the initiator's clock: each hop sets SentAt, from which the
+// next one estimates how far its own clock is off.
+type CaptureCommand struct {
+	ID         string   `json:"id"`
+	Origin     string   `json:"origin"`          // node ID of the initiator
+	Nodes      []string `json:"nodes,omitempty"` // node IDs to record, all if empty
+	Start      int64    `json:"start"`           // unix nanos
+	SentAt     int64    `json:"sent_at"`         // unix nanos
+	Duration   int      `json:"duration"`        // seconds
+	MaxPackets int      `json:"max_packets,omitempty"`
+	MaxBytes   int      `json:"max_bytes,omitempty"`
+	Pull       bool     `json:"pull,omitempty"` // send the files back to the initiator
+}
+
+// CaptureChunk is a piece of a recorded file on its way to the initiator.
+// Size is the whole file's; Error reports a node that recorded nothing.
+type CaptureChunk struct {
+	ID     string `json:"id"`
+	Node   string `json:"node"`
+	Offset int64  `json:"offset"`
+	Size   int64  `json:"size"`
+	Data   []byte `json:"data,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Control is a JSON-encoded message on the peer link. Which fields are set
+// depends on Type.
+type Control struct {
+	Type        string   `json:"type"`
+	Timestamp   int64    `json:"ts,omitempty"`           // ping/pong: sender's unix nanos
+	ListenAddr  string   `json:"listen_addr,omitempty"`  // status: sender's peer listener
+	NumChildren int      `json:"num_children,omitempty"` // status
+	MaxChildren int      `json:"max_children,omitempty"` // status
+	Networks    []uint32 `json:"networks,omitempty"`     // status: IPX networks local to the sender
+	Advertise   bool     `json:"advertise,omitempty"`    // status: sender's address may be gossiped
+	Cost        int      `json:"cost,omitempty"`         // status: the administrative cost the sender's config gives the link
+	Addr        string   `json:"addr,omitempty"`         // redirect: node to reconnect to
+
+	NodeID string         `json:"node_id,omitempty"` // hello: sender's node ID
+	Role   string         `json:"role,omitempty"`    // hello: sender's side of the link
+	Nodes  []TopologyNode `json:"nodes,omitempty"`   // topology: tree as seen by the sender
+	Peers  []string       `json:"peers,omitempty"`   // gossip: listen addresses of the sender's other peers
+
+	Segments []stats.SegmentReport `json:"segments,omitempty"` // segments: sender's and its subtree's, to the parent
+
+	Capture     *CaptureCommand `json:"capture,omitempty"`      // capture
+	CaptureData *CaptureChunk   `json:"capture_data,omitempty"` // capture_data
+
+	Replica json.RawMessage `json:"replica,omitempty"` // replicate: the primary's config, as the relay encodes it
+
+	Target string `json:"target,omitempty"` // relay_*: node ID at the far end of the relayed path
+	Reason string `json:"reason,omitempty"` // relay_refuse: why the path is refused or closed
+
+	Protocol  int    `json:"protocol,omitempty"`   // hello: sender's ProtocolVersion, none from version 1
+	PublicKey []byte `json:"public_key,omitempty"` // hello: PKIX key that signs the sender's metadata
+	Padding   bool   `json:"padding,omitempty"`    // hello: sender accepts padded packets
+	Traces    bool   `json:"traces,omitempty"`     // hello: sender accepts traced packets
+	Deflate   bool   `json:"deflate,omitempty"`    // hello: sender accepts compressed packets
+	Standby   bool   `json:"standby,omitempty"`    // hello: sender is our warm standby and asks for our config
+	Probe     bool   `json:"probe,omitempty"`      // hello: sender only tests the link and closes it, send it no traffic
+
+	// hello, status: the sender declares the IPX networks reachable
+	// through it, its own and those declared to it by its other links.
+	Declares bool     `json:"declares,omitempty"`
+	Declared []uint32 `json:"declared,omitempty"`
+}
+
+// SetControlHandler registers a callback for control frames other than ping,
+// pong and status. Hello and topology frames are recorded on the peer before
+// the callback runs.
+func (p *Peer) SetControlHandler(fn func(p *Peer, c Control)) {
+	p.mu.Lock()
+	p.onControl = fn
+	p.mu.Unlock()
+}
+
+// SetFrameHandler registers a callback for every relayed packet received
+// from the peer, called before the packet is handed to the relay loop.
+// hops is the packet's relay trace, nil unless it carries one.
+func (p *Peer) SetFrameHandler(fn func(p *Peer, data []byte, hops []stats.TraceHop)) {
+	p.mu.Lock()
+	p.onFrame = fn
+	p.mu.Unlock()
+}
+
+// SetHandshakeHandler registers a callback for the end of the network key
+// handshake, with the error that failed it or nil. The link is closed
+// after a failed one.
+func (p *Peer) SetHandshakeHandler(fn func(p *Peer, err error)) {
+	p.mu.Lock()
+	p.onHandshake = fn
+	p.mu.Unlock()
+}
+
+// SendControl queues a control frame. It returns false if the control
+// queue is full or the link closed.
+func (p *Peer) SendControl(c Control) bool {
+	p.sendMu.Lock()
+	defer p.sendMu.Unlock()
+	return p.sendControlLocked(c)
+}
+
+func (p *Peer) sendControlLocked(c Control) bool {
+	if p.closed || len(p.ctrlQueue) >= controlBufferSize {
+		return false
+	}
+	p.ctrlQueue = append(p.ctrlQueue, c)
+	if !p.quiet {
+		p.wakeLocked()
+	}
+	return true
+}
+
+// NodeID returns the remote node ID announced in its hello frame.
+func (p *Peer) NodeID() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.nodeID
+}
+
+// Topology returns the part of the overlay tree last reported by the peer,
+// including the peer itself.
+func (p *Peer) Topology() []TopologyNode {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return append([]TopologyNode(nil), p.topology...)
+}
+
+// Negotiated returns the protocol version and the features the hellos
+// settled on, or 0 and none before the peer's hello.
+func (p *Peer) Negotiated() (protocol int, features []string) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.protocol, p.featuresLocked()
+}
+
+// featuresLocked returns the features the peer's hello offered, all of
+// which this node supports. Must be called with p.mu held.
+func (p *Peer) featuresLocked() []string {
+	if p.protocol == 0 {
+		return nil
+	}
+	var out []string
+	for _, f := range []struct {
+		name string
+		on   bool
+	}{
+		{FeaturePadding, p.remotePads},
+		{FeatureTraces, p.remoteTraces},
+		{FeatureDeclares, p.declares},
+		{FeatureSigned, p.remoteKey != nil},
+		{FeatureCompression, p.remoteFlate},
+	} {
+		if f.on {
+			out = append(out, f.name)
+		}
+	}
+	return out
+}
+
+// MayAdvertise reports whether the peer allows its address to be gossiped
+// to other nodes.
+func (p *Peer) MayAdvertise() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.mayAdvertise
+}
+
+// RemoteListenAddr returns the address other nodes can use to reach this
+// peer's listener, built from its observed IP and advertised listen port.
+func (p *Peer) RemoteListenAddr() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.remoteListen
+}
+
+func (p *Peer) handleControl(payload []byte) {
+	p.heard()
+	var c Control
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return
+	}
+	if !p.verifyControl(c, payload) {
+		return
+	}
+	if c.Type != ControlPing && c.Type != ControlPong {
+		logger.Peer.Debug("Peer %s: received %s frame", p.ID, c.Type)
+	}
+
+	switch c.Type {
+	case ControlPing:
+		p.SendControl(Control{Type: ControlPong, Timestamp: c.Timestamp})
+	case ControlPong:
+		rtt := time.Since(time.Unix(0, c.Timestamp))
+		p.mu.Lock()
+		p.latencyMs = float64(rtt.Microseconds()) / 1000
+		p.mu.Unlock()
+	case ControlStatus:
+		p.mu.Lock()
+		p.numChildren = c.NumChildren
+		p.maxChildren = c.MaxChildren
+		p.remoteListen = p.listenAddrFor(c.ListenAddr)
+		p.advertised = c.Networks
+		p.mayAdvertise = c.Advertise
+		p.remoteCost = c.Cost
+		if c.Declares {
+			p.declares, p.declared = true, c.Declared
+		}
+		p.mu.Unlock()
+	case ControlHello:
+		p.mu.Lock()
+		p.nodeID = c.NodeID
+		p.protocol = min(max(c.Protocol, 1), ProtocolVersion)
+		p.remotePads = c.Padding
+		p.remoteTraces = c.Traces
+		p.remoteFlate = c.Deflate
+		p.declares, p.declared = c.Declares, c.Declared
+		p.mu.Unlock()
+		p.forwardControl(c)
+	case ControlTopology:
+		p.mu.Lock()
+		p.topology = c.Nodes
+		p.mu.Unlock()
+		p.forwardControl(c)
+	default:
+		p.forwardControl(c)
+	}
+}
+
+func (p *Peer) forwardControl(c Control) {
+	p.mu.RLock()
+	fn := p.onControl
+	p.mu.RUnlock()
+	if fn != nil {
+		fn(p, c)
+	}
+}
+
+// listenAddrFor combines the peer's observed IP with the port of its
+// advertised listen address. Must be called with p.mu held.
+func (p *Peer) listenAddrFor(advertised string) string {
+	if advertised == "" {
+		return ""
+	}
+	_, port, err := net.SplitHostPort(advertised)
+	if err != nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Conn.RemoteAddr().String())
+	if err != nil {
+		return ""
+	}
+	return net.JoinHostPort(host, port)
+}
@@ -0,0 +1,305 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Peer lifecycle tests over links with injected faults
+
+package peer
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errInjected = errors.New("injected fault")
+
+// faultConn wraps a net.Conn with the faults of a flaky link. Faults are
+// drawn from a seeded source, so a failing seed can be run again.
+type faultConn struct {
+	net.Conn
+	chunk     int           // bytes moved per underlying read or write at most, 0 for no limit
+	readDelay time.Duration // one read in delayEvery first waits up to this long
+	failWrite float64       // chance that a chunk is cut short and the link reset
+	resetAt   int           // reset the link once this many bytes are written, 0 for never
+
+	mu      sync.Mutex
+	rnd     *rand.Rand
+	written int
+	cutAt   int  // bytes written when a write was cut short, -1 if none
+	broken  bool // reset by us; the conn fails as a reset one does
+}
+
+// delayEvery is how rarely reads are delayed: sleeping before every read
+// of a few bytes makes a test crawl without exercising anything more.
+const delayEvery = 32
+
+func newFaultConn(conn net.Conn, seed uint64) *faultConn {
+	return &faultConn{Conn: conn, rnd: rand.New(rand.NewPCG(seed, seed)), cutAt: -1}
+}
+
+func (c *faultConn) intN(n int) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rnd.IntN(n)
+}
+
+func (c *faultConn) float() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rnd.Float64()
+}
+
+// reset drops the connection the way a crashed host or a middlebox does:
+// on TCP the far end sees a reset rather than a clean close.
+func (c *faultConn) reset() {
+	c.mu.Lock()
+	c.broken = true
+	c.mu.Unlock()
+	if tcp, ok := c.Conn.(*net.TCPConn); ok {
+		tcp.SetLinger(0)
+	}
+	c.Conn.Close()
+}
+
+func (c *faultConn) Write(b []byte) (int, error) {
+	n := 0
+	for n < len(b) {
+		if c.resetAt > 0 && c.written >= c.resetAt {
+			c.reset()
+			return n, errInjected
+		}
+		size := len(b) - n
+		if c.chunk > 0 {
+			size = min(size, 1+c.intN(c.chunk))
+		}
+		if c.resetAt > 0 {
+			size = min(size, c.resetAt-c.written)
+		}
+		cut := c.failWrite > 0 && c.float() < c.failWrite
+		if cut {
+			size = c.intN(size)
+		}
+		m, err := c.Conn.Write(b[n : n+size])
+		n += m
+		c.written += m
+		if err != nil {
+			return n, err
+		}
+		if cut {
+			c.cutAt = c.written
+			c.reset()
+			return n, errInjected
+		}
+	}
+	return n, nil
+}
+
+func (c *faultConn) Read(b []byte) (int, error) {
+	if c.readDelay > 0 && c.intN(delayEvery) == 0 {
+		time.Sleep(time.Duration(c.intN(int(c.readDelay)) + 1))
+	}
+	if c.chunk > 0 && len(b) > 0 {
+		b = b[:min(len(b), 1+c.intN(c.chunk))]
+	}
+	n, err := c.Conn.Read(b)
+	if err != nil {
+		c.mu.Lock()
+		// Our own reset closed the conn under a pending read; a real one
+		// fails it with the reset instead of net.ErrClosed.
+		if c.broken {
+			err = errInjected
+		}
+		c.mu.Unlock()
+	}
+	return n, err
+}
+
+// flakyLink links two running peers over loopback TCP, passing each end
+// through wrap, and returns the dialing side, the channel the accepting
+// side relays to and a channel receiving both IDs as their Run returns.
+func flakyLink(t *testing.T, ctx context.Context, key string, wrapDial, wrapAccept func(net.Conn) net.Conn) (*Peer, <-chan []byte, <-chan string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	relayed := make(chan []byte, 1000)
+	done := make(chan string, 2)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		child := NewPeer("child", wrapAccept(conn), key)
+		child.NoLookup = true
+		child.Run(ctx, relayed, func(id string) { done <- id })
+	}()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent := NewPeer("parent", wrapDial(conn), key)
+	parent.NoLookup = true
+	go parent.Run(ctx, make(chan []byte, 10), func(id string) { done <- id })
+	return parent, relayed, done
+}
+
+// seqFrame is packet i of a test stream: its number and filler.
+func seqFrame(i, size int) []byte {
+	data := bytes.Repeat([]byte{byte(i)}, max(size, 4))
+	binary.BigEndian.PutUint32(data, uint32(i))
+	return data
+}
+
+// sendSeq queues packets 0 to n-1, waiting out a full send queue, until the
+// link closes.
+func sendSeq(ctx context.Context, p *Peer, n int, size func(i int) int) {
+	for i := 0; i < n; i++ {
+		for !p.Send(seqFrame(i, size(i))) {
+			p.sendMu.Lock()
+			closed := p.closed
+			p.sendMu.Unlock()
+			if closed || ctx.Err() != nil {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+		if i%40 == 0 {
+			p.SendControl(Control{Type: ControlStatus, NumChildren: i})
+		}
+	}
+}
+
+// waitDone waits for both ends of a link to finish Run.
+func waitDone(t *testing.T, done <-chan string) {
+	t.Helper()
+	for range 2 {
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatal("Timed out waiting for the link to shut down")
+		}
+	}
+}
+
+// A link whose bytes arrive a few at a time and late still completes the
+// handshake and delivers every packet intact and in order, with many such
+// links running at once.
+func TestPeerFragmentedLinks(t *testing.T) {
+	for seed := range uint64(8) {
+		t.Run(fmt.Sprintf("seed%d", seed), func(t *testing.T) {
+			t.Parallel()
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+			defer cancel()
+			wrap := func(conn net.Conn) net.Conn {
+				fc := newFaultConn(conn, seed)
+				fc.chunk = 7
+				fc.readDelay = time.Millisecond
+				return fc
+			}
+			key := ""
+			if seed%2 == 0 {
+				key = "secret"
+			}
+			parent, relayed, done := flakyLink(t, ctx, key, wrap, wrap)
+
+			sizes := rand.New(rand.NewPCG(seed, 0))
+			n := 200
+			want := make([]int, n)
+			for i := range want {
+				want[i] = 30 + sizes.IntN(1400)
+			}
+			go sendSeq(ctx, parent, n, func(i int) int { return want[i] })
+			for i := range n {
+				select {
+				case data := <-relayed:
+					if !bytes.Equal(data, seqFrame(i, want[i])) {
+						t.Fatalf("Expected packet %d of %d bytes, got %d bytes", i, want[i], len(data))
+					}
+				case <-ctx.Done():
+					t.Fatalf("Timed out after %d of %d packets", i, n)
+				}
+			}
+			cancel()
+			waitDone(t, done)
+		})
+	}
+}
+
+// A link reset at any point of the handshake, or just after it, ends Run
+// on both sides.
+func TestPeerHandshakeResets(t *testing.T) {
+	const key = "secret"
+	for resetAt := 1; resetAt <= 4+len(key)+8; resetAt++ {
+		t.Run(fmt.Sprintf("after%d", resetAt), func(t *testing.T) {
+			t.Parallel()
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+			defer cancel()
+			wrapDial := func(conn net.Conn) net.Conn {
+				fc := newFaultConn(conn, uint64(resetAt))
+				fc.chunk = 3
+				fc.resetAt = resetAt
+				return fc
+			}
+			keep := func(conn net.Conn) net.Conn { return conn }
+			_, _, done := flakyLink(t, ctx, key, wrapDial, keep)
+			waitDone(t, done)
+		})
+	}
+}
+
+// Writes cut short at random end the link, and the receiving side never
+// relays a misframed packet: what arrives is a prefix of what was sent.
+func TestPeerWriteFaultsNeverMisframe(t *testing.T) {
+	for seed := range uint64(8) {
+		t.Run(fmt.Sprintf("seed%d", seed), func(t *testing.T) {
+			t.Parallel()
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+			defer cancel()
+			var fc *faultConn
+			wrapDial := func(conn net.Conn) net.Conn {
+				fc = newFaultConn(conn, seed)
+				fc.chunk = 64
+				fc.failWrite = 0.01
+				return fc
+			}
+			keep := func(conn net.Conn) net.Conn { return conn }
+			parent, relayed, done := flakyLink(t, ctx, "", wrapDial, keep)
+
+			size := func(i int) int { return 30 + (i*37)%1200 }
+			go sendSeq(ctx, parent, 100000, size)
+			i := 0
+			check := func(data []byte) {
+				if !bytes.Equal(data, seqFrame(i, size(i))) {
+					t.Fatalf("Expected packet %d of %d bytes, got %d bytes starting %x", i, size(i), len(data), data[:min(len(data), 4)])
+				}
+				i++
+			}
+			for ended := 0; ended < 2; {
+				select {
+				case data := <-relayed:
+					check(data)
+				case <-done:
+					ended++
+				case <-ctx.Done():
+					t.Fatalf("Timed out with the link up after %d packets", i)
+				}
+			}
+			for len(relayed) > 0 {
+				check(<-relayed)
+			}
+			// A cut past the handshake is a send error of the running link.
+			if st := parent.GetStats(); fc.cutAt > 4 && st.Errors == 0 {
+				t.Errorf("Expected the cut write to be counted as an error, got %d", st.Errors)
+			}
+		})
+	}
+}
@@ -0,0 +1,245 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Length-prefixed framing of peer links with reused buffers
+
+package peer
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+)
+
+// Per-link buffer sizes. They bound what an idle or slow link costs a hub
+// with many peers: one read buffer, at most one slab and one batch.
+const (
+	readBufferSize = 16 * 1024
+	slabSize       = 16 * 1024
+	maxBatch       = 64        // frames coalesced into one write
+	maxBatchBytes  = 16 * 1024 // stop batching past this many payload bytes
+)
+
+// padFlag marks a relayed packet padded to hide its size: the payload is
+// the packet's length in two bytes, the packet and zeros. It is only sent
+// to peers whose hello says they accept it.
+const padFlag = uint32(1) << 30
+
+// Padding modes for SetPadding.
+const (
+	PadOff     = "off"
+	PadBuckets = "buckets" // pad to the next of padBuckets
+	PadFixed   = "fixed"   // pad everything to the largest bucket
+)
+
+// padBuckets are the sizes packets are padded to. The largest holds a full
+// Ethernet frame; larger packets are sent as they are.
+var padBuckets = []int{128, 256, 512, 1024, 1536}
+
+var zeroPad [1536]byte
+
+// ValidPadding reports whether mode is a padding mode.
+func ValidPadding(mode string) bool {
+	switch mode {
+	case "", PadOff, PadBuckets, PadFixed:
+		return true
+	}
+	return false
+}
+
+// padSize returns the size an n byte packet is padded to, or -1 if it is
+// sent as it is.
+func padSize(mode string, n int) int {
+	switch mode {
+	case PadBuckets:
+		for _, b := range padBuckets {
+			if n <= b {
+				return b
+			}
+		}
+	case PadFixed:
+		if b := padBuckets[len(padBuckets)-1]; n <= b {
+			return b
+		}
+	}
+	return -1
+}
+
+// frameReader reads length-prefixed frames through a persistent buffer, so
+// that a burst of small frames costs one read syscall rather than two per
+// frame.
+type frameReader struct {
+	r    *bufio.Reader
+	hdr  [4]byte
+	slab []byte // unused tail of the slab packets are carved from
+	ctrl []byte // reused for control payloads
+}
+
+func newFrameReader(conn net.Conn) *frameReader {
+	return &frameReader{r: bufio.NewReaderSize(conn, readBufferSize)}
+}
+
+// header returns the next length prefix, control flag included.
+func (fr *frameReader) header() (uint32, error) {
+	if _, err := io.ReadFull(fr.r, fr.hdr[:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(fr.hdr[:]), nil
+}
+
+// packet reads an n byte packet. Packets are handed on to the relay, so
+// they cannot share a buffer; instead they are carved from a slab that is
+// replaced once used up, which allocates once per slab rather than once
+// per packet. n must not exceed slabSize.
+func (fr *frameReader) packet(n int) ([]byte, error) {
+	if len(fr.slab) < n {
+		fr.slab = make([]byte, slabSize)
+	}
+	data := fr.slab[:n:n]
+	fr.slab = fr.slab[n:]
+	if _, err := io.ReadFull(fr.r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// control reads an n byte control payload. It is only valid until the next
+// call: control frames are decoded before the next frame is read.
+func (fr *frameReader) control(n int) ([]byte, error) {
+	if cap(fr.ctrl) < n {
+		fr.ctrl = make([]byte, n)
+	}
+	payload := fr.ctrl[:n]
+	if _, err := io.ReadFull(fr.r, payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// frameWriter batches length-prefixed frames into a single write.
+type frameWriter struct {
+	conn   net.Conn
+	hdrs   []byte // length prefixes of the batched frames
+	lens   []byte // packet lengths of padded frames, two bytes per frame
+	pads   []int  // zeros after each padded frame, -1 if not padded
+	frames [][]byte
+	plain  [][]byte // the packet of each compressed frame, nil for others
+	size   int      // payload bytes batched
+	vec    net.Buffers
+	out    net.Buffers // consumed by WriteTo
+	flat   []byte
+}
+
+func newFrameWriter(conn net.Conn) *frameWriter {
+	return &frameWriter{
+		conn:   conn,
+		hdrs:   make([]byte, 0, 4*maxBatch),
+		lens:   make([]byte, 0, 2*maxBatch),
+		pads:   make([]int, 0, maxBatch),
+		frames: make([][]byte, 0, maxBatch),
+		plain:  make([][]byte, 0, maxBatch),
+		vec:    make(net.Buffers, 0, 4*maxBatch),
+	}
+}
+
+func (fw *frameWriter) add(data []byte, flag uint32) {
+	fw.hdrs = binary.BigEndian.AppendUint32(fw.hdrs, uint32(len(data))|flag)
+	fw.lens = append(fw.lens, 0, 0)
+	fw.pads = append(fw.pads, -1)
+	fw.frames = append(fw.frames, data)
+	fw.plain = append(fw.plain, nil)
+	fw.size += len(data)
+}
+
+// addCompressed batches packed, the packet plain deflated, see
+// compressFlag.
+func (fw *frameWriter) addCompressed(packed, plain []byte) {
+	fw.add(packed, compressFlag)
+	fw.plain[len(fw.plain)-1] = plain
+}
+
+// addPadded batches a packet padded to size bytes, see padFlag.
+func (fw *frameWriter) addPadded(data []byte, size int) {
+	fw.hdrs = binary.BigEndian.AppendUint32(fw.hdrs, uint32(2+size)|padFlag)
+	fw.lens = binary.BigEndian.AppendUint16(fw.lens, uint16(len(data)))
+	fw.pads = append(fw.pads, size-len(data))
+	fw.frames = append(fw.frames, data)
+	fw.plain = append(fw.plain, nil)
+	fw.size += 2 + size
+}
+
+// overhead returns the bytes frame i takes on the wire beyond its packet.
+func (fw *frameWriter) overhead(i int) int {
+	if fw.pads[i] < 0 {
+		return 4
+	}
+	return 4 + 2 + fw.pads[i]
+}
+
+func (fw *frameWriter) pending() int {
+	return len(fw.frames)
+}
+
+// full reports whether the batch should be written before adding more.
+func (fw *frameWriter) full() bool {
+	return len(fw.frames) >= maxBatch || fw.size >= maxBatchBytes
+}
+
+// flush writes the batched frames and returns the bytes written. On plain
+// TCP this is one writev of the prefixes and payloads in place; TLS turns
+// every Write into a record of its own, so there the batch is copied into
+// one buffer and written once.
+func (fw *frameWriter) flush() (int64, error) {
+	if len(fw.frames) == 0 {
+		return 0, nil
+	}
+	if _, ok := fw.conn.(*net.TCPConn); ok {
+		fw.vec = fw.vec[:0]
+		for i, f := range fw.frames {
+			if fw.pads[i] < 0 {
+				fw.vec = append(fw.vec, fw.hdrs[4*i:4*i+4], f)
+				continue
+			}
+			fw.vec = append(fw.vec, fw.hdrs[4*i:4*i+4], fw.lens[2*i:2*i+2], f, zeroPad[:fw.pads[i]])
+		}
+		fw.out = fw.vec
+		return fw.out.WriteTo(fw.conn)
+	}
+	fw.flat = fw.flat[:0]
+	for i, f := range fw.frames {
+		fw.flat = append(fw.flat, fw.hdrs[4*i:4*i+4]...)
+		if fw.pads[i] >= 0 {
+			fw.flat = append(fw.flat, fw.lens[2*i:2*i+2]...)
+		}
+		fw.flat = append(fw.flat, f...)
+		if fw.pads[i] > 0 {
+			fw.flat = append(fw.flat, zeroPad[:fw.pads[i]]...)
+		}
+	}
+	n, err := fw.conn.Write(fw.flat)
+	return int64(n), err
+}
+
+// midFrame reports whether the first n bytes of the batch end inside a
+// frame, leaving the stream where the far end expects more of it.
+func (fw *frameWriter) midFrame(n int64) bool {
+	for i, f := range fw.frames {
+		if n <= 0 {
+			break
+		}
+		n -= int64(fw.overhead(i) + len(f))
+	}
+	return n < 0
+}
+
+// reset empties the batch, dropping its references to the frames.
+func (fw *frameWriter) reset() {
+	clear(fw.frames)
+	fw.frames = fw.frames[:0]
+	clear(fw.plain)
+	fw.plain = fw.plain[:0]
+	fw.hdrs = fw.hdrs[:0]
+	fw.lens = fw.lens[:0]
+	fw.pads = fw.pads[:0]
+	fw.size = 0
+}
@@ -0,0 +1,226 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests and benchmarks for peer link framing
+
+package peer
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/ipx"
+)
+
+// linkPair connects two running peers over loopback TCP and returns the
+// dialing side and the channel the accepting side relays frames to.
+func linkPair(tb testing.TB, ctx context.Context) (*Peer, <-chan []byte) {
+	tb.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { l.Close() })
+
+	relayed := make(chan []byte, 1000)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		NewPeer("child", conn, "").Run(ctx, relayed, func(id string) {})
+	}()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	parent := NewPeer("parent", conn, "")
+	go parent.Run(ctx, make(chan []byte, 10), func(id string) {})
+	return parent, relayed
+}
+
+func TestPeerFrameBatches(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	parent, relayed := linkPair(t, ctx)
+
+	// Queue more frames than fit in one batch, interleaved with control
+	// frames, and check they all arrive intact and in order.
+	const n = 3*maxBatch + 7
+	go func() {
+		for i := range n {
+			parent.Send(bytes.Repeat([]byte{byte(i)}, 30+i%500))
+			if i%50 == 0 {
+				parent.SendControl(Control{Type: ControlStatus, NumChildren: i})
+			}
+		}
+	}()
+	for i := range n {
+		select {
+		case data := <-relayed:
+			if want := bytes.Repeat([]byte{byte(i)}, 30+i%500); !bytes.Equal(data, want) {
+				t.Fatalf("Expected frame %d of %d bytes, got %d bytes starting %x", i, len(want), len(data), data[:1])
+			}
+		case <-ctx.Done():
+			t.Fatalf("Timed out after %d of %d frames", i, n)
+		}
+	}
+	if st := parent.GetStats(); st.SentPkts != n {
+		t.Errorf("Expected %d packets sent, got %d", n, st.SentPkts)
+	}
+}
+
+// BenchmarkPeerRelay measures a frame from the send queue of one peer to
+// the relay channel of the other, allocations of both sides included.
+func BenchmarkPeerRelay(b *testing.B) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	parent, relayed := linkPair(b, ctx)
+	packet := make([]byte, 562)
+	binary.BigEndian.PutUint16(packet[0:2], 0xffff)
+	binary.BigEndian.PutUint16(packet[2:4], uint16(len(packet)))
+	frame, err := ipx.EncapEthernetII(packet)
+	if err != nil {
+		b.Fatal(err)
+	}
+	parent.Send(frame)
+	<-relayed
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(frame)))
+	b.ResetTimer()
+	go func() {
+		for range b.N {
+			for !parent.Send(frame) {
+				runtime.Gosched()
+			}
+		}
+	}()
+	for range b.N {
+		<-relayed
+	}
+}
+
+// writeCounter is a net.Conn that counts Write calls.
+type writeCounter struct {
+	net.Conn
+	writes int
+	buf    bytes.Buffer
+}
+
+func (w *writeCounter) Write(b []byte) (int, error) {
+	w.writes++
+	return w.buf.Write(b)
+}
+
+func TestFrameWriterSingleWrite(t *testing.T) {
+	conn := &writeCounter{}
+	fw := newFrameWriter(conn)
+	fw.add([]byte("ab"), 0)
+	fw.add([]byte(`{}`), controlFlag)
+	fw.add([]byte("c"), 0)
+	if _, err := fw.flush(); err != nil {
+		t.Fatal(err)
+	}
+	fw.reset()
+	if conn.writes != 1 {
+		t.Errorf("Expected one write per batch, got %d", conn.writes)
+	}
+	want := []byte{0, 0, 0, 2, 'a', 'b', 0x80, 0, 0, 2, '{', '}', 0, 0, 0, 1, 'c'}
+	if !bytes.Equal(conn.buf.Bytes(), want) {
+		t.Errorf("Expected % x, got % x", want, conn.buf.Bytes())
+	}
+	if fw.pending() != 0 {
+		t.Errorf("Expected an empty batch after reset, got %d frames", fw.pending())
+	}
+}
+
+func TestObservedNetworksBounded(t *testing.T) {
+	p := NewPeer("p", &writeCounter{}, "")
+	start := time.Now()
+	p.mu.Lock()
+	for i := range maxObservedNets + 10 {
+		p.observeNetwork(uint32(i+1), start.Add(time.Duration(i)*time.Second))
+	}
+	p.mu.Unlock()
+	_, observed := p.Networks(time.Hour)
+	if len(observed) != maxObservedNets {
+		t.Fatalf("Expected %d observed networks, got %d", maxObservedNets, len(observed))
+	}
+	for _, n := range observed {
+		if n <= 10 {
+			t.Errorf("Expected the oldest networks to be evicted, found %d", n)
+		}
+	}
+}
+
+func TestPadSize(t *testing.T) {
+	for _, tc := range []struct {
+		mode string
+		n    int
+		want int
+	}{
+		{PadOff, 60, -1}, {"", 60, -1},
+		{PadBuckets, 60, 128}, {PadBuckets, 128, 128}, {PadBuckets, 129, 256}, {PadBuckets, 1500, 1536}, {PadBuckets, 1600, -1},
+		{PadFixed, 60, 1536}, {PadFixed, 1536, 1536}, {PadFixed, 1600, -1},
+	} {
+		if got := padSize(tc.mode, tc.n); got != tc.want {
+			t.Errorf("Expected padSize(%q, %d) = %d, got %d", tc.mode, tc.n, tc.want, got)
+		}
+	}
+}
+
+func TestFrameWriterPadded(t *testing.T) {
+	conn := &writeCounter{}
+	fw := newFrameWriter(conn)
+	fw.addPadded([]byte("abc"), 8)
+	fw.add([]byte("d"), 0)
+	if _, err := fw.flush(); err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0x40, 0, 0, 10, 0, 3, 'a', 'b', 'c', 0, 0, 0, 0, 0, 0, 0, 0, 1, 'd'}
+	if !bytes.Equal(conn.buf.Bytes(), want) {
+		t.Errorf("Expected % x, got % x", want, conn.buf.Bytes())
+	}
+	if !fw.midFrame(13) || fw.midFrame(14) || !fw.midFrame(16) || fw.midFrame(19) {
+		t.Error("Expected frame boundaries to include the padding")
+	}
+}
+
+func TestPeerPadding(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	parent, relayed := linkPair(t, ctx)
+	parent.SetPadding(PadBuckets)
+	parent.mu.Lock()
+	parent.remotePads = true // as if its hello said so
+	parent.mu.Unlock()
+
+	// Packets arrive as they were sent, whatever they were padded to.
+	sizes := []int{60, 200, 1500, 1800}
+	for _, n := range sizes {
+		parent.Send(bytes.Repeat([]byte{byte(n)}, n))
+	}
+	for _, n := range sizes {
+		select {
+		case data := <-relayed:
+			if !bytes.Equal(data, bytes.Repeat([]byte{byte(n)}, n)) {
+				t.Errorf("Expected a %d byte packet, got %d bytes", n, len(data))
+			}
+		case <-ctx.Done():
+			t.Fatal("Timed out waiting for padded packets")
+		}
+	}
+	// 1800 bytes exceed the largest bucket and go unpadded.
+	want := uint64((2 + 128 - 60) + (2 + 256 - 200) + (2 + 1536 - 1500))
+	for parent.GetStats().PadBytes != want && ctx.Err() == nil {
+		time.Sleep(time.Millisecond) // counted once the write returns
+	}
+	if st := parent.GetStats(); st.PadBytes != want || st.Padding != PadBuckets {
+		t.Errorf("Expected %d padding bytes in %s mode, got %d in %q", want, PadBuckets, st.PadBytes, st.Padding)
+	}
+}
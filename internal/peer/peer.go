@@ -6,8 +6,10 @@ package peer
 
 import (
 	"context"
+	"crypto"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -17,7 +19,9 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/mlapointe/ipxtransporter/internal/ipx"
 	"github.com/mlapointe/ipxtransporter/internal/logger"
+	"github.com/mlapointe/ipxtransporter/internal/rules"
 	"github.com/mlapointe/ipxtransporter/internal/stats"
 )
 
@@ -25,188 +29,342 @@ type Peer struct {
 	ID          string
 	Conn        net.Conn
 	ConnectedAt time.Time
-	SendChan    chan []byte
-
-	lastSeen    time.Time
-	sentBytes   uint64
-	recvBytes   uint64
-	sentPkts    uint64
-	recvPkts    uint64
-	errors      uint64
-	country     string
-	city        string
-	lat         float64
-	lon         float64
-	hostname    string
-	parentID    string
-	numChildren int
-	maxChildren int
-	whois       string
-	networkKey  string
-	latencyMs   float64
-	mu          sync.RWMutex
+	Inbound     bool   // accepted on our listener, i.e. one of our children
+	DialAddr    string // address we dialed for outbound links
+	NoLookup    bool   // skip GeoIP and reverse DNS, e.g. for synthetic load
+	SendBudget  int    // bytes of packets queued before more are dropped, DefaultSendBudget if zero
+
+	lastSeen     time.Time
+	sentBytes    uint64
+	recvBytes    uint64
+	sentPkts     uint64
+	recvPkts     uint64
+	errors       uint64
+	country      string
+	city         string
+	lat          float64
+	lon          float64
+	hostname     string
+	parentID     string
+	numChildren  int
+	maxChildren  int
+	whois        string
+	networkKey   string
+	latencyMs    float64
+	onControl    func(p *Peer, c Control)
+	onFrame      func(p *Peer, data []byte, hops []stats.TraceHop)
+	onHandshake  func(p *Peer, err error)
+	remoteListen string
+	mayAdvertise bool
+	advertised   []uint32             // networks the peer says are local to it
+	observedNets map[uint32]time.Time // source networks seen on frames from this link
+	declares     bool                 // the peer declares the networks reachable through it
+	declared     []uint32             // as of its last hello or status
+	nodeID       string
+	topology     []TopologyNode
+	frames       stats.FrameTypeCounter
+	mutedIn      atomic.Bool // drop frames received from the peer
+	mutedOut     atomic.Bool // drop frames queued for the peer
+	mutedPkts    uint64
+	signer       crypto.Signer    // signs our metadata frames, nil to send unsigned
+	remoteKey    crypto.PublicKey // key pinned by the peer's signed hello
+	fingerprint  string
+	remotePads   bool // the peer accepts padded packets
+	remoteTraces bool // the peer accepts traced packets
+	protocol     int  // negotiated in the hellos, 0 until the peer's arrives
+	remoteFlate  bool // the peer accepts compressed packets
+	remoteCost   int  // the cost the peer's config gives the link
+	mu           sync.RWMutex
+
+	// Set by Configure from the peer's entry in the config.
+	name        string
+	cost        int
+	compress    bool
+	limit       *rateLimit    // guarded by sendMu
+	filters     *rules.Engine // nil if the link has none
+	rateDrops   uint64
+	filterDrops uint64
+	deflated    uint64 // bytes compression saved
+
+	// Send queue, written by a WriterPool.
+	sendMu        sync.Mutex
+	sendQueue     [][]byte
+	traceQueue    [][]byte // traced payloads, see SendTraced
+	ctrlQueue     []Control
+	queued        int // payload bytes in sendQueue
+	queueDrops    uint64
+	partialFrames uint64 // cut short by a failed write or a stream ending inside one
+	padMode       string
+	padBytes      uint64 // sent to hide packet sizes, length prefixes included
+	pool          *WriterPool
+	active        bool // handshake done, writers may send
+	quiet         bool // accepted link whose remote sent no control frame yet
+	scheduled     bool // waiting for or held by a writer
+	closed        bool
+	ping          *time.Timer
 }
 
 func NewPeer(id string, conn net.Conn, networkKey string) *Peer {
 	return &Peer{
-		ID:          id,
-		Conn:        conn,
-		ConnectedAt: time.Now(),
-		SendChan:    make(chan []byte, 1000),
-		lastSeen:    time.Now(),
-		networkKey:  networkKey,
+		ID:           id,
+		Conn:         conn,
+		ConnectedAt:  time.Now(),
+		lastSeen:     time.Now(),
+		networkKey:   networkKey,
+		observedNets: make(map[uint32]time.Time),
 	}
 }
 
-func (p *Peer) Run(ctx context.Context, relayChan chan<- []byte, onDisconnect func(string)) {
-	defer func() {
-		if err := p.Conn.Close(); err != nil && err != net.ErrClosed {
-			logger.Error("Error closing peer %s connection: %v", p.ID, err)
+// Networks returns the IPX networks the peer advertised as local and the
+// source networks observed on its link within the last maxAge.
+func (p *Peer) Networks(maxAge time.Duration) (advertised, observed []uint32) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	advertised = append([]uint32(nil), p.advertised...)
+	for n, seen := range p.observedNets {
+		if time.Since(seen) <= maxAge {
+			observed = append(observed, n)
 		}
-	}()
-	defer onDisconnect(p.ID)
+	}
+	return advertised, observed
+}
+
+// Declared returns the IPX networks the peer declared reachable through
+// its link, and false if it does not declare them.
+func (p *Peer) Declared() ([]uint32, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.declared, p.declares
+}
+
+// maxObservedNets bounds the networks remembered per link, so a peer
+// sending from random source networks cannot grow it without limit.
+const maxObservedNets = 256
+
+// observeNetwork must be called with p.mu held. When the table is full the
+// network seen longest ago makes room.
+func (p *Peer) observeNetwork(n uint32, now time.Time) {
+	if _, ok := p.observedNets[n]; !ok && len(p.observedNets) >= maxObservedNets {
+		var oldest uint32
+		var oldestSeen time.Time
+		for k, seen := range p.observedNets {
+			if oldestSeen.IsZero() || seen.Before(oldestSeen) {
+				oldest, oldestSeen = k, seen
+			}
+		}
+		delete(p.observedNets, oldest)
+	}
+	p.observedNets[n] = now
+}
+
+// ErrKeyMismatch is the handshake error of a remote with another network
+// key.
+var ErrKeyMismatch = errors.New("network key mismatch")
 
-	// Authentication Handshake
+// authenticate exchanges network keys with the remote, failing if they
+// differ.
+func (p *Peer) authenticate() error {
 	if p.networkKey != "" {
 		// Send our network key
 		keyLen := uint32(len(p.networkKey))
 		if err := binary.Write(p.Conn, binary.BigEndian, keyLen); err != nil {
-			logger.Error("Peer %s: failed to send key length: %v", p.ID, err)
-			return
+			return fmt.Errorf("failed to send key length: %v", err)
 		}
 		if _, err := p.Conn.Write([]byte(p.networkKey)); err != nil {
-			logger.Error("Peer %s: failed to send network key: %v", p.ID, err)
-			return
+			return fmt.Errorf("failed to send network key: %v", err)
 		}
 
 		// Receive their network key
 		var remoteKeyLen uint32
 		if err := binary.Read(p.Conn, binary.BigEndian, &remoteKeyLen); err != nil {
-			logger.Error("Peer %s: failed to read remote key length: %v", p.ID, err)
-			return
+			return fmt.Errorf("failed to read remote key length: %v", err)
 		}
 		if remoteKeyLen > 256 {
-			logger.Error("Peer %s: remote network key too long (%d)", p.ID, remoteKeyLen)
-			return
+			return fmt.Errorf("remote network key too long (%d)", remoteKeyLen)
 		}
 		remoteKey := make([]byte, remoteKeyLen)
 		if _, err := io.ReadFull(p.Conn, remoteKey); err != nil {
-			logger.Error("Peer %s: failed to read remote network key: %v", p.ID, err)
-			return
+			return fmt.Errorf("failed to read remote network key: %v", err)
 		}
 
 		if string(remoteKey) != p.networkKey {
-			logger.Error("Peer %s: network key mismatch!", p.ID)
-			return
+			return ErrKeyMismatch
 		}
-		logger.Info("Peer %s: authenticated successfully", p.ID)
-	} else {
-		// Even if no key is required locally, we must check if the remote expects one
-		// Wait for a short time to see if they send a key length
-		p.Conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
-		var remoteKeyLen uint32
-		err := binary.Read(p.Conn, binary.BigEndian, &remoteKeyLen)
-		p.Conn.SetReadDeadline(time.Time{}) // Clear deadline
+		logger.Peer.Info("Peer %s: authenticated successfully", p.ID)
+		return nil
+	}
+	// Send an empty key, so that a remote waiting for one does not take
+	// our first frame for its length, then take the remote's if it sends
+	// one. Peers without a key accept anyone.
+	if err := binary.Write(p.Conn, binary.BigEndian, uint32(0)); err != nil {
+		return fmt.Errorf("failed to send key length: %v", err)
+	}
+	p.Conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	var remoteKeyLen uint32
+	err := binary.Read(p.Conn, binary.BigEndian, &remoteKeyLen)
+	p.Conn.SetReadDeadline(time.Time{}) // Clear deadline
 
-		if err == nil {
-			// They sent a key, but we don't have one.
-			// Just read it and proceed if we want to be permissive as requested
-			// "If there is no network key present, allow anyone to connect"
-			if remoteKeyLen <= 256 {
-				remoteKey := make([]byte, remoteKeyLen)
-				io.ReadFull(p.Conn, remoteKey)
-			}
-			// Send empty key back if they are waiting for one?
-			// Actually, if we are permissive, we should just continue.
-			// But the remote might be expecting a key.
-			// Let's send an empty key back to satisfy the handshake if they sent one.
-			binary.Write(p.Conn, binary.BigEndian, uint32(0))
+	if err == nil && remoteKeyLen <= 256 {
+		remoteKey := make([]byte, remoteKeyLen)
+		io.ReadFull(p.Conn, remoteKey)
+	}
+	return nil
+}
+
+func (p *Peer) Run(ctx context.Context, relayChan chan<- []byte, onDisconnect func(string)) {
+	defer func() {
+		if err := p.Conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			logger.Peer.Error("Error closing peer %s connection: %v", p.ID, err)
 		}
+	}()
+	defer onDisconnect(p.ID)
+	defer p.shutdown()
+	stop := context.AfterFunc(ctx, func() { p.Conn.Close() })
+	defer stop()
+
+	err := p.authenticate()
+	p.mu.RLock()
+	onHandshake := p.onHandshake
+	p.mu.RUnlock()
+	if onHandshake != nil {
+		onHandshake(p, err)
+	}
+	if err != nil {
+		logger.Peer.Error("Peer %s: %v", p.ID, err)
+		return
 	}
 
 	// Fetch GeoIP and Whois in background
-	go p.lookupInfo()
-
-	wg := sync.WaitGroup{}
-	wg.Add(2)
-
-	// Receiver goroutine
-	go func() {
-		defer wg.Done()
-		for {
-			// Length-prefixed framing (4 bytes length)
-			var length uint32
-			err := binary.Read(p.Conn, binary.BigEndian, &length)
-			if err != nil {
-				if err != io.EOF {
-					logger.Error("Peer %s recv error: %v", p.ID, err)
-					atomic.AddUint64(&p.errors, 1)
-				}
-				return
+	if !p.NoLookup {
+		go p.lookupInfo()
+	}
+
+	// Writers from the pool send what is queued and pings are queued by a
+	// timer, so an idle link costs this goroutine only.
+	p.activate()
+
+	fr := newFrameReader(p.Conn)
+	for {
+		// Length-prefixed framing (4 bytes length)
+		length, err := fr.header()
+		if err != nil {
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				logger.Peer.Error("Peer %s recv error: %v", p.ID, err)
+				atomic.AddUint64(&p.errors, 1)
+			}
+			if err == io.ErrUnexpectedEOF {
+				p.cutFrame(err)
 			}
+			return
+		}
 
-			if length > 2000 { // Max IPX packet is around 576-1500
-				logger.Error("Peer %s sent too large packet: %d", p.ID, length)
+		if length&controlFlag != 0 {
+			length &^= controlFlag
+			if length > maxControlLen {
+				logger.Peer.Error("Peer %s sent too large control frame: %d", p.ID, length)
 				return
 			}
-
-			data := make([]byte, length)
-			_, err = io.ReadFull(p.Conn, data)
+			payload, err := fr.control(int(length))
 			if err != nil {
-				logger.Error("Peer %s recv data error: %v", p.ID, err)
+				logger.Peer.Error("Peer %s recv control error: %v", p.ID, err)
+				p.cutFrame(err)
 				return
 			}
+			p.handleControl(payload)
+			continue
+		}
 
-			atomic.AddUint64(&p.recvBytes, uint64(length))
-			atomic.AddUint64(&p.recvPkts, 1)
-			p.mu.Lock()
-			p.lastSeen = time.Now()
-			p.mu.Unlock()
+		padded := length&padFlag != 0
+		traced := length&traceFlag != 0
+		compressed := length&compressFlag != 0
+		length &^= padFlag | traceFlag | compressFlag
+		limit := uint32(maxPacketLen)
+		if traced {
+			limit += 2 + maxTraceLen
+		}
+		if length > limit {
+			logger.Peer.Error("Peer %s sent too large packet: %d", p.ID, length)
+			return
+		}
 
-			select {
-			case <-ctx.Done():
+		data, err := fr.packet(int(length))
+		if err != nil {
+			logger.Peer.Error("Peer %s recv data error: %v", p.ID, err)
+			p.cutFrame(err)
+			return
+		}
+		if padded {
+			if len(data) < 2 || int(binary.BigEndian.Uint16(data)) > len(data)-2 {
+				logger.Peer.Error("Peer %s sent a malformed padded packet", p.ID)
 				return
-			case relayChan <- data:
 			}
+			n := 2 + int(binary.BigEndian.Uint16(data))
+			data = data[2:n:n]
 		}
-	}()
-
-	// Sender goroutine
-	go func() {
-		defer wg.Done()
-		for {
-			select {
-			case <-ctx.Done():
+		if compressed {
+			if padded || traced {
+				logger.Peer.Error("Peer %s sent a compressed packet that is padded or traced", p.ID)
+				return
+			}
+			if data, err = inflate(data); err != nil {
+				logger.Peer.Error("Peer %s sent a malformed compressed packet: %v", p.ID, err)
+				return
+			}
+		}
+		var hops []stats.TraceHop
+		if traced {
+			if hops, data, err = splitTraced(data); err != nil {
+				logger.Peer.Error("Peer %s sent a malformed traced packet: %v", p.ID, err)
 				return
-			case data, ok := <-p.SendChan:
-				if !ok {
-					return
-				}
-
-				// Write length header
-				err := binary.Write(p.Conn, binary.BigEndian, uint32(len(data)))
-				if err != nil {
-					logger.Error("Peer %s send error: %v", p.ID, err)
-					return
-				}
-
-				// Write packet data
-				_, err = p.Conn.Write(data)
-				if err != nil {
-					logger.Error("Peer %s send data error: %v", p.ID, err)
-					return
-				}
-
-				atomic.AddUint64(&p.sentBytes, uint64(len(data)))
-				atomic.AddUint64(&p.sentPkts, 1)
 			}
 		}
-	}()
 
-	wg.Wait()
+		atomic.AddUint64(&p.recvBytes, uint64(len(data)))
+		atomic.AddUint64(&p.recvPkts, 1)
+		h, err := ipx.Parse(data)
+		if err == nil {
+			p.frames.AddRx(h.FrameType, len(data))
+		} else {
+			p.frames.AddRx(ipx.FrameUnknown, len(data))
+		}
+		p.mu.Lock()
+		p.lastSeen = time.Now()
+		if err == nil && h.Src.Network != 0 {
+			p.observeNetwork(h.Src.Network, p.lastSeen)
+		}
+		onFrame := p.onFrame
+		p.mu.Unlock()
+
+		if p.mutedIn.Load() {
+			atomic.AddUint64(&p.mutedPkts, 1)
+			continue
+		}
+		if !p.passes(data) {
+			continue
+		}
+		if onFrame != nil {
+			onFrame(p, data, hops)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case relayChan <- data:
+		}
+	}
+}
+
+// cutFrame counts a frame the stream ended inside of, unless the link was
+// closed on our side.
+func (p *Peer) cutFrame(err error) {
+	if !errors.Is(err, net.ErrClosed) {
+		atomic.AddUint64(&p.partialFrames, 1)
+	}
 }
 
 func (p *Peer) GetStats() stats.PeerStat {
+	queued, mem := p.memory()
+	padding := p.padding()
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 
@@ -216,25 +374,46 @@ func (p *Peer) GetStats() stats.PeerStat {
 	}
 
 	return stats.PeerStat{
-		ID:          p.ID,
-		IP:          ip,
-		ConnectedAt: p.ConnectedAt,
-		LastSeen:    p.lastSeen,
-		SentBytes:   atomic.LoadUint64(&p.sentBytes),
-		RecvBytes:   atomic.LoadUint64(&p.recvBytes),
-		SentPkts:    atomic.LoadUint64(&p.sentPkts),
-		RecvPkts:    atomic.LoadUint64(&p.recvPkts),
-		Errors:      atomic.LoadUint64(&p.errors),
-		Hostname:    p.hostname,
-		ParentID:    p.parentID,
-		NumChildren: p.numChildren,
-		MaxChildren: p.maxChildren,
-		Country:     p.country,
-		City:        p.city,
-		Lat:         p.lat,
-		Lon:         p.lon,
-		Whois:       p.whois,
-		LatencyMs:   p.latencyMs,
+		ID:             p.ID,
+		IP:             ip,
+		ConnectedAt:    p.ConnectedAt,
+		LastSeen:       p.lastSeen,
+		SentBytes:      atomic.LoadUint64(&p.sentBytes),
+		RecvBytes:      atomic.LoadUint64(&p.recvBytes),
+		SentPkts:       atomic.LoadUint64(&p.sentPkts),
+		RecvPkts:       atomic.LoadUint64(&p.recvPkts),
+		Errors:         atomic.LoadUint64(&p.errors),
+		Hostname:       p.hostname,
+		ParentID:       p.parentID,
+		NumChildren:    p.numChildren,
+		MaxChildren:    p.maxChildren,
+		Country:        p.country,
+		City:           p.city,
+		Lat:            p.lat,
+		Lon:            p.lon,
+		Whois:          p.whois,
+		LatencyMs:      p.latencyMs,
+		Cost:           p.costLocked(),
+		Metric:         Metric(p.costLocked(), p.latencyMs),
+		Inbound:        p.Inbound,
+		NodeID:         p.nodeID,
+		FrameTypes:     p.frames.Snapshot(),
+		MutedIn:        p.mutedIn.Load(),
+		MutedOut:       p.mutedOut.Load(),
+		MutedPkts:      atomic.LoadUint64(&p.mutedPkts),
+		KeyFingerprint: p.fingerprint,
+		QueuedBytes:    queued,
+		QueueDrops:     atomic.LoadUint64(&p.queueDrops),
+		PartialFrames:  atomic.LoadUint64(&p.partialFrames),
+		Padding:        padding,
+		PadBytes:       atomic.LoadUint64(&p.padBytes),
+		Name:           p.name,
+		RateDrops:      atomic.LoadUint64(&p.rateDrops),
+		FilterDrops:    atomic.LoadUint64(&p.filterDrops),
+		Deflated:       atomic.LoadUint64(&p.deflated),
+		Protocol:       p.protocol,
+		Features:       p.featuresLocked(),
+		MemBytes:       mem,
 	}
 }
 
@@ -242,6 +421,24 @@ func (p *Peer) UpdateDemoStats() {
 	p.UpdateDemoStatsWithSeed(time.Now().Unix())
 }
 
+// AddDemoTraffic counts rx packets received from and tx packets sent to
+// the peer, all frames of type ft and n bytes.
+func (p *Peer) AddDemoTraffic(ft ipx.FrameType, n, rx, tx int) {
+	atomic.AddUint64(&p.recvPkts, uint64(rx))
+	atomic.AddUint64(&p.recvBytes, uint64(rx*n))
+	atomic.AddUint64(&p.sentPkts, uint64(tx))
+	atomic.AddUint64(&p.sentBytes, uint64(tx*n))
+	for range rx {
+		p.frames.AddRx(ft, n)
+	}
+	for range tx {
+		p.frames.AddTx(ft, n)
+	}
+	p.mu.Lock()
+	p.lastSeen = time.Now()
+	p.mu.Unlock()
+}
+
 func (p *Peer) UpdateDemoStatsWithParent(seed int64, parentID string, numChildren, maxChildren int, latency float64) {
 	p.UpdateDemoStatsWithSeed(seed)
 	p.mu.Lock()
@@ -252,6 +449,19 @@ func (p *Peer) UpdateDemoStatsWithParent(seed int64, parentID string, numChildre
 	p.mu.Unlock()
 }
 
+// SetMute stops relaying traffic from (inbound) and/or to (outbound) the
+// peer while keeping the link, pings and control frames up.
+func (p *Peer) SetMute(inbound, outbound bool) {
+	p.mutedIn.Store(inbound)
+	p.mutedOut.Store(outbound)
+}
+
+func (p *Peer) SetParentID(id string) {
+	p.mu.Lock()
+	p.parentID = id
+	p.mu.Unlock()
+}
+
 func (p *Peer) UpdateChildCount(num, max int) {
 	p.mu.Lock()
 	p.numChildren = num
@@ -280,22 +490,50 @@ func (p *Peer) UpdateDemoStatsWithSeed(seed int64) {
 }
 
 func (p *Peer) lookupInfo() {
-	ip := ""
-	if addr, ok := p.Conn.RemoteAddr().(*net.TCPAddr); ok {
-		ip = addr.IP.String()
-	} else {
+	addr, ok := p.Conn.RemoteAddr().(*net.TCPAddr)
+	if !ok {
 		return
 	}
+	ip := addr.IP.String()
+
+	// Private and loopback addresses have no GeoIP record. Skipping them
+	// also keeps a hub with many LAN peers under the service's rate limit.
+	if addr.IP.IsGlobalUnicast() && !addr.IP.IsPrivate() {
+		p.lookupGeo(ip)
+	}
 
+	// Reverse DNS lookup
+	p.mu.RLock()
+	currentHostname := p.hostname
+	p.mu.RUnlock()
+
+	if currentHostname == "" {
+		names, err := net.LookupAddr(ip)
+		if err == nil && len(names) > 0 {
+			p.mu.Lock()
+			p.hostname = strings.TrimSuffix(names[0], ".")
+			p.mu.Unlock()
+		} else {
+			// Fallback to IP address if no hostname found and no demo hostname set
+			p.mu.Lock()
+			if p.hostname == "" {
+				p.hostname = ip
+			}
+			p.mu.Unlock()
+		}
+	}
+}
+
+func (p *Peer) lookupGeo(ip string) {
 	// Use ip-api.com for GeoIP (free for non-commercial, no API key needed)
 	resp, err := http.Get(fmt.Sprintf("http://ip-api.com/json/%s", ip))
 	if err != nil {
-		logger.Error("GeoIP lookup failed: %v", err)
+		logger.Peer.Error("GeoIP lookup failed: %v", err)
 		return
 	}
 	defer func() {
 		if err := resp.Body.Close(); err != nil {
-			logger.Error("Error closing GeoIP response body: %v", err)
+			logger.Peer.Error("Error closing GeoIP response body: %v", err)
 		}
 	}()
 
@@ -310,7 +548,7 @@ func (p *Peer) lookupInfo() {
 	}
 
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		logger.Error("Failed to decode GeoIP response: %v", err)
+		logger.Peer.Error("Failed to decode GeoIP response: %v", err)
 		return
 	}
 
@@ -323,25 +561,4 @@ func (p *Peer) lookupInfo() {
 		p.whois = fmt.Sprintf("Org: %s\nAS: %s", result.Org, result.AS)
 		p.mu.Unlock()
 	}
-
-	// Reverse DNS lookup
-	p.mu.RLock()
-	currentHostname := p.hostname
-	p.mu.RUnlock()
-
-	if currentHostname == "" {
-		names, err := net.LookupAddr(ip)
-		if err == nil && len(names) > 0 {
-			p.mu.Lock()
-			p.hostname = strings.TrimSuffix(names[0], ".")
-			p.mu.Unlock()
-		} else {
-			// Fallback to IP address if no hostname found and no demo hostname set
-			p.mu.Lock()
-			if p.hostname == "" {
-				p.hostname = ip
-			}
-			p.mu.Unlock()
-		}
-	}
 }
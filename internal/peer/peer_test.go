@@ -9,6 +9,7 @@ import (
 	"encoding/binary"
 	"io"
 	"net"
+	"slices"
 	"testing"
 	"time"
 )
@@ -117,3 +118,252 @@ func TestPeerHandshakeMismatch(t *testing.T) {
 		}
 	}
 }
+
+func TestPeerControlFrames(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	redirects := make(chan Control, 1)
+	accepted := make(chan *Peer, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		p := NewPeer("child", conn, "")
+		p.SetControlHandler(func(p *Peer, c Control) { redirects <- c })
+		accepted <- p
+		p.Run(ctx, make(chan []byte, 10), func(id string) {})
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent := NewPeer("parent", conn, "")
+	go parent.Run(ctx, make(chan []byte, 10), func(id string) {})
+
+	parent.SendControl(Control{Type: ControlStatus, ListenAddr: ":9999", NumChildren: 2, MaxChildren: 5, Cost: 40})
+	parent.SendControl(Control{Type: ControlRedirect, Addr: "10.0.0.1:8787"})
+
+	child := <-accepted
+	select {
+	case c := <-redirects:
+		if c.Addr != "10.0.0.1:8787" {
+			t.Errorf("Expected redirect to 10.0.0.1:8787, got %s", c.Addr)
+		}
+	case <-ctx.Done():
+		t.Fatal("Timed out waiting for redirect control frame")
+	}
+
+	st := child.GetStats()
+	if st.NumChildren != 2 || st.MaxChildren != 5 {
+		t.Errorf("Expected status 2/5 children, got %d/%d", st.NumChildren, st.MaxChildren)
+	}
+	if st.Cost != 40 || st.Metric < 40 {
+		t.Errorf("Expected the cost the dialer sent in the link's metric, got cost %d, metric %d", st.Cost, st.Metric)
+	}
+	if addr := child.RemoteListenAddr(); addr != "127.0.0.1:9999" {
+		t.Errorf("Expected remote listen addr 127.0.0.1:9999, got %s", addr)
+	}
+}
+
+// TestPeerQuietUntilHeard dials as a node from before control frames would,
+// which drops the link on the first one it reads.
+func TestPeerQuietUntilHeard(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	accepted := make(chan *Peer, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		p := NewPeer("child", conn, "")
+		p.Inbound = true
+		p.SendControl(Control{Type: ControlStatus, NumChildren: 1})
+		accepted <- p
+		p.Run(ctx, make(chan []byte, 10), func(id string) {})
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	binary.Write(conn, binary.BigEndian, uint32(0))
+	var keyLen uint32
+	if err := binary.Read(conn, binary.BigEndian, &keyLen); err != nil || keyLen != 0 {
+		t.Fatalf("Expected an empty key, got %d: %v", keyLen, err)
+	}
+	p := <-accepted
+
+	// next reads the length prefix of the next frame and skips its body.
+	next := func() (uint32, error) {
+		var length uint32
+		if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
+			return 0, err
+		}
+		_, err := io.CopyN(io.Discard, conn, int64(length&^controlFlag))
+		return length, err
+	}
+
+	// Packets flow, control frames do not, past the first ping's time.
+	for !p.Authenticated() {
+		time.Sleep(time.Millisecond)
+	}
+	p.Send(make([]byte, 64))
+	conn.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	for {
+		length, err := next()
+		if err != nil {
+			if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+				t.Fatalf("Expected the link to stay up, got %v", err)
+			}
+			break
+		}
+		if length&controlFlag != 0 {
+			t.Fatal("Expected no control frame before the remote sent one")
+		}
+	}
+
+	// Once the remote pings, the held frames and the pong follow.
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	ping := []byte(`{"type":"ping","ts":1}`)
+	binary.Write(conn, binary.BigEndian, uint32(len(ping))|controlFlag)
+	conn.Write(ping)
+	controls := 0
+	for controls < 2 {
+		length, err := next()
+		if err != nil {
+			t.Fatalf("Expected the held control frames, got %v", err)
+		}
+		if length&controlFlag != 0 {
+			controls++
+		}
+	}
+}
+
+func TestPeerMute(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	relayed := make(chan []byte, 10)
+	accepted := make(chan *Peer, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		p := NewPeer("child", conn, "")
+		p.SetMute(true, false)
+		accepted <- p
+		p.Run(ctx, relayed, func(id string) {})
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent := NewPeer("parent", conn, "")
+	go parent.Run(ctx, make(chan []byte, 10), func(id string) {})
+
+	child := <-accepted
+	parent.Send([]byte("muted"))
+
+	deadline := time.After(2 * time.Second)
+	for child.GetStats().MutedPkts == 0 {
+		select {
+		case <-deadline:
+			t.Fatal("Timed out waiting for muted frame")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if len(relayed) != 0 {
+		t.Errorf("Expected muted frame not to be relayed, got %d frames", len(relayed))
+	}
+
+	child.SetMute(false, false)
+	parent.Send([]byte("unmuted"))
+	select {
+	case data := <-relayed:
+		if string(data) != "unmuted" {
+			t.Errorf("Expected 'unmuted', got '%s'", data)
+		}
+	case <-deadline:
+		t.Fatal("Timed out waiting for frame after unmute")
+	}
+}
+
+func TestPeerNegotiated(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	hellos := make(chan *Peer, 2)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		p := NewPeer("child", conn, "")
+		p.SetControlHandler(func(p *Peer, c Control) { hellos <- p })
+		p.Run(ctx, make(chan []byte, 10), func(id string) {})
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent := NewPeer("parent", conn, "")
+	go parent.Run(ctx, make(chan []byte, 10), func(id string) {})
+
+	wait := func() *Peer {
+		select {
+		case p := <-hellos:
+			return p
+		case <-ctx.Done():
+			t.Fatal("Timed out waiting for the hello")
+			return nil
+		}
+	}
+
+	// A node from before versioned hellos speaks version 1.
+	parent.SendControl(Control{Type: ControlHello, NodeID: "old", Padding: true})
+	child := wait()
+	if protocol, features := child.Negotiated(); protocol != 1 || !slices.Equal(features, []string{FeaturePadding}) {
+		t.Errorf("Expected protocol 1 with padding, got %d and %v", protocol, features)
+	}
+
+	// A newer node settles on the version this one speaks.
+	parent.SendControl(Control{Type: ControlHello, NodeID: "new", Protocol: ProtocolVersion + 1, Traces: true, Declares: true, Deflate: true})
+	wait()
+	st := child.GetStats()
+	if st.Protocol != ProtocolVersion || !slices.Equal(st.Features, []string{FeatureTraces, FeatureDeclares, FeatureCompression}) {
+		t.Errorf("Expected protocol %d with traces, declares and compression, got %d and %v", ProtocolVersion, st.Protocol, st.Features)
+	}
+}
@@ -0,0 +1,119 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Settings of one link: name, cost, compression, rate limit and filters
+
+package peer
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/rules"
+)
+
+// Settings are what a node's config sets for its link to one peer.
+type Settings struct {
+	Name        string       // shown for the peer
+	Cost        int          // administrative cost, added to the latency in the link's metric
+	Compression bool         // deflate packets sent if the peer accepts them
+	RateLimit   int          // bytes per second sent, 0 for no limit
+	Filters     []rules.Rule // filter rules for packets both ways
+}
+
+// Configure applies the settings of the link. It must be called before
+// Run.
+func (p *Peer) Configure(s Settings) {
+	p.name = s.Name
+	p.cost = s.Cost
+	p.compress = s.Compression
+	p.limit = nil
+	if s.RateLimit > 0 {
+		p.limit = newRateLimit(s.RateLimit, time.Now())
+	}
+	p.filters = nil
+	if len(s.Filters) > 0 {
+		p.filters = rules.NewEngine(s.Filters, nil)
+	}
+}
+
+// Metric is the metric of a link with an administrative cost and a
+// latency: their sum, the latency in milliseconds rounded up. The lower
+// the better.
+func Metric(cost int, latencyMs float64) int {
+	return cost + int(math.Ceil(latencyMs))
+}
+
+// Cost is the administrative cost of the link: the one this node's config
+// gives it, or else the one the peer's does, which the peer sends in its
+// status.
+func (p *Peer) Cost() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.costLocked()
+}
+
+// LinkMetric is the metric of the link, see Metric.
+func (p *Peer) LinkMetric() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return Metric(p.costLocked(), p.latencyMs)
+}
+
+// ConfiguredCost is the cost this node's config gives the link, 0 if none.
+func (p *Peer) ConfiguredCost() int {
+	return p.cost
+}
+
+// costLocked must be called with p.mu held.
+func (p *Peer) costLocked() int {
+	if p.cost > 0 {
+		return p.cost
+	}
+	return p.remoteCost
+}
+
+// passes applies the link's filters to a packet, counting it if they drop
+// it.
+func (p *Peer) passes(data []byte) bool {
+	if p.filters == nil {
+		return true
+	}
+	if allow, _ := p.filters.Classify(data); !allow {
+		atomic.AddUint64(&p.filterDrops, 1)
+		return false
+	}
+	return true
+}
+
+// compressingLocked reports whether packets to the peer are deflated: the
+// settings ask for it, the peer accepts it and the link is not padded.
+// Must be called with p.mu held.
+func (p *Peer) compressingLocked() bool {
+	return p.compress && p.remoteFlate && (!p.remotePads || p.padMode == "" || p.padMode == PadOff)
+}
+
+// rateLimit is a token bucket of bytes. It holds a second's worth, and at
+// least one packet of the largest size so a low limit still passes them.
+type rateLimit struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimit(bytesPerSec int, now time.Time) *rateLimit {
+	burst := float64(max(bytesPerSec, maxPacketLen))
+	return &rateLimit{rate: float64(bytesPerSec), burst: burst, tokens: burst, last: now}
+}
+
+// take spends n bytes if the bucket holds them.
+func (l *rateLimit) take(n int, now time.Time) bool {
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	if l.tokens < float64(n) {
+		return false
+	}
+	l.tokens -= float64(n)
+	return true
+}
@@ -0,0 +1,128 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for per-link settings: cost, compression, rate limit and filters
+
+package peer
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/rules"
+)
+
+// socketFrame is an Ethernet II IPX frame to dstSock, zeros past the
+// header to size bytes.
+func socketFrame(dstSock uint16, size int) []byte {
+	frame := make([]byte, size)
+	binary.BigEndian.PutUint16(frame[12:14], 0x8137)
+	binary.BigEndian.PutUint16(frame[30:32], dstSock)
+	return frame
+}
+
+func TestPeerCompression(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	parent, relayed := linkPair(t, ctx)
+	parent.Configure(Settings{Compression: true})
+	parent.mu.Lock()
+	parent.remoteFlate = true // as if its hello said so
+	parent.mu.Unlock()
+
+	// A packet that deflates is sent compressed, one that would not as it
+	// is; both arrive as they were sent.
+	zeros := socketFrame(0x4000, 1400)
+	noise := make([]byte, 64)
+	for i := range noise {
+		noise[i] = byte(i * 151)
+	}
+	for _, data := range [][]byte{zeros, noise} {
+		parent.Send(data)
+		select {
+		case got := <-relayed:
+			if !bytes.Equal(got, data) {
+				t.Errorf("Expected a %d byte packet back, got %d bytes", len(data), len(got))
+			}
+		case <-ctx.Done():
+			t.Fatal("Timed out waiting for compressed packets")
+		}
+	}
+	for parent.GetStats().SentPkts != 2 && ctx.Err() == nil {
+		time.Sleep(time.Millisecond) // counted once the write returns
+	}
+	st := parent.GetStats()
+	if st.Deflated == 0 || st.SentBytes != uint64(len(zeros)+len(noise)) {
+		t.Errorf("Expected bytes saved and the packets counted whole, got %d saved of %d sent", st.Deflated, st.SentBytes)
+	}
+}
+
+func TestInflateBounded(t *testing.T) {
+	packed := deflate(make([]byte, 64*1024))
+	if packed == nil {
+		t.Fatal("Expected zeros to deflate")
+	}
+	if _, err := inflate(packed); err == nil {
+		t.Error("Expected a packet inflating past the largest refused")
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	now := time.Now()
+	l := newRateLimit(1000, now)
+	// A second's worth, or a full packet if that is more, passes at once.
+	if !l.take(1500, now) || l.take(1000, now) {
+		t.Error("Expected a burst of one packet of the largest size")
+	}
+	if !l.take(500, now.Add(time.Second)) {
+		t.Error("Expected the bucket to refill at the rate")
+	}
+}
+
+func TestPeerSettingsDrop(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	parent, relayed := linkPair(t, ctx)
+	parent.Configure(Settings{
+		Name:      "Upstairs",
+		RateLimit: 2000,
+		Filters:   []rules.Rule{{Socket: 0x869c, Direction: "dst", Action: "drop"}},
+	})
+
+	if parent.Send(socketFrame(0x869c, 100)) {
+		t.Error("Expected a packet the filters drop refused")
+	}
+	if !parent.Send(socketFrame(0x4000, 1500)) {
+		t.Error("Expected a packet within the rate limit queued")
+	}
+	if parent.Send(socketFrame(0x4000, 1500)) {
+		t.Error("Expected a packet over the rate limit dropped")
+	}
+	select {
+	case <-relayed:
+	case <-ctx.Done():
+		t.Fatal("Timed out waiting for the packet")
+	}
+	st := parent.GetStats()
+	if st.Name != "Upstairs" || st.FilterDrops != 1 || st.RateDrops != 1 {
+		t.Errorf("Expected the name and one drop of each, got %q, %d and %d", st.Name, st.FilterDrops, st.RateDrops)
+	}
+}
+
+func TestLinkCost(t *testing.T) {
+	if m := Metric(100, 12.3); m != 113 {
+		t.Errorf("Expected the cost plus the latency rounded up, got %d", m)
+	}
+	p := NewPeer("p", nil, "")
+	p.remoteCost = 40
+	if p.Cost() != 40 {
+		t.Errorf("Expected the cost the peer sent, got %d", p.Cost())
+	}
+	p.Configure(Settings{Cost: 500})
+	p.latencyMs = 20
+	if p.Cost() != 500 || p.LinkMetric() != 520 || p.ConfiguredCost() != 500 {
+		t.Errorf("Expected our config's cost to win, got cost %d, metric %d", p.Cost(), p.LinkMetric())
+	}
+}
@@ -0,0 +1,210 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Signing of control-plane metadata with the node's TLS key
+
+package peer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+)
+
+// signedTypes are the control frames carrying metadata other nodes act on.
+var signedTypes = map[string]bool{
+	ControlHello:    true,
+	ControlStatus:   true,
+	ControlTopology: true,
+	ControlGossip:   true,
+}
+
+// The signature travels as the last member of the JSON object, so nodes
+// that do not check it simply ignore the field, and the signed bytes are
+// exactly what the sender marshalled.
+var sigField = []byte(`,"sig":"`)
+
+const bindingLabel = "EXPORTER-ipxtransporter-control"
+
+// SetSigner makes the peer sign outgoing metadata frames with key and send
+// its public key in the hello frame. Call it before Run.
+func (p *Peer) SetSigner(key crypto.Signer) {
+	p.mu.Lock()
+	p.signer = key
+	p.mu.Unlock()
+}
+
+// KeyFingerprint returns the SHA-256 fingerprint of the key the peer proved
+// it holds in its hello frame, or "" if it did not sign its metadata.
+func (p *Peer) KeyFingerprint() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.fingerprint
+}
+
+// Fingerprint returns the hex SHA-256 of a public key in PKIX form.
+func Fingerprint(pub crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// marshalControl encodes a control frame, signing metadata frames when the
+// peer has a signer.
+func (p *Peer) marshalControl(c Control) ([]byte, error) {
+	p.mu.RLock()
+	signer := p.signer
+	p.mu.RUnlock()
+	if signer == nil || !signedTypes[c.Type] {
+		return json.Marshal(c)
+	}
+	if c.Type == ControlHello {
+		der, err := x509.MarshalPKIXPublicKey(signer.Public())
+		if err != nil {
+			return nil, err
+		}
+		c.PublicKey = der
+	}
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := sign(signer, p.binding(), payload)
+	if err != nil {
+		return nil, err
+	}
+	out := append(payload[:len(payload)-1:len(payload)-1], sigField...)
+	out = base64.StdEncoding.AppendEncode(out, sig)
+	return append(out, '"', '}'), nil
+}
+
+// verifyControl checks the signature of a metadata frame. A hello frame
+// with a public key must be signed by it and pins the key for the rest of
+// the link; after that every metadata frame must carry a valid signature.
+// Links whose hello had no key are accepted unsigned.
+func (p *Peer) verifyControl(c Control, payload []byte) bool {
+	if !signedTypes[c.Type] {
+		return true
+	}
+	p.mu.RLock()
+	pinned := p.remoteKey
+	p.mu.RUnlock()
+
+	signed, sig, hasSig := splitSig(payload)
+	if c.Type == ControlHello && len(c.PublicKey) > 0 {
+		pub, err := x509.ParsePKIXPublicKey(c.PublicKey)
+		if err == nil && hasSig {
+			err = verify(pub, p.binding(), signed, sig)
+		} else if err == nil {
+			err = errors.New("missing signature")
+		}
+		if err != nil {
+			logger.Peer.Error("Peer %s: rejecting hello with bad signature: %v", p.ID, err)
+			return false
+		}
+		fp, err := Fingerprint(pub)
+		if err != nil {
+			return false
+		}
+		if pinned != nil && fp != p.KeyFingerprint() {
+			logger.Peer.Error("Peer %s: rejecting hello with a different key", p.ID)
+			return false
+		}
+		p.mu.Lock()
+		p.remoteKey, p.fingerprint = pub, fp
+		p.mu.Unlock()
+		return true
+	}
+	if pinned == nil {
+		return true
+	}
+	if !hasSig {
+		logger.Peer.Error("Peer %s: dropping unsigned %s frame", p.ID, c.Type)
+		return false
+	}
+	if err := verify(pinned, p.binding(), signed, sig); err != nil {
+		logger.Peer.Error("Peer %s: dropping %s frame with bad signature: %v", p.ID, c.Type, err)
+		return false
+	}
+	return true
+}
+
+// binding ties signatures to this TLS session so that a signed frame
+// cannot be replayed on another link. Plain TCP links have none.
+func (p *Peer) binding() []byte {
+	tc, ok := p.Conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	state := tc.ConnectionState()
+	b, err := state.ExportKeyingMaterial(bindingLabel, nil, 32)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+// splitSig separates the signature member from a signed payload and
+// returns the payload as it was before signing.
+func splitSig(payload []byte) (signed, sig []byte, ok bool) {
+	if !bytes.HasSuffix(payload, []byte(`"}`)) {
+		return nil, nil, false
+	}
+	i := bytes.LastIndex(payload, sigField)
+	if i < 0 {
+		return nil, nil, false
+	}
+	sig, err := base64.StdEncoding.AppendDecode(nil, payload[i+len(sigField):len(payload)-2])
+	if err != nil {
+		return nil, nil, false
+	}
+	signed = append(payload[:i:i], '}')
+	return signed, sig, true
+}
+
+func digest(binding, payload []byte) []byte {
+	h := sha256.New()
+	h.Write(binding)
+	h.Write(payload)
+	return h.Sum(nil)
+}
+
+func sign(key crypto.Signer, binding, payload []byte) ([]byte, error) {
+	if _, ok := key.Public().(ed25519.PublicKey); ok {
+		return key.Sign(rand.Reader, append(binding[:len(binding):len(binding)], payload...), crypto.Hash(0))
+	}
+	return key.Sign(rand.Reader, digest(binding, payload), crypto.SHA256)
+}
+
+func verify(pub crypto.PublicKey, binding, payload, sig []byte) error {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, digest(binding, payload), sig)
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(k, digest(binding, payload), sig) {
+			return errors.New("invalid ECDSA signature")
+		}
+		return nil
+	case ed25519.PublicKey:
+		if !ed25519.Verify(k, append(binding[:len(binding):len(binding)], payload...), sig) {
+			return errors.New("invalid Ed25519 signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported key type %T", pub)
+}
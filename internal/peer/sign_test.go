@@ -0,0 +1,98 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for control metadata signing
+
+package peer
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net"
+	"testing"
+)
+
+func decode(t *testing.T, payload []byte) Control {
+	t.Helper()
+	var c Control
+	if err := json.Unmarshal(payload, &c); err != nil {
+		t.Fatalf("Expected valid JSON, got %v: %s", err, payload)
+	}
+	return c
+}
+
+func TestSignedControl(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	sender := NewPeer("sender", a, "")
+	sender.SetSigner(key)
+	receiver := NewPeer("receiver", b, "")
+
+	hello, err := sender.marshalControl(Control{Type: ControlHello, NodeID: "n1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !receiver.verifyControl(decode(t, hello), hello) {
+		t.Fatal("Expected signed hello to verify")
+	}
+	want, _ := Fingerprint(key.Public())
+	if fp := receiver.KeyFingerprint(); fp != want {
+		t.Errorf("Expected pinned fingerprint %s, got %s", want, fp)
+	}
+
+	status, err := sender.marshalControl(Control{Type: ControlStatus, NumChildren: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !receiver.verifyControl(decode(t, status), status) {
+		t.Error("Expected signed status to verify")
+	}
+	tampered := bytes.Replace(status, []byte(`"num_children":5`), []byte(`"num_children":6`), 1)
+	if receiver.verifyControl(decode(t, tampered), tampered) {
+		t.Error("Expected tampered status to be rejected")
+	}
+	unsigned, _ := json.Marshal(Control{Type: ControlStatus, NumChildren: 5})
+	if receiver.verifyControl(decode(t, unsigned), unsigned) {
+		t.Error("Expected unsigned status to be rejected once a key is pinned")
+	}
+	ping, _ := json.Marshal(Control{Type: ControlPing, Timestamp: 1})
+	if !receiver.verifyControl(decode(t, ping), ping) {
+		t.Error("Expected unsigned ping to be accepted")
+	}
+
+	_, other, _ := ed25519.GenerateKey(rand.Reader)
+	impostor := NewPeer("impostor", a, "")
+	impostor.SetSigner(other)
+	hello, err = impostor.marshalControl(Control{Type: ControlHello, NodeID: "n1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if receiver.verifyControl(decode(t, hello), hello) {
+		t.Error("Expected hello with a different key to be rejected")
+	}
+}
+
+func TestUnsignedControl(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	receiver := NewPeer("receiver", b, "")
+
+	for _, c := range []Control{{Type: ControlHello, NodeID: "n1"}, {Type: ControlStatus, NumChildren: 1}} {
+		payload, _ := json.Marshal(c)
+		if !receiver.verifyControl(c, payload) {
+			t.Errorf("Expected unsigned %s to be accepted from a link without a key", c.Type)
+		}
+	}
+	if fp := receiver.KeyFingerprint(); fp != "" {
+		t.Errorf("Expected no fingerprint, got %s", fp)
+	}
+}
@@ -0,0 +1,89 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Relay traces: the nodes a debugged packet passed, carried with it
+
+package peer
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+// traceFlag marks a relayed packet carrying a relay trace: the payload is
+// the trace's length in two bytes, the trace and the packet. It is only
+// sent to peers whose hello says they accept it.
+//
+// A trace is a hop count byte, then per hop the node ID, as one length
+// byte and that many bytes, and the unix nanoseconds at which the node
+// sent the packet on, eight bytes big-endian. The origin comes first.
+const traceFlag = uint32(1) << 29
+
+const (
+	// MaxTraceHops caps the hops a trace records; nodes further along
+	// pass it on as it is.
+	MaxTraceHops = 8
+	maxTraceNode = 32
+	maxTraceLen  = 1 + MaxTraceHops*(1+maxTraceNode+8)
+)
+
+func appendTrace(b []byte, hops []stats.TraceHop) []byte {
+	hops = hops[:min(len(hops), MaxTraceHops)]
+	b = append(b, byte(len(hops)))
+	for _, h := range hops {
+		node := h.Node[:min(len(h.Node), maxTraceNode)]
+		b = append(b, byte(len(node)))
+		b = append(b, node...)
+		b = binary.BigEndian.AppendUint64(b, uint64(h.At.UnixNano()))
+	}
+	return b
+}
+
+func parseTrace(b []byte) ([]stats.TraceHop, error) {
+	if len(b) < 1 || int(b[0]) > MaxTraceHops {
+		return nil, errors.New("bad hop count")
+	}
+	hops := make([]stats.TraceHop, 0, b[0])
+	rest := b[1:]
+	for range int(b[0]) {
+		if len(rest) < 1 || len(rest) < 1+int(rest[0])+8 {
+			return nil, errors.New("truncated hop")
+		}
+		n := int(rest[0])
+		at := int64(binary.BigEndian.Uint64(rest[1+n:]))
+		hops = append(hops, stats.TraceHop{Node: string(rest[1 : 1+n]), At: time.Unix(0, at)})
+		rest = rest[1+n+8:]
+	}
+	return hops, nil
+}
+
+// splitTraced separates a traced payload into the trace and the packet.
+func splitTraced(payload []byte) ([]stats.TraceHop, []byte, error) {
+	if len(payload) < 2 {
+		return nil, nil, errors.New("short traced packet")
+	}
+	n := 2 + int(binary.BigEndian.Uint16(payload))
+	if n > len(payload) {
+		return nil, nil, errors.New("trace longer than the packet")
+	}
+	hops, err := parseTrace(payload[2:n])
+	return hops, payload[n:len(payload):len(payload)], err
+}
+
+// SendTraced queues a packet carrying the relay trace hops. Peers that do
+// not accept traces get the packet alone, as Send would queue it.
+func (p *Peer) SendTraced(data []byte, hops []stats.TraceHop) bool {
+	p.mu.RLock()
+	accepts := p.remoteTraces
+	p.mu.RUnlock()
+	if !accepts || len(hops) == 0 {
+		return p.Send(data)
+	}
+	trace := appendTrace(nil, hops)
+	payload := make([]byte, 0, 2+len(trace)+len(data))
+	payload = binary.BigEndian.AppendUint16(payload, uint16(len(trace)))
+	payload = append(append(payload, trace...), data...)
+	return p.enqueue(payload, true)
+}
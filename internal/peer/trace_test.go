@@ -0,0 +1,68 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for relay traces
+
+package peer
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+func TestTraceRoundTrip(t *testing.T) {
+	at := time.Unix(1700000000, 123456789)
+	hops := []stats.TraceHop{{Node: "aaaa", At: at}, {Node: "bbbb", At: at.Add(time.Millisecond)}}
+	got, err := parseTrace(appendTrace(nil, hops))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Node != "aaaa" || !got[1].At.Equal(at.Add(time.Millisecond)) {
+		t.Errorf("Expected the hops back, got %+v", got)
+	}
+
+	long := make([]stats.TraceHop, MaxTraceHops+3)
+	if got, _ := parseTrace(appendTrace(nil, long)); len(got) != MaxTraceHops {
+		t.Errorf("Expected a trace capped at %d hops, got %d", MaxTraceHops, len(got))
+	}
+	if _, err := parseTrace([]byte{2, 4, 'a'}); err == nil {
+		t.Error("Expected a truncated trace to be refused")
+	}
+}
+
+func TestPeerSendTraced(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	parent, relayed := linkPair(t, ctx)
+	hops := []stats.TraceHop{{Node: "origin", At: time.Now()}}
+
+	// Without the peer's consent the packet goes plain; with it, the trace
+	// is stripped on arrival.
+	recv := func(want string) {
+		t.Helper()
+		select {
+		case data := <-relayed:
+			if !bytes.Equal(data, []byte(want)) {
+				t.Errorf("Expected %q, got %q", want, data)
+			}
+		case <-ctx.Done():
+			t.Fatal("Timed out waiting for traced packets")
+		}
+	}
+	parent.SendTraced([]byte("plain"), hops)
+	recv("plain")
+	parent.mu.Lock()
+	parent.remoteTraces = true // as if its hello said so
+	parent.mu.Unlock()
+	parent.SendTraced([]byte("traced"), hops)
+	recv("traced")
+	for parent.GetStats().SentBytes != 11 && ctx.Err() == nil {
+		time.Sleep(time.Millisecond) // counted once the write returns
+	}
+	if st := parent.GetStats(); st.SentBytes != 11 || st.SentPkts != 2 {
+		t.Errorf("Expected 2 packets of 11 bytes counted without the trace, got %d and %d", st.SentPkts, st.SentBytes)
+	}
+}
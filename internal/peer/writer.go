@@ -0,0 +1,373 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Send queues bounded in bytes and a writer pool shared by all links
+
+package peer
+
+import (
+	"errors"
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/ipx"
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+)
+
+const (
+	// DefaultSendBudget is the payload a link may have queued when its
+	// SendBudget is zero: about 170 full-size frames.
+	DefaultSendBudget = 256 * 1024
+	// writeTimeout bounds one batched write, so a stalled link cannot hold
+	// a writer that other links are waiting for. Such a link is closed.
+	writeTimeout = 10 * time.Second
+	// queueEntrySize approximates what a queued packet costs beyond its
+	// payload: its slice header.
+	queueEntrySize = 24
+)
+
+// WriterPool writes the send queues of many links with a fixed number of
+// goroutines. A link with data to send waits in a ready queue until a
+// writer takes it, writes one batch and puts it back if more is queued.
+type WriterPool struct {
+	mu    sync.Mutex
+	cond  *sync.Cond
+	ready []*Peer
+}
+
+// NewWriterPool starts workers writers, or a number derived from the CPU
+// count if workers is not positive.
+func NewWriterPool(workers int) *WriterPool {
+	if workers <= 0 {
+		workers = max(4, 2*runtime.GOMAXPROCS(0))
+	}
+	wp := &WriterPool{}
+	wp.cond = sync.NewCond(&wp.mu)
+	for range workers {
+		go wp.worker()
+	}
+	return wp
+}
+
+// SharedWriterPool returns the pool serving links that were not given one
+// of their own, starting it on first use.
+var SharedWriterPool = sync.OnceValue(func() *WriterPool { return NewWriterPool(0) })
+
+// Waiting returns the number of links waiting for a writer.
+func (wp *WriterPool) Waiting() int {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	return len(wp.ready)
+}
+
+func (wp *WriterPool) schedule(p *Peer) {
+	wp.mu.Lock()
+	wp.ready = append(wp.ready, p)
+	wp.mu.Unlock()
+	wp.cond.Signal()
+}
+
+func (wp *WriterPool) worker() {
+	fw := newFrameWriter(nil)
+	for {
+		wp.mu.Lock()
+		for len(wp.ready) == 0 {
+			wp.cond.Wait()
+		}
+		p := wp.ready[0]
+		wp.ready[0] = nil
+		wp.ready = wp.ready[1:]
+		if len(wp.ready) == 0 {
+			wp.ready = wp.ready[:0:0]
+		}
+		wp.mu.Unlock()
+		p.write(wp, fw)
+	}
+}
+
+// SetWriterPool makes the link send through wp instead of the shared pool.
+// It must be called before Run.
+func (p *Peer) SetWriterPool(wp *WriterPool) {
+	p.sendMu.Lock()
+	p.pool = wp
+	p.sendMu.Unlock()
+}
+
+// SetPadding pads the packets sent to the peer as mode (PadBuckets or
+// PadFixed) says, once its hello shows it accepts padded packets. It must
+// be called before Run.
+func (p *Peer) SetPadding(mode string) {
+	p.padMode = mode
+}
+
+// padding returns the padding mode in effect on the link, "" if none.
+func (p *Peer) padding() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if !p.remotePads || p.padMode == PadOff {
+		return ""
+	}
+	return p.padMode
+}
+
+// Send queues a packet for the peer. It reports false if the packet was
+// dropped because sending is muted, the link is closed or the queue would
+// exceed the link's SendBudget.
+func (p *Peer) Send(data []byte) bool {
+	return p.enqueue(data, false)
+}
+
+// enqueue queues a packet, or a traced payload on the trace queue.
+func (p *Peer) enqueue(data []byte, traced bool) bool {
+	if p.mutedOut.Load() {
+		atomic.AddUint64(&p.mutedPkts, 1)
+		return false
+	}
+	if !p.passes(data) {
+		return false
+	}
+	budget := p.SendBudget
+	if budget <= 0 {
+		budget = DefaultSendBudget
+	}
+	p.sendMu.Lock()
+	defer p.sendMu.Unlock()
+	if p.closed || p.queued+len(data) > budget {
+		atomic.AddUint64(&p.queueDrops, 1)
+		return false
+	}
+	if p.limit != nil && !p.limit.take(len(data), time.Now()) {
+		atomic.AddUint64(&p.rateDrops, 1)
+		return false
+	}
+	if traced {
+		p.traceQueue = append(p.traceQueue, data)
+	} else {
+		p.sendQueue = append(p.sendQueue, data)
+	}
+	p.queued += len(data)
+	p.wakeLocked()
+	return true
+}
+
+// wakeLocked hands the link to a writer unless one already has it or the
+// handshake is not done. It must be called with p.sendMu held.
+func (p *Peer) wakeLocked() {
+	if p.active && !p.scheduled {
+		p.scheduled = true
+		p.pool.schedule(p)
+	}
+}
+
+// activate lets writers send what was queued during the handshake.
+func (p *Peer) activate() {
+	p.sendMu.Lock()
+	defer p.sendMu.Unlock()
+	if p.pool == nil {
+		p.pool = SharedWriterPool()
+	}
+	p.active = true
+	// Nodes from before control frames take one for an oversized packet
+	// and drop the link. The dialer speaks first; on a link it accepted
+	// this node keeps its control frames until the remote has sent one.
+	p.quiet = p.Inbound
+	if p.pendingLocked() {
+		p.wakeLocked()
+	}
+	p.pingLocked()
+}
+
+// pendingLocked reports whether writers have anything to send. It must be
+// called with p.sendMu held.
+func (p *Peer) pendingLocked() bool {
+	return len(p.sendQueue) > 0 || len(p.traceQueue) > 0 || (len(p.ctrlQueue) > 0 && !p.quiet)
+}
+
+// heard lets the control frames held on a quiet link go, once the remote
+// has shown it speaks them.
+func (p *Peer) heard() {
+	p.sendMu.Lock()
+	defer p.sendMu.Unlock()
+	if p.quiet {
+		p.quiet = false
+		if len(p.ctrlQueue) > 0 {
+			p.wakeLocked()
+		}
+	}
+}
+
+// Authenticated reports whether the network key exchange is done and the
+// link carries frames.
+func (p *Peer) Authenticated() bool {
+	p.sendMu.Lock()
+	defer p.sendMu.Unlock()
+	return p.active
+}
+
+// pingLocked queues a ping, unless the link is quiet, and arms the timer
+// for the next one. It must be called with p.sendMu held.
+func (p *Peer) pingLocked() {
+	if p.closed {
+		return
+	}
+	if !p.quiet {
+		p.sendControlLocked(Control{Type: ControlPing, Timestamp: time.Now().UnixNano()})
+	}
+	p.ping = time.AfterFunc(pingInterval, func() {
+		p.sendMu.Lock()
+		defer p.sendMu.Unlock()
+		p.pingLocked()
+	})
+}
+
+// shutdown closes the send queue and releases what it holds.
+func (p *Peer) shutdown() {
+	p.sendMu.Lock()
+	defer p.sendMu.Unlock()
+	p.closed = true
+	p.sendQueue = nil
+	p.traceQueue = nil
+	p.ctrlQueue = nil
+	p.queued = 0
+	if p.ping != nil {
+		p.ping.Stop()
+	}
+}
+
+// write sends one batch of the queued control frames and packets.
+func (p *Peer) write(wp *WriterPool, fw *frameWriter) {
+	p.sendMu.Lock()
+	var ctrls []Control
+	if !p.quiet {
+		ctrls = p.ctrlQueue
+		p.ctrlQueue = nil
+	}
+	p.sendMu.Unlock()
+
+	fw.conn = p.Conn
+	for _, c := range ctrls {
+		payload, err := p.marshalControl(c)
+		if err != nil {
+			logger.Peer.Error("Peer %s: encoding %s frame: %v", p.ID, c.Type, err)
+			continue
+		}
+		fw.add(payload, controlFlag)
+	}
+	nctrl := fw.pending()
+
+	mode := p.padding()
+	p.mu.RLock()
+	compress := p.compressingLocked()
+	p.mu.RUnlock()
+	p.sendMu.Lock()
+	// Traced packets are few and go ahead of the others, unpadded.
+	ntrace := len(p.traceQueue)
+	for _, payload := range p.traceQueue {
+		fw.add(payload, traceFlag)
+		p.queued -= len(payload)
+	}
+	p.traceQueue = nil
+	n := 0
+	for n < len(p.sendQueue) && !fw.full() {
+		data := p.sendQueue[n]
+		size := padSize(mode, len(data))
+		var packed []byte
+		if size < 0 && compress {
+			packed = deflate(data)
+		}
+		switch {
+		case size >= 0:
+			fw.addPadded(data, size)
+		case packed != nil:
+			fw.addCompressed(packed, data)
+		default:
+			fw.add(data, 0)
+		}
+		p.queued -= len(data)
+		n++
+	}
+	m := copy(p.sendQueue, p.sendQueue[n:])
+	clear(p.sendQueue[m:])
+	p.sendQueue = p.sendQueue[:m]
+	p.sendMu.Unlock()
+
+	p.Conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	written, err := fw.flush()
+	partial := err != nil && fw.midFrame(written)
+	if err != nil {
+		// Whatever was written, the stream cannot be resumed: the far end
+		// would read what follows as the rest of a frame. Abort it before
+		// anything else can be written.
+		abort(p.Conn)
+	} else {
+		for i, data := range fw.frames[nctrl:] {
+			if i < ntrace {
+				_, data, _ = splitTraced(data)
+			}
+			if plain := fw.plain[nctrl+i]; plain != nil {
+				atomic.AddUint64(&p.deflated, uint64(len(plain)-len(data)))
+				data = plain
+			}
+			atomic.AddUint64(&p.sentBytes, uint64(len(data)))
+			atomic.AddUint64(&p.sentPkts, 1)
+			if pad := fw.pads[nctrl+i]; pad >= 0 {
+				atomic.AddUint64(&p.padBytes, uint64(2+pad))
+			}
+			ft, _ := ipx.DetectFrameType(data)
+			p.frames.AddTx(ft, len(data))
+		}
+	}
+	fw.reset()
+	fw.conn = nil
+
+	p.sendMu.Lock()
+	defer p.sendMu.Unlock()
+	if err != nil {
+		if partial {
+			atomic.AddUint64(&p.partialFrames, 1)
+		}
+		if !p.closed && !errors.Is(err, net.ErrClosed) {
+			if partial {
+				logger.Peer.Error("Peer %s send error mid-frame after %d bytes, aborting link: %v", p.ID, written, err)
+			} else {
+				logger.Peer.Error("Peer %s send error: %v", p.ID, err)
+			}
+			atomic.AddUint64(&p.errors, 1)
+		}
+		// The closed connection ends the receiver and with it Run.
+		p.closed = true
+	}
+	if p.closed || !p.pendingLocked() {
+		p.scheduled = false
+		return
+	}
+	wp.schedule(p)
+}
+
+// abort closes a link whose stream is no longer framed. On TCP unsent data
+// is discarded and the far end sees a reset rather than a clean close.
+func abort(conn net.Conn) {
+	c := conn
+	if tc, ok := c.(interface{ NetConn() net.Conn }); ok {
+		c = tc.NetConn()
+	}
+	if tcp, ok := c.(*net.TCPConn); ok {
+		tcp.SetLinger(0)
+	}
+	conn.Close()
+}
+
+// memory estimates the bytes the link holds: its queue and, once running,
+// its read buffers.
+func (p *Peer) memory() (queued, total uint64) {
+	p.sendMu.Lock()
+	defer p.sendMu.Unlock()
+	queued = uint64(p.queued)
+	total = queued + uint64(cap(p.sendQueue)+cap(p.traceQueue))*queueEntrySize
+	if p.active && !p.closed {
+		total += readBufferSize + slabSize
+	}
+	return queued, total
+}
@@ -0,0 +1,203 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for send budgets and the writer pool
+
+package peer
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPeerSendBudget(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	p := NewPeer("p", a, "")
+	p.SendBudget = 100
+	if !p.Send(make([]byte, 60)) {
+		t.Fatal("Expected a packet within budget to be queued")
+	}
+	if p.Send(make([]byte, 60)) {
+		t.Error("Expected a packet over budget to be dropped")
+	}
+	if !p.Send(make([]byte, 40)) {
+		t.Error("Expected a packet filling the budget to be queued")
+	}
+	st := p.GetStats()
+	if st.QueuedBytes != 100 || st.QueueDrops != 1 {
+		t.Errorf("Expected 100 queued bytes and 1 drop, got %d and %d", st.QueuedBytes, st.QueueDrops)
+	}
+	if st.MemBytes < st.QueuedBytes {
+		t.Errorf("Expected memory of at least the queued bytes, got %d", st.MemBytes)
+	}
+
+	p.shutdown()
+	if p.Send([]byte("late")) {
+		t.Error("Expected no packets to be queued once the link closed")
+	}
+	if st := p.GetStats(); st.QueuedBytes != 0 || st.MemBytes != 0 {
+		t.Errorf("Expected the queue to be released, got %d queued and %d held", st.QueuedBytes, st.MemBytes)
+	}
+}
+
+func TestWriterPoolSharedWorker(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	wp := NewWriterPool(1)
+
+	// Several links served by a single writer each deliver their frames.
+	relayed := make(chan []byte, 100)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go NewPeer("child", conn, "").Run(ctx, relayed, func(id string) {})
+		}
+	}()
+	const links = 5
+	for i := range links {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := NewPeer("parent", conn, "")
+		p.SetWriterPool(wp)
+		p.Send(bytes.Repeat([]byte{byte(i)}, 40))
+		go p.Run(ctx, make(chan []byte, 10), func(id string) {})
+	}
+	seen := map[byte]bool{}
+	for len(seen) < links {
+		select {
+		case data := <-relayed:
+			seen[data[0]] = true
+		case <-ctx.Done():
+			t.Fatalf("Timed out with frames from %d of %d links", len(seen), links)
+		}
+	}
+}
+
+// cutConn is a net.Conn whose writes fail after limit bytes.
+type cutConn struct {
+	net.Conn
+	limit int
+}
+
+func (c *cutConn) Write(b []byte) (int, error) {
+	n := min(len(b), c.limit)
+	c.limit -= n
+	if _, err := c.Conn.Write(b[:n]); err != nil {
+		return 0, err
+	}
+	if n < len(b) {
+		return n, errors.New("link down")
+	}
+	return n, nil
+}
+
+func TestFrameWriterMidFrame(t *testing.T) {
+	fw := newFrameWriter(nil)
+	fw.add(make([]byte, 10), 0)
+	fw.add(make([]byte, 3), controlFlag)
+	for _, tc := range []struct {
+		n    int64
+		want bool
+	}{{0, false}, {2, true}, {13, true}, {14, false}, {15, true}, {20, true}, {21, false}} {
+		if got := fw.midFrame(tc.n); got != tc.want {
+			t.Errorf("Expected midFrame(%d) = %v, got %v", tc.n, tc.want, got)
+		}
+	}
+}
+
+func TestPeerPartialWriteAborts(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	// The write fails three bytes into the second frame.
+	p := NewPeer("p", &cutConn{Conn: a, limit: 4 + 10 + 3}, "")
+	p.SetWriterPool(NewWriterPool(1))
+	received := make(chan []byte, 1)
+	go func() {
+		data, _ := io.ReadAll(b)
+		received <- data
+	}()
+	p.Send(make([]byte, 10))
+	p.Send(make([]byte, 10))
+	p.sendMu.Lock()
+	p.active = true
+	p.wakeLocked()
+	p.sendMu.Unlock()
+
+	select {
+	case data := <-received:
+		if len(data) != 17 {
+			t.Errorf("Expected the stream to end after 17 bytes, got %d", len(data))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the link to be aborted")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for p.GetStats().PartialFrames == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	st := p.GetStats()
+	if st.PartialFrames != 1 || st.Errors != 1 {
+		t.Errorf("Expected 1 partial frame and 1 error, got %d and %d", st.PartialFrames, st.Errors)
+	}
+	if p.Send(make([]byte, 10)) {
+		t.Error("Expected nothing to be queued on an aborted link")
+	}
+}
+
+func TestPeerRecvPartialFrame(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	done := make(chan *Peer)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		p := NewPeer("child", conn, "")
+		p.NoLookup = true
+		p.Run(context.Background(), make(chan []byte, 10), func(id string) {})
+		done <- p
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Handshake without a key, then a frame announcing 100 bytes that ends
+	// after 10.
+	binary.Write(conn, binary.BigEndian, uint32(0))
+	var keyLen uint32
+	binary.Read(conn, binary.BigEndian, &keyLen)
+	binary.Write(conn, binary.BigEndian, uint32(100))
+	conn.Write(make([]byte, 10))
+	conn.Close()
+
+	select {
+	case p := <-done:
+		if st := p.GetStats(); st.PartialFrames != 1 {
+			t.Errorf("Expected 1 partial frame, got %d", st.PartialFrames)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the link to close")
+	}
+}
@@ -0,0 +1,63 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Certificates for the peer listener from an ACME CA
+
+package relay
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/acmecert"
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+func newACME(ac config.ACMEConfig) *acmecert.Manager {
+	var last acmecert.Status
+	return acmecert.NewManager(acmecert.Options{
+		Domains:      ac.Domains,
+		Email:        ac.Email,
+		Challenge:    ac.Challenge,
+		HTTPAddr:     ac.HTTPAddr,
+		DNSHook:      ac.DNSHook,
+		CacheDir:     ac.CacheDir,
+		DirectoryURL: ac.DirectoryURL,
+		// Only changes are logged: a failure once until it changes, and a
+		// new certificate once.
+		Notify: func(st acmecert.Status) {
+			switch {
+			case st.Error != "" && st.Error != last.Error:
+				logger.Relay.Error("ACME: no certificate for %s: %s", strings.Join(st.Domains, ", "), st.Error)
+			case st.Error == "" && !st.Expires.Equal(last.Expires):
+				logger.Relay.Info("ACME: certificate for %s valid until %s", strings.Join(st.Domains, ", "), st.Expires.Format(time.DateOnly))
+			}
+			last = st
+		},
+	})
+}
+
+// runACME obtains the certificate of the peer listener and keeps it
+// renewed; until there is one, TLS handshakes with peers fail.
+func (s *Server) runACME(ctx context.Context) {
+	logger.Relay.Info("ACME: obtaining a certificate for %s by %s", strings.Join(s.cfg.ACME.Domains, ", "), s.cfg.ACME.Challenge)
+	s.acme.Run(ctx)
+	if st := s.acme.Status(); st.Error != "" && ctx.Err() == nil {
+		logger.Relay.Error("ACME: stopped: %s", st.Error)
+	}
+}
+
+func (s *Server) certificate() *stats.CertStats {
+	if s.acme == nil {
+		return nil
+	}
+	st := s.acme.Status()
+	return &stats.CertStats{
+		Domains:   st.Domains,
+		Challenge: st.Challenge,
+		Expires:   st.Expires,
+		Error:     st.Error,
+	}
+}
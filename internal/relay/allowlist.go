@@ -0,0 +1,208 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Allow list of a closed network: only listed hosts and IDs may link
+
+package relay
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+	"github.com/mlapointe/ipxtransporter/internal/peer"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+// allowHelloTimeout is how long a link held for its hello may take to
+// name an allowed node before it is closed.
+const allowHelloTimeout = 10 * time.Second
+
+// What the allow list makes of a new link.
+type admitVerdict int
+
+const (
+	admitYes     admitVerdict = iota
+	admitNo                   // not listed
+	admitOnHello              // only a node ID in the hello can admit it
+)
+
+// admits checks a new link against the allow list. With allow_list off
+// every link is admitted; with it on, links from an allowed host or peer
+// ID and those dialed to a configured peer are. Others wait for their
+// hello if allowed_ids may still name their node, and are refused if not.
+func (s *Server) admits(ctx context.Context, peerID, ip, dialAddr string) admitVerdict {
+	s.peersMu.RLock()
+	on, ids, hosts := s.cfg.AllowList, s.cfg.AllowedIDs, s.hostAllows
+	s.peersMu.RUnlock()
+	switch {
+	case !on:
+		return admitYes
+	case slices.Contains(ids, peerID):
+		return admitYes
+	case dialAddr != "" && slices.Contains(s.configuredPeers(), dialAddr):
+		return admitYes
+	}
+	if _, ok := hosts.Match(connHosts(ctx, ip, dialAddr, hosts.HasNames())...); ok {
+		return admitYes
+	}
+	if len(ids) > 0 {
+		return admitOnHello
+	}
+	return admitNo
+}
+
+// admitOnHello lets a link held for its hello in if the hello names an
+// allowed node, and closes it if not. Links not held are let in.
+func (s *Server) admitOnHello(p *peer.Peer, nodeID string) bool {
+	s.peersMu.Lock()
+	pending := s.allowPending[p.ID]
+	delete(s.allowPending, p.ID)
+	allowed := slices.Contains(s.cfg.AllowedIDs, nodeID)
+	s.peersMu.Unlock()
+	if !pending {
+		return true
+	}
+	if allowed {
+		p.SetMute(false, false)
+		logger.Relay.Info("Peer %s admitted by the allow list as node %s", p.ID, nodeID)
+		return true
+	}
+	logger.Relay.Info("Rejecting peer %s: node %s is not on the allow list", p.ID, nodeID)
+	if err := p.Conn.Close(); err != nil {
+		logger.Relay.Error("Error closing peer %s connection (allow list): %v", p.ID, err)
+	}
+	return false
+}
+
+// expireAllowPending closes p if it is still held for its hello.
+func (s *Server) expireAllowPending(p *peer.Peer) {
+	s.peersMu.Lock()
+	pending := s.allowPending[p.ID] && s.peers[p.ID] == p
+	delete(s.allowPending, p.ID)
+	s.peersMu.Unlock()
+	if !pending {
+		return
+	}
+	logger.Relay.Info("Rejecting peer %s: no hello naming an allowed node within %v", p.ID, allowHelloTimeout)
+	if err := p.Conn.Close(); err != nil {
+		logger.Relay.Error("Error closing peer %s connection (allow list): %v", p.ID, err)
+	}
+}
+
+// allowedLink reports whether linked peer p is on the allow list. Name
+// patterns match only the host a peer was dialed by. Must be called with
+// peersMu held.
+func (s *Server) allowedLink(p *peer.Peer, configured []string) bool {
+	if slices.Contains(s.cfg.AllowedIDs, p.ID) || slices.Contains(s.cfg.AllowedIDs, p.NodeID()) {
+		return true
+	}
+	if p.DialAddr != "" && slices.Contains(configured, p.DialAddr) {
+		return true
+	}
+	hosts := []string{peerHost(p.ID)}
+	if p.DialAddr != "" {
+		hosts = append(hosts, peerHost(p.DialAddr))
+	}
+	_, ok := s.hostAllows.Match(hosts...)
+	return ok
+}
+
+// enforceAllowList closes the links the allow list no longer admits, when
+// it is on. Links still held for their hello are left to it.
+func (s *Server) enforceAllowList() {
+	configured := s.configuredPeers()
+	s.peersMu.RLock()
+	defer s.peersMu.RUnlock()
+	if !s.cfg.AllowList {
+		return
+	}
+	for id, p := range s.peers {
+		if s.allowPending[id] || s.allowedLink(p, configured) {
+			continue
+		}
+		logger.Relay.Info("Closing peer %s: not on the allow list", id)
+		if err := p.Conn.Close(); err != nil {
+			logger.Relay.Error("Error closing peer %s connection (allow list): %v", id, err)
+		}
+	}
+}
+
+// AllowList returns the allow list and whether it is enforced.
+func (s *Server) AllowList() stats.AllowList {
+	s.peersMu.RLock()
+	defer s.peersMu.RUnlock()
+	return stats.AllowList{
+		Enabled: s.cfg.AllowList,
+		Hosts:   slices.Clone(s.cfg.AllowedHosts),
+		IDs:     slices.Clone(s.cfg.AllowedIDs),
+	}
+}
+
+// SetAllowListMode turns the allow list on or off and saves the config.
+// Turning it on closes the links it does not admit.
+func (s *Server) SetAllowListMode(on bool) {
+	s.peersMu.Lock()
+	s.cfg.AllowList = on
+	s.peersMu.Unlock()
+	s.enforceAllowList()
+	s.persistConfig()
+	if on {
+		logger.Relay.Info("Allow list enforced: only listed hosts and IDs may link")
+	} else {
+		logger.Relay.Info("Allow list lifted")
+	}
+}
+
+// AllowPeer adds target to the allow list: to allowed_hosts for kind
+// "host", an address, CIDR range or host name pattern, and to allowed_ids
+// for kind "id", a peer ID or node ID.
+func (s *Server) AllowPeer(kind, target string) error {
+	s.peersMu.Lock()
+	var err error
+	switch kind {
+	case "host":
+		if !slices.Contains(s.cfg.AllowedHosts, target) {
+			hosts := append(slices.Clone(s.cfg.AllowedHosts), target)
+			var m *config.HostMatcher
+			if m, err = config.CompileHostPatterns(hosts); err == nil {
+				s.cfg.AllowedHosts, s.hostAllows = hosts, m
+			}
+		}
+	case "id":
+		if !slices.Contains(s.cfg.AllowedIDs, target) {
+			s.cfg.AllowedIDs = append(slices.Clone(s.cfg.AllowedIDs), target)
+		}
+	default:
+		err = fmt.Errorf("unknown allow list kind %q, want host or id", kind)
+	}
+	s.peersMu.Unlock()
+	if err != nil {
+		return err
+	}
+	s.persistConfig()
+	logger.Relay.Info("Allowed %s %s", kind, target)
+	return nil
+}
+
+// RemoveAllowed drops target from allowed_hosts and allowed_ids and, when
+// the allow list is on, closes the links it no longer admits.
+func (s *Server) RemoveAllowed(target string) error {
+	is := func(a string) bool { return a == target }
+	s.peersMu.Lock()
+	before := len(s.cfg.AllowedHosts) + len(s.cfg.AllowedIDs)
+	s.cfg.AllowedHosts = slices.DeleteFunc(slices.Clone(s.cfg.AllowedHosts), is)
+	s.cfg.AllowedIDs = slices.DeleteFunc(slices.Clone(s.cfg.AllowedIDs), is)
+	s.hostAllows, _ = config.CompileHostPatterns(s.cfg.AllowedHosts)
+	removed := before - len(s.cfg.AllowedHosts) - len(s.cfg.AllowedIDs)
+	s.peersMu.Unlock()
+	if removed == 0 {
+		return fmt.Errorf("%s is not on the allow list", target)
+	}
+	s.persistConfig()
+	s.enforceAllowList()
+	logger.Relay.Info("Removed %s from the allow list", target)
+	return nil
+}
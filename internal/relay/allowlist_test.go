@@ -0,0 +1,95 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for the allow list
+
+package relay
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/peer"
+)
+
+func TestAllowListAdmits(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Peers = []config.PeerConfig{{Addr: "relay.example.net:8787"}}
+	srv, err := NewServer(cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if srv.admits(ctx, "10.9.0.1:5000", "10.9.0.1", "") != admitYes {
+		t.Error("Expected every link admitted with the allow list off")
+	}
+
+	srv.SetAllowListMode(true)
+	if srv.admits(ctx, "10.9.0.1:5000", "10.9.0.1", "") != admitNo {
+		t.Error("Expected an unlisted link refused")
+	}
+	if err := srv.AllowPeer("host", "10.1.0.0/16"); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.AllowPeer("host", "not a host"); err == nil {
+		t.Error("Expected an invalid host pattern refused")
+	}
+	if srv.admits(ctx, "10.1.2.3:5000", "10.1.2.3", "") != admitYes {
+		t.Error("Expected a host in the allowed range admitted")
+	}
+	if srv.admits(ctx, "10.9.0.2:8787", "10.9.0.2", "relay.example.net:8787") != admitYes {
+		t.Error("Expected a configured peer admitted")
+	}
+
+	if err := srv.AllowPeer("id", "node-b"); err != nil {
+		t.Fatal(err)
+	}
+	if srv.admits(ctx, "10.9.0.1:5000", "10.9.0.1", "") != admitOnHello {
+		t.Error("Expected an unlisted link held for its hello while allowed_ids is set")
+	}
+
+	if err := srv.RemoveAllowed("10.1.0.0/16"); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.RemoveAllowed("10.1.0.0/16"); err == nil {
+		t.Error("Expected removing an entry twice to fail")
+	}
+	if got := srv.AllowList(); !got.Enabled || len(got.Hosts) != 0 || len(got.IDs) != 1 {
+		t.Errorf("Expected the allow list on with one ID, got %+v", got)
+	}
+}
+
+func TestAllowListOnHello(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.AllowList = true
+	cfg.AllowedIDs = []string{"node-b"}
+	srv, err := NewServer(cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hold := func(id string) (*peer.Peer, net.Conn) {
+		local, remote := net.Pipe()
+		p := peer.NewPeer(id, local, "")
+		p.SetMute(true, true)
+		srv.peersMu.Lock()
+		srv.addPeerLocked(p)
+		srv.allowPending[p.ID] = true
+		srv.peersMu.Unlock()
+		return p, remote
+	}
+
+	p, remote := hold("10.9.0.1:5000")
+	defer remote.Close()
+	if !srv.admitOnHello(p, "node-b") || p.GetStats().MutedIn {
+		t.Error("Expected an allowed node admitted and unmuted")
+	}
+
+	p, remote = hold("10.9.0.2:5000")
+	if srv.admitOnHello(p, "node-c") {
+		t.Error("Expected an unlisted node refused")
+	}
+	if _, err := remote.Read(make([]byte, 1)); err == nil {
+		t.Error("Expected the refused link closed")
+	}
+}
@@ -0,0 +1,174 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Audit trail of inbound connection attempts
+
+package relay
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+	"github.com/mlapointe/ipxtransporter/internal/peer"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+const (
+	maxAuditEntries = 1024
+	auditLookups    = 16 // reverse lookups of attempts in flight at most
+)
+
+// Outcomes of stats.ConnAttempt.
+const (
+	auditAccepted    = "accepted"
+	auditBanned      = "banned"
+	auditNotAllowed  = "not-allowed"
+	auditScheduled   = "scheduled"
+	auditMaxChildren = "max-children"
+	auditDraining    = "draining"
+	auditAuthFailed  = "auth-failed"
+)
+
+// auditTrail keeps the latest inbound connection attempts, and appends
+// each to the audit_log file if one is set.
+type auditTrail struct {
+	mu      sync.Mutex
+	entries []stats.ConnAttempt // oldest first
+	path    string
+	lookups chan struct{}
+	lookup  func(ctx context.Context, ip string) string
+}
+
+func newAuditTrail(path string) *auditTrail {
+	a := &auditTrail{
+		path:    path,
+		lookups: make(chan struct{}, auditLookups),
+		lookup:  lookupHost,
+	}
+	if path != "" {
+		a.entries = readAuditTail(path)
+	}
+	return a
+}
+
+func lookupHost(ctx context.Context, ip string) string {
+	ctx, cancel := context.WithTimeout(ctx, banLookupTimeout)
+	defer cancel()
+	names, err := net.DefaultResolver.LookupAddr(ctx, ip)
+	if err != nil || len(names) == 0 {
+		return ""
+	}
+	return strings.TrimSuffix(names[0], ".")
+}
+
+// readAuditTail loads the last attempts written to the audit file, so the
+// trail outlives restarts.
+func readAuditTail(path string) []stats.ConnAttempt {
+	f, err := os.Open(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logger.Relay.Warn("Audit log: %v", err)
+		}
+		return nil
+	}
+	defer f.Close()
+	var out []stats.ConnAttempt
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var e stats.ConnAttempt
+		if json.Unmarshal(sc.Bytes(), &e) != nil {
+			continue
+		}
+		out = append(out, e)
+		if len(out) > 2*maxAuditEntries {
+			out = slices.Clone(out[len(out)-maxAuditEntries:])
+		}
+	}
+	if len(out) > maxAuditEntries {
+		out = out[len(out)-maxAuditEntries:]
+	}
+	return out
+}
+
+// record resolves the attempt's host, if there is a lookup to spare, then
+// keeps it and writes it to the audit file.
+func (a *auditTrail) record(e stats.ConnAttempt) {
+	go func() {
+		select {
+		case a.lookups <- struct{}{}:
+			e.Host = a.lookup(context.Background(), e.IP)
+			<-a.lookups
+		default:
+		}
+		a.add(e)
+	}()
+}
+
+func (a *auditTrail) add(e stats.ConnAttempt) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.entries = append(a.entries, e)
+	if len(a.entries) > maxAuditEntries {
+		a.entries = slices.Clone(a.entries[len(a.entries)-maxAuditEntries:])
+	}
+	if a.path == "" {
+		return
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	f, err := os.OpenFile(a.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		logger.Relay.Error("Audit log: %v", err)
+		return
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		logger.Relay.Error("Audit log: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		logger.Relay.Error("Audit log: %v", err)
+	}
+}
+
+// auditConn records an inbound connection attempt; outbound links are
+// not audited.
+func (s *Server) auditConn(peerID, ip, dialAddr, outcome, detail string) {
+	if dialAddr != "" {
+		return
+	}
+	s.audit.record(stats.ConnAttempt{Time: time.Now(), IP: ip, PeerID: peerID, Outcome: outcome, Detail: detail})
+}
+
+// auditHandshake records an inbound link once its handshake ends, as
+// accepted or failed.
+func (s *Server) auditHandshake(p *peer.Peer, err error) {
+	ip := peerHost(p.ID)
+	if err != nil {
+		s.auditConn(p.ID, ip, p.DialAddr, auditAuthFailed, err.Error())
+		return
+	}
+	s.auditConn(p.ID, ip, p.DialAddr, auditAccepted, "")
+}
+
+// ConnAttempts returns the latest inbound connection attempts, newest
+// first, with the given outcome only unless it is empty.
+func (s *Server) ConnAttempts(outcome string) []stats.ConnAttempt {
+	s.audit.mu.Lock()
+	defer s.audit.mu.Unlock()
+	out := make([]stats.ConnAttempt, 0, len(s.audit.entries))
+	for i := len(s.audit.entries) - 1; i >= 0; i-- {
+		if e := s.audit.entries[i]; outcome == "" || e.Outcome == outcome {
+			out = append(out, e)
+		}
+	}
+	return out
+}
@@ -0,0 +1,49 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for the connection attempt audit trail
+
+package relay
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+func TestAuditTrail(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.AuditLog = filepath.Join(t.TempDir(), "audit.log")
+	cfg.BannedHosts = []string{"10.0.0.0/24"}
+	srv, err := NewServer(cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.audit.lookup = func(ctx context.Context, ip string) string { return "knocker.example.net" }
+
+	conn := &fakeConn{remoteAddr: &net.TCPAddr{IP: net.ParseIP("10.0.0.3"), Port: 5000}}
+	srv.handleNewConn(context.Background(), conn, nil, "")
+	var got []stats.ConnAttempt
+	for deadline := time.Now().Add(2 * time.Second); len(got) == 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		got = srv.ConnAttempts("")
+	}
+	if len(got) != 1 || got[0].Outcome != auditBanned || got[0].Detail != "10.0.0.0/24" || got[0].Host != "knocker.example.net" {
+		t.Fatalf("Expected the banned attempt audited, got %+v", got)
+	}
+
+	srv.audit.add(stats.ConnAttempt{Time: time.Now(), IP: "10.1.0.1", PeerID: "10.1.0.1:5000", Outcome: auditAccepted})
+	if got := srv.ConnAttempts(auditAccepted); len(got) != 1 || got[0].IP != "10.1.0.1" {
+		t.Errorf("Expected only the accepted attempt, got %+v", got)
+	}
+
+	// The file carries the trail over a restart.
+	again := newAuditTrail(cfg.AuditLog)
+	if len(again.entries) != 2 || again.entries[0].Outcome != auditBanned {
+		t.Errorf("Expected both attempts read back, got %+v", again.entries)
+	}
+}
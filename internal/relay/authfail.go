@@ -0,0 +1,86 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Temporary bans of addresses that keep failing the peer handshake
+
+package relay
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+	"github.com/mlapointe/ipxtransporter/internal/peer"
+	"github.com/mlapointe/ipxtransporter/internal/schedule"
+)
+
+// maxAuthFailAddrs bounds the addresses whose failures are counted, so a
+// scan from many addresses cannot grow the table without limit.
+const maxAuthFailAddrs = 4096
+
+// authFailures counts failed inbound handshakes by source address.
+type authFailures struct {
+	mu     sync.Mutex
+	byAddr map[string]*authFailCount
+}
+
+type authFailCount struct {
+	n     int
+	first time.Time // of the failures counted
+}
+
+// fail counts a failure from addr at now and reports whether it reaches
+// max within window, which starts the count over.
+func (a *authFailures) fail(addr string, now time.Time, max int, window time.Duration) (int, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.byAddr == nil {
+		a.byAddr = make(map[string]*authFailCount)
+	}
+	if len(a.byAddr) >= maxAuthFailAddrs {
+		for k, c := range a.byAddr {
+			if now.Sub(c.first) > window {
+				delete(a.byAddr, k)
+			}
+		}
+		if len(a.byAddr) >= maxAuthFailAddrs {
+			return 0, false
+		}
+	}
+	c := a.byAddr[addr]
+	if c == nil || now.Sub(c.first) > window {
+		c = &authFailCount{first: now}
+		a.byAddr[addr] = c
+	}
+	c.n++
+	if c.n < max {
+		return c.n, false
+	}
+	delete(a.byAddr, addr)
+	return c.n, true
+}
+
+// handshakeDone audits an inbound link once its handshake ends and, after
+// auth_fail_max failures from one address within auth_fail_window, bans
+// the address for auth_fail_ban.
+func (s *Server) handshakeDone(p *peer.Peer, err error) {
+	s.auditHandshake(p, err)
+	if err == nil || !p.Inbound || s.cfg.AuthFailMax <= 0 {
+		return
+	}
+	ip := peerHost(p.ID)
+	window := time.Duration(s.cfg.AuthFailWindow) * time.Second
+	n, ban := s.authFails.fail(ip, time.Now(), s.cfg.AuthFailMax, window)
+	if !ban {
+		return
+	}
+	d := time.Duration(s.cfg.AuthFailBan) * time.Second
+	reason := fmt.Sprintf("%d failed handshakes within %v", n, window)
+	until := time.Now().Add(d)
+	if _, err := s.schedule.Add(schedule.Entry{Action: schedule.ActionBan, Target: ip, Until: until, Reason: reason}); err != nil {
+		logger.Relay.Error("Failed to schedule ban for %s: %v", ip, err)
+		return
+	}
+	s.scheduleUpdated()
+	logger.Relay.Warn("Banned %s until %s: %s", ip, until.Format("2006-01-02 15:04"), reason)
+}
@@ -0,0 +1,54 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for bans on repeated handshake failures
+
+package relay
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/peer"
+)
+
+func TestAuthFailuresWindow(t *testing.T) {
+	var a authFailures
+	now := time.Now()
+	if _, ban := a.fail("10.0.0.1", now, 2, time.Minute); ban {
+		t.Fatal("Expected no ban after one failure")
+	}
+	if _, ban := a.fail("10.0.0.1", now.Add(2*time.Minute), 2, time.Minute); ban {
+		t.Error("Expected failures outside the window to start over")
+	}
+	if n, ban := a.fail("10.0.0.1", now.Add(150*time.Second), 2, time.Minute); !ban || n != 2 {
+		t.Errorf("Expected a ban on the second failure in the window, got %d, %t", n, ban)
+	}
+}
+
+func TestAuthFailBan(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.AuthFailMax = 3
+	srv, err := NewServer(cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 3 {
+		p := peer.NewPeer("10.0.0.7:5000", &fakeConn{}, "")
+		p.Inbound = true
+		srv.handshakeDone(p, peer.ErrKeyMismatch)
+		if bans := srv.Bans(); len(bans) != 0 && i < 2 {
+			t.Fatalf("Expected no ban after %d failures, got %+v", i+1, bans)
+		}
+	}
+	bans := srv.Bans()
+	if len(bans) != 1 || bans[0].Target != "10.0.0.7" || bans[0].Kind != "schedule" || !bans[0].Active || bans[0].Reason == "" {
+		t.Fatalf("Expected a timed ban of the address with its reason, got %+v", bans)
+	}
+	if blocked, _ := srv.scheduleBlocks(time.Now(), "10.0.0.7:5001", "10.0.0.7", ""); !blocked {
+		t.Error("Expected new links from the address refused")
+	}
+	if err := srv.UnbanPeer("10.0.0.7"); err != nil {
+		t.Errorf("Expected the automatic ban to be cancellable, got %v", err)
+	}
+}
@@ -0,0 +1,39 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Restoring an earlier config file from its backups
+
+package relay
+
+import (
+	"errors"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+)
+
+var errNoConfigFile = errors.New("the relay was started without a config file")
+
+// ConfigBackups lists the backups kept of the config file, newest first.
+func (s *Server) ConfigBackups() ([]config.Backup, error) {
+	if s.configPath == "" {
+		return nil, errNoConfigFile
+	}
+	return config.ListBackups(s.configPath)
+}
+
+// RestoreConfig puts the backup name back in place of the config file and
+// restarts the relay to read it. Until the restart the relay no longer
+// saves its config, which would overwrite the one restored.
+func (s *Server) RestoreConfig(name string) error {
+	if s.configPath == "" {
+		return errNoConfigFile
+	}
+	s.restored.Store(true)
+	if err := config.RestoreBackup(s.configPath, name, s.cfg.ConfigBackups); err != nil {
+		s.restored.Store(false)
+		return err
+	}
+	logger.Relay.Warn("Restored the config from %s", name)
+	s.RequestRestart("config restore")
+	return nil
+}
@@ -0,0 +1,67 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for restoring config backups
+
+package relay
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+)
+
+func TestRestoreConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	cfg := config.DefaultConfig()
+	if err := config.SaveConfig(path, cfg); err != nil {
+		t.Fatal(err)
+	}
+	srv, err := NewServer(cfg, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.LabelPeers(nil, "") // saves the config unchanged, so no backup
+	cfg.MaxChildren = 9
+	srv.persistConfig()
+
+	backups, err := srv.ConfigBackups()
+	if err != nil || len(backups) != 1 {
+		t.Fatalf("Expected 1 backup, got %+v, %v", backups, err)
+	}
+	if err := srv.RestoreConfig("nonexistent.bak"); err == nil {
+		t.Error("Expected an unknown backup refused")
+	}
+	if srv.restored.Load() {
+		t.Error("Expected saving to resume after a failed restore")
+	}
+	if err := srv.RestoreConfig(backups[0].Name); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case reason := <-srv.RestartRequested():
+		if reason != "config restore" {
+			t.Errorf("Expected a restart for the restore, got %q", reason)
+		}
+	default:
+		t.Error("Expected a restart requested")
+	}
+
+	// The running config no longer overwrites the one restored.
+	srv.persistConfig()
+	got, err := config.LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.MaxChildren != config.DefaultConfig().MaxChildren {
+		t.Errorf("Expected the restored max_children, got %d", got.MaxChildren)
+	}
+
+	noFile, err := NewServer(config.DefaultConfig(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := noFile.ConfigBackups(); err == nil {
+		t.Error("Expected an error without a config file")
+	}
+}
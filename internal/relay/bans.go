@@ -0,0 +1,98 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Listing bans and lifting them
+
+package relay
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+	"github.com/mlapointe/ipxtransporter/internal/schedule"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+// banLookupTimeout bounds the reverse lookup of a connecting address when
+// banned_hosts or allowed_hosts holds host name patterns.
+const banLookupTimeout = 2 * time.Second
+
+// connHosts lists what host patterns are matched against for a new link:
+// its address, the host it was dialed by, and with names its reverse
+// lookup, which name patterns match.
+func connHosts(ctx context.Context, ip, dialAddr string, names bool) []string {
+	hosts := []string{ip}
+	if dialAddr != "" {
+		hosts = append(hosts, peerHost(dialAddr))
+	}
+	if names {
+		lctx, cancel := context.WithTimeout(ctx, banLookupTimeout)
+		found, _ := net.DefaultResolver.LookupAddr(lctx, ip)
+		cancel()
+		hosts = append(hosts, found...)
+	}
+	return hosts
+}
+
+// isBannedHost reports whether host, an address or name, matches
+// banned_hosts.
+func (s *Server) isBannedHost(host string) bool {
+	s.peersMu.RLock()
+	defer s.peersMu.RUnlock()
+	_, banned := s.hostBans.Match(host)
+	return banned
+}
+
+// Bans lists banned_ids, banned_hosts and the scheduled bans.
+func (s *Server) Bans() []stats.Ban {
+	var out []stats.Ban
+	s.peersMu.RLock()
+	for _, b := range s.cfg.BannedIDs {
+		snap := s.banHits.Snapshot("id:" + b)
+		out = append(out, stats.Ban{Target: b, Kind: "id", Active: true, Hits: snap.Total, LastHit: snap.LastHit})
+	}
+	for _, b := range s.cfg.BannedHosts {
+		snap := s.banHits.Snapshot("host:" + b)
+		out = append(out, stats.Ban{Target: b, Kind: "host", Active: true, Hits: snap.Total, LastHit: snap.LastHit})
+	}
+	s.peersMu.RUnlock()
+	now := time.Now()
+	for _, e := range s.schedule.List() {
+		if e.Action == schedule.ActionBan {
+			out = append(out, stats.Ban{Target: e.Target, Kind: "schedule", When: e.Describe(), Active: e.Active(now), ScheduleID: e.ID, Reason: e.Reason})
+		}
+	}
+	return out
+}
+
+// UnbanPeer lifts every ban of target, a peer ID, host or peer address:
+// its entries in banned_ids and banned_hosts and its scheduled bans.
+func (s *Server) UnbanPeer(target string) error {
+	is := func(b string) bool { return b == target }
+	s.peersMu.Lock()
+	before := len(s.cfg.BannedIDs) + len(s.cfg.BannedHosts)
+	s.cfg.BannedIDs = slices.DeleteFunc(s.cfg.BannedIDs, is)
+	s.cfg.BannedHosts = slices.DeleteFunc(s.cfg.BannedHosts, is)
+	s.hostBans, _ = config.CompileHostPatterns(s.cfg.BannedHosts)
+	lifted := before - len(s.cfg.BannedIDs) - len(s.cfg.BannedHosts)
+	s.peersMu.Unlock()
+	if lifted > 0 {
+		s.banHits.Remove("id:" + target)
+		s.banHits.Remove("host:" + target)
+		s.persistConfig()
+	}
+	for _, e := range s.schedule.List() {
+		if e.Action == schedule.ActionBan && e.Target == target && s.schedule.Delete(e.ID) == nil {
+			lifted++
+		}
+	}
+	if lifted == 0 {
+		return fmt.Errorf("%s is not banned", target)
+	}
+	logger.Relay.Info("Unbanned %s", target)
+	return nil
+}
@@ -0,0 +1,67 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for listing and lifting bans
+
+package relay
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+)
+
+func TestUnbanPeer(t *testing.T) {
+	srv, err := NewServer(config.DefaultConfig(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.BanPeer("10.0.0.1:8787", "10.0.0.1")
+	srv.BanPeerFor("", "10.0.0.2", time.Hour)
+	srv.banHits.Hit("host:10.0.0.1")
+
+	bans := srv.Bans()
+	if len(bans) != 3 || bans[0].Kind != "id" || bans[1].Kind != "host" || bans[1].Hits != 1 || bans[2].Kind != "schedule" || !bans[2].Active {
+		t.Fatalf("Expected an ID, a host and a scheduled ban, got %+v", bans)
+	}
+
+	if err := srv.UnbanPeer("10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.UnbanPeer("10.0.0.2"); err != nil {
+		t.Fatal(err)
+	}
+	if len(srv.cfg.BannedHosts) != 0 || len(srv.schedule.List()) != 0 {
+		t.Errorf("Expected the host and scheduled bans lifted, got %v and %+v", srv.cfg.BannedHosts, srv.schedule.List())
+	}
+	if len(srv.cfg.BannedIDs) != 1 {
+		t.Errorf("Expected the ID ban kept, got %v", srv.cfg.BannedIDs)
+	}
+	if err := srv.UnbanPeer("10.0.0.1"); err == nil {
+		t.Error("Expected lifting a ban twice to fail")
+	}
+}
+
+func TestBanHostRange(t *testing.T) {
+	srv, err := NewServer(config.DefaultConfig(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.BanPeer("", "203.0.113.0/24")
+	srv.BanPeer("", "not a host")
+	if !srv.isBannedHost("203.0.113.9") || srv.isBannedHost("203.0.114.9") {
+		t.Error("Expected the range banned and nothing else")
+	}
+	if len(srv.cfg.BannedHosts) != 1 {
+		t.Errorf("Expected the invalid pattern left out, got %v", srv.cfg.BannedHosts)
+	}
+	if err := srv.UnbanPeer("203.0.113.0/24"); err != nil || srv.isBannedHost("203.0.113.9") {
+		t.Errorf("Expected the range lifted, got %v", err)
+	}
+
+	cfg := config.DefaultConfig()
+	cfg.BannedHosts = []string{"10.0.0.0/40"}
+	if _, err := NewServer(cfg, ""); err == nil {
+		t.Error("Expected an invalid banned_hosts to fail")
+	}
+}
@@ -0,0 +1,247 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Presence beacon: announce the relay on the local IPX segment
+
+package relay
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/ipx"
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+// A beacon is an IPX packet type 4 broadcast to ipx.SocketBeacon of the
+// local network. Its data, simple enough for a DOS TSR to build and parse:
+//
+//	"IPXT"  magic
+//	1 byte  version, 1
+//	1 byte  kind: 0 announces a relay, 1 asks relays to announce themselves
+//	2 bytes number of peer links, big-endian
+//	then the node ID, hostname and peer listen address, each one length
+//	byte followed by that many ASCII bytes; a query may leave them empty.
+const (
+	beaconVersion  = 1
+	beaconAnnounce = 0
+	beaconQuery    = 1
+
+	// beaconMinReply spaces out the announcements sent in answer to queries,
+	// so a burst of them cannot make us flood the segment.
+	beaconMinReply = time.Second
+	// beaconExpiry is how many missed announcements drop a relay from the list.
+	beaconExpiry = 3
+)
+
+var beaconMagic = []byte("IPXT")
+
+type beaconMsg struct {
+	kind     byte
+	peers    uint16
+	nodeID   string
+	hostname string
+	listen   string
+}
+
+func (m beaconMsg) marshal() []byte {
+	b := append([]byte(nil), beaconMagic...)
+	b = append(b, beaconVersion, m.kind)
+	b = binary.BigEndian.AppendUint16(b, m.peers)
+	for _, s := range []string{m.nodeID, m.hostname, m.listen} {
+		s = s[:min(len(s), 255)]
+		b = append(b, byte(len(s)))
+		b = append(b, s...)
+	}
+	return b
+}
+
+func parseBeacon(data []byte) (beaconMsg, error) {
+	var m beaconMsg
+	if len(data) < 8 || !bytes.Equal(data[:4], beaconMagic) {
+		return m, errors.New("not a beacon")
+	}
+	if data[4] != beaconVersion {
+		return m, errors.New("unknown beacon version")
+	}
+	m.kind = data[5]
+	m.peers = binary.BigEndian.Uint16(data[6:8])
+	rest := data[8:]
+	for _, f := range []*string{&m.nodeID, &m.hostname, &m.listen} {
+		if len(rest) == 0 {
+			break // trailing fields may be left out
+		}
+		n := int(rest[0])
+		if len(rest) < 1+n {
+			return m, errors.New("truncated beacon")
+		}
+		*f = string(rest[1 : 1+n])
+		rest = rest[1+n:]
+	}
+	return m, nil
+}
+
+// beaconState is the beacon we send and the relays heard on the segment.
+type beaconState struct {
+	interval  time.Duration
+	sent      atomic.Uint64
+	queries   atomic.Uint64
+	lastReply atomic.Int64 // unix nanoseconds
+
+	mu    sync.Mutex
+	heard map[string]*stats.BeaconNode // by node ID
+}
+
+func newBeaconState(interval time.Duration) *beaconState {
+	return &beaconState{interval: interval, heard: make(map[string]*stats.BeaconNode)}
+}
+
+func (s *Server) runBeacon(ctx context.Context) {
+	logger.Relay.Info("Beacon: announcing on IPX socket %04X every %s", ipx.SocketBeacon, s.beacon.interval)
+	// Ask the relays already there to announce themselves rather than
+	// waiting out their interval.
+	s.sendBeacon(beaconQuery)
+	s.sendBeacon(beaconAnnounce)
+	ticker := time.NewTicker(s.beacon.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.sendBeacon(beaconAnnounce)
+			s.pruneBeacons(time.Now())
+		}
+	}
+}
+
+// sendBeacon broadcasts a beacon to the local segment: the interface and
+// the attached emulators, but never to peers.
+func (s *Server) sendBeacon(kind byte) {
+	s.peersMu.RLock()
+	peers := len(s.peers)
+	s.peersMu.RUnlock()
+	msg := beaconMsg{kind: kind, nodeID: s.nodeID, hostname: s.hostname, listen: s.cfg.ListenAddr}
+	msg.peers = uint16(min(peers, 0xFFFF))
+	data := msg.marshal()
+
+	node := s.virtualServerNode()
+	pkt := make([]byte, 30+len(data))
+	binary.BigEndian.PutUint16(pkt[0:2], 0xFFFF)
+	binary.BigEndian.PutUint16(pkt[2:4], uint16(len(pkt)))
+	pkt[5] = 4 // packet exchange
+	copy(pkt[10:16], []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF})
+	binary.BigEndian.PutUint16(pkt[16:18], ipx.SocketBeacon)
+	copy(pkt[22:28], node)
+	binary.BigEndian.PutUint16(pkt[28:30], ipx.SocketBeacon)
+	copy(pkt[30:], data)
+	frame, err := ipx.EncapEthernetII(pkt)
+	if err != nil {
+		return
+	}
+
+	s.deliverVirtual(frame, nil)
+	s.deliverBridge(frame, nil)
+	if captureErr, _ := s.captureError.Load().(string); captureErr == "" && !s.relayOnly {
+		s.dispatch(s.injectQueue, frame)
+	}
+	if kind == beaconAnnounce {
+		s.beacon.sent.Add(1)
+	}
+}
+
+// handleBeacon takes a frame from the local segment addressed to the beacon
+// socket: it records announcements, answers queries and reports true so the
+// caller does not relay it, as beacons describe this segment only. With the
+// beacon off, the socket is relayed like any other.
+func (s *Server) handleBeacon(frame []byte, via string) bool {
+	if s.beacon == nil {
+		return false
+	}
+	h, err := ipx.Parse(frame)
+	if err != nil || h.Dst.Socket != ipx.SocketBeacon {
+		return false
+	}
+	pkt, err := ipx.Packet(frame)
+	if err != nil || len(pkt) < 30 {
+		return true
+	}
+	m, err := parseBeacon(pkt[30:])
+	if err != nil || m.nodeID == s.nodeID {
+		return true
+	}
+
+	now := time.Now()
+	switch m.kind {
+	case beaconQuery:
+		last := s.beacon.lastReply.Load()
+		if now.UnixNano()-last >= int64(beaconMinReply) && s.beacon.lastReply.CompareAndSwap(last, now.UnixNano()) {
+			s.beacon.queries.Add(1)
+			s.sendBeacon(beaconAnnounce)
+		}
+	case beaconAnnounce:
+		if m.nodeID == "" {
+			return true
+		}
+		s.beacon.mu.Lock()
+		n, ok := s.beacon.heard[m.nodeID]
+		if !ok {
+			n = &stats.BeaconNode{ID: m.nodeID, FirstSeen: now}
+			s.beacon.heard[m.nodeID] = n
+			logger.Relay.Info("Beacon: relay %s (%s) is on the local segment, listening on %s", m.nodeID, m.hostname, m.listen)
+		}
+		n.Hostname, n.Listen, n.Peers, n.Via = m.hostname, m.listen, int(m.peers), via
+		n.Addr = h.Src.String()
+		n.LastSeen = now
+		n.Count++
+		s.beacon.mu.Unlock()
+	}
+	return true
+}
+
+// remoteBeacon reports whether a frame from a peer is a beacon from another
+// segment, which is not injected into ours while our own beacon is on.
+func (s *Server) remoteBeacon(frame []byte) bool {
+	if s.beacon == nil {
+		return false
+	}
+	h, err := ipx.Parse(frame)
+	return err == nil && h.Dst.Socket == ipx.SocketBeacon
+}
+
+// pruneBeacons forgets relays that missed several announcements.
+func (s *Server) pruneBeacons(now time.Time) {
+	s.beacon.mu.Lock()
+	defer s.beacon.mu.Unlock()
+	for id, n := range s.beacon.heard {
+		if now.Sub(n.LastSeen) > beaconExpiry*s.beacon.interval {
+			delete(s.beacon.heard, id)
+		}
+	}
+}
+
+func (s *Server) collectBeacon() *stats.BeaconStats {
+	if s.beacon == nil {
+		return nil
+	}
+	st := &stats.BeaconStats{
+		Socket:   ipx.SocketBeacon,
+		Interval: int(s.beacon.interval / time.Second),
+		Sent:     s.beacon.sent.Load(),
+		Queries:  s.beacon.queries.Load(),
+		Beacons:  []stats.BeaconNode{},
+	}
+	s.beacon.mu.Lock()
+	for _, n := range s.beacon.heard {
+		st.Beacons = append(st.Beacons, *n)
+	}
+	s.beacon.mu.Unlock()
+	sort.Slice(st.Beacons, func(i, j int) bool { return st.Beacons[i].ID < st.Beacons[j].ID })
+	return st
+}
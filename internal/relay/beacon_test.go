@@ -0,0 +1,114 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for the presence beacon
+
+package relay
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/ipx"
+)
+
+// beaconFrame builds a beacon broadcast from src carrying m.
+func beaconFrame(src net.HardwareAddr, m beaconMsg) []byte {
+	data := m.marshal()
+	pkt := make([]byte, 30+len(data))
+	binary.BigEndian.PutUint16(pkt[0:2], 0xffff)
+	binary.BigEndian.PutUint16(pkt[2:4], uint16(len(pkt)))
+	pkt[5] = 4
+	copy(pkt[10:16], net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	binary.BigEndian.PutUint16(pkt[16:18], ipx.SocketBeacon)
+	copy(pkt[22:28], src)
+	binary.BigEndian.PutUint16(pkt[28:30], ipx.SocketBeacon)
+	copy(pkt[30:], data)
+	frame, _ := ipx.EncapEthernetII(pkt)
+	return frame
+}
+
+func TestBeaconMarshal(t *testing.T) {
+	in := beaconMsg{kind: beaconAnnounce, peers: 3, nodeID: "abc123", hostname: "lan-box", listen: ":8787"}
+	out, err := parseBeacon(in.marshal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != in {
+		t.Errorf("Expected %+v, got %+v", in, out)
+	}
+
+	// A DOS client may send a bare query without the strings.
+	q, err := parseBeacon([]byte{'I', 'P', 'X', 'T', beaconVersion, beaconQuery, 0, 0})
+	if err != nil || q.kind != beaconQuery {
+		t.Errorf("Expected a bare query to parse, got %+v (%v)", q, err)
+	}
+
+	data := in.marshal()
+	if _, err := parseBeacon(data[:len(data)-2]); err == nil {
+		t.Error("Expected a truncated beacon to be rejected")
+	}
+	if _, err := parseBeacon([]byte("IPXQ\x01\x00\x00\x00")); err == nil {
+		t.Error("Expected a bad magic to be rejected")
+	}
+}
+
+func TestServerHandleBeacon(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Beacon = true
+	srv, err := NewServer(cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.captureError.Store("no capture")
+	other := net.HardwareAddr{0x02, 0, 0, 0, 0, 9}
+
+	announce := beaconFrame(other, beaconMsg{kind: beaconAnnounce, peers: 2, nodeID: "other", hostname: "pc2", listen: ":9000"})
+	if !srv.handleBeacon(announce, viaInterface) {
+		t.Fatal("Expected a beacon to be consumed")
+	}
+	srv.handleBeacon(announce, viaInterface)
+	st := srv.CollectStats().Beacon
+	if st == nil || len(st.Beacons) != 1 {
+		t.Fatalf("Expected one relay heard, got %+v", st)
+	}
+	if b := st.Beacons[0]; b.ID != "other" || b.Peers != 2 || b.Listen != ":9000" || b.Count != 2 || b.Via != viaInterface {
+		t.Errorf("Expected the announcement recorded, got %+v", b)
+	}
+
+	// Our own beacon seen again is not a relay on the segment.
+	srv.handleBeacon(beaconFrame(other, beaconMsg{kind: beaconAnnounce, nodeID: srv.nodeID}), viaInterface)
+	if n := len(srv.CollectStats().Beacon.Beacons); n != 1 {
+		t.Errorf("Expected our own beacon ignored, got %d relays", n)
+	}
+
+	// Queries are answered, but at most once per beaconMinReply.
+	query := beaconFrame(other, beaconMsg{kind: beaconQuery})
+	srv.handleBeacon(query, viaEmulator)
+	srv.handleBeacon(query, viaEmulator)
+	if st := srv.CollectStats().Beacon; st.Queries != 1 || st.Sent != 1 {
+		t.Errorf("Expected one query answered, got %d queries and %d sent", st.Queries, st.Sent)
+	}
+
+	if srv.handleBeacon(bridgeFrame(other, other), viaInterface) {
+		t.Error("Expected other sockets to pass through")
+	}
+	if !srv.remoteBeacon(announce) {
+		t.Error("Expected beacons from peers to stay off the segment")
+	}
+}
+
+func TestServerBeaconOff(t *testing.T) {
+	srv, err := NewServer(config.DefaultConfig(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := beaconFrame(net.HardwareAddr{0x02, 0, 0, 0, 0, 9}, beaconMsg{kind: beaconQuery})
+	if srv.handleBeacon(frame, viaInterface) || srv.remoteBeacon(frame) {
+		t.Error("Expected the beacon socket relayed like any other with the beacon off")
+	}
+	if srv.CollectStats().Beacon != nil {
+		t.Error("Expected no beacon stats with the beacon off")
+	}
+}
@@ -0,0 +1,52 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Capture interface and BPF filter, changed without restarting the relay
+
+package relay
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/mlapointe/ipxtransporter/internal/capture"
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+)
+
+func newCapturer(cfg *config.Config) *capture.Capturer {
+	c := capture.NewCapturer(cfg.Interface)
+	c.SetFilter(cfg.CaptureFilter)
+	return c
+}
+
+// RestartCapture moves the capture to iface with the BPF filter, the
+// default if empty, while the peer links stay up. A filter that does not
+// compile is refused before the capture is touched, and if iface cannot be
+// opened the capture goes on as it was. The caller saves the settings.
+func (s *Server) RestartCapture(iface, filter string) error {
+	switch {
+	case s.demoMode:
+		return errors.New("demo mode does not capture")
+	case s.relayOnly:
+		return errors.New("the relay was started without a capture interface; set interface and restart it")
+	case iface == "":
+		return errors.New("no interface specified")
+	}
+	if filter != "" {
+		if err := capture.CheckFilter(filter); err != nil {
+			return fmt.Errorf("capture filter: %w", err)
+		}
+	}
+	op := s.beginOp(opCaptureRestart, iface)
+	err := s.capturer.Restart(iface, filter)
+	s.endOp(op, err)
+	if err != nil {
+		return err
+	}
+	s.captureError.Store("")
+	if filter == "" {
+		filter = capture.DefaultFilter
+	}
+	logger.Capture.Info("Capturing on %s with filter %q", iface, filter)
+	return nil
+}
@@ -0,0 +1,31 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for changing the capture without a restart
+
+package relay
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+)
+
+func TestRestartCaptureRefused(t *testing.T) {
+	srv, err := NewServer(config.DefaultConfig(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.RestartCapture("eth1", ""); err == nil || !strings.Contains(err.Error(), "without a capture interface") {
+		t.Errorf("Expected a relay without an interface to refuse, got %v", err)
+	}
+	if ops := srv.operations(time.Now()); len(ops) != 0 {
+		t.Errorf("Expected no capture restart begun, got %+v", ops)
+	}
+
+	srv.SetDemoMode(true)
+	if err := srv.RestartCapture("eth1", ""); err == nil || !strings.Contains(err.Error(), "demo") {
+		t.Errorf("Expected demo mode to refuse, got %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Change notifications for the TUI
+
+package relay
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+// counterTick is how often the traffic counters are looked at. Counting
+// each packet as a change would cost the relay path; a quiet relay still
+// reports nothing.
+const counterTick = 500 * time.Millisecond
+
+// Changes reports what changes in the stats, so the TUI redraws when and
+// what it has to instead of polling.
+func (s *Server) Changes() *stats.Changes {
+	return s.changes
+}
+
+// runChanges notifies logged messages as they come, and moved counters
+// and operations in flight (for their spinner) every counterTick. Peers,
+// the topology and operations starting or ending are notified where they
+// change.
+func (s *Server) runChanges(ctx context.Context) {
+	_, logs, cancel := logger.Subscribe(64)
+	defer cancel()
+	ticker := time.NewTicker(counterTick)
+	defer ticker.Stop()
+	var last [4]uint64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-logs:
+			s.changes.Notify(stats.ChangeLogs)
+		case <-ticker.C:
+			totals := [4]uint64{
+				atomic.LoadUint64(&s.totalReceived),
+				atomic.LoadUint64(&s.totalForwarded),
+				atomic.LoadUint64(&s.totalDropped),
+				atomic.LoadUint64(&s.totalErrors),
+			}
+			if totals != last {
+				last = totals
+				s.changes.Notify(stats.ChangeCounters)
+			}
+			if s.opsInFlight() {
+				s.changes.Notify(stats.ChangeOps)
+			}
+		}
+	}
+}
@@ -0,0 +1,58 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for change notifications
+
+package relay
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+// waitChange waits for changes holding want, or fails.
+func waitChange(t *testing.T, n *stats.Changes, want stats.Change) {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case <-n.C():
+			if n.Take()&want == want {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("Timed out waiting for change %b", want)
+		}
+	}
+}
+
+func TestChanges(t *testing.T) {
+	srv, err := NewServer(config.DefaultConfig(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go srv.runChanges(ctx)
+	n := srv.Changes()
+
+	atomic.AddUint64(&srv.totalReceived, 1)
+	waitChange(t, n, stats.ChangeCounters)
+
+	// A quiet relay reports nothing.
+	n.Take()
+	time.Sleep(2 * counterTick)
+	if c := n.Take(); c&stats.ChangeCounters != 0 {
+		t.Errorf("Expected no counter change while idle, got %b", c)
+	}
+
+	id := srv.beginOp(opDial, "10.0.0.1:8787")
+	waitChange(t, n, stats.ChangeOps)
+	srv.endOp(id, nil)
+	srv.markTopologyDirty()
+	waitChange(t, n, stats.ChangeOps|stats.ChangePeers)
+}
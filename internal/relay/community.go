@@ -0,0 +1,195 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Opt-in community statistics: coarse aggregates for adoption numbers
+
+package relay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+const (
+	communityPeriod = 24 * time.Hour
+	communityCheck  = time.Hour // how often whether a report is due is checked
+	communityJitter = time.Hour // random delay of the first report after a start
+)
+
+// communityState tracks the reports sent to community_stats_url.
+type communityState struct {
+	closedBytes atomic.Uint64 // sent on links that have closed
+
+	mu        sync.Mutex
+	lastBytes uint64 // relayedBytes at the last report
+	lastSent  time.Time
+	lastError string
+}
+
+// Buckets of stats.CommunityReport, upper bounds exclusive.
+var (
+	nodeBuckets  = []int{2, 5, 10, 25, 50, 100}
+	byteBuckets  = []uint64{1 << 20, 10 << 20, 100 << 20, 1 << 30, 10 << 30, 100 << 30, 1 << 40}
+	byteBucketsN = []string{"<1 MB", "1-10 MB", "10-100 MB", "100 MB-1 GB", "1-10 GB", "10-100 GB", "100 GB-1 TB", ">=1 TB"}
+)
+
+// nodeBucket hides the exact size of a mesh of n nodes.
+func nodeBucket(n int) string {
+	lo := 1
+	for _, hi := range nodeBuckets {
+		if n < hi {
+			if hi-1 == lo {
+				return fmt.Sprint(lo)
+			}
+			return fmt.Sprintf("%d-%d", lo, hi-1)
+		}
+		lo = hi
+	}
+	return fmt.Sprintf("%d+", lo)
+}
+
+// byteBucket hides the exact traffic of n bytes to within a power of ten.
+func byteBucket(n uint64) string {
+	if n == 0 {
+		return "0"
+	}
+	for i, hi := range byteBuckets {
+		if n < hi {
+			return byteBucketsN[i]
+		}
+	}
+	return byteBucketsN[len(byteBucketsN)-1]
+}
+
+// relayedBytes is what this node has sent on its links since it started.
+func (s *Server) relayedBytes() uint64 {
+	total := s.community.closedBytes.Load()
+	s.peersMu.RLock()
+	for _, p := range s.peers {
+		total += p.GetStats().SentBytes
+	}
+	s.peersMu.RUnlock()
+	return total
+}
+
+// CommunityReport returns the report the next submission would send: the
+// size of the mesh and the traffic relayed since the last one, both in
+// buckets, and community_country. Nothing identifies the node or its
+// peers.
+func (s *Server) CommunityReport() stats.CommunityReport {
+	s.peersMu.RLock()
+	nodes := len(s.topologyLocked(nil))
+	s.peersMu.RUnlock()
+	relayed := s.relayedBytes()
+	c := &s.community
+	c.mu.Lock()
+	period := relayed - min(c.lastBytes, relayed)
+	c.mu.Unlock()
+	return stats.CommunityReport{
+		Schema:  1,
+		Nodes:   nodeBucket(nodes),
+		Relayed: byteBucket(period),
+		Country: strings.ToUpper(s.cfg.CommunityCountry),
+	}
+}
+
+// CommunityStatus shows whether community statistics are submitted, where,
+// and the exact report the next submission would send.
+func (s *Server) CommunityStatus() stats.CommunityStatus {
+	c := &s.community
+	c.mu.Lock()
+	st := stats.CommunityStatus{
+		Enabled:   s.cfg.CommunityStats,
+		URL:       s.cfg.CommunityStatsURL,
+		LastSent:  c.lastSent,
+		LastError: c.lastError,
+	}
+	c.mu.Unlock()
+	st.Preview = s.CommunityReport()
+	return st
+}
+
+// runCommunity submits a report every communityPeriod while
+// community_stats is on, which may change while running.
+func (s *Server) runCommunity(ctx context.Context) {
+	first := time.NewTimer(rand.N(communityJitter))
+	defer first.Stop()
+	select {
+	case <-ctx.Done():
+		return
+	case <-first.C:
+	}
+	ticker := time.NewTicker(communityCheck)
+	defer ticker.Stop()
+	for {
+		c := &s.community
+		c.mu.Lock()
+		due := time.Since(c.lastSent) >= communityPeriod
+		c.mu.Unlock()
+		if due && s.cfg.CommunityStats {
+			s.submitCommunity(ctx)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) submitCommunity(ctx context.Context) {
+	relayed := s.relayedBytes()
+	report := s.CommunityReport()
+	err := s.postCommunity(ctx, report)
+	c := &s.community
+	c.mu.Lock()
+	c.lastSent = time.Now()
+	c.lastError = ""
+	if err != nil {
+		c.lastError = err.Error()
+	} else {
+		c.lastBytes = relayed
+	}
+	c.mu.Unlock()
+	if err != nil {
+		logger.Relay.Warn("Community statistics: %v", err)
+		return
+	}
+	logger.Relay.Info("Community statistics submitted: %s nodes, %s relayed", report.Nodes, report.Relayed)
+}
+
+func (s *Server) postCommunity(ctx context.Context, report stats.CommunityReport) error {
+	if s.cfg.CommunityStatsURL == "" {
+		return fmt.Errorf("community_stats_url is not set")
+	}
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.CommunityStatsURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s answered %s", s.cfg.CommunityStatsURL, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for community statistics
+
+package relay
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+func TestCommunityBuckets(t *testing.T) {
+	for n, want := range map[int]string{1: "1", 3: "2-4", 9: "5-9", 30: "25-49", 100: "100+", 5000: "100+"} {
+		if got := nodeBucket(n); got != want {
+			t.Errorf("nodeBucket(%d): expected %q, got %q", n, want, got)
+		}
+	}
+	for n, want := range map[uint64]string{0: "0", 1000: "<1 MB", 3 << 30: "1-10 GB", 5 << 40: ">=1 TB"} {
+		if got := byteBucket(n); got != want {
+			t.Errorf("byteBucket(%d): expected %q, got %q", n, want, got)
+		}
+	}
+}
+
+func TestCommunitySubmit(t *testing.T) {
+	var got stats.CommunityReport
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer ts.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.CommunityStatsURL = ts.URL
+	cfg.CommunityCountry = "de"
+	srv, err := NewServer(cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.community.closedBytes.Add(50 << 20)
+
+	preview := srv.CommunityStatus().Preview
+	want := stats.CommunityReport{Schema: 1, Nodes: "1", Relayed: "10-100 MB", Country: "DE"}
+	if preview != want {
+		t.Fatalf("Expected preview %+v, got %+v", want, preview)
+	}
+	srv.submitCommunity(context.Background())
+	if got != preview {
+		t.Errorf("Expected the previewed report submitted, got %+v", got)
+	}
+	if st := srv.CommunityStatus(); st.LastSent.IsZero() || st.LastError != "" || st.Preview.Relayed != "0" {
+		t.Errorf("Expected a clean submission and the period restarted, got %+v", st)
+	}
+}
@@ -0,0 +1,100 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// IPX network number conflict detection
+
+package relay
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+const networkObservationTTL = 5 * time.Minute
+
+// detectConflicts compares the IPX networks seen on the local segment with
+// those advertised by or observed from each peer link. In a tree overlay
+// every remote segment is reachable through exactly one link, so a network
+// number claimed by more than one source means two segments share it.
+func (s *Server) detectConflicts() {
+	sources := make(map[uint32]map[string]bool)
+	claim := func(network uint32, source string) {
+		if network == 0 {
+			return
+		}
+		if sources[network] == nil {
+			sources[network] = make(map[string]bool)
+		}
+		sources[network][source] = true
+	}
+
+	for _, n := range s.macTable.LocalNetworks() {
+		claim(n, "Local")
+	}
+	s.peersMu.RLock()
+	for _, p := range s.peers {
+		advertised, observed := p.Networks(networkObservationTTL)
+		if !s.honors(p, TrustKnown) {
+			advertised = nil
+		}
+		for _, n := range advertised {
+			claim(n, p.ID)
+		}
+		for _, n := range observed {
+			claim(n, p.ID)
+		}
+	}
+	s.peersMu.RUnlock()
+
+	s.conflictsMu.Lock()
+	defer s.conflictsMu.Unlock()
+
+	previous := make(map[uint32]stats.NetworkConflict)
+	for _, c := range s.conflicts {
+		previous[c.Network] = c
+	}
+
+	var current []stats.NetworkConflict
+	for network, srcs := range sources {
+		if len(srcs) < 2 {
+			continue
+		}
+		ids := make([]string, 0, len(srcs))
+		for id := range srcs {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+
+		c := stats.NetworkConflict{
+			Network:    network,
+			NetworkStr: fmt.Sprintf("%08X", network),
+			Sources:    ids,
+			DetectedAt: time.Now(),
+		}
+		if prev, ok := previous[network]; ok {
+			c.DetectedAt = prev.DetectedAt
+		}
+		if prev, ok := previous[network]; !ok || strings.Join(prev.Sources, ",") != strings.Join(ids, ",") {
+			logger.Relay.Error("IPX network %s is claimed by multiple segments: %s", c.NetworkStr, strings.Join(ids, ", "))
+		}
+		current = append(current, c)
+	}
+	for network, prev := range previous {
+		if _, ok := sources[network]; !ok || len(sources[network]) < 2 {
+			logger.Relay.Info("IPX network %s conflict cleared", prev.NetworkStr)
+		}
+	}
+
+	sort.Slice(current, func(i, j int) bool { return current[i].Network < current[j].Network })
+	s.conflicts = current
+}
+
+func (s *Server) networkConflicts() []stats.NetworkConflict {
+	s.conflictsMu.Lock()
+	defer s.conflictsMu.Unlock()
+	return append([]stats.NetworkConflict(nil), s.conflicts...)
+}
@@ -0,0 +1,82 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// IPX address coordinator: hand out node and network numbers to virtual clients
+
+package relay
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"sync"
+)
+
+// AddressCoordinator assigns IPX node numbers to virtual clients so that
+// emulators attached to different relays never pick the same address. Nodes
+// are locally administered MACs: 02, three bytes of our node ID, then a
+// per-relay counter. A client re-registering from the same transport
+// address keeps its node until it is released.
+type AddressCoordinator struct {
+	mu      sync.Mutex
+	prefix  [3]byte
+	network uint32
+	next    uint16
+	leases  map[string]net.HardwareAddr // by client transport address
+	inUse   func(node net.HardwareAddr) bool
+}
+
+func NewAddressCoordinator(nodeID string, network uint32) *AddressCoordinator {
+	c := &AddressCoordinator{
+		network: network,
+		next:    1,
+		leases:  make(map[string]net.HardwareAddr),
+	}
+	b, _ := hex.DecodeString(nodeID)
+	copy(c.prefix[:], b)
+	return c
+}
+
+// SetInUse registers a check for nodes already seen elsewhere in the mesh,
+// which are skipped when assigning.
+func (c *AddressCoordinator) SetInUse(fn func(node net.HardwareAddr) bool) {
+	c.mu.Lock()
+	c.inUse = fn
+	c.mu.Unlock()
+}
+
+// Assign returns the network and node for client, reusing its lease if it
+// has one. The node is nil if the pool is exhausted.
+func (c *AddressCoordinator) Assign(client string) (uint32, net.HardwareAddr) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if node, ok := c.leases[client]; ok {
+		return c.network, node
+	}
+
+	taken := make(map[string]bool, len(c.leases))
+	for _, node := range c.leases {
+		taken[string(node)] = true
+	}
+	for range 0xffff {
+		node := net.HardwareAddr{0x02, c.prefix[0], c.prefix[1], c.prefix[2], 0, 0}
+		binary.BigEndian.PutUint16(node[4:], c.next)
+		c.next++
+		if c.next == 0 {
+			c.next = 1
+		}
+		if taken[string(node)] || (c.inUse != nil && c.inUse(node)) {
+			continue
+		}
+		c.leases[client] = node
+		return c.network, node
+	}
+	return c.network, nil
+}
+
+// Release frees the node leased to client.
+func (c *AddressCoordinator) Release(client string) {
+	c.mu.Lock()
+	delete(c.leases, client)
+	c.mu.Unlock()
+}
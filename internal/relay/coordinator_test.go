@@ -0,0 +1,100 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for the IPX address coordinator and IPXNET virtual clients
+
+package relay
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+)
+
+func TestAddressCoordinator(t *testing.T) {
+	c := NewAddressCoordinator("a1b2c3d4", 0x1234)
+	busy := net.HardwareAddr{0x02, 0xa1, 0xb2, 0xc3, 0x00, 0x02}
+	c.SetInUse(func(node net.HardwareAddr) bool { return bytes.Equal(node, busy) })
+
+	network, first := c.Assign("10.0.0.5:213")
+	if network != 0x1234 || first.String() != "02:a1:b2:c3:00:01" {
+		t.Fatalf("Expected 00001234/02:a1:b2:c3:00:01, got %08X/%s", network, first)
+	}
+	if _, again := c.Assign("10.0.0.5:213"); !bytes.Equal(again, first) {
+		t.Errorf("Expected the same client to keep %s, got %s", first, again)
+	}
+	_, second := c.Assign("10.0.0.6:213")
+	if second.String() != "02:a1:b2:c3:00:03" {
+		t.Errorf("Expected the in-use node to be skipped, got %s", second)
+	}
+
+	c.Release("10.0.0.5:213")
+	if _, renewed := c.Assign("10.0.0.5:213"); bytes.Equal(renewed, first) || bytes.Equal(renewed, second) {
+		t.Errorf("Expected a fresh node after release, got %s", renewed)
+	}
+}
+
+func TestServerIPXNetRegistration(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.IPXNetListenAddr = "127.0.0.1:0"
+	cfg.AssignAddresses = true
+	cfg.VirtualNetwork = "CAFE"
+	srv, err := NewServer(cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go srv.runIPXNet(ctx)
+
+	var server *net.UDPAddr
+	for i := 0; i < 100 && server == nil; i++ {
+		srv.ipxnet.mu.RLock()
+		if srv.ipxnet.conn != nil {
+			server = srv.ipxnet.conn.LocalAddr().(*net.UDPAddr)
+		}
+		srv.ipxnet.mu.RUnlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+	if server == nil {
+		t.Fatal("IPXNET host did not start")
+	}
+
+	client, err := net.DialUDP("udp", nil, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	reg := make([]byte, ipxnetHeaderLen)
+	binary.BigEndian.PutUint16(reg[0:2], 0xffff)
+	binary.BigEndian.PutUint16(reg[2:4], ipxnetHeaderLen)
+	binary.BigEndian.PutUint16(reg[16:18], ipxnetRegSocket)
+	if _, err := client.Write(reg); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	reply := make([]byte, 64)
+	n, err := client.Read(reply)
+	if err != nil {
+		t.Fatalf("Expected a registration reply, got %v", err)
+	}
+	if n != ipxnetHeaderLen || binary.BigEndian.Uint16(reply[0:2]) != 0xffff {
+		t.Fatalf("Expected a 30-byte reply with checksum FFFF, got % x", reply[:n])
+	}
+	if network := binary.BigEndian.Uint32(reply[6:10]); network != 0xcafe {
+		t.Errorf("Expected network 0000CAFE, got %08X", network)
+	}
+	node := net.HardwareAddr(reply[10:16])
+	if node[0] != 0x02 || !srv.macTable.Known(node) {
+		t.Errorf("Expected an assigned node recorded in the MAC table, got %s", node)
+	}
+	if clients := srv.collectVirtualClients(); len(clients) != 1 || clients[0].Node != node.String() || !clients[0].Assigned {
+		t.Errorf("Expected one assigned virtual client %s, got %+v", node, clients)
+	}
+}
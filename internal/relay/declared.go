@@ -0,0 +1,184 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Declared networks: what each link says it reaches, checked against traffic
+
+package relay
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/ipx"
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+	"github.com/mlapointe/ipxtransporter/internal/peer"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+// declareGrace is how long traffic from an undeclared network is tolerated
+// before it counts as an anomaly: a station new to a segment sends before
+// the next status frame declares its network.
+const declareGrace = 2 * statusInterval
+
+// declaredState holds the traffic seen from networks a peer did not
+// declare, by peer ID and network.
+type declaredState struct {
+	mu        sync.Mutex
+	anomalies map[anomalyKey]*stats.NetworkAnomaly
+}
+
+type anomalyKey struct {
+	peer    string
+	network uint32
+}
+
+func newDeclaredState() declaredState {
+	return declaredState{anomalies: make(map[anomalyKey]*stats.NetworkAnomaly)}
+}
+
+func parseLocalNetworks(list []string) ([]uint32, error) {
+	var out []uint32
+	for _, s := range list {
+		n, err := parseVirtualNetwork(s)
+		if err != nil || n == 0 {
+			return nil, fmt.Errorf("local_networks: %q is not a non-zero IPX network of up to 8 hex digits", s)
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
+
+// declaredFor returns the networks we declare to p: those of our local
+// segment, configured or learned, and those our other links declared to us
+// if we trust them with that. Must be called with peersMu held.
+func (s *Server) declaredFor(to *peer.Peer) []uint32 {
+	nets := append(slices.Clone(s.localNets), s.macTable.LocalNetworks()...)
+	for _, p := range s.peers {
+		if p == to || !s.honors(p, TrustKnown) {
+			continue
+		}
+		declared, _ := p.Declared()
+		nets = append(nets, declared...)
+	}
+	slices.Sort(nets)
+	return slices.Compact(nets)
+}
+
+// checkDeclared reports whether a frame from p may be relayed. Frames from
+// a network p did not declare are counted as a possible anomaly and, with
+// strict_networks, dropped.
+func (s *Server) checkDeclared(p *peer.Peer, frame []byte) bool {
+	declared, ok := p.Declared()
+	if !ok {
+		return true
+	}
+	h, err := ipx.Parse(frame)
+	if err != nil || h.Src.Network == 0 || slices.Contains(declared, h.Src.Network) {
+		return true
+	}
+	now := time.Now()
+	key := anomalyKey{p.ID, h.Src.Network}
+	d := &s.declared
+	d.mu.Lock()
+	a, ok := d.anomalies[key]
+	if !ok {
+		a = &stats.NetworkAnomaly{
+			Network:    h.Src.Network,
+			NetworkStr: fmt.Sprintf("%08X", h.Src.Network),
+			Peer:       p.ID,
+			FirstSeen:  now,
+			Dropped:    s.flags.strictNetworks.Load(),
+		}
+		d.anomalies[key] = a
+	}
+	a.NodeID = p.NodeID()
+	a.LastSeen = now
+	a.Packets++
+	d.mu.Unlock()
+	return !s.flags.strictNetworks.Load()
+}
+
+// detectAnomalies drops the records of networks since declared or no
+// longer seen, and logs those undeclared past declareGrace.
+func (s *Server) detectAnomalies(now time.Time) {
+	declared := make(map[string][]uint32)
+	s.peersMu.RLock()
+	for id, p := range s.peers {
+		declared[id], _ = p.Declared()
+	}
+	s.peersMu.RUnlock()
+
+	d := &s.declared
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for key, a := range d.anomalies {
+		nets, linked := declared[key.peer]
+		if !linked || slices.Contains(nets, key.network) || now.Sub(a.LastSeen) > networkObservationTTL {
+			delete(d.anomalies, key)
+			continue
+		}
+		// Logged once, on the tick the grace runs out.
+		if age := now.Sub(a.FirstSeen); age >= declareGrace && age < declareGrace+statusInterval {
+			logger.Relay.Warn("Peer %s (node %s) sends from IPX network %s it did not declare (%d packets)",
+				a.Peer, a.NodeID, a.NetworkStr, a.Packets)
+		}
+	}
+}
+
+// networkAnomalies returns the anomalies undeclared past declareGrace.
+func (s *Server) networkAnomalies(now time.Time) []stats.NetworkAnomaly {
+	d := &s.declared
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var out []stats.NetworkAnomaly
+	for _, a := range d.anomalies {
+		if now.Sub(a.FirstSeen) >= declareGrace {
+			out = append(out, *a)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Network != out[j].Network {
+			return out[i].Network < out[j].Network
+		}
+		return out[i].Peer < out[j].Peer
+	})
+	return out
+}
+
+// collectRoutes lists the networks of our segment and those each link
+// declared or was seen sending from. Must be called with peersMu held.
+func (s *Server) collectRoutes() []stats.NetworkRoute {
+	var out []stats.NetworkRoute
+	add := func(n uint32, via, nodeID, source string) {
+		out = append(out, stats.NetworkRoute{Network: n, NetworkStr: fmt.Sprintf("%08X", n), Via: via, NodeID: nodeID, Source: source})
+	}
+	for _, n := range s.localNets {
+		add(n, "Local", s.nodeID, "configured")
+	}
+	for _, n := range s.macTable.LocalNetworks() {
+		if !slices.Contains(s.localNets, n) {
+			add(n, "Local", s.nodeID, "learned")
+		}
+	}
+	for _, p := range s.peers {
+		declared, _ := p.Declared()
+		for _, n := range declared {
+			add(n, p.ID, p.NodeID(), "declared")
+		}
+		_, observed := p.Networks(networkObservationTTL)
+		for _, n := range observed {
+			if !slices.Contains(declared, n) {
+				add(n, p.ID, p.NodeID(), "observed")
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Network != out[j].Network {
+			return out[i].Network < out[j].Network
+		}
+		return out[i].Via < out[j].Via
+	})
+	return out
+}
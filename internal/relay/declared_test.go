@@ -0,0 +1,140 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for declared IPX networks
+
+package relay
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/ipx"
+	"github.com/mlapointe/ipxtransporter/internal/peer"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+// networkFrame builds an Ethernet_II IPX frame sent from the given network.
+func networkFrame(network uint32) []byte {
+	pkt := make([]byte, 30)
+	binary.BigEndian.PutUint16(pkt[0:2], 0xffff)
+	binary.BigEndian.PutUint16(pkt[2:4], 30)
+	binary.BigEndian.PutUint32(pkt[18:22], network)
+	copy(pkt[22:28], net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	frame, _ := ipx.EncapEthernetII(pkt)
+	return frame
+}
+
+func TestParseLocalNetworks(t *testing.T) {
+	nets, err := parseLocalNetworks([]string{"A01", "0000BEEF"})
+	if err != nil || len(nets) != 2 || nets[0] != 0xA01 || nets[1] != 0xBEEF {
+		t.Errorf("Expected [A01 BEEF], got %v (%v)", nets, err)
+	}
+	for _, bad := range []string{"", "0", "xyz", "123456789"} {
+		if _, err := parseLocalNetworks([]string{bad}); err == nil {
+			t.Errorf("Expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestServerDeclaredNetworks(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	hcfg := config.DefaultConfig()
+	hcfg.LocalNetworks = []string{"00000A01"}
+	hub, err := NewServer(hcfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lcfg := config.DefaultConfig()
+	lcfg.StrictNetworks = true
+	leaf, err := NewServer(lcfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		hub.handleNewConn(ctx, conn, hub.peerRelayChan, "")
+	}()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	go leaf.handleNewConn(ctx, conn, leaf.peerRelayChan, l.Addr().String())
+
+	var link *peer.Peer
+	for link == nil {
+		leaf.peersMu.RLock()
+		for _, p := range leaf.peers {
+			if _, ok := p.Declared(); ok {
+				link = p
+			}
+		}
+		leaf.peersMu.RUnlock()
+		select {
+		case <-ctx.Done():
+			t.Fatal("Timed out waiting for the hub's declaration")
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	var route *stats.NetworkRoute
+	for _, r := range leaf.CollectStats().Routes {
+		if r.Network == 0xA01 {
+			route = &r
+		}
+	}
+	if route == nil || route.Via != link.ID || route.Source != "declared" || route.NodeID != hub.NodeID() {
+		t.Errorf("Expected network 00000A01 routed via the hub, got %+v", route)
+	}
+
+	if !leaf.checkDeclared(link, networkFrame(0xA01)) {
+		t.Error("Expected a frame from a declared network to be relayed")
+	}
+	if leaf.checkDeclared(link, networkFrame(0xBEEF)) {
+		t.Error("Expected a frame from an undeclared network to be dropped in strict mode")
+	}
+	now := time.Now()
+	if a := leaf.networkAnomalies(now); len(a) != 0 {
+		t.Errorf("Expected no anomaly within the grace period, got %+v", a)
+	}
+	a := leaf.networkAnomalies(now.Add(declareGrace))
+	if len(a) != 1 || a[0].Network != 0xBEEF || a[0].NodeID != hub.NodeID() || !a[0].Dropped || a[0].Packets != 1 {
+		t.Fatalf("Expected one dropped anomaly for 0000BEEF, got %+v", a)
+	}
+
+	// Once the hub learns the network itself, it is declared and the anomaly clears.
+	hub.localNets = append(hub.localNets, 0xBEEF)
+	hub.peersMu.RLock()
+	for _, p := range hub.peers {
+		p.SendControl(peer.Control{Type: peer.ControlStatus, Declares: true, Declared: hub.declaredFor(p)})
+	}
+	hub.peersMu.RUnlock()
+	for {
+		if declared, _ := link.Declared(); len(declared) == 2 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatal("Timed out waiting for the new declaration")
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	leaf.detectAnomalies(now.Add(declareGrace))
+	if a := leaf.networkAnomalies(now.Add(declareGrace)); len(a) != 0 {
+		t.Errorf("Expected the anomaly cleared once declared, got %+v", a)
+	}
+}
@@ -6,14 +6,17 @@ package relay
 
 import (
 	"crypto/sha256"
+	"sync/atomic"
 	"time"
 
 	lru "github.com/hashicorp/golang-lru/v2"
 )
 
 type DedupCache struct {
-	cache *lru.Cache[string, bool]
-	ttl   time.Duration
+	cache   *lru.Cache[string, bool]
+	ttl     time.Duration
+	lookups atomic.Uint64
+	hits    atomic.Uint64
 }
 
 func NewDedupCache(size int, ttlSeconds int) (*DedupCache, error) {
@@ -36,5 +39,15 @@ func (d *DedupCache) IsDuplicate(data []byte) bool {
 	key := string(hash[:])
 
 	ok, _ := d.cache.ContainsOrAdd(key, true)
+	d.lookups.Add(1)
+	if ok {
+		d.hits.Add(1)
+	}
 	return ok
 }
+
+// Stats returns how many packets were checked and how many of them were
+// duplicates.
+func (d *DedupCache) Stats() (lookups, hits uint64) {
+	return d.lookups.Load(), d.hits.Load()
+}
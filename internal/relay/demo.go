@@ -0,0 +1,222 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Demo traffic patterns modeled on real IPX games and NetWare networks
+
+package relay
+
+import (
+	"encoding/binary"
+	"math"
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/ipx"
+	"github.com/mlapointe/ipxtransporter/internal/peer"
+)
+
+// demoFlow is a second's worth of packets of one kind.
+type demoFlow struct {
+	frame ipx.FrameType
+	dst   uint16 // destination socket, naming the protocol
+	size  int    // IPX packet bytes
+	pkts  int
+}
+
+// demoPattern generates the flows of second t of the demo for a node with
+// the given number of demo peers.
+type demoPattern struct {
+	name string
+	gen  func(t, peers int, rnd *rand.Rand) []demoFlow
+}
+
+// demoPatterns lists the selectable presets. The flat pattern keeps the
+// configured packet rate and is the default.
+var demoPatterns = []demoPattern{
+	{"flat", nil},
+	{"doom", doomTraffic},
+	{"sap", sapTraffic},
+	{"login", loginTraffic},
+	{"lan-party", func(t, peers int, rnd *rand.Rand) []demoFlow {
+		return append(doomTraffic(t, peers, rnd), sapTraffic(t, peers, rnd)...)
+	}},
+}
+
+func demoPatternNames() []string {
+	names := make([]string, len(demoPatterns))
+	for i, p := range demoPatterns {
+		names[i] = p.name
+	}
+	return names
+}
+
+func findDemoPattern(name string) (demoPattern, bool) {
+	for _, p := range demoPatterns {
+		if p.name == name {
+			return p, true
+		}
+	}
+	return demoPattern{}, false
+}
+
+const (
+	socketNCP  = 0x0451
+	socketSAP  = 0x0452
+	socketRIP  = 0x0453
+	socketDoom = 0x869B
+	ipxHdrLen  = 30
+
+	// demoFlatSize is the frame size the flat pattern's packets count as.
+	demoFlatSize = 64
+)
+
+// doomTraffic models a 4-player IPX Doom game: during a level every node
+// sends its ticcmds to every other node 35 times a second, with a burst of
+// resends after the occasional stall. Levels end in an intermission where
+// only keep-alives flow, then a short silence while the next one loads.
+func doomTraffic(t, peers int, rnd *rand.Rand) []demoFlow {
+	players := min(max(peers, 1)+1, 4)
+	const level, intermission, loading = 150, 12, 3
+	phase := t % (level + intermission + loading)
+	switch {
+	case phase >= level+intermission:
+		return nil
+	case phase >= level:
+		return []demoFlow{{ipx.FrameEthernetII, socketDoom, ipxHdrLen + 8, players}}
+	}
+	pkts := players * (players - 1) * 35
+	pkts += rnd.IntN(pkts/10+1) - pkts/20
+	if rnd.IntN(25) == 0 {
+		pkts *= 2 + rnd.IntN(2)
+	}
+	// Header, checksum and one 8 byte ticcmd per player for two tics.
+	return []demoFlow{{ipx.FrameEthernetII, socketDoom, ipxHdrLen + 8 + 16*players, pkts}}
+}
+
+// sapTraffic models the background chatter of a NetWare network: each
+// server broadcasts its services and routes once a minute, staggered, and
+// workstations now and then ask for the nearest server.
+func sapTraffic(t, peers int, rnd *rand.Rand) []demoFlow {
+	var flows []demoFlow
+	servers := max(peers, 1)
+	for i := range servers {
+		if (t+7*i)%60 != 0 {
+			continue
+		}
+		services := 1 + i%7
+		networks := 1 + i%4
+		flows = append(flows,
+			demoFlow{ipx.Frame8023Raw, socketSAP, ipxHdrLen + 2 + 64*services, 1},
+			demoFlow{ipx.Frame8023Raw, socketRIP, ipxHdrLen + 2 + 8*networks, 1})
+	}
+	if rnd.IntN(3) == 0 {
+		flows = append(flows,
+			demoFlow{ipx.Frame8023Raw, socketSAP, ipxHdrLen + 4, 1},
+			demoFlow{ipx.Frame8023Raw, socketSAP, ipxHdrLen + 2 + 64, servers})
+	}
+	return flows
+}
+
+// loginTraffic models a NetWare login storm: a quiet office of idle
+// workstations sending watchdog replies, then every workstation logging in
+// within a minute, each running its login script and drive mappings over
+// NCP. The storm repeats every five minutes.
+func loginTraffic(t, peers int, rnd *rand.Rand) []demoFlow {
+	stations := 10 * max(peers, 1)
+	flows := []demoFlow{{ipx.Frame8023Raw, socketNCP, ipxHdrLen + 2, max(stations/30, 1)}}
+	const cycle, start, length = 300, 60, 60
+	phase := t % cycle
+	if phase < start || phase >= start+length {
+		return flows
+	}
+	// Logins ramp up, peak mid-storm and tail off.
+	x := float64(phase-start) / length
+	active := int(math.Round(float64(stations) * math.Sin(math.Pi*x)))
+	if active == 0 {
+		return flows
+	}
+	return append(flows,
+		demoFlow{ipx.Frame8023Raw, socketSAP, ipxHdrLen + 4, active / 4},
+		demoFlow{ipx.Frame8023Raw, socketNCP, ipxHdrLen + 40 + rnd.IntN(500), 60 * active})
+}
+
+// demoFrame builds a frame carrying an IPX packet of the given size to dst.
+func demoFrame(ft ipx.FrameType, dst uint16, size int) []byte {
+	packet := make([]byte, max(size, ipxHdrLen))
+	binary.BigEndian.PutUint16(packet[0:2], 0xffff)
+	binary.BigEndian.PutUint16(packet[2:4], uint16(len(packet)))
+	binary.BigEndian.PutUint32(packet[6:10], 0x00000001)
+	copy(packet[10:16], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	binary.BigEndian.PutUint16(packet[16:18], dst)
+	binary.BigEndian.PutUint32(packet[18:22], 0x00000001)
+	copy(packet[22:28], []byte{0x02, 0x00, 0x00, 0xde, 0x00, 0x01})
+	binary.BigEndian.PutUint16(packet[28:30], 0x4000)
+	if ft == ipx.FrameEthernetII {
+		frame, _ := ipx.EncapEthernetII(packet)
+		return frame
+	}
+	// Novell's raw 802.3: a length where Ethernet II has its type.
+	frame := make([]byte, 14+len(packet))
+	copy(frame[0:6], packet[10:16])
+	copy(frame[6:12], packet[22:28])
+	binary.BigEndian.PutUint16(frame[12:14], uint16(len(packet)))
+	copy(frame[14:], packet)
+	return frame
+}
+
+// demoTraffic adds second t of the selected pattern to the node's counters,
+// its socket and frame type tables and the demo peers.
+func (s *Server) demoTraffic(t int, rnd *rand.Rand, demoPeers []*peer.Peer) {
+	pattern, ok := findDemoPattern(s.demoPattern)
+	if !ok || pattern.gen == nil {
+		now := time.Now().Unix()
+		rx := uint64(s.demoPacketRate + int(now%int64(s.demoPacketRate/2+1)))
+		fwd := uint64(s.demoPacketRate - s.demoDropRate + int(now%int64(s.demoPacketRate/2+1)))
+		atomic.AddUint64(&s.totalReceived, rx)
+		atomic.AddUint64(&s.totalForwarded, fwd)
+		atomic.AddUint64(&s.receivedBytes, rx*demoFlatSize)
+		atomic.AddUint64(&s.forwardedBytes, fwd*demoFlatSize)
+		atomic.AddUint64(&s.totalDropped, uint64(now%int64(s.demoDropRate+1)))
+		if s.demoErrorRate > 0 && now%int64(s.demoErrorRate) == 0 {
+			atomic.AddUint64(&s.totalErrors, 1)
+		}
+		for _, p := range demoPeers {
+			p.UpdateDemoStats()
+		}
+		return
+	}
+
+	total, bytes := 0, 0
+	for _, f := range pattern.gen(t, len(demoPeers), rnd) {
+		frame := demoFrame(f.frame, f.dst, f.size)
+		bytes += f.pkts * len(frame)
+		for range f.pkts {
+			s.frames.AddRx(f.frame, len(frame))
+			s.sockets.AddRx(frame)
+		}
+		total += f.pkts
+		if len(demoPeers) == 0 {
+			continue
+		}
+		// Every peer sends its share and is sent everybody else's.
+		share := f.pkts / len(demoPeers)
+		for i, p := range demoPeers {
+			rx := share
+			if i < f.pkts%len(demoPeers) {
+				rx++
+			}
+			p.AddDemoTraffic(f.frame, len(frame), rx, f.pkts-rx)
+		}
+	}
+	dropped := min(rnd.IntN(s.demoDropRate+1), total)
+	atomic.AddUint64(&s.totalReceived, uint64(total))
+	atomic.AddUint64(&s.totalForwarded, uint64(total-dropped))
+	atomic.AddUint64(&s.receivedBytes, uint64(bytes))
+	if total > 0 {
+		atomic.AddUint64(&s.forwardedBytes, uint64(bytes*(total-dropped)/total))
+	}
+	atomic.AddUint64(&s.totalDropped, uint64(dropped))
+	if s.demoErrorRate > 0 && rnd.IntN(s.demoErrorRate) == 0 {
+		atomic.AddUint64(&s.totalErrors, 1)
+	}
+}
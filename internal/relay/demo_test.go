@@ -0,0 +1,109 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for demo traffic patterns
+
+package relay
+
+import (
+	"math/rand/v2"
+	"net"
+	"testing"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/ipx"
+	"github.com/mlapointe/ipxtransporter/internal/peer"
+)
+
+func flowPkts(flows []demoFlow) int {
+	n := 0
+	for _, f := range flows {
+		n += f.pkts
+	}
+	return n
+}
+
+func TestDemoPatternShapes(t *testing.T) {
+	rnd := rand.New(rand.NewPCG(1, 2))
+
+	// Four players at 35 tics a second during a level, then an
+	// intermission of keep-alives and silence while loading.
+	if n := flowPkts(doomTraffic(10, 5, rnd)); n < 4*3*35*9/10 {
+		t.Errorf("Expected Doom play at about %d pps, got %d", 4*3*35, n)
+	}
+	if n := flowPkts(doomTraffic(155, 5, rnd)); n != 4 {
+		t.Errorf("Expected 4 keep-alives during intermission, got %d", n)
+	}
+	if n := flowPkts(doomTraffic(163, 5, rnd)); n != 0 {
+		t.Errorf("Expected silence while loading, got %d", n)
+	}
+
+	quiet := flowPkts(loginTraffic(10, 5, rnd))
+	storm := flowPkts(loginTraffic(90, 5, rnd))
+	if storm < 100*quiet {
+		t.Errorf("Expected a login storm far above the idle %d pps, got %d", quiet, storm)
+	}
+
+	// Server 0 advertises on the minute, server 1 seven seconds earlier.
+	if n := flowPkts(sapTraffic(60, 2, rand.New(rand.NewPCG(0, 0)))); n < 2 {
+		t.Errorf("Expected a SAP and RIP broadcast on the minute, got %d packets", n)
+	}
+}
+
+func TestDemoFrame(t *testing.T) {
+	for _, tc := range []struct {
+		ft     ipx.FrameType
+		socket uint16
+	}{{ipx.FrameEthernetII, socketDoom}, {ipx.Frame8023Raw, socketNCP}} {
+		frame := demoFrame(tc.ft, tc.socket, 100)
+		if ft, _ := ipx.DetectFrameType(frame); ft != tc.ft {
+			t.Errorf("Expected frame type %s, got %s", tc.ft, ft)
+		}
+		h, err := ipx.Parse(frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ipx.ClassifySocket(h) != tc.socket || h.Length != 100 {
+			t.Errorf("Expected a 100 byte packet to socket %04X, got %d bytes to %04X", tc.socket, h.Length, h.Dst.Socket)
+		}
+	}
+}
+
+func TestServerDemoPattern(t *testing.T) {
+	srv, err := NewServer(config.DefaultConfig(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if srv.SetDemoPattern("quake") {
+		t.Error("Expected an unknown pattern to be rejected")
+	}
+	if !srv.SetDemoPattern("doom") {
+		t.Fatal("Expected the doom pattern to be accepted")
+	}
+	peers := []*peer.Peer{
+		peer.NewPeer("demo-node-0", &fakeConn{remoteAddr: &net.TCPAddr{IP: net.ParseIP("8.0.0.1"), Port: 8787}}, ""),
+		peer.NewPeer("demo-node-1", &fakeConn{remoteAddr: &net.TCPAddr{IP: net.ParseIP("8.0.0.2"), Port: 8787}}, ""),
+	}
+	srv.demoTraffic(10, rand.New(rand.NewPCG(1, 2)), peers)
+
+	st := srv.CollectStats()
+	// Two peers and this node make three players.
+	if st.TotalReceived < 3*2*35*9/10 {
+		t.Errorf("Expected Doom traffic, got %d packets", st.TotalReceived)
+	}
+	var doom uint64
+	for _, sc := range st.Sockets {
+		if sc.Label == "Doom" {
+			doom = sc.RxPkts
+		}
+	}
+	if doom != st.TotalReceived {
+		t.Errorf("Expected all %d packets on the Doom socket, got %d", st.TotalReceived, doom)
+	}
+	var recv uint64
+	for _, p := range peers {
+		recv += p.GetStats().RecvPkts
+	}
+	if recv != st.TotalReceived {
+		t.Errorf("Expected the peers to have sent all %d packets, got %d", st.TotalReceived, recv)
+	}
+}
@@ -0,0 +1,238 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Hub election and automatic failover for hub-and-spoke deployments
+
+package relay
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+	"github.com/mlapointe/ipxtransporter/internal/peer"
+)
+
+const (
+	electionInterval = 5 * time.Second
+	// hubCandidateTTL is how long a node we no longer see is remembered as
+	// a candidate. After a failure the partitions can no longer see each
+	// other, so everyone elects from what they saw before it.
+	hubCandidateTTL = 10 * time.Minute
+)
+
+type hubCandidate struct {
+	node     peer.TopologyNode
+	lastSeen time.Time
+}
+
+type hubState struct {
+	mu          sync.Mutex
+	candidates  map[string]hubCandidate
+	failed      map[string]time.Time // candidates we lost or could not reach
+	lastParent  string
+	orphanSince time.Time
+	elected     string // hub among the nodes currently visible
+	rehomedTo   string
+}
+
+func newHubState() hubState {
+	return hubState{
+		candidates: make(map[string]hubCandidate),
+		failed:     make(map[string]time.Time),
+	}
+}
+
+// betterHub reports whether a should be preferred over b: higher priority
+// first, then longer uptime, then the lower ID so every node agrees.
+func betterHub(a, b peer.TopologyNode) bool {
+	if a.HubPriority != b.HubPriority {
+		return a.HubPriority > b.HubPriority
+	}
+	if a.StartedAt != b.StartedAt {
+		return a.StartedAt < b.StartedAt
+	}
+	return a.ID < b.ID
+}
+
+// electHub picks the best eligible node. Other nodes are only eligible if
+// we know an address to reach them at.
+func electHub(nodes []peer.TopologyNode, exclude map[string]bool, selfID string) (peer.TopologyNode, bool) {
+	var best peer.TopologyNode
+	found := false
+	for _, n := range nodes {
+		if n.HubPriority <= 0 || exclude[n.ID] || (n.ID != selfID && n.ListenAddr == "") {
+			continue
+		}
+		if !found || betterHub(n, best) {
+			best, found = n, true
+		}
+	}
+	return best, found
+}
+
+// isDescendant reports whether id sits below ancestor in the tree.
+func isDescendant(nodes []peer.TopologyNode, id, ancestor string) bool {
+	parents := make(map[string]string, len(nodes))
+	for _, n := range nodes {
+		parents[n.ID] = n.ParentID
+	}
+	for i := 0; i < maxTopologyHops && id != ""; i++ {
+		id = parents[id]
+		if id == ancestor {
+			return true
+		}
+	}
+	return false
+}
+
+// HubID returns the node currently elected as hub, or "" if election is
+// disabled everywhere.
+func (s *Server) HubID() string {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	return s.hub.elected
+}
+
+func (s *Server) runElection(ctx context.Context) {
+	ticker := time.NewTicker(electionInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.checkHub(time.Now())
+		}
+	}
+}
+
+// checkHub refreshes the candidate list and, once our parent link has been
+// down for HubFailoverDelay, re-homes this subtree to the elected hub.
+// Only the root of an orphaned subtree acts; its children stay attached.
+func (s *Server) checkHub(now time.Time) {
+	nodes := s.topologyFor(nil)
+	parent := nodes[0].ParentID
+
+	s.peersMu.RLock()
+	linked := false
+	for _, p := range s.peers {
+		if !p.Inbound {
+			linked = true
+		}
+	}
+	s.peersMu.RUnlock()
+
+	h := &s.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, n := range nodes {
+		if n.HubPriority > 0 {
+			h.candidates[n.ID] = hubCandidate{node: n, lastSeen: now}
+		}
+	}
+	for id, c := range h.candidates {
+		if now.Sub(c.lastSeen) > hubCandidateTTL {
+			delete(h.candidates, id)
+		}
+	}
+	for id, at := range h.failed {
+		if now.Sub(at) > hubCandidateTTL {
+			delete(h.failed, id)
+		}
+	}
+	h.elected = ""
+	if winner, ok := electHub(nodes, nil, s.nodeID); ok {
+		h.elected = winner.ID
+	}
+
+	if linked {
+		if parent != "" {
+			h.lastParent = parent
+		}
+		h.orphanSince = time.Time{}
+		h.rehomedTo = ""
+		return
+	}
+	dialing := s.dialAddrs()
+	if len(dialing) == 0 {
+		// A root by configuration, or already the hub.
+		return
+	}
+	if h.orphanSince.IsZero() {
+		h.orphanSince = now
+		return
+	}
+	if now.Sub(h.orphanSince) < time.Duration(s.cfg.HubFailoverDelay)*time.Second {
+		return
+	}
+	h.orphanSince = now
+
+	// Whoever we were attached to, or last tried, is gone.
+	if h.lastParent != "" {
+		h.failed[h.lastParent] = now
+	}
+	if h.rehomedTo != "" && h.rehomedTo != s.nodeID {
+		h.failed[h.rehomedTo] = now
+	}
+
+	cached := make([]peer.TopologyNode, 0, len(h.candidates))
+	for _, c := range h.candidates {
+		cached = append(cached, c.node)
+	}
+	exclude := make(map[string]bool, len(h.failed))
+	for id := range h.failed {
+		exclude[id] = true
+	}
+	winner, ok := electHub(cached, exclude, s.nodeID)
+	if !ok || len(cached) < 2 {
+		// Nothing to fail over to: keep retrying the configured peers.
+		return
+	}
+
+	if winner.ID == s.nodeID || isDescendant(nodes, winner.ID, s.nodeID) {
+		// The new hub is us or below us; the other orphans will join this
+		// subtree. Keep dialing the old parent in case it comes back.
+		if h.rehomedTo != s.nodeID {
+			logger.Relay.Info("Hub failover: lost parent %s, this subtree now holds hub %s", h.lastParent, winner.ID)
+		}
+		h.rehomedTo = s.nodeID
+		return
+	}
+
+	logger.Relay.Info("Hub failover: lost parent %s, re-homing to hub %s (%s)", h.lastParent, winner.ID, winner.ListenAddr)
+	h.rehomedTo = winner.ID
+	for _, addr := range dialing {
+		s.stopDialer(addr)
+	}
+	s.replacePeers(dialing, winner.ListenAddr)
+	s.startDialer(s.runCtx, winner.ListenAddr)
+}
+
+func (s *Server) dialAddrs() []string {
+	s.dialersMu.Lock()
+	defer s.dialersMu.Unlock()
+	out := make([]string, 0, len(s.dialers))
+	for addr := range s.dialers {
+		out = append(out, addr)
+	}
+	return out
+}
+
+// replacePeers swaps the configured upstream peers for the new hub so the
+// choice survives a restart.
+func (s *Server) replacePeers(old []string, addr string) {
+	drop := make(map[string]bool, len(old))
+	for _, a := range old {
+		drop[a] = true
+	}
+	peers := []string{addr}
+	for _, a := range s.configuredPeers() {
+		if !drop[a] && a != addr {
+			peers = append(peers, a)
+		}
+	}
+	s.setConfiguredPeers(peers)
+	s.persistConfig()
+}
@@ -0,0 +1,81 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for hub election and failover
+
+package relay
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/peer"
+)
+
+func TestElectHub(t *testing.T) {
+	nodes := []peer.TopologyNode{
+		{ID: "a", HubPriority: 100, StartedAt: 200, ListenAddr: "10.0.0.1:8787"},
+		{ID: "b", HubPriority: 100, StartedAt: 100, ListenAddr: "10.0.0.2:8787"},
+		{ID: "c", HubPriority: 50, StartedAt: 1, ListenAddr: "10.0.0.3:8787"},
+		{ID: "d", HubPriority: 500, StartedAt: 1},
+		{ID: "e", StartedAt: 1, ListenAddr: "10.0.0.5:8787"},
+	}
+
+	w, ok := electHub(nodes, nil, "x")
+	if !ok || w.ID != "b" {
+		t.Errorf("Expected longest-running top priority node b, got %q", w.ID)
+	}
+	if w, _ := electHub(nodes, nil, "d"); w.ID != "d" {
+		t.Errorf("Expected self d to win without a listen address, got %q", w.ID)
+	}
+	if w, _ := electHub(nodes, map[string]bool{"a": true, "b": true}, "x"); w.ID != "c" {
+		t.Errorf("Expected c after excluding a and b, got %q", w.ID)
+	}
+}
+
+func TestIsDescendant(t *testing.T) {
+	nodes := []peer.TopologyNode{
+		{ID: "root"},
+		{ID: "mid", ParentID: "root"},
+		{ID: "leaf", ParentID: "mid"},
+	}
+	if !isDescendant(nodes, "leaf", "root") {
+		t.Error("Expected leaf to be below root")
+	}
+	if isDescendant(nodes, "root", "leaf") {
+		t.Error("Expected root not to be below leaf")
+	}
+}
+
+func TestServerHubFailover(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.HubElection = true
+	cfg.Peers = []config.PeerConfig{{Addr: "10.0.0.1:8787"}}
+	srv, err := NewServer(cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // dialers must not actually connect
+	srv.runCtx = ctx
+	srv.startDialer(ctx, "10.0.0.1:8787")
+
+	now := time.Now()
+	srv.hub.lastParent = "old-hub"
+	srv.hub.candidates["old-hub"] = hubCandidate{node: peer.TopologyNode{ID: "old-hub", HubPriority: 200, ListenAddr: "10.0.0.1:8787"}, lastSeen: now}
+	srv.hub.candidates["next"] = hubCandidate{node: peer.TopologyNode{ID: "next", HubPriority: 150, ListenAddr: "10.0.0.2:8787"}, lastSeen: now}
+
+	srv.checkHub(now)
+	if len(srv.dialAddrs()) != 1 || srv.dialAddrs()[0] != "10.0.0.1:8787" {
+		t.Fatalf("Expected no failover before the delay, dialing %v", srv.dialAddrs())
+	}
+
+	srv.checkHub(now.Add(time.Duration(cfg.HubFailoverDelay+1) * time.Second))
+	if addrs := srv.dialAddrs(); len(addrs) != 1 || addrs[0] != "10.0.0.2:8787" {
+		t.Errorf("Expected re-home to 10.0.0.2:8787, dialing %v", addrs)
+	}
+	if len(cfg.Peers) != 1 || cfg.Peers[0].Addr != "10.0.0.2:8787" {
+		t.Errorf("Expected configured peers to follow the new hub, got %v", cfg.Peers)
+	}
+}
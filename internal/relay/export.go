@@ -0,0 +1,176 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// SQLite export of history samples, sessions, events and peer metadata
+
+package relay
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+	"github.com/mlapointe/ipxtransporter/internal/sqlite"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+// exportSchemaVersion is bumped whenever a table or column of the export
+// changes; it is stored in the meta table.
+const exportSchemaVersion = 2
+
+// ExportSQLite writes a SQLite database with these tables (times are
+// RFC 3339 text in UTC):
+//
+//	meta      key, value: schema_version, node_id, hostname, exported_at
+//	samples   time, received, forwarded, dropped, errors, echo_suppressed,
+//	          peers, stations: relay counters (cumulative) from the
+//	          history, with the end-stations served
+//	sessions  peer_id, node_id, hostname, ip, inbound, connected_at,
+//	          disconnected_at (NULL while up), duration_s, sent_bytes,
+//	          recv_bytes, sent_pkts, recv_pkts, errors
+//	events    time, level, message: recent log messages
+//	peers     peer_id, node_id, hostname, ip, inbound, country, city, lat,
+//	          lon, whois, latency_ms, connected_at, last_seen
+func (s *Server) ExportSQLite(w io.Writer) error {
+	now := time.Now()
+	st := s.CollectStats()
+
+	meta := sqlite.Table{
+		Name:    "meta",
+		Columns: []sqlite.Column{{Name: "key", Type: "TEXT"}, {Name: "value", Type: "TEXT"}},
+		Rows: [][]any{
+			{"schema_version", fmt.Sprint(exportSchemaVersion)},
+			{"node_id", s.nodeID},
+			{"hostname", s.hostname},
+			{"exported_at", now.UTC().Format(time.RFC3339)},
+		},
+	}
+
+	samples := sqlite.Table{
+		Name: "samples",
+		Columns: []sqlite.Column{
+			{Name: "time", Type: "TEXT"},
+			{Name: "received", Type: "INTEGER"},
+			{Name: "forwarded", Type: "INTEGER"},
+			{Name: "dropped", Type: "INTEGER"},
+			{Name: "errors", Type: "INTEGER"},
+			{Name: "echo_suppressed", Type: "INTEGER"},
+			{Name: "peers", Type: "INTEGER"},
+			{Name: "stations", Type: "INTEGER"},
+		},
+	}
+	sessions := sqlite.Table{
+		Name: "sessions",
+		Columns: []sqlite.Column{
+			{Name: "peer_id", Type: "TEXT"},
+			{Name: "node_id", Type: "TEXT"},
+			{Name: "hostname", Type: "TEXT"},
+			{Name: "ip", Type: "TEXT"},
+			{Name: "inbound", Type: "INTEGER"},
+			{Name: "connected_at", Type: "TEXT"},
+			{Name: "disconnected_at", Type: "TEXT"},
+			{Name: "duration_s", Type: "INTEGER"},
+			{Name: "sent_bytes", Type: "INTEGER"},
+			{Name: "recv_bytes", Type: "INTEGER"},
+			{Name: "sent_pkts", Type: "INTEGER"},
+			{Name: "recv_pkts", Type: "INTEGER"},
+			{Name: "errors", Type: "INTEGER"},
+		},
+	}
+	for _, h := range s.history.samples.Since(time.Time{}) {
+		samples.Rows = append(samples.Rows, []any{h.Time, h.Received, h.Forwarded, h.Dropped, h.Errors, h.EchoSuppressed, h.Peers, h.Stations})
+	}
+	s.history.mu.Lock()
+	list := append([]peerSession(nil), s.history.sessions...)
+	s.history.mu.Unlock()
+	var linked []stats.PeerStat
+	for _, p := range st.Peers {
+		if !p.Emulated {
+			linked = append(linked, p)
+			list = append(list, peerSession{PeerStat: p})
+		}
+	}
+	for _, ps := range list {
+		end := ps.ended
+		if end.IsZero() {
+			end = now
+		}
+		sessions.Rows = append(sessions.Rows, []any{
+			ps.ID, ps.NodeID, ps.Hostname, ps.IP.String(), ps.Inbound, ps.ConnectedAt, ps.ended,
+			int64(end.Sub(ps.ConnectedAt).Seconds()), ps.SentBytes, ps.RecvBytes, ps.SentPkts, ps.RecvPkts, ps.Errors,
+		})
+	}
+
+	events := sqlite.Table{
+		Name:    "events",
+		Columns: []sqlite.Column{{Name: "time", Type: "TEXT"}, {Name: "level", Type: "TEXT"}, {Name: "subsystem", Type: "TEXT"}, {Name: "message", Type: "TEXT"}},
+	}
+	for _, l := range st.Logs {
+		events.Rows = append(events.Rows, []any{l.Timestamp, l.Level, l.Subsystem, l.Message})
+	}
+
+	peers := sqlite.Table{
+		Name: "peers",
+		Columns: []sqlite.Column{
+			{Name: "peer_id", Type: "TEXT"},
+			{Name: "node_id", Type: "TEXT"},
+			{Name: "hostname", Type: "TEXT"},
+			{Name: "ip", Type: "TEXT"},
+			{Name: "inbound", Type: "INTEGER"},
+			{Name: "country", Type: "TEXT"},
+			{Name: "city", Type: "TEXT"},
+			{Name: "lat", Type: "REAL"},
+			{Name: "lon", Type: "REAL"},
+			{Name: "whois", Type: "TEXT"},
+			{Name: "latency_ms", Type: "REAL"},
+			{Name: "connected_at", Type: "TEXT"},
+			{Name: "last_seen", Type: "TEXT"},
+		},
+	}
+	for _, p := range linked {
+		peers.Rows = append(peers.Rows, []any{
+			p.ID, p.NodeID, p.Hostname, p.IP.String(), p.Inbound, p.Country, p.City, p.Lat, p.Lon,
+			p.Whois, p.LatencyMs, p.ConnectedAt, p.LastSeen,
+		})
+	}
+
+	return sqlite.Write(w, []sqlite.Table{meta, samples, sessions, events, peers})
+}
+
+// runExport writes the SQLite export to cfg.ExportPath every
+// cfg.ExportInterval minutes.
+func (s *Server) runExport(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(max(s.cfg.ExportInterval, 1)) * time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.ExportSQLiteFile(s.cfg.ExportPath); err != nil {
+				logger.Relay.Error("Export: %v", err)
+			}
+		}
+	}
+}
+
+// ExportSQLiteFile writes the export to path, replacing any previous file
+// only once the new one is complete.
+func (s *Server) ExportSQLiteFile(path string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := s.ExportSQLite(f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,56 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for the SQLite export
+
+package relay
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+func TestServerExportSQLite(t *testing.T) {
+	srv, err := NewServer(config.DefaultConfig(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	srv.recordSample(now.Add(-time.Minute))
+	srv.recordSample(now)
+	srv.recordSession(stats.PeerStat{ID: "192.0.2.1:50000", IP: net.ParseIP("192.0.2.1"), ConnectedAt: now.Add(-time.Hour), SentBytes: 1234}, now)
+
+	path := filepath.Join(t.TempDir(), "export.db")
+	if err := srv.ExportSQLiteFile(path); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(b, []byte("SQLite format 3\x00")) {
+		t.Fatal("Expected a SQLite database file")
+	}
+
+	sqlite3, err := exec.LookPath("sqlite3")
+	if err != nil {
+		return
+	}
+	out, err := exec.Command(sqlite3, path,
+		"SELECT count(*) FROM samples;",
+		"SELECT ip, duration_s, sent_bytes, disconnected_at IS NOT NULL FROM sessions;",
+		"SELECT value FROM meta WHERE key = 'node_id';").CombinedOutput()
+	if err != nil {
+		t.Fatalf("sqlite3 failed: %v: %s", err, out)
+	}
+	if want := "2\n192.0.2.1|3600|1234|1\n" + srv.NodeID() + "\n"; string(out) != want {
+		t.Errorf("Expected %q, got %q", want, out)
+	}
+}
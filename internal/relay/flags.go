@@ -0,0 +1,140 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Feature flags: subsystems switched on and off without a restart
+
+package relay
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+// maxFlagChanges is how many flag changes are kept for display.
+const maxFlagChanges = 64
+
+// flagState holds the flags' current values where the hot paths read them
+// without taking a lock, and the latest changes.
+type flagState struct {
+	echoSuppression atomic.Bool
+	strictNetworks  atomic.Bool
+	relayAssist     atomic.Bool
+	remoteCapture   atomic.Bool
+	tracing         atomic.Bool
+
+	mu      sync.Mutex
+	changes []stats.FlagChange // oldest first
+}
+
+// featureFlag is a boolean setting that takes effect at once. Its name is
+// the setting's config file key, so a flip persists like any other
+// setting.
+type featureFlag struct {
+	name        string
+	description string
+	setting     func(*config.Config) *bool
+	state       func(*flagState) *atomic.Bool
+}
+
+// featureFlags are the flags, in the order they are listed. A subsystem
+// that can be trialled on a running hub adds itself here and reads its
+// state from flagState.
+var featureFlags = []featureFlag{
+	{
+		name:        "echo_suppression",
+		description: "drop captured frames from stations known to be remote",
+		setting:     func(c *config.Config) *bool { return &c.EchoSuppression },
+		state:       func(f *flagState) *atomic.Bool { return &f.echoSuppression },
+	},
+	{
+		name:        "strict_networks",
+		description: "drop frames from networks a peer did not declare",
+		setting:     func(c *config.Config) *bool { return &c.StrictNetworks },
+		state:       func(f *flagState) *atomic.Bool { return &f.strictNetworks },
+	},
+	{
+		name:        "relay_assist",
+		description: "forward traffic between peers that cannot link directly",
+		setting:     func(c *config.Config) *bool { return &c.RelayAssist },
+		state:       func(f *flagState) *atomic.Bool { return &f.relayAssist },
+	},
+	{
+		name:        "remote_capture",
+		description: "record when another node starts a mesh capture",
+		setting:     func(c *config.Config) *bool { return &c.RemoteCapture },
+		state:       func(f *flagState) *atomic.Bool { return &f.remoteCapture },
+	},
+	{
+		name:        "tracing",
+		description: "send the frames of the trace flows with a relay trace",
+		setting:     func(c *config.Config) *bool { return &c.Tracing },
+		state:       func(f *flagState) *atomic.Bool { return &f.tracing },
+	},
+}
+
+func findFlag(name string) (featureFlag, bool) {
+	i := slices.IndexFunc(featureFlags, func(f featureFlag) bool { return f.name == name })
+	if i < 0 {
+		return featureFlag{}, false
+	}
+	return featureFlags[i], true
+}
+
+// loadFlags copies the flags' settings from the config. The caller holds
+// peersMu or has not started the server.
+func (s *Server) loadFlags() {
+	for _, f := range featureFlags {
+		f.state(&s.flags).Store(*f.setting(s.cfg))
+	}
+}
+
+// Flags returns the feature flags and their current state.
+func (s *Server) Flags() []stats.FeatureFlag {
+	out := make([]stats.FeatureFlag, len(featureFlags))
+	for i, f := range featureFlags {
+		out[i] = stats.FeatureFlag{Name: f.name, Description: f.description, Enabled: f.state(&s.flags).Load()}
+	}
+	return out
+}
+
+// SetFlag switches the named feature flag on or off, saves the config and
+// records the change as made by user.
+func (s *Server) SetFlag(name string, enabled bool, user string) error {
+	if _, ok := findFlag(name); !ok {
+		return fmt.Errorf("unknown flag %q", name)
+	}
+	value, _ := json.Marshal(enabled)
+	if err := s.SetConfigValue(name, value); err != nil {
+		return err
+	}
+	state := "off"
+	if enabled {
+		state = "on"
+	}
+	logger.Relay.Info("Flag: %s turned %s by %s", name, state, user)
+	f := &s.flags
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.changes = append(f.changes, stats.FlagChange{Time: time.Now(), Flag: name, Enabled: enabled, By: user})
+	if len(f.changes) > maxFlagChanges {
+		f.changes = slices.Clone(f.changes[len(f.changes)-maxFlagChanges:])
+	}
+	return nil
+}
+
+// FlagChanges returns the latest flag changes, newest first.
+func (s *Server) FlagChanges() []stats.FlagChange {
+	f := &s.flags
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := slices.Clone(f.changes)
+	slices.Reverse(out)
+	return out
+}
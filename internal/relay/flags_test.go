@@ -0,0 +1,65 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for feature flags
+
+package relay
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+)
+
+func TestFeatureFlags(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	cfg := config.DefaultConfig()
+	cfg.Trace = []config.TraceFlow{{Socket: 0x869C}}
+	srv, err := NewServer(cfg, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range srv.Flags() {
+		if f.Name == "tracing" && !f.Enabled {
+			t.Fatal("Expected tracing to be on by default")
+		}
+	}
+	frame := socketFrame(0x4000, 0x869C)
+	if srv.traceStart(frame) == nil {
+		t.Fatal("Expected the flow to be traced")
+	}
+
+	if err := srv.SetFlag("tracing", false, "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if srv.traceStart(frame) != nil {
+		t.Error("Expected no trace with tracing turned off")
+	}
+	if flows := srv.TraceFlows(); len(flows) != 1 {
+		t.Errorf("Expected the flows to be kept, got %v", flows)
+	}
+	saved, err := config.LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Tracing {
+		t.Error("Expected the flag to be saved")
+	}
+	changes := srv.FlagChanges()
+	if len(changes) != 1 || changes[0].Flag != "tracing" || changes[0].Enabled || changes[0].By != "admin" {
+		t.Errorf("Expected the change to be recorded, got %+v", changes)
+	}
+
+	// Setting the config key directly moves the flag too.
+	if err := srv.SetConfigValue("echo_suppression", json.RawMessage("false")); err != nil {
+		t.Fatal(err)
+	}
+	if srv.flags.echoSuppression.Load() {
+		t.Error("Expected echo_suppression to follow its setting")
+	}
+
+	if err := srv.SetFlag("warp_drive", true, "admin"); err == nil {
+		t.Error("Expected an unknown flag to be refused")
+	}
+}
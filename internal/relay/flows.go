@@ -0,0 +1,212 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Flow export: metering IPX flows and sending them to a collector
+
+package relay
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/flowexport"
+	"github.com/mlapointe/ipxtransporter/internal/ipx"
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+const (
+	// maxFlows bounds the flow table. When it is full the frames of new
+	// flows go uncounted until the next check exports every flow.
+	maxFlows = 16384
+	// flowCheck is how often flows are checked for their timeouts.
+	flowCheck = time.Second
+	// flowLinkEmulator is the link of frames from attached emulators.
+	flowLinkEmulator = "emulator"
+)
+
+// flowAddr is an ipx.Addr that can be a map key.
+type flowAddr struct {
+	network uint32
+	node    [6]byte
+	socket  uint16
+}
+
+func newFlowAddr(a ipx.Addr) flowAddr {
+	f := flowAddr{network: a.Network, socket: a.Socket}
+	copy(f.node[:], a.Node)
+	return f
+}
+
+func (f flowAddr) addr() ipx.Addr {
+	return ipx.Addr{Network: f.network, Node: net.HardwareAddr(f.node[:]), Socket: f.socket}
+}
+
+type flowKey struct {
+	src, dst flowAddr
+	link     string
+}
+
+type flowEntry struct {
+	packets, bytes uint64
+	exported       time.Time // start of the part not exported yet
+	end            time.Time // last packet
+}
+
+// flowState meters the flows of flow_export.
+type flowState struct {
+	mu        sync.Mutex
+	flows     map[flowKey]*flowEntry
+	overflows uint64
+
+	records, messages, sendErrors atomic.Uint64
+	lastError                     atomic.Value // string
+}
+
+func newFlowState() *flowState {
+	return &flowState{flows: make(map[flowKey]*flowEntry)}
+}
+
+// meterFlow counts a frame that came in on link towards its flow.
+func (s *Server) meterFlow(link string, frame []byte) {
+	f := s.flowExport
+	if f == nil {
+		return
+	}
+	h, err := ipx.Parse(frame)
+	if err != nil {
+		return
+	}
+	key := flowKey{src: newFlowAddr(h.Src), dst: newFlowAddr(h.Dst), link: link}
+	now := time.Now()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	e, ok := f.flows[key]
+	if !ok {
+		if len(f.flows) >= maxFlows {
+			f.overflows++
+			return
+		}
+		e = &flowEntry{exported: now}
+		f.flows[key] = e
+	}
+	e.packets++
+	e.bytes += uint64(len(frame))
+	e.end = now
+}
+
+// expireFlows removes the flows idle since idle and returns them with the
+// part of the active ones older than active, all flows if flush is set.
+func (f *flowState) expireFlows(now time.Time, idle, active time.Duration, flush bool) []flowexport.Record {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	flush = flush || len(f.flows) >= maxFlows
+	var out []flowexport.Record
+	for key, e := range f.flows {
+		ended := flush || now.Sub(e.end) >= idle
+		if !ended && now.Sub(e.exported) < active {
+			continue
+		}
+		if e.packets > 0 {
+			out = append(out, flowexport.Record{
+				Src:     key.src.addr(),
+				Dst:     key.dst.addr(),
+				Link:    key.link,
+				Packets: e.packets,
+				Bytes:   e.bytes,
+				Start:   e.exported,
+				End:     e.end,
+			})
+		}
+		if ended {
+			delete(f.flows, key)
+			continue
+		}
+		e.packets, e.bytes, e.exported = 0, 0, now
+	}
+	return out
+}
+
+// runFlowExport sends the flows that ended or ran for active_timeout to
+// the collector, and the rest when ctx is done.
+func (s *Server) runFlowExport(ctx context.Context) {
+	fc := s.cfg.FlowExport
+	f := s.flowExport
+	enc, err := flowexport.NewEncoder(fc.Protocol, fc.DomainID, time.Now())
+	if err != nil {
+		logger.Relay.Error("Flow export: %v", err)
+		return
+	}
+	idle := time.Duration(fc.IdleTimeout) * time.Second
+	active := time.Duration(fc.ActiveTimeout) * time.Second
+	var conn net.Conn
+	send := func(records []flowexport.Record) {
+		if len(records) == 0 {
+			return
+		}
+		if conn == nil {
+			dctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			conn, err = s.resolver.Load().DialContext(dctx, &net.Dialer{}, "udp", fc.Collector)
+			cancel()
+			if err != nil {
+				f.sendErrors.Add(1)
+				f.lastError.Store(err.Error())
+				logger.Relay.Warn("Flow export: %v", err)
+				return
+			}
+		}
+		for _, msg := range enc.Encode(records, time.Now()) {
+			if _, err := conn.Write(msg); err != nil {
+				f.sendErrors.Add(1)
+				f.lastError.Store(err.Error())
+				// Dial again, the collector's address may have changed.
+				conn.Close()
+				conn = nil
+				return
+			}
+			f.messages.Add(1)
+		}
+		f.records.Add(uint64(len(records)))
+	}
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+	logger.Relay.Info("Exporting flows to %s as %s", fc.Collector, fc.Protocol)
+
+	ticker := time.NewTicker(flowCheck)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			send(f.expireFlows(time.Now(), idle, active, true))
+			return
+		case now := <-ticker.C:
+			send(f.expireFlows(now, idle, active, false))
+		}
+	}
+}
+
+func (s *Server) collectFlowExport() *stats.FlowExportStats {
+	f := s.flowExport
+	if f == nil {
+		return nil
+	}
+	f.mu.Lock()
+	flows, overflows := len(f.flows), f.overflows
+	f.mu.Unlock()
+	lastError, _ := f.lastError.Load().(string)
+	return &stats.FlowExportStats{
+		Collector:  s.cfg.FlowExport.Collector,
+		Protocol:   s.cfg.FlowExport.Protocol,
+		Flows:      flows,
+		Records:    f.records.Load(),
+		Messages:   f.messages.Load(),
+		Overflows:  overflows,
+		SendErrors: f.sendErrors.Load(),
+		LastError:  lastError,
+	}
+}
@@ -0,0 +1,93 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for flow export
+
+package relay
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+)
+
+func TestFlowExpiry(t *testing.T) {
+	f := newFlowState()
+	srv := &Server{flowExport: f}
+	srv.meterFlow("peer-a", socketFrame(0x4000, 0x869C))
+	srv.meterFlow("peer-a", socketFrame(0x4000, 0x869C))
+	srv.meterFlow("peer-b", socketFrame(0x4000, 0x869C))
+	srv.meterFlow("peer-a", []byte{1, 2, 3})
+
+	now := time.Now()
+	if recs := f.expireFlows(now, time.Minute, time.Hour, false); len(recs) != 0 {
+		t.Fatalf("Expected no flow to be due, got %v", recs)
+	}
+	// Active flows are exported and go on counting from zero.
+	recs := f.expireFlows(now.Add(2*time.Second), time.Minute, time.Second, false)
+	if len(recs) != 2 {
+		t.Fatalf("Expected a flow per link, got %v", recs)
+	}
+	for _, r := range recs {
+		want := uint64(1)
+		if r.Link == "peer-a" {
+			want = 2
+		}
+		if r.Packets != want || r.Src.Socket != 0x4000 || r.Dst.Socket != 0x869C {
+			t.Errorf("Expected %d packets from socket 4000 to 869C, got %+v", want, r)
+		}
+	}
+	if len(f.flows) != 2 {
+		t.Errorf("Expected the active flows to be kept, got %d", len(f.flows))
+	}
+	if recs := f.expireFlows(now.Add(2*time.Minute), time.Minute, time.Hour, false); len(recs) != 0 || len(f.flows) != 0 {
+		t.Errorf("Expected idle flows with nothing new to end unexported, got %v and %d left", recs, len(f.flows))
+	}
+}
+
+func TestFlowExport(t *testing.T) {
+	collector, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer collector.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.FlowExport.Collector = collector.LocalAddr().String()
+	cfg.FlowExport.DomainID = 42
+	srv, err := NewServer(cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.meterFlow("peer-a", socketFrame(0x4000, 0x869C))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		srv.runFlowExport(ctx)
+		close(done)
+	}()
+	cancel()
+	<-done
+
+	collector.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 2048)
+	n, _, err := collector.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := buf[:n]
+	if v := binary.BigEndian.Uint16(msg); v != 10 {
+		t.Errorf("Expected an IPFIX message, got version %d", v)
+	}
+	if d := binary.BigEndian.Uint32(msg[12:]); d != 42 {
+		t.Errorf("Expected observation domain 42, got %d", d)
+	}
+	st := srv.collectFlowExport()
+	if st.Records != 1 || st.Messages != 1 || st.Flows != 0 {
+		t.Errorf("Expected one record sent and none left, got %+v", st)
+	}
+}
@@ -0,0 +1,349 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Gossip-based peer discovery: exchange peer addresses and auto-connect
+
+package relay
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+	"github.com/mlapointe/ipxtransporter/internal/peer"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+const (
+	gossipInterval = 30 * time.Second
+	gossipEntryTTL = 10 * time.Minute
+	// autoDialGrace is how long an auto-connect may go without a live link
+	// before we give up on the address.
+	autoDialGrace  = time.Minute
+	maxGossipPeers = 32
+)
+
+// Trust levels for gossip sources, see config.GossipTrust.
+const (
+	TrustAuto    = "auto"    // auto-connect to addresses the source shares
+	TrustApprove = "approve" // hold its addresses until an operator approves
+	TrustIgnore  = "ignore"  // discard its addresses
+)
+
+type gossipEntry struct {
+	from     string
+	lastSeen time.Time
+	failedAt time.Time
+	pending  bool // waiting for manual approval
+}
+
+type gossipState struct {
+	mu       sync.Mutex
+	known    map[string]*gossipEntry
+	auto     map[string]time.Time // auto-connect dialers by address, with start time
+	rejected map[string]bool      // addresses an operator turned down
+}
+
+func newGossipState() gossipState {
+	return gossipState{
+		known:    make(map[string]*gossipEntry),
+		auto:     make(map[string]time.Time),
+		rejected: make(map[string]bool),
+	}
+}
+
+func (s *Server) runGossip(ctx context.Context) {
+	ticker := time.NewTicker(gossipInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.sendGossip()
+			s.autoConnect(time.Now())
+		}
+	}
+}
+
+// sendGossip tells each peer the listen addresses of our other peers that
+// allow being advertised, leaving out banned hosts and the peers whose
+// entry in the config says not to.
+func (s *Server) sendGossip() {
+	hidden := make(map[string]bool)
+	s.roomMu.RLock()
+	for _, pc := range s.cfg.Peers {
+		if !pc.MayAdvertise() {
+			hidden[pc.Addr] = true
+		}
+	}
+	s.roomMu.RUnlock()
+
+	s.peersMu.RLock()
+	defer s.peersMu.RUnlock()
+	for _, to := range s.peers {
+		var addrs []string
+		for _, p := range s.peers {
+			if p == to || !p.MayAdvertise() || hidden[p.DialAddr] {
+				continue
+			}
+			addr := p.RemoteListenAddr()
+			if addr == "" || len(addrs) >= maxGossipPeers {
+				continue
+			}
+			if _, banned := s.hostBans.Match(peerHost(addr)); banned {
+				continue
+			}
+			addrs = append(addrs, addr)
+		}
+		if len(addrs) > 0 {
+			to.SendControl(peer.Control{Type: peer.ControlGossip, Peers: addrs})
+		}
+	}
+}
+
+// gossipTrust returns the trust level configured for a gossip source,
+// looked up by node ID, then by IP.
+func (s *Server) gossipTrust(p *peer.Peer) string {
+	level, ok := s.cfg.GossipTrust[p.NodeID()]
+	if !ok {
+		if addr, isTCP := p.Conn.RemoteAddr().(*net.TCPAddr); isTCP {
+			level, ok = s.cfg.GossipTrust[addr.IP.String()]
+		}
+	}
+	switch {
+	case ok && (level == TrustAuto || level == TrustApprove || level == TrustIgnore):
+		return level
+	case s.honors(p, TrustTrusted):
+		return TrustAuto
+	case s.cfg.GossipApproval:
+		return TrustApprove
+	}
+	return TrustAuto
+}
+
+func (s *Server) handleGossip(p *peer.Peer, c peer.Control) {
+	if !s.cfg.Gossip || !s.honors(p, TrustKnown) {
+		return
+	}
+	trust := s.gossipTrust(p)
+	if trust == TrustIgnore {
+		return
+	}
+	var addrs []string
+	for i, addr := range c.Peers {
+		if i >= maxGossipPeers {
+			break
+		}
+		host, _, err := net.SplitHostPort(addr)
+		if err == nil && !s.isBannedHost(host) {
+			addrs = append(addrs, addr)
+		}
+	}
+
+	now := time.Now()
+	g := &s.gossip
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, addr := range addrs {
+		if g.rejected[addr] {
+			continue
+		}
+		if e, ok := g.known[addr]; ok {
+			e.from, e.lastSeen = p.ID, now
+			if trust == TrustAuto {
+				e.pending = false
+			}
+			continue
+		}
+		g.known[addr] = &gossipEntry{from: p.ID, lastSeen: now, pending: trust == TrustApprove}
+	}
+}
+
+// ApproveDiscovered lets autoConnect dial a gossiped address that was held
+// for manual approval.
+func (s *Server) ApproveDiscovered(addr string) error {
+	g := &s.gossip
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	e, ok := g.known[addr]
+	if !ok {
+		return fmt.Errorf("no discovered peer %s", addr)
+	}
+	e.pending = false
+	e.lastSeen = time.Now()
+	logger.Relay.Info("Gossip: approved %s (learned from %s)", addr, e.from)
+	return nil
+}
+
+// RejectDiscovered forgets a gossiped address and ignores it from now on.
+func (s *Server) RejectDiscovered(addr string) error {
+	g := &s.gossip
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, ok := g.known[addr]; !ok {
+		return fmt.Errorf("no discovered peer %s", addr)
+	}
+	delete(g.known, addr)
+	g.rejected[addr] = true
+	logger.Relay.Info("Gossip: rejected %s", addr)
+	return nil
+}
+
+// autoConnect dials learned addresses until MaxAutoPeers auto links are up,
+// and gives up on auto links that never came up.
+func (s *Server) autoConnect(now time.Time) {
+	linked := make(map[string]bool)
+	s.peersMu.RLock()
+	for _, p := range s.peers {
+		if p.DialAddr != "" {
+			linked[p.DialAddr] = true
+		}
+		if addr := p.RemoteListenAddr(); addr != "" {
+			linked[addr] = true
+		}
+	}
+	s.peersMu.RUnlock()
+	dialing := make(map[string]bool)
+	for _, addr := range s.dialAddrs() {
+		dialing[addr] = true
+	}
+
+	// Addresses we could not link to may still be reachable through a
+	// common peer; ask for a relayed path once the gossip lock is released.
+	var gaveUp []string
+	defer func() {
+		for _, addr := range gaveUp {
+			s.relayFallback(addr)
+		}
+	}()
+
+	g := &s.gossip
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for addr, started := range g.auto {
+		if !linked[addr] && now.Sub(started) > autoDialGrace {
+			logger.Relay.Info("Gossip: giving up on auto-connect to %s", addr)
+			gaveUp = append(gaveUp, addr)
+			s.stopDialer(addr)
+			delete(g.auto, addr)
+			if e, ok := g.known[addr]; ok {
+				e.failedAt = now
+			}
+		}
+	}
+
+	addrs := make([]string, 0, len(g.known))
+	for addr, e := range g.known {
+		if _, auto := g.auto[addr]; !auto && now.Sub(e.lastSeen) > gossipEntryTTL {
+			delete(g.known, addr)
+			continue
+		}
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+
+	for _, addr := range addrs {
+		if len(g.auto) >= s.cfg.MaxAutoPeers {
+			break
+		}
+		e := g.known[addr]
+		if _, auto := g.auto[addr]; auto || e.pending || linked[addr] || dialing[addr] || s.isOwnAddr(addr) {
+			continue
+		}
+		if !e.failedAt.IsZero() && now.Sub(e.failedAt) < gossipEntryTTL {
+			continue
+		}
+		logger.Relay.Info("Gossip: auto-connecting to %s (learned from %s)", addr, e.from)
+		g.auto[addr] = now
+		s.startDialer(s.runCtx, addr)
+	}
+}
+
+// isOwnAddr reports whether addr points at our own peer listener.
+func (s *Server) isOwnAddr(addr string) bool {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	_, ownPort, err := net.SplitHostPort(s.cfg.ListenAddr)
+	if err != nil || port != ownPort {
+		return false
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	if ip.IsLoopback() {
+		return true
+	}
+	ifAddrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, a := range ifAddrs {
+		if n, ok := a.(*net.IPNet); ok && n.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// dropRedundantAutoLink closes an auto-connected link that turned out to
+// lead back to us or to a node we already have a link to.
+func (s *Server) dropRedundantAutoLink(p *peer.Peer, nodeID string) bool {
+	s.gossip.mu.Lock()
+	_, auto := s.gossip.auto[p.DialAddr]
+	s.gossip.mu.Unlock()
+	if !auto {
+		return false
+	}
+
+	redundant := nodeID == s.nodeID
+	s.peersMu.RLock()
+	for _, other := range s.peers {
+		if other != p && other.NodeID() == nodeID {
+			redundant = true
+		}
+	}
+	s.peersMu.RUnlock()
+	if !redundant {
+		return false
+	}
+
+	logger.Relay.Info("Gossip: %s leads to node %s we already reach, dropping it", p.DialAddr, nodeID)
+	s.gossip.mu.Lock()
+	delete(s.gossip.auto, p.DialAddr)
+	if e, ok := s.gossip.known[p.DialAddr]; ok {
+		e.failedAt = time.Now()
+	}
+	s.gossip.mu.Unlock()
+	s.stopDialer(p.DialAddr)
+	if err := p.Conn.Close(); err != nil {
+		logger.Relay.Error("Error closing redundant peer %s connection: %v", p.ID, err)
+	}
+	return true
+}
+
+func (s *Server) discoveredPeers() []stats.DiscoveredPeer {
+	s.gossip.mu.Lock()
+	defer s.gossip.mu.Unlock()
+	out := make([]stats.DiscoveredPeer, 0, len(s.gossip.known))
+	for addr, e := range s.gossip.known {
+		_, auto := s.gossip.auto[addr]
+		out = append(out, stats.DiscoveredPeer{
+			Addr:     addr,
+			From:     e.from,
+			LastSeen: e.lastSeen,
+			Auto:     auto,
+			Failed:   !e.failedAt.IsZero(),
+			Pending:  e.pending,
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Addr < out[j].Addr })
+	return out
+}
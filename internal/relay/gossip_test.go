@@ -0,0 +1,120 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for gossip-based peer discovery
+
+package relay
+
+import (
+	"context"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/peer"
+)
+
+func TestServerGossipAutoConnect(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Gossip = true
+	cfg.MaxAutoPeers = 1
+	cfg.BannedHosts = []string{"10.0.0.9"}
+	srv, err := NewServer(cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // dialers must not actually connect
+	srv.runCtx = ctx
+
+	from := peer.NewPeer("bootstrap", &fakeConn{remoteAddr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 8787}}, "")
+	srv.handleGossip(from, peer.Control{
+		Type:  peer.ControlGossip,
+		Peers: []string{"10.0.0.3:8787", "10.0.0.2:8787", "10.0.0.9:8787", "bogus"},
+	})
+
+	discovered := srv.discoveredPeers()
+	if len(discovered) != 2 || discovered[0].Addr != "10.0.0.2:8787" || discovered[0].From != "bootstrap" {
+		t.Fatalf("Expected two valid, unbanned addresses, got %+v", discovered)
+	}
+
+	now := time.Now()
+	srv.autoConnect(now)
+	if addrs := srv.dialAddrs(); len(addrs) != 1 || addrs[0] != "10.0.0.2:8787" {
+		t.Fatalf("Expected one auto-connect to 10.0.0.2:8787, dialing %v", addrs)
+	}
+
+	// The link never comes up: give up and move on to the next address.
+	srv.autoConnect(now.Add(autoDialGrace + time.Second))
+	srv.autoConnect(now.Add(autoDialGrace + 2*time.Second))
+	if addrs := srv.dialAddrs(); len(addrs) != 1 || addrs[0] != "10.0.0.3:8787" {
+		t.Errorf("Expected failover to 10.0.0.3:8787, dialing %v", addrs)
+	}
+	for _, d := range srv.discoveredPeers() {
+		if d.Addr == "10.0.0.2:8787" && (!d.Failed || d.Auto) {
+			t.Errorf("Expected 10.0.0.2:8787 marked failed, got %+v", d)
+		}
+	}
+}
+
+func TestServerGossipTrust(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Gossip = true
+	cfg.GossipApproval = true
+	cfg.GossipTrust = map[string]string{"10.0.0.1": TrustAuto, "10.0.0.66": TrustIgnore}
+	srv, err := NewServer(cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // dialers must not actually connect
+	srv.runCtx = ctx
+
+	trusted := peer.NewPeer("trusted", &fakeConn{remoteAddr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 8787}}, "")
+	stranger := peer.NewPeer("stranger", &fakeConn{remoteAddr: &net.TCPAddr{IP: net.ParseIP("10.0.0.5"), Port: 8787}}, "")
+	ignored := peer.NewPeer("ignored", &fakeConn{remoteAddr: &net.TCPAddr{IP: net.ParseIP("10.0.0.66"), Port: 8787}}, "")
+	srv.handleGossip(trusted, peer.Control{Type: peer.ControlGossip, Peers: []string{"10.0.0.2:8787"}})
+	srv.handleGossip(stranger, peer.Control{Type: peer.ControlGossip, Peers: []string{"10.0.0.3:8787", "10.0.0.4:8787"}})
+	srv.handleGossip(ignored, peer.Control{Type: peer.ControlGossip, Peers: []string{"10.0.0.7:8787"}})
+
+	pending := map[string]bool{}
+	for _, d := range srv.discoveredPeers() {
+		pending[d.Addr] = d.Pending
+	}
+	if want := map[string]bool{"10.0.0.2:8787": false, "10.0.0.3:8787": true, "10.0.0.4:8787": true}; len(pending) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, pending)
+	} else {
+		for addr, p := range want {
+			if pending[addr] != p {
+				t.Errorf("Expected %s pending=%v, got %v", addr, p, pending[addr])
+			}
+		}
+	}
+
+	srv.autoConnect(time.Now())
+	if addrs := srv.dialAddrs(); len(addrs) != 1 || addrs[0] != "10.0.0.2:8787" {
+		t.Fatalf("Expected only the trusted address dialed, dialing %v", addrs)
+	}
+
+	if err := srv.ApproveDiscovered("10.0.0.4:8787"); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.RejectDiscovered("10.0.0.3:8787"); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.ApproveDiscovered("10.0.0.9:8787"); err == nil {
+		t.Error("Expected an error approving an unknown address")
+	}
+	srv.autoConnect(time.Now())
+	if addrs := srv.dialAddrs(); len(addrs) != 2 || !slices.Contains(addrs, "10.0.0.4:8787") {
+		t.Errorf("Expected the approved address dialed, dialing %v", addrs)
+	}
+
+	srv.handleGossip(stranger, peer.Control{Type: peer.ControlGossip, Peers: []string{"10.0.0.3:8787"}})
+	for _, d := range srv.discoveredPeers() {
+		if d.Addr == "10.0.0.3:8787" {
+			t.Error("Expected a rejected address to stay forgotten")
+		}
+	}
+}
@@ -0,0 +1,201 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Negotiated protocol versions and features: downgrade audit and policy
+
+package relay
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+	"github.com/mlapointe/ipxtransporter/internal/peer"
+)
+
+const (
+	// downgradeWarnAfter is how many downgrades of a node pass as a
+	// rollback before they are warned about.
+	downgradeWarnAfter = 2
+	// policyHelloTimeout is how long a link of a group with a
+	// handshake_policy may take to send its hello before it is closed.
+	policyHelloTimeout = 10 * time.Second
+)
+
+// negotiated is the most a node's links have negotiated so far, and how
+// often one negotiated less since.
+type negotiated struct {
+	protocol   int
+	features   []string
+	downgrades int
+}
+
+type handshakeState struct {
+	mu    sync.Mutex
+	nodes map[string]*negotiated // node ID, or IP of a node that sends none
+}
+
+func newHandshakeState() handshakeState {
+	return handshakeState{nodes: make(map[string]*negotiated)}
+}
+
+// validHandshakePolicy checks the handshake_policy of the config.
+func validHandshakePolicy(policies config.HandshakePolicies) error {
+	for group, pol := range policies {
+		if pol.MinProtocol > peer.ProtocolVersion {
+			return fmt.Errorf("handshake_policy: %s peers need protocol %d but this node speaks %d", group, pol.MinProtocol, peer.ProtocolVersion)
+		}
+		for _, f := range pol.Require {
+			if !slices.Contains(peer.Features, f) {
+				return fmt.Errorf("handshake_policy: unknown feature %q for %s peers; use %s", f, group, strings.Join(peer.Features, ", "))
+			}
+		}
+	}
+	return nil
+}
+
+// hasPolicy reports whether the handshake_policy of p's group asks for
+// anything, so that its hello must be seen before the link relays.
+func (s *Server) hasPolicy(p *peer.Peer) bool {
+	pol := s.cfg.HandshakePolicy[s.peerGroup(p)]
+	return pol.MinProtocol > 0 || len(pol.Require) > 0
+}
+
+// expireHelloPending closes p if it still has not sent the hello its
+// handshake_policy is checked on. Without the timeout a peer sending none,
+// or a man in the middle stripping it, would keep the link.
+func (s *Server) expireHelloPending(p *peer.Peer) {
+	s.peersMu.Lock()
+	pending := s.helloPending[p.ID] && s.peers[p.ID] == p
+	delete(s.helloPending, p.ID)
+	s.peersMu.Unlock()
+	if !pending {
+		return
+	}
+	logger.Relay.Warn("Rejecting peer %s: no hello within %v, and %s peers have a handshake policy", p.ID, policyHelloTimeout, s.peerGroup(p))
+	if err := p.Conn.Close(); err != nil {
+		logger.Relay.Error("Error closing peer %s connection (handshake policy): %v", p.ID, err)
+	}
+}
+
+// checkHandshake records what p's hello negotiated and closes the link if
+// its group's handshake_policy asks for more, or lets it relay if it was
+// held for its hello. A node negotiating less
+// than it did before is counted, and warned about once it happens again:
+// stale software on the node or a man in the middle stripping its hello.
+func (s *Server) checkHandshake(p *peer.Peer, nodeID string) bool {
+	protocol, features := p.Negotiated()
+	key := nodeID
+	if key == "" {
+		key = peerHost(p.ID)
+	}
+
+	best, lost, down := s.handshake.record(key, protocol, features)
+	if down {
+		msg := fmt.Sprintf("Peer %s (node %s) negotiated less than before: protocol %d", p.ID, nodeID, protocol)
+		if protocol < best.protocol {
+			msg += fmt.Sprintf(" (was %d)", best.protocol)
+		}
+		if len(lost) > 0 {
+			msg += " without " + strings.Join(lost, ", ")
+		}
+		if best.downgrades >= downgradeWarnAfter {
+			logger.Relay.Warn("%s, %d times now: the node may run stale software or its hellos may be tampered with", msg, best.downgrades)
+		} else {
+			logger.Relay.Info("%s", msg)
+		}
+	} else {
+		logger.Relay.Debug("Peer %s (node %s) negotiated protocol %d with %s", p.ID, nodeID, protocol, featureList(features))
+	}
+
+	group := s.peerGroup(p)
+	if problem := handshakeProblem(s.cfg.HandshakePolicy[group], protocol, features); problem != "" {
+		logger.Relay.Warn("Rejecting peer %s (node %s): %s peers %s", p.ID, nodeID, group, problem)
+		if err := p.Conn.Close(); err != nil {
+			logger.Relay.Error("Error closing peer %s connection (handshake policy): %v", p.ID, err)
+		}
+		return false
+	}
+	s.peersMu.Lock()
+	pending := s.helloPending[p.ID]
+	delete(s.helloPending, p.ID)
+	s.peersMu.Unlock()
+	if pending {
+		p.SetMute(false, false)
+	}
+	return true
+}
+
+// record notes what a link of the node key negotiated. It returns the
+// most the node's links negotiated before, with the downgrades counting
+// this one, the features this link lacks of those and whether it is a
+// downgrade.
+func (h *handshakeState) record(key string, protocol int, features []string) (best negotiated, lost []string, down bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	n := h.nodes[key]
+	if n == nil {
+		n = &negotiated{protocol: protocol, features: slices.Clone(features)}
+		h.nodes[key] = n
+	}
+	for _, f := range n.features {
+		if !slices.Contains(features, f) {
+			lost = append(lost, f)
+		}
+	}
+	down = protocol < n.protocol || len(lost) > 0
+	if down {
+		n.downgrades++
+	}
+	best = *n
+	best.features = slices.Clone(n.features)
+	n.protocol = max(n.protocol, protocol)
+	for _, f := range features {
+		if !slices.Contains(n.features, f) {
+			n.features = append(n.features, f)
+		}
+	}
+	return best, lost, down
+}
+
+// handshakeProblem tells what a link negotiating protocol and features
+// lacks for pol, or "" if nothing.
+func handshakeProblem(pol config.HandshakePolicy, protocol int, features []string) string {
+	if protocol < pol.MinProtocol {
+		return fmt.Sprintf("must negotiate protocol %d or later, got %d", pol.MinProtocol, protocol)
+	}
+	var missing []string
+	for _, f := range pol.Require {
+		if !slices.Contains(features, f) {
+			missing = append(missing, f)
+		}
+	}
+	if len(missing) > 0 {
+		return "must negotiate " + strings.Join(missing, ", ")
+	}
+	return ""
+}
+
+// downgrades returns how often the node of p negotiated less than before.
+func (s *Server) downgrades(p *peer.Peer) int {
+	key := p.NodeID()
+	if key == "" {
+		key = peerHost(p.ID)
+	}
+	s.handshake.mu.Lock()
+	defer s.handshake.mu.Unlock()
+	if n := s.handshake.nodes[key]; n != nil {
+		return n.downgrades
+	}
+	return 0
+}
+
+func featureList(features []string) string {
+	if len(features) == 0 {
+		return "no features"
+	}
+	return strings.Join(features, ", ")
+}
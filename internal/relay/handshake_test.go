@@ -0,0 +1,132 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for negotiated protocol versions and features
+
+package relay
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/peer"
+)
+
+func TestHandshakeProblem(t *testing.T) {
+	pol := config.HandshakePolicy{MinProtocol: 2, Require: []string{peer.FeatureSigned, peer.FeaturePadding}}
+	for _, tc := range []struct {
+		protocol int
+		features []string
+		want     string
+	}{
+		{2, []string{peer.FeaturePadding, peer.FeatureSigned, peer.FeatureTraces}, ""},
+		{1, []string{peer.FeaturePadding, peer.FeatureSigned}, "must negotiate protocol 2 or later, got 1"},
+		{2, []string{peer.FeatureTraces}, "must negotiate signed, padding"},
+	} {
+		if got := handshakeProblem(pol, tc.protocol, tc.features); got != tc.want {
+			t.Errorf("protocol %d with %v: expected %q, got %q", tc.protocol, tc.features, tc.want, got)
+		}
+	}
+	if got := handshakeProblem(config.HandshakePolicy{}, 1, nil); got != "" {
+		t.Errorf("Expected an empty policy to accept anything, got %q", got)
+	}
+}
+
+func TestValidHandshakePolicy(t *testing.T) {
+	for _, tc := range []struct {
+		policies config.HandshakePolicies
+		ok       bool
+	}{
+		{config.HandshakePolicies{"inbound": {MinProtocol: 2, Require: []string{"signed"}}}, true},
+		{config.HandshakePolicies{"inbound": {Require: []string{"multicast"}}}, false},
+		{config.HandshakePolicies{"auto": {MinProtocol: peer.ProtocolVersion + 1}}, false},
+	} {
+		if err := validHandshakePolicy(tc.policies); (err == nil) != tc.ok {
+			t.Errorf("%+v: expected ok %v, got %v", tc.policies, tc.ok, err)
+		}
+	}
+	cfg := config.DefaultConfig()
+	cfg.HandshakePolicy = config.HandshakePolicies{"inbound": {Require: []string{"multicast"}}}
+	if _, err := NewServer(cfg, ""); err == nil {
+		t.Error("Expected the server to refuse an unknown feature")
+	}
+}
+
+func TestHandshakeDowngrades(t *testing.T) {
+	h := newHandshakeState()
+	full := []string{peer.FeaturePadding, peer.FeatureSigned}
+	if _, _, down := h.record("node-a", 2, full); down {
+		t.Error("Expected the first hello of a node not to be a downgrade")
+	}
+	best, lost, down := h.record("node-a", 2, []string{peer.FeaturePadding})
+	if !down || best.downgrades != 1 || len(lost) != 1 || lost[0] != peer.FeatureSigned {
+		t.Errorf("Expected a hello without signing to be a downgrade, got %+v, %v, %v", best, lost, down)
+	}
+	best, lost, down = h.record("node-a", 1, full)
+	if !down || best.downgrades != 2 || best.protocol != 2 || len(lost) != 0 {
+		t.Errorf("Expected an older protocol to be a downgrade, got %+v, %v, %v", best, lost, down)
+	}
+	if _, _, down := h.record("node-a", 2, append(full, peer.FeatureTraces)); down {
+		t.Error("Expected negotiating more not to be a downgrade")
+	}
+	if _, _, down := h.record("node-b", 1, nil); down {
+		t.Error("Expected nodes tracked apart")
+	}
+}
+
+func TestHandshakePolicyNeedsHello(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.HandshakePolicy = config.HandshakePolicies{"inbound": {MinProtocol: 2}}
+	srv, err := NewServer(cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hold := func(id string, inbound bool) (*peer.Peer, net.Conn) {
+		local, remote := net.Pipe()
+		p := peer.NewPeer(id, local, "")
+		p.Inbound = inbound
+		if !srv.hasPolicy(p) {
+			return p, remote
+		}
+		p.SetMute(true, true)
+		srv.peersMu.Lock()
+		srv.addPeerLocked(p)
+		srv.helloPending[p.ID] = true
+		srv.peersMu.Unlock()
+		return p, remote
+	}
+	closed := func(remote net.Conn) bool {
+		remote.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		_, err := remote.Read(make([]byte, 1))
+		return err != nil && !errors.Is(err, os.ErrDeadlineExceeded)
+	}
+
+	if p, _ := hold("10.9.0.9:5000", false); srv.hasPolicy(p) {
+		t.Error("Expected configured peers to have no policy")
+	}
+
+	// No hello at all: the link is closed when the timeout runs out.
+	_, remote := hold("10.9.0.1:5000", true)
+	srv.peersMu.RLock()
+	p := srv.peers["10.9.0.1:5000"]
+	srv.peersMu.RUnlock()
+	if !p.GetStats().MutedIn {
+		t.Error("Expected the link muted until its hello")
+	}
+	srv.expireHelloPending(p)
+	if !closed(remote) {
+		t.Error("Expected a link without a hello closed")
+	}
+
+	// A hello below the policy closes the link at once.
+	p, remote = hold("10.9.0.2:5000", true)
+	if srv.checkHandshake(p, "node-b") {
+		t.Error("Expected a link negotiating nothing refused")
+	}
+	if !closed(remote) {
+		t.Error("Expected the refused link closed")
+	}
+}
@@ -0,0 +1,200 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Self-healing: restarting stuck dialers and the scheduled safe restart
+
+package relay
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+const (
+	healInterval = 5 * time.Second
+	// drainPoll is how often a drain checks the links' send queues.
+	drainPoll = 100 * time.Millisecond
+)
+
+// healState tracks what the self-healing policy acts on.
+type healState struct {
+	mu           sync.Mutex
+	unlinked     map[string]time.Time // dial address -> since when it has no link
+	restartAt    time.Duration        // into the local day, if restarting
+	nextRestart  time.Time
+	dialRestarts atomic.Uint64
+	draining     atomic.Bool
+	restart      chan string // reason, taken by whoever restarts the process
+}
+
+func newHealState() healState {
+	return healState{unlinked: make(map[string]time.Time), restart: make(chan string, 1)}
+}
+
+func validSelfHeal(p config.SelfHealPolicy) (time.Duration, error) {
+	if p.InjectFailures < 0 || p.DialerStuck < 0 || p.RestartDrain < 0 {
+		return 0, fmt.Errorf("self_heal: inject_failures, dialer_stuck and restart_drain must not be negative")
+	}
+	if p.RestartAt == "" {
+		return 0, nil
+	}
+	t, err := time.Parse("15:04", p.RestartAt)
+	if err != nil {
+		return 0, fmt.Errorf("self_heal: restart_at %q is not HH:MM", p.RestartAt)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// nextRestartAfter returns the first restart time of day after now.
+func nextRestartAfter(now time.Time, at time.Duration) time.Time {
+	y, m, d := now.Date()
+	next := time.Date(y, m, d, 0, 0, 0, 0, now.Location()).Add(at)
+	if !next.After(now) {
+		next = time.Date(y, m, d+1, 0, 0, 0, 0, now.Location()).Add(at)
+	}
+	return next
+}
+
+// runSelfHeal applies the self_heal policy: it restarts dialers that have
+// gone dialer_stuck seconds without a link and asks for the daily restart.
+// Restarting capture is up to the injection path.
+func (s *Server) runSelfHeal(ctx context.Context) {
+	ticker := time.NewTicker(healInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			now := time.Now()
+			s.healDialers(now)
+			s.checkRestart(now)
+		}
+	}
+}
+
+func (s *Server) healDialers(now time.Time) {
+	stuck := time.Duration(s.cfg.SelfHeal.DialerStuck) * time.Second
+	if stuck == 0 {
+		return
+	}
+	linked := make(map[string]bool)
+	s.peersMu.RLock()
+	for _, p := range s.peers {
+		if !p.Inbound {
+			linked[p.DialAddr] = true
+		}
+	}
+	s.peersMu.RUnlock()
+
+	h := &s.heal
+	var restart []string
+	h.mu.Lock()
+	dialing := make(map[string]bool)
+	for _, addr := range s.dialAddrs() {
+		dialing[addr] = true
+		since, ok := h.unlinked[addr]
+		switch {
+		case linked[addr]:
+			delete(h.unlinked, addr)
+		case !ok:
+			h.unlinked[addr] = now
+		case now.Sub(since) >= stuck:
+			restart = append(restart, addr)
+			h.unlinked[addr] = now
+		}
+	}
+	for addr := range h.unlinked {
+		if !dialing[addr] {
+			delete(h.unlinked, addr)
+		}
+	}
+	h.mu.Unlock()
+
+	for _, addr := range restart {
+		logger.Relay.Warn("Self-heal: restarting the dialer for %s, no link for %s", addr, stuck)
+		s.startDialer(s.runCtx, addr)
+		h.dialRestarts.Add(1)
+	}
+}
+
+func (s *Server) checkRestart(now time.Time) {
+	h := &s.heal
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.nextRestart.IsZero() || now.Before(h.nextRestart) {
+		return
+	}
+	h.nextRestart = nextRestartAfter(now, h.restartAt)
+	s.RequestRestart("scheduled restart at " + s.cfg.SelfHeal.RestartAt)
+}
+
+// RequestRestart asks for a safe restart of the process, see
+// RestartRequested. A restart already asked for is not asked again.
+func (s *Server) RequestRestart(reason string) {
+	select {
+	case s.heal.restart <- reason:
+		logger.Relay.Warn("Self-heal: %s requested", reason)
+	default:
+	}
+}
+
+// RestartRequested delivers the reason of each restart asked for. The
+// receiver drains the links with Drain and restarts the process.
+func (s *Server) RestartRequested() <-chan string {
+	return s.heal.restart
+}
+
+// Drain readies the relay for a restart: it turns new links away, stops
+// dialing, gives the links up to timeout to send what is queued for them
+// and closes them.
+func (s *Server) Drain(timeout time.Duration) {
+	op := s.beginOp(opDrain, "")
+	s.heal.draining.Store(true)
+	for _, addr := range s.dialAddrs() {
+		s.stopDialer(addr)
+	}
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) && s.queuedBytes() > 0 {
+		time.Sleep(drainPoll)
+	}
+	var err error
+	if n := s.queuedBytes(); n > 0 {
+		logger.Relay.Warn("Drain: closing links with %d bytes still queued", n)
+		err = fmt.Errorf("%d bytes still queued", n)
+	}
+	defer s.endOp(op, err)
+	s.peersMu.RLock()
+	defer s.peersMu.RUnlock()
+	for id, p := range s.peers {
+		if err := p.Conn.Close(); err != nil {
+			logger.Relay.Error("Error closing peer %s connection on drain: %v", id, err)
+		}
+	}
+}
+
+func (s *Server) queuedBytes() uint64 {
+	s.peersMu.RLock()
+	defer s.peersMu.RUnlock()
+	var n uint64
+	for _, p := range s.peers {
+		n += p.GetStats().QueuedBytes
+	}
+	return n
+}
+
+func (s *Server) selfHealStats() stats.SelfHealStats {
+	s.heal.mu.Lock()
+	defer s.heal.mu.Unlock()
+	return stats.SelfHealStats{
+		DialerRestarts: s.heal.dialRestarts.Load(),
+		NextRestart:    s.heal.nextRestart,
+		Draining:       s.heal.draining.Load(),
+	}
+}
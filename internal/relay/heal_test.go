@@ -0,0 +1,101 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for the self-healing policy
+
+package relay
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/peer"
+)
+
+func TestNextRestartAfter(t *testing.T) {
+	at := 4*time.Hour + 30*time.Minute
+	now := time.Date(2026, 3, 1, 2, 0, 0, 0, time.UTC)
+	if got := nextRestartAfter(now, at); !got.Equal(time.Date(2026, 3, 1, 4, 30, 0, 0, time.UTC)) {
+		t.Errorf("Expected 04:30 the same day, got %v", got)
+	}
+	now = time.Date(2026, 3, 1, 4, 30, 0, 0, time.UTC)
+	if got := nextRestartAfter(now, at); !got.Equal(time.Date(2026, 3, 2, 4, 30, 0, 0, time.UTC)) {
+		t.Errorf("Expected 04:30 the next day, got %v", got)
+	}
+}
+
+func TestSelfHealValidation(t *testing.T) {
+	for _, p := range []config.SelfHealPolicy{{RestartAt: "25:00"}, {RestartAt: "4am"}, {DialerStuck: -1}} {
+		cfg := config.DefaultConfig()
+		cfg.SelfHeal = p
+		if _, err := NewServer(cfg, ""); err == nil {
+			t.Errorf("Expected %+v to be rejected", p)
+		}
+	}
+}
+
+func TestServerHealDialers(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.SelfHeal.DialerStuck = 60
+	srv, err := NewServer(cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // dialers must not actually connect
+	srv.runCtx = ctx
+	srv.startDialer(ctx, "10.0.0.1:8787")
+	srv.startDialer(ctx, "10.0.0.2:8787")
+	p := peer.NewPeer("10.0.0.2:8787", &fakeConn{remoteAddr: &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 8787}}, "")
+	p.DialAddr = "10.0.0.2:8787"
+	srv.peers[p.ID] = p
+
+	now := time.Now()
+	srv.healDialers(now)
+	srv.healDialers(now.Add(59 * time.Second))
+	if n := srv.heal.dialRestarts.Load(); n != 0 {
+		t.Fatalf("Expected no restart before dialer_stuck, got %d", n)
+	}
+	srv.healDialers(now.Add(61 * time.Second))
+	if n := srv.heal.dialRestarts.Load(); n != 1 {
+		t.Errorf("Expected the unlinked dialer alone restarted, got %d restarts", n)
+	}
+	srv.healDialers(now.Add(62 * time.Second))
+	if n := srv.heal.dialRestarts.Load(); n != 1 {
+		t.Errorf("Expected a restarted dialer to get dialer_stuck again, got %d restarts", n)
+	}
+}
+
+func TestServerScheduledRestart(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.SelfHeal.RestartAt = "04:00"
+	srv, err := NewServer(cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := srv.heal.nextRestart
+	srv.checkRestart(next.Add(-time.Second))
+	select {
+	case r := <-srv.RestartRequested():
+		t.Fatalf("Expected no restart before 04:00, got %q", r)
+	default:
+	}
+	srv.checkRestart(next)
+	select {
+	case <-srv.RestartRequested():
+	default:
+		t.Fatal("Expected a restart at 04:00")
+	}
+	if !srv.heal.nextRestart.Equal(next.AddDate(0, 0, 1)) {
+		t.Errorf("Expected the next restart a day later, got %v", srv.heal.nextRestart)
+	}
+
+	srv.Drain(time.Second)
+	conn := &fakeConn{remoteAddr: &net.TCPAddr{IP: net.ParseIP("10.0.0.3"), Port: 8787}}
+	srv.handleNewConn(context.Background(), conn, nil, "")
+	if len(srv.peers) != 0 {
+		t.Error("Expected a link to be turned away while draining")
+	}
+}
@@ -0,0 +1,116 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Traffic history samples and closed peer sessions kept for export
+
+package relay
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+const maxSessions = 1000
+
+// peerSession is a peer link from connect to disconnect. ended is zero for
+// links that are still up.
+type peerSession struct {
+	stats.PeerStat
+	ended time.Time
+}
+
+type historyState struct {
+	samples  *stats.History
+	mu       sync.Mutex // guards sessions
+	sessions []peerSession
+}
+
+func newHistoryState(cfg *config.Config) historyState {
+	return historyState{samples: stats.NewHistory(
+		time.Duration(cfg.HistoryResolution)*time.Second,
+		time.Duration(cfg.HistoryRetention)*time.Minute,
+	)}
+}
+
+// History returns the counter samples of the last span and the interval
+// they were taken at.
+func (s *Server) History(span time.Duration) ([]stats.Sample, time.Duration) {
+	return s.history.samples.Since(time.Now().Add(-span)), s.history.samples.Resolution()
+}
+
+func (s *Server) runHistory(ctx context.Context) {
+	ticker := time.NewTicker(s.history.samples.Resolution())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.recordSample(now)
+		}
+	}
+}
+
+func (s *Server) recordSample(now time.Time) {
+	s.history.samples.Add(s.sample(now))
+}
+
+// sample reads the relay counters at now.
+func (s *Server) sample(now time.Time) stats.Sample {
+	s.peersMu.RLock()
+	peers := len(s.peers)
+	s.peersMu.RUnlock()
+	stations, behind := s.hosts.stationCounts(now)
+	for _, n := range behind {
+		stations += n
+	}
+	return stats.Sample{
+		Time:           now,
+		Received:       atomic.LoadUint64(&s.totalReceived),
+		Forwarded:      atomic.LoadUint64(&s.totalForwarded),
+		ReceivedBytes:  atomic.LoadUint64(&s.receivedBytes),
+		ForwardedBytes: atomic.LoadUint64(&s.forwardedBytes),
+		Dropped:        atomic.LoadUint64(&s.totalDropped),
+		Errors:         atomic.LoadUint64(&s.totalErrors),
+		EchoSuppressed: atomic.LoadUint64(&s.totalEchoes),
+		Peers:          peers,
+		Stations:       stations,
+	}
+}
+
+// LogSummaries logs a line on the traffic of each interval until ctx is
+// done, so a node running without the TUI still shows its throughput.
+func (s *Server) LogSummaries(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	prev := s.sample(time.Now())
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			cur := s.sample(now)
+			logger.Relay.Info("Traffic: %s", stats.Summary(prev, cur))
+			prev = cur
+		}
+	}
+}
+
+// recordSession keeps the final counters of a peer link that went away.
+func (s *Server) recordSession(st stats.PeerStat, ended time.Time) {
+	h := &s.history
+	h.mu.Lock()
+	h.sessions = append(h.sessions, peerSession{PeerStat: st, ended: ended})
+	if len(h.sessions) > maxSessions {
+		h.sessions = h.sessions[len(h.sessions)-maxSessions:]
+	}
+	h.mu.Unlock()
+}
@@ -0,0 +1,181 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Inventory of IPX hosts seen on the local segment and behind peers
+
+package relay
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/ipx"
+	"github.com/mlapointe/ipxtransporter/internal/peer"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+const (
+	maxHosts = 4096
+	hostTTL  = 24 * time.Hour
+
+	// stationWindow is how recently a host must have sent to count as an
+	// end-station a relay serves.
+	stationWindow = 5 * time.Minute
+)
+
+// Where a host was seen, see stats.IPXHost.Via.
+const (
+	viaInterface = "interface"
+	viaEmulator  = "emulator"
+	viaPeer      = "peer"
+)
+
+// hostInventory records every IPX source address seen, keyed by
+// network.node. A host that moves, e.g. from behind one peer to another,
+// keeps its first-seen time and frame count.
+type hostInventory struct {
+	mu    sync.Mutex
+	hosts map[string]*stats.IPXHost
+}
+
+func newHostInventory() *hostInventory {
+	return &hostInventory{hosts: make(map[string]*stats.IPXHost)}
+}
+
+func (hi *hostInventory) see(h *ipx.Header, via, behind, peerID string, now time.Time) {
+	key := fmt.Sprintf("%08X.%s", h.Src.Network, h.Src.Node)
+	hi.mu.Lock()
+	defer hi.mu.Unlock()
+	e := hi.hosts[key]
+	if e == nil {
+		if len(hi.hosts) >= maxHosts {
+			hi.evictOldest()
+		}
+		e = &stats.IPXHost{
+			Address:   key,
+			Network:   fmt.Sprintf("%08X", h.Src.Network),
+			Node:      h.Src.Node.String(),
+			FirstSeen: now,
+		}
+		hi.hosts[key] = e
+	}
+	e.LastSeen = now
+	e.Frames++
+	e.Via, e.Behind, e.PeerID = via, behind, peerID
+}
+
+// evictOldest drops the least recently seen host. Must be called with mu
+// held.
+func (hi *hostInventory) evictOldest() {
+	var oldest string
+	for k, e := range hi.hosts {
+		if oldest == "" || e.LastSeen.Before(hi.hosts[oldest].LastSeen) {
+			oldest = k
+		}
+	}
+	delete(hi.hosts, oldest)
+}
+
+func (hi *hostInventory) prune(now time.Time) {
+	hi.mu.Lock()
+	defer hi.mu.Unlock()
+	for k, e := range hi.hosts {
+		if now.Sub(e.LastSeen) > hostTTL {
+			delete(hi.hosts, k)
+		}
+	}
+}
+
+func (hi *hostInventory) snapshot() []stats.IPXHost {
+	hi.mu.Lock()
+	out := make([]stats.IPXHost, 0, len(hi.hosts))
+	for _, e := range hi.hosts {
+		out = append(out, *e)
+	}
+	hi.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Address < out[j].Address })
+	return out
+}
+
+// stationCounts estimates the end-stations being served: the distinct
+// source nodes that sent within stationWindow on the local segment,
+// captured or emulated, and behind each peer link by peer ID.
+func (hi *hostInventory) stationCounts(now time.Time) (local int, behind map[string]int) {
+	localNodes := make(map[string]bool)
+	peerNodes := make(map[string]map[string]bool)
+	hi.mu.Lock()
+	for _, e := range hi.hosts {
+		if now.Sub(e.LastSeen) > stationWindow {
+			continue
+		}
+		if e.Via != viaPeer {
+			localNodes[e.Node] = true
+			continue
+		}
+		if peerNodes[e.PeerID] == nil {
+			peerNodes[e.PeerID] = make(map[string]bool)
+		}
+		peerNodes[e.PeerID][e.Node] = true
+	}
+	hi.mu.Unlock()
+	behind = make(map[string]int, len(peerNodes))
+	for id, nodes := range peerNodes {
+		behind[id] = len(nodes)
+	}
+	return len(localNodes), behind
+}
+
+// seeCaptured records the source of a frame captured on the interface.
+func (s *Server) seeCaptured(frame []byte) {
+	h, err := ipx.Parse(frame)
+	if err != nil {
+		return
+	}
+	s.hosts.see(h, viaInterface, s.cfg.Interface, "", time.Now())
+}
+
+// seePeer records the source of a frame received from a peer link.
+func (s *Server) seePeer(p *peer.Peer, frame []byte) {
+	h, err := ipx.Parse(frame)
+	if err != nil {
+		return
+	}
+	s.hosts.see(h, viaPeer, p.ID, p.ID, time.Now())
+}
+
+// Hosts returns every IPX host seen within the last day. Hosts behind a
+// peer that is still linked are shown behind its hostname.
+func (s *Server) Hosts() []stats.IPXHost {
+	s.peersMu.RLock()
+	defer s.peersMu.RUnlock()
+	return s.collectHosts()
+}
+
+// collectHosts must be called with peersMu held.
+func (s *Server) collectHosts() []stats.IPXHost {
+	out := s.hosts.snapshot()
+	for i, h := range out {
+		if p, ok := s.peers[h.PeerID]; ok && h.Via == viaPeer {
+			if st := p.GetStats(); st.Hostname != "" {
+				out[i].Behind = st.Hostname
+			}
+		}
+	}
+	return out
+}
+
+// peerFrame handles each data frame received from a peer link.
+func (s *Server) peerFrame(p *peer.Peer, frame []byte, hops []stats.TraceHop) {
+	if !s.checkDeclared(p, frame) {
+		atomic.AddUint64(&s.totalDropped, 1)
+		return
+	}
+	if hops != nil {
+		s.recordTrace(p, frame, hops)
+	}
+	s.meterFlow(p.ID, frame)
+	s.seePeer(p, frame)
+	s.forwardRelayed(p, frame, hops)
+}
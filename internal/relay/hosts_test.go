@@ -0,0 +1,83 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for the IPX host inventory
+
+package relay
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/ipx"
+)
+
+func hostHeader(network uint32, node string) *ipx.Header {
+	mac, _ := net.ParseMAC(node)
+	return &ipx.Header{SrcMAC: mac, Src: ipx.Addr{Network: network, Node: mac}}
+}
+
+func TestHostInventory(t *testing.T) {
+	hi := newHostInventory()
+	start := time.Now()
+	doom := hostHeader(1, "02:00:00:00:00:01")
+	hi.see(doom, viaInterface, "eth0", "", start)
+	hi.see(doom, viaInterface, "eth0", "", start.Add(time.Minute))
+	hi.see(hostHeader(2, "02:00:00:00:00:02"), viaPeer, "192.0.2.1:50000", "192.0.2.1:50000", start)
+
+	hosts := hi.snapshot()
+	if len(hosts) != 2 {
+		t.Fatalf("Expected 2 hosts, got %+v", hosts)
+	}
+	h := hosts[0]
+	if h.Address != "00000001.02:00:00:00:00:01" || h.Frames != 2 || h.Behind != "eth0" {
+		t.Errorf("Expected the local host with 2 frames, got %+v", h)
+	}
+	if !h.FirstSeen.Equal(start) || !h.LastSeen.Equal(start.Add(time.Minute)) {
+		t.Errorf("Expected first/last seen %v/%v, got %v/%v", start, start.Add(time.Minute), h.FirstSeen, h.LastSeen)
+	}
+	if hosts[1].Via != viaPeer || hosts[1].PeerID != "192.0.2.1:50000" {
+		t.Errorf("Expected the second host behind the peer, got %+v", hosts[1])
+	}
+
+	hi.prune(start.Add(hostTTL + 30*time.Second))
+	if hosts := hi.snapshot(); len(hosts) != 1 || hosts[0].Network != "00000001" {
+		t.Errorf("Expected only the recently seen host to survive pruning, got %+v", hosts)
+	}
+}
+
+func TestHostInventoryEvictsOldest(t *testing.T) {
+	hi := newHostInventory()
+	start := time.Now()
+	for i := range maxHosts + 1 {
+		h := hostHeader(uint32(i+1), "02:00:00:00:00:01")
+		hi.see(h, viaInterface, "eth0", "", start.Add(time.Duration(i)*time.Second))
+	}
+	hosts := hi.snapshot()
+	if len(hosts) != maxHosts {
+		t.Fatalf("Expected %d hosts, got %d", maxHosts, len(hosts))
+	}
+	if hosts[0].Network != "00000002" {
+		t.Errorf("Expected the oldest host to be evicted, got %s first", hosts[0].Network)
+	}
+}
+
+func TestStationCounts(t *testing.T) {
+	hi := newHostInventory()
+	now := time.Now()
+	const peerA, peerB = "192.0.2.1:50000", "192.0.2.2:50000"
+	hi.see(hostHeader(1, "02:00:00:00:00:01"), viaInterface, "eth0", "", now)
+	hi.see(hostHeader(2, "02:00:00:00:00:01"), viaInterface, "eth0", "", now) // same station, second network
+	hi.see(hostHeader(1, "02:00:00:00:00:02"), viaEmulator, "", "", now)
+	hi.see(hostHeader(3, "02:00:00:00:00:03"), viaPeer, peerA, peerA, now)
+	hi.see(hostHeader(3, "02:00:00:00:00:04"), viaPeer, peerA, peerA, now.Add(-time.Minute))
+	hi.see(hostHeader(4, "02:00:00:00:00:05"), viaPeer, peerB, peerB, now.Add(-stationWindow-time.Second))
+
+	local, behind := hi.stationCounts(now)
+	if local != 2 {
+		t.Errorf("Expected 2 local stations, got %d", local)
+	}
+	if behind[peerA] != 2 || behind[peerB] != 0 {
+		t.Errorf("Expected 2 stations behind A and none recent behind B, got %v", behind)
+	}
+}
@@ -0,0 +1,291 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Bulk import of peers from a published relay list
+
+package relay
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+const (
+	maxImportLen     = 1 << 20 // bytes of a peer list
+	maxImportEntries = 1000
+	importWorkers    = 8 // entries resolved and tested at once
+	importFetchLimit = 15 * time.Second
+)
+
+// Import statuses of stats.PeerImportResult.
+const (
+	importAdded   = "added"
+	importExists  = "exists"
+	importValid   = "valid"
+	importSkipped = "skipped"
+	importFailed  = "failed"
+)
+
+// ImportOptions tune ImportPeers.
+type ImportOptions struct {
+	Preflight bool     // add only entries whose preflight passes
+	Groups    []string // import only entries in one of these groups
+	DryRun    bool     // validate, change nothing
+}
+
+// ParsePeerList reads a relay list: JSON, either an array of addresses or
+// of {"addr", "label", "transport", "groups"} objects, bare or under
+// "peers"; or CSV with the columns addr, label, transport and groups
+// (separated by ';' or spaces), an optional header row and '#' comments.
+func ParsePeerList(data []byte) ([]stats.PeerImportEntry, error) {
+	if len(data) > maxImportLen {
+		return nil, fmt.Errorf("peer list is larger than %d bytes", maxImportLen)
+	}
+	var entries []stats.PeerImportEntry
+	var err error
+	switch trimmed := bytes.TrimSpace(data); {
+	case len(trimmed) == 0:
+		return nil, errors.New("peer list is empty")
+	case trimmed[0] == '[' || trimmed[0] == '{':
+		entries, err = parsePeerJSON(trimmed)
+	default:
+		entries, err = parsePeerCSV(trimmed)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) > maxImportEntries {
+		return nil, fmt.Errorf("peer list has %d entries, at most %d are imported at once", len(entries), maxImportEntries)
+	}
+	return entries, nil
+}
+
+func parsePeerJSON(data []byte) ([]stats.PeerImportEntry, error) {
+	var list []json.RawMessage
+	if data[0] == '{' {
+		var wrapped struct {
+			Peers []json.RawMessage `json:"peers"`
+		}
+		if err := json.Unmarshal(data, &wrapped); err != nil {
+			return nil, fmt.Errorf("peer list: %v", err)
+		}
+		list = wrapped.Peers
+	} else if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("peer list: %v", err)
+	}
+	entries := make([]stats.PeerImportEntry, 0, len(list))
+	for i, raw := range list {
+		var e stats.PeerImportEntry
+		if err := json.Unmarshal(raw, &e.Addr); err != nil {
+			if err := json.Unmarshal(raw, &e); err != nil {
+				return nil, fmt.Errorf("peer list entry %d: %v", i+1, err)
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func parsePeerCSV(data []byte) ([]stats.PeerImportEntry, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.Comment = '#'
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("peer list: %v", err)
+	}
+	if len(records) > 0 && strings.EqualFold(strings.TrimSpace(records[0][0]), "addr") {
+		records = records[1:]
+	}
+	field := func(rec []string, i int) string {
+		if i < len(rec) {
+			return strings.TrimSpace(rec[i])
+		}
+		return ""
+	}
+	entries := make([]stats.PeerImportEntry, 0, len(records))
+	for _, rec := range records {
+		e := stats.PeerImportEntry{Addr: field(rec, 0), Label: field(rec, 1), Transport: field(rec, 2)}
+		e.Groups = strings.FieldsFunc(field(rec, 3), func(r rune) bool { return r == ';' || r == ' ' })
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// FetchPeerList downloads a relay list published at url.
+func FetchPeerList(ctx context.Context, url string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, importFetchLimit)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", url, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxImportLen+1))
+	if err != nil {
+		return nil, fmt.Errorf("fetching %s: %v", url, err)
+	}
+	return data, nil
+}
+
+// ImportPeers validates each entry of a relay list, runs its preflight if
+// asked, and adds those that pass to the configured peers in one go, with
+// their transport. A label names the peer and becomes the note of the
+// addresses the entry resolves to. The results are in the order of
+// entries.
+func (s *Server) ImportPeers(ctx context.Context, entries []stats.PeerImportEntry, opts ImportOptions) []stats.PeerImportResult {
+	results := make([]stats.PeerImportResult, len(entries))
+	configured := s.configuredPeers()
+	seen := make(map[string]bool)
+	var pending []int
+	for i, e := range entries {
+		r := &results[i]
+		r.PeerImportEntry = e
+		r.Addr = withDefaultPort(strings.TrimSpace(e.Addr))
+		r.Transport = strings.ToLower(e.Transport)
+		if err := s.checkImportEntry(r.PeerImportEntry, opts.Groups); err != nil {
+			r.Status, r.Error = importFailed, err.Error()
+			if errors.Is(err, errNotInGroups) {
+				r.Status = importSkipped
+			}
+			continue
+		}
+		if seen[r.Addr] {
+			r.Status, r.Error = importSkipped, "listed twice"
+			continue
+		}
+		seen[r.Addr] = true
+		pending = append(pending, i)
+	}
+
+	// Resolving and preflights wait on the network; run a few at once.
+	ips := make([][]string, len(entries))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, importWorkers)
+	for _, i := range pending {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			ips[i] = s.vetImportEntry(ctx, &results[i], opts.Preflight)
+		}()
+	}
+	wg.Wait()
+
+	var added []config.PeerConfig
+	notes := make(map[string]string)
+	for _, i := range pending {
+		r := &results[i]
+		switch {
+		case r.Status == importFailed:
+			continue
+		case slices.Contains(configured, r.Addr):
+			r.Status = importExists
+		case opts.DryRun:
+			r.Status = importValid
+		default:
+			r.Status = importAdded
+			added = append(added, config.PeerConfig{Addr: r.Addr, Name: r.Label, Transport: r.Transport})
+		}
+		if r.Label != "" {
+			for _, ip := range ips[i] {
+				notes[ip] = r.Label
+			}
+		}
+	}
+	if opts.DryRun || (len(added) == 0 && len(notes) == 0) {
+		return results
+	}
+
+	if len(added) > 0 {
+		s.addConfiguredPeers(added...)
+	}
+	if len(notes) > 0 {
+		s.replica.mu.Lock()
+		if s.cfg.PeerNotes == nil {
+			s.cfg.PeerNotes = map[string]string{}
+		}
+		maps.Copy(s.cfg.PeerNotes, notes)
+		all := maps.Clone(s.cfg.PeerNotes)
+		s.replica.mu.Unlock()
+		s.columns.SetNotes(all)
+	}
+	s.persistConfig()
+	if !s.demoMode {
+		for _, pc := range added {
+			s.startDialer(s.runCtx, pc.Addr)
+		}
+	}
+	logger.Relay.Info("Imported %d peers from a list of %d", len(added), len(entries))
+	return results
+}
+
+// errNotInGroups skips entries outside the groups asked for.
+var errNotInGroups = errors.New("not in the selected groups")
+
+// checkImportEntry checks what it can of an entry without the network.
+func (s *Server) checkImportEntry(e stats.PeerImportEntry, groups []string) error {
+	if len(groups) > 0 && !slices.ContainsFunc(e.Groups, func(g string) bool { return slices.Contains(groups, g) }) {
+		return errNotInGroups
+	}
+	host, port, err := net.SplitHostPort(e.Addr)
+	if err != nil || host == "" {
+		return fmt.Errorf("invalid address %q", e.Addr)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("invalid port %q", port)
+	}
+	pc := config.PeerConfig{Addr: e.Addr, Transport: e.Transport}
+	return pc.Validate(s.cfg.DisableSSL)
+}
+
+// vetImportEntry resolves an entry, refuses banned hosts and runs its
+// preflight if asked, marking r failed if any of it does. It returns the
+// addresses the entry resolved to.
+func (s *Server) vetImportEntry(ctx context.Context, r *stats.PeerImportResult, preflight bool) []string {
+	host, _, _ := net.SplitHostPort(r.Addr)
+	ips, err := s.resolver.Load().LookupHost(ctx, host)
+	if err != nil {
+		r.Status, r.Error = importFailed, err.Error()
+		return nil
+	}
+	for _, h := range append([]string{host}, ips...) {
+		if s.isBannedHost(h) {
+			r.Status, r.Error = importFailed, h+" is banned"
+			return nil
+		}
+	}
+	if preflight {
+		res := s.Preflight(ctx, r.Addr)
+		r.Preflight = &res
+		if !res.OK {
+			r.Status, r.Error = importFailed, "preflight failed: "+res.Steps[len(res.Steps)-1].Detail
+			return nil
+		}
+	}
+	return ips
+}
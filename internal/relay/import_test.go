@@ -0,0 +1,94 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for bulk peer import
+
+package relay
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+func TestParsePeerList(t *testing.T) {
+	want := []stats.PeerImportEntry{
+		{Addr: "10.0.0.1"},
+		{Addr: "10.0.0.2:9000", Label: "Quake hub", Transport: "tls", Groups: []string{"eu", "quake"}},
+	}
+	for name, list := range map[string]string{
+		"json":    `["10.0.0.1", {"addr": "10.0.0.2:9000", "label": "Quake hub", "transport": "tls", "groups": ["eu", "quake"]}]`,
+		"wrapped": `{"peers": ["10.0.0.1", {"addr": "10.0.0.2:9000", "label": "Quake hub", "transport": "tls", "groups": ["eu", "quake"]}]}`,
+		"csv":     "addr,label,transport,groups\n# community relays\n10.0.0.1\n10.0.0.2:9000, Quake hub, tls, eu;quake\n",
+	} {
+		got, err := ParsePeerList([]byte(list))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("%s: expected %d entries, got %+v", name, len(want), got)
+		}
+		for i := range want {
+			if got[i].Addr != want[i].Addr || got[i].Label != want[i].Label || got[i].Transport != want[i].Transport || !slices.Equal(got[i].Groups, want[i].Groups) {
+				t.Errorf("%s: entry %d: expected %+v, got %+v", name, i, want[i], got[i])
+			}
+		}
+	}
+	if _, err := ParsePeerList([]byte(" \n")); err == nil {
+		t.Error("Expected an empty list to fail")
+	}
+}
+
+func TestImportPeers(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Peers = []config.PeerConfig{{Addr: "10.0.0.1:8787"}}
+	cfg.BannedHosts = []string{"10.0.0.5"}
+	srv, err := NewServer(cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.demoMode = true
+
+	entries := []stats.PeerImportEntry{
+		{Addr: "10.0.0.1"},
+		{Addr: "10.0.0.2", Label: "Doom hub", Groups: []string{"eu"}},
+		{Addr: "10.0.0.2:8787"},
+		{Addr: "10.0.0.3", Transport: "quic"},
+		{Addr: "10.0.0.7", Transport: "tcp"},
+		{Addr: "10.0.0.4:99999"},
+		{Addr: "10.0.0.5"},
+		{Addr: "10.0.0.6", Groups: []string{"us"}},
+	}
+	want := []string{importExists, importAdded, importSkipped, importFailed, importAdded, importFailed, importFailed, importAdded}
+
+	dry := srv.ImportPeers(context.Background(), entries, ImportOptions{DryRun: true})
+	if dry[1].Status != importValid || len(srv.configuredPeers()) != 1 {
+		t.Fatalf("Expected a dry run to change nothing, got %+v and peers %v", dry, srv.configuredPeers())
+	}
+
+	got := srv.ImportPeers(context.Background(), entries, ImportOptions{})
+	for i, r := range got {
+		if r.Status != want[i] {
+			t.Errorf("Entry %s: expected %s, got %s (%s)", r.Addr, want[i], r.Status, r.Error)
+		}
+	}
+	if peers := srv.configuredPeers(); !slices.Equal(peers, []string{"10.0.0.1:8787", "10.0.0.2:8787", "10.0.0.7:8787", "10.0.0.6:8787"}) {
+		t.Errorf("Expected three peers added, got %v", peers)
+	}
+	if pc := srv.peerSettings("10.0.0.7:8787"); pc.Transport != "tcp" {
+		t.Errorf("Expected the transport kept for the peer, got %+v", pc)
+	}
+	if pc := srv.peerSettings("10.0.0.2:8787"); pc.Name != "Doom hub" {
+		t.Errorf("Expected the label to name the peer, got %+v", pc)
+	}
+	if note := srv.cfg.PeerNotes["10.0.0.2"]; note != "Doom hub" {
+		t.Errorf("Expected the label kept as a note, got %q", note)
+	}
+
+	eu := srv.ImportPeers(context.Background(), []stats.PeerImportEntry{{Addr: "10.0.0.7"}, {Addr: "10.0.0.8", Groups: []string{"eu"}}}, ImportOptions{Groups: []string{"eu"}})
+	if eu[0].Status != importSkipped || eu[1].Status != importAdded {
+		t.Errorf("Expected only the eu entry imported, got %+v", eu)
+	}
+}
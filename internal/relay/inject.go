@@ -0,0 +1,146 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Local segment injection with retry and handle recovery
+
+package relay
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/capture"
+	"github.com/mlapointe/ipxtransporter/internal/ipx"
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+const (
+	injectRetryQueueSize = 64
+	injectMaxAttempts    = 3
+	injectRetryDelay     = 10 * time.Millisecond
+	injectReopenBackoff  = 5 * time.Second
+)
+
+type injectRetry struct {
+	data     []byte
+	attempts int
+}
+
+type injectCounters struct {
+	handleClosed uint64
+	transient    uint64
+	other        uint64
+	retried      uint64
+	retryDropped uint64
+	reopens      uint64
+	consecutive  uint64
+	reopening    int32
+	lastReopen   int64 // unix nanos
+}
+
+// injectWorker injects the frames of one shard of the inject queue.
+func (s *Server) injectWorker(ctx context.Context, shard int) {
+	for {
+		data, ok := s.injectQueue.pop(ctx, shard)
+		if !ok {
+			return
+		}
+		s.inject(data, 1)
+	}
+}
+
+// retryWorker re-attempts frames that failed with a recoverable error.
+func (s *Server) retryWorker(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case r := <-s.retryQueue:
+			time.Sleep(injectRetryDelay)
+			s.inject(r.data, r.attempts)
+		}
+	}
+}
+
+func (s *Server) inject(data []byte, attempt int) {
+	c := &s.injectErrs
+	err := s.capturer.Inject(data)
+	if err == nil {
+		ft, _ := ipx.DetectFrameType(data)
+		s.frames.AddTx(ft, len(data))
+		s.sockets.AddTx(data)
+		atomic.StoreUint64(&c.consecutive, 0)
+		if attempt > 1 {
+			atomic.AddUint64(&c.retried, 1)
+		}
+		return
+	}
+
+	atomic.AddUint64(&s.totalErrors, 1)
+	class := capture.ClassifyError(err)
+	switch class {
+	case capture.ErrClassHandleClosed:
+		atomic.AddUint64(&c.handleClosed, 1)
+	case capture.ErrClassTransient:
+		atomic.AddUint64(&c.transient, 1)
+	default:
+		atomic.AddUint64(&c.other, 1)
+	}
+
+	n := atomic.AddUint64(&c.consecutive, 1)
+	if class == capture.ErrClassHandleClosed {
+		s.reopenCapture("the capture handle closed")
+	} else if limit := s.cfg.SelfHeal.InjectFailures; limit > 0 && n >= uint64(limit) {
+		s.reopenCapture(fmt.Sprintf("%d consecutive injection failures", n))
+	}
+
+	if class != capture.ErrClassOther && attempt < injectMaxAttempts {
+		select {
+		case s.retryQueue <- injectRetry{data: data, attempts: attempt + 1}:
+			return
+		default:
+			atomic.AddUint64(&c.retryDropped, 1)
+		}
+	}
+	logger.Capture.Error("Failed to inject packet (%s, attempt %d): %v", class, attempt, err)
+}
+
+// reopenCapture reopens the pcap handle, at most once per backoff period.
+func (s *Server) reopenCapture(why string) {
+	c := &s.injectErrs
+	if time.Since(time.Unix(0, atomic.LoadInt64(&c.lastReopen))) < injectReopenBackoff {
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&c.reopening, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&c.reopening, 0)
+	atomic.StoreInt64(&c.lastReopen, time.Now().UnixNano())
+
+	atomic.AddUint64(&c.reopens, 1)
+	atomic.StoreUint64(&c.consecutive, 0)
+	op := s.beginOp(opCaptureRestart, why)
+	err := s.capturer.Reopen()
+	s.endOp(op, err)
+	if err != nil {
+		logger.Capture.Error("Failed to reopen capture handle: %v", err)
+		s.captureError.Store(err.Error())
+		return
+	}
+	logger.Capture.Warn("Self-heal: capture restarted after %s", why)
+	s.captureError.Store("")
+}
+
+func (s *Server) injectStats() stats.InjectStats {
+	c := &s.injectErrs
+	return stats.InjectStats{
+		HandleClosed: atomic.LoadUint64(&c.handleClosed),
+		Transient:    atomic.LoadUint64(&c.transient),
+		Other:        atomic.LoadUint64(&c.other),
+		Retried:      atomic.LoadUint64(&c.retried),
+		RetryDropped: atomic.LoadUint64(&c.retryDropped),
+		Reopens:      atomic.LoadUint64(&c.reopens),
+	}
+}
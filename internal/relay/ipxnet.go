@@ -0,0 +1,267 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// IPXNET host: attach DOSBox-compatible emulator clients over UDP
+
+package relay
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/ipx"
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+const (
+	ipxnetRegSocket = 0x2
+	ipxnetHeaderLen = 30
+)
+
+type virtualClient struct {
+	addr      *net.UDPAddr
+	network   uint32
+	node      net.HardwareAddr
+	assigned  bool
+	connected time.Time
+	lastSeen  atomic.Int64 // unix nanoseconds
+	rx, tx    atomic.Uint64
+}
+
+// ipxnetHost speaks the DOSBox IPXNET protocol: each UDP datagram carries a
+// bare IPX packet, and a client registers by sending a header addressed to
+// socket 2 of node 0. Registered clients are bridged into the relay as if
+// they were stations on the local segment.
+type ipxnetHost struct {
+	mu      sync.RWMutex
+	conn    *net.UDPConn
+	clients map[string]*virtualClient // by UDP source address
+}
+
+func newIPXNetHost() *ipxnetHost {
+	return &ipxnetHost{clients: make(map[string]*virtualClient)}
+}
+
+// parseVirtualNetwork decodes the configured virtual network number.
+func parseVirtualNetwork(s string) (uint32, error) {
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid virtual_network %q: want up to 8 hex digits", s)
+	}
+	return uint32(n), nil
+}
+
+func (s *Server) runIPXNet(ctx context.Context) {
+	addr, err := net.ResolveUDPAddr("udp", s.cfg.IPXNetListenAddr)
+	if err != nil {
+		logger.Relay.Error("IPXNET: invalid listen address %s: %v", s.cfg.IPXNetListenAddr, err)
+		return
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		logger.Relay.Error("IPXNET: failed to listen on %s: %v", s.cfg.IPXNetListenAddr, err)
+		return
+	}
+	logger.Relay.Info("IPXNET: hosting virtual clients on udp %s", conn.LocalAddr())
+
+	s.ipxnet.mu.Lock()
+	s.ipxnet.conn = conn
+	s.ipxnet.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		_ = conn.Close()
+	}()
+
+	buf := make([]byte, 2048)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() == nil {
+				logger.Relay.Error("IPXNET: read error: %v", err)
+			}
+			return
+		}
+		s.handleIPXNet(append([]byte(nil), buf[:n]...), from)
+	}
+}
+
+func (s *Server) handleIPXNet(pkt []byte, from *net.UDPAddr) {
+	if len(pkt) < ipxnetHeaderLen {
+		return
+	}
+	dstNet := binary.BigEndian.Uint32(pkt[6:10])
+	dstNode := pkt[10:16]
+	dstSocket := binary.BigEndian.Uint16(pkt[16:18])
+	if dstSocket == ipxnetRegSocket && dstNet == 0 && bytes.Equal(dstNode, make([]byte, 6)) {
+		s.registerVirtual(from)
+		return
+	}
+
+	s.ipxnet.mu.RLock()
+	c := s.ipxnet.clients[from.String()]
+	s.ipxnet.mu.RUnlock()
+	if c == nil {
+		return
+	}
+	c.lastSeen.Store(time.Now().UnixNano())
+	c.rx.Add(1)
+
+	frame, err := ipx.EncapEthernetII(pkt)
+	if err != nil {
+		return
+	}
+	s.deliverVirtual(frame, c)
+	s.deliverBridge(frame, nil)
+	s.deliverLegacy(frame)
+	s.ingestEmulated(frame)
+}
+
+// registerVirtual gives a client its IPX address and acknowledges it with
+// the registration reply DOSBox expects.
+func (s *Server) registerVirtual(from *net.UDPAddr) {
+	key := from.String()
+	c := &virtualClient{addr: from, connected: time.Now()}
+	if s.coordinator != nil {
+		c.network, c.node = s.coordinator.Assign(key)
+		if c.node == nil {
+			logger.Relay.Error("IPXNET: no free node numbers for client %s", key)
+			return
+		}
+		c.assigned = true
+	} else {
+		// DOSBox addressing: the node is the client's IPv4 address and port.
+		ip4 := from.IP.To4()
+		if ip4 == nil {
+			logger.Relay.Error("IPXNET: client %s is not IPv4 and address assignment is off", key)
+			return
+		}
+		c.node = make(net.HardwareAddr, 6)
+		copy(c.node, ip4)
+		binary.BigEndian.PutUint16(c.node[4:], uint16(from.Port))
+	}
+	c.lastSeen.Store(time.Now().UnixNano())
+
+	s.ipxnet.mu.Lock()
+	if old, ok := s.ipxnet.clients[key]; ok {
+		c.connected = old.connected
+	}
+	s.ipxnet.clients[key] = c
+	conn := s.ipxnet.conn
+	s.ipxnet.mu.Unlock()
+
+	// Record the client in the node table so peers' traffic for it is
+	// recognised as local and its echoes are suppressed.
+	s.macTable.LearnLocal(&ipx.Header{SrcMAC: c.node, Src: ipx.Addr{Network: c.network, Node: c.node}})
+
+	reply := make([]byte, ipxnetHeaderLen)
+	binary.BigEndian.PutUint16(reply[0:2], 0xffff)
+	binary.BigEndian.PutUint16(reply[2:4], ipxnetHeaderLen)
+	binary.BigEndian.PutUint32(reply[6:10], c.network)
+	copy(reply[10:16], c.node)
+	binary.BigEndian.PutUint16(reply[16:18], ipxnetRegSocket)
+	binary.BigEndian.PutUint32(reply[18:22], 1)
+	copy(reply[22:28], s.virtualServerNode())
+	binary.BigEndian.PutUint16(reply[28:30], ipxnetRegSocket)
+	if conn != nil {
+		if _, err := conn.WriteToUDP(reply, from); err != nil {
+			logger.Relay.Error("IPXNET: failed to acknowledge %s: %v", key, err)
+			return
+		}
+	}
+	logger.Relay.Info("IPXNET: registered %s as %08X:%s", key, c.network, c.node)
+}
+
+// virtualServerNode is the node we answer registrations from. The
+// coordinator never hands out counter 0, so it cannot collide with a client.
+func (s *Server) virtualServerNode() net.HardwareAddr {
+	node := net.HardwareAddr{0x02, 0, 0, 0, 0, 0}
+	b, _ := hex.DecodeString(s.nodeID)
+	copy(node[1:4], b)
+	return node
+}
+
+// deliverVirtual sends a frame to the virtual clients it is addressed to,
+// skipping the client it came from.
+func (s *Server) deliverVirtual(frame []byte, except *virtualClient) {
+	if s.ipxnet == nil {
+		return
+	}
+	s.ipxnet.mu.RLock()
+	defer s.ipxnet.mu.RUnlock()
+	if s.ipxnet.conn == nil || len(s.ipxnet.clients) == 0 {
+		return
+	}
+	pkt, err := ipx.Packet(frame)
+	if err != nil {
+		return
+	}
+	dst := net.HardwareAddr(pkt[10:16])
+	broadcast := bytes.Equal(dst, net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	for _, c := range s.ipxnet.clients {
+		if c == except || (!broadcast && !bytes.Equal(dst, c.node)) {
+			continue
+		}
+		if _, err := s.ipxnet.conn.WriteToUDP(pkt, c.addr); err != nil {
+			atomic.AddUint64(&s.totalErrors, 1)
+			continue
+		}
+		c.tx.Add(1)
+	}
+}
+
+// pruneVirtual drops clients that have been silent for longer than the MAC
+// table TTL and releases their addresses.
+func (s *Server) pruneVirtual() {
+	if s.ipxnet == nil {
+		return
+	}
+	ttl := time.Duration(s.cfg.MACTableTTL) * time.Second
+	s.ipxnet.mu.Lock()
+	defer s.ipxnet.mu.Unlock()
+	for key, c := range s.ipxnet.clients {
+		if time.Since(time.Unix(0, c.lastSeen.Load())) <= ttl {
+			continue
+		}
+		delete(s.ipxnet.clients, key)
+		if s.coordinator != nil {
+			s.coordinator.Release(key)
+		}
+		logger.Relay.Info("IPXNET: client %s (%s) timed out", key, c.node)
+	}
+}
+
+func (s *Server) collectVirtualClients() []stats.VirtualClient {
+	if s.ipxnet == nil {
+		return nil
+	}
+	s.ipxnet.mu.RLock()
+	out := make([]stats.VirtualClient, 0, len(s.ipxnet.clients))
+	for key, c := range s.ipxnet.clients {
+		out = append(out, stats.VirtualClient{
+			Addr:      key,
+			Network:   fmt.Sprintf("%08X", c.network),
+			Node:      c.node.String(),
+			Assigned:  c.assigned,
+			Connected: c.connected,
+			LastSeen:  time.Unix(0, c.lastSeen.Load()),
+			RxPackets: c.rx.Load(),
+			TxPackets: c.tx.Load(),
+		})
+	}
+	s.ipxnet.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Node < out[j].Node })
+	return out
+}
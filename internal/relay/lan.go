@@ -0,0 +1,73 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// LAN discovery: advertise the peer listener over mDNS and list other nodes
+
+package relay
+
+import (
+	"context"
+	"net"
+	"strconv"
+
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+	"github.com/mlapointe/ipxtransporter/internal/mdns"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+func (s *Server) runMDNS(ctx context.Context) {
+	_, portStr, err := net.SplitHostPort(s.cfg.ListenAddr)
+	port, _ := strconv.Atoi(portStr)
+	if err != nil || port == 0 {
+		logger.Relay.Error("mDNS: cannot advertise listen address %q", s.cfg.ListenAddr)
+		return
+	}
+
+	r := mdns.NewResponder(s.nodeID, s.hostname, port)
+	s.lanMu.Lock()
+	s.lan = r
+	s.lanMu.Unlock()
+
+	logger.Relay.Info("mDNS: advertising %s on port %d", mdns.Service, port)
+	if err := r.Run(ctx); err != nil {
+		logger.Relay.Error("mDNS: %v", err)
+	}
+}
+
+// RefreshLAN asks the LAN for other IPXTransporter nodes right away.
+func (s *Server) RefreshLAN() {
+	s.lanMu.Lock()
+	r := s.lan
+	s.lanMu.Unlock()
+	if r != nil {
+		r.Query()
+	}
+}
+
+// collectLANNodes lists the nodes discovered over mDNS, marking those
+// already in our overlay tree. Must be called with peersMu held.
+func (s *Server) collectLANNodes(topology []stats.TopologyNode) []stats.LANNode {
+	s.lanMu.Lock()
+	r := s.lan
+	s.lanMu.Unlock()
+	if r == nil {
+		return nil
+	}
+
+	inMesh := make(map[string]bool, len(topology))
+	for _, n := range topology {
+		inMesh[n.ID] = true
+	}
+	nodes := r.Nodes()
+	out := make([]stats.LANNode, 0, len(nodes))
+	for _, n := range nodes {
+		out = append(out, stats.LANNode{
+			Instance:  n.Instance,
+			ID:        n.ID,
+			Hostname:  n.Hostname,
+			Addr:      n.Addr,
+			LastSeen:  n.LastSeen,
+			Connected: n.ID != "" && inMesh[n.ID],
+		})
+	}
+	return out
+}
@@ -0,0 +1,403 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Legacy IPXNET bridge: join a DOSBox IPXNET server as a client during a migration
+
+package relay
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/ipx"
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+const (
+	legacyRegTimeout = 5 * time.Second
+	legacyRetry      = 30 * time.Second
+	// maxLegacyProxies bounds the registrations made for mesh stations,
+	// each a UDP socket of its own.
+	maxLegacyProxies = 64
+	maxLegacyClients = 256
+)
+
+var ipxBroadcast = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+// legacyLink is one registration with the legacy server, on a UDP socket
+// of its own, and the address the server gave it. A DOSBox server only
+// delivers unicast to addresses registered with it, so every mesh station
+// talking to its clients gets a link, a proxy, whose address stands in for
+// the station's on the server.
+type legacyLink struct {
+	conn     *net.UDPConn
+	network  uint32
+	node     net.HardwareAddr
+	station  ipx.Addr // the mesh station a proxy stands in for
+	lastUsed atomic.Int64
+}
+
+func (l *legacyLink) isProxy() bool {
+	return l.station.Node != nil
+}
+
+// legacyClient is a client of the legacy server, learned from its traffic
+// and listed as a pseudo-peer.
+type legacyClient struct {
+	network   uint32
+	node      net.HardwareAddr
+	firstSeen time.Time
+	lastSeen  atomic.Int64 // unix nanoseconds
+	rx, tx    atomic.Uint64
+}
+
+// legacyBridge joins a DOSBox IPXNET server as a client, so that its users
+// and the mesh see each other while they move over. Its main link receives
+// the server's broadcasts; proxies receive the unicast for mesh stations.
+type legacyBridge struct {
+	server string
+
+	mu      sync.RWMutex
+	ctx     context.Context
+	main    *legacyLink            // nil until registered
+	proxies map[string]*legacyLink // by mesh station node
+	pending map[string]time.Time   // stations whose proxy is registering or failed to
+	own     map[string]bool        // nodes of our links
+	clients map[string]*legacyClient
+}
+
+func newLegacyBridge(server string) *legacyBridge {
+	return &legacyBridge{
+		server:  server,
+		proxies: make(map[string]*legacyLink),
+		pending: make(map[string]time.Time),
+		own:     make(map[string]bool),
+		clients: make(map[string]*legacyClient),
+	}
+}
+
+// runLegacyIPXNet keeps the main link to the legacy server registered,
+// registering again if it fails.
+func (s *Server) runLegacyIPXNet(ctx context.Context) {
+	b := s.legacy
+	b.mu.Lock()
+	b.ctx = ctx
+	b.mu.Unlock()
+	for {
+		l, err := s.legacyRegister(ctx)
+		if err != nil {
+			logger.Relay.Warn("Legacy IPXNET: registering with %s: %v", b.server, err)
+		} else {
+			b.mu.Lock()
+			b.main = l
+			b.own[l.node.String()] = true
+			b.mu.Unlock()
+			logger.Relay.Info("Legacy IPXNET: bridging %s as %08X.%s", b.server, l.network, l.node)
+			s.legacyRead(ctx, l)
+			b.mu.Lock()
+			b.main = nil
+			delete(b.own, l.node.String())
+			b.mu.Unlock()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(legacyRetry):
+		}
+	}
+}
+
+// legacyRegister opens a socket to the legacy server and registers it the
+// way a DOSBox client does.
+func (s *Server) legacyRegister(ctx context.Context) (*legacyLink, error) {
+	host, port, err := net.SplitHostPort(s.legacy.server)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := s.resolver.Load().LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	raddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(ips[0], port))
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return nil, err
+	}
+	reg := make([]byte, ipxnetHeaderLen)
+	binary.BigEndian.PutUint16(reg[0:2], 0xffff)
+	binary.BigEndian.PutUint16(reg[2:4], ipxnetHeaderLen)
+	binary.BigEndian.PutUint16(reg[16:18], ipxnetRegSocket)
+	binary.BigEndian.PutUint16(reg[28:30], ipxnetRegSocket)
+	reply := make([]byte, 2048)
+	conn.SetReadDeadline(time.Now().Add(legacyRegTimeout))
+	if _, err := conn.Write(reg); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	for {
+		n, err := conn.Read(reply)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("no registration reply: %v", err)
+		}
+		// Broadcasts may arrive before the reply.
+		if n >= ipxnetHeaderLen && binary.BigEndian.Uint16(reply[16:18]) == ipxnetRegSocket {
+			conn.SetReadDeadline(time.Time{})
+			l := &legacyLink{
+				conn:    conn,
+				network: binary.BigEndian.Uint32(reply[6:10]),
+				node:    append(net.HardwareAddr(nil), reply[10:16]...),
+			}
+			l.lastUsed.Store(time.Now().UnixNano())
+			return l, nil
+		}
+	}
+}
+
+// legacyRead passes what the server sends on l to the mesh until the
+// socket is closed.
+func (s *Server) legacyRead(ctx context.Context, l *legacyLink) {
+	buf := make([]byte, 2048)
+	for {
+		n, err := l.conn.Read(buf)
+		if err != nil {
+			if ctx.Err() == nil && !errors.Is(err, net.ErrClosed) {
+				logger.Relay.Error("Legacy IPXNET: read error: %v", err)
+			}
+			return
+		}
+		s.legacyPacket(l, append([]byte(nil), buf[:n]...))
+	}
+}
+
+// legacyPacket relays a packet from a client of the legacy server. The main
+// link takes broadcasts and proxies the unicast addressed to them, which
+// goes on to the station they stand in for.
+func (s *Server) legacyPacket(l *legacyLink, pkt []byte) {
+	if len(pkt) < ipxnetHeaderLen {
+		return
+	}
+	b := s.legacy
+	dst := net.HardwareAddr(pkt[10:16])
+	src := net.HardwareAddr(pkt[22:28])
+	if bytes.Equal(dst, ipxBroadcast) == l.isProxy() {
+		return
+	}
+	b.mu.Lock()
+	if b.own[src.String()] {
+		// Our own broadcast, sent through a proxy.
+		b.mu.Unlock()
+		return
+	}
+	c := b.clients[src.String()]
+	if c == nil {
+		if len(b.clients) >= maxLegacyClients {
+			b.mu.Unlock()
+			return
+		}
+		c = &legacyClient{network: binary.BigEndian.Uint32(pkt[18:22]), node: append(net.HardwareAddr(nil), src...), firstSeen: time.Now()}
+		b.clients[src.String()] = c
+		logger.Relay.Info("Legacy IPXNET: client %08X.%s joined the mesh as legacy:%s", c.network, src, src)
+	}
+	b.mu.Unlock()
+	c.lastSeen.Store(time.Now().UnixNano())
+	c.rx.Add(1)
+	if l.isProxy() {
+		l.lastUsed.Store(time.Now().UnixNano())
+		binary.BigEndian.PutUint32(pkt[6:10], l.station.Network)
+		copy(pkt[10:16], l.station.Node)
+	}
+
+	frame, err := ipx.EncapEthernetII(pkt)
+	if err != nil {
+		return
+	}
+	s.deliverVirtual(frame, nil)
+	s.deliverBridge(frame, nil)
+	s.ingestEmulated(frame)
+}
+
+// deliverLegacy sends a frame from the mesh on to the legacy server if it
+// is a broadcast or addressed to one of its clients. It goes through the
+// proxy of its source station, with the proxy's address as its source;
+// until the proxy is registered the station's frames are dropped.
+func (s *Server) deliverLegacy(frame []byte) {
+	b := s.legacy
+	if b == nil {
+		return
+	}
+	pkt, err := ipx.Packet(frame)
+	if err != nil || len(pkt) < ipxnetHeaderLen {
+		return
+	}
+	dst := net.HardwareAddr(pkt[10:16])
+	src := net.HardwareAddr(pkt[22:28])
+	b.mu.RLock()
+	c := b.clients[dst.String()]
+	_, fromLegacy := b.clients[src.String()]
+	proxy := b.proxies[src.String()]
+	b.mu.RUnlock()
+	if fromLegacy || c == nil && !bytes.Equal(dst, ipxBroadcast) {
+		return
+	}
+	if proxy == nil {
+		s.legacyProxy(ipx.Addr{Network: binary.BigEndian.Uint32(pkt[18:22]), Node: src})
+		return
+	}
+	out := bytes.Clone(pkt)
+	binary.BigEndian.PutUint32(out[18:22], proxy.network)
+	copy(out[22:28], proxy.node)
+	if _, err := proxy.conn.Write(out); err != nil {
+		atomic.AddUint64(&s.totalErrors, 1)
+		return
+	}
+	proxy.lastUsed.Store(time.Now().UnixNano())
+	if c != nil {
+		c.tx.Add(1)
+	}
+}
+
+// legacyProxy registers a proxy for station in the background, unless one
+// is registering, failed within legacyRetry or there are too many.
+func (s *Server) legacyProxy(station ipx.Addr) {
+	b := s.legacy
+	key := station.Node.String()
+	b.mu.Lock()
+	ctx := b.ctx
+	if ctx == nil || b.main == nil || len(b.proxies)+len(b.pending) >= maxLegacyProxies || time.Since(b.pending[key]) < legacyRetry {
+		b.mu.Unlock()
+		return
+	}
+	b.pending[key] = time.Now()
+	b.mu.Unlock()
+	station.Node = append(net.HardwareAddr(nil), station.Node...)
+
+	go func() {
+		l, err := s.legacyRegister(ctx)
+		if err != nil {
+			logger.Relay.Warn("Legacy IPXNET: proxy for %08X.%s: %v", station.Network, station.Node, err)
+			return
+		}
+		l.station = station
+		b.mu.Lock()
+		delete(b.pending, key)
+		b.proxies[key] = l
+		b.own[l.node.String()] = true
+		b.mu.Unlock()
+		logger.Relay.Info("Legacy IPXNET: %08X.%s reaches the legacy clients as %08X.%s", station.Network, station.Node, l.network, l.node)
+		s.legacyRead(ctx, l)
+	}()
+}
+
+// pruneLegacy closes the proxies unused and forgets the clients silent for
+// longer than the MAC table TTL, and the proxies that failed to register.
+func (s *Server) pruneLegacy() {
+	b := s.legacy
+	if b == nil {
+		return
+	}
+	cutoff := time.Now().Add(-time.Duration(s.cfg.MACTableTTL) * time.Second).UnixNano()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for key, l := range b.proxies {
+		if l.lastUsed.Load() < cutoff {
+			l.conn.Close()
+			delete(b.proxies, key)
+			delete(b.own, l.node.String())
+		}
+	}
+	for key, at := range b.pending {
+		if time.Since(at) > legacyRetry {
+			delete(b.pending, key)
+		}
+	}
+	for key, c := range b.clients {
+		if c.lastSeen.Load() < cutoff {
+			delete(b.clients, key)
+			logger.Relay.Info("Legacy IPXNET: client %08X.%s timed out", c.network, c.node)
+		}
+	}
+}
+
+// collectLegacyClients reports every client of the legacy server as a
+// pseudo-peer.
+func (s *Server) collectLegacyClients() []stats.PeerStat {
+	b := s.legacy
+	if b == nil {
+		return nil
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	var ip net.IP
+	if b.main != nil {
+		ip = b.main.conn.RemoteAddr().(*net.UDPAddr).IP
+	}
+	out := make([]stats.PeerStat, 0, len(b.clients))
+	for _, c := range b.clients {
+		out = append(out, stats.PeerStat{
+			ID:          "legacy:" + c.node.String(),
+			IP:          ip,
+			ConnectedAt: c.firstSeen,
+			LastSeen:    time.Unix(0, c.lastSeen.Load()),
+			SentPkts:    c.tx.Load(),
+			RecvPkts:    c.rx.Load(),
+			Hostname:    fmt.Sprintf("legacy IPXNET client %08X.%s", c.network, c.node),
+			Inbound:     true,
+			Emulated:    true,
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// LegacyIPXNet reports the bridge to the legacy IPXNET server: its clients,
+// the pseudo-peers they are listed as and the proxies mesh stations reach
+// them through. It is nil unless legacy_ipxnet is set.
+func (s *Server) LegacyIPXNet() *stats.LegacyIPXNet {
+	b := s.legacy
+	if b == nil {
+		return nil
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	out := &stats.LegacyIPXNet{
+		Server:  b.server,
+		Clients: make([]stats.LegacyClient, 0, len(b.clients)),
+		Proxies: make([]stats.LegacyProxy, 0, len(b.proxies)),
+	}
+	if b.main != nil {
+		out.Registered = true
+		out.Address = fmt.Sprintf("%08X.%s", b.main.network, b.main.node)
+	}
+	for _, c := range b.clients {
+		out.Clients = append(out.Clients, stats.LegacyClient{
+			Address:   fmt.Sprintf("%08X.%s", c.network, c.node),
+			PeerID:    "legacy:" + c.node.String(),
+			FirstSeen: c.firstSeen,
+			LastSeen:  time.Unix(0, c.lastSeen.Load()),
+			RxPackets: c.rx.Load(),
+			TxPackets: c.tx.Load(),
+		})
+	}
+	for _, l := range b.proxies {
+		out.Proxies = append(out.Proxies, stats.LegacyProxy{
+			Station:  fmt.Sprintf("%08X.%s", l.station.Network, l.station.Node),
+			Proxy:    fmt.Sprintf("%08X.%s", l.network, l.node),
+			LastUsed: time.Unix(0, l.lastUsed.Load()),
+		})
+	}
+	sort.Slice(out.Clients, func(i, j int) bool { return out.Clients[i].Address < out.Clients[j].Address })
+	sort.Slice(out.Proxies, func(i, j int) bool { return out.Proxies[i].Station < out.Proxies[j].Station })
+	return out
+}
@@ -0,0 +1,151 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for the legacy IPXNET bridge
+
+package relay
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/ipx"
+)
+
+// fakeIPXNetServer forwards packets between its clients as DOSBox's IPXNET
+// server does: broadcasts to every other client, unicast by the node it
+// derives from each client's address and port.
+func fakeIPXNetServer(t *testing.T) *net.UDPConn {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		clients := make(map[string]*net.UDPAddr) // by node
+		buf := make([]byte, 2048)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			pkt := buf[:n]
+			if n < ipxnetHeaderLen {
+				continue
+			}
+			if binary.BigEndian.Uint16(pkt[16:18]) == ipxnetRegSocket && bytes.Equal(pkt[10:16], make([]byte, 6)) {
+				node := append(net.HardwareAddr(from.IP.To4()), byte(from.Port>>8), byte(from.Port))
+				clients[node.String()] = from
+				reply := make([]byte, ipxnetHeaderLen)
+				copy(reply[10:16], node)
+				binary.BigEndian.PutUint16(reply[16:18], ipxnetRegSocket)
+				conn.WriteToUDP(reply, from)
+				continue
+			}
+			dst := net.HardwareAddr(pkt[10:16])
+			for node, c := range clients {
+				if c.String() != from.String() && (bytes.Equal(dst, ipxBroadcast) || node == dst.String()) {
+					conn.WriteToUDP(pkt, c)
+				}
+			}
+		}
+	}()
+	return conn
+}
+
+// legacyUser registers a client of the fake server and returns its node.
+func legacyUser(t *testing.T, server net.Addr) (*net.UDPConn, net.HardwareAddr) {
+	conn, err := net.DialUDP("udp", nil, server.(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := make([]byte, ipxnetHeaderLen)
+	binary.BigEndian.PutUint16(reg[16:18], ipxnetRegSocket)
+	conn.Write(reg)
+	reply := make([]byte, 2048)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(reply); err != nil {
+		t.Fatal(err)
+	}
+	return conn, append(net.HardwareAddr(nil), reply[10:16]...)
+}
+
+func legacyPacketTo(dst, src net.HardwareAddr) []byte {
+	pkt := make([]byte, ipxnetHeaderLen+4)
+	binary.BigEndian.PutUint16(pkt[0:2], 0xffff)
+	binary.BigEndian.PutUint16(pkt[2:4], uint16(len(pkt)))
+	copy(pkt[10:16], dst)
+	binary.BigEndian.PutUint16(pkt[16:18], 0x4000)
+	copy(pkt[22:28], src)
+	binary.BigEndian.PutUint16(pkt[28:30], 0x4000)
+	return pkt
+}
+
+func TestLegacyIPXNetBridge(t *testing.T) {
+	server := fakeIPXNetServer(t)
+	defer server.Close()
+	cfg := config.DefaultConfig()
+	cfg.LegacyIPXNet = server.LocalAddr().String()
+	cfg.BroadcastWorkers = 1
+	srv, err := NewServer(cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.captureError.Store("no capture")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go srv.runLegacyIPXNet(ctx)
+	waitUntil(t, func() bool { return srv.LegacyIPXNet().Registered })
+
+	user, userNode := legacyUser(t, server.LocalAddr())
+	defer user.Close()
+	user.Write(legacyPacketTo(ipxBroadcast, userNode))
+	waitUntil(t, func() bool { return srv.broadcastQueue.len() == 1 })
+	if l := srv.LegacyIPXNet(); len(l.Clients) != 1 || l.Clients[0].PeerID != "legacy:"+userNode.String() {
+		t.Fatalf("Expected the user mapped to a pseudo-peer, got %+v", l.Clients)
+	}
+
+	// A mesh station's first frame registers its proxy, the next goes
+	// through it.
+	station := net.HardwareAddr{0x02, 0, 0, 0, 0, 9}
+	frame, _ := ipx.EncapEthernetII(legacyPacketTo(userNode, station))
+	srv.deliverLegacy(frame)
+	waitUntil(t, func() bool { return len(srv.LegacyIPXNet().Proxies) == 1 })
+	srv.deliverLegacy(frame)
+	got := make([]byte, 2048)
+	user.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := user.Read(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := net.HardwareAddr(got[22:28])
+	if n != ipxnetHeaderLen+4 || bytes.Equal(proxy, station) {
+		t.Fatalf("Expected the frame from the proxy's address, got % x", got[:n])
+	}
+
+	// The user's reply to the proxy reaches the station.
+	user.Write(legacyPacketTo(proxy, userNode))
+	waitUntil(t, func() bool { return srv.broadcastQueue.len() == 2 })
+	srv.broadcastQueue.pop(ctx, 0)
+	reply, _ := srv.broadcastQueue.pop(ctx, 0)
+	if h, err := ipx.Parse(reply); err != nil || !bytes.Equal(h.Dst.Node, station) {
+		t.Errorf("Expected the reply addressed to the station, got %v", h)
+	}
+	if c := srv.LegacyIPXNet().Clients[0]; c.RxPackets != 2 || c.TxPackets != 1 {
+		t.Errorf("Expected 2 packets from and 1 to the user, got %+v", c)
+	}
+}
+
+func waitUntil(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
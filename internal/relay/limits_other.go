@@ -0,0 +1,9 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// File descriptor limit check for platforms without rlimits
+
+//go:build !unix
+
+package relay
+
+func (s *Server) checkFileLimit() {}
@@ -0,0 +1,30 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// File descriptor limit check for hubs with many peers
+
+//go:build unix
+
+package relay
+
+import (
+	"syscall"
+
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+)
+
+// fdHeadroom covers the capture handle, listeners, the HTTP API, outbound
+// links and log files on top of one descriptor per child.
+const fdHeadroom = 64
+
+// checkFileLimit warns when the open file limit cannot hold max_children
+// links. Go already raises the soft limit to the hard limit at startup, so
+// a low value here means the hard limit itself needs raising.
+func (s *Server) checkFileLimit() {
+	var lim syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &lim); err != nil {
+		return
+	}
+	if need := uint64(s.cfg.MaxChildren + fdHeadroom); uint64(lim.Cur) < need {
+		logger.Relay.Warn("Open file limit %d is too low for max_children %d; raise it to at least %d", lim.Cur, s.cfg.MaxChildren, need)
+	}
+}
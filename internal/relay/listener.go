@@ -0,0 +1,119 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Peer listener: opened at start, reopened with backoff when it fails
+
+package relay
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+const (
+	listenRetryMin = 5 * time.Second
+	listenRetryMax = 2 * time.Minute
+	acceptBackoff  = 100 * time.Millisecond // after an accept error, such as running out of file descriptors
+)
+
+// ErrListenerDown is wrapped by the error of Start when the peer listener
+// could not be opened.
+var ErrListenerDown = errors.New("peer listener down")
+
+// listenState is why the peer listener is down and when it is retried.
+type listenState struct {
+	err   string
+	retry time.Time
+}
+
+// openListener opens the peer listener at listen_addr, with TLS unless
+// disable_ssl is on. The certificate is read anew each time, so one put in
+// place after a failure is picked up by the next retry.
+func (s *Server) openListener() (net.Listener, error) {
+	switch {
+	case s.cfg.DisableSSL:
+		return net.Listen("tcp", s.cfg.ListenAddr)
+	case s.acme != nil:
+		return tls.Listen("tcp", s.cfg.ListenAddr, s.acme.TLSConfig())
+	case s.cfg.TLSCertPath == "" && s.cfg.TLSKeyPath == "":
+		return nil, errors.New("no TLS certificate is set; run ipxtransporter --gen-cert to make one, or set disable_ssl")
+	}
+	cert, err := tls.LoadX509KeyPair(s.cfg.TLSCertPath, s.cfg.TLSKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS keys: %v", err)
+	}
+	tlsCfg := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS13}
+	return tls.Listen("tcp", s.cfg.ListenAddr, tlsCfg)
+}
+
+// listenPeers accepts peer links on listener until ctx is done. A nil
+// listener, one Start could not open, or one that fails is reopened after
+// a delay doubling up to listenRetryMax.
+func (s *Server) listenPeers(ctx context.Context, listener net.Listener, relayChan chan<- []byte) {
+	wait := listenRetryMin
+	for {
+		if listener == nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+			var err error
+			if listener, err = s.openListener(); err != nil {
+				wait = min(2*wait, listenRetryMax)
+				s.listenDown(err, wait)
+				continue
+			}
+			logger.Relay.Info("Accepting peer links on %s again", s.cfg.ListenAddr)
+		}
+		s.listenState.Store(nil)
+		s.changes.Notify(stats.ChangePeers)
+		wait = listenRetryMin
+
+		err := s.acceptPeers(ctx, listener, relayChan)
+		if ctx.Err() != nil {
+			return
+		}
+		listener = nil
+		s.listenDown(fmt.Errorf("listener failed: %v", err), wait)
+	}
+}
+
+// acceptPeers hands the links accepted on listener to handleNewConn until
+// ctx is done or the listener fails, and closes it.
+func (s *Server) acceptPeers(ctx context.Context, listener net.Listener, relayChan chan<- []byte) error {
+	stop := context.AfterFunc(ctx, func() { listener.Close() })
+	defer stop()
+	defer func() {
+		if err := listener.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			logger.Relay.Error("Error closing listener: %v", err)
+		}
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			logger.Relay.Error("Accept error: %v", err)
+			time.Sleep(acceptBackoff)
+			continue
+		}
+		go s.handleNewConn(ctx, conn, relayChan, "")
+	}
+}
+
+// listenDown records and logs why the peer listener is down and that it is
+// retried after wait. Links we dial still work, so the node runs on.
+func (s *Server) listenDown(err error, wait time.Duration) {
+	s.listenState.Store(&listenState{err: err.Error(), retry: time.Now().Add(wait)})
+	s.changes.Notify(stats.ChangePeers)
+	logger.Relay.Error("Not accepting peer links: %v; retrying in %s", err, wait)
+}
@@ -0,0 +1,44 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for the peer listener
+
+package relay
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+)
+
+func TestListenerDown(t *testing.T) {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.DisableSSL = true
+	cfg.ListenAddr = busy.Addr().String()
+	srv, err := NewServer(cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := srv.Start(ctx); !errors.Is(err, ErrListenerDown) {
+		t.Fatalf("Expected the listener reported down, got %v", err)
+	}
+	if st := srv.CollectStats(); st.ListenError == "" || st.ListenRetry.IsZero() {
+		t.Errorf("Expected the stats to show the listener down and its retry, got %q at %v", st.ListenError, st.ListenRetry)
+	}
+
+	cfg.DisableSSL = false
+	if _, err := srv.openListener(); err == nil || !strings.Contains(err.Error(), "--gen-cert") {
+		t.Errorf("Expected a missing certificate to point at --gen-cert, got %v", err)
+	}
+}
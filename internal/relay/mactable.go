@@ -0,0 +1,132 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// MAC learning table for local echo suppression
+
+package relay
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/ipx"
+)
+
+const (
+	originLocal  = "local"
+	originRemote = "remote"
+)
+
+type macEntry struct {
+	origin   string
+	node     net.HardwareAddr
+	network  uint32
+	lastSeen time.Time
+}
+
+// MACTable records whether a station lives on the local segment (learned
+// from capture) or behind a peer link (learned from relayed traffic). Frames
+// we inject are re-captured by pcap; knowing their source is remote lets us
+// drop the echo at capture time instead of relying on the dedup cache.
+type MACTable struct {
+	mu      sync.Mutex
+	entries map[string]*macEntry
+	ttl     time.Duration
+}
+
+func NewMACTable(ttlSeconds int) *MACTable {
+	return &MACTable{
+		entries: make(map[string]*macEntry),
+		ttl:     time.Duration(ttlSeconds) * time.Second,
+	}
+}
+
+// LearnLocal records a frame seen on the local segment. It reports false if
+// the source is known to be remote, i.e. the frame is an echo of one we
+// injected and should be dropped.
+func (t *MACTable) LearnLocal(h *ipx.Header) bool {
+	return t.learn(h, originLocal)
+}
+
+// LearnRemote records a frame received from a peer link.
+func (t *MACTable) LearnRemote(h *ipx.Header) {
+	t.learn(h, originRemote)
+}
+
+func (t *MACTable) learn(h *ipx.Header, origin string) bool {
+	key := string(h.SrcMAC)
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	e, ok := t.entries[key]
+	if ok && now.Sub(e.lastSeen) > t.ttl {
+		ok = false
+	}
+	if ok && origin == originLocal && e.origin == originRemote {
+		return false
+	}
+	if !ok {
+		e = &macEntry{}
+		t.entries[key] = e
+	}
+	e.origin = origin
+	e.node = append(e.node[:0], h.Src.Node...)
+	e.network = h.Src.Network
+	e.lastSeen = now
+	return true
+}
+
+// Known reports whether a live entry exists for the station mac.
+func (t *MACTable) Known(mac net.HardwareAddr) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e, ok := t.entries[string(mac)]
+	return ok && time.Since(e.lastSeen) <= t.ttl
+}
+
+// Prune removes entries that have not been seen within the TTL.
+func (t *MACTable) Prune() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for k, e := range t.entries {
+		if time.Since(e.lastSeen) > t.ttl {
+			delete(t.entries, k)
+		}
+	}
+}
+
+// Counts returns the number of live local and remote entries.
+func (t *MACTable) Counts() (local, remote int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, e := range t.entries {
+		if time.Since(e.lastSeen) > t.ttl {
+			continue
+		}
+		if e.origin == originLocal {
+			local++
+		} else {
+			remote++
+		}
+	}
+	return local, remote
+}
+
+// LocalNetworks returns the distinct non-zero IPX networks of live local
+// stations, i.e. the network numbers in use on our own segment.
+func (t *MACTable) LocalNetworks() []uint32 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	seen := make(map[uint32]bool)
+	var out []uint32
+	for _, e := range t.entries {
+		if e.origin != originLocal || e.network == 0 || seen[e.network] || time.Since(e.lastSeen) > t.ttl {
+			continue
+		}
+		seen[e.network] = true
+		out = append(out, e.network)
+	}
+	return out
+}
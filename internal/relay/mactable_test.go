@@ -0,0 +1,72 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for the MAC learning table
+
+package relay
+
+import (
+	"net"
+	"testing"
+
+	"github.com/mlapointe/ipxtransporter/internal/ipx"
+)
+
+func header(mac string) *ipx.Header {
+	hw, _ := net.ParseMAC(mac)
+	return &ipx.Header{SrcMAC: hw, Src: ipx.Addr{Network: 1, Node: hw}}
+}
+
+func TestMACTableEchoSuppression(t *testing.T) {
+	table := NewMACTable(300)
+
+	local := header("02:00:00:00:00:01")
+	remote := header("02:00:00:00:00:02")
+
+	if !table.LearnLocal(local) {
+		t.Error("Expected unknown local station to be accepted")
+	}
+
+	table.LearnRemote(remote)
+	if table.LearnLocal(remote) {
+		t.Error("Expected capture of a remote station to be flagged as an echo")
+	}
+
+	if l, r := table.Counts(); l != 1 || r != 1 {
+		t.Errorf("Expected 1 local and 1 remote entry, got %d/%d", l, r)
+	}
+}
+
+func TestMACTableExpiry(t *testing.T) {
+	table := NewMACTable(0)
+
+	remote := header("02:00:00:00:00:02")
+	table.LearnRemote(remote)
+	table.Prune()
+
+	if !table.LearnLocal(remote) {
+		t.Error("Expected expired remote entry to be relearned as local")
+	}
+}
+
+func TestMACTableLocalNetworks(t *testing.T) {
+	table := NewMACTable(300)
+
+	a := header("02:00:00:00:00:01")
+	b := header("02:00:00:00:00:02")
+	b.Src.Network = 2
+	c := header("02:00:00:00:00:03")
+	c.Src.Network = 0
+
+	table.LearnLocal(a)
+	table.LearnLocal(b)
+	table.LearnLocal(c)
+	table.LearnRemote(header("02:00:00:00:00:04"))
+
+	nets := table.LocalNetworks()
+	if len(nets) != 2 {
+		t.Fatalf("Expected 2 local networks, got %v", nets)
+	}
+	if nets[0]+nets[1] != 3 {
+		t.Errorf("Expected networks 1 and 2, got %v", nets)
+	}
+}
@@ -0,0 +1,514 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Mesh-wide captures: record on several nodes over the same period
+
+package relay
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/capture"
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+	"github.com/mlapointe/ipxtransporter/internal/peer"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+const (
+	// captureLead is the time between the command and the start, for it
+	// to reach every node first.
+	captureLead        = 2 * time.Second
+	maxCaptureDuration = 10 * time.Minute
+	// maxCaptureWait refuses a start so far ahead that the clocks or the
+	// command must be wrong.
+	maxCaptureWait     = time.Minute
+	captureChunkSize   = 32 * 1024
+	captureSendTimeout = 10 * time.Second
+	maxMeshCaptures    = 32
+
+	// Interfaces of the capture files.
+	captureSegment = 0 // frames from the interface and the emulators
+	captureMesh    = 1 // frames from peers
+)
+
+// CaptureRequest starts a mesh capture from this node. Nodes are node IDs,
+// all nodes if empty.
+type CaptureRequest struct {
+	Nodes      []string `json:"nodes"`
+	Duration   int      `json:"duration"` // seconds
+	MaxPackets int      `json:"max_packets"`
+	Pull       bool     `json:"pull"`
+}
+
+type meshCapture struct {
+	stat       stats.MeshCapture
+	upstream   string        // peer ID towards the initiator, "" at the initiator
+	offset     time.Duration // our clock minus the initiator's
+	maxPackets int
+	maxBytes   int
+	file       *os.File
+	w          *capture.PcapngWriter // set while recording
+	pulled     map[string]*pulledFile
+}
+
+// pulledFile is a file coming back to the initiator.
+type pulledFile struct {
+	file  *os.File
+	index int // in stat.Pulled
+}
+
+type captureState struct {
+	mu        sync.Mutex
+	recording atomic.Int32 // checked on every frame without the lock
+	byID      map[string]*meshCapture
+	order     []string // oldest first
+}
+
+func newCaptureState() captureState {
+	return captureState{byID: make(map[string]*meshCapture)}
+}
+
+// StartMeshCapture sends a capture command through the mesh and records
+// here too if this node is among the selected ones.
+func (s *Server) StartMeshCapture(req CaptureRequest) (stats.MeshCapture, error) {
+	d := time.Duration(req.Duration) * time.Second
+	if d <= 0 || d > maxCaptureDuration {
+		return stats.MeshCapture{}, fmt.Errorf("duration must be 1 to %d seconds", int(maxCaptureDuration/time.Second))
+	}
+	cmd := peer.CaptureCommand{
+		ID:         newNodeID() + newNodeID(),
+		Origin:     s.nodeID,
+		Nodes:      req.Nodes,
+		Start:      time.Now().Add(captureLead).UnixNano(),
+		Duration:   req.Duration,
+		MaxPackets: max(req.MaxPackets, 0),
+		MaxBytes:   s.cfg.CaptureMaxBytes,
+		Pull:       req.Pull,
+	}
+	s.joinCapture(cmd, "", 0)
+	s.forwardCapture(cmd, "", 0)
+	logger.Relay.Info("Capture %s: starting on %s for %ds", cmd.ID, captureTargets(cmd.Nodes), cmd.Duration)
+	c, _ := s.meshCapture(cmd.ID)
+	return c, nil
+}
+
+func captureTargets(nodes []string) string {
+	if len(nodes) == 0 {
+		return "all nodes"
+	}
+	return strings.Join(nodes, ", ")
+}
+
+// handleCapture joins a capture started elsewhere and passes the command
+// on. Our clock offset to the initiator is estimated from when the sender
+// says it sent the command and half the link's round trip.
+func (s *Server) handleCapture(p *peer.Peer, c peer.Control) {
+	cmd := c.Capture
+	if cmd == nil || cmd.Origin == s.nodeID {
+		return
+	}
+	if !validCaptureID(cmd.ID) {
+		logger.Relay.Warn("Ignoring capture command with a malformed ID from peer %s", p.ID)
+		return
+	}
+	if !s.honors(p, TrustKnown) {
+		logger.Relay.Debug("Ignoring capture command from untrusted peer %s", p.ID)
+		return
+	}
+	d := time.Duration(cmd.Duration) * time.Second
+	if d <= 0 || d > maxCaptureDuration {
+		return
+	}
+	if cmd.MaxBytes <= 0 || cmd.MaxBytes > s.cfg.CaptureMaxBytes {
+		cmd.MaxBytes = s.cfg.CaptureMaxBytes
+	}
+	latency := time.Duration(p.GetStats().LatencyMs * float64(time.Millisecond))
+	offset := time.Since(time.Unix(0, cmd.SentAt)) - latency/2
+	if !s.joinCapture(*cmd, p.ID, offset) {
+		return
+	}
+	s.forwardCapture(*cmd, p.ID, offset)
+}
+
+// validCaptureID reports whether id is as StartMeshCapture makes them:
+// 16 lowercase hex digits. The ID names the capture files, so anything
+// else from a peer could point them outside capture_dir.
+func validCaptureID(id string) bool {
+	if len(id) != 16 {
+		return false
+	}
+	for _, c := range id {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// capturePath is the file the capture with id records node's frames to.
+// It refuses a name that would leave capture_dir.
+func (s *Server) capturePath(id, node string) (string, error) {
+	name := fmt.Sprintf("ipx-%s-%s.pcapng", id, node)
+	if !filepath.IsLocal(name) || filepath.Base(name) != name {
+		return "", fmt.Errorf("capture file name %q is not a plain file name", name)
+	}
+	return filepath.Join(s.cfg.CaptureDir, name), nil
+}
+
+// forwardCapture sends the command to every peer but the one it came from.
+func (s *Server) forwardCapture(cmd peer.CaptureCommand, except string, offset time.Duration) {
+	cmd.SentAt = time.Now().Add(-offset).UnixNano()
+	s.peersMu.RLock()
+	defer s.peersMu.RUnlock()
+	for id, p := range s.peers {
+		if id != except {
+			p.SendControl(peer.Control{Type: peer.ControlCapture, Capture: &cmd})
+		}
+	}
+}
+
+// joinCapture records a capture command and schedules our recording if we
+// are selected. It returns false for a command seen before.
+func (s *Server) joinCapture(cmd peer.CaptureCommand, upstream string, offset time.Duration) bool {
+	mc := &meshCapture{
+		stat: stats.MeshCapture{
+			ID:       cmd.ID,
+			Origin:   cmd.Origin,
+			Nodes:    cmd.Nodes,
+			Start:    time.Unix(0, cmd.Start),
+			Duration: cmd.Duration,
+			OffsetMs: float64(offset.Microseconds()) / 1000,
+			Pull:     cmd.Pull,
+			State:    "relayed",
+		},
+		upstream:   upstream,
+		offset:     offset,
+		maxPackets: cmd.MaxPackets,
+		maxBytes:   cmd.MaxBytes,
+	}
+	if upstream == "" {
+		mc.pulled = make(map[string]*pulledFile)
+	}
+	selected := len(cmd.Nodes) == 0 || slices.Contains(cmd.Nodes, s.nodeID)
+	declined := selected && upstream != "" && !s.flags.remoteCapture.Load()
+
+	c := &s.meshCaps
+	c.mu.Lock()
+	if _, ok := c.byID[cmd.ID]; ok {
+		c.mu.Unlock()
+		return false
+	}
+	c.byID[cmd.ID] = mc
+	c.order = append(c.order, cmd.ID)
+	s.pruneCapturesLocked()
+	switch {
+	case declined:
+		mc.stat.State = "declined"
+	case selected:
+		mc.stat.State = "scheduled"
+	}
+	c.mu.Unlock()
+
+	switch {
+	case declined:
+		logger.Relay.Info("Capture %s from node %s declined: remote_capture is off", cmd.ID, cmd.Origin)
+		if cmd.Pull {
+			go s.sendCaptureChunk(mc.upstream, peer.CaptureChunk{ID: cmd.ID, Node: s.nodeID, Error: "remote capture is disabled"})
+		}
+	case selected:
+		go s.runRecording(mc)
+	}
+	return true
+}
+
+// pruneCapturesLocked forgets the oldest captures that are not recording.
+func (s *Server) pruneCapturesLocked() {
+	c := &s.meshCaps
+	for len(c.order) > maxMeshCaptures {
+		i := slices.IndexFunc(c.order, func(id string) bool {
+			st := c.byID[id].stat.State
+			return st != "scheduled" && st != "recording"
+		})
+		if i < 0 {
+			return
+		}
+		for _, pf := range c.byID[c.order[i]].pulled {
+			if pf.file != nil {
+				pf.file.Close()
+			}
+		}
+		delete(c.byID, c.order[i])
+		c.order = slices.Delete(c.order, i, i+1)
+	}
+}
+
+func (s *Server) runRecording(mc *meshCapture) {
+	start := mc.stat.Start.Add(mc.offset)
+	wait := time.Until(start)
+	if wait > maxCaptureWait {
+		s.failRecording(mc, fmt.Errorf("start is %s away, check the clocks", wait.Round(time.Second)))
+		return
+	}
+	sleepCtx(s.runCtx, wait)
+
+	path, err := s.capturePath(mc.stat.ID, s.nodeID)
+	if err != nil {
+		s.failRecording(mc, err)
+		return
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		s.failRecording(mc, err)
+		return
+	}
+	comment := fmt.Sprintf("IPXTransporter capture %s of node %s, started by node %s; timestamps on the initiator's clock, ours is %s off",
+		mc.stat.ID, s.nodeID, mc.stat.Origin, mc.offset.Round(time.Microsecond))
+	w, err := capture.NewPcapngWriter(f, comment)
+	if err == nil {
+		err = w.AddInterface("segment", s.cfg.Interface)
+	}
+	if err == nil {
+		err = w.AddInterface("mesh", "frames from peers")
+	}
+	if err != nil {
+		f.Close()
+		s.failRecording(mc, err)
+		return
+	}
+
+	c := &s.meshCaps
+	c.mu.Lock()
+	mc.file, mc.w = f, w
+	mc.stat.File = path
+	mc.stat.State = "recording"
+	c.mu.Unlock()
+	c.recording.Add(1)
+	logger.Relay.Info("Capture %s: recording to %s for %ds", mc.stat.ID, path, mc.stat.Duration)
+
+	sleepCtx(s.runCtx, time.Duration(mc.stat.Duration)*time.Second)
+
+	c.recording.Add(-1)
+	c.mu.Lock()
+	err = w.Flush()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	mc.w, mc.file = nil, nil
+	mc.stat.State = "done"
+	if err != nil {
+		mc.stat.State, mc.stat.Error = "failed", err.Error()
+	}
+	c.mu.Unlock()
+	logger.Relay.Info("Capture %s: recorded %d packets to %s", mc.stat.ID, mc.stat.Packets, path)
+
+	if err == nil && mc.stat.Pull && mc.upstream != "" {
+		s.sendCaptureFile(mc, path)
+	}
+}
+
+func (s *Server) failRecording(mc *meshCapture, err error) {
+	logger.Relay.Error("Capture %s: %v", mc.stat.ID, err)
+	s.meshCaps.mu.Lock()
+	mc.stat.State, mc.stat.Error = "failed", err.Error()
+	s.meshCaps.mu.Unlock()
+	if mc.stat.Pull && mc.upstream != "" {
+		s.sendCaptureChunk(mc.upstream, peer.CaptureChunk{ID: mc.stat.ID, Node: s.nodeID, Error: err.Error()})
+	}
+}
+
+// recordFrame adds a frame to the captures recording now.
+func (s *Server) recordFrame(iface int, data []byte) {
+	c := &s.meshCaps
+	if c.recording.Load() == 0 {
+		return
+	}
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, mc := range c.byID {
+		if mc.w == nil || mc.stat.Truncated {
+			continue
+		}
+		// An enhanced packet block adds 32 bytes and padding to the frame.
+		if mc.maxPackets > 0 && mc.stat.Packets >= uint64(mc.maxPackets) || mc.w.Written()+int64(len(data))+36 > int64(mc.maxBytes) {
+			mc.stat.Truncated = true
+			continue
+		}
+		if err := mc.w.WritePacket(iface, now.Add(-mc.offset), data); err != nil {
+			mc.stat.Truncated, mc.stat.Error = true, err.Error()
+			continue
+		}
+		mc.stat.Packets++
+	}
+}
+
+// sendCaptureFile sends a recorded file towards the initiator in chunks.
+func (s *Server) sendCaptureFile(mc *meshCapture, path string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		s.sendCaptureChunk(mc.upstream, peer.CaptureChunk{ID: mc.stat.ID, Node: s.nodeID, Error: err.Error()})
+		return
+	}
+	for off := 0; off < len(data); off += captureChunkSize {
+		chunk := peer.CaptureChunk{
+			ID:     mc.stat.ID,
+			Node:   s.nodeID,
+			Offset: int64(off),
+			Size:   int64(len(data)),
+			Data:   data[off:min(off+captureChunkSize, len(data))],
+		}
+		if !s.sendCaptureChunk(mc.upstream, chunk) {
+			logger.Relay.Warn("Capture %s: could not send the file to node %s", mc.stat.ID, mc.stat.Origin)
+			return
+		}
+	}
+}
+
+// sendCaptureChunk queues a chunk on a peer link, waiting for room in its
+// control queue for up to captureSendTimeout.
+func (s *Server) sendCaptureChunk(peerID string, chunk peer.CaptureChunk) bool {
+	c := peer.Control{Type: peer.ControlCaptureData, CaptureData: &chunk}
+	deadline := time.Now().Add(captureSendTimeout)
+	for {
+		s.peersMu.RLock()
+		p := s.peers[peerID]
+		s.peersMu.RUnlock()
+		if p == nil {
+			return false
+		}
+		if p.SendControl(c) {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// handleCaptureData passes a chunk on towards the initiator, or stores it
+// if that is us. Chunks only travel back the way the command came.
+func (s *Server) handleCaptureData(p *peer.Peer, c peer.Control) {
+	ch := c.CaptureData
+	if ch == nil {
+		return
+	}
+	caps := &s.meshCaps
+	caps.mu.Lock()
+	mc, ok := caps.byID[ch.ID]
+	if !ok || mc.upstream == p.ID || ch.Size < 0 || ch.Size > int64(mc.maxBytes) {
+		caps.mu.Unlock()
+		return
+	}
+	if mc.upstream != "" {
+		up := mc.upstream
+		caps.mu.Unlock()
+		go s.sendCaptureChunk(up, *ch)
+		return
+	}
+	err := s.storeChunkLocked(mc, ch)
+	caps.mu.Unlock()
+	if err != nil {
+		logger.Relay.Warn("Capture %s: dropping data from node %s: %v", ch.ID, ch.Node, err)
+	}
+}
+
+// storeChunkLocked writes a chunk of a pulled file. Must be called with
+// meshCaps.mu held.
+func (s *Server) storeChunkLocked(mc *meshCapture, ch *peer.CaptureChunk) error {
+	if ch.Node == "" || strings.ContainsAny(ch.Node, `/\.`) {
+		return errors.New("bad node ID")
+	}
+	if len(mc.stat.Nodes) > 0 && !slices.Contains(mc.stat.Nodes, ch.Node) {
+		return errors.New("node was not asked to record")
+	}
+	pf := mc.pulled[ch.Node]
+	if pf == nil {
+		pf = &pulledFile{index: len(mc.stat.Pulled)}
+		mc.pulled[ch.Node] = pf
+		mc.stat.Pulled = append(mc.stat.Pulled, stats.CaptureFile{Node: ch.Node, Size: ch.Size})
+		if ch.Error == "" {
+			path, err := s.capturePath(mc.stat.ID, ch.Node)
+			if err != nil {
+				mc.stat.Pulled[pf.index].Error = err.Error()
+				return err
+			}
+			f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+			if err != nil {
+				mc.stat.Pulled[pf.index].Error = err.Error()
+				return err
+			}
+			pf.file = f
+			mc.stat.Pulled[pf.index].File = path
+		}
+	}
+	st := &mc.stat.Pulled[pf.index]
+	if ch.Error != "" {
+		st.Error = ch.Error
+		logger.Relay.Warn("Capture %s: node %s recorded nothing: %s", mc.stat.ID, ch.Node, ch.Error)
+		return nil
+	}
+	if pf.file == nil || ch.Size != st.Size || ch.Offset < 0 || ch.Offset+int64(len(ch.Data)) > st.Size {
+		return errors.New("chunk does not fit the file")
+	}
+	if _, err := pf.file.WriteAt(ch.Data, ch.Offset); err != nil {
+		st.Error = err.Error()
+		return err
+	}
+	st.Received += int64(len(ch.Data))
+	if st.Received >= st.Size {
+		pf.file.Close()
+		pf.file = nil
+		logger.Relay.Info("Capture %s: received %s from node %s", mc.stat.ID, st.File, ch.Node)
+	}
+	return nil
+}
+
+// MeshCaptures lists the captures this node started or took part in,
+// oldest first.
+func (s *Server) MeshCaptures() []stats.MeshCapture {
+	c := &s.meshCaps
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make([]stats.MeshCapture, 0, len(c.order))
+	for _, id := range c.order {
+		st := c.byID[id].stat
+		st.Pulled = slices.Clone(st.Pulled)
+		out = append(out, st)
+	}
+	return out
+}
+
+func (s *Server) meshCapture(id string) (stats.MeshCapture, bool) {
+	for _, c := range s.MeshCaptures() {
+		if c.ID == id {
+			return c, true
+		}
+	}
+	return stats.MeshCapture{}, false
+}
+
+// MeshCaptureFile returns the path of a finished capture file: ours, or one
+// pulled back from node.
+func (s *Server) MeshCaptureFile(id, node string) (string, bool) {
+	c, ok := s.meshCapture(id)
+	if !ok {
+		return "", false
+	}
+	if node == "" || node == s.nodeID {
+		return c.File, c.State == "done"
+	}
+	for _, f := range c.Pulled {
+		if f.Node == node {
+			return f.File, f.File != "" && f.Received == f.Size
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,181 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for mesh-wide captures
+
+package relay
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/peer"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+func waitCapture(t *testing.T, srv *Server, id, state string) stats.MeshCapture {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		c, ok := srv.meshCapture(id)
+		if ok && c.State == state {
+			return c
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected capture %s to be %s, got %+v", id, state, c)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestMeshCaptureRecording(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.CaptureDir = t.TempDir()
+	srv, err := NewServer(cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	srv.runCtx = ctx
+
+	cmd := peer.CaptureCommand{ID: "c1", Origin: srv.nodeID, Start: time.Now().UnixNano(), Duration: 60, MaxPackets: 1, MaxBytes: cfg.CaptureMaxBytes}
+	if !srv.joinCapture(cmd, "", 0) {
+		t.Fatal("Expected a new capture to be joined")
+	}
+	if srv.joinCapture(cmd, "", 0) {
+		t.Error("Expected a capture seen before to be ignored")
+	}
+	waitCapture(t, srv, "c1", "recording")
+	srv.recordFrame(captureSegment, []byte{1, 2, 3})
+	srv.recordFrame(captureMesh, []byte{4, 5, 6})
+	cancel()
+
+	c := waitCapture(t, srv, "c1", "done")
+	if c.Packets != 1 || !c.Truncated {
+		t.Errorf("Expected 1 packet and a truncated capture, got %d and %v", c.Packets, c.Truncated)
+	}
+	data, err := os.ReadFile(c.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte{0x0A, 0x0D, 0x0D, 0x0A}) {
+		t.Errorf("Expected a pcapng file, got % x", data[:min(len(data), 8)])
+	}
+	if path, ok := srv.MeshCaptureFile("c1", ""); !ok || path != c.File {
+		t.Errorf("Expected %s to be served, got %q", c.File, path)
+	}
+}
+
+func TestServerHandleCapture(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.TrustDefaults["inbound"] = TrustUnknown
+	cfg.PeerTrust = map[string]string{"10.0.0.1": TrustKnown}
+	srv, err := NewServer(cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newPeer := func(id, ip string) *peer.Peer {
+		p := peer.NewPeer(id, &fakeConn{remoteAddr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 8787}}, "")
+		p.Inbound = true
+		srv.peers[id] = p
+		return p
+	}
+	known := newPeer("known", "10.0.0.1")
+	stranger := newPeer("stranger", "10.0.0.2")
+
+	// The sender stamps the command on the initiator's clock, 5s behind ours.
+	cmd := &peer.CaptureCommand{ID: "00000000000000c2", Origin: "far", Start: time.Now().UnixNano(), SentAt: time.Now().Add(-5 * time.Second).UnixNano(), Duration: 10}
+	srv.handleCapture(stranger, peer.Control{Type: peer.ControlCapture, Capture: cmd})
+	if _, ok := srv.meshCapture("00000000000000c2"); ok {
+		t.Fatal("Expected a command from an untrusted peer to be ignored")
+	}
+
+	srv.handleCapture(known, peer.Control{Type: peer.ControlCapture, Capture: cmd})
+	c, ok := srv.meshCapture("00000000000000c2")
+	if !ok {
+		t.Fatal("Expected the command from a known peer to be taken")
+	}
+	if c.State != "declined" {
+		t.Errorf("Expected the capture declined with remote_capture off, got %s", c.State)
+	}
+	if c.OffsetMs < 4900 || c.OffsetMs > 5100 {
+		t.Errorf("Expected a clock offset of about 5000ms, got %.1f", c.OffsetMs)
+	}
+}
+
+func TestServerPulledCaptureFile(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.CaptureDir = t.TempDir()
+	srv, err := NewServer(cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	child := peer.NewPeer("child", &fakeConn{remoteAddr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 8787}}, "")
+	srv.peers["child"] = child
+	srv.joinCapture(peer.CaptureCommand{ID: "c3", Origin: srv.nodeID, Nodes: []string{"leaf"}, Start: time.Now().Add(time.Hour).UnixNano(), Duration: 1, MaxBytes: cfg.CaptureMaxBytes}, "", 0)
+
+	send := func(chunk peer.CaptureChunk) {
+		srv.handleCaptureData(child, peer.Control{Type: peer.ControlCaptureData, CaptureData: &chunk})
+	}
+	send(peer.CaptureChunk{ID: "c3", Node: "other", Size: 4, Data: []byte("nope")})
+	send(peer.CaptureChunk{ID: "c3", Node: "leaf", Offset: 3, Size: 6, Data: []byte("def")})
+	if _, ok := srv.MeshCaptureFile("c3", "leaf"); ok {
+		t.Error("Expected a partly received file not to be served")
+	}
+	send(peer.CaptureChunk{ID: "c3", Node: "leaf", Offset: 0, Size: 6, Data: []byte("abc")})
+
+	c, _ := srv.meshCapture("c3")
+	if len(c.Pulled) != 1 || c.Pulled[0].Node != "leaf" || c.Pulled[0].Received != 6 {
+		t.Fatalf("Expected the file of the selected node only, got %+v", c.Pulled)
+	}
+	path, ok := srv.MeshCaptureFile("c3", "leaf")
+	if !ok {
+		t.Fatal("Expected the received file to be served")
+	}
+	if data, _ := os.ReadFile(path); string(data) != "abcdef" {
+		t.Errorf("Expected abcdef, got %q", data)
+	}
+}
+
+func TestServerCaptureTraversal(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.RemoteCapture = true
+	dir := t.TempDir()
+	cfg.CaptureDir = filepath.Join(dir, "captures")
+	cfg.PeerTrust = map[string]string{"10.0.0.1": TrustKnown}
+	srv, err := NewServer(cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	known := peer.NewPeer("known", &fakeConn{remoteAddr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 8787}}, "")
+	known.Inbound = true
+	srv.peers["known"] = known
+
+	for _, id := range []string{"/../../evil", "../evil", "0123456789ABCDEF", "0123456789abcde", ""} {
+		cmd := &peer.CaptureCommand{ID: id, Origin: "far", Start: time.Now().UnixNano(), SentAt: time.Now().UnixNano(), Duration: 1}
+		srv.handleCapture(known, peer.Control{Type: peer.ControlCapture, Capture: cmd})
+		if _, ok := srv.meshCapture(id); ok {
+			t.Errorf("Expected the capture ID %q to be refused", id)
+		}
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("Expected no file written, got %v", entries)
+	}
+
+	// A node name from a peer cannot lead the pulled file out either.
+	srv.joinCapture(peer.CaptureCommand{ID: "00000000000000c4", Origin: srv.nodeID, Start: time.Now().Add(time.Hour).UnixNano(), Duration: 1, MaxBytes: cfg.CaptureMaxBytes}, "", 0)
+	chunk := peer.CaptureChunk{ID: "00000000000000c4", Node: "x/../../../evil", Size: 3, Data: []byte("bad")}
+	srv.handleCaptureData(known, peer.Control{Type: peer.ControlCaptureData, CaptureData: &chunk})
+	if _, err := os.Stat(filepath.Join(dir, "evil.pcapng")); err == nil {
+		t.Error("Expected no file written outside capture_dir")
+	}
+	c, _ := srv.meshCapture("00000000000000c4")
+	if len(c.Pulled) != 0 {
+		t.Errorf("Expected the pulled file refused, got %+v", c.Pulled)
+	}
+}
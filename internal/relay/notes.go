@@ -0,0 +1,56 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Peer notes set from the TUI
+
+package relay
+
+import (
+	"maps"
+
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+// LabelPeers sets the note of peers, or removes it if note is empty. A
+// note already kept under a peer's ID, node ID or IP is replaced where it
+// is; a new one goes under the node ID, or the IP, which outlast the ID of
+// an inbound link.
+func (s *Server) LabelPeers(peers []stats.PeerStat, note string) {
+	s.replica.mu.Lock()
+	if s.cfg.PeerNotes == nil {
+		s.cfg.PeerNotes = map[string]string{}
+	}
+	for _, p := range peers {
+		keys := []string{p.ID, p.NodeID, p.IP.String()}
+		found := false
+		for _, key := range keys {
+			if _, ok := s.cfg.PeerNotes[key]; !ok || key == "" {
+				continue
+			}
+			found = true
+			if note == "" {
+				delete(s.cfg.PeerNotes, key)
+			} else {
+				s.cfg.PeerNotes[key] = note
+				break
+			}
+		}
+		if !found && note != "" {
+			key := p.NodeID
+			if key == "" {
+				key = p.IP.String()
+			}
+			s.cfg.PeerNotes[key] = note
+		}
+	}
+	all := maps.Clone(s.cfg.PeerNotes)
+	s.replica.mu.Unlock()
+	s.columns.SetNotes(all)
+	s.persistConfig()
+	s.changes.Notify(stats.ChangePeers)
+	if note == "" {
+		logger.Relay.Info("Removed the label of %d peers", len(peers))
+	} else {
+		logger.Relay.Info("Labelled %d peers %q", len(peers), note)
+	}
+}
@@ -0,0 +1,41 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for peer notes
+
+package relay
+
+import (
+	"maps"
+	"net"
+	"testing"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+func TestLabelPeers(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.PeerNotes = map[string]string{"10.0.0.1:40000": "old"}
+	srv, err := NewServer(cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	peers := []stats.PeerStat{
+		{ID: "10.0.0.1:40000", IP: net.ParseIP("10.0.0.1")},
+		{ID: "10.0.0.2:40000", IP: net.ParseIP("10.0.0.2"), NodeID: "node-2"},
+		{ID: "10.0.0.3:40000", IP: net.ParseIP("10.0.0.3")},
+	}
+	srv.LabelPeers(peers, "griefers")
+	want := map[string]string{"10.0.0.1:40000": "griefers", "node-2": "griefers", "10.0.0.3": "griefers"}
+	if !maps.Equal(srv.cfg.PeerNotes, want) {
+		t.Errorf("Expected %v, got %v", want, srv.cfg.PeerNotes)
+	}
+	if note := srv.Columns().Note(peers[1]); note != "griefers" {
+		t.Errorf("Expected the columns to show the label, got %q", note)
+	}
+
+	srv.LabelPeers(peers[:2], "")
+	if want := map[string]string{"10.0.0.3": "griefers"}; !maps.Equal(srv.cfg.PeerNotes, want) {
+		t.Errorf("Expected %v left, got %v", want, srv.cfg.PeerNotes)
+	}
+}
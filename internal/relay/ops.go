@@ -0,0 +1,89 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Operations in flight, reported until shortly after they end
+
+package relay
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+// Kinds of stats.Operation.
+const (
+	opDial           = "dial"
+	opRotateSecret   = "rotate_secret"
+	opDrain          = "drain"
+	opCaptureRestart = "capture_restart"
+	opPreflight      = "preflight"
+)
+
+const (
+	// opLinger is how long a finished operation is still reported, so
+	// its result is seen.
+	opLinger = 30 * time.Second
+	maxOps   = 64
+)
+
+type opsState struct {
+	mu   sync.Mutex
+	next uint64
+	ops  []stats.Operation // oldest first
+}
+
+// beginOp records an operation in flight and returns its ID for endOp.
+func (s *Server) beginOp(kind, target string) uint64 {
+	o := &s.ops
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	s.changes.Notify(stats.ChangeOps)
+	o.next++
+	o.ops = append(o.ops, stats.Operation{ID: o.next, Kind: kind, Target: target, Started: time.Now()})
+	if len(o.ops) > maxOps {
+		o.ops = o.ops[len(o.ops)-maxOps:]
+	}
+	return o.next
+}
+
+// endOp records how an operation ended, err nil for success.
+func (s *Server) endOp(id uint64, err error) {
+	o := &s.ops
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	s.changes.Notify(stats.ChangeOps)
+	for i := range o.ops {
+		if o.ops[i].ID == id {
+			o.ops[i].Ended = time.Now()
+			if err != nil {
+				o.ops[i].Error = err.Error()
+			}
+			return
+		}
+	}
+}
+
+// operations returns those in flight and those ended within opLinger.
+func (s *Server) operations(now time.Time) []stats.Operation {
+	o := &s.ops
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	kept := o.ops[:0]
+	for _, op := range o.ops {
+		if op.Ended.IsZero() || now.Sub(op.Ended) < opLinger {
+			kept = append(kept, op)
+		}
+	}
+	o.ops = kept
+	return append([]stats.Operation(nil), kept...)
+}
+
+// opsInFlight reports whether an operation has not ended yet.
+func (s *Server) opsInFlight() bool {
+	o := &s.ops
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return slices.ContainsFunc(o.ops, func(op stats.Operation) bool { return op.Ended.IsZero() })
+}
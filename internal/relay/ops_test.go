@@ -0,0 +1,51 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for operations in flight
+
+package relay
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+)
+
+func TestServerOperations(t *testing.T) {
+	srv, err := NewServer(config.DefaultConfig(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dial := srv.beginOp(opDial, "10.0.0.1:8787")
+	drain := srv.beginOp(opDrain, "")
+	now := time.Now()
+	if ops := srv.operations(now); len(ops) != 2 || !ops[0].Ended.IsZero() {
+		t.Fatalf("Expected two operations in flight, got %+v", ops)
+	}
+
+	srv.endOp(dial, errors.New("connection refused"))
+	srv.endOp(drain, nil)
+	ops := srv.operations(now)
+	if len(ops) != 2 || ops[0].Error != "connection refused" || ops[1].Error != "" || ops[1].Ended.IsZero() {
+		t.Errorf("Expected a failed dial and a finished drain, got %+v", ops)
+	}
+	if ops := srv.operations(time.Now().Add(opLinger)); len(ops) != 0 {
+		t.Errorf("Expected finished operations gone after %s, got %+v", opLinger, ops)
+	}
+}
+
+func TestServerDialOperation(t *testing.T) {
+	srv, err := NewServer(config.DefaultConfig(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	srv.connectToPeer(ctx, "10.0.0.1:8787", srv.peerRelayChan)
+	ops := srv.CollectStats().Operations
+	if len(ops) != 1 || ops[0].Kind != opDial || ops[0].Target != "10.0.0.1:8787" || ops[0].Error != "dialer stopped" {
+		t.Errorf("Expected the stopped dial reported, got %+v", ops)
+	}
+}
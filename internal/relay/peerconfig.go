@@ -0,0 +1,100 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Per-peer settings: the transport, pinned key and settings of a dialed link
+
+package relay
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+	"github.com/mlapointe/ipxtransporter/internal/peer"
+)
+
+// peerTLSConfig is the TLS client config for a link to pc. Certificates
+// are not verified against a CA, but a peer with a fingerprint must
+// present one holding that key.
+func peerTLSConfig(pc config.PeerConfig) *tls.Config {
+	tlsCfg := &tls.Config{InsecureSkipVerify: true, MinVersion: tls.VersionTLS13} // Production should verify
+	if host := peerHost(pc.Addr); net.ParseIP(host) == nil {
+		tlsCfg.ServerName = host
+	}
+	if want := config.NormalFingerprint(pc.Fingerprint); want != "" {
+		tlsCfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			return checkFingerprint(cs, want)
+		}
+	}
+	return tlsCfg
+}
+
+// checkFingerprint checks that the certificate of a TLS link holds the
+// key with fingerprint want.
+func checkFingerprint(cs tls.ConnectionState, want string) error {
+	if len(cs.PeerCertificates) == 0 {
+		return fmt.Errorf("no certificate presented, key %.16s… expected", want)
+	}
+	got, err := peer.Fingerprint(cs.PeerCertificates[0].PublicKey)
+	if err != nil {
+		return err
+	}
+	if got != want {
+		return fmt.Errorf("certificate key %.16s… is not the expected %.16s…", got, want)
+	}
+	return nil
+}
+
+// AddPeerConfig adds the peer pc, or gives a configured one the settings
+// of pc; a link already dialed to it is redialed with them.
+func (s *Server) AddPeerConfig(ctx context.Context, pc config.PeerConfig) error {
+	pc.Addr = withDefaultPort(pc.Addr)
+	if err := pc.Validate(s.cfg.DisableSSL); err != nil {
+		return err
+	}
+	s.roomMu.Lock()
+	if s.cfg.Room != nil {
+		s.roomMu.Unlock()
+		if !pc.AddrOnly() {
+			return errors.New("room members take no settings; leave the room to configure peers")
+		}
+		s.AddPeer(ctx, pc.Addr)
+		return nil
+	}
+	i := slices.IndexFunc(s.cfg.Peers, func(old config.PeerConfig) bool { return old.Addr == pc.Addr })
+	if i >= 0 {
+		s.cfg.Peers[i] = pc
+	} else {
+		s.cfg.Peers = append(s.cfg.Peers, pc)
+	}
+	s.roomMu.Unlock()
+	s.persistConfig()
+
+	if i < 0 {
+		if !s.demoMode {
+			s.startDialer(s.runCtx, pc.Addr)
+		}
+		logger.Relay.Info("Manually added peer: %s", pc.Addr)
+		return nil
+	}
+	s.peersMu.RLock()
+	for id, p := range s.peers {
+		if !p.Inbound && p.DialAddr == pc.Addr {
+			if err := p.Conn.Close(); err != nil {
+				logger.Relay.Error("Error closing peer %s connection on new settings: %v", id, err)
+			}
+		}
+	}
+	s.peersMu.RUnlock()
+	logger.Relay.Info("Updated the settings of peer %s", pc.Addr)
+	return nil
+}
+
+// linkSettings returns what the peer pc sets for its link.
+func linkSettings(pc config.PeerConfig) peer.Settings {
+	return peer.Settings{Name: pc.Name, Cost: pc.Cost, Compression: pc.Compression, RateLimit: pc.RateLimit, Filters: pc.Filters}
+}
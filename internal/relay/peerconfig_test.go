@@ -0,0 +1,75 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for per-peer settings
+
+package relay
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/peer"
+)
+
+func TestCheckFingerprint(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	fp, _ := peer.Fingerprint(key.Public())
+	cs := tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+
+	if err := checkFingerprint(cs, fp); err != nil {
+		t.Errorf("Expected the pinned key accepted, got %v", err)
+	}
+	if err := checkFingerprint(cs, strings.Repeat("0", 64)); err == nil {
+		t.Error("Expected another key refused")
+	}
+	if err := checkFingerprint(tls.ConnectionState{}, fp); err == nil {
+		t.Error("Expected a link without a certificate refused")
+	}
+}
+
+func TestPeerSettingsKept(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Peers = []config.PeerConfig{{Addr: "10.0.0.1:8787", Name: "Upstairs", RateLimit: 4096}}
+	srv, err := NewServer(cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.demoMode = true
+
+	// Peers added by address leave the settings of the others alone.
+	srv.AddPeer(context.Background(), "10.0.0.2")
+	if pc := srv.peerSettings("10.0.0.1:8787"); pc.Name != "Upstairs" || pc.RateLimit != 4096 {
+		t.Errorf("Expected the settings kept, got %+v", pc)
+	}
+	if pc := srv.peerSettings("10.0.0.2:8787"); !pc.AddrOnly() {
+		t.Errorf("Expected no settings for a peer added by address, got %+v", pc)
+	}
+
+	if err := srv.AddPeerConfig(context.Background(), config.PeerConfig{Addr: "10.0.0.2", Compression: true}); err != nil {
+		t.Fatal(err)
+	}
+	if pc := srv.peerSettings("10.0.0.2:8787"); !pc.Compression || len(cfg.Peers) != 2 {
+		t.Errorf("Expected the settings of the configured peer replaced, got %+v in %+v", pc, cfg.Peers)
+	}
+	if err := srv.AddPeerConfig(context.Background(), config.PeerConfig{Addr: "10.0.0.3", Transport: "udp"}); err == nil {
+		t.Error("Expected an unknown transport refused")
+	}
+	if len(cfg.Peers) != 2 {
+		t.Errorf("Expected a refused peer not added, got %+v", cfg.Peers)
+	}
+}
@@ -0,0 +1,50 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Gateway port mapping for the peer listener
+
+package relay
+
+import (
+	"context"
+	"net"
+	"strconv"
+
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+	"github.com/mlapointe/ipxtransporter/internal/natmap"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+// runPortMapping asks the gateway to forward the peer listener's port so
+// that nodes behind consumer routers can accept inbound links.
+func (s *Server) runPortMapping(ctx context.Context) {
+	_, portStr, err := net.SplitHostPort(s.cfg.ListenAddr)
+	port, _ := strconv.Atoi(portStr)
+	if err != nil || port == 0 {
+		logger.Relay.Error("Port mapping: cannot map listen address %q", s.cfg.ListenAddr)
+		return
+	}
+
+	m := natmap.NewMapper(port)
+	s.lanMu.Lock()
+	s.portMap = m
+	s.lanMu.Unlock()
+
+	logger.Relay.Info("Port mapping: requesting TCP port %d from the gateway", port)
+	m.Run(ctx)
+}
+
+func (s *Server) portMapping() *stats.PortMapping {
+	s.lanMu.Lock()
+	m := s.portMap
+	s.lanMu.Unlock()
+	if m == nil {
+		return nil
+	}
+	st := m.Status()
+	return &stats.PortMapping{
+		Method:   st.Method,
+		External: st.External,
+		Expires:  st.Expires,
+		Error:    st.Error,
+	}
+}
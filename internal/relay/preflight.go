@@ -0,0 +1,206 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Trial links to a peer address before it is added
+
+package relay
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+	"github.com/mlapointe/ipxtransporter/internal/peer"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+// preflightTimeout bounds a whole preflight.
+const preflightTimeout = 10 * time.Second
+
+// Preflight tries a link to addr as a dialer would, without relaying
+// anything over it: it resolves the address, connects, checks the TLS
+// certificate, exchanges network keys and waits for the peer's hello. Our
+// hello marks the link as a probe, so the peer sends it no traffic before
+// it is closed. A configured peer is tried with its settings.
+func (s *Server) Preflight(ctx context.Context, addr string) stats.Preflight {
+	return s.PreflightPeer(ctx, s.peerSettings(withDefaultPort(addr)))
+}
+
+// PreflightPeer is Preflight for a peer with the settings of pc, its
+// transport and fingerprint.
+func (s *Server) PreflightPeer(ctx context.Context, pc config.PeerConfig) stats.Preflight {
+	ctx, cancel := context.WithTimeout(ctx, preflightTimeout)
+	defer cancel()
+	addr := withDefaultPort(pc.Addr)
+	pc.Addr = addr
+	op := s.beginOp(opPreflight, addr)
+	res := stats.Preflight{Addr: addr}
+	var failed error
+	step := func(name, detail string, err error) bool {
+		if err != nil {
+			detail, failed = err.Error(), err
+		}
+		res.Steps = append(res.Steps, stats.PreflightStep{Name: name, OK: err == nil, Detail: detail})
+		return err == nil
+	}
+	defer func() {
+		s.endOp(op, failed)
+		if failed != nil {
+			logger.Relay.Info("Preflight of %s failed: %v", addr, failed)
+		}
+	}()
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		step("resolve", "", err)
+		return res
+	}
+	ips, err := s.resolver.Load().LookupHost(ctx, host)
+	if !step("resolve", strings.Join(ips, ", "), err) {
+		return res
+	}
+
+	start := time.Now()
+	conn, err := s.resolver.Load().DialContext(ctx, &net.Dialer{}, "tcp", addr)
+	if err != nil {
+		step("connect", "", err)
+		return res
+	}
+	res.RTTMs = float64(time.Since(start).Microseconds()) / 1000
+	step("connect", fmt.Sprintf("%s in %.1f ms", conn.RemoteAddr(), res.RTTMs), nil)
+
+	if pc.TLS(s.cfg.DisableSSL) {
+		tc := tls.Client(conn, peerTLSConfig(pc))
+		detail := ""
+		err := tc.HandshakeContext(ctx)
+		if err == nil {
+			detail, err = certStatus(tc.ConnectionState(), host, time.Now())
+		}
+		if err == nil && pc.Fingerprint != "" {
+			detail += ", key as pinned"
+		}
+		if !step("tls", detail, err) {
+			conn.Close()
+			return res
+		}
+		conn = tc
+	}
+
+	p := peer.NewPeer(conn.RemoteAddr().String(), conn, s.linkKey())
+	p.DialAddr = addr
+	p.NoLookup = true
+	p.SetWriterPool(s.writers)
+	p.SetMute(true, true)
+	if s.signer != nil {
+		p.SetSigner(s.signer)
+	}
+	hellos := make(chan peer.Control, 1)
+	p.SetControlHandler(func(_ *peer.Peer, c peer.Control) {
+		if c.Type == peer.ControlHello {
+			select {
+			case hellos <- c:
+			default:
+			}
+		}
+	})
+	hello := s.hello(p)
+	hello.Probe = true
+	p.SendControl(hello)
+	done := make(chan struct{})
+	go p.Run(ctx, make(chan []byte), func(string) { close(done) })
+	defer func() {
+		p.Conn.Close()
+		<-done
+	}()
+
+	var c peer.Control
+	select {
+	case c = <-hellos:
+	case <-done:
+	case <-ctx.Done():
+	}
+	if c.Type == "" {
+		select {
+		case c = <-hellos:
+		default:
+		}
+	}
+
+	if !p.Authenticated() {
+		err := errors.New("the peer closed the link during the key exchange: its network_key differs or it refused us")
+		if ctx.Err() != nil {
+			err = errors.New("no answer to the key exchange within " + preflightTimeout.String())
+		}
+		step("network_key", "", err)
+		return res
+	}
+	if s.linkKey() != "" {
+		step("network_key", "accepted", nil)
+	} else {
+		step("network_key", "none set, links are open to anyone", nil)
+	}
+
+	switch {
+	case c.Type == "" && ctx.Err() != nil:
+		step("hello", "", errors.New("no hello within "+preflightTimeout.String()))
+		return res
+	case c.Type == "":
+		step("hello", "", errors.New("the peer closed the link before its hello: it may have banned us, be full or hold a different network_key"))
+		return res
+	case c.NodeID == s.nodeID:
+		step("hello", "", errors.New("that address is this node"))
+		return res
+	}
+	res.NodeID, res.KeyFingerprint, res.Trust = c.NodeID, p.KeyFingerprint(), s.peerTrust(p)
+	detail := "node " + c.NodeID + ", unsigned"
+	if res.KeyFingerprint != "" {
+		detail = fmt.Sprintf("node %s, signed by key %.16s…", c.NodeID, res.KeyFingerprint)
+	}
+	step("hello", detail+", trust "+res.Trust, nil)
+	protocol, features := p.Negotiated()
+	detail = fmt.Sprintf("protocol %d with %s", protocol, featureList(features))
+	var policyErr error
+	if problem := handshakeProblem(s.cfg.HandshakePolicy[groupConfigured], protocol, features); problem != "" {
+		policyErr = fmt.Errorf("handshake_policy: configured peers %s", problem)
+	}
+	if !step("handshake", detail, policyErr) {
+		return res
+	}
+	res.OK = true
+	return res
+}
+
+// certStatus describes the peer's certificate, failing if it has expired
+// or is not yet valid. One no public CA vouches for is fine: peers are
+// told apart by the keys signing their hellos.
+func certStatus(cs tls.ConnectionState, host string, now time.Time) (string, error) {
+	if len(cs.PeerCertificates) == 0 {
+		return "", errors.New("the peer sent no certificate")
+	}
+	cert := cs.PeerCertificates[0]
+	name := cert.Subject.CommonName
+	if name == "" {
+		name = cert.Subject.String()
+	}
+	if now.After(cert.NotAfter) {
+		return "", fmt.Errorf("certificate %q expired on %s", name, cert.NotAfter.Format(time.DateOnly))
+	}
+	if now.Before(cert.NotBefore) {
+		return "", fmt.Errorf("certificate %q is not valid until %s", name, cert.NotBefore.Format(time.DateOnly))
+	}
+	pool := x509.NewCertPool()
+	for _, c := range cs.PeerCertificates[1:] {
+		pool.AddCert(c)
+	}
+	vouched := "self-signed or from a private CA"
+	if _, err := cert.Verify(x509.VerifyOptions{DNSName: host, Intermediates: pool, CurrentTime: now}); err == nil {
+		vouched = "valid for " + host
+	}
+	return fmt.Sprintf("%s, certificate %q (%s) until %s", tls.VersionName(cs.Version), name, vouched, cert.NotAfter.Format(time.DateOnly)), nil
+}
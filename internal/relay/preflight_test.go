@@ -0,0 +1,102 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for peer preflights
+
+package relay
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+)
+
+// preflightPair returns a node dialing without TLS and the address of a
+// hub accepting links with networkKey.
+func preflightPair(t *testing.T, ctx context.Context, networkKey string) (*Server, *Server, string) {
+	hcfg := config.DefaultConfig()
+	hcfg.NetworkKey = networkKey
+	hub, err := NewServer(hcfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lcfg := config.DefaultConfig()
+	lcfg.DisableSSL = true
+	leaf, err := NewServer(lcfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go hub.handleNewConn(ctx, conn, hub.peerRelayChan, "")
+		}
+	}()
+	return leaf, hub, l.Addr().String()
+}
+
+func TestPreflight(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	leaf, hub, addr := preflightPair(t, ctx, "")
+
+	res := leaf.Preflight(ctx, addr)
+	if !res.OK || res.NodeID != hub.NodeID() || len(res.Steps) != 5 || res.RTTMs <= 0 {
+		t.Errorf("Expected a passing preflight reaching the hub, got %+v", res)
+	}
+	if ops := leaf.CollectStats().Operations; len(ops) != 1 || ops[0].Kind != opPreflight || ops[0].Error != "" {
+		t.Errorf("Expected the preflight reported as a finished operation, got %+v", ops)
+	}
+	leaf.peersMu.RLock()
+	linked := len(leaf.peers)
+	leaf.peersMu.RUnlock()
+	if linked != 0 || len(leaf.cfg.Peers) != 0 {
+		t.Errorf("Expected the preflight to leave no link and no configured peer, got %d and %v", linked, leaf.cfg.Peers)
+	}
+}
+
+func TestPreflightFailures(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	leaf, _, addr := preflightPair(t, ctx, "hub-key")
+	leaf.cfg.NetworkKey = "typo"
+
+	res := leaf.Preflight(ctx, addr)
+	if res.OK || len(res.Steps) != 3 || res.Steps[2].Name != "network_key" || res.Steps[2].OK {
+		t.Errorf("Expected the preflight to fail at the network key, got %+v", res)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := l.Addr().String()
+	l.Close()
+	res = leaf.Preflight(ctx, closed)
+	if res.OK || len(res.Steps) != 2 || res.Steps[1].Name != "connect" || res.Steps[1].OK {
+		t.Errorf("Expected the preflight to fail to connect, got %+v", res)
+	}
+
+	leaf.cfg.NetworkKey = "hub-key"
+	leaf.cfg.HandshakePolicy = config.HandshakePolicies{"configured": {Require: []string{"signed"}}}
+	res = leaf.Preflight(ctx, addr)
+	if res.OK || len(res.Steps) != 5 || res.Steps[4].Name != "handshake" || res.Steps[4].OK {
+		t.Errorf("Expected an unsigned hub to fail the handshake policy, got %+v", res)
+	}
+	leaf.cfg.HandshakePolicy = nil
+
+	res = leaf.Preflight(ctx, "peer.invalid")
+	if res.OK || res.Addr != "peer.invalid:8787" || len(res.Steps) != 1 || res.Steps[0].Name != "resolve" || res.Steps[0].OK {
+		t.Errorf("Expected a name that does not resolve to fail, got %+v", res)
+	}
+}
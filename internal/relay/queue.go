@@ -0,0 +1,131 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Relay stage queues and traffic sampling
+
+package relay
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+
+	"github.com/mlapointe/ipxtransporter/internal/ipx"
+)
+
+// stageQueue feeds a pool of stage workers, one per shard. The frames of a
+// station all go to the same shard, so however many workers there are, a
+// station's frames leave in the order they came; SPX and games rely on it.
+// Frames assigned a priority by the rule engine go through the expedited
+// lane of their shard, which its worker always drains first.
+type stageQueue struct {
+	shards []stageLanes
+}
+
+type stageLanes struct {
+	high   chan []byte
+	normal chan []byte
+}
+
+// newStageQueue makes a queue of workers shards holding up to size frames
+// in each lane.
+func newStageQueue(size, workers int) *stageQueue {
+	q := &stageQueue{shards: make([]stageLanes, max(workers, 1))}
+	for i := range q.shards {
+		q.shards[i] = stageLanes{high: make(chan []byte, size), normal: make(chan []byte, size)}
+	}
+	return q
+}
+
+// shardOf is the shard of the station that sent data: its IPX source
+// address, or the first shard for frames that do not parse.
+func (q *stageQueue) shardOf(data []byte) int {
+	if len(q.shards) == 1 {
+		return 0
+	}
+	h, err := ipx.Parse(data)
+	if err != nil {
+		return 0
+	}
+	f := fnv.New32a()
+	f.Write([]byte{byte(h.Src.Network >> 24), byte(h.Src.Network >> 16), byte(h.Src.Network >> 8), byte(h.Src.Network)})
+	f.Write(h.Src.Node)
+	f.Write([]byte{byte(h.Src.Socket >> 8), byte(h.Src.Socket)})
+	return int(f.Sum32() % uint32(len(q.shards)))
+}
+
+// push enqueues a frame without blocking and reports whether it was accepted.
+func (q *stageQueue) push(data []byte, priority int) bool {
+	s := &q.shards[q.shardOf(data)]
+	lane := s.normal
+	if priority > 0 {
+		lane = s.high
+	}
+	select {
+	case lane <- data:
+		return true
+	default:
+		return false
+	}
+}
+
+// pop blocks until a frame of shard is available or ctx is done.
+func (q *stageQueue) pop(ctx context.Context, shard int) ([]byte, bool) {
+	s := &q.shards[shard]
+	select {
+	case data := <-s.high:
+		return data, true
+	default:
+	}
+	select {
+	case <-ctx.Done():
+		return nil, false
+	case data := <-s.high:
+		return data, true
+	case data := <-s.normal:
+		return data, true
+	}
+}
+
+// workers is the number of shards, each served by one worker.
+func (q *stageQueue) workers() int {
+	return len(q.shards)
+}
+
+func (q *stageQueue) len() int {
+	n := 0
+	for _, s := range q.shards {
+		n += len(s.high) + len(s.normal)
+	}
+	return n
+}
+
+// sampleRing keeps copies of the most recent frames for rule dry-runs.
+type sampleRing struct {
+	mu     sync.Mutex
+	frames [][]byte
+	next   int
+	full   bool
+}
+
+func newSampleRing(size int) *sampleRing {
+	return &sampleRing{frames: make([][]byte, size)}
+}
+
+func (r *sampleRing) add(data []byte) {
+	r.mu.Lock()
+	r.frames[r.next] = append([]byte(nil), data...)
+	r.next = (r.next + 1) % len(r.frames)
+	if r.next == 0 {
+		r.full = true
+	}
+	r.mu.Unlock()
+}
+
+func (r *sampleRing) snapshot() [][]byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.full {
+		return append([][]byte(nil), r.frames[:r.next]...)
+	}
+	return append(append([][]byte(nil), r.frames[r.next:]...), r.frames[:r.next]...)
+}
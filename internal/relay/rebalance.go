@@ -0,0 +1,244 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Hierarchy rebalancing: keep child counts within MaxChildren across the mesh
+
+package relay
+
+import (
+	"context"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+	"github.com/mlapointe/ipxtransporter/internal/peer"
+)
+
+const statusInterval = 10 * time.Second
+
+func sleepCtx(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
+
+// localChildren counts peers attached directly below this node.
+func (s *Server) localChildren() int {
+	s.peersMu.RLock()
+	defer s.peersMu.RUnlock()
+	n := 0
+	for _, p := range s.peers {
+		if p.GetStats().ParentID == "Local" {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *Server) localStatus() peer.Control {
+	return peer.Control{
+		Type:        peer.ControlStatus,
+		ListenAddr:  s.cfg.ListenAddr,
+		NumChildren: s.localChildren(),
+		MaxChildren: s.cfg.MaxChildren,
+		Networks:    s.macTable.LocalNetworks(),
+		Advertise:   s.cfg.Advertise,
+	}
+}
+
+// runStatus periodically tells every peer how loaded this node is and which
+// IPX networks live here, and re-checks the mesh for network conflicts.
+func (s *Server) runStatus(ctx context.Context) {
+	ticker := time.NewTicker(statusInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.detectConflicts()
+			s.detectAnomalies(time.Now())
+			status := s.localStatus()
+			status.Declares = true
+			s.peersMu.RLock()
+			for _, p := range s.peers {
+				status.Declared = s.declaredFor(p)
+				// The cost our config gives a link we dialed is the
+				// link's at both ends.
+				status.Cost = 0
+				if !p.Inbound {
+					status.Cost = p.ConfiguredCost()
+				}
+				p.SendControl(status)
+			}
+			s.peersMu.RUnlock()
+		}
+	}
+}
+
+func (s *Server) handlePeerControl(p *peer.Peer, c peer.Control) {
+	switch c.Type {
+	case peer.ControlRedirect:
+		// Only the node we dialed (our parent) may move us elsewhere.
+		if p.DialAddr == "" || c.Addr == "" {
+			logger.Relay.Info("Ignoring redirect from child peer %s", p.ID)
+			return
+		}
+		logger.Relay.Info("Peer %s redirected us to %s", p.ID, c.Addr)
+		s.stopDialer(p.DialAddr)
+		if err := p.Conn.Close(); err != nil {
+			logger.Relay.Error("Error closing peer %s connection on redirect: %v", p.ID, err)
+		}
+		s.startDialer(s.runCtx, c.Addr)
+	case peer.ControlHello:
+		s.handleHello(p, c)
+	case peer.ControlTopology:
+		s.handleTopology(p, c)
+	case peer.ControlGossip:
+		s.handleGossip(p, c)
+	case peer.ControlSegments:
+		s.handleSegments(p, c)
+	case peer.ControlCapture:
+		s.handleCapture(p, c)
+	case peer.ControlCaptureData:
+		s.handleCaptureData(p, c)
+	case peer.ControlReplicate:
+		s.handleReplicate(p, c)
+	case peer.ControlRelayRequest:
+		s.handleRelayRequest(p, c)
+	case peer.ControlRelayAccept:
+		s.handleRelayAccept(p, c)
+	case peer.ControlRelayRefuse:
+		s.handleRelayRefuse(p, c)
+	}
+}
+
+func (s *Server) sendRedirect(p *peer.Peer, addr string) {
+	if p.SendControl(peer.Control{Type: peer.ControlRedirect, Addr: addr}) {
+		atomic.AddUint64(&s.redirects, 1)
+		logger.Relay.Info("Redirecting peer %s to %s", p.ID, addr)
+	}
+}
+
+type rebalanceCandidate struct {
+	id     string
+	addr   string
+	spare  int
+	metric int
+}
+
+// rebalanceCandidates lists children that advertised a listener and still
+// have room for more children of their own, least loaded first.
+func (s *Server) rebalanceCandidates() []*rebalanceCandidate {
+	s.peersMu.RLock()
+	defer s.peersMu.RUnlock()
+
+	var out []*rebalanceCandidate
+	for _, p := range s.peers {
+		if !p.Inbound || !s.honors(p, TrustKnown) {
+			continue
+		}
+		st := p.GetStats()
+		addr := p.RemoteListenAddr()
+		if addr == "" || st.MaxChildren <= 0 || st.NumChildren >= st.MaxChildren {
+			continue
+		}
+		out = append(out, &rebalanceCandidate{
+			id:     p.ID,
+			addr:   addr,
+			spare:  st.MaxChildren - st.NumChildren,
+			metric: st.Metric,
+		})
+	}
+	sortCandidates(out)
+	return out
+}
+
+func sortCandidates(c []*rebalanceCandidate) {
+	sort.Slice(c, func(i, j int) bool {
+		if c[i].spare != c[j].spare {
+			return c[i].spare > c[j].spare
+		}
+		return c[i].metric < c[j].metric
+	})
+}
+
+// redirectTarget returns the listen address of the best child to take on
+// another node, or "" if none has spare capacity.
+func (s *Server) redirectTarget(excludeID string) string {
+	for _, c := range s.rebalanceCandidates() {
+		if c.id != excludeID {
+			return c.addr
+		}
+	}
+	return ""
+}
+
+// rebalanceNetwork moves the children with the worst link metrics below
+// less-loaded siblings until we are back within MaxChildren. Every node
+// runs the same pass over its own children, which keeps the limit
+// respected across the mesh.
+func (s *Server) rebalanceNetwork() {
+	if s.demoMode {
+		// In demo mode, we just let the runDemo hierarchical generation handle it
+		return
+	}
+
+	s.peersMu.RLock()
+	var children []*peer.Peer
+	for _, p := range s.peers {
+		if p.Inbound {
+			children = append(children, p)
+		}
+	}
+	s.peersMu.RUnlock()
+
+	excess := len(children) - s.cfg.MaxChildren
+	if excess <= 0 {
+		return
+	}
+
+	// The costliest links move first
+	metrics := make(map[*peer.Peer]int, len(children))
+	for _, p := range children {
+		metrics[p] = p.LinkMetric()
+	}
+	sort.Slice(children, func(i, j int) bool {
+		return metrics[children[i]] > metrics[children[j]]
+	})
+
+	candidates := s.rebalanceCandidates()
+	moved := make(map[string]bool)
+	targeted := make(map[string]bool)
+	for _, child := range children {
+		if excess == 0 {
+			break
+		}
+		if targeted[child.ID] {
+			continue
+		}
+		var target *rebalanceCandidate
+		for _, c := range candidates {
+			if c.id != child.ID && !moved[c.id] && c.spare > 0 {
+				target = c
+				break
+			}
+		}
+		if target == nil {
+			continue
+		}
+		s.sendRedirect(child, target.addr)
+		moved[child.ID] = true
+		targeted[target.id] = true
+		target.spare--
+		sortCandidates(candidates)
+		excess--
+	}
+
+	if excess > 0 {
+		logger.Relay.Info("Rebalance: %d children over limit with no spare capacity below us", excess)
+	} else {
+		logger.Relay.Info("Network rebalanced: redirected %d children", len(moved))
+	}
+}
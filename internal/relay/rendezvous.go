@@ -0,0 +1,271 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Relay-assisted NAT traversal: forward traffic between peers that cannot link directly
+
+package relay
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+	"github.com/mlapointe/ipxtransporter/internal/peer"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+// maxRelayPaths bounds how many node pairs we forward for at once, since
+// every relayed frame costs us bandwidth in both directions.
+const maxRelayPaths = 16
+
+// relayPath is a pair of our peers whose traffic we forward to each other.
+type relayPath struct {
+	a, b    string // node IDs
+	since   time.Time
+	packets atomic.Uint64
+}
+
+type rendezvousState struct {
+	mu    sync.RWMutex
+	paths map[string]*relayPath // served by us, by relayPathKey
+	via   map[string]string     // node ID we reach through a rendezvous -> the rendezvous' node ID
+}
+
+func newRendezvousState() rendezvousState {
+	return rendezvousState{
+		paths: make(map[string]*relayPath),
+		via:   make(map[string]string),
+	}
+}
+
+func relayPathKey(a, b string) string {
+	if a > b {
+		a, b = b, a
+	}
+	return a + "|" + b
+}
+
+// RequestRelay asks a peer that links directly to nodeID to forward traffic
+// between that node and us, for when neither side can accept the other's
+// connection.
+func (s *Server) RequestRelay(nodeID string) error {
+	if nodeID == "" || nodeID == s.nodeID {
+		return fmt.Errorf("invalid node %q", nodeID)
+	}
+	var via *peer.Peer
+	s.peersMu.RLock()
+	for _, p := range s.sortedPeersLocked() {
+		if p.NodeID() == nodeID {
+			s.peersMu.RUnlock()
+			return fmt.Errorf("already linked to node %s", nodeID)
+		}
+		if via == nil && linksTo(p, nodeID) {
+			via = p
+		}
+	}
+	s.peersMu.RUnlock()
+	if via == nil {
+		return fmt.Errorf("no peer links to node %s", nodeID)
+	}
+	if !via.SendControl(peer.Control{Type: peer.ControlRelayRequest, Target: nodeID}) {
+		return fmt.Errorf("peer %s is not accepting control frames", via.ID)
+	}
+	logger.Relay.Info("Asking node %s to relay traffic to node %s", via.NodeID(), nodeID)
+	return nil
+}
+
+// linksTo reports whether the peer reported a direct link to nodeID.
+func linksTo(p *peer.Peer, nodeID string) bool {
+	for _, n := range p.Topology() {
+		if n.ID == nodeID && n.Hops == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// relayFallback asks for a relayed path to the node listening on addr after
+// a direct connection to it could not be made.
+func (s *Server) relayFallback(addr string) {
+	for _, n := range s.topologyFor(nil) {
+		if n.ListenAddr != addr || n.Hops < 2 {
+			continue
+		}
+		if err := s.RequestRelay(n.ID); err != nil {
+			logger.Relay.Info("Relay fallback for %s: %v", addr, err)
+		}
+		return
+	}
+}
+
+func (s *Server) peerByNode(nodeID string) *peer.Peer {
+	s.peersMu.RLock()
+	defer s.peersMu.RUnlock()
+	for _, p := range s.peers {
+		if p.NodeID() == nodeID {
+			return p
+		}
+	}
+	return nil
+}
+
+func (s *Server) handleRelayRequest(p *peer.Peer, c peer.Control) {
+	from := p.NodeID()
+	refuse := func(reason string) {
+		logger.Relay.Info("Refusing to relay node %s to node %s: %s", from, c.Target, reason)
+		p.SendControl(peer.Control{Type: peer.ControlRelayRefuse, Target: c.Target, Reason: reason})
+	}
+	if !s.flags.relayAssist.Load() {
+		refuse("relay assist is disabled")
+		return
+	}
+	if from == "" || c.Target == "" || c.Target == from || c.Target == s.nodeID {
+		refuse("invalid target")
+		return
+	}
+	to := s.peerByNode(c.Target)
+	if to == nil {
+		refuse("target is not linked")
+		return
+	}
+
+	r := &s.rendezvous
+	key := relayPathKey(from, c.Target)
+	r.mu.Lock()
+	if _, ok := r.paths[key]; !ok {
+		if len(r.paths) >= maxRelayPaths {
+			r.mu.Unlock()
+			refuse("relay limit reached")
+			return
+		}
+		r.paths[key] = &relayPath{a: from, b: c.Target, since: time.Now()}
+	}
+	r.mu.Unlock()
+
+	p.SendControl(peer.Control{Type: peer.ControlRelayAccept, Target: c.Target})
+	to.SendControl(peer.Control{Type: peer.ControlRelayAccept, Target: from})
+	logger.Relay.Info("Relaying traffic between node %s and node %s", from, c.Target)
+}
+
+// handleRelayAccept records that the sending peer forwards our traffic to
+// and from the target node.
+func (s *Server) handleRelayAccept(p *peer.Peer, c peer.Control) {
+	if c.Target == "" || c.Target == s.nodeID {
+		return
+	}
+	s.rendezvous.mu.Lock()
+	s.rendezvous.via[c.Target] = p.NodeID()
+	s.rendezvous.mu.Unlock()
+	logger.Relay.Info("Reaching node %s through node %s", c.Target, p.NodeID())
+	s.markTopologyDirty()
+}
+
+func (s *Server) handleRelayRefuse(p *peer.Peer, c peer.Control) {
+	s.rendezvous.mu.Lock()
+	if s.rendezvous.via[c.Target] == p.NodeID() {
+		delete(s.rendezvous.via, c.Target)
+	}
+	s.rendezvous.mu.Unlock()
+	logger.Relay.Info("Node %s will not relay traffic to node %s: %s", p.NodeID(), c.Target, c.Reason)
+}
+
+// forwardRelayed passes a frame received from p on to the nodes we relay
+// its traffic to, adding our hop to its trace if it has one. Like any
+// relayed frame it is also handled locally.
+func (s *Server) forwardRelayed(p *peer.Peer, data []byte, hops []stats.TraceHop) {
+	r := &s.rendezvous
+	r.mu.RLock()
+	if len(r.paths) == 0 {
+		r.mu.RUnlock()
+		return
+	}
+	from := p.NodeID()
+	var targets []*relayPath
+	for _, path := range r.paths {
+		if path.a == from || path.b == from {
+			targets = append(targets, path)
+		}
+	}
+	r.mu.RUnlock()
+	if hops != nil {
+		hops = s.addHop(hops, time.Now())
+	}
+
+	for _, path := range targets {
+		far := path.b
+		if far == from {
+			far = path.a
+		}
+		to := s.peerByNode(far)
+		if to == nil {
+			continue
+		}
+		if to.SendTraced(data, hops) {
+			path.packets.Add(1)
+		}
+	}
+}
+
+// dropRelays tears down relayed paths through or to a node whose link went
+// away, telling the other end of each path we served.
+func (s *Server) dropRelays(nodeID string) {
+	if nodeID == "" {
+		return
+	}
+	var notify []string
+	r := &s.rendezvous
+	r.mu.Lock()
+	for key, path := range r.paths {
+		switch nodeID {
+		case path.a:
+			notify = append(notify, path.b)
+		case path.b:
+			notify = append(notify, path.a)
+		default:
+			continue
+		}
+		delete(r.paths, key)
+	}
+	for target, via := range r.via {
+		if via == nodeID {
+			delete(r.via, target)
+		}
+	}
+	r.mu.Unlock()
+
+	for _, far := range notify {
+		if p := s.peerByNode(far); p != nil {
+			p.SendControl(peer.Control{Type: peer.ControlRelayRefuse, Target: nodeID, Reason: "link closed"})
+		}
+		logger.Relay.Info("Stopped relaying traffic between node %s and node %s", nodeID, far)
+	}
+}
+
+// relayedVia returns the rendezvous node for each node we reach through one.
+func (s *Server) relayedVia() map[string]string {
+	s.rendezvous.mu.RLock()
+	defer s.rendezvous.mu.RUnlock()
+	out := make(map[string]string, len(s.rendezvous.via))
+	for target, via := range s.rendezvous.via {
+		out[target] = via
+	}
+	return out
+}
+
+func (s *Server) collectRelays() []stats.RelayPath {
+	s.rendezvous.mu.RLock()
+	out := make([]stats.RelayPath, 0, len(s.rendezvous.paths))
+	for _, path := range s.rendezvous.paths {
+		out = append(out, stats.RelayPath{
+			From:    path.a,
+			To:      path.b,
+			Since:   path.since,
+			Packets: path.packets.Load(),
+		})
+	}
+	s.rendezvous.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Since.Before(out[j].Since) })
+	return out
+}
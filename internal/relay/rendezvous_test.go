@@ -0,0 +1,108 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for relay-assisted traversal through a rendezvous node
+
+package relay
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/peer"
+)
+
+// linkServers connects child to parent over loopback and waits until both
+// sides know each other's node ID.
+func linkServers(t *testing.T, ctx context.Context, child, parent *Server) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		parent.handleNewConn(ctx, conn, parent.peerRelayChan, "")
+	}()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	go child.handleNewConn(ctx, conn, child.peerRelayChan, l.Addr().String())
+
+	deadline := time.Now().Add(5 * time.Second)
+	for child.peerByNode(parent.nodeID) == nil || parent.peerByNode(child.nodeID) == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the link to come up")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServerRelayPath(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var nodes []*Server
+	for range 3 {
+		srv, err := NewServer(config.DefaultConfig(), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv.runCtx = ctx
+		nodes = append(nodes, srv)
+	}
+	a, b, c := nodes[0], nodes[1], nodes[2]
+	linkServers(t, ctx, a, b)
+	linkServers(t, ctx, c, b)
+
+	b.handleRelayRequest(b.peerByNode(a.nodeID), peer.Control{Type: peer.ControlRelayRequest, Target: c.nodeID})
+	if len(b.collectRelays()) != 0 {
+		t.Fatal("Expected the request to be refused with relay assist disabled")
+	}
+
+	if err := b.SetFlag("relay_assist", true, "test"); err != nil {
+		t.Fatal(err)
+	}
+	b.handleRelayRequest(b.peerByNode(a.nodeID), peer.Control{Type: peer.ControlRelayRequest, Target: c.nodeID})
+	if paths := b.collectRelays(); len(paths) != 1 {
+		t.Fatalf("Expected one relayed path, got %v", paths)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for a.relayedVia()[c.nodeID] != b.nodeID || c.relayedVia()[a.nodeID] != b.nodeID {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected both ends to learn the rendezvous, got %v and %v", a.relayedVia(), c.relayedVia())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	frame := []byte("relayed frame from a")
+	a.broadcastToPeers(frame)
+	select {
+	case got := <-c.peerRelayChan:
+		if !bytes.Equal(got, frame) {
+			t.Errorf("Expected %q at c, got %q", frame, got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the relayed frame at c")
+	}
+
+	b.dropRelays(a.nodeID)
+	if len(b.collectRelays()) != 0 {
+		t.Error("Expected the path to be dropped with a's link")
+	}
+	deadline = time.Now().Add(5 * time.Second)
+	for c.relayedVia()[a.nodeID] != "" {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected c to be told the path closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,352 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Warm standby: a primary hub mirrors its config to a paired standby
+
+package relay
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+	"github.com/mlapointe/ipxtransporter/internal/peer"
+	"github.com/mlapointe/ipxtransporter/internal/rules"
+	"github.com/mlapointe/ipxtransporter/internal/schedule"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+const (
+	// replicaRefresh is how often an unchanged config is sent again, so
+	// the standby sees the primary is still replicating.
+	replicaRefresh = 6 * statusInterval
+	// maxReplicaLen leaves room for the rest of the control frame.
+	maxReplicaLen = 60 * 1024
+)
+
+// configReplica is what a primary mirrors to its standbys: enough for the
+// standby to take over without starting from an empty or stale config.
+type configReplica struct {
+	Peers         []string          `json:"peers"`
+	BannedIDs     []string          `json:"banned_ids"`
+	BannedHosts   []string          `json:"banned_hosts"`
+	PeerNotes     map[string]string `json:"peer_notes"`
+	FilterRules   []rules.Rule      `json:"filter_rules"`
+	PriorityRules []rules.Rule      `json:"priority_rules"`
+	Schedules     []schedule.Entry  `json:"schedules"`
+}
+
+// replicaLink is one end of a pairing as seen from the other.
+type replicaLink struct {
+	nodeID  string
+	peerID  string // current link, if any
+	addr    string
+	version string
+	synced  time.Time
+}
+
+// replicaState is the primary's standbys or the standby's primary. mu also
+// guards cfg.PeerNotes and cfg.ReplicaPeers, which replication replaces.
+type replicaState struct {
+	mu        sync.Mutex
+	standbys  map[string]*replicaLink // primary: by node ID
+	primary   replicaLink             // standby
+	lostSince time.Time               // standby: when the link to the primary dropped
+	active    bool                    // standby: dialing the primary's peers
+	err       string
+}
+
+func newReplicaState() replicaState {
+	return replicaState{standbys: make(map[string]*replicaLink)}
+}
+
+// replicaVersion identifies an encoded replica.
+func replicaVersion(raw []byte) string {
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:6])
+}
+
+func (s *Server) encodeReplica() (json.RawMessage, string, error) {
+	r := configReplica{Peers: s.configuredPeers(), Schedules: s.schedule.List()}
+	r.FilterRules, _ = s.rules.List(rules.KindFilter)
+	r.PriorityRules, _ = s.rules.List(rules.KindPriority)
+	s.peersMu.RLock()
+	r.BannedIDs, r.BannedHosts = slices.Clone(s.cfg.BannedIDs), slices.Clone(s.cfg.BannedHosts)
+	s.peersMu.RUnlock()
+	s.replica.mu.Lock()
+	r.PeerNotes = maps.Clone(s.cfg.PeerNotes)
+	s.replica.mu.Unlock()
+
+	raw, err := json.Marshal(r)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(raw) > maxReplicaLen {
+		return nil, "", fmt.Errorf("config of %d bytes is too large to replicate", len(raw))
+	}
+	return raw, replicaVersion(raw), nil
+}
+
+// runReplication has a primary push its config to its standbys, and a
+// standby take over the primary's peers once the primary is lost.
+func (s *Server) runReplication(ctx context.Context) {
+	ticker := time.NewTicker(statusInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if s.cfg.ReplicateConfig {
+				s.pushReplicas(time.Now())
+			} else {
+				s.checkPrimary(time.Now())
+			}
+		}
+	}
+}
+
+// addStandby pairs the peer with us as a standby, if we replicate and it
+// is trusted, and sends it our config right away.
+func (s *Server) addStandby(p *peer.Peer, nodeID string) {
+	if !s.cfg.ReplicateConfig {
+		return
+	}
+	if !p.Inbound || !s.honors(p, TrustTrusted) {
+		logger.Relay.Warn("Peer %s (node %s) asked for our config but is not a trusted child", p.ID, nodeID)
+		return
+	}
+	s.replica.mu.Lock()
+	l, ok := s.replica.standbys[nodeID]
+	if !ok {
+		l = &replicaLink{nodeID: nodeID}
+		s.replica.standbys[nodeID] = l
+	}
+	l.peerID, l.addr, l.version = p.ID, p.ID, ""
+	s.replica.mu.Unlock()
+	logger.Relay.Info("Standby hub %s paired on %s", nodeID, p.ID)
+	s.pushReplicas(time.Now())
+}
+
+// pushReplicas sends our config to each connected standby that does not
+// have it yet, or has not heard from us for replicaRefresh.
+func (s *Server) pushReplicas(now time.Time) {
+	raw, version, err := s.encodeReplica()
+
+	s.peersMu.RLock()
+	defer s.peersMu.RUnlock()
+	s.replica.mu.Lock()
+	defer s.replica.mu.Unlock()
+	if err != nil {
+		if s.replica.err != err.Error() {
+			logger.Relay.Error("Config replication: %v", err)
+		}
+		s.replica.err = err.Error()
+		return
+	}
+	s.replica.err = ""
+	for _, l := range s.replica.standbys {
+		p, ok := s.peers[l.peerID]
+		if !ok || l.version == version && now.Sub(l.synced) < replicaRefresh {
+			continue
+		}
+		if p.SendControl(peer.Control{Type: peer.ControlReplicate, Replica: raw}) {
+			l.version, l.synced = version, now
+		}
+	}
+}
+
+// handleReplicate applies the config of our primary. Only the link we
+// dialed to standby_of, and only while the primary is trusted, is taken.
+func (s *Server) handleReplicate(p *peer.Peer, c peer.Control) {
+	if s.cfg.StandbyOf == "" || p.Inbound || p.DialAddr != s.cfg.StandbyOf {
+		logger.Relay.Warn("Ignoring config replica from %s: not our primary", p.ID)
+		return
+	}
+	if !s.honors(p, TrustTrusted) {
+		s.replicaFailed(fmt.Sprintf("primary %s is not trusted, its config is ignored", p.ID))
+		return
+	}
+	var r configReplica
+	if err := json.Unmarshal(c.Replica, &r); err != nil {
+		s.replicaFailed(fmt.Sprintf("bad config replica from %s: %v", p.ID, err))
+		return
+	}
+	version := replicaVersion(c.Replica)
+
+	s.replica.mu.Lock()
+	pr := &s.replica.primary
+	pr.nodeID, pr.peerID, pr.synced = p.NodeID(), p.ID, time.Now()
+	s.replica.err = ""
+	if pr.version == version {
+		s.replica.mu.Unlock()
+		return
+	}
+	pr.version = version
+	s.replica.mu.Unlock()
+
+	s.applyReplica(p, r)
+	logger.Relay.Info("Applied config %s of primary %s: %d peers, %d bans, %d rules, %d schedules",
+		version, p.NodeID(), len(r.Peers), len(r.BannedIDs)+len(r.BannedHosts),
+		len(r.FilterRules)+len(r.PriorityRules), len(r.Schedules))
+}
+
+func (s *Server) replicaFailed(msg string) {
+	s.replica.mu.Lock()
+	changed := s.replica.err != msg
+	s.replica.err = msg
+	s.replica.mu.Unlock()
+	if changed {
+		logger.Relay.Error("Config replication: %s", msg)
+	}
+}
+
+// applyReplica replaces our bans, notes, rules and schedules with the
+// primary's and keeps its peers for when we take over.
+func (s *Server) applyReplica(primary *peer.Peer, r configReplica) {
+	hostBans, err := config.CompileHostPatterns(r.BannedHosts)
+	if err != nil {
+		// Keep our host bans rather than lose them all.
+		logger.Relay.Error("Config replication: banned_hosts: %v", err)
+	}
+	s.peersMu.Lock()
+	s.cfg.BannedIDs = r.BannedIDs
+	if err == nil {
+		s.cfg.BannedHosts, s.hostBans = r.BannedHosts, hostBans
+	}
+	for id, p := range s.peers {
+		_, hostBanned := s.hostBans.Match(peerHost(id))
+		if p != primary && (slices.Contains(r.BannedIDs, id) || hostBanned) {
+			if err := p.Conn.Close(); err != nil {
+				logger.Relay.Error("Error closing peer %s connection on replicated ban: %v", id, err)
+			}
+		}
+	}
+	s.peersMu.Unlock()
+
+	peers := []string{}
+	for _, addr := range r.Peers {
+		if addr != s.cfg.StandbyOf {
+			peers = append(peers, addr)
+		}
+	}
+	if r.PeerNotes == nil {
+		r.PeerNotes = map[string]string{}
+	}
+	s.replica.mu.Lock()
+	old := s.cfg.ReplicaPeers
+	s.cfg.PeerNotes, s.cfg.ReplicaPeers = r.PeerNotes, peers
+	active := s.replica.active
+	s.replica.mu.Unlock()
+	s.columns.SetNotes(maps.Clone(r.PeerNotes))
+	if active {
+		s.dialReplicaPeers(old, peers)
+	}
+
+	// Both save the config, with everything above in it.
+	s.rules.Replace(r.FilterRules, r.PriorityRules)
+	s.schedule.Replace(r.Schedules)
+}
+
+// checkPrimary takes over the primary's peers once its link has been down
+// for hub_failover_delay, and hands them back when it returns.
+func (s *Server) checkPrimary(now time.Time) {
+	connected := false
+	s.peersMu.RLock()
+	for _, p := range s.peers {
+		if !p.Inbound && p.DialAddr == s.cfg.StandbyOf {
+			connected = true
+			break
+		}
+	}
+	s.peersMu.RUnlock()
+
+	r := &s.replica
+	r.mu.Lock()
+	peers := slices.Clone(s.cfg.ReplicaPeers)
+	switch {
+	case connected:
+		r.lostSince = time.Time{}
+		if !r.active {
+			r.mu.Unlock()
+			return
+		}
+		r.active = false
+		r.mu.Unlock()
+		logger.Relay.Info("Standby: primary %s is back, dropping its %d peers", s.cfg.StandbyOf, len(peers))
+		s.dialReplicaPeers(peers, nil)
+		return
+	case r.lostSince.IsZero():
+		r.lostSince = now
+	}
+	if r.active || len(peers) == 0 || now.Sub(r.lostSince) < time.Duration(s.cfg.HubFailoverDelay)*time.Second {
+		r.mu.Unlock()
+		return
+	}
+	r.active = true
+	r.mu.Unlock()
+	logger.Relay.Warn("Standby: primary %s lost, taking over its %d peers", s.cfg.StandbyOf, len(peers))
+	s.dialReplicaPeers(nil, peers)
+}
+
+// dialReplicaPeers stops dialing the primary's peers in old that are not
+// in next and starts on those new in next. Peers of our own config are
+// left as they are.
+func (s *Server) dialReplicaPeers(old, next []string) {
+	own := s.configuredPeers()
+	for _, addr := range old {
+		if !slices.Contains(next, addr) && !slices.Contains(own, addr) {
+			s.stopDialer(addr)
+		}
+	}
+	for _, addr := range next {
+		if !slices.Contains(old, addr) && !slices.Contains(own, addr) {
+			s.startDialer(s.runCtx, addr)
+		}
+	}
+}
+
+// replicationStats reports the pairing, nil if this node has none. Must be
+// called with peersMu held.
+func (s *Server) replicationStats() *stats.ReplicationStats {
+	if !s.cfg.ReplicateConfig && s.cfg.StandbyOf == "" {
+		return nil
+	}
+	r := &s.replica
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	partner := func(l replicaLink) stats.ReplicaPartner {
+		_, connected := s.peers[l.peerID]
+		return stats.ReplicaPartner{NodeID: l.nodeID, Addr: l.addr, Connected: connected && l.peerID != "", LastSync: l.synced, Version: l.version}
+	}
+	out := &stats.ReplicationStats{Role: "primary", Error: r.err}
+	if s.cfg.StandbyOf != "" {
+		out.Role, out.Active = "standby", r.active
+		pr := partner(r.primary)
+		pr.Addr = s.cfg.StandbyOf
+		for _, p := range s.peers {
+			if !p.Inbound && p.DialAddr == s.cfg.StandbyOf {
+				pr.Connected = true
+				break
+			}
+		}
+		out.Partners = []stats.ReplicaPartner{pr}
+		return out
+	}
+	for _, l := range r.standbys {
+		out.Partners = append(out.Partners, partner(*l))
+	}
+	slices.SortFunc(out.Partners, func(a, b stats.ReplicaPartner) int {
+		return strings.Compare(a.NodeID, b.NodeID)
+	})
+	return out
+}
@@ -0,0 +1,112 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for warm standby config replication
+
+package relay
+
+import (
+	"context"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/peer"
+	"github.com/mlapointe/ipxtransporter/internal/rules"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+func TestServerHandleReplicate(t *testing.T) {
+	pcfg := config.DefaultConfig()
+	pcfg.ReplicateConfig = true
+	pcfg.Peers = []config.PeerConfig{{Addr: "10.0.0.9:8787"}}
+	pcfg.BannedHosts = []string{"10.0.0.3"}
+	pcfg.PeerNotes = map[string]string{"10.0.0.4": "Dave's box"}
+	pcfg.FilterRules = []rules.Rule{{Name: "no sap", Socket: 0x452, Direction: "dst", Action: "drop"}}
+	primarySrv, err := NewServer(pcfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, version, err := primarySrv.encodeReplica()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.DefaultConfig()
+	cfg.StandbyOf = "10.0.0.1:8787"
+	cfg.PeerTrust = map[string]string{"10.0.0.1": TrustTrusted, "10.0.0.2": TrustTrusted}
+	srv, err := NewServer(cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newPeer := func(addr string) *peer.Peer {
+		host, _, _ := net.SplitHostPort(addr)
+		p := peer.NewPeer(addr, &fakeConn{remoteAddr: &net.TCPAddr{IP: net.ParseIP(host), Port: 8787}}, "")
+		p.DialAddr = addr
+		srv.peers[addr] = p
+		return p
+	}
+	primary := newPeer("10.0.0.1:8787")
+	other := newPeer("10.0.0.2:8787")
+
+	c := peer.Control{Type: peer.ControlReplicate, Replica: raw}
+	srv.handleReplicate(other, c)
+	if len(cfg.BannedHosts) != 0 {
+		t.Fatal("Expected a replica from a peer other than standby_of to be ignored")
+	}
+
+	srv.handleReplicate(primary, c)
+	if !slices.Equal(cfg.BannedHosts, []string{"10.0.0.3"}) {
+		t.Errorf("Expected the primary's bans, got %v", cfg.BannedHosts)
+	}
+	if !slices.Equal(cfg.ReplicaPeers, []string{"10.0.0.9:8787"}) || len(cfg.Peers) != 0 {
+		t.Errorf("Expected the primary's peers kept for a takeover only, got %v and %v", cfg.ReplicaPeers, cfg.Peers)
+	}
+	if len(cfg.FilterRules) != 1 || cfg.FilterRules[0].Name != "no sap" {
+		t.Errorf("Expected the primary's filter rule, got %+v", cfg.FilterRules)
+	}
+	if note := srv.Columns().Note(stats.PeerStat{IP: net.ParseIP("10.0.0.4")}); note != "Dave's box" {
+		t.Errorf("Expected the primary's note to show, got %q", note)
+	}
+
+	r := srv.replicationStats()
+	if r.Role != "standby" || len(r.Partners) != 1 || !r.Partners[0].Connected || r.Partners[0].Version != version {
+		t.Errorf("Expected a connected primary synced to %s, got %+v", version, r)
+	}
+}
+
+func TestStandbyTakeover(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.StandbyOf = "10.0.0.1:8787"
+	cfg.ReplicaPeers = []string{"10.0.0.9:8787"}
+	srv, err := NewServer(cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // dialers must not actually connect
+	srv.runCtx = ctx
+
+	now := time.Now()
+	srv.checkPrimary(now)
+	srv.checkPrimary(now.Add(time.Duration(cfg.HubFailoverDelay-1) * time.Second))
+	if addrs := srv.dialAddrs(); len(addrs) != 0 {
+		t.Fatalf("Expected no takeover before the delay, dialing %v", addrs)
+	}
+	srv.checkPrimary(now.Add(time.Duration(cfg.HubFailoverDelay+1) * time.Second))
+	if addrs := srv.dialAddrs(); !slices.Equal(addrs, []string{"10.0.0.9:8787"}) {
+		t.Fatalf("Expected the primary's peers dialed after the delay, dialing %v", addrs)
+	}
+	if r := srv.replicationStats(); !r.Active {
+		t.Error("Expected the standby reported active")
+	}
+
+	p := peer.NewPeer("10.0.0.1:8787", &fakeConn{remoteAddr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 8787}}, "")
+	p.DialAddr = cfg.StandbyOf
+	srv.peers[p.ID] = p
+	srv.checkPrimary(now.Add(time.Duration(cfg.HubFailoverDelay+2) * time.Second))
+	if addrs := srv.dialAddrs(); len(addrs) != 0 {
+		t.Errorf("Expected the primary's peers handed back, dialing %v", addrs)
+	}
+}
@@ -0,0 +1,323 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Rooms: join a named, isolated virtual LAN by invite token or tracker
+
+package relay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+	"github.com/mlapointe/ipxtransporter/internal/rooms"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+const roomAnnounceInterval = time.Minute
+
+// linkKey is the network key peer links authenticate with: the room's key
+// while in a room, the configured network key otherwise.
+func (s *Server) linkKey() string {
+	s.roomMu.RLock()
+	defer s.roomMu.RUnlock()
+	if s.cfg.Room != nil {
+		return s.cfg.Room.NetworkKey()
+	}
+	return s.cfg.NetworkKey
+}
+
+// configuredPeers returns the addresses we keep links to: the room's
+// members while in a room, the configured peers otherwise.
+func (s *Server) configuredPeers() []string {
+	s.roomMu.RLock()
+	defer s.roomMu.RUnlock()
+	if s.cfg.Room != nil {
+		return append([]string(nil), s.cfg.Room.Peers...)
+	}
+	return config.PeerAddrs(s.cfg.Peers)
+}
+
+// setConfiguredPeers replaces the addresses we keep links to. Configured
+// peers that stay keep their settings.
+func (s *Server) setConfiguredPeers(peers []string) {
+	s.roomMu.Lock()
+	if s.cfg.Room != nil {
+		s.cfg.Room.Peers = peers
+	} else {
+		list := make([]config.PeerConfig, len(peers))
+		for i, addr := range peers {
+			list[i] = config.PeerConfig{Addr: addr}
+			if pc, ok := config.FindPeer(s.cfg.Peers, addr); ok {
+				list[i] = pc
+			}
+		}
+		s.cfg.Peers = list
+	}
+	s.roomMu.Unlock()
+}
+
+// addConfiguredPeers adds peers to the addresses we keep links to; in a
+// room their settings are dropped, as room members take none.
+func (s *Server) addConfiguredPeers(peers ...config.PeerConfig) {
+	s.roomMu.Lock()
+	if s.cfg.Room != nil {
+		s.cfg.Room.Peers = append(s.cfg.Room.Peers, config.PeerAddrs(peers)...)
+	} else {
+		s.cfg.Peers = append(s.cfg.Peers, peers...)
+	}
+	s.roomMu.Unlock()
+}
+
+// peerSettings returns the configured settings of the peer at addr, or
+// none if it is not a configured peer. They hold in a room as well.
+func (s *Server) peerSettings(addr string) config.PeerConfig {
+	s.roomMu.RLock()
+	defer s.roomMu.RUnlock()
+	if pc, ok := config.FindPeer(s.cfg.Peers, addr); ok {
+		return pc
+	}
+	return config.PeerConfig{Addr: addr}
+}
+
+// CreateRoom creates a room, moves this node into it and returns an invite
+// token for it.
+func (s *Server) CreateRoom(name string, public bool) (string, error) {
+	r, err := rooms.New(name, public)
+	if err != nil {
+		return "", err
+	}
+	s.switchRoom(&r)
+	return s.RoomInvite(), nil
+}
+
+// JoinRoom moves this node into the room described by an invite token.
+func (s *Server) JoinRoom(token string) error {
+	r, err := rooms.ParseToken(token)
+	if err != nil {
+		return err
+	}
+	var peers []string
+	for _, addr := range r.Peers {
+		if !s.isOwnAddr(addr) {
+			peers = append(peers, addr)
+		}
+	}
+	r.Peers = peers
+	s.switchRoom(&r)
+	return nil
+}
+
+// LeaveRoom returns this node to its configured peers and network key.
+func (s *Server) LeaveRoom() {
+	s.switchRoom(nil)
+}
+
+// switchRoom replaces the current room. Existing links were keyed for the
+// old segment, so they are dropped and the new peer list is dialed.
+func (s *Server) switchRoom(r *rooms.Room) {
+	old := s.configuredPeers()
+	s.roomMu.Lock()
+	prev := s.cfg.Room
+	s.cfg.Room = r
+	s.roomMu.Unlock()
+	s.persistConfig()
+
+	for _, addr := range old {
+		s.stopDialer(addr)
+	}
+	s.peersMu.RLock()
+	for _, p := range s.peers {
+		if err := p.Conn.Close(); err != nil {
+			logger.Relay.Error("Error closing peer %s connection on room change: %v", p.ID, err)
+		}
+	}
+	s.peersMu.RUnlock()
+	if !s.demoMode {
+		for _, addr := range s.configuredPeers() {
+			s.startDialer(s.runCtx, addr)
+		}
+	}
+
+	switch {
+	case r != nil:
+		logger.Relay.Info("Joined room %q", r.Name)
+	case prev != nil:
+		logger.Relay.Info("Left room %q", prev.Name)
+	}
+}
+
+// RoomInvite returns an invite token for the current room, pointing at the
+// addresses this node and its peers can be reached on.
+func (s *Server) RoomInvite() string {
+	s.roomMu.RLock()
+	r := s.cfg.Room
+	s.roomMu.RUnlock()
+	if r == nil {
+		return ""
+	}
+	return r.Token(s.roomAddrs())
+}
+
+// roomAddrs lists where other nodes can reach the room: our mapped external
+// address, our own listener and the listeners of connected members.
+func (s *Server) roomAddrs() []string {
+	var addrs []string
+	seen := make(map[string]bool)
+	add := func(a string) {
+		if a != "" && !seen[a] {
+			seen[a] = true
+			addrs = append(addrs, a)
+		}
+	}
+
+	if pm := s.portMapping(); pm != nil {
+		add(pm.External)
+	}
+	host, port, err := net.SplitHostPort(s.cfg.ListenAddr)
+	if err == nil {
+		if ip := net.ParseIP(host); host != "" && (ip == nil || !ip.IsUnspecified()) {
+			add(s.cfg.ListenAddr)
+		} else if ifAddrs, err := net.InterfaceAddrs(); err == nil {
+			for _, a := range ifAddrs {
+				if n, ok := a.(*net.IPNet); ok && !n.IP.IsLoopback() && n.IP.To4() != nil {
+					add(net.JoinHostPort(n.IP.String(), port))
+				}
+			}
+		}
+	}
+
+	s.peersMu.RLock()
+	for _, p := range s.sortedPeersLocked() {
+		add(p.RemoteListenAddr())
+	}
+	s.peersMu.RUnlock()
+	return addrs
+}
+
+// runRoomTracker announces the current room to the configured tracker while
+// it is public.
+func (s *Server) runRoomTracker(ctx context.Context) {
+	ticker := time.NewTicker(roomAnnounceInterval)
+	defer ticker.Stop()
+	for {
+		s.announceRoom(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) announceRoom(ctx context.Context) {
+	s.roomMu.RLock()
+	r := s.cfg.Room
+	s.roomMu.RUnlock()
+	if r == nil || !r.Public {
+		return
+	}
+
+	s.peersMu.RLock()
+	members := len(s.topologyLocked(nil))
+	s.peersMu.RUnlock()
+	body, _ := json.Marshal(rooms.Listing{
+		Name:    r.Name,
+		Token:   r.Token(s.roomAddrs()),
+		Members: members,
+		Packets: atomic.LoadUint64(&s.totalReceived) + atomic.LoadUint64(&s.totalForwarded),
+	})
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, trackerURL(s.cfg.RoomTracker), bytes.NewReader(body))
+	if err != nil {
+		logger.Relay.Error("Room tracker: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		logger.Relay.Error("Room tracker: announce failed: %v", err)
+		return
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		logger.Relay.Error("Room tracker: announce failed: %s", resp.Status)
+	}
+}
+
+// TrackerRooms fetches the public rooms listed by the configured tracker.
+func (s *Server) TrackerRooms() ([]rooms.Listing, error) {
+	if s.cfg.RoomTracker == "" {
+		return nil, fmt.Errorf("no room tracker configured")
+	}
+	ctx, cancel := context.WithTimeout(s.runCtx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, trackerURL(s.cfg.RoomTracker), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("room tracker: %s", resp.Status)
+	}
+	var list []rooms.Listing
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("room tracker: %w", err)
+	}
+	return list, nil
+}
+
+// trackerURL accepts either a tracker's base URL or its full rooms URL.
+func trackerURL(base string) string {
+	if strings.HasSuffix(base, "/api/tracker/rooms") {
+		return base
+	}
+	return strings.TrimSuffix(base, "/") + "/api/tracker/rooms"
+}
+
+// collectRoom reports the current room and its members: every node in our
+// overlay tree is in the room.
+func (s *Server) collectRoom(topology []stats.TopologyNode, peers []stats.PeerStat) *stats.RoomStat {
+	s.roomMu.RLock()
+	r := s.cfg.Room
+	s.roomMu.RUnlock()
+	if r == nil {
+		return nil
+	}
+
+	byNode := make(map[string]stats.PeerStat, len(peers))
+	for _, p := range peers {
+		if p.NodeID != "" {
+			byNode[p.NodeID] = p
+		}
+	}
+	st := &stats.RoomStat{Name: r.Name, Public: r.Public, Tracker: s.cfg.RoomTracker}
+	for _, n := range topology {
+		m := stats.RoomMember{
+			ID:       n.ID,
+			Hostname: n.Hostname,
+			Local:    n.ID == s.nodeID,
+			Hops:     n.Hops,
+		}
+		if p, ok := byNode[n.ID]; ok {
+			m.Direct = true
+			m.LastSeen = p.LastSeen
+			m.Packets = p.RecvPkts + p.SentPkts
+		}
+		st.Members = append(st.Members, m)
+	}
+	return st
+}
@@ -0,0 +1,47 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for joining and leaving rooms
+
+package relay
+
+import (
+	"testing"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/rooms"
+)
+
+func TestServerJoinRoom(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.NetworkKey = "site-key"
+	cfg.Peers = []config.PeerConfig{{Addr: "192.0.2.1:8787"}}
+	srv, err := NewServer(cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.SetDemoMode(true) // do not dial
+
+	r, _ := rooms.New("lan party", false)
+	if err := srv.JoinRoom(r.Token([]string{"198.51.100.7:8787", "127.0.0.1:8787"})); err != nil {
+		t.Fatal(err)
+	}
+	if srv.linkKey() != r.NetworkKey() {
+		t.Error("Expected links to use the room's key")
+	}
+	if peers := srv.configuredPeers(); len(peers) != 1 || peers[0] != "198.51.100.7:8787" {
+		t.Errorf("Expected the room's members minus ourselves, got %v", peers)
+	}
+
+	srv.AddPeer(srv.runCtx, "198.51.100.8")
+	if len(cfg.Room.Peers) != 2 || len(cfg.Peers) != 1 {
+		t.Errorf("Expected peers added in a room to join the room's list, got room %v, config %v", cfg.Room.Peers, cfg.Peers)
+	}
+	if st := srv.CollectStats(); st.Room == nil || st.Room.Name != "lan party" {
+		t.Errorf("Expected the room in stats, got %+v", st.Room)
+	}
+
+	srv.LeaveRoom()
+	if srv.linkKey() != "site-key" || len(srv.configuredPeers()) != 1 || srv.RoomInvite() != "" {
+		t.Errorf("Expected the configured key and peers back after leaving, got %q %v", srv.linkKey(), srv.configuredPeers())
+	}
+}
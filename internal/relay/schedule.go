@@ -0,0 +1,123 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Scheduler: expire timed bans and enforce peer access windows
+
+package relay
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+	"github.com/mlapointe/ipxtransporter/internal/peer"
+	"github.com/mlapointe/ipxtransporter/internal/schedule"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+// maxScheduleWait bounds how long the scheduler sleeps, so clock jumps and
+// newly added entries are picked up even without a wakeup.
+const maxScheduleWait = time.Minute
+
+// Schedule returns the scheduled ban and access window list.
+func (s *Server) Schedule() *schedule.Scheduler {
+	return s.schedule
+}
+
+// runSchedule applies the schedule at every transition: expired entries are
+// dropped and peers that may no longer be connected are disconnected.
+func (s *Server) runSchedule(ctx context.Context) {
+	for {
+		now := time.Now()
+		s.applySchedule(now)
+
+		wait := maxScheduleWait
+		if next := s.schedule.NextTransition(now); !next.IsZero() && next.Sub(now) < wait {
+			wait = max(next.Sub(now), time.Second)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.scheduleChanged:
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (s *Server) applySchedule(now time.Time) {
+	for _, e := range s.schedule.Expire(now) {
+		logger.Relay.Info("Schedule: %s for %s expired", e.Action, e.Target)
+	}
+
+	var blocked []*peer.Peer
+	s.peersMu.RLock()
+	for _, p := range s.peers {
+		if ok, _ := s.scheduleBlocks(now, p.ID, peerHost(p.ID), p.DialAddr); ok {
+			blocked = append(blocked, p)
+		}
+	}
+	s.peersMu.RUnlock()
+
+	for _, p := range blocked {
+		logger.Relay.Info("Schedule: disconnecting peer %s outside its allowed time", p.ID)
+		if err := p.Conn.Close(); err != nil {
+			logger.Relay.Error("Error closing peer %s connection on schedule: %v", p.ID, err)
+		}
+	}
+}
+
+// scheduleBlocks checks the schedule for a peer known by its ID, host and,
+// for outbound links, the address we dial.
+func (s *Server) scheduleBlocks(now time.Time, id, host, dialAddr string) (bool, schedule.Entry) {
+	dialHost := ""
+	if dialAddr != "" {
+		dialHost = peerHost(dialAddr)
+	}
+	return s.schedule.Blocked(now, id, host, dialAddr, dialHost)
+}
+
+func (s *Server) scheduleUpdated() {
+	select {
+	case s.scheduleChanged <- struct{}{}:
+	default:
+	}
+}
+
+// BanPeerFor disconnects a peer and bans its ID and host until d has passed.
+func (s *Server) BanPeerFor(id, ip string, d time.Duration) {
+	s.DisconnectPeer(id)
+	until := time.Now().Add(d)
+	for _, target := range []string{id, ip} {
+		if target == "" {
+			continue
+		}
+		if _, err := s.schedule.Add(schedule.Entry{Action: schedule.ActionBan, Target: target, Until: until}); err != nil {
+			logger.Relay.Error("Failed to schedule ban for %s: %v", target, err)
+		}
+	}
+	logger.Relay.Info("Banned peer %s (%s) until %s", id, ip, until.Format("2006-01-02 15:04"))
+}
+
+func (s *Server) collectSchedules(now time.Time) []stats.ScheduleStat {
+	entries := s.schedule.List()
+	out := make([]stats.ScheduleStat, 0, len(entries))
+	for _, e := range entries {
+		out = append(out, stats.ScheduleStat{
+			ID:         e.ID,
+			Action:     e.Action,
+			Target:     e.Target,
+			When:       e.Describe(),
+			Active:     e.Active(now),
+			NextChange: e.Next(now),
+		})
+	}
+	return out
+}
+
+func peerHost(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
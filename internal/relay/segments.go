@@ -0,0 +1,161 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Segment reports: leaves push a summary of their local segment upstream
+
+package relay
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/peer"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+// Privacy levels of the report a node pushes upstream, see
+// config.SegmentReport.
+const (
+	SegmentReportOff     = "off"     // share nothing of our own segment
+	SegmentReportSummary = "summary" // station count and whether capture works
+	SegmentReportFull    = "full"    // also interface, capture error and traffic
+)
+
+const (
+	// segmentReportTTL drops the reports of a subtree that stopped sending.
+	segmentReportTTL = 3 * statusInterval
+	// maxSegmentReports bounds what one child can make us store and forward.
+	maxSegmentReports = 256
+)
+
+// segmentState holds the reports received from each child peer, covering
+// the child and the nodes below it.
+type segmentState struct {
+	mu      sync.Mutex
+	byPeer  map[string][]stats.SegmentReport // by peer ID
+	updated map[string]time.Time
+}
+
+func newSegmentState() segmentState {
+	return segmentState{
+		byPeer:  make(map[string][]stats.SegmentReport),
+		updated: make(map[string]time.Time),
+	}
+}
+
+func validSegmentReport(mode string) error {
+	switch mode {
+	case SegmentReportOff, SegmentReportSummary, SegmentReportFull:
+		return nil
+	}
+	return fmt.Errorf("segment_report: unknown level %q, want off, summary or full", mode)
+}
+
+// runSegmentReports sends our parents our own report, as far as
+// segment_report allows, and those of our subtree every statusInterval.
+// Reports of the subtree are passed on whatever our own level is.
+func (s *Server) runSegmentReports(ctx context.Context) {
+	ticker := time.NewTicker(statusInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.sendSegmentReports(time.Now())
+		}
+	}
+}
+
+func (s *Server) sendSegmentReports(now time.Time) {
+	var reports []stats.SegmentReport
+	if own, ok := s.ownSegmentReport(now); ok {
+		reports = append(reports, own)
+	}
+	s.segments.mu.Lock()
+	for id, at := range s.segments.updated {
+		if now.Sub(at) > segmentReportTTL {
+			delete(s.segments.byPeer, id)
+			delete(s.segments.updated, id)
+			continue
+		}
+		reports = append(reports, s.segments.byPeer[id]...)
+	}
+	s.segments.mu.Unlock()
+	if len(reports) == 0 {
+		return
+	}
+	reports = reports[:min(len(reports), maxSegmentReports)]
+
+	s.peersMu.RLock()
+	defer s.peersMu.RUnlock()
+	for _, p := range s.peers {
+		if !p.Inbound {
+			p.SendControl(peer.Control{Type: peer.ControlSegments, Segments: reports})
+		}
+	}
+}
+
+// ownSegmentReport summarizes our local segment at the configured level.
+func (s *Server) ownSegmentReport(now time.Time) (stats.SegmentReport, bool) {
+	mode := s.cfg.SegmentReport
+	if mode == "" || mode == SegmentReportOff || s.demoMode {
+		return stats.SegmentReport{}, false
+	}
+	captureErr, _ := s.captureError.Load().(string)
+	r := stats.SegmentReport{NodeID: s.nodeID, CaptureOK: captureErr == "", Updated: now}
+	r.Stations, _ = s.macTable.Counts()
+	if mode == SegmentReportFull {
+		r.CaptureError = captureErr
+		r.Interface = s.cfg.Interface
+		r.Received = atomic.LoadUint64(&s.totalReceived)
+		r.Forwarded = atomic.LoadUint64(&s.totalForwarded)
+		r.Dropped = atomic.LoadUint64(&s.totalDropped)
+	}
+	return r, true
+}
+
+// handleSegments stores the reports a child pushed up. A child speaks for
+// the nodes below it only if it is trusted with topology.
+func (s *Server) handleSegments(p *peer.Peer, c peer.Control) {
+	if !p.Inbound {
+		return
+	}
+	now := time.Now()
+	id, known := p.NodeID(), s.honors(p, TrustKnown)
+	var kept []stats.SegmentReport
+	for _, r := range c.Segments {
+		if r.NodeID == "" || r.NodeID == s.nodeID || !known && r.NodeID != id {
+			continue
+		}
+		r.Updated = now
+		kept = append(kept, r)
+		if len(kept) == maxSegmentReports {
+			break
+		}
+	}
+	s.segments.mu.Lock()
+	s.segments.byPeer[p.ID] = kept
+	s.segments.updated[p.ID] = now
+	s.segments.mu.Unlock()
+}
+
+// segmentReports returns the reports from connected children by node ID.
+// Must be called with peersMu held.
+func (s *Server) segmentReports() map[string]*stats.SegmentReport {
+	out := make(map[string]*stats.SegmentReport)
+	now := time.Now()
+	s.segments.mu.Lock()
+	defer s.segments.mu.Unlock()
+	for id, reports := range s.segments.byPeer {
+		if _, ok := s.peers[id]; !ok || now.Sub(s.segments.updated[id]) > segmentReportTTL {
+			continue
+		}
+		for _, r := range reports {
+			out[r.NodeID] = &r
+		}
+	}
+	return out
+}
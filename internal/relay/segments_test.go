@@ -0,0 +1,93 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for segment reports pushed up the tree
+
+package relay
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/peer"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+func TestOwnSegmentReport(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Interface = "eth0"
+	srv, err := NewServer(cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.captureError.Store("permission denied")
+	srv.totalReceived = 42
+	now := time.Now()
+
+	if _, ok := srv.ownSegmentReport(now); ok {
+		t.Error("Expected no report by default")
+	}
+
+	cfg.SegmentReport = SegmentReportSummary
+	r, ok := srv.ownSegmentReport(now)
+	if !ok || r.NodeID != srv.nodeID || r.CaptureOK {
+		t.Fatalf("Expected a summary with failing capture, got %+v", r)
+	}
+	if r.Interface != "" || r.CaptureError != "" || r.Received != 0 {
+		t.Errorf("Expected a summary to leave out details, got %+v", r)
+	}
+
+	cfg.SegmentReport = SegmentReportFull
+	r, _ = srv.ownSegmentReport(now)
+	if r.Interface != "eth0" || r.CaptureError != "permission denied" || r.Received != 42 {
+		t.Errorf("Expected a full report, got %+v", r)
+	}
+}
+
+func TestServerHandleSegments(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.TrustDefaults["inbound"] = TrustUnknown
+	cfg.PeerTrust = map[string]string{"10.0.0.1": TrustKnown}
+	srv, err := NewServer(cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newPeer := func(id, ip string, inbound bool) *peer.Peer {
+		p := peer.NewPeer(id, &fakeConn{remoteAddr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 8787}}, "")
+		p.Inbound = inbound
+		srv.peers[id] = p
+		return p
+	}
+	known := newPeer("known", "10.0.0.1", true)
+	stranger := newPeer("stranger", "10.0.0.2", true)
+	parent := newPeer("parent", "10.0.0.3", false)
+
+	reports := []stats.SegmentReport{{NodeID: "leaf1", Stations: 3, CaptureOK: true}, {NodeID: "leaf2", Stations: 1}}
+	srv.handleSegments(known, peer.Control{Type: peer.ControlSegments, Segments: reports})
+	srv.handleSegments(stranger, peer.Control{Type: peer.ControlSegments, Segments: []stats.SegmentReport{{NodeID: "leaf3"}}})
+	srv.handleSegments(parent, peer.Control{Type: peer.ControlSegments, Segments: []stats.SegmentReport{{NodeID: "leaf4"}}})
+
+	got := srv.segmentReports()
+	if len(got) != 2 || got["leaf1"] == nil || got["leaf1"].Stations != 3 || got["leaf2"] == nil {
+		t.Fatalf("Expected the known child's subtree only, got %v", got)
+	}
+	if got["leaf1"].Updated.IsZero() {
+		t.Error("Expected reports stamped on receipt")
+	}
+
+	// Reports of a child that went away are not shown.
+	delete(srv.peers, "known")
+	if got := srv.segmentReports(); len(got) != 0 {
+		t.Errorf("Expected no reports after the child left, got %v", got)
+	}
+}
+
+func TestSegmentReportConfig(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.SegmentReport = "everything"
+	if _, err := NewServer(cfg, ""); err == nil {
+		t.Error("Expected an unknown segment_report level to be rejected")
+	}
+}
@@ -6,20 +6,31 @@ package relay
 
 import (
 	"context"
+	"crypto"
 	"crypto/tls"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math/rand/v2"
 	"net"
+	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/mlapointe/ipxtransporter/internal/acmecert"
 	"github.com/mlapointe/ipxtransporter/internal/capture"
 	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/ipx"
 	"github.com/mlapointe/ipxtransporter/internal/logger"
+	"github.com/mlapointe/ipxtransporter/internal/mdns"
+	"github.com/mlapointe/ipxtransporter/internal/natmap"
 	"github.com/mlapointe/ipxtransporter/internal/peer"
+	"github.com/mlapointe/ipxtransporter/internal/resolver"
+	"github.com/mlapointe/ipxtransporter/internal/rules"
+	"github.com/mlapointe/ipxtransporter/internal/schedule"
 	"github.com/mlapointe/ipxtransporter/internal/stats"
-	"sort"
 )
 
 type Server struct {
@@ -27,71 +38,351 @@ type Server struct {
 	capturer  *capture.Capturer
 	dedup     *DedupCache
 	peers     map[string]*peer.Peer
+	peerList  atomic.Pointer[[]*peer.Peer] // copy of peers for lock-free fan-out
 	peersMu   sync.RWMutex
 	startTime time.Time
 
-	totalReceived  uint64
-	totalForwarded uint64
-	totalDropped   uint64
-	totalErrors    uint64
-	captureError   atomic.Value // stores string
-	configPath     string
-	demoMode       bool
-	demoPacketRate int
-	demoDropRate   int
-	demoErrorRate  int
-	demoNumPeers   int
-	demoPeersMu    sync.RWMutex
-	peerRelayChan  chan []byte
-	rebalanceTimer *time.Ticker
+	totalReceived   uint64
+	totalForwarded  uint64
+	receivedBytes   uint64 // of the packets in totalReceived
+	forwardedBytes  uint64 // of the packets in totalForwarded
+	totalDropped    uint64
+	totalErrors     uint64
+	totalEchoes     uint64
+	captureError    atomic.Value // stores string
+	listenState     atomic.Pointer[listenState]
+	apiStats        atomic.Value // stores func() stats.APIStats, set by the HTTP API
+	configPath      string
+	restored        atomic.Bool // a config backup was restored, see RestoreConfig
+	demoMode        bool
+	relayOnly       bool // no capture interface, so nothing to inject into
+	demoPacketRate  int
+	demoDropRate    int
+	demoErrorRate   int
+	demoNumPeers    int
+	demoPattern     string
+	demoPeersMu     sync.RWMutex
+	peerRelayChan   chan []byte
+	captureChan     chan []byte
+	broadcastQueue  *stageQueue
+	injectQueue     *stageQueue
+	retryQueue      chan injectRetry
+	injectErrs      injectCounters
+	rules           *rules.Engine
+	samples         *sampleRing
+	macTable        *MACTable
+	banHits         *rules.HitTable
+	hostBans        *config.HostMatcher // compiled cfg.BannedHosts, guarded by peersMu
+	hostAllows      *config.HostMatcher // compiled cfg.AllowedHosts, guarded by peersMu
+	allowPending    map[string]bool     // links let in if their hello names an allowed node, guarded by peersMu
+	helloPending    map[string]bool     // links held until their hello meets the handshake_policy, guarded by peersMu
+	audit           *auditTrail
+	resolver        atomic.Pointer[resolver.Resolver] // resolves peer host names
+	authFails       authFailures
+	dialers         map[string]context.CancelFunc
+	dialersMu       sync.Mutex
+	redirects       uint64
+	conflicts       []stats.NetworkConflict
+	conflictsMu     sync.Mutex
+	nodeID          string
+	hostname        string
+	topologyDirty   chan struct{}
+	frames          stats.FrameTypeCounter // interface traffic by IPX frame type
+	sockets         stats.SocketCounter    // interface traffic by IPX socket
+	hub             hubState
+	gossip          gossipState
+	rendezvous      rendezvousState
+	segments        segmentState
+	meshCaps        captureState
+	trace           traceState
+	flags           flagState
+	replica         replicaState
+	heal            healState
+	handshake       handshakeState
+	declared        declaredState
+	ops             opsState
+	changes         *stats.Changes // what the TUI redraws
+	localNets       []uint32       // local_networks, declared to peers
+	columns         *stats.Columns // peer columns and notes, shared with the TUI and API
+	history         historyState
+	community       communityState
+	hosts           *hostInventory
+	signer          crypto.Signer // TLS key signing our control metadata, nil if none
+	writers         *peer.WriterPool
+	keyFingerprint  string
+	schedule        *schedule.Scheduler
+	scheduleChanged chan struct{}
+	ipxnet          *ipxnetHost         // nil unless hosting virtual clients
+	coordinator     *AddressCoordinator // nil unless assigning addresses
+	lan             *mdns.Responder     // nil unless mDNS is enabled
+	lanMu           sync.Mutex          // guards lan and portMap
+	bridge          *socketBridge       // nil unless the socket bridge is enabled
+	legacy          *legacyBridge       // nil unless legacy_ipxnet is set
+	flowExport      *flowState          // nil unless flow_export has a collector
+	beacon          *beaconState        // nil unless the presence beacon is on
+	portMap         *natmap.Mapper      // nil unless port mapping is enabled
+	acme            *acmecert.Manager   // nil unless acme has domains and TLS is on
+	roomMu          sync.RWMutex        // guards cfg.Room and cfg.Peers
+	runCtx          context.Context
+	rebalanceTimer  *time.Ticker
 }
 
 func NewServer(cfg *config.Config, configPath string) (*Server, error) {
+	if err := cfg.Validate().Err(); err != nil {
+		return nil, err
+	}
 	dedup, err := NewDedupCache(cfg.DedupCacheSize, cfg.DedupCacheTTL)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Server{
-		cfg:            cfg,
-		configPath:     configPath,
-		capturer:       capture.NewCapturer(cfg.Interface),
-		dedup:          dedup,
-		peers:          make(map[string]*peer.Peer),
-		startTime:      time.Now(),
-		demoPacketRate: 15,
-		demoDropRate:   3,
-		demoErrorRate:  10,
-		demoNumPeers:   5,
-		peerRelayChan:  make(chan []byte, 1000),
-		rebalanceTimer: time.NewTicker(time.Duration(cfg.RebalanceInterval) * time.Second),
-	}, nil
+	s := &Server{
+		cfg:             cfg,
+		configPath:      configPath,
+		capturer:        newCapturer(cfg),
+		relayOnly:       cfg.Interface == "",
+		dedup:           dedup,
+		peers:           make(map[string]*peer.Peer),
+		allowPending:    make(map[string]bool),
+		helloPending:    make(map[string]bool),
+		audit:           newAuditTrail(cfg.AuditLog),
+		startTime:       time.Now(),
+		demoPacketRate:  15,
+		demoDropRate:    3,
+		demoErrorRate:   10,
+		demoNumPeers:    5,
+		demoPattern:     "flat",
+		peerRelayChan:   make(chan []byte, 1000),
+		captureChan:     make(chan []byte, 1000),
+		broadcastQueue:  newStageQueue(1000, cfg.BroadcastWorkers),
+		injectQueue:     newStageQueue(1000, cfg.InjectWorkers),
+		retryQueue:      make(chan injectRetry, injectRetryQueueSize),
+		rules:           rules.NewEngine(cfg.FilterRules, cfg.PriorityRules),
+		samples:         newSampleRing(512),
+		macTable:        NewMACTable(cfg.MACTableTTL),
+		banHits:         rules.NewHitTable(),
+		dialers:         make(map[string]context.CancelFunc),
+		nodeID:          newNodeID(),
+		hostname:        localHostname(),
+		topologyDirty:   make(chan struct{}, 1),
+		changes:         stats.NewChanges(),
+		hub:             newHubState(),
+		gossip:          newGossipState(),
+		rendezvous:      newRendezvousState(),
+		segments:        newSegmentState(),
+		meshCaps:        newCaptureState(),
+		replica:         newReplicaState(),
+		heal:            newHealState(),
+		handshake:       newHandshakeState(),
+		declared:        newDeclaredState(),
+		history:         newHistoryState(cfg),
+		hosts:           newHostInventory(),
+		schedule:        schedule.NewScheduler(cfg.Schedules),
+		scheduleChanged: make(chan struct{}, 1),
+		runCtx:          context.Background(),
+		rebalanceTimer:  time.NewTicker(time.Duration(cfg.RebalanceInterval) * time.Second),
+	}
+	s.rules.SetOnChange(func(filters, priorities []rules.Rule) {
+		s.cfg.FilterRules = filters
+		s.cfg.PriorityRules = priorities
+		s.persistConfig()
+	})
+	s.schedule.SetOnChange(func(entries []schedule.Entry) {
+		s.cfg.Schedules = entries
+		s.persistConfig()
+		s.scheduleUpdated()
+	})
+	if cfg.TLSCertPath != "" && cfg.TLSKeyPath != "" {
+		signer, fp, err := loadSigner(cfg.TLSCertPath, cfg.TLSKeyPath)
+		if err != nil {
+			logger.Relay.Warn("Control frames will not be signed: %v", err)
+		} else {
+			s.signer, s.keyFingerprint = signer, fp
+			logger.Relay.Info("Signing control frames with key %s", fp)
+		}
+	}
+	for group, mode := range cfg.PadPolicy {
+		if !peer.ValidPadding(mode) {
+			return nil, fmt.Errorf("pad_policy: unknown padding %q for %s peers", mode, group)
+		}
+	}
+	if err := validHandshakePolicy(cfg.HandshakePolicy); err != nil {
+		return nil, err
+	}
+	columns, err := stats.NewColumns(cfg.PeerColumns, cfg.PeerNotes)
+	if err != nil {
+		return nil, err
+	}
+	s.columns = columns
+	if cfg.WriterWorkers > 0 {
+		s.writers = peer.NewWriterPool(cfg.WriterWorkers)
+	} else {
+		s.writers = peer.SharedWriterPool()
+	}
+	if cfg.SocketBridgeAddr != "" {
+		s.bridge = newSocketBridge()
+	}
+	if cfg.LegacyIPXNet != "" {
+		if _, _, err := net.SplitHostPort(cfg.LegacyIPXNet); err != nil {
+			return nil, fmt.Errorf("legacy_ipxnet: %v", err)
+		}
+		s.legacy = newLegacyBridge(cfg.LegacyIPXNet)
+	}
+	if cfg.FlowExport.Collector != "" {
+		s.flowExport = newFlowState()
+	}
+	if len(cfg.ACME.Domains) > 0 && !cfg.DisableSSL {
+		s.acme = newACME(cfg.ACME)
+	}
+	if s.hostBans, err = config.CompileHostPatterns(cfg.BannedHosts); err != nil {
+		return nil, fmt.Errorf("banned_hosts: %v", err)
+	}
+	res, err := resolver.New(cfg.Resolver)
+	if err != nil {
+		return nil, err
+	}
+	s.resolver.Store(res)
+	if s.hostAllows, err = config.CompileHostPatterns(cfg.AllowedHosts); err != nil {
+		return nil, fmt.Errorf("allowed_hosts: %v", err)
+	}
+	if err := validSegmentReport(cfg.SegmentReport); err != nil {
+		return nil, err
+	}
+	if err := s.SetTraceFlows(cfg.Trace); err != nil {
+		return nil, err
+	}
+	if err := s.applySocketNames(); err != nil {
+		return nil, err
+	}
+	s.loadFlags()
+	if s.localNets, err = parseLocalNetworks(cfg.LocalNetworks); err != nil {
+		return nil, err
+	}
+	restartAt, err := validSelfHeal(cfg.SelfHeal)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.SelfHeal.RestartAt != "" {
+		s.heal.restartAt = restartAt
+		s.heal.nextRestart = nextRestartAfter(time.Now(), restartAt)
+	}
+	if cfg.Beacon {
+		s.beacon = newBeaconState(time.Duration(cfg.BeaconInterval) * time.Second)
+	}
+	if cfg.IPXNetListenAddr != "" {
+		s.ipxnet = newIPXNetHost()
+		if cfg.AssignAddresses {
+			network, err := parseVirtualNetwork(cfg.VirtualNetwork)
+			if err != nil {
+				return nil, err
+			}
+			s.coordinator = NewAddressCoordinator(s.nodeID, network)
+			s.coordinator.SetInUse(s.macTable.Known)
+		}
+	}
+	return s, nil
 }
 
+// Start runs the relay until ctx is done. If the peer listener cannot be
+// opened it returns an error wrapping ErrListenerDown, but the relay runs
+// on, dialing its peers, and retries the listener.
 func (s *Server) Start(ctx context.Context) error {
+	s.runCtx = ctx
+	go s.runHistory(ctx)
+	if s.cfg.ExportPath != "" {
+		go s.runExport(ctx)
+	}
 	if s.demoMode {
 		go s.runDemo(ctx)
 		return nil
 	}
-	packetChan := make(chan []byte, 1000)
-
-	if err := s.capturer.Start(ctx, packetChan); err != nil {
-		logger.Error("Capture error: %v", err)
+	if s.relayOnly {
+		logger.Relay.Info("No capture interface: running without a local segment, nothing is injected")
+		s.captureError.Store("no interface specified")
+	} else if err := s.capturer.Start(ctx, s.captureChan); err != nil {
+		logger.Relay.Error("Capture error: %v", err)
 		s.captureError.Store(err.Error())
 	} else {
 		s.captureError.Store("")
 	}
 
 	// Listen for incoming peer connections
-	go s.listenPeers(ctx, s.peerRelayChan)
+	s.checkFileLimit()
+	if s.acme != nil {
+		go s.runACME(ctx)
+	}
+	listener, listenErr := s.openListener()
+	if listenErr != nil {
+		// The caller reports it.
+		s.listenState.Store(&listenState{err: listenErr.Error(), retry: time.Now().Add(listenRetryMin)})
+		listenErr = fmt.Errorf("%w: %v", ErrListenerDown, listenErr)
+	}
+	go s.listenPeers(ctx, listener, s.peerRelayChan)
 
 	// Outgoing connections to peers
-	for _, peerAddr := range s.cfg.Peers {
-		go s.connectToPeer(ctx, peerAddr, s.peerRelayChan)
+	for _, peerAddr := range s.configuredPeers() {
+		s.startDialer(ctx, peerAddr)
+	}
+	if s.cfg.StandbyOf != "" && !slices.Contains(s.configuredPeers(), s.cfg.StandbyOf) {
+		s.startDialer(ctx, s.cfg.StandbyOf)
+	}
+	if s.cfg.ReplicateConfig || s.cfg.StandbyOf != "" {
+		go s.runReplication(ctx)
+	}
+	if s.cfg.SelfHeal.DialerStuck > 0 || s.cfg.SelfHeal.RestartAt != "" {
+		go s.runSelfHeal(ctx)
+	}
+	go s.runStatus(ctx)
+	go s.runTopology(ctx)
+	go s.runChanges(ctx)
+	go s.runCommunity(ctx)
+	if s.flowExport != nil {
+		go s.runFlowExport(ctx)
+	}
+	go s.runSegmentReports(ctx)
+	go s.runSchedule(ctx)
+	if s.cfg.HubElection {
+		go s.runElection(ctx)
 	}
+	if s.cfg.Gossip {
+		go s.runGossip(ctx)
+	}
+	if s.ipxnet != nil {
+		go s.runIPXNet(ctx)
+	}
+	if s.cfg.MDNS {
+		go s.runMDNS(ctx)
+	}
+	if s.bridge != nil {
+		go s.runSocketBridge(ctx)
+	}
+	if s.legacy != nil {
+		go s.runLegacyIPXNet(ctx)
+	}
+	if s.cfg.PortMapping {
+		go s.runPortMapping(ctx)
+	}
+	if s.cfg.RoomTracker != "" {
+		go s.runRoomTracker(ctx)
+	}
+	if s.beacon != nil {
+		go s.runBeacon(ctx)
+	}
+
+	// Stage workers: broadcast to peers and inject into the local segment
+	// run independently so a slow pcap write cannot stall forwarding.
+	for i := range s.broadcastQueue.workers() {
+		go s.broadcastWorker(ctx, i)
+	}
+	for i := range s.injectQueue.workers() {
+		go s.injectWorker(ctx, i)
+	}
+	go s.retryWorker(ctx)
 
-	// Main relay loop
+	// Main relay loop: dedup and dispatch to the stage queues
 	go func() {
+		pruneTicker := time.NewTicker(time.Minute)
+		defer pruneTicker.Stop()
 		for {
 			select {
 			case <-ctx.Done():
@@ -100,174 +391,366 @@ func (s *Server) Start(ctx context.Context) error {
 				if s.cfg.RebalanceEnabled {
 					s.rebalanceNetwork()
 				}
-			case data := <-packetChan:
+			case <-pruneTicker.C:
+				s.macTable.Prune()
+				s.hosts.prune(time.Now())
+				s.pruneVirtual()
+				s.pruneBridge()
+				s.pruneLegacy()
+			case data := <-s.captureChan:
 				atomic.AddUint64(&s.totalReceived, 1)
+				atomic.AddUint64(&s.receivedBytes, uint64(len(data)))
+				ft, _ := ipx.DetectFrameType(data)
+				s.frames.AddRx(ft, len(data))
+				s.sockets.AddRx(data)
+				s.recordFrame(captureSegment, data)
+				if s.handleBeacon(data, viaInterface) {
+					continue
+				}
+				if s.isEcho(data) {
+					atomic.AddUint64(&s.totalEchoes, 1)
+					continue
+				}
+				s.meterFlow(s.cfg.Interface, data)
+				s.seeCaptured(data)
+				s.samples.add(data)
 				if s.dedup.IsDuplicate(data) {
 					atomic.AddUint64(&s.totalDropped, 1)
 					continue
 				}
-				s.broadcastToPeers(data)
-				atomic.AddUint64(&s.totalForwarded, 1)
+				s.deliverVirtual(data, nil)
+				s.deliverBridge(data, nil)
+				s.deliverLegacy(data)
+				s.dispatch(s.broadcastQueue, data)
 
 			case data := <-s.peerRelayChan:
+				s.recordFrame(captureMesh, data)
+				if s.remoteBeacon(data) {
+					continue
+				}
+				s.samples.add(data)
 				if s.dedup.IsDuplicate(data) {
 					continue
 				}
-				if err := s.capturer.Inject(data); err != nil {
-					logger.Error("Failed to inject packet: %v", err)
-					atomic.AddUint64(&s.totalErrors, 1)
+				if h, err := ipx.Parse(data); err == nil {
+					s.macTable.LearnRemote(h)
+				}
+				s.deliverVirtual(data, nil)
+				s.deliverBridge(data, nil)
+				s.deliverLegacy(data)
+				if !s.relayOnly {
+					s.dispatch(s.injectQueue, data)
 				}
 			}
 		}
 	}()
 
-	return nil
+	return listenErr
 }
 
-func (s *Server) listenPeers(ctx context.Context, relayChan chan<- []byte) {
-	var listener net.Listener
-	var err error
-
-	if s.cfg.DisableSSL {
-		listener, err = net.Listen("tcp", s.cfg.ListenAddr)
-	} else {
-		cert, err2 := tls.LoadX509KeyPair(s.cfg.TLSCertPath, s.cfg.TLSKeyPath)
-		if err2 != nil {
-			logger.Error("Failed to load TLS keys: %v", err2)
-			return
-		}
-		tlsCfg := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS13}
-		listener, err = tls.Listen("tcp", s.cfg.ListenAddr, tlsCfg)
+// isEcho learns the source of a captured frame and reports whether it is a
+// station we know to be remote, i.e. our own injected traffic seen again.
+func (s *Server) isEcho(data []byte) bool {
+	if !s.flags.echoSuppression.Load() {
+		return false
 	}
-
+	h, err := ipx.Parse(data)
 	if err != nil {
-		logger.Error("Failed to listen: %v", err)
-		return
+		return false
 	}
-	defer func() {
-		if err := listener.Close(); err != nil && err != net.ErrClosed {
-			logger.Error("Error closing listener: %v", err)
-		}
-	}()
+	return !s.macTable.LearnLocal(h)
+}
 
-	go func() {
-		<-ctx.Done()
-		if err := listener.Close(); err != nil && err != net.ErrClosed {
-			logger.Error("Error closing listener on context done: %v", err)
-		}
-	}()
+// dispatch applies the filter and priority rules and hands the frame to a
+// stage queue, dropping it if filtered out or the stage is backed up.
+func (s *Server) dispatch(stage *stageQueue, data []byte) {
+	allow, priority := s.rules.Classify(data)
+	if !allow || !stage.push(data, priority) {
+		atomic.AddUint64(&s.totalDropped, 1)
+	}
+}
 
+// broadcastWorker sends the frames of one shard of the broadcast queue to
+// the peers.
+func (s *Server) broadcastWorker(ctx context.Context, shard int) {
 	for {
-		conn, err := listener.Accept()
-		if err != nil {
-			select {
-			case <-ctx.Done():
-				return
-			default:
-				logger.Error("Accept error: %v", err)
-				continue
-			}
+		data, ok := s.broadcastQueue.pop(ctx, shard)
+		if !ok {
+			return
 		}
+		s.broadcastToPeers(data)
+		atomic.AddUint64(&s.totalForwarded, 1)
+		atomic.AddUint64(&s.forwardedBytes, uint64(len(data)))
+	}
+}
+
+// Rules returns the live filter and priority rule engine.
+func (s *Server) Rules() *rules.Engine {
+	return s.rules
+}
+
+// DryRunRule counts how many recently relayed frames a rule would match.
+func (s *Server) DryRunRule(kind string, r rules.Rule) (matches, samples int, err error) {
+	if err := rules.Validate(kind, &r); err != nil {
+		return 0, 0, err
+	}
+	frames := s.samples.snapshot()
+	return rules.CountMatches(r, frames), len(frames), nil
+}
+
+// startDialer keeps an outbound link to addr alive until ctx is done or the
+// dialer is stopped by a redirect.
+func (s *Server) startDialer(ctx context.Context, addr string) {
+	dctx, cancel := context.WithCancel(ctx)
+	s.dialersMu.Lock()
+	if old, ok := s.dialers[addr]; ok {
+		old()
+	}
+	s.dialers[addr] = cancel
+	s.dialersMu.Unlock()
+	go s.connectToPeer(dctx, addr, s.peerRelayChan)
+}
 
-		s.handleNewConn(ctx, conn, relayChan)
+func (s *Server) stopDialer(addr string) {
+	s.dialersMu.Lock()
+	if cancel, ok := s.dialers[addr]; ok {
+		cancel()
+		delete(s.dialers, addr)
 	}
+	s.dialersMu.Unlock()
 }
 
 func (s *Server) connectToPeer(ctx context.Context, addr string, relayChan chan<- []byte) {
+	// The first attempt is reported as an operation; retries are not.
+	op := s.beginOp(opDial, addr)
+	dialed := func(err error) {
+		if op != 0 {
+			s.endOp(op, err)
+			op = 0
+		}
+	}
+	defer dialed(errors.New("dialer stopped"))
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		default:
-			var conn net.Conn
-			var err error
-			if s.cfg.DisableSSL {
-				conn, err = net.DialTimeout("tcp", addr, 10*time.Second)
-			} else {
-				tlsCfg := &tls.Config{InsecureSkipVerify: true, MinVersion: tls.VersionTLS13} // Production should verify
-				conn, err = tls.DialWithDialer(&net.Dialer{Timeout: 10 * time.Second}, "tcp", addr, tlsCfg)
+			if blocked, _ := s.scheduleBlocks(time.Now(), "", "", addr); blocked {
+				dialed(errors.New("held back by a schedule"))
+				sleepCtx(ctx, 5*time.Second)
+				continue
 			}
-
+			// Settings are looked up for each attempt, so a redial picks
+			// up changes to them.
+			conn, err := s.dialPeer(ctx, s.peerSettings(addr))
 			if err != nil {
-				logger.Error("Failed to connect to peer %s: %v, retrying...", addr, err)
-				time.Sleep(5 * time.Second)
+				logger.Relay.Warn("Failed to connect to peer %s: %v, retrying...", addr, err)
+				dialed(err)
+				sleepCtx(ctx, 5*time.Second)
 				continue
 			}
 
-			s.handleNewConn(ctx, conn, relayChan)
-			time.Sleep(5 * time.Second) // Wait before reconnecting if it drops
+			dialed(nil)
+			s.handleNewConn(ctx, conn, relayChan, addr)
+			sleepCtx(ctx, 5*time.Second) // Wait before reconnecting if it drops
 		}
 	}
 }
 
-func (s *Server) handleNewConn(ctx context.Context, conn net.Conn, relayChan chan<- []byte) {
+// dialPeer opens a link to the peer pc, resolving its host with the
+// configured resolver, and completes the TLS handshake unless the link is
+// plain TCP.
+func (s *Server) dialPeer(ctx context.Context, pc config.PeerConfig) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	conn, err := s.resolver.Load().DialContext(ctx, &net.Dialer{}, "tcp", pc.Addr)
+	if err != nil || !pc.TLS(s.cfg.DisableSSL) {
+		return conn, err
+	}
+	tc := tls.Client(conn, peerTLSConfig(pc))
+	if err := tc.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tc, nil
+}
+
+// handleNewConn runs a peer link until it drops. dialAddr is the address we
+// dialed for outbound links and empty for connections accepted as children.
+func (s *Server) handleNewConn(ctx context.Context, conn net.Conn, relayChan chan<- []byte, dialAddr string) {
 	peerID := conn.RemoteAddr().String()
 	ip, _, _ := net.SplitHostPort(peerID)
 
+	if s.heal.draining.Load() {
+		s.auditConn(peerID, ip, dialAddr, auditDraining, "")
+		if err := conn.Close(); err != nil {
+			logger.Relay.Error("Error closing peer %s connection while draining: %v", peerID, err)
+		}
+		return
+	}
+
+	// A closed network admits only what its allow list names, before the
+	// bans are even looked at.
+	admit := s.admits(ctx, peerID, ip, dialAddr)
+	if admit == admitNo {
+		logger.Relay.Info("Rejecting peer %s: not on the allow list", peerID)
+		s.auditConn(peerID, ip, dialAddr, auditNotAllowed, "")
+		if err := conn.Close(); err != nil {
+			logger.Relay.Error("Error closing peer %s connection (allow list): %v", peerID, err)
+		}
+		return
+	}
+
 	// Enforce bans
 	s.peersMu.RLock()
 	for _, b := range s.cfg.BannedIDs {
 		if b == peerID {
 			s.peersMu.RUnlock()
-			logger.Info("Rejecting banned peer ID: %s", peerID)
+			s.banHits.Hit("id:" + b)
+			logger.Relay.Info("Rejecting banned peer ID: %s", peerID)
+			s.auditConn(peerID, ip, dialAddr, auditBanned, b)
 			if err := conn.Close(); err != nil {
-				logger.Error("Error closing banned peer ID connection: %v", err)
-			}
-			return
-		}
-	}
-	for _, b := range s.cfg.BannedHosts {
-		if b == ip {
-			s.peersMu.RUnlock()
-			logger.Info("Rejecting banned peer Host/IP: %s", ip)
-			if err := conn.Close(); err != nil {
-				logger.Error("Error closing banned peer Host/IP connection: %v", err)
+				logger.Relay.Error("Error closing banned peer ID connection: %v", err)
 			}
 			return
 		}
 	}
+	hostBans := s.hostBans
 	s.peersMu.RUnlock()
-
-	// Enforce max children for local node
-	s.peersMu.RLock()
-	localChildren := 0
-	for _, p := range s.peers {
-		if p.GetStats().ParentID == "Local" {
-			localChildren++
+	if b, banned := hostBans.Match(connHosts(ctx, ip, dialAddr, hostBans.HasNames())...); banned {
+		s.banHits.Hit("host:" + b)
+		logger.Relay.Info("Rejecting banned peer Host/IP: %s (%s)", ip, b)
+		s.auditConn(peerID, ip, dialAddr, auditBanned, b)
+		if err := conn.Close(); err != nil {
+			logger.Relay.Error("Error closing banned peer Host/IP connection: %v", err)
 		}
+		return
 	}
-	s.peersMu.RUnlock()
 
-	if localChildren >= s.cfg.MaxChildren {
-		logger.Info("Rejecting peer %s: max child connections reached (%d)", peerID, s.cfg.MaxChildren)
+	if blocked, e := s.scheduleBlocks(time.Now(), peerID, ip, dialAddr); blocked {
+		logger.Relay.Info("Rejecting peer %s: %s schedule for %s (%s)", peerID, e.Action, e.Target, e.Describe())
+		s.auditConn(peerID, ip, dialAddr, auditScheduled, e.Target+" "+e.Describe())
 		if err := conn.Close(); err != nil {
-			logger.Error("Error closing peer %s connection (max children): %v", peerID, err)
+			logger.Relay.Error("Error closing scheduled-out peer %s connection: %v", peerID, err)
 		}
 		return
 	}
 
-	p := peer.NewPeer(peerID, conn, s.cfg.NetworkKey)
+	// Enforce max children for local node. When full, hand the newcomer to
+	// a child with spare capacity instead of turning it away.
+	redirectTo := ""
+	if dialAddr == "" && s.localChildren() >= s.cfg.MaxChildren {
+		redirectTo = s.redirectTarget("")
+		if redirectTo == "" {
+			logger.Relay.Info("Rejecting peer %s: max child connections reached (%d)", peerID, s.cfg.MaxChildren)
+			s.auditConn(peerID, ip, dialAddr, auditMaxChildren, "")
+			if err := conn.Close(); err != nil {
+				logger.Relay.Error("Error closing peer %s connection (max children): %v", peerID, err)
+			}
+			return
+		}
+	}
+
+	p := peer.NewPeer(peerID, conn, s.linkKey())
+	p.Inbound = dialAddr == ""
+	p.DialAddr = dialAddr
+	p.SendBudget = s.cfg.PeerQueueBytes
+	p.SetWriterPool(s.writers)
+	if dialAddr != "" {
+		p.Configure(linkSettings(s.peerSettings(dialAddr)))
+	}
+	if !s.cfg.DisableSSL {
+		// Padding hides packet sizes inside TLS; in the clear it would hide
+		// nothing.
+		p.SetPadding(s.cfg.PadPolicy[s.peerGroup(p)])
+	}
+	if p.Inbound {
+		p.SetParentID("Local")
+	}
+	policy := s.hasPolicy(p)
+	if admit == admitOnHello || policy {
+		// Nothing crosses the link until its hello names an allowed node
+		// or meets the handshake_policy.
+		p.SetMute(true, true)
+	}
+	if s.signer != nil {
+		p.SetSigner(s.signer)
+	}
+	p.SetControlHandler(s.handlePeerControl)
+	p.SetFrameHandler(s.peerFrame)
+	p.SetHandshakeHandler(s.handshakeDone)
+	p.SendControl(s.hello(p))
+	p.SendControl(s.localStatus())
+	if redirectTo != "" {
+		s.sendRedirect(p, redirectTo)
+	}
 
 	s.peersMu.Lock()
-	s.peers[peerID] = p
+	s.addPeerLocked(p)
+	if admit == admitOnHello {
+		s.allowPending[p.ID] = true
+	}
+	if policy {
+		s.helloPending[p.ID] = true
+	}
 	s.peersMu.Unlock()
+	if admit == admitOnHello {
+		time.AfterFunc(allowHelloTimeout, func() { s.expireAllowPending(p) })
+	}
+	if policy {
+		time.AfterFunc(policyHelloTimeout, func() { s.expireHelloPending(p) })
+	}
 
 	p.Run(ctx, relayChan, func(id string) {
 		s.peersMu.Lock()
-		delete(s.peers, id)
+		s.removePeerLocked(id)
 		s.peersMu.Unlock()
+		st := p.GetStats()
+		s.recordSession(st, time.Now())
+		s.community.closedBytes.Add(st.SentBytes)
+		s.dropRelays(p.NodeID())
+		s.markTopologyDirty()
 	})
 }
 
-func (s *Server) broadcastToPeers(data []byte) {
-	s.peersMu.RLock()
-	defer s.peersMu.RUnlock()
+// addPeerLocked and removePeerLocked must be called with peersMu held for
+// writing. They keep the snapshot broadcastToPeers reads in step with the
+// map, so that fanning a frame out to a thousand peers takes no lock and
+// does not contend with stats collection.
+func (s *Server) addPeerLocked(p *peer.Peer) {
+	s.peers[p.ID] = p
+	s.publishPeersLocked()
+}
+
+func (s *Server) removePeerLocked(id string) {
+	delete(s.peers, id)
+	delete(s.allowPending, id)
+	delete(s.helloPending, id)
+	s.publishPeersLocked()
+}
+
+func (s *Server) publishPeersLocked() {
+	list := make([]*peer.Peer, 0, len(s.peers))
 	for _, p := range s.peers {
-		select {
-		case p.SendChan <- data:
-		default:
-			// Peer buffer full, drop packet for this peer
+		list = append(list, p)
+	}
+	s.peerList.Store(&list)
+	s.changes.Notify(stats.ChangePeers)
+}
+
+func (s *Server) broadcastToPeers(data []byte) {
+	list := s.peerList.Load()
+	if list == nil {
+		return
+	}
+	if hops := s.traceStart(data); hops != nil {
+		for _, p := range *list {
+			p.SendTraced(data, hops)
 		}
+		return
+	}
+	for _, p := range *list {
+		p.Send(data) // dropped if over the peer's send budget
 	}
 }
 
@@ -275,12 +758,24 @@ func (s *Server) CollectStats() stats.Stats {
 	s.peersMu.RLock()
 	defer s.peersMu.RUnlock()
 
+	localStations, stations := s.hosts.stationCounts(time.Now())
 	peerStats := make([]stats.PeerStat, 0, len(s.peers))
 	for _, p := range s.peers {
-		peerStats = append(peerStats, p.GetStats())
+		ps := p.GetStats()
+		ps.Trust = s.peerTrust(p)
+		ps.Downgrades = s.downgrades(p)
+		ps.Stations = stations[p.ID]
+		peerStats = append(peerStats, ps)
 	}
+	peerStats = append(peerStats, s.collectMachines()...)
+	peerStats = append(peerStats, s.collectLegacyClients()...)
 
 	captureErr, _ := s.captureError.Load().(string)
+	var listenErr string
+	var listenRetry time.Time
+	if ls := s.listenState.Load(); ls != nil {
+		listenErr, listenRetry = ls.err, ls.retry
+	}
 	if s.demoMode && captureErr == "" {
 		captureErr = "[DEMO MODE ACTIVE]"
 	}
@@ -288,13 +783,20 @@ func (s *Server) CollectStats() stats.Stats {
 	st := stats.Stats{
 		TotalReceived:     atomic.LoadUint64(&s.totalReceived),
 		TotalForwarded:    atomic.LoadUint64(&s.totalForwarded),
+		ReceivedBytes:     atomic.LoadUint64(&s.receivedBytes),
+		ForwardedBytes:    atomic.LoadUint64(&s.forwardedBytes),
 		TotalDropped:      atomic.LoadUint64(&s.totalDropped),
 		TotalErrors:       atomic.LoadUint64(&s.totalErrors),
+		EchoSuppressed:    atomic.LoadUint64(&s.totalEchoes),
+		Redirects:         atomic.LoadUint64(&s.redirects),
 		Uptime:            time.Since(s.startTime),
 		UptimeStr:         stats.FormatDuration(time.Since(s.startTime)),
 		Peers:             peerStats,
+		PeerCount:         len(peerStats),
 		Logs:              logger.GetLogs(),
 		CaptureError:      captureErr,
+		ListenError:       listenErr,
+		ListenRetry:       listenRetry,
 		SortField:         s.cfg.SortField,
 		SortReverse:       s.cfg.SortReverse,
 		ListenAddr:        s.cfg.ListenAddr,
@@ -302,7 +804,53 @@ func (s *Server) CollectStats() stats.Stats {
 		NetworkKey:        s.cfg.NetworkKey,
 		RebalanceEnabled:  s.cfg.RebalanceEnabled,
 		RebalanceInterval: s.cfg.RebalanceInterval,
-		DemoProps:         nil,
+		Queues: stats.QueueStats{
+			Capture:   len(s.captureChan),
+			PeerRelay: len(s.peerRelayChan),
+			Broadcast: s.broadcastQueue.len(),
+			Inject:    s.injectQueue.len(),
+			Retry:     len(s.retryQueue),
+			PeerWrite: s.writers.Waiting(),
+		},
+		Inject:    s.injectStats(),
+		DemoProps: nil,
+	}
+
+	st.MACTable.Local, st.MACTable.Remote = s.macTable.Counts()
+	st.LocalStations = localStations
+	st.Dedup.Lookups, st.Dedup.Hits = s.dedup.Stats()
+	if cs, err := s.capturer.Stats(); err == nil {
+		st.Capture = stats.CaptureStats{Received: cs.Received, Dropped: cs.Dropped, IfDropped: cs.IfDropped}
+	}
+	st.RuleHits = s.collectRuleHits()
+	st.NetworkConflicts = s.networkConflicts()
+	st.NodeID = s.nodeID
+	st.KeyFingerprint = s.keyFingerprint
+	st.Interface = s.cfg.Interface
+	st.FrameTypes = s.frames.Snapshot()
+	st.Sockets = s.sockets.Snapshot()
+	st.Topology = s.collectTopology(localStations, stations)
+	st.LANNodes = s.collectLANNodes(st.Topology)
+	st.PortMapping = s.portMapping()
+	st.Certificate = s.certificate()
+	st.Room = s.collectRoom(st.Topology, peerStats)
+	st.Hub = s.HubID()
+	st.DiscoveredPeers = s.discoveredPeers()
+	st.Relays = s.collectRelays()
+	st.Schedules = s.collectSchedules(time.Now())
+	st.VirtualClients = s.collectVirtualClients()
+	st.Hosts = s.collectHosts()
+	st.Beacon = s.collectBeacon()
+	st.FlowExport = s.collectFlowExport()
+	st.Captures = s.MeshCaptures()
+	st.Traces = s.Traces()
+	st.Replication = s.replicationStats()
+	st.SelfHeal = s.selfHealStats()
+	st.NetworkAnomalies = s.networkAnomalies(time.Now())
+	st.Routes = s.collectRoutes()
+	st.Operations = s.operations(time.Now())
+	if fn, ok := s.apiStats.Load().(func() stats.APIStats); ok {
+		st.API = fn()
 	}
 
 	if s.demoMode {
@@ -311,6 +859,8 @@ func (s *Server) CollectStats() stats.Stats {
 			DropRate:   s.demoDropRate,
 			ErrorRate:  s.demoErrorRate,
 			NumPeers:   s.demoNumPeers,
+			Pattern:    s.demoPattern,
+			Patterns:   demoPatternNames(),
 		}
 	}
 
@@ -318,6 +868,35 @@ func (s *Server) CollectStats() stats.Stats {
 	return st
 }
 
+// collectRuleHits reports hit counters for every filter, priority and ban rule.
+// Must be called with peersMu held.
+func (s *Server) collectRuleHits() []stats.RuleHitStat {
+	var out []stats.RuleHitStat
+	add := func(kind, id, name string, snap rules.HitSnapshot) {
+		out = append(out, stats.RuleHitStat{
+			Kind:    kind,
+			ID:      id,
+			Name:    name,
+			Hits:    snap.Total,
+			LastHit: snap.LastHit,
+			Series:  snap.Series,
+		})
+	}
+	for _, kind := range []string{rules.KindFilter, rules.KindPriority} {
+		list, _ := s.rules.List(kind)
+		for _, r := range list {
+			add(kind, r.ID, r.Name, s.rules.Hits(r.ID))
+		}
+	}
+	for _, b := range s.cfg.BannedIDs {
+		add("ban_id", b, b, s.banHits.Snapshot("id:"+b))
+	}
+	for _, b := range s.cfg.BannedHosts {
+		add("ban_host", b, b, s.banHits.Snapshot("host:"+b))
+	}
+	return out
+}
+
 func (s *Server) SetRebalanceInterval(interval time.Duration) {
 	s.rebalanceTimer.Reset(interval)
 }
@@ -333,7 +912,9 @@ func (s *Server) SetSortField(field string) {
 
 func (s *Server) UpdateConfig(adminPass string, maxChildren int, networkKey string, rebalanceEnabled bool, rebalanceInterval int) {
 	if adminPass != "" {
-		s.cfg.AdminPass = adminPass
+		if err := s.cfg.SetAdminPass(adminPass); err != nil {
+			logger.Relay.Error("Failed to hash the admin password: %v", err)
+		}
 	}
 	if maxChildren > 0 {
 		s.cfg.MaxChildren = maxChildren
@@ -349,71 +930,184 @@ func (s *Server) UpdateConfig(adminPass string, maxChildren int, networkKey stri
 	s.persistConfig()
 }
 
+// SetConfigValue sets one setting by its config file key and saves the
+// config. Settings read as they are used take effect at once, log_level
+// too; the rest on the next restart.
+func (s *Server) SetConfigValue(key string, value json.RawMessage) error {
+	var res *resolver.Resolver
+	if key == "resolver" {
+		var rc config.ResolverConfig
+		err := json.Unmarshal(value, &rc)
+		if err == nil {
+			res, err = resolver.New(rc)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	s.peersMu.Lock()
+	err := s.cfg.SetSetting(key, value)
+	if err == nil {
+		// SetSetting checked the patterns compile.
+		switch key {
+		case "banned_hosts":
+			s.hostBans, _ = config.CompileHostPatterns(s.cfg.BannedHosts)
+		case "allowed_hosts":
+			s.hostAllows, _ = config.CompileHostPatterns(s.cfg.AllowedHosts)
+		}
+		s.loadFlags()
+	}
+	s.peersMu.Unlock()
+	if err != nil {
+		return err
+	}
+	if key == "allow_list" || key == "allowed_hosts" || key == "allowed_ids" {
+		s.enforceAllowList()
+	}
+	if res != nil {
+		s.resolver.Store(res)
+	}
+	if key == "socket_names" {
+		s.applySocketNames()
+		s.changes.Notify(stats.ChangeCounters)
+	}
+	if key == "log_level" {
+		if err := logger.SetLevel(s.cfg.LogLevel); err != nil {
+			logger.Relay.Warn("%v", err)
+		}
+	}
+	logger.Relay.Info("Config: %s changed", key)
+	s.persistConfig()
+	return nil
+}
+
+// SetAdminCredentials stores a new admin password, hashed, and a new admin
+// user name unless user is empty, and saves the config. Tokens signed for
+// the old credentials no longer verify.
+func (s *Server) SetAdminCredentials(user, pass string) error {
+	if err := s.cfg.SetAdminPass(pass); err != nil {
+		return err
+	}
+	if user != "" {
+		s.cfg.SetAdminUser(user)
+	}
+	s.persistConfig()
+	return nil
+}
+
+// Columns returns the compiled peer columns with the current peer notes.
+func (s *Server) Columns() *stats.Columns {
+	return s.columns
+}
+
+// SetAPIStats has the stats report the HTTP API's counters from fn.
+func (s *Server) SetAPIStats(fn func() stats.APIStats) {
+	s.apiStats.Store(fn)
+}
+
+// RotateJWTSecret replaces the JWT secret and saves the config, revoking
+// every token issued so far.
+func (s *Server) RotateJWTSecret() error {
+	op := s.beginOp(opRotateSecret, "")
+	err := s.cfg.RotateJWTSecret()
+	if err == nil {
+		s.persistConfig()
+	}
+	s.endOp(op, err)
+	return err
+}
+
 func (s *Server) persistConfig() {
-	if s.configPath != "" {
+	if s.configPath != "" && !s.restored.Load() {
 		if err := config.SaveConfig(s.configPath, s.cfg); err != nil {
-			logger.Error("Failed to save config: %v", err)
+			logger.Relay.Error("Failed to save config: %v", err)
 		}
 	}
 }
 
-func (s *Server) UpdateDemoProps(packetRate, dropRate, errorRate, numPeers int) {
+// UpdateDemoProps changes the generated demo traffic. An unknown pattern
+// leaves the current one selected.
+func (s *Server) UpdateDemoProps(packetRate, dropRate, errorRate, numPeers int, pattern string) {
 	s.demoPacketRate = packetRate
 	s.demoDropRate = dropRate
 	s.demoErrorRate = errorRate
 	s.demoNumPeers = numPeers
+	s.SetDemoPattern(pattern)
 }
 
+// SetDemoPattern selects the named demo traffic pattern, reporting false
+// if there is none by that name.
+func (s *Server) SetDemoPattern(name string) bool {
+	if _, ok := findDemoPattern(name); !ok {
+		return false
+	}
+	s.demoPattern = name
+	return true
+}
+
+// BanPeer bans peer ID id and host ip, either may be empty, and closes
+// the links they match. ip may be a CIDR range or host name pattern as
+// banned_hosts takes; one that does not compile is not added.
 func (s *Server) BanPeer(id string, ip string) {
 	s.peersMu.Lock()
 	if p, ok := s.peers[id]; ok {
 		if err := p.Conn.Close(); err != nil {
-			logger.Error("Error closing peer %s connection on ban: %v", id, err)
+			logger.Relay.Error("Error closing peer %s connection on ban: %v", id, err)
 		}
 	}
-	s.peersMu.Unlock()
-
-	if id != "" {
-		found := false
-		for _, b := range s.cfg.BannedIDs {
-			if b == id {
-				found = true
-				break
-			}
-		}
-		if !found {
-			s.cfg.BannedIDs = append(s.cfg.BannedIDs, id)
-		}
+	if id != "" && !slices.Contains(s.cfg.BannedIDs, id) {
+		s.cfg.BannedIDs = append(s.cfg.BannedIDs, id)
 	}
-	if ip != "" {
-		found := false
-		for _, b := range s.cfg.BannedHosts {
-			if b == ip {
-				found = true
-				break
+	if ip != "" && !slices.Contains(s.cfg.BannedHosts, ip) {
+		hosts := append(slices.Clone(s.cfg.BannedHosts), ip)
+		if m, err := config.CompileHostPatterns(hosts); err != nil {
+			logger.Relay.Error("Not banning host: %v", err)
+		} else {
+			s.cfg.BannedHosts, s.hostBans = hosts, m
+			for pid, p := range s.peers {
+				if _, banned := m.Match(peerHost(pid)); banned {
+					if err := p.Conn.Close(); err != nil {
+						logger.Relay.Error("Error closing peer %s connection on ban: %v", pid, err)
+					}
+				}
 			}
 		}
-		if !found {
-			s.cfg.BannedHosts = append(s.cfg.BannedHosts, ip)
-		}
 	}
+	s.peersMu.Unlock()
 
 	// Persist config immediately
 	s.persistConfig()
 }
 
 func (s *Server) DisconnectPeer(id string) {
+	if s.disconnectMachine(id) {
+		return
+	}
 	s.peersMu.Lock()
 	if p, ok := s.peers[id]; ok {
 		if err := p.Conn.Close(); err != nil {
-			logger.Error("Error closing peer %s connection on disconnect: %v", id, err)
+			logger.Relay.Error("Error closing peer %s connection on disconnect: %v", id, err)
 		}
 	}
 	s.peersMu.Unlock()
 }
 
-func (s *Server) AddPeer(ctx context.Context, addr string) {
-	// If port is missing, add default port
+// MutePeer sets whether traffic from (inbound) and to (outbound) a peer is
+// relayed, without disconnecting it.
+func (s *Server) MutePeer(id string, inbound, outbound bool) {
+	s.peersMu.RLock()
+	p, ok := s.peers[id]
+	s.peersMu.RUnlock()
+	if !ok {
+		return
+	}
+	p.SetMute(inbound, outbound)
+	s.changes.Notify(stats.ChangePeers)
+	logger.Relay.Info("Peer %s mute set: inbound %t, outbound %t", id, inbound, outbound)
+}
+
+// withDefaultPort adds the default peer port to addr if it has none.
+func withDefaultPort(addr string) string {
 	if !strings.Contains(addr, "]") { // Not an IPv6 literal with port or without
 		if !strings.Contains(addr, ":") {
 			addr = net.JoinHostPort(addr, "8787")
@@ -424,33 +1118,60 @@ func (s *Server) AddPeer(ctx context.Context, addr string) {
 			addr = net.JoinHostPort(addr, "8787")
 		}
 	}
+	return addr
+}
+
+func (s *Server) AddPeer(ctx context.Context, addr string) {
+	addr = withDefaultPort(addr)
 
 	// Check if already in peers list
-	s.peersMu.RLock()
-	for _, p := range s.cfg.Peers {
+	peers := s.configuredPeers()
+	for _, p := range peers {
 		if p == addr {
-			s.peersMu.RUnlock()
-			logger.Info("Peer %s already in configuration", addr)
+			logger.Relay.Info("Peer %s already in configuration", addr)
 			return
 		}
 	}
-	s.peersMu.RUnlock()
-
-	s.peersMu.Lock()
-	s.cfg.Peers = append(s.cfg.Peers, addr)
-	s.peersMu.Unlock()
+	s.setConfiguredPeers(append(peers, addr))
 
 	s.persistConfig()
 
 	if !s.demoMode {
-		go s.connectToPeer(ctx, addr, s.peerRelayChan)
+		s.startDialer(s.runCtx, addr)
 	}
-	logger.Info("Manually added peer: %s", addr)
+	logger.Relay.Info("Manually added peer: %s", addr)
+}
+
+// RemovePeer drops addr from the configured peers, stops dialing it and
+// closes the link we dialed to it.
+func (s *Server) RemovePeer(addr string) error {
+	peers := s.configuredPeers()
+	i := slices.Index(peers, addr)
+	if i < 0 {
+		return fmt.Errorf("peer %s is not configured", addr)
+	}
+	s.setConfiguredPeers(slices.Delete(peers, i, i+1))
+	s.persistConfig()
+	s.stopDialer(addr)
+
+	s.peersMu.Lock()
+	for id, p := range s.peers {
+		if !p.Inbound && p.DialAddr == addr {
+			if err := p.Conn.Close(); err != nil {
+				logger.Relay.Error("Error closing peer %s connection on removal: %v", id, err)
+			}
+		}
+	}
+	s.peersMu.Unlock()
+	logger.Relay.Info("Manually removed peer: %s", addr)
+	return nil
 }
 
 func (s *Server) runDemo(ctx context.Context) {
 	ticker := time.NewTicker(1 * time.Second)
 	defer ticker.Stop()
+	rnd := rand.New(rand.NewPCG(uint64(time.Now().UnixNano()), 0))
+	t := 0
 
 	for {
 		select {
@@ -506,7 +1227,7 @@ func (s *Server) runDemo(ctx context.Context) {
 					if _, exists := s.peers[id]; !exists {
 						p := peer.NewPeer(id, &fakeConn{remoteAddr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 8787}}, s.cfg.NetworkKey)
 						p.UpdateDemoStatsWithParent(int64(i), parentID, 0, s.cfg.MaxChildren, float64(10+i%50))
-						s.peers[id] = p
+						s.addPeerLocked(p)
 					}
 				}
 			} else if currentCount > s.demoNumPeers {
@@ -527,7 +1248,7 @@ func (s *Server) runDemo(ctx context.Context) {
 							}
 						}
 						if !hasChildren {
-							delete(s.peers, id)
+							s.removePeerLocked(id)
 							removed++
 						}
 					}
@@ -535,18 +1256,15 @@ func (s *Server) runDemo(ctx context.Context) {
 			}
 			s.peersMu.Unlock()
 
-			atomic.AddUint64(&s.totalReceived, uint64(s.demoPacketRate+int(time.Now().Unix()%int64(s.demoPacketRate/2+1))))
-			atomic.AddUint64(&s.totalForwarded, uint64(s.demoPacketRate-s.demoDropRate+int(time.Now().Unix()%int64(s.demoPacketRate/2+1))))
-			atomic.AddUint64(&s.totalDropped, uint64(time.Now().Unix()%int64(s.demoDropRate+1)))
-			if s.demoErrorRate > 0 && time.Now().Unix()%int64(s.demoErrorRate) == 0 {
-				atomic.AddUint64(&s.totalErrors, 1)
-			}
-
 			s.peersMu.RLock()
+			demoPeers := make([]*peer.Peer, 0, len(s.peers))
 			for _, p := range s.peers {
-				p.UpdateDemoStats()
+				demoPeers = append(demoPeers, p)
 			}
 			s.peersMu.RUnlock()
+			slices.SortFunc(demoPeers, func(a, b *peer.Peer) int { return strings.Compare(a.ID, b.ID) })
+			s.demoTraffic(t, rnd, demoPeers)
+			t++
 		}
 	}
 }
@@ -567,64 +1285,3 @@ type fakeConn struct {
 
 func (f *fakeConn) RemoteAddr() net.Addr { return f.remoteAddr }
 func (f *fakeConn) Close() error         { return nil }
-
-func (s *Server) rebalanceNetwork() {
-	if s.demoMode {
-		// In demo mode, we just let the runDemo hierarchical generation handle it
-		// or we could implement a demo-specific rebalance if needed.
-		return
-	}
-
-	s.peersMu.Lock()
-	defer s.peersMu.Unlock()
-
-	if len(s.peers) <= 1 {
-		return
-	}
-
-	// 1. Get all peers
-	type peerPerf struct {
-		id      string
-		latency float64
-	}
-	var perfs []peerPerf
-	for id, p := range s.peers {
-		perfs = append(perfs, peerPerf{id: id, latency: p.GetStats().LatencyMs})
-	}
-
-	// 2. Sort peers by performance (lowest latency first)
-	sort.Slice(perfs, func(i, j int) bool {
-		return perfs[i].latency < perfs[j].latency
-	})
-
-	// 3. Re-assign parents based on performance
-	// The fastest N peers connect to "Local"
-	// The next N*M peers connect to those N peers, and so on.
-	// For now, let's just make the fastest MaxChildren connect to Local,
-	// and others connect to the fastest ones.
-
-	maxChildren := s.cfg.MaxChildren
-	if maxChildren <= 0 {
-		maxChildren = 5
-	}
-
-	for i, perf := range perfs {
-		p := s.peers[perf.id]
-		if i < maxChildren {
-			p.UpdateDemoStatsWithParent(0, "Local", p.GetStats().NumChildren, p.GetStats().MaxChildren, p.GetStats().LatencyMs)
-		} else {
-			// Connect to one of the top peers
-			parentIdx := (i - maxChildren) / maxChildren
-			if parentIdx < maxChildren && parentIdx < i {
-				parentID := perfs[parentIdx].id
-				p.UpdateDemoStatsWithParent(0, parentID, p.GetStats().NumChildren, p.GetStats().MaxChildren, p.GetStats().LatencyMs)
-			} else {
-				// Fallback or deeper hierarchy
-				parentID := perfs[i%maxChildren].id
-				p.UpdateDemoStatsWithParent(0, parentID, p.GetStats().NumChildren, p.GetStats().MaxChildren, p.GetStats().LatencyMs)
-			}
-		}
-	}
-
-	logger.Info("Network rebalanced based on peer performance")
-}
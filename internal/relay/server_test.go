@@ -6,10 +6,15 @@ package relay
 
 import (
 	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
 	"testing"
 	"time"
 
 	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/ipx"
+	"github.com/mlapointe/ipxtransporter/internal/peer"
 )
 
 func TestServerUpdateConfig(t *testing.T) {
@@ -21,8 +26,8 @@ func TestServerUpdateConfig(t *testing.T) {
 
 	srv.UpdateConfig("new-pass", 10, "new-key", false, 60)
 
-	if cfg.AdminPass != "new-pass" {
-		t.Errorf("Expected admin pass 'new-pass', got '%s'", cfg.AdminPass)
+	if !config.IsPasswordHash(cfg.AdminPass) || !config.CheckPassword(cfg.AdminPass, "new-pass") {
+		t.Errorf("Expected the hash of 'new-pass', got '%s'", cfg.AdminPass)
 	}
 	if cfg.MaxChildren != 10 {
 		t.Errorf("Expected max children 10, got %d", cfg.MaxChildren)
@@ -78,7 +83,7 @@ func TestServerDemoMode(t *testing.T) {
 	}
 
 	srv.SetDemoMode(true)
-	srv.UpdateDemoProps(100, 5, 2, 10)
+	srv.UpdateDemoProps(100, 5, 2, 10, "flat")
 
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancel()
@@ -102,3 +107,205 @@ func TestServerDemoMode(t *testing.T) {
 		t.Errorf("Expected packet rate 100, got %d", st.DemoProps.PacketRate)
 	}
 }
+
+func TestServerBroadcastWorker(t *testing.T) {
+	cfg := config.DefaultConfig()
+	srv, err := NewServer(cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := peer.NewPeer("peer-1", &fakeConn{remoteAddr: &net.TCPAddr{IP: net.ParseIP("1.2.3.4"), Port: 8787}}, "")
+	srv.addPeerLocked(p)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go srv.broadcastWorker(ctx, 0)
+
+	srv.dispatch(srv.broadcastQueue, []byte("frame"))
+
+	deadline := time.After(time.Second)
+	for p.GetStats().QueuedBytes != uint64(len("frame")) {
+		select {
+		case <-deadline:
+			t.Fatal("Timed out waiting for broadcast worker")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	if st := srv.CollectStats(); st.Queues.Broadcast != 0 {
+		t.Errorf("Expected empty broadcast queue, got %d", st.Queues.Broadcast)
+	}
+}
+
+// seqFrame is an IPX frame from node carrying seq.
+func seqFrame(node byte, seq uint16) []byte {
+	pkt := make([]byte, 32)
+	binary.BigEndian.PutUint16(pkt[0:2], 0xffff)
+	binary.BigEndian.PutUint16(pkt[2:4], 32)
+	pkt[10] = 0xff
+	copy(pkt[22:28], net.HardwareAddr{0x02, 0, 0, 0, 0, node})
+	binary.BigEndian.PutUint16(pkt[30:32], seq)
+	frame, _ := ipx.EncapEthernetII(pkt)
+	return frame
+}
+
+func TestServerBroadcastOrder(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.BroadcastWorkers = 4
+	srv, err := NewServer(cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	const nodes, frames = 8, 50
+	var received []chan []byte
+	for i := range 2 {
+		remote, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		local, err := l.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := peer.NewPeer(fmt.Sprintf("peer-%d", i), local, "")
+		srv.addPeerLocked(p)
+		go p.Run(ctx, make(chan []byte, 10), func(string) {})
+		relayed := make(chan []byte, nodes*frames)
+		go peer.NewPeer("remote", remote, "").Run(ctx, relayed, func(string) {})
+		received = append(received, relayed)
+	}
+	for i := range srv.broadcastQueue.workers() {
+		go srv.broadcastWorker(ctx, i)
+	}
+
+	for seq := range uint16(frames) {
+		for node := range byte(nodes) {
+			srv.dispatch(srv.broadcastQueue, seqFrame(node, seq))
+		}
+	}
+
+	for i, relayed := range received {
+		next := make(map[string]uint16)
+		for range nodes * frames {
+			var data []byte
+			select {
+			case data = <-relayed:
+			case <-ctx.Done():
+				t.Fatalf("Peer %d: timed out after %d frames", i, len(next))
+			}
+			h, err := ipx.Parse(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			src := h.Src.Node.String()
+			if seq := binary.BigEndian.Uint16(data[len(data)-2:]); seq != next[src] {
+				t.Fatalf("Peer %d: expected frame %d from %s, got %d", i, next[src], src, seq)
+			}
+			next[src]++
+		}
+	}
+}
+
+func TestServerInjectRetryClassification(t *testing.T) {
+	cfg := config.DefaultConfig()
+	srv, err := NewServer(cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The capturer was never started, so injection fails with a closed handle.
+	srv.inject([]byte("frame"), 1)
+
+	st := srv.CollectStats()
+	if st.Inject.HandleClosed != 1 {
+		t.Errorf("Expected 1 handle-closed error, got %d", st.Inject.HandleClosed)
+	}
+	if st.Inject.Reopens != 1 {
+		t.Errorf("Expected 1 reopen attempt, got %d", st.Inject.Reopens)
+	}
+	if st.Queues.Retry != 1 {
+		t.Errorf("Expected frame to be queued for retry, got queue depth %d", st.Queues.Retry)
+	}
+
+	r := <-srv.retryQueue
+	srv.inject(r.data, injectMaxAttempts)
+	if st := srv.CollectStats(); st.Queues.Retry != 0 || st.Inject.HandleClosed != 2 {
+		t.Errorf("Expected final attempt not to be requeued, got %+v", st.Inject)
+	}
+}
+
+func TestServerTopologyPropagation(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	newNode := func() *Server {
+		srv, err := NewServer(config.DefaultConfig(), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go srv.runTopology(ctx)
+		return srv
+	}
+	link := func(parent, child *Server) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { l.Close() })
+		go func() {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			parent.handleNewConn(ctx, conn, parent.peerRelayChan, "")
+		}()
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		go child.handleNewConn(ctx, conn, child.peerRelayChan, l.Addr().String())
+	}
+
+	root, mid, leaf := newNode(), newNode(), newNode()
+	link(root, mid)
+	link(mid, leaf)
+
+	parents := func(srv *Server) map[string]string {
+		out := make(map[string]string)
+		for _, n := range srv.CollectStats().Topology {
+			out[n.ID] = n.ParentID
+		}
+		return out
+	}
+
+	for {
+		leafView, rootView := parents(leaf), parents(root)
+		if leafView[root.NodeID()] == "" && leafView[mid.NodeID()] == root.NodeID() && leafView[leaf.NodeID()] == mid.NodeID() &&
+			rootView[leaf.NodeID()] == mid.NodeID() {
+			if _, ok := leafView[root.NodeID()]; ok {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatalf("Timed out waiting for topology, leaf sees %v, root sees %v", leafView, rootView)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	for _, p := range mid.CollectStats().Peers {
+		if !p.Inbound && p.ParentID != "" {
+			t.Errorf("Expected mid's parent link to report root as a tree root, got parent %q", p.ParentID)
+		}
+	}
+}
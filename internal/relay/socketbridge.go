@@ -0,0 +1,377 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Socket bridge: connect emulated machines (QEMU, 86Box, PCem) without TAP or pcap
+
+package relay
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/ipx"
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+const (
+	bridgeMaxFrame  = 2000
+	bridgeSendQueue = 256
+)
+
+// bridgeMachine is one emulated machine attached to the socket bridge. It is
+// reported as a pseudo-peer so its traffic shows up next to real links.
+type bridgeMachine struct {
+	id        string
+	remote    net.Addr
+	proto     string // "tcp" or "udp"
+	connected time.Time
+	sendChan  chan []byte
+	close     func()
+
+	mu       sync.RWMutex
+	mac      net.HardwareAddr // learned from the frames it sends
+	lastSeen time.Time
+
+	rxPkts, txPkts, rxBytes, txBytes, errors atomic.Uint64
+	frames                                   stats.FrameTypeCounter
+}
+
+type socketBridge struct {
+	mu       sync.RWMutex
+	machines map[string]*bridgeMachine
+}
+
+func newSocketBridge() *socketBridge {
+	return &socketBridge{machines: make(map[string]*bridgeMachine)}
+}
+
+// runSocketBridge accepts QEMU-style socket networking on cfg.SocketBridgeAddr:
+// over TCP each Ethernet frame is preceded by its length as a 4-byte
+// big-endian integer (-netdev socket/stream), over UDP each datagram is one
+// frame (-netdev dgram, socket udp).
+func (s *Server) runSocketBridge(ctx context.Context) {
+	addr := s.cfg.SocketBridgeAddr
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		logger.Relay.Error("Socket bridge: failed to listen on tcp %s: %v", addr, err)
+		return
+	}
+	udp, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		logger.Relay.Error("Socket bridge: failed to listen on udp %s: %v", addr, err)
+		_ = ln.Close()
+		return
+	}
+	logger.Relay.Info("Socket bridge: accepting emulated machines on tcp/udp %s", addr)
+
+	go func() {
+		<-ctx.Done()
+		_ = ln.Close()
+		_ = udp.Close()
+	}()
+	go s.bridgeUDP(ctx, udp)
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				logger.Relay.Error("Socket bridge: accept error: %v", err)
+			}
+			return
+		}
+		go s.bridgeTCP(ctx, conn)
+	}
+}
+
+func (s *Server) bridgeTCP(ctx context.Context, conn net.Conn) {
+	m := &bridgeMachine{
+		id:        "emu:" + conn.RemoteAddr().String(),
+		remote:    conn.RemoteAddr(),
+		proto:     "tcp",
+		connected: time.Now(),
+		lastSeen:  time.Now(),
+		sendChan:  make(chan []byte, bridgeSendQueue),
+		close:     func() { _ = conn.Close() },
+	}
+	s.addMachine(m)
+	defer s.removeMachine(m)
+	defer conn.Close()
+
+	go func() {
+		w := bufio.NewWriter(conn)
+		for data := range m.sendChan {
+			if err := binary.Write(w, binary.BigEndian, uint32(len(data))); err != nil {
+				m.errors.Add(1)
+				return
+			}
+			if _, err := w.Write(data); err != nil {
+				m.errors.Add(1)
+				return
+			}
+			if len(m.sendChan) == 0 {
+				if err := w.Flush(); err != nil {
+					m.errors.Add(1)
+					return
+				}
+			}
+		}
+	}()
+
+	r := bufio.NewReader(conn)
+	for {
+		var length uint32
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+			if err != io.EOF && ctx.Err() == nil {
+				logger.Relay.Error("Socket bridge: %s recv error: %v", m.id, err)
+			}
+			return
+		}
+		if length > bridgeMaxFrame {
+			logger.Relay.Error("Socket bridge: %s sent too large frame: %d", m.id, length)
+			return
+		}
+		frame := make([]byte, length)
+		if _, err := io.ReadFull(r, frame); err != nil {
+			logger.Relay.Error("Socket bridge: %s recv data error: %v", m.id, err)
+			return
+		}
+		s.machineFrame(m, frame)
+	}
+}
+
+func (s *Server) bridgeUDP(ctx context.Context, conn net.PacketConn) {
+	buf := make([]byte, bridgeMaxFrame)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() == nil {
+				logger.Relay.Error("Socket bridge: udp read error: %v", err)
+			}
+			return
+		}
+
+		id := "emu:" + from.String()
+		s.bridge.mu.RLock()
+		m := s.bridge.machines[id]
+		s.bridge.mu.RUnlock()
+		if m == nil {
+			m = &bridgeMachine{
+				id:        id,
+				remote:    from,
+				proto:     "udp",
+				connected: time.Now(),
+				lastSeen:  time.Now(),
+				sendChan:  make(chan []byte, bridgeSendQueue),
+			}
+			done := make(chan struct{})
+			var once sync.Once
+			m.close = func() { once.Do(func() { close(done) }) }
+			s.addMachine(m)
+			go func() {
+				for {
+					select {
+					case <-ctx.Done():
+						return
+					case <-done:
+						s.removeMachine(m)
+						return
+					case data := <-m.sendChan:
+						if _, err := conn.WriteTo(data, from); err != nil {
+							m.errors.Add(1)
+						}
+					}
+				}
+			}()
+		}
+		s.machineFrame(m, append([]byte(nil), buf[:n]...))
+	}
+}
+
+func (s *Server) addMachine(m *bridgeMachine) {
+	s.bridge.mu.Lock()
+	s.bridge.machines[m.id] = m
+	s.bridge.mu.Unlock()
+	logger.Relay.Info("Socket bridge: machine %s attached over %s", m.id, m.proto)
+}
+
+func (s *Server) removeMachine(m *bridgeMachine) {
+	s.bridge.mu.Lock()
+	if s.bridge.machines[m.id] == m {
+		delete(s.bridge.machines, m.id)
+		if m.proto == "tcp" {
+			close(m.sendChan)
+		}
+	}
+	s.bridge.mu.Unlock()
+	logger.Relay.Info("Socket bridge: machine %s detached", m.id)
+}
+
+// machineFrame relays an Ethernet frame sent by an emulated machine. Only
+// IPX is bridged; the machine's other traffic (ARP, IP) is dropped.
+func (s *Server) machineFrame(m *bridgeMachine, frame []byte) {
+	h, err := ipx.Parse(frame)
+	m.mu.Lock()
+	m.lastSeen = time.Now()
+	if err == nil {
+		m.mac = append(m.mac[:0], h.SrcMAC...)
+	}
+	m.mu.Unlock()
+	m.rxPkts.Add(1)
+	m.rxBytes.Add(uint64(len(frame)))
+	if err != nil {
+		m.frames.AddRx(ipx.FrameUnknown, len(frame))
+		return
+	}
+	m.frames.AddRx(h.FrameType, len(frame))
+
+	s.deliverBridge(frame, m)
+	s.deliverVirtual(frame, nil)
+	s.deliverLegacy(frame)
+	s.ingestEmulated(frame)
+}
+
+// ingestEmulated feeds a frame from an attached emulator into the relay as
+// if it had been captured on the local segment.
+func (s *Server) ingestEmulated(frame []byte) {
+	atomic.AddUint64(&s.totalReceived, 1)
+	atomic.AddUint64(&s.receivedBytes, uint64(len(frame)))
+	s.recordFrame(captureSegment, frame)
+	if s.handleBeacon(frame, viaEmulator) {
+		return
+	}
+	s.meterFlow(flowLinkEmulator, frame)
+	if h, err := ipx.Parse(frame); err == nil {
+		s.macTable.LearnLocal(h)
+		s.hosts.see(h, viaEmulator, "", "", time.Now())
+	}
+	s.samples.add(frame)
+	if s.dedup.IsDuplicate(frame) {
+		atomic.AddUint64(&s.totalDropped, 1)
+		return
+	}
+	s.dispatch(s.broadcastQueue, frame)
+	if captureErr, _ := s.captureError.Load().(string); captureErr == "" {
+		s.dispatch(s.injectQueue, frame)
+	}
+}
+
+// deliverBridge queues a frame for the emulated machines it is addressed to,
+// skipping the machine it came from.
+func (s *Server) deliverBridge(frame []byte, except *bridgeMachine) {
+	if s.bridge == nil || len(frame) < 6 {
+		return
+	}
+	dst := net.HardwareAddr(frame[0:6])
+	group := dst[0]&1 != 0 // broadcast or multicast
+
+	s.bridge.mu.RLock()
+	defer s.bridge.mu.RUnlock()
+	for _, m := range s.bridge.machines {
+		if m == except {
+			continue
+		}
+		if !group {
+			m.mu.RLock()
+			match := string(m.mac) == string(dst)
+			m.mu.RUnlock()
+			if !match {
+				continue
+			}
+		}
+		select {
+		case m.sendChan <- frame:
+			m.txPkts.Add(1)
+			m.txBytes.Add(uint64(len(frame)))
+			ft, _ := ipx.DetectFrameType(frame)
+			m.frames.AddTx(ft, len(frame))
+		default:
+			m.errors.Add(1)
+		}
+	}
+}
+
+// pruneBridge detaches UDP machines that have been silent for longer than
+// the MAC table TTL; TCP machines go away when their connection closes.
+func (s *Server) pruneBridge() {
+	if s.bridge == nil {
+		return
+	}
+	ttl := time.Duration(s.cfg.MACTableTTL) * time.Second
+	var idle []*bridgeMachine
+	s.bridge.mu.RLock()
+	for _, m := range s.bridge.machines {
+		m.mu.RLock()
+		if m.proto == "udp" && time.Since(m.lastSeen) > ttl {
+			idle = append(idle, m)
+		}
+		m.mu.RUnlock()
+	}
+	s.bridge.mu.RUnlock()
+	for _, m := range idle {
+		m.close()
+	}
+}
+
+// disconnectMachine detaches an emulated machine by its pseudo-peer ID.
+func (s *Server) disconnectMachine(id string) bool {
+	if s.bridge == nil {
+		return false
+	}
+	s.bridge.mu.RLock()
+	m := s.bridge.machines[id]
+	s.bridge.mu.RUnlock()
+	if m == nil {
+		return false
+	}
+	m.close()
+	return true
+}
+
+// collectMachines reports every emulated machine as a pseudo-peer.
+func (s *Server) collectMachines() []stats.PeerStat {
+	if s.bridge == nil {
+		return nil
+	}
+	s.bridge.mu.RLock()
+	defer s.bridge.mu.RUnlock()
+	out := make([]stats.PeerStat, 0, len(s.bridge.machines))
+	for _, m := range s.bridge.machines {
+		var ip net.IP
+		switch a := m.remote.(type) {
+		case *net.TCPAddr:
+			ip = a.IP
+		case *net.UDPAddr:
+			ip = a.IP
+		}
+		m.mu.RLock()
+		ps := stats.PeerStat{
+			ID:          m.id,
+			IP:          ip,
+			ConnectedAt: m.connected,
+			LastSeen:    m.lastSeen,
+			SentBytes:   m.txBytes.Load(),
+			RecvBytes:   m.rxBytes.Load(),
+			SentPkts:    m.txPkts.Load(),
+			RecvPkts:    m.rxPkts.Load(),
+			Errors:      m.errors.Load(),
+			Hostname:    "emulator (" + m.proto + ")",
+			Inbound:     true,
+			Emulated:    true,
+			FrameTypes:  m.frames.Snapshot(),
+		}
+		if m.mac != nil {
+			ps.Hostname = "emulator " + m.mac.String() + " (" + m.proto + ")"
+		}
+		m.mu.RUnlock()
+		out = append(out, ps)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
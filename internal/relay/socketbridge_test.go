@@ -0,0 +1,92 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for the emulator socket bridge
+
+package relay
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/ipx"
+)
+
+// bridgeFrame builds an Ethernet_II IPX frame from src to dst.
+func bridgeFrame(dst, src net.HardwareAddr) []byte {
+	pkt := make([]byte, 30)
+	binary.BigEndian.PutUint16(pkt[0:2], 0xffff)
+	binary.BigEndian.PutUint16(pkt[2:4], 30)
+	copy(pkt[10:16], dst)
+	copy(pkt[22:28], src)
+	frame, _ := ipx.EncapEthernetII(pkt)
+	return frame
+}
+
+func TestServerSocketBridgeTCP(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.SocketBridgeAddr = "127.0.0.1:0"
+	srv, err := NewServer(cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.captureError.Store("no capture")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	vm, server := net.Pipe()
+	defer vm.Close()
+	go srv.bridgeTCP(ctx, server)
+
+	mac := net.HardwareAddr{0x52, 0x54, 0x00, 0x12, 0x34, 0x56}
+	out := bridgeFrame(net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, mac)
+	msg := binary.BigEndian.AppendUint32(nil, uint32(len(out)))
+	if _, err := vm.Write(append(msg, out...)); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for srv.broadcastQueue.len() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if srv.broadcastQueue.len() != 1 {
+		t.Fatal("Expected the machine's frame to be queued for the peers")
+	}
+
+	var machine *bridgeMachine
+	for _, p := range srv.CollectStats().Peers {
+		if p.Emulated && p.RecvPkts == 1 {
+			srv.bridge.mu.RLock()
+			machine = srv.bridge.machines[p.ID]
+			srv.bridge.mu.RUnlock()
+		}
+	}
+	if machine == nil {
+		t.Fatal("Expected the machine to be reported as a pseudo-peer")
+	}
+
+	// Unicast from the mesh reaches the machine by its learned MAC.
+	in := bridgeFrame(mac, net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	srv.deliverBridge(in, nil)
+	_ = vm.SetReadDeadline(time.Now().Add(time.Second))
+	var length uint32
+	if err := binary.Read(vm, binary.BigEndian, &length); err != nil || int(length) != len(in) {
+		t.Fatalf("Expected a %d-byte frame, got %d (%v)", len(in), length, err)
+	}
+	if _, err := io.ReadFull(vm, make([]byte, length)); err != nil {
+		t.Fatal(err)
+	}
+
+	srv.DisconnectPeer(machine.id)
+	deadline = time.Now().Add(time.Second)
+	for len(srv.collectMachines()) != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := len(srv.collectMachines()); n != 0 {
+		t.Errorf("Expected the machine to be detached, got %d", n)
+	}
+}
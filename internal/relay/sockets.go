@@ -0,0 +1,70 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Socket names set by the user over the built-in database
+
+package relay
+
+import (
+	"fmt"
+	"maps"
+	"strings"
+
+	"github.com/mlapointe/ipxtransporter/internal/ipx"
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+// applySocketNames has the classifier, traffic counters and rules use the
+// names of socket_names.
+func (s *Server) applySocketNames() error {
+	names, err := s.cfg.SocketNameMap()
+	if err != nil {
+		return fmt.Errorf("socket_names: %v", err)
+	}
+	ipx.SetSocketNames(names)
+	return nil
+}
+
+// SocketNames lists the named sockets, built in and set by the user.
+func (s *Server) SocketNames() []ipx.SocketName {
+	return ipx.SocketNames()
+}
+
+// NameSocket names socket, over its built-in name if it has one, or
+// removes the name the user gave it if name is empty, and saves the config.
+func (s *Server) NameSocket(socket uint16, name string) error {
+	name = strings.TrimSpace(name)
+	s.replica.mu.Lock()
+	names := maps.Clone(s.cfg.SocketNames)
+	if names == nil {
+		names = map[string]string{}
+	}
+	found := false
+	for key := range names {
+		if n, err := ipx.ParseSocketNumber(key); err == nil && n == socket {
+			delete(names, key)
+			found = true
+		}
+	}
+	if name == "" && !found {
+		s.replica.mu.Unlock()
+		return fmt.Errorf("socket 0x%04X has no name of its own", socket)
+	}
+	if name != "" {
+		names[fmt.Sprintf("0x%04X", socket)] = name
+	}
+	s.cfg.SocketNames = names
+	err := s.applySocketNames()
+	s.replica.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	s.persistConfig()
+	s.changes.Notify(stats.ChangeCounters)
+	if name == "" {
+		logger.Relay.Info("Removed the name of socket 0x%04X", socket)
+	} else {
+		logger.Relay.Info("Named socket 0x%04X %q", socket, name)
+	}
+	return nil
+}
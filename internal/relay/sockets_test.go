@@ -0,0 +1,48 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for user socket names
+
+package relay
+
+import (
+	"encoding/json"
+	"maps"
+	"testing"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/ipx"
+)
+
+func TestNameSocket(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.SocketNames = map[string]string{"5a00": "Raptor"}
+	srv, err := NewServer(cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ipx.SetSocketNames(nil) })
+	if ipx.SocketLabel(0x5A00) != "Raptor" {
+		t.Errorf("Expected the configured name applied, got %q", ipx.SocketLabel(0x5A00))
+	}
+
+	// A new name replaces the one kept under another spelling.
+	if err := srv.NameSocket(0x5A00, "Raptor: Call of the Shadows"); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"0x5A00": "Raptor: Call of the Shadows"}; !maps.Equal(cfg.SocketNames, want) {
+		t.Errorf("Expected %v, got %v", want, cfg.SocketNames)
+	}
+	if err := srv.NameSocket(0x5A00, ""); err != nil || ipx.SocketLabel(0x5A00) != "" {
+		t.Errorf("Expected the name removed, got %v and %q", err, ipx.SocketLabel(0x5A00))
+	}
+	if err := srv.NameSocket(0x0452, ""); err == nil {
+		t.Error("Expected removing a built-in name refused")
+	}
+
+	if err := srv.SetConfigValue("socket_names", json.RawMessage(`{"0x7001": "Blood"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if ipx.SocketLabel(0x7001) != "Blood" {
+		t.Errorf("Expected a name set as a setting applied, got %q", ipx.SocketLabel(0x7001))
+	}
+}
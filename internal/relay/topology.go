@@ -0,0 +1,343 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Overlay tree discovery: hello and topology exchange between peers
+
+package relay
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+	"github.com/mlapointe/ipxtransporter/internal/peer"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+const (
+	// maxTopologyHops bounds how far topology entries travel, so a
+	// misconfigured loop cannot grow the tree without limit.
+	maxTopologyHops  = 16
+	topologyDebounce = 500 * time.Millisecond
+)
+
+func newNodeID() string {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func localHostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return ""
+	}
+	return name
+}
+
+// NodeID returns the ID this node announces to its peers.
+func (s *Server) NodeID() string {
+	return s.nodeID
+}
+
+// hello opens every link after the network key exchange: it tells the
+// remote side who we are, which end of the parent/child link we take and
+// which IPX networks it reaches through us.
+func (s *Server) hello(p *peer.Peer) peer.Control {
+	role := peer.RoleParent
+	if p.DialAddr != "" {
+		role = peer.RoleChild
+	}
+	standby := p.DialAddr != "" && p.DialAddr == s.cfg.StandbyOf
+	s.peersMu.RLock()
+	declared := s.declaredFor(p)
+	s.peersMu.RUnlock()
+	return peer.Control{Type: peer.ControlHello, NodeID: s.nodeID, Role: role, Protocol: peer.ProtocolVersion, Padding: true, Traces: true, Deflate: true, Standby: standby,
+		Declares: true, Declared: declared}
+}
+
+func (s *Server) handleHello(p *peer.Peer, c peer.Control) {
+	if !s.admitOnHello(p, c.NodeID) {
+		return
+	}
+	if c.Probe {
+		// A preflight only tests the link before closing it: send it
+		// nothing and leave it out of the tree.
+		p.SetMute(false, true)
+		logger.Relay.Info("Peer %s (node %s) is testing the link", p.ID, c.NodeID)
+		return
+	}
+	if !s.checkHandshake(p, c.NodeID) {
+		return
+	}
+	if s.dropRedundantAutoLink(p, c.NodeID) {
+		return
+	}
+	want := peer.RoleChild
+	if !p.Inbound {
+		want = peer.RoleParent
+	}
+	if c.Role != want {
+		logger.Relay.Error("Peer %s (node %s) announced itself as %s, expected %s", p.ID, c.NodeID, c.Role, want)
+	}
+	if c.Standby {
+		s.addStandby(p, c.NodeID)
+	}
+	s.markTopologyDirty()
+}
+
+// handleTopology records where an outbound peer sits in the tree and
+// schedules our own view to be passed on.
+func (s *Server) handleTopology(p *peer.Peer, c peer.Control) {
+	if !p.Inbound {
+		id := p.NodeID()
+		for _, n := range c.Nodes {
+			if n.ID == id {
+				p.SetParentID(n.ParentID)
+				break
+			}
+		}
+	}
+	s.markTopologyDirty()
+}
+
+func (s *Server) markTopologyDirty() {
+	s.changes.Notify(stats.ChangePeers)
+	select {
+	case s.topologyDirty <- struct{}{}:
+	default:
+	}
+}
+
+// runTopology sends each peer our view of the tree whenever it changes and
+// again every statusInterval, so stale branches age out.
+func (s *Server) runTopology(ctx context.Context) {
+	ticker := time.NewTicker(statusInterval)
+	defer ticker.Stop()
+	lastSent := make(map[string]string)
+	for {
+		force := false
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			force = true
+		case <-s.topologyDirty:
+			sleepCtx(ctx, topologyDebounce)
+		}
+
+		s.peersMu.RLock()
+		peers := make([]*peer.Peer, 0, len(s.peers))
+		for _, p := range s.peers {
+			peers = append(peers, p)
+		}
+		s.peersMu.RUnlock()
+
+		current := make(map[string]string, len(peers))
+		for _, p := range peers {
+			nodes := s.topologyFor(p)
+			key, _ := json.Marshal(nodes)
+			current[p.ID] = string(key)
+			if !force && lastSent[p.ID] == string(key) {
+				continue
+			}
+			p.SendControl(peer.Control{Type: peer.ControlTopology, Nodes: nodes})
+		}
+		lastSent = current
+	}
+}
+
+// topologyFor returns the tree as seen from this node, leaving out the
+// branch reached through exclude so it is not echoed back to its source.
+func (s *Server) topologyFor(exclude *peer.Peer) []peer.TopologyNode {
+	s.peersMu.RLock()
+	defer s.peersMu.RUnlock()
+	return s.topologyLocked(exclude)
+}
+
+// topologyLocked must be called with peersMu held.
+func (s *Server) topologyLocked(exclude *peer.Peer) []peer.TopologyNode {
+	self := peer.TopologyNode{
+		ID:          s.nodeID,
+		Hostname:    s.hostname,
+		MaxChildren: s.cfg.MaxChildren,
+		ListenAddr:  s.cfg.ListenAddr,
+		StartedAt:   s.startTime.Unix(),
+	}
+	if parent := s.parentLocked(); parent != nil {
+		self.ParentID, self.Metric = parent.NodeID(), parent.LinkMetric()
+	}
+	if s.cfg.HubElection {
+		self.HubPriority = s.cfg.HubPriority
+	}
+	for _, p := range s.peers {
+		if p.Inbound {
+			self.NumChildren++
+		}
+	}
+
+	nodes := []peer.TopologyNode{self}
+	index := map[string]int{s.nodeID: 0}
+	for _, p := range s.sortedPeersLocked() {
+		if p == exclude {
+			continue
+		}
+		known := s.honors(p, TrustKnown)
+		for _, n := range p.Topology() {
+			n.Hops++
+			if n.ID == "" || n.Hops > maxTopologyHops || !known && n.ID != p.NodeID() {
+				continue
+			}
+			if n.ID == p.NodeID() {
+				// A node only knows its listen port; we know the address
+				// we see it connect from.
+				n.ListenAddr = p.RemoteListenAddr()
+			}
+			if i, ok := index[n.ID]; ok {
+				if n.Hops < nodes[i].Hops {
+					nodes[i] = n
+				}
+				continue
+			}
+			index[n.ID] = len(nodes)
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
+}
+
+// parentMargin is how much lower than the oldest outbound link's metric
+// another's must be to make it the parent, so latency jitter does not
+// flip the tree between links of about the same metric.
+const parentMargin = 0.8
+
+// parentLocked returns our outbound link to a known node with the lowest
+// metric, which is our parent in the tree, or nil if we are a root. Of
+// links with about the same metric the oldest wins.
+func (s *Server) parentLocked() *peer.Peer {
+	var best *peer.Peer
+	var bestMetric int
+	for _, p := range s.sortedPeersLocked() {
+		if p.Inbound || p.NodeID() == "" {
+			continue
+		}
+		if m := p.LinkMetric(); best == nil || float64(m) < parentMargin*float64(bestMetric) {
+			best, bestMetric = p, m
+		}
+	}
+	return best
+}
+
+// parentNodeLocked returns the node ID of our parent, or "" if we are a
+// root.
+func (s *Server) parentNodeLocked() string {
+	if p := s.parentLocked(); p != nil {
+		return p.NodeID()
+	}
+	return ""
+}
+
+func (s *Server) sortedPeersLocked() []*peer.Peer {
+	out := make([]*peer.Peer, 0, len(s.peers))
+	for _, p := range s.peers {
+		out = append(out, p)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].ConnectedAt.Equal(out[j].ConnectedAt) {
+			return out[i].ConnectedAt.Before(out[j].ConnectedAt)
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out
+}
+
+// collectTopology converts the tree for CollectStats. Direct peers that have
+// not reported a topology yet, and all peers in demo mode, are placed by
+// their ParentID. Nodes we link to directly, and the local node, carry the
+// end-stations seen behind them. Must be called with peersMu held.
+func (s *Server) collectTopology(localStations int, stations map[string]int) []stats.TopologyNode {
+	byNode := make(map[string]*peer.Peer)
+	for _, p := range s.peers {
+		if id := p.NodeID(); id != "" {
+			byNode[id] = p
+		}
+	}
+
+	var out []stats.TopologyNode
+	listed := make(map[string]bool)
+	if !s.demoMode {
+		via := s.relayedVia()
+		segments := s.segmentReports()
+		if own, ok := s.ownSegmentReport(time.Now()); ok {
+			segments[s.nodeID] = &own
+		}
+		for _, n := range s.topologyLocked(nil) {
+			tn := stats.TopologyNode{
+				ID:          n.ID,
+				ParentID:    n.ParentID,
+				Hostname:    n.Hostname,
+				NumChildren: n.NumChildren,
+				MaxChildren: n.MaxChildren,
+				Hops:        n.Hops,
+				RelayedVia:  via[n.ID],
+				Segment:     segments[n.ID],
+				Metric:      n.Metric,
+			}
+			if p, ok := byNode[n.ID]; ok {
+				tn.PeerID = p.ID
+				tn.Stations = stations[p.ID]
+				if tn.Metric == 0 && n.ParentID == s.nodeID {
+					// A child too old to report the metric of its link.
+					tn.Metric = p.LinkMetric()
+				}
+			}
+			if n.ID == s.nodeID {
+				tn.Stations = localStations
+			}
+			out = append(out, tn)
+			listed[n.ID] = true
+		}
+	} else {
+		out = append(out, stats.TopologyNode{ID: s.nodeID, Hostname: s.hostname, MaxChildren: s.cfg.MaxChildren, Stations: localStations})
+	}
+
+	for _, p := range s.peers {
+		if listed[p.NodeID()] {
+			continue
+		}
+		st := p.GetStats()
+		tn := stats.TopologyNode{
+			ID:          p.ID,
+			ParentID:    st.ParentID,
+			Hostname:    st.Hostname,
+			PeerID:      p.ID,
+			NumChildren: st.NumChildren,
+			MaxChildren: st.MaxChildren,
+			Hops:        1,
+			Stations:    stations[p.ID],
+			Metric:      st.Metric,
+		}
+		switch {
+		case tn.ParentID == "Local" || (tn.ParentID == "" && p.Inbound):
+			tn.ParentID = s.nodeID
+		case tn.ParentID == "" && !s.demoMode && out[0].ParentID == "":
+			out[0].ParentID = p.ID
+		case tn.ParentID == "":
+			tn.ParentID = s.nodeID
+		}
+		out = append(out, tn)
+	}
+
+	sort.SliceStable(out[1:], func(i, j int) bool {
+		a, b := out[1+i], out[1+j]
+		if a.Hops != b.Hops {
+			return a.Hops < b.Hops
+		}
+		return a.ID < b.ID
+	})
+	return out
+}
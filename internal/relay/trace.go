@@ -0,0 +1,134 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Packet provenance: relay traces on the frames of debugged flows
+
+package relay
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/ipx"
+	"github.com/mlapointe/ipxtransporter/internal/peer"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+// maxTraces is how many received traces are kept for display.
+const maxTraces = 64
+
+// traceState selects the frames we send with a relay trace and keeps the
+// traces of those we received.
+type traceState struct {
+	on atomic.Bool // some flow is selected, checked for every frame sent
+
+	mu     sync.Mutex
+	flows  []config.TraceFlow
+	nodes  []net.HardwareAddr // parsed Node of each flow, nil for any
+	recent []stats.PacketTrace
+}
+
+func parseTraceFlows(flows []config.TraceFlow) ([]net.HardwareAddr, error) {
+	nodes := make([]net.HardwareAddr, len(flows))
+	for i, f := range flows {
+		if f.Socket == 0 && f.Node == "" {
+			return nil, errors.New("trace: a flow needs a socket, a node or both")
+		}
+		if f.Node == "" {
+			continue
+		}
+		mac, err := net.ParseMAC(f.Node)
+		if err != nil {
+			return nil, fmt.Errorf("trace: bad node %q: %v", f.Node, err)
+		}
+		nodes[i] = mac
+	}
+	return nodes, nil
+}
+
+// SetTraceFlows replaces the flows whose frames are sent with a relay
+// trace, until the next restart; an empty list stops tracing.
+func (s *Server) SetTraceFlows(flows []config.TraceFlow) error {
+	nodes, err := parseTraceFlows(flows)
+	if err != nil {
+		return err
+	}
+	t := &s.trace
+	t.mu.Lock()
+	t.flows, t.nodes = slices.Clone(flows), nodes
+	t.mu.Unlock()
+	t.on.Store(len(flows) > 0)
+	return nil
+}
+
+// TraceFlows returns the flows being traced.
+func (s *Server) TraceFlows() []config.TraceFlow {
+	s.trace.mu.Lock()
+	defer s.trace.mu.Unlock()
+	return slices.Clone(s.trace.flows)
+}
+
+// traceStart returns the trace a frame we originate starts with, or nil if
+// it belongs to no traced flow.
+func (s *Server) traceStart(data []byte) []stats.TraceHop {
+	t := &s.trace
+	if !t.on.Load() || !s.flags.tracing.Load() {
+		return nil
+	}
+	h, err := ipx.Parse(data)
+	if err != nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, f := range t.flows {
+		if f.Socket != 0 && h.Src.Socket != f.Socket && h.Dst.Socket != f.Socket {
+			continue
+		}
+		if n := t.nodes[i]; n != nil && !bytes.Equal(h.Src.Node, n) && !bytes.Equal(h.Dst.Node, n) {
+			continue
+		}
+		return []stats.TraceHop{{Node: s.nodeID, At: time.Now()}}
+	}
+	return nil
+}
+
+// addHop returns hops with ours appended, leaving hops as it is. A full
+// trace is passed on unchanged.
+func (s *Server) addHop(hops []stats.TraceHop, now time.Time) []stats.TraceHop {
+	if len(hops) >= peer.MaxTraceHops {
+		return hops
+	}
+	return append(slices.Clip(hops), stats.TraceHop{Node: s.nodeID, At: now})
+}
+
+// recordTrace keeps the path of a traced frame received from p, ending
+// with our own hop.
+func (s *Server) recordTrace(p *peer.Peer, data []byte, hops []stats.TraceHop) {
+	now := time.Now()
+	tr := stats.PacketTrace{Received: now, Peer: p.ID, Size: len(data)}
+	tr.Hops = append(slices.Clone(hops), stats.TraceHop{Node: s.nodeID, At: now})
+	if h, err := ipx.Parse(data); err == nil {
+		tr.Src, tr.Dst = h.Src.String(), h.Dst.String()
+	}
+	t := &s.trace
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.recent) == maxTraces {
+		t.recent = slices.Delete(t.recent, 0, 1)
+	}
+	t.recent = append(t.recent, tr)
+}
+
+// Traces returns the traced frames received lately, oldest first.
+func (s *Server) Traces() []stats.PacketTrace {
+	s.trace.mu.Lock()
+	defer s.trace.mu.Unlock()
+	return slices.Clone(s.trace.recent)
+}
@@ -0,0 +1,89 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for packet provenance tracing
+
+package relay
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/ipx"
+	"github.com/mlapointe/ipxtransporter/internal/peer"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+// socketFrame builds an Ethernet_II IPX frame between two sockets.
+func socketFrame(src, dst uint16) []byte {
+	pkt := make([]byte, 30)
+	binary.BigEndian.PutUint16(pkt[0:2], 0xffff)
+	binary.BigEndian.PutUint16(pkt[2:4], 30)
+	binary.BigEndian.PutUint16(pkt[16:18], dst)
+	copy(pkt[22:28], net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	binary.BigEndian.PutUint16(pkt[28:30], src)
+	frame, _ := ipx.EncapEthernetII(pkt)
+	return frame
+}
+
+func TestServerTraceFlows(t *testing.T) {
+	cfg := config.DefaultConfig()
+	srv, err := NewServer(cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := socketFrame(0x4000, 0x869C)
+	if hops := srv.traceStart(frame); hops != nil {
+		t.Fatalf("Expected no trace with no flows, got %v", hops)
+	}
+
+	if err := srv.SetTraceFlows([]config.TraceFlow{{}}); err == nil {
+		t.Error("Expected a flow matching everything to be refused")
+	}
+	if err := srv.SetTraceFlows([]config.TraceFlow{{Node: "not a mac"}}); err == nil {
+		t.Error("Expected a bad node to be refused")
+	}
+	if err := srv.SetTraceFlows([]config.TraceFlow{{Socket: 0x869C}}); err != nil {
+		t.Fatal(err)
+	}
+	hops := srv.traceStart(frame)
+	if len(hops) != 1 || hops[0].Node != srv.nodeID {
+		t.Fatalf("Expected a trace starting here, got %v", hops)
+	}
+	if srv.traceStart(socketFrame(0x4000, 0x0452)) != nil {
+		t.Error("Expected a frame of another socket not to be traced")
+	}
+
+	full := make([]stats.TraceHop, peer.MaxTraceHops)
+	if got := srv.addHop(full, time.Now()); len(got) != peer.MaxTraceHops {
+		t.Errorf("Expected a full trace to pass unchanged, got %d hops", len(got))
+	}
+	if got := srv.addHop(hops, time.Now()); len(got) != 2 || len(hops) != 1 {
+		t.Errorf("Expected our hop added to a copy, got %d and %d hops", len(got), len(hops))
+	}
+}
+
+func TestServerRecordTrace(t *testing.T) {
+	srv, err := NewServer(config.DefaultConfig(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := peer.NewPeer("p1", &fakeConn{remoteAddr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 8787}}, "")
+	start := time.Now().Add(-5 * time.Millisecond)
+	for range maxTraces + 1 {
+		srv.peerFrame(p, socketFrame(0x4000, 0x869C), []stats.TraceHop{{Node: "origin", At: start}})
+	}
+	traces := srv.Traces()
+	if len(traces) != maxTraces {
+		t.Fatalf("Expected %d traces kept, got %d", maxTraces, len(traces))
+	}
+	tr := traces[0]
+	if tr.Peer != "p1" || len(tr.Hops) != 2 || tr.Hops[0].Node != "origin" || tr.Hops[1].Node != srv.nodeID {
+		t.Errorf("Expected the path from origin to us, got %+v", tr)
+	}
+	if tr.Src == "" || tr.Dst == "" {
+		t.Errorf("Expected the addresses decoded, got %q and %q", tr.Src, tr.Dst)
+	}
+}
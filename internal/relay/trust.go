@@ -0,0 +1,98 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Peer trust levels gating which metadata from a peer is acted on
+
+package relay
+
+import (
+	"crypto"
+	"crypto/tls"
+	"fmt"
+	"net"
+
+	"github.com/mlapointe/ipxtransporter/internal/peer"
+)
+
+// Peer trust levels, lowest first. Traffic is relayed for every level; the
+// level decides which of the peer's metadata we act on:
+//
+//	unknown  only the peer's own place in the topology and the networks
+//	         actually seen on its link
+//	known    also its gossip, advertised networks, the rest of the tree it
+//	         reports (hub candidates, relay paths) and the load it reports
+//	         for rebalancing
+//	trusted  also gossip that bypasses manual approval
+const (
+	TrustUnknown = "unknown"
+	TrustKnown   = "known"
+	TrustTrusted = "trusted"
+)
+
+var trustRank = map[string]int{TrustUnknown: 0, TrustKnown: 1, TrustTrusted: 2}
+
+// Peer groups for config.TrustDefaults.
+const (
+	groupConfigured = "configured" // peers we dial from the config or by hand
+	groupAuto       = "auto"       // peers auto-connected from gossip
+	groupInbound    = "inbound"    // peers that connected to us
+)
+
+// loadSigner returns the private key of the TLS certificate, used to sign
+// control-plane metadata.
+func loadSigner(certPath, keyPath string) (crypto.Signer, string, error) {
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, "", err
+	}
+	key, ok := cert.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, "", fmt.Errorf("TLS key of type %T cannot sign", cert.PrivateKey)
+	}
+	fp, err := peer.Fingerprint(key.Public())
+	if err != nil {
+		return nil, "", err
+	}
+	return key, fp, nil
+}
+
+// peerTrust returns the peer's trust level: the one configured for its
+// verified key fingerprint or its IP, else the default for its group.
+func (s *Server) peerTrust(p *peer.Peer) string {
+	if fp := p.KeyFingerprint(); fp != "" {
+		if level, ok := s.cfg.PeerTrust[fp]; ok && validTrust(level) {
+			return level
+		}
+	}
+	if addr, ok := p.Conn.RemoteAddr().(*net.TCPAddr); ok {
+		if level, ok := s.cfg.PeerTrust[addr.IP.String()]; ok && validTrust(level) {
+			return level
+		}
+	}
+	if level := s.cfg.TrustDefaults[s.peerGroup(p)]; validTrust(level) {
+		return level
+	}
+	return TrustKnown
+}
+
+func validTrust(level string) bool {
+	_, ok := trustRank[level]
+	return ok
+}
+
+func (s *Server) peerGroup(p *peer.Peer) string {
+	if p.Inbound {
+		return groupInbound
+	}
+	s.gossip.mu.Lock()
+	_, auto := s.gossip.auto[p.DialAddr]
+	s.gossip.mu.Unlock()
+	if auto {
+		return groupAuto
+	}
+	return groupConfigured
+}
+
+// honors reports whether the peer's trust level is at least min.
+func (s *Server) honors(p *peer.Peer, min string) bool {
+	return trustRank[s.peerTrust(p)] >= trustRank[min]
+}
@@ -0,0 +1,61 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for peer trust levels
+
+package relay
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/peer"
+)
+
+func TestServerPeerTrust(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Gossip = true
+	cfg.GossipApproval = true
+	cfg.TrustDefaults["inbound"] = TrustUnknown
+	cfg.PeerTrust = map[string]string{"10.0.0.1": TrustTrusted, "10.0.0.2": "bogus"}
+	srv, err := NewServer(cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // dialers must not actually connect
+	srv.runCtx = ctx
+
+	newPeer := func(id, ip string, inbound bool) *peer.Peer {
+		p := peer.NewPeer(id, &fakeConn{remoteAddr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 8787}}, "")
+		p.Inbound = inbound
+		return p
+	}
+	trusted := newPeer("trusted", "10.0.0.1", false)
+	bogus := newPeer("bogus", "10.0.0.2", false)
+	stranger := newPeer("stranger", "10.0.0.3", true)
+
+	for p, want := range map[*peer.Peer]string{trusted: TrustTrusted, bogus: TrustKnown, stranger: TrustUnknown} {
+		if got := srv.peerTrust(p); got != want {
+			t.Errorf("Expected %s trust %s, got %s", p.ID, want, got)
+		}
+	}
+
+	srv.handleGossip(stranger, peer.Control{Type: peer.ControlGossip, Peers: []string{"10.0.0.5:8787"}})
+	srv.handleGossip(bogus, peer.Control{Type: peer.ControlGossip, Peers: []string{"10.0.0.6:8787"}})
+	srv.handleGossip(trusted, peer.Control{Type: peer.ControlGossip, Peers: []string{"10.0.0.7:8787"}})
+	pending := map[string]bool{}
+	for _, d := range srv.discoveredPeers() {
+		pending[d.Addr] = d.Pending
+	}
+	if _, ok := pending["10.0.0.5:8787"]; ok {
+		t.Error("Expected gossip from an unknown peer to be ignored")
+	}
+	if !pending["10.0.0.6:8787"] {
+		t.Error("Expected gossip from a known peer to wait for approval")
+	}
+	if p, ok := pending["10.0.0.7:8787"]; !ok || p {
+		t.Error("Expected gossip from a trusted peer to bypass approval")
+	}
+}
@@ -0,0 +1,175 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// DNS-over-HTTPS (RFC 8484) lookups of A and AAAA records
+
+package resolver
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const (
+	dnsTypeA    = 1
+	dnsTypeAAAA = 28
+	dnsClassIN  = 1
+
+	maxDNSMessage = 64 << 10
+)
+
+var errShortMessage = errors.New("doh: truncated DNS message")
+
+type dohClient struct {
+	url    string
+	client *http.Client // nil for http.DefaultClient
+}
+
+// lookupHost asks for the A and AAAA records of host at once.
+func (c *dohClient) lookupHost(ctx context.Context, host string) ([]string, error) {
+	type result struct {
+		addrs []string
+		err   error
+	}
+	results := make(chan result, 2)
+	for _, qtype := range []uint16{dnsTypeA, dnsTypeAAAA} {
+		go func() {
+			addrs, err := c.query(ctx, host, qtype)
+			results <- result{addrs, err}
+		}()
+	}
+	var addrs []string
+	var firstErr error
+	for range 2 {
+		r := <-results
+		addrs = append(addrs, r.addrs...)
+		if r.err != nil && firstErr == nil {
+			firstErr = r.err
+		}
+	}
+	if len(addrs) > 0 {
+		return addrs, nil
+	}
+	return nil, firstErr
+}
+
+func (c *dohClient) query(ctx context.Context, host string, qtype uint16) ([]string, error) {
+	msg, err := dnsQuery(host, qtype)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(msg))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	client := c.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("doh: %s answered %s", c.url, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxDNSMessage))
+	if err != nil {
+		return nil, err
+	}
+	return dnsAnswers(body, host, qtype)
+}
+
+// dnsQuery encodes a recursive query for host's qtype records. Its ID is
+// 0, as RFC 8484 asks for cache friendliness.
+func dnsQuery(host string, qtype uint16) ([]byte, error) {
+	msg := []byte{0, 0, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0}
+	for _, label := range strings.Split(strings.TrimSuffix(host, "."), ".") {
+		if label == "" || len(label) > 63 {
+			return nil, fmt.Errorf("doh: invalid host name %q", host)
+		}
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0)
+	msg = binary.BigEndian.AppendUint16(msg, qtype)
+	return binary.BigEndian.AppendUint16(msg, dnsClassIN), nil
+}
+
+// dnsAnswers returns the addresses of the qtype records in response msg.
+func dnsAnswers(msg []byte, host string, qtype uint16) ([]string, error) {
+	if len(msg) < 12 {
+		return nil, errShortMessage
+	}
+	switch rcode := msg[3] & 0x0f; rcode {
+	case 0:
+	case 3:
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	default:
+		return nil, fmt.Errorf("doh: lookup of %s failed with rcode %d", host, rcode)
+	}
+	qdcount := binary.BigEndian.Uint16(msg[4:])
+	ancount := binary.BigEndian.Uint16(msg[6:])
+	off := 12
+	var err error
+	for range qdcount {
+		if off, err = skipName(msg, off); err != nil {
+			return nil, err
+		}
+		off += 4 // type and class
+	}
+	var addrs []string
+	for range ancount {
+		if off, err = skipName(msg, off); err != nil {
+			return nil, err
+		}
+		if off+10 > len(msg) {
+			return nil, errShortMessage
+		}
+		typ := binary.BigEndian.Uint16(msg[off:])
+		class := binary.BigEndian.Uint16(msg[off+2:])
+		rdlen := int(binary.BigEndian.Uint16(msg[off+8:]))
+		off += 10
+		if off+rdlen > len(msg) {
+			return nil, errShortMessage
+		}
+		rdata := msg[off : off+rdlen]
+		off += rdlen
+		// CNAMEs come before the records they lead to; those are kept.
+		if class != dnsClassIN || typ != qtype {
+			continue
+		}
+		if typ == dnsTypeA && rdlen == net.IPv4len || typ == dnsTypeAAAA && rdlen == net.IPv6len {
+			addrs = append(addrs, net.IP(rdata).String())
+		}
+	}
+	return addrs, nil
+}
+
+// skipName returns the offset just past the name at off, which may end in
+// a compression pointer.
+func skipName(msg []byte, off int) (int, error) {
+	for {
+		if off >= len(msg) {
+			return 0, errShortMessage
+		}
+		l := int(msg[off])
+		switch {
+		case l == 0:
+			return off + 1, nil
+		case l&0xc0 == 0xc0:
+			return off + 2, nil
+		default:
+			off += 1 + l
+		}
+	}
+}
@@ -0,0 +1,139 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Peer host name resolution: system, custom DNS servers, DoH or a hosts map
+
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+)
+
+const (
+	ModeSystem = "system"
+	ModeDNS    = "dns"
+	ModeDoH    = "doh"
+
+	lookupTimeout = 10 * time.Second
+)
+
+// Resolver resolves peer host names as config.ResolverConfig selects.
+type Resolver struct {
+	mode   string
+	hosts  map[string][]string // lower-case name -> addresses
+	lookup func(ctx context.Context, host string) ([]string, error)
+}
+
+// New builds the resolver c describes, checking its servers and URL.
+func New(c config.ResolverConfig) (*Resolver, error) {
+	r := &Resolver{mode: c.Mode, hosts: make(map[string][]string)}
+	for name, addrs := range c.Hosts {
+		for _, a := range addrs {
+			if net.ParseIP(a) == nil {
+				return nil, fmt.Errorf("resolver: hosts: %q of %s is not an IP address", a, name)
+			}
+		}
+		r.hosts[strings.ToLower(strings.TrimSuffix(name, "."))] = addrs
+	}
+	switch c.Mode {
+	case "", ModeSystem:
+		r.mode = ModeSystem
+		r.lookup = net.DefaultResolver.LookupHost
+	case ModeDNS:
+		if len(c.Servers) == 0 {
+			return nil, errors.New("resolver: dns mode needs servers")
+		}
+		servers := make([]string, len(c.Servers))
+		for i, s := range c.Servers {
+			if _, _, err := net.SplitHostPort(s); err != nil {
+				s = net.JoinHostPort(s, "53")
+			}
+			servers[i] = s
+		}
+		r.lookup = dnsServers(servers)
+	case ModeDoH:
+		u, err := url.Parse(c.DoHURL)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return nil, fmt.Errorf("resolver: doh_url %q is not an https URL", c.DoHURL)
+		}
+		r.lookup = (&dohClient{url: c.DoHURL}).lookupHost
+	default:
+		return nil, fmt.Errorf("resolver: unknown mode %q, want system, dns or doh", c.Mode)
+	}
+	return r, nil
+}
+
+// Mode is the resolver's mode, system, dns or doh.
+func (r *Resolver) Mode() string {
+	return r.mode
+}
+
+// LookupHost returns the addresses of host: itself if it is one, those of
+// the hosts map if it lists host, and else those the resolver finds.
+func (r *Resolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if net.ParseIP(host) != nil {
+		return []string{host}, nil
+	}
+	if addrs, ok := r.hosts[strings.ToLower(strings.TrimSuffix(host, "."))]; ok {
+		return addrs, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, lookupTimeout)
+	defer cancel()
+	addrs, err := r.lookup(ctx, host)
+	if err == nil && len(addrs) == 0 {
+		err = &net.DNSError{Err: "no addresses", Name: host, IsNotFound: true}
+	}
+	return addrs, err
+}
+
+// DialContext dials addr, a host:port, with d, trying each address of the
+// host in turn. The system resolver is left to d itself for names the
+// hosts map does not list.
+func (r *Resolver) DialContext(ctx context.Context, d *net.Dialer, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if _, listed := r.hosts[strings.ToLower(host)]; r.mode == ModeSystem && !listed {
+		return d.DialContext(ctx, network, addr)
+	}
+	addrs, err := r.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var firstErr error
+	for _, a := range addrs {
+		conn, err := d.DialContext(ctx, network, net.JoinHostPort(a, port))
+		if err == nil {
+			return conn, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return nil, firstErr
+}
+
+// dnsServers looks hosts up with the DNS servers given instead of those of
+// the system. Each query goes to the next server in turn, so a retry after
+// a timeout asks another.
+func dnsServers(servers []string) func(ctx context.Context, host string) ([]string, error) {
+	var next atomic.Uint32
+	res := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			s := servers[int(next.Add(1)-1)%len(servers)]
+			var d net.Dialer
+			return d.DialContext(ctx, network, s)
+		},
+	}
+	return res.LookupHost
+}
@@ -0,0 +1,81 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for peer host name resolution
+
+package resolver
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+)
+
+func TestHostsMap(t *testing.T) {
+	r, err := New(config.ResolverConfig{Mode: ModeSystem, Hosts: map[string][]string{"Hub.Example.net": {"10.0.0.1"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addrs, err := r.LookupHost(context.Background(), "hub.example.net."); err != nil || !slices.Equal(addrs, []string{"10.0.0.1"}) {
+		t.Errorf("Expected the hosts map answer, got %v, %v", addrs, err)
+	}
+	if addrs, _ := r.LookupHost(context.Background(), "192.0.2.7"); !slices.Equal(addrs, []string{"192.0.2.7"}) {
+		t.Errorf("Expected an address to resolve to itself, got %v", addrs)
+	}
+
+	for _, c := range []config.ResolverConfig{
+		{Mode: "carrier-pigeon"},
+		{Mode: ModeDNS},
+		{Mode: ModeDoH, DoHURL: "http://dns.example.net/dns-query"},
+		{Hosts: map[string][]string{"hub": {"not an address"}}},
+	} {
+		if _, err := New(c); err == nil {
+			t.Errorf("Expected %+v to be refused", c)
+		}
+	}
+}
+
+func TestDoH(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/dns-message" {
+			http.Error(w, "bad content type", http.StatusUnsupportedMediaType)
+			return
+		}
+		q, _ := io.ReadAll(r.Body)
+		qtype := binary.BigEndian.Uint16(q[len(q)-4:])
+		resp := slices.Clone(q)
+		resp[2], resp[3] = 0x81, 0x80
+		if qtype != dnsTypeA {
+			w.Write(resp)
+			return
+		}
+		resp[7] = 2
+		// A CNAME, then the address it leads to.
+		resp = append(resp, 0xc0, 12, 0, 5, 0, 1, 0, 0, 0, 60, 0, 2, 0xc0, 12)
+		resp = append(resp, 0xc0, 12, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4, 203, 0, 113, 9)
+		w.Write(resp)
+	}))
+	defer ts.Close()
+
+	c := &dohClient{url: ts.URL, client: ts.Client()}
+	addrs, err := c.lookupHost(context.Background(), "hub.example.net")
+	if err != nil || !slices.Equal(addrs, []string{"203.0.113.9"}) {
+		t.Errorf("Expected the A record, got %v, %v", addrs, err)
+	}
+
+	nx := []byte{0, 0, 0x81, 0x83, 0, 0, 0, 0, 0, 0, 0, 0}
+	var dnsErr *net.DNSError
+	if _, err := dnsAnswers(nx, "gone.example.net", dnsTypeA); err == nil || !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+		t.Errorf("Expected NXDOMAIN to be not found, got %v", err)
+	}
+	if _, err := dnsAnswers([]byte{0, 0, 0x81, 0x80, 0, 1, 0, 1, 0, 0, 0, 0, 5, 'h'}, "h", dnsTypeA); err == nil {
+		t.Error("Expected a truncated message to fail")
+	}
+}
@@ -0,0 +1,196 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Virtual LAN rooms: invite tokens and a tracker of public rooms
+
+package rooms
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const (
+	tokenPrefix = "ipxroom:"
+	maxNameLen  = 32
+	maxPeers    = 8
+
+	// ListingTTL is how long a tracker keeps a room that stops announcing.
+	ListingTTL = 3 * time.Minute
+)
+
+// Room is a named, isolated virtual IPX segment. Every member links with a
+// network key derived from the room's secret, so nodes outside the room
+// cannot join the mesh.
+type Room struct {
+	Name   string   `json:"name"`
+	Key    string   `json:"key"`
+	Peers  []string `json:"peers,omitempty"` // members to dial
+	Public bool     `json:"public,omitempty"`
+}
+
+// New creates a room with a random secret.
+func New(name string, public bool) (Room, error) {
+	r := Room{Name: strings.TrimSpace(name), Public: public}
+	if err := validateName(r.Name); err != nil {
+		return Room{}, err
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return Room{}, err
+	}
+	r.Key = hex.EncodeToString(b)
+	return r, nil
+}
+
+func validateName(name string) error {
+	if name == "" || len(name) > maxNameLen {
+		return fmt.Errorf("room name must be 1-%d characters", maxNameLen)
+	}
+	for _, c := range name {
+		if unicode.IsControl(c) {
+			return fmt.Errorf("room name contains control characters")
+		}
+	}
+	return nil
+}
+
+// NetworkKey is the peer link key members authenticate with.
+func (r Room) NetworkKey() string {
+	sum := sha256.Sum256([]byte("ipxroom\x00" + r.Name + "\x00" + r.Key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Token encodes an invite to the room that connects to addrs.
+func (r Room) Token(addrs []string) string {
+	inv := Room{Name: r.Name, Key: r.Key, Peers: addrs, Public: r.Public}
+	if len(inv.Peers) > maxPeers {
+		inv.Peers = inv.Peers[:maxPeers]
+	}
+	b, _ := json.Marshal(inv)
+	return tokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+}
+
+// ParseToken decodes an invite token.
+func ParseToken(token string) (Room, error) {
+	data, ok := strings.CutPrefix(strings.TrimSpace(token), tokenPrefix)
+	if !ok {
+		return Room{}, fmt.Errorf("not a room invite token")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(data)
+	if err != nil {
+		return Room{}, fmt.Errorf("invalid room invite token: %w", err)
+	}
+	var r Room
+	if err := json.Unmarshal(b, &r); err != nil {
+		return Room{}, fmt.Errorf("invalid room invite token: %w", err)
+	}
+	if err := validateName(r.Name); err != nil {
+		return Room{}, err
+	}
+	if r.Key == "" {
+		return Room{}, fmt.Errorf("room invite token has no key")
+	}
+	return r, nil
+}
+
+// Listing is a public room as announced to a tracker.
+type Listing struct {
+	Name     string    `json:"name"`
+	Token    string    `json:"token"`
+	Members  int       `json:"members"`
+	Packets  uint64    `json:"packets"` // relayed by the announcing member
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// Tracker lists the public rooms its announcers are in. Each member
+// announces with its own invite token; their addresses are merged so that
+// joiners can reach whichever members are up.
+type Tracker struct {
+	mu       sync.Mutex
+	listings map[string]*trackedRoom // by network key
+}
+
+type trackedRoom struct {
+	room    Room
+	members int
+	packets uint64
+	seen    map[string]time.Time // peer address -> last announcement
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{listings: make(map[string]*trackedRoom)}
+}
+
+func (t *Tracker) Announce(l Listing, now time.Time) error {
+	r, err := ParseToken(l.Token)
+	if err != nil {
+		return err
+	}
+	if !r.Public {
+		return fmt.Errorf("room %q is not public", r.Name)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tr, ok := t.listings[r.NetworkKey()]
+	if !ok {
+		tr = &trackedRoom{room: Room{Name: r.Name, Key: r.Key, Public: true}, seen: make(map[string]time.Time)}
+		t.listings[r.NetworkKey()] = tr
+	}
+	for _, p := range r.Peers {
+		tr.seen[p] = now
+	}
+	tr.members = max(l.Members, 1)
+	tr.packets = l.Packets
+	return nil
+}
+
+// List returns the rooms announced within ListingTTL, busiest first.
+func (t *Tracker) List(now time.Time) []Listing {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var out []Listing
+	for k, tr := range t.listings {
+		var addrs []string
+		var last time.Time
+		for p, seen := range tr.seen {
+			if now.Sub(seen) > ListingTTL {
+				delete(tr.seen, p)
+				continue
+			}
+			addrs = append(addrs, p)
+			if seen.After(last) {
+				last = seen
+			}
+		}
+		if len(addrs) == 0 {
+			delete(t.listings, k)
+			continue
+		}
+		sort.Strings(addrs)
+		out = append(out, Listing{
+			Name:     tr.room.Name,
+			Token:    tr.room.Token(addrs),
+			Members:  tr.members,
+			Packets:  tr.packets,
+			LastSeen: last,
+		})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Members != out[j].Members {
+			return out[i].Members > out[j].Members
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
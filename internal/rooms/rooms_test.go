@@ -0,0 +1,76 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for room invite tokens and the room tracker
+
+package rooms
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRoomToken(t *testing.T) {
+	r, err := New("Friday Doom", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ParseToken(r.Token([]string{"198.51.100.4:8787"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != r.Name || got.Key != r.Key || !got.Public || len(got.Peers) != 1 {
+		t.Errorf("Expected the token to round-trip, got %+v", got)
+	}
+	if got.NetworkKey() != r.NetworkKey() {
+		t.Error("Expected members to derive the same network key")
+	}
+
+	other, _ := New("Friday Doom", true)
+	if other.NetworkKey() == r.NetworkKey() {
+		t.Error("Expected rooms with the same name to be isolated by their keys")
+	}
+
+	for _, bad := range []string{"", "ipxroom:!!", "ipxroom:e30", "not-a-token"} {
+		if _, err := ParseToken(bad); err == nil {
+			t.Errorf("Expected %q to be rejected", bad)
+		}
+	}
+	if _, err := New("", false); err == nil {
+		t.Error("Expected an empty room name to be rejected")
+	}
+}
+
+func TestTracker(t *testing.T) {
+	tr := NewTracker()
+	r, _ := New("Warcraft II", true)
+	now := time.Now()
+
+	if err := tr.Announce(Listing{Token: r.Token([]string{"10.0.0.1:8787"}), Members: 2}, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Announce(Listing{Token: r.Token([]string{"10.0.0.2:8787"}), Members: 3}, now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	private, _ := New("Secret", false)
+	if err := tr.Announce(Listing{Token: private.Token(nil)}, now); err == nil {
+		t.Error("Expected private rooms to be refused")
+	}
+
+	list := tr.List(now.Add(time.Minute))
+	if len(list) != 1 || list[0].Members != 3 {
+		t.Fatalf("Expected one room with 3 members, got %+v", list)
+	}
+	joined, _ := ParseToken(list[0].Token)
+	if len(joined.Peers) != 2 || joined.NetworkKey() != r.NetworkKey() {
+		t.Errorf("Expected both members' addresses in the listed token, got %+v", joined)
+	}
+
+	// The first member stops announcing and ages out, then the second.
+	list = tr.List(now.Add(ListingTTL + 30*time.Second))
+	if joined, _ := ParseToken(list[0].Token); len(joined.Peers) != 1 || joined.Peers[0] != "10.0.0.2:8787" {
+		t.Errorf("Expected only the live member's address, got %+v", joined.Peers)
+	}
+	if list := tr.List(now.Add(ListingTTL + 2*time.Minute)); len(list) != 0 {
+		t.Errorf("Expected the room to expire, got %+v", list)
+	}
+}
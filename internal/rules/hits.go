@@ -0,0 +1,90 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Per-rule hit counters with a recent-rate series
+
+package rules
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	HitBucketWidth = 10 * time.Second
+	HitBuckets     = 60 // 10 minutes of history
+)
+
+// HitSnapshot is a point-in-time view of one rule's hit counter.
+type HitSnapshot struct {
+	Total   uint64
+	LastHit time.Time
+	Series  []uint64 // hits per HitBucketWidth, oldest first
+}
+
+type hitCounter struct {
+	total   uint64
+	lastHit time.Time
+	buckets [HitBuckets]uint64
+	head    int64 // bucket index (unix time / width) of the newest bucket
+}
+
+// advance rotates the ring so that the newest bucket covers now.
+func (c *hitCounter) advance(now time.Time) {
+	idx := now.UnixNano() / int64(HitBucketWidth)
+	if idx <= c.head {
+		return
+	}
+	for i := c.head + 1; i <= idx && i <= c.head+HitBuckets; i++ {
+		c.buckets[i%HitBuckets] = 0
+	}
+	c.head = idx
+}
+
+// HitTable tracks hit counters keyed by rule ID.
+type HitTable struct {
+	mu       sync.Mutex
+	counters map[string]*hitCounter
+}
+
+func NewHitTable() *HitTable {
+	return &HitTable{counters: make(map[string]*hitCounter)}
+}
+
+func (t *HitTable) Hit(id string) {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c, ok := t.counters[id]
+	if !ok {
+		c = &hitCounter{}
+		t.counters[id] = c
+	}
+	c.advance(now)
+	c.total++
+	c.lastHit = now
+	c.buckets[c.head%HitBuckets]++
+}
+
+func (t *HitTable) Snapshot(id string) HitSnapshot {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	snap := HitSnapshot{Series: make([]uint64, HitBuckets)}
+	c, ok := t.counters[id]
+	if !ok {
+		return snap
+	}
+	c.advance(now)
+	snap.Total = c.total
+	snap.LastHit = c.lastHit
+	for i := range HitBuckets {
+		snap.Series[i] = c.buckets[(c.head+1+int64(i))%HitBuckets]
+	}
+	return snap
+}
+
+func (t *HitTable) Remove(id string) {
+	t.mu.Lock()
+	delete(t.counters, id)
+	t.mu.Unlock()
+}
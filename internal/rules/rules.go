@@ -0,0 +1,308 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Socket filter and priority rule engine
+
+package rules
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	"github.com/mlapointe/ipxtransporter/internal/ipx"
+)
+
+const (
+	KindFilter   = "filter"
+	KindPriority = "priority"
+
+	MaxPriority = 7
+)
+
+// Rule matches IPX frames by socket. Filter rules carry an Action, priority
+// rules a Priority; rules are evaluated in order and the first match wins.
+type Rule struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Socket    uint16 `json:"socket"`             // 0 matches any socket
+	Direction string `json:"direction"`          // "src", "dst" or "any"
+	Action    string `json:"action,omitempty"`   // filter rules: "allow" or "drop"
+	Priority  int    `json:"priority,omitempty"` // priority rules: 0-7, above 0 is expedited
+}
+
+func (r Rule) Matches(h *ipx.Header) bool {
+	if r.Socket == 0 {
+		return true
+	}
+	switch r.Direction {
+	case "src":
+		return h.Src.Socket == r.Socket
+	case "dst":
+		return h.Dst.Socket == r.Socket
+	default:
+		return h.Src.Socket == r.Socket || h.Dst.Socket == r.Socket
+	}
+}
+
+// Validate normalizes r and checks that it is well-formed for the given kind.
+func Validate(kind string, r *Rule) error {
+	switch r.Direction {
+	case "":
+		r.Direction = "any"
+	case "src", "dst", "any":
+	default:
+		return fmt.Errorf("invalid direction %q (want src, dst or any)", r.Direction)
+	}
+
+	switch kind {
+	case KindFilter:
+		if r.Action != "allow" && r.Action != "drop" {
+			return fmt.Errorf("invalid action %q (want allow or drop)", r.Action)
+		}
+		r.Priority = 0
+	case KindPriority:
+		if r.Priority < 0 || r.Priority > MaxPriority {
+			return fmt.Errorf("invalid priority %d (want 0-%d)", r.Priority, MaxPriority)
+		}
+		r.Action = ""
+	default:
+		return fmt.Errorf("unknown rule kind %q", kind)
+	}
+	return nil
+}
+
+// CountMatches reports how many of the given frames r would match.
+func CountMatches(r Rule, frames [][]byte) int {
+	n := 0
+	for _, f := range frames {
+		h, err := ipx.Parse(f)
+		if err != nil {
+			continue
+		}
+		if r.Matches(h) {
+			n++
+		}
+	}
+	return n
+}
+
+type Engine struct {
+	mu         sync.RWMutex
+	saveMu     sync.Mutex // runs onChange one call at a time
+	filters    []Rule
+	priorities []Rule
+	hits       *HitTable
+	onChange   func(filters, priorities []Rule)
+}
+
+func NewEngine(filters, priorities []Rule) *Engine {
+	e := &Engine{hits: NewHitTable()}
+	for _, r := range filters {
+		if err := Validate(KindFilter, &r); err == nil {
+			e.filters = append(e.filters, withID(r))
+		}
+	}
+	for _, r := range priorities {
+		if err := Validate(KindPriority, &r); err == nil {
+			e.priorities = append(e.priorities, withID(r))
+		}
+	}
+	return e
+}
+
+// SetOnChange registers a callback invoked after every modification.
+func (e *Engine) SetOnChange(fn func(filters, priorities []Rule)) {
+	e.mu.Lock()
+	e.onChange = fn
+	e.mu.Unlock()
+}
+
+// Classify reports whether a frame passes the filter rules and the priority
+// assigned to it. Frames that are not IPX are allowed at priority 0.
+func (e *Engine) Classify(frame []byte) (allow bool, priority int) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if len(e.filters) == 0 && len(e.priorities) == 0 {
+		return true, 0
+	}
+	h, err := ipx.Parse(frame)
+	if err != nil {
+		return true, 0
+	}
+
+	allow = true
+	for _, r := range e.filters {
+		if r.Matches(h) {
+			allow = r.Action == "allow"
+			e.hits.Hit(r.ID)
+			break
+		}
+	}
+	for _, r := range e.priorities {
+		if r.Matches(h) {
+			priority = r.Priority
+			e.hits.Hit(r.ID)
+			break
+		}
+	}
+	return allow, priority
+}
+
+// Hits returns the hit counter for the rule with the given ID.
+func (e *Engine) Hits(id string) HitSnapshot {
+	return e.hits.Snapshot(id)
+}
+
+func (e *Engine) List(kind string) ([]Rule, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	list, err := e.list(kind)
+	if err != nil {
+		return nil, err
+	}
+	return append([]Rule(nil), *list...), nil
+}
+
+func (e *Engine) Add(kind string, r Rule) (Rule, error) {
+	if err := Validate(kind, &r); err != nil {
+		return Rule{}, err
+	}
+	r.ID = ""
+	r = withID(r)
+
+	e.mu.Lock()
+	list, err := e.list(kind)
+	if err != nil {
+		e.mu.Unlock()
+		return Rule{}, err
+	}
+	*list = append(*list, r)
+	e.mu.Unlock()
+
+	e.changed()
+	return r, nil
+}
+
+func (e *Engine) Update(kind string, r Rule) error {
+	if err := Validate(kind, &r); err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	list, err := e.list(kind)
+	if err != nil {
+		e.mu.Unlock()
+		return err
+	}
+	i := indexOf(*list, r.ID)
+	if i < 0 {
+		e.mu.Unlock()
+		return fmt.Errorf("rule %q not found", r.ID)
+	}
+	(*list)[i] = r
+	e.mu.Unlock()
+
+	e.changed()
+	return nil
+}
+
+func (e *Engine) Delete(kind, id string) error {
+	e.mu.Lock()
+	list, err := e.list(kind)
+	if err != nil {
+		e.mu.Unlock()
+		return err
+	}
+	i := indexOf(*list, id)
+	if i < 0 {
+		e.mu.Unlock()
+		return fmt.Errorf("rule %q not found", id)
+	}
+	*list = append((*list)[:i], (*list)[i+1:]...)
+	e.mu.Unlock()
+
+	e.hits.Remove(id)
+	e.changed()
+	return nil
+}
+
+// Replace swaps both rule lists for the given ones, keeping their IDs.
+// Rules that do not validate are dropped, as when the engine is created.
+func (e *Engine) Replace(filters, priorities []Rule) {
+	next := NewEngine(filters, priorities)
+	e.mu.Lock()
+	e.filters, e.priorities = next.filters, next.priorities
+	e.mu.Unlock()
+
+	e.changed()
+}
+
+// Move repositions a rule; index is clamped to the bounds of the list.
+func (e *Engine) Move(kind, id string, index int) error {
+	e.mu.Lock()
+	list, err := e.list(kind)
+	if err != nil {
+		e.mu.Unlock()
+		return err
+	}
+	i := indexOf(*list, id)
+	if i < 0 {
+		e.mu.Unlock()
+		return fmt.Errorf("rule %q not found", id)
+	}
+	index = max(0, min(index, len(*list)-1))
+	r := (*list)[i]
+	*list = append((*list)[:i], (*list)[i+1:]...)
+	*list = append((*list)[:index], append([]Rule{r}, (*list)[index:]...)...)
+	e.mu.Unlock()
+
+	e.changed()
+	return nil
+}
+
+func (e *Engine) list(kind string) (*[]Rule, error) {
+	switch kind {
+	case KindFilter:
+		return &e.filters, nil
+	case KindPriority:
+		return &e.priorities, nil
+	}
+	return nil, fmt.Errorf("unknown rule kind %q", kind)
+}
+
+// changed runs onChange with the rules. The calls run one at a time, each
+// with the rules as they are when it starts, so concurrent edits cannot
+// have an older list saved over a newer one.
+func (e *Engine) changed() {
+	e.saveMu.Lock()
+	defer e.saveMu.Unlock()
+	e.mu.RLock()
+	fn := e.onChange
+	filters := append([]Rule(nil), e.filters...)
+	priorities := append([]Rule(nil), e.priorities...)
+	e.mu.RUnlock()
+
+	if fn != nil {
+		fn(filters, priorities)
+	}
+}
+
+func indexOf(list []Rule, id string) int {
+	for i, r := range list {
+		if r.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func withID(r Rule) Rule {
+	if r.ID == "" {
+		b := make([]byte, 4)
+		_, _ = rand.Read(b)
+		r.ID = hex.EncodeToString(b)
+	}
+	return r
+}
@@ -0,0 +1,144 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for the rule engine
+
+package rules
+
+import (
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func ipxFrame(dstSock, srcSock uint16) []byte {
+	frame := make([]byte, 44)
+	binary.BigEndian.PutUint16(frame[12:14], 0x8137)
+	binary.BigEndian.PutUint16(frame[30:32], dstSock)
+	binary.BigEndian.PutUint16(frame[42:44], srcSock)
+	return frame
+}
+
+func TestEngineClassify(t *testing.T) {
+	e := NewEngine(
+		[]Rule{{Socket: 0x869c, Direction: "dst", Action: "drop"}},
+		[]Rule{{Socket: 0x4000, Priority: 5}},
+	)
+
+	if allow, _ := e.Classify(ipxFrame(0x869c, 0x4001)); allow {
+		t.Error("Expected frame to dst socket 0x869c to be dropped")
+	}
+	if allow, _ := e.Classify(ipxFrame(0x4001, 0x869c)); !allow {
+		t.Error("Expected frame from src socket 0x869c to be allowed")
+	}
+	if _, prio := e.Classify(ipxFrame(0x0452, 0x4000)); prio != 5 {
+		t.Errorf("Expected priority 5, got %d", prio)
+	}
+	if allow, prio := e.Classify([]byte("not ipx")); !allow || prio != 0 {
+		t.Error("Expected non-IPX frame to pass at priority 0")
+	}
+}
+
+func TestEngineCRUD(t *testing.T) {
+	e := NewEngine(nil, nil)
+	changes := 0
+	e.SetOnChange(func(filters, priorities []Rule) { changes++ })
+
+	if _, err := e.Add(KindFilter, Rule{Socket: 1, Action: "bogus"}); err == nil {
+		t.Error("Expected validation error for bad action")
+	}
+
+	a, err := e.Add(KindFilter, Rule{Name: "a", Socket: 1, Action: "drop"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := e.Add(KindFilter, Rule{Name: "b", Socket: 2, Action: "allow"})
+
+	if err := e.Move(KindFilter, b.ID, 0); err != nil {
+		t.Fatal(err)
+	}
+	list, _ := e.List(KindFilter)
+	if len(list) != 2 || list[0].ID != b.ID {
+		t.Fatalf("Expected %s first after move, got %+v", b.ID, list)
+	}
+
+	a.Action = "allow"
+	if err := e.Update(KindFilter, a); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Delete(KindFilter, b.ID); err != nil {
+		t.Fatal(err)
+	}
+	list, _ = e.List(KindFilter)
+	if len(list) != 1 || list[0].Action != "allow" {
+		t.Errorf("Unexpected rules after update/delete: %+v", list)
+	}
+	if changes != 5 {
+		t.Errorf("Expected 5 change notifications, got %d", changes)
+	}
+}
+
+func TestEngineConcurrentEdits(t *testing.T) {
+	e := NewEngine(nil, nil)
+	var saved []Rule
+	var running atomic.Int32
+	e.SetOnChange(func(filters, priorities []Rule) {
+		if running.Add(1) > 1 {
+			t.Error("Expected change notifications one at a time")
+		}
+		// A slow save lets later edits pile up behind this one.
+		time.Sleep(time.Millisecond)
+		saved = filters
+		running.Add(-1)
+	})
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Go(func() {
+			if _, err := e.Add(KindFilter, Rule{Socket: uint16(i + 1), Action: "drop"}); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+	wg.Wait()
+	if len(saved) != 20 {
+		t.Errorf("Expected the last save to hold all 20 rules, got %d", len(saved))
+	}
+}
+
+func TestCountMatches(t *testing.T) {
+	frames := [][]byte{ipxFrame(0x869c, 1), ipxFrame(2, 0x869c), ipxFrame(3, 4)}
+	if n := CountMatches(Rule{Socket: 0x869c, Direction: "any"}, frames); n != 2 {
+		t.Errorf("Expected 2 matches, got %d", n)
+	}
+	if n := CountMatches(Rule{Socket: 0x869c, Direction: "src"}, frames); n != 1 {
+		t.Errorf("Expected 1 match, got %d", n)
+	}
+}
+
+func TestEngineHits(t *testing.T) {
+	e := NewEngine([]Rule{{ID: "drop-doom", Socket: 0x869c, Action: "drop"}}, nil)
+
+	e.Classify(ipxFrame(0x869c, 1))
+	e.Classify(ipxFrame(0x869c, 2))
+	e.Classify(ipxFrame(3, 4))
+
+	snap := e.Hits("drop-doom")
+	if snap.Total != 2 {
+		t.Errorf("Expected 2 hits, got %d", snap.Total)
+	}
+	if len(snap.Series) != HitBuckets || snap.Series[HitBuckets-1] != 2 {
+		t.Errorf("Expected 2 hits in newest bucket, got %v", snap.Series)
+	}
+	if snap.LastHit.IsZero() {
+		t.Error("Expected LastHit to be set")
+	}
+
+	if err := e.Delete(KindFilter, "drop-doom"); err != nil {
+		t.Fatal(err)
+	}
+	if snap := e.Hits("drop-doom"); snap.Total != 0 {
+		t.Errorf("Expected counter to be removed with the rule, got %d", snap.Total)
+	}
+}
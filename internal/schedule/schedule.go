@@ -0,0 +1,351 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Scheduled bans and time-boxed peer access windows
+
+package schedule
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ActionBan   = "ban"
+	ActionAllow = "allow"
+)
+
+var dayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// Entry bans a peer or limits it to a time window. A ban blocks the target
+// while active; a target with allow entries may only connect while one of
+// them is active. Entries with Until set are removed once it passes.
+type Entry struct {
+	ID     string    `json:"id"`
+	Action string    `json:"action"`          // "ban" or "allow"
+	Target string    `json:"target"`          // peer ID, host/IP or peer address
+	Days   []string  `json:"days,omitempty"`  // sun..sat, empty means every day
+	Start  string    `json:"start,omitempty"` // HH:MM local time, empty means 00:00
+	End    string    `json:"end,omitempty"`   // HH:MM, empty means midnight; may wrap past it
+	Until  time.Time `json:"until,omitzero"`
+	Reason string    `json:"reason,omitempty"` // why it was added, for automatic bans
+}
+
+// Validate normalizes e and checks that it is well-formed.
+func Validate(e *Entry) error {
+	if e.Action != ActionBan && e.Action != ActionAllow {
+		return fmt.Errorf("invalid action %q (want ban or allow)", e.Action)
+	}
+	e.Target = strings.TrimSpace(e.Target)
+	if e.Target == "" {
+		return fmt.Errorf("target is required")
+	}
+	for i, d := range e.Days {
+		d = strings.ToLower(strings.TrimSpace(d))
+		if len(d) > 3 {
+			d = d[:3]
+		}
+		if dayIndex(d) < 0 {
+			return fmt.Errorf("invalid day %q (want sun, mon, ... sat)", e.Days[i])
+		}
+		e.Days[i] = d
+	}
+	if _, err := parseClock(e.Start, 0); err != nil {
+		return err
+	}
+	if _, err := parseClock(e.End, 24*60); err != nil {
+		return err
+	}
+	return nil
+}
+
+func dayIndex(d string) int {
+	for i, n := range dayNames {
+		if n == d {
+			return i
+		}
+	}
+	return -1
+}
+
+// parseClock returns minutes past midnight for an HH:MM string.
+func parseClock(s string, empty int) (int, error) {
+	if s == "" {
+		return empty, nil
+	}
+	var h, m int
+	if _, err := fmt.Sscanf(s, "%d:%d", &h, &m); err != nil || h < 0 || h > 24 || m < 0 || m > 59 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("invalid time %q (want HH:MM)", s)
+	}
+	return h*60 + m, nil
+}
+
+func (e Entry) onDay(d time.Weekday) bool {
+	if len(e.Days) == 0 {
+		return true
+	}
+	for _, n := range e.Days {
+		if dayIndex(n) == int(d) {
+			return true
+		}
+	}
+	return false
+}
+
+// Expired reports whether the entry has run out at t.
+func (e Entry) Expired(t time.Time) bool {
+	return !e.Until.IsZero() && !t.Before(e.Until)
+}
+
+// Active reports whether the entry applies at t.
+func (e Entry) Active(t time.Time) bool {
+	if e.Expired(t) {
+		return false
+	}
+	start, _ := parseClock(e.Start, 0)
+	end, _ := parseClock(e.End, 24*60)
+	now := t.Hour()*60 + t.Minute()
+	switch {
+	case start == end:
+		return e.onDay(t.Weekday())
+	case start < end:
+		return e.onDay(t.Weekday()) && now >= start && now < end
+	default:
+		// The window wraps past midnight into the next day.
+		yesterday := (t.Weekday() + 6) % 7
+		return (e.onDay(t.Weekday()) && now >= start) || (e.onDay(yesterday) && now < end)
+	}
+}
+
+// Next returns the first time after t at which Active changes, or the zero
+// time if it never does.
+func (e Entry) Next(t time.Time) time.Time {
+	start, _ := parseClock(e.Start, 0)
+	end, _ := parseClock(e.End, 24*60)
+	var candidates []time.Time
+	if !e.Until.IsZero() {
+		candidates = append(candidates, e.Until)
+	}
+	y, m, d := t.Date()
+	for i := 0; i <= 8; i++ {
+		day := time.Date(y, m, d+i, 0, 0, 0, 0, t.Location())
+		candidates = append(candidates,
+			day,
+			day.Add(time.Duration(start)*time.Minute),
+			day.Add(time.Duration(end)*time.Minute))
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Before(candidates[j]) })
+
+	now := e.Active(t)
+	for _, c := range candidates {
+		if c.After(t) && e.Active(c) != now {
+			return c
+		}
+	}
+	return time.Time{}
+}
+
+// Describe summarises when the entry applies, e.g. "sat,sun 18:00-02:00".
+func (e Entry) Describe() string {
+	var parts []string
+	if len(e.Days) > 0 {
+		parts = append(parts, strings.Join(e.Days, ","))
+	}
+	if e.Start != "" || e.End != "" {
+		start, end := e.Start, e.End
+		if start == "" {
+			start = "00:00"
+		}
+		if end == "" {
+			end = "24:00"
+		}
+		parts = append(parts, start+"-"+end)
+	}
+	if !e.Until.IsZero() {
+		parts = append(parts, "until "+e.Until.Format("2006-01-02 15:04"))
+	}
+	if len(parts) == 0 {
+		return "always"
+	}
+	return strings.Join(parts, " ")
+}
+
+type Scheduler struct {
+	mu       sync.RWMutex
+	saveMu   sync.Mutex // runs onChange one call at a time
+	entries  []Entry
+	onChange func(entries []Entry)
+}
+
+func NewScheduler(entries []Entry) *Scheduler {
+	s := &Scheduler{}
+	for _, e := range entries {
+		if err := Validate(&e); err == nil {
+			s.entries = append(s.entries, withID(e))
+		}
+	}
+	return s
+}
+
+// SetOnChange registers a callback invoked after every modification.
+func (s *Scheduler) SetOnChange(fn func(entries []Entry)) {
+	s.mu.Lock()
+	s.onChange = fn
+	s.mu.Unlock()
+}
+
+func (s *Scheduler) List() []Entry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]Entry(nil), s.entries...)
+}
+
+func (s *Scheduler) Add(e Entry) (Entry, error) {
+	if err := Validate(&e); err != nil {
+		return Entry{}, err
+	}
+	e.ID = ""
+	e = withID(e)
+
+	s.mu.Lock()
+	s.entries = append(s.entries, e)
+	s.mu.Unlock()
+
+	s.changed()
+	return e, nil
+}
+
+func (s *Scheduler) Delete(id string) error {
+	s.mu.Lock()
+	i := indexOf(s.entries, id)
+	if i < 0 {
+		s.mu.Unlock()
+		return fmt.Errorf("schedule entry %q not found", id)
+	}
+	s.entries = append(s.entries[:i], s.entries[i+1:]...)
+	s.mu.Unlock()
+
+	s.changed()
+	return nil
+}
+
+// Replace swaps all entries for the given ones, keeping their IDs. Entries
+// that do not validate are dropped, as when the scheduler is created.
+func (s *Scheduler) Replace(entries []Entry) {
+	next := NewScheduler(entries)
+	s.mu.Lock()
+	s.entries = next.entries
+	s.mu.Unlock()
+
+	s.changed()
+}
+
+// Blocked reports whether a peer known by any of the given targets may not
+// be connected at t, and the entry responsible.
+func (s *Scheduler) Blocked(t time.Time, targets ...string) (bool, Entry) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var window *Entry
+	for i, e := range s.entries {
+		if !matches(e.Target, targets) || e.Expired(t) {
+			continue
+		}
+		switch e.Action {
+		case ActionBan:
+			if e.Active(t) {
+				return true, e
+			}
+		case ActionAllow:
+			if e.Active(t) {
+				return false, Entry{}
+			}
+			window = &s.entries[i]
+		}
+	}
+	if window != nil {
+		return true, *window
+	}
+	return false, Entry{}
+}
+
+// Expire removes entries whose Until has passed and returns them.
+func (s *Scheduler) Expire(t time.Time) []Entry {
+	s.mu.Lock()
+	var expired, kept []Entry
+	for _, e := range s.entries {
+		if e.Expired(t) {
+			expired = append(expired, e)
+		} else {
+			kept = append(kept, e)
+		}
+	}
+	s.entries = kept
+	s.mu.Unlock()
+
+	if len(expired) > 0 {
+		s.changed()
+	}
+	return expired
+}
+
+// NextTransition returns the earliest time after t at which any entry
+// changes state, or the zero time if none will.
+func (s *Scheduler) NextTransition(t time.Time) time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var next time.Time
+	for _, e := range s.entries {
+		if n := e.Next(t); !n.IsZero() && (next.IsZero() || n.Before(next)) {
+			next = n
+		}
+	}
+	return next
+}
+
+// changed runs onChange with the entries. The calls run one at a time,
+// each with the entries as they are when it starts, so concurrent edits
+// cannot have an older list saved over a newer one.
+func (s *Scheduler) changed() {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	s.mu.RLock()
+	fn := s.onChange
+	entries := append([]Entry(nil), s.entries...)
+	s.mu.RUnlock()
+
+	if fn != nil {
+		fn(entries)
+	}
+}
+
+func matches(target string, targets []string) bool {
+	for _, t := range targets {
+		if t != "" && t == target {
+			return true
+		}
+	}
+	return false
+}
+
+func indexOf(list []Entry, id string) int {
+	for i, e := range list {
+		if e.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func withID(e Entry) Entry {
+	if e.ID == "" {
+		b := make([]byte, 4)
+		_, _ = rand.Read(b)
+		e.ID = hex.EncodeToString(b)
+	}
+	return e
+}
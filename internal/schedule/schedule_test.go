@@ -0,0 +1,109 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for scheduled bans and access windows
+
+package schedule
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 2026-10-17 is a Saturday.
+func at(day, hour, min int) time.Time {
+	return time.Date(2026, 10, day, hour, min, 0, 0, time.UTC)
+}
+
+func TestEntryWindow(t *testing.T) {
+	e := Entry{Action: ActionAllow, Target: "peer", Days: []string{"Saturday", "sun"}}
+	if err := Validate(&e); err != nil {
+		t.Fatal(err)
+	}
+	if !e.Active(at(17, 12, 0)) || e.Active(at(16, 12, 0)) {
+		t.Error("Expected weekend-only window to be active on Saturday and not Friday")
+	}
+	if next := e.Next(at(16, 12, 0)); !next.Equal(at(17, 0, 0)) {
+		t.Errorf("Expected window to open Saturday 00:00, got %v", next)
+	}
+	if next := e.Next(at(18, 9, 0)); !next.Equal(at(19, 0, 0)) {
+		t.Errorf("Expected window to close Monday 00:00, got %v", next)
+	}
+
+	night := Entry{Action: ActionAllow, Target: "peer", Days: []string{"fri"}, Start: "22:00", End: "02:00"}
+	if err := Validate(&night); err != nil {
+		t.Fatal(err)
+	}
+	if !night.Active(at(17, 1, 30)) || night.Active(at(17, 2, 0)) {
+		t.Error("Expected Friday night window to wrap into Saturday until 02:00")
+	}
+
+	if err := Validate(&Entry{Action: ActionBan, Target: "x", Start: "25:00"}); err == nil {
+		t.Error("Expected validation error for bad start time")
+	}
+}
+
+func TestSchedulerBlocked(t *testing.T) {
+	s := NewScheduler(nil)
+	changes := 0
+	s.SetOnChange(func(entries []Entry) { changes++ })
+
+	now := at(16, 12, 0)
+	if _, err := s.Add(Entry{Action: ActionBan, Target: "1.2.3.4", Until: now.Add(48 * time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Add(Entry{Action: ActionAllow, Target: "lan:8787", Days: []string{"sat", "sun"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if blocked, _ := s.Blocked(now, "peer-id", "1.2.3.4"); !blocked {
+		t.Error("Expected banned host to be blocked")
+	}
+	if blocked, _ := s.Blocked(now.Add(49*time.Hour), "peer-id", "1.2.3.4"); blocked {
+		t.Error("Expected ban to lapse after 48 hours")
+	}
+	if blocked, _ := s.Blocked(now, "lan:8787"); !blocked {
+		t.Error("Expected weekend-only peer to be blocked on Friday")
+	}
+	if blocked, _ := s.Blocked(at(17, 12, 0), "lan:8787"); blocked {
+		t.Error("Expected weekend-only peer to be allowed on Saturday")
+	}
+	if next := s.NextTransition(now); !next.Equal(at(17, 0, 0)) {
+		t.Errorf("Expected next transition Saturday 00:00, got %v", next)
+	}
+
+	if expired := s.Expire(now.Add(49 * time.Hour)); len(expired) != 1 || expired[0].Target != "1.2.3.4" {
+		t.Errorf("Expected the timed ban to expire, got %+v", expired)
+	}
+	if len(s.List()) != 1 || changes != 3 {
+		t.Errorf("Expected 1 entry left after 3 changes, got %d entries, %d changes", len(s.List()), changes)
+	}
+}
+
+func TestSchedulerConcurrentEdits(t *testing.T) {
+	s := NewScheduler(nil)
+	var saved []Entry
+	var running atomic.Int32
+	s.SetOnChange(func(entries []Entry) {
+		if running.Add(1) > 1 {
+			t.Error("Expected change notifications one at a time")
+		}
+		time.Sleep(time.Millisecond)
+		saved = entries
+		running.Add(-1)
+	})
+
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Go(func() {
+			if _, err := s.Add(Entry{Action: "ban", Target: "10.0.0.1"}); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+	wg.Wait()
+	if len(saved) != 20 {
+		t.Errorf("Expected the last save to hold all 20 entries, got %d", len(saved))
+	}
+}
@@ -0,0 +1,359 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Minimal SQLite 3 database file writer for exports
+
+// Package sqlite writes self-contained SQLite 3 database files without cgo
+// or a driver. It only creates files from scratch: each table is written
+// once as a rowid b-tree, which is all an export needs.
+package sqlite
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+const (
+	pageSize     = 4096
+	headerSize   = 100 // database header at the start of page 1
+	leafType     = 0x0d
+	interiorType = 0x05
+	// maxLocal is the largest payload stored entirely on a table leaf page.
+	maxLocal = pageSize - 35
+	minLocal = (pageSize-12)*32/255 - 23
+)
+
+// Column is a column of a table. Type is the declared SQL type, e.g.
+// INTEGER, REAL or TEXT.
+type Column struct {
+	Name string
+	Type string
+}
+
+// Table is a table and its rows. Row values may be nil, bool, any integer
+// type, float32/64, string, []byte or time.Time (stored as RFC 3339 text).
+type Table struct {
+	Name    string
+	Columns []Column
+	Rows    [][]any
+}
+
+// CreateSQL returns the CREATE TABLE statement for the table.
+func (t Table) CreateSQL() string {
+	cols := make([]string, len(t.Columns))
+	for i, c := range t.Columns {
+		cols[i] = quote(c.Name) + " " + c.Type
+	}
+	return "CREATE TABLE " + quote(t.Name) + " (" + strings.Join(cols, ", ") + ")"
+}
+
+func quote(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+type writer struct {
+	pages [][]byte // pages[0] is page 1
+}
+
+// Write encodes the tables as a SQLite database file.
+func Write(w io.Writer, tables []Table) error {
+	wr := &writer{pages: [][]byte{nil}} // page 1 is filled in last
+
+	master := make([][]any, 0, len(tables))
+	for _, t := range tables {
+		if len(t.Columns) == 0 {
+			return fmt.Errorf("table %q has no columns", t.Name)
+		}
+		cells := make([][]byte, len(t.Rows))
+		for i, row := range t.Rows {
+			if len(row) != len(t.Columns) {
+				return fmt.Errorf("table %q row %d: %d values for %d columns", t.Name, i+1, len(row), len(t.Columns))
+			}
+			rec, err := record(row)
+			if err != nil {
+				return fmt.Errorf("table %q row %d: %w", t.Name, i+1, err)
+			}
+			cells[i] = wr.leafCell(int64(i+1), rec)
+		}
+		root := wr.alloc(wr.buildTree(cells, 0))
+		master = append(master, []any{"table", t.Name, t.Name, root, t.CreateSQL()})
+	}
+
+	cells := make([][]byte, len(master))
+	for i, row := range master {
+		rec, err := record(row)
+		if err != nil {
+			return err
+		}
+		cells[i] = wr.leafCell(int64(i+1), rec)
+	}
+	wr.pages[0] = wr.buildTree(cells, headerSize)
+	wr.writeHeader()
+
+	for _, p := range wr.pages {
+		if _, err := w.Write(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *writer) alloc(page []byte) uint32 {
+	w.pages = append(w.pages, page)
+	return uint32(len(w.pages))
+}
+
+func (w *writer) writeHeader() {
+	h := w.pages[0][:headerSize]
+	copy(h, "SQLite format 3\x00")
+	binary.BigEndian.PutUint16(h[16:], pageSize)
+	h[18], h[19] = 1, 1 // legacy journal mode
+	h[21], h[22], h[23] = 64, 32, 32
+	binary.BigEndian.PutUint32(h[24:], 1) // file change counter
+	binary.BigEndian.PutUint32(h[28:], uint32(len(w.pages)))
+	binary.BigEndian.PutUint32(h[40:], 1) // schema cookie
+	binary.BigEndian.PutUint32(h[44:], 4) // schema format
+	binary.BigEndian.PutUint32(h[56:], 1) // UTF-8
+	binary.BigEndian.PutUint32(h[92:], 1) // version-valid-for
+	binary.BigEndian.PutUint32(h[96:], 3040001)
+}
+
+// leafCell encodes a table leaf cell, spilling a large payload onto
+// overflow pages.
+func (w *writer) leafCell(rowid int64, payload []byte) []byte {
+	cell := appendVarint(nil, uint64(len(payload)))
+	cell = appendVarint(cell, uint64(rowid))
+	if len(payload) <= maxLocal {
+		return append(cell, payload...)
+	}
+
+	local := minLocal + (len(payload)-minLocal)%(pageSize-4)
+	if local > maxLocal {
+		local = minLocal
+	}
+	cell = append(cell, payload[:local]...)
+
+	// Overflow pages are chained; allocate back to front so each page
+	// knows its successor.
+	rest := payload[local:]
+	var chunks [][]byte
+	for len(rest) > 0 {
+		n := min(len(rest), pageSize-4)
+		chunks = append(chunks, rest[:n])
+		rest = rest[n:]
+	}
+	next := uint32(0)
+	for i := len(chunks) - 1; i >= 0; i-- {
+		page := make([]byte, pageSize)
+		binary.BigEndian.PutUint32(page, next)
+		copy(page[4:], chunks[i])
+		next = w.alloc(page)
+	}
+	return binary.BigEndian.AppendUint32(cell, next)
+}
+
+type child struct {
+	page   uint32
+	maxKey int64
+}
+
+// buildTree lays the leaf cells, whose rowids run from 1, out as a b-tree
+// and returns the root page, allocating every other page. off is where the
+// root's b-tree header starts, 100 for page 1.
+func (w *writer) buildTree(cells [][]byte, off int) []byte {
+	if fits(cells, off, 8) {
+		return leafPage(cells, off)
+	}
+
+	var level []child
+	for start := 0; start < len(cells); {
+		end := start + 1
+		for end < len(cells) && fits(cells[start:end+1], 0, 8) {
+			end++
+		}
+		level = append(level, child{page: w.alloc(leafPage(cells[start:end], 0)), maxKey: int64(end)})
+		start = end
+	}
+
+	for {
+		if page, ok := interiorPage(level, off); ok {
+			return page
+		}
+		var next []child
+		for start := 0; start < len(level); {
+			end := min(start+2, len(level))
+			for end < len(level) {
+				if _, ok := interiorPage(level[start:end+1], 0); !ok {
+					break
+				}
+				end++
+			}
+			if len(level)-end == 1 {
+				// Leave two children for the last page rather than one.
+				end--
+			}
+			page, _ := interiorPage(level[start:end], 0)
+			next = append(next, child{page: w.alloc(page), maxKey: level[end-1].maxKey})
+			start = end
+		}
+		level = next
+	}
+}
+
+// fits reports whether the cells and their pointers fit on one page.
+func fits(cells [][]byte, off, hdr int) bool {
+	n := off + hdr
+	for _, c := range cells {
+		n += len(c) + 2
+	}
+	return n <= pageSize
+}
+
+func leafPage(cells [][]byte, off int) []byte {
+	page := make([]byte, pageSize)
+	page[off] = leafType
+	layout(page, off, 8, cells)
+	return page
+}
+
+// interiorPage points at each child but the last through a cell keyed by
+// the child's largest rowid, and at the last through the right pointer.
+func interiorPage(children []child, off int) ([]byte, bool) {
+	cells := make([][]byte, len(children)-1)
+	for i, c := range children[:len(children)-1] {
+		cells[i] = appendVarint(binary.BigEndian.AppendUint32(nil, c.page), uint64(c.maxKey))
+	}
+	if !fits(cells, off, 12) {
+		return nil, false
+	}
+	page := make([]byte, pageSize)
+	page[off] = interiorType
+	binary.BigEndian.PutUint32(page[off+8:], children[len(children)-1].page)
+	layout(page, off, 12, cells)
+	return page, true
+}
+
+// layout fills in the cell count, cell pointer array and cell content area
+// of a b-tree page, packing cells from the end of the page.
+func layout(page []byte, off, hdr int, cells [][]byte) {
+	binary.BigEndian.PutUint16(page[off+3:], uint16(len(cells)))
+	end := pageSize
+	for i, c := range cells {
+		end -= len(c)
+		copy(page[end:], c)
+		binary.BigEndian.PutUint16(page[off+hdr+2*i:], uint16(end))
+	}
+	binary.BigEndian.PutUint16(page[off+5:], uint16(end))
+}
+
+// record encodes a row in the SQLite record format.
+func record(values []any) ([]byte, error) {
+	var hdr, body []byte
+	for _, v := range values {
+		switch x := v.(type) {
+		case int:
+			v = int64(x)
+		case int32:
+			v = int64(x)
+		case uint32:
+			v = int64(x)
+		case uint64:
+			if x > math.MaxInt64 {
+				return nil, fmt.Errorf("integer %d out of range", x)
+			}
+			v = int64(x)
+		case float32:
+			v = float64(x)
+		case time.Time:
+			v = nil
+			if !x.IsZero() {
+				v = x.UTC().Format(time.RFC3339)
+			}
+		}
+
+		switch x := v.(type) {
+		case nil:
+			hdr = appendVarint(hdr, 0)
+		case bool:
+			if x {
+				hdr = appendVarint(hdr, 9)
+			} else {
+				hdr = appendVarint(hdr, 8)
+			}
+		case int64:
+			t, b := encodeInt(x)
+			hdr, body = appendVarint(hdr, t), append(body, b...)
+		case float64:
+			hdr = appendVarint(hdr, 7)
+			body = binary.BigEndian.AppendUint64(body, math.Float64bits(x))
+		case string:
+			hdr = appendVarint(hdr, uint64(13+2*len(x)))
+			body = append(body, x...)
+		case []byte:
+			hdr = appendVarint(hdr, uint64(12+2*len(x)))
+			body = append(body, x...)
+		default:
+			return nil, fmt.Errorf("unsupported value type %T", v)
+		}
+	}
+
+	// The header size counts its own varint.
+	n := len(hdr) + 1
+	for len(appendVarint(nil, uint64(n)))+len(hdr) != n {
+		n = len(appendVarint(nil, uint64(n))) + len(hdr)
+	}
+	out := appendVarint(nil, uint64(n))
+	out = append(out, hdr...)
+	return append(out, body...), nil
+}
+
+// encodeInt picks the smallest serial type that holds v.
+func encodeInt(v int64) (uint64, []byte) {
+	switch {
+	case v == 0:
+		return 8, nil
+	case v == 1:
+		return 9, nil
+	case v >= math.MinInt8 && v <= math.MaxInt8:
+		return 1, []byte{byte(v)}
+	case v >= math.MinInt16 && v <= math.MaxInt16:
+		return 2, binary.BigEndian.AppendUint16(nil, uint16(v))
+	case v >= -1<<23 && v < 1<<23:
+		return 3, []byte{byte(v >> 16), byte(v >> 8), byte(v)}
+	case v >= math.MinInt32 && v <= math.MaxInt32:
+		return 4, binary.BigEndian.AppendUint32(nil, uint32(v))
+	case v >= -1<<47 && v < 1<<47:
+		b := binary.BigEndian.AppendUint64(nil, uint64(v))
+		return 5, b[2:]
+	default:
+		return 6, binary.BigEndian.AppendUint64(nil, uint64(v))
+	}
+}
+
+// appendVarint appends v as a SQLite varint: big-endian groups of 7 bits
+// with the high bit set on all but the last byte, and a full ninth byte.
+func appendVarint(b []byte, v uint64) []byte {
+	if v > 1<<56-1 {
+		var buf [9]byte
+		buf[8] = byte(v)
+		v >>= 8
+		for i := 7; i >= 0; i-- {
+			buf[i] = byte(v&0x7f) | 0x80
+			v >>= 7
+		}
+		return append(b, buf[:]...)
+	}
+	var buf [8]byte
+	i := len(buf) - 1
+	buf[i] = byte(v & 0x7f)
+	v >>= 7
+	for v > 0 {
+		i--
+		buf[i] = byte(v&0x7f) | 0x80
+		v >>= 7
+	}
+	return append(b, buf[i:]...)
+}
@@ -0,0 +1,119 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for the SQLite file writer
+
+package sqlite
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVarint(t *testing.T) {
+	cases := map[uint64][]byte{
+		0:           {0x00},
+		127:         {0x7f},
+		128:         {0x81, 0x00},
+		16383:       {0xff, 0x7f},
+		1 << 56:     {0x80, 0xc0, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x00},
+		1<<64 - 1:   {0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		0x123456789: {0x92, 0x9a, 0x95, 0xcf, 0x09},
+	}
+	for v, want := range cases {
+		if got := appendVarint(nil, v); !bytes.Equal(got, want) {
+			t.Errorf("Expected varint(%d) = % x, got % x", v, want, got)
+		}
+	}
+}
+
+func TestRecord(t *testing.T) {
+	rec, err := record([]any{nil, 1, 300, "ab", 1.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{
+		6, 0, 9, 2, 17, 7, // header: size, NULL, one, int16, text(2), float
+		0x01, 0x2c, 'a', 'b', 0x3f, 0xf8, 0, 0, 0, 0, 0, 0,
+	}
+	if !bytes.Equal(rec, want) {
+		t.Errorf("Expected record % x, got % x", want, rec)
+	}
+	if _, err := record([]any{struct{}{}}); err == nil {
+		t.Error("Expected an error for an unsupported value")
+	}
+}
+
+func testTables() []Table {
+	samples := Table{
+		Name:    "samples",
+		Columns: []Column{{"time", "TEXT"}, {"received", "INTEGER"}, {"ratio", "REAL"}},
+	}
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i := range 20000 {
+		samples.Rows = append(samples.Rows, []any{start.Add(time.Duration(i) * time.Minute), int64(i) * 1000003, float64(i) / 7})
+	}
+	notes := Table{
+		Name:    "notes",
+		Columns: []Column{{"id", "INTEGER"}, {"body", "TEXT"}},
+		Rows: [][]any{
+			{1, "short"},
+			{2, strings.Repeat("x", 10000)},
+			{3, nil},
+		},
+	}
+	return []Table{samples, notes, {Name: "empty", Columns: []Column{{"a", "TEXT"}}}}
+}
+
+func TestWrite(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, testTables()); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+	if len(b)%pageSize != 0 || !bytes.HasPrefix(b, []byte("SQLite format 3\x00")) {
+		t.Fatalf("Expected whole pages behind a SQLite header, got %d bytes", len(b))
+	}
+	if pages := int(b[28])<<24 | int(b[29])<<16 | int(b[30])<<8 | int(b[31]); pages*pageSize != len(b) {
+		t.Errorf("Expected header page count %d, got %d", len(b)/pageSize, pages)
+	}
+
+	if err := Write(&buf, []Table{{Name: "bad", Columns: []Column{{"a", "TEXT"}}, Rows: [][]any{{1, 2}}}}); err == nil {
+		t.Error("Expected an error for a row with too many values")
+	}
+}
+
+// TestWriteReadBack checks the file with the sqlite3 shell when it is
+// installed.
+func TestWriteReadBack(t *testing.T) {
+	sqlite3, err := exec.LookPath("sqlite3")
+	if err != nil {
+		t.Skip("sqlite3 not installed")
+	}
+	path := filepath.Join(t.TempDir(), "export.db")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Write(f, testTables()); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	out, err := exec.Command(sqlite3, path,
+		"PRAGMA integrity_check;",
+		"SELECT count(*), max(received), min(time) FROM samples;",
+		"SELECT length(body) FROM notes WHERE id = 2;",
+		"SELECT count(*) FROM empty;").CombinedOutput()
+	if err != nil {
+		t.Fatalf("sqlite3 failed: %v: %s", err, out)
+	}
+	want := "ok\n20000|19999059997|2026-01-02T03:04:05Z\n10000\n0\n"
+	if string(out) != want {
+		t.Errorf("Expected %q, got %q", want, out)
+	}
+}
@@ -0,0 +1,50 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Change notifications, so a UI redraws when and what the stats change
+
+package stats
+
+import "sync/atomic"
+
+// Change is a set of the parts of the stats that changed.
+type Change uint32
+
+const (
+	ChangePeers    Change = 1 << iota // a peer linked, left or was changed, or the topology moved
+	ChangeCounters                    // traffic counters moved
+	ChangeLogs                        // a message was logged
+	ChangeOps                         // an operation started, ended or is in flight
+
+	ChangeAll = ChangePeers | ChangeCounters | ChangeLogs | ChangeOps
+)
+
+// Changes collects the changes notified until its reader takes them.
+// Notifying never blocks, and any number of changes between two takes
+// wake the reader once.
+type Changes struct {
+	pending atomic.Uint32
+	wake    chan struct{}
+}
+
+func NewChanges() *Changes {
+	return &Changes{wake: make(chan struct{}, 1)}
+}
+
+// Notify records c and wakes the reader.
+func (n *Changes) Notify(c Change) {
+	n.pending.Or(uint32(c))
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+}
+
+// C is ready to receive from once changes are pending.
+func (n *Changes) C() <-chan struct{} {
+	return n.wake
+}
+
+// Take returns the pending changes and clears them.
+func (n *Changes) Take() Change {
+	return Change(n.pending.Swap(0))
+}
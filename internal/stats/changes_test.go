@@ -0,0 +1,37 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for change notifications
+
+package stats
+
+import "testing"
+
+func TestChanges(t *testing.T) {
+	n := NewChanges()
+	select {
+	case <-n.C():
+		t.Fatal("Expected no wake-up before a change")
+	default:
+	}
+
+	// Changes before the reader wakes are taken together.
+	n.Notify(ChangePeers)
+	n.Notify(ChangeLogs)
+	n.Notify(ChangePeers)
+	select {
+	case <-n.C():
+	default:
+		t.Fatal("Expected a wake-up after a change")
+	}
+	if c := n.Take(); c != ChangePeers|ChangeLogs {
+		t.Errorf("Expected peers and logs changed, got %b", c)
+	}
+	select {
+	case <-n.C():
+		t.Error("Expected one wake-up for the changes taken")
+	default:
+	}
+	if c := n.Take(); c != 0 {
+		t.Errorf("Expected nothing pending once taken, got %b", c)
+	}
+}
@@ -0,0 +1,137 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Operator-defined peer columns and the CSV export of the peer list
+
+package stats
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Column is a peer column defined in the configuration. Template is a Go
+// text/template over the peer's stats, e.g. "{{.City}}, {{.Country}}", with
+// .Note holding the operator's note on the peer.
+type Column struct {
+	Name     string `json:"name"`
+	Template string `json:"template"`
+}
+
+// ColumnData is what a column template is executed with.
+type ColumnData struct {
+	PeerStat
+	Note string
+}
+
+// Columns are the compiled custom columns with the notes they can show.
+type Columns struct {
+	names []string
+	tmpls []*template.Template
+
+	mu    sync.RWMutex
+	notes map[string]string
+}
+
+// NewColumns compiles the column templates. Notes are keyed by peer ID,
+// node ID or IP address.
+func NewColumns(cols []Column, notes map[string]string) (*Columns, error) {
+	c := &Columns{notes: notes}
+	for i, col := range cols {
+		if col.Name == "" {
+			return nil, fmt.Errorf("peer column %d has no name", i+1)
+		}
+		tmpl, err := template.New(col.Name).Option("missingkey=zero").Parse(col.Template)
+		if err != nil {
+			return nil, fmt.Errorf("peer column %q: %w", col.Name, err)
+		}
+		// Catch fields that do not exist now rather than on every refresh.
+		if err := tmpl.Execute(io.Discard, ColumnData{}); err != nil {
+			return nil, fmt.Errorf("peer column %q: %w", col.Name, err)
+		}
+		c.names = append(c.names, col.Name)
+		c.tmpls = append(c.tmpls, tmpl)
+	}
+	return c, nil
+}
+
+// Names returns the column headers.
+func (c *Columns) Names() []string {
+	if c == nil {
+		return nil
+	}
+	return c.names
+}
+
+// Note returns the operator's note on a peer, if any.
+func (c *Columns) Note(p PeerStat) string {
+	if c == nil {
+		return ""
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, key := range []string{p.ID, p.NodeID, p.IP.String()} {
+		if n, ok := c.notes[key]; ok && key != "" {
+			return n
+		}
+	}
+	return ""
+}
+
+// SetNotes replaces the notes, as when they are replicated from a primary
+// hub.
+func (c *Columns) SetNotes(notes map[string]string) {
+	c.mu.Lock()
+	c.notes = notes
+	c.mu.Unlock()
+}
+
+// Values returns a peer's value for each column. A template failing on
+// this peer shows as "?".
+func (c *Columns) Values(p PeerStat) []string {
+	if c == nil {
+		return nil
+	}
+	data := ColumnData{PeerStat: p, Note: c.Note(p)}
+	out := make([]string, len(c.tmpls))
+	for i, tmpl := range c.tmpls {
+		var b strings.Builder
+		if err := tmpl.Execute(&b, data); err != nil {
+			out[i] = "?"
+			continue
+		}
+		out[i] = b.String()
+	}
+	return out
+}
+
+// WritePeersCSV writes the peer list as CSV: the standard columns of the
+// peer table, then the custom ones.
+func WritePeersCSV(w io.Writer, peers []PeerStat, cols *Columns) error {
+	cw := csv.NewWriter(w)
+	header := []string{"id", "ip", "hostname", "node_id", "country", "city", "connected_at", "last_seen",
+		"latency_ms", "sent_bytes", "recv_bytes", "sent_pkts", "recv_pkts", "errors"}
+	if err := cw.Write(append(header, cols.Names()...)); err != nil {
+		return err
+	}
+	for _, p := range peers {
+		row := []string{
+			p.ID, p.IP.String(), p.Hostname, p.NodeID, p.Country, p.City,
+			p.ConnectedAt.Format(time.RFC3339), p.LastSeen.Format(time.RFC3339),
+			strconv.FormatFloat(p.LatencyMs, 'f', 1, 64),
+			strconv.FormatUint(p.SentBytes, 10), strconv.FormatUint(p.RecvBytes, 10),
+			strconv.FormatUint(p.SentPkts, 10), strconv.FormatUint(p.RecvPkts, 10),
+			strconv.FormatUint(p.Errors, 10),
+		}
+		if err := cw.Write(append(row, cols.Values(p)...)); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,87 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for custom peer columns and the CSV export
+
+package stats
+
+import (
+	"encoding/csv"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestColumns(t *testing.T) {
+	cols, err := NewColumns([]Column{
+		{Name: "Where", Template: "{{.City}}, {{.Country}}"},
+		{Name: "Note", Template: "{{.Note}}"},
+		{Name: "Label", Template: `{{if gt .Errors 10}}flaky{{else}}ok{{end}}`},
+	}, map[string]string{"node-b": "Bob's LAN", "10.0.0.3": "by IP"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cols.Names(); !slices.Equal(got, []string{"Where", "Note", "Label"}) {
+		t.Errorf("Expected the column names, got %v", got)
+	}
+	tests := []struct {
+		p    PeerStat
+		want []string
+	}{
+		{PeerStat{ID: "a", City: "Montreal", Country: "CA", Errors: 20}, []string{"Montreal, CA", "", "flaky"}},
+		{PeerStat{ID: "b", NodeID: "node-b"}, []string{", ", "Bob's LAN", "ok"}},
+		{PeerStat{ID: "c", IP: net.ParseIP("10.0.0.3")}, []string{", ", "by IP", "ok"}},
+	}
+	for _, tt := range tests {
+		if got := cols.Values(tt.p); !slices.Equal(got, tt.want) {
+			t.Errorf("Expected %q for peer %s, got %q", tt.want, tt.p.ID, got)
+		}
+	}
+}
+
+func TestColumnsInvalid(t *testing.T) {
+	for _, c := range []Column{
+		{Name: "", Template: "x"},
+		{Name: "Bad", Template: "{{.City"},
+		{Name: "Unknown", Template: "{{.Nope}}"},
+	} {
+		if _, err := NewColumns([]Column{c}, nil); err == nil {
+			t.Errorf("Expected an error for %+v", c)
+		}
+	}
+}
+
+func TestWritePeersCSV(t *testing.T) {
+	cols, err := NewColumns([]Column{{Name: "Where", Template: "{{.City}}, {{.Country}}"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	peers := []PeerStat{{ID: "a", IP: net.ParseIP("10.0.0.1"), City: "Paris", Country: "FR", SentBytes: 42}}
+	if err := WritePeersCSV(&b, peers, cols); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(strings.NewReader(b.String())).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("Expected a header and one row, got %d rows", len(rows))
+	}
+	header, row := rows[0], rows[1]
+	if header[len(header)-1] != "Where" || row[len(row)-1] != "Paris, FR" {
+		t.Errorf("Expected the custom column last, got %q and %q", header[len(header)-1], row[len(row)-1])
+	}
+	if i := slices.Index(header, "sent_bytes"); i < 0 || row[i] != "42" {
+		t.Errorf("Expected sent_bytes 42, got row %q", row)
+	}
+
+	// Without custom columns only the standard ones are written.
+	b.Reset()
+	if err := WritePeersCSV(&b, peers, nil); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(b.String(), "Where") {
+		t.Errorf("Expected no custom column, got %q", b.String())
+	}
+}
@@ -0,0 +1,122 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Grafana dashboard generated from the metric registry
+
+package stats
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+const (
+	dashboardUID  = "ipxtransporter"
+	panelWidth    = 12
+	panelHeight   = 8
+	rateWindow    = "[$__rate_interval]"
+	instanceMatch = `{instance=~"$instance"}`
+)
+
+// GrafanaDashboard returns a dashboard JSON model with one time series panel
+// per registered metric, ready to import into Grafana. Counters are graphed
+// as per-second rates. The Prometheus data source is chosen on import.
+func GrafanaDashboard() ([]byte, error) {
+	datasource := map[string]string{"type": "prometheus", "uid": "${DS_PROMETHEUS}"}
+
+	var panels []map[string]any
+	for i, m := range Metrics() {
+		expr := m.Name + instanceMatch
+		if m.Type == Counter {
+			expr = "rate(" + expr + rateWindow + ")"
+		}
+		if len(m.Labels) > 0 {
+			expr = "sum by (" + strings.Join(legendLabels(m.Labels), ", ") + ") (" + expr + ")"
+		} else {
+			expr = "sum(" + expr + ")"
+		}
+
+		panels = append(panels, map[string]any{
+			"id":          i + 1,
+			"type":        "timeseries",
+			"title":       panelTitle(m),
+			"description": m.Help,
+			"datasource":  datasource,
+			"gridPos":     map[string]int{"x": (i % 2) * panelWidth, "y": (i / 2) * panelHeight, "w": panelWidth, "h": panelHeight},
+			"fieldConfig": map[string]any{
+				"defaults":  map[string]any{"unit": m.Unit},
+				"overrides": []any{},
+			},
+			"targets": []map[string]any{{
+				"refId":        "A",
+				"datasource":   datasource,
+				"expr":         expr,
+				"legendFormat": legendFormat(m),
+			}},
+		})
+	}
+
+	dashboard := map[string]any{
+		"__inputs": []map[string]string{{
+			"name":       "DS_PROMETHEUS",
+			"label":      "Prometheus",
+			"type":       "datasource",
+			"pluginId":   "prometheus",
+			"pluginName": "Prometheus",
+		}},
+		"uid":           dashboardUID,
+		"title":         "IPXTransporter",
+		"tags":          []string{"ipxtransporter", "ipx"},
+		"timezone":      "browser",
+		"schemaVersion": 39,
+		"refresh":       "30s",
+		"time":          map[string]string{"from": "now-6h", "to": "now"},
+		"templating": map[string]any{"list": []map[string]any{{
+			"name":       "instance",
+			"label":      "Node",
+			"type":       "query",
+			"datasource": datasource,
+			"query":      "label_values(ipxt_uptime_seconds, instance)",
+			"refresh":    2,
+			"multi":      true,
+			"includeAll": true,
+			"current":    map[string]any{"text": "All", "value": "$__all"},
+		}}},
+		"panels": panels,
+	}
+	return json.MarshalIndent(dashboard, "", "  ")
+}
+
+// legendLabels drops the peer address, which changes on every reconnect,
+// in favour of the stable node ID and hostname.
+func legendLabels(labels []string) []string {
+	var out []string
+	for _, l := range labels {
+		if l != "peer" {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+func legendFormat(m MetricDesc) string {
+	if len(m.Labels) == 0 {
+		return panelTitle(m)
+	}
+	var parts []string
+	for _, l := range legendLabels(m.Labels) {
+		if l != "node_id" {
+			parts = append(parts, "{{"+l+"}}")
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// panelTitle turns ipxt_peer_bytes_total into "Peer bytes".
+func panelTitle(m MetricDesc) string {
+	title := strings.TrimSuffix(strings.TrimPrefix(m.Name, "ipxt_"), "_total")
+	title = strings.ReplaceAll(title, "_", " ")
+	if m.Type == Counter {
+		title += " /s"
+	}
+	return strings.ToUpper(title[:1]) + title[1:]
+}
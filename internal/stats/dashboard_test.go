@@ -0,0 +1,47 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for the generated Grafana dashboard
+
+package stats
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestGrafanaDashboard(t *testing.T) {
+	b, err := GrafanaDashboard()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var d struct {
+		Panels []struct {
+			Title   string
+			GridPos struct{ X, Y int }
+			Targets []struct{ Expr, LegendFormat string }
+		}
+	}
+	if err := json.Unmarshal(b, &d); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Panels) != len(Metrics()) {
+		t.Fatalf("Expected %d panels, got %d", len(Metrics()), len(d.Panels))
+	}
+	exprs := map[string]string{}
+	for _, p := range d.Panels {
+		exprs[p.Title] = p.Targets[0].Expr
+	}
+	want := map[string]string{
+		"Packets received /s": `sum(rate(ipxt_packets_received_total{instance=~"$instance"}[$__rate_interval]))`,
+		"Peer bytes /s":       `sum by (node_id, hostname, dir) (rate(ipxt_peer_bytes_total{instance=~"$instance"}[$__rate_interval]))`,
+		"Queue depth":         `sum by (queue) (ipxt_queue_depth{instance=~"$instance"})`,
+	}
+	for title, expr := range want {
+		if exprs[title] != expr {
+			t.Errorf("Expected %q panel to query %s, got %s", title, expr, exprs[title])
+		}
+	}
+	if last := d.Panels[1]; last.GridPos.X != panelWidth || last.GridPos.Y != 0 {
+		t.Errorf("Expected the second panel beside the first, got %+v", last.GridPos)
+	}
+}
@@ -0,0 +1,144 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Ring buffer of sampled relay counters and the series drawn from it
+
+package stats
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Sample is a snapshot of the cumulative relay counters.
+type Sample struct {
+	Time           time.Time `json:"time"`
+	Received       uint64    `json:"received"`
+	Forwarded      uint64    `json:"forwarded"`
+	ReceivedBytes  uint64    `json:"received_bytes"`
+	ForwardedBytes uint64    `json:"forwarded_bytes"`
+	Dropped        uint64    `json:"dropped"`
+	Errors         uint64    `json:"errors"`
+	EchoSuppressed uint64    `json:"echo_suppressed"`
+	Peers          int       `json:"peers"`
+	Stations       int       `json:"stations"` // end-stations served, local and behind peers
+}
+
+// History keeps the samples taken every resolution for the retention
+// period, dropping the oldest once full. It is safe for concurrent use.
+type History struct {
+	mu         sync.Mutex
+	resolution time.Duration
+	samples    []Sample
+	next       int // slot the next sample goes to once full
+}
+
+// NewHistory returns a history holding retention worth of samples taken
+// every resolution. Both are raised to a second if smaller.
+func NewHistory(resolution, retention time.Duration) *History {
+	resolution = max(resolution, time.Second)
+	size := max(int(retention/resolution), 1)
+	return &History{resolution: resolution, samples: make([]Sample, 0, size)}
+}
+
+// Resolution is the intended interval between samples.
+func (h *History) Resolution() time.Duration {
+	return h.resolution
+}
+
+// Add appends a sample, replacing the oldest when the history is full.
+func (h *History) Add(s Sample) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.samples) < cap(h.samples) {
+		h.samples = append(h.samples, s)
+		return
+	}
+	h.samples[h.next] = s
+	h.next = (h.next + 1) % len(h.samples)
+}
+
+// Since returns the samples taken at or after t, oldest first. A zero t
+// returns them all.
+func (h *History) Since(t time.Time) []Sample {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := make([]Sample, 0, len(h.samples))
+	for i := range h.samples {
+		s := h.samples[(h.next+i)%len(h.samples)]
+		if !s.Time.Before(t) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// SeriesPoint is the traffic rate, in packets and bytes per second, over
+// the interval ending at Time, and the end-stations served at Time.
+type SeriesPoint struct {
+	Time     time.Time `json:"time"`
+	RX       float64   `json:"rx"`
+	TX       float64   `json:"tx"`
+	RXBytes  float64   `json:"rx_bytes"`
+	TXBytes  float64   `json:"tx_bytes"`
+	Dropped  float64   `json:"dropped"`
+	Stations int       `json:"stations"`
+}
+
+// Series turns consecutive samples into rates. A counter that went
+// backwards, as after a restart, counts as zero for that interval.
+func Series(samples []Sample) []SeriesPoint {
+	out := make([]SeriesPoint, 0, max(len(samples)-1, 0))
+	for i := 1; i < len(samples); i++ {
+		prev, cur := samples[i-1], samples[i]
+		secs := cur.Time.Sub(prev.Time).Seconds()
+		if secs <= 0 {
+			continue
+		}
+		rate := func(a, b uint64) float64 {
+			if b < a {
+				return 0
+			}
+			return float64(b-a) / secs
+		}
+		out = append(out, SeriesPoint{
+			Time:     cur.Time,
+			RX:       rate(prev.Received, cur.Received),
+			TX:       rate(prev.Forwarded, cur.Forwarded),
+			RXBytes:  rate(prev.ReceivedBytes, cur.ReceivedBytes),
+			TXBytes:  rate(prev.ForwardedBytes, cur.ForwardedBytes),
+			Dropped:  rate(prev.Dropped, cur.Dropped),
+			Stations: cur.Stations,
+		})
+	}
+	return out
+}
+
+// Summary is a line for the log on the traffic from prev to cur: packet and
+// byte rates each way, drops and errors, and the peers and stations served.
+func Summary(prev, cur Sample) string {
+	var p SeriesPoint
+	if points := Series([]Sample{prev, cur}); len(points) == 1 {
+		p = points[0]
+	}
+	var errors uint64
+	if cur.Errors > prev.Errors {
+		errors = cur.Errors - prev.Errors
+	}
+	return fmt.Sprintf("RX %.1f pkt/s (%s/s), TX %.1f pkt/s (%s/s), %.1f dropped/s, %d errors, %d peers, %d stations",
+		p.RX, byteCount(p.RXBytes), p.TX, byteCount(p.TXBytes), p.Dropped, errors, cur.Peers, cur.Stations)
+}
+
+// byteCount is n bytes in binary units, e.g. 1.5 KiB.
+func byteCount(n float64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%.0f B", n)
+	}
+	exp := 0
+	for n >= unit*unit && exp < 5 {
+		n /= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", n/unit, "KMGTPE"[exp])
+}
@@ -0,0 +1,59 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for the stats history
+
+package stats
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistoryRing(t *testing.T) {
+	h := NewHistory(time.Minute, 3*time.Minute)
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := range 5 {
+		h.Add(Sample{Time: start.Add(time.Duration(i) * time.Minute), Received: uint64(i)})
+	}
+	all := h.Since(time.Time{})
+	if len(all) != 3 {
+		t.Fatalf("Expected 3 samples kept, got %d", len(all))
+	}
+	for i, s := range all {
+		if s.Received != uint64(i+2) {
+			t.Errorf("Expected sample %d to be %d, got %d", i, i+2, s.Received)
+		}
+	}
+	if got := h.Since(start.Add(4 * time.Minute)); len(got) != 1 || got[0].Received != 4 {
+		t.Errorf("Expected only the latest sample, got %+v", got)
+	}
+}
+
+func TestSeries(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	points := Series([]Sample{
+		{Time: start, Received: 100, Forwarded: 50},
+		{Time: start.Add(10 * time.Second), Received: 200, Forwarded: 70, ReceivedBytes: 6400, Dropped: 5},
+		{Time: start.Add(20 * time.Second), Received: 10}, // restarted
+	})
+	if len(points) != 2 {
+		t.Fatalf("Expected 2 points, got %d", len(points))
+	}
+	if p := points[0]; p.RX != 10 || p.TX != 2 || p.Dropped != 0.5 || p.RXBytes != 640 {
+		t.Errorf("Expected 10/2/0.5 pps and 640 B/s, got %+v", p)
+	}
+	if p := points[1]; p.RX != 0 || p.TX != 0 {
+		t.Errorf("Expected a reset to count as zero, got %+v", p)
+	}
+}
+
+func TestSummary(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	prev := Sample{Time: start, Received: 100, Forwarded: 50, Errors: 1}
+	cur := Sample{Time: start.Add(10 * time.Second), Received: 200, Forwarded: 70,
+		ReceivedBytes: 15360, ForwardedBytes: 500, Dropped: 5, Errors: 3, Peers: 4, Stations: 12}
+	want := "RX 10.0 pkt/s (1.5 KiB/s), TX 2.0 pkt/s (50 B/s), 0.5 dropped/s, 2 errors, 4 peers, 12 stations"
+	if got := Summary(prev, cur); got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
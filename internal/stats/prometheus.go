@@ -0,0 +1,180 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Prometheus metric registry and text exposition of the relay statistics
+
+package stats
+
+import (
+	"bufio"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Metric types.
+const (
+	Counter = "counter"
+	Gauge   = "gauge"
+)
+
+// MetricDesc describes one metric family of the registry.
+type MetricDesc struct {
+	Name   string
+	Type   string
+	Help   string
+	Unit   string   // Grafana panel unit; for counters the unit of their rate
+	Labels []string // label names, in the order values are emitted
+}
+
+// metric ties a descriptor to how its samples are read from Stats. emit
+// takes the sample value and one value per label.
+type metric struct {
+	MetricDesc
+	collect func(s Stats, emit func(v float64, labels ...string))
+}
+
+var (
+	peerLabels    = []string{"peer", "node_id", "hostname"}
+	peerDirLabels = []string{"peer", "node_id", "hostname", "dir"}
+)
+
+func scalar(get func(s Stats) float64) func(Stats, func(float64, ...string)) {
+	return func(s Stats, emit func(float64, ...string)) { emit(get(s)) }
+}
+
+// perPeer emits one sample per peer, or two with a dir label when rx is set.
+func perPeer(tx, rx func(PeerStat) float64) func(Stats, func(float64, ...string)) {
+	return func(s Stats, emit func(float64, ...string)) {
+		peers := append([]PeerStat(nil), s.Peers...)
+		sort.Slice(peers, func(i, j int) bool { return peers[i].ID < peers[j].ID })
+		for _, p := range peers {
+			if rx == nil {
+				emit(tx(p), p.ID, p.NodeID, p.Hostname)
+				continue
+			}
+			emit(tx(p), p.ID, p.NodeID, p.Hostname, "tx")
+			emit(rx(p), p.ID, p.NodeID, p.Hostname, "rx")
+		}
+	}
+}
+
+// registry lists every exported metric. Names follow the Prometheus
+// conventions: an ipxt_ prefix, base units, _total on counters and labels
+// rather than one metric per direction or class.
+var registry = []metric{
+	{MetricDesc{"ipxt_packets_received_total", Counter, "Packets captured locally or received from emulated machines.", "pps", nil},
+		scalar(func(s Stats) float64 { return float64(s.TotalReceived) })},
+	{MetricDesc{"ipxt_packets_forwarded_total", Counter, "Packets forwarded to peers.", "pps", nil},
+		scalar(func(s Stats) float64 { return float64(s.TotalForwarded) })},
+	{MetricDesc{"ipxt_bytes_received_total", Counter, "Bytes of the packets received.", "Bps", nil},
+		scalar(func(s Stats) float64 { return float64(s.ReceivedBytes) })},
+	{MetricDesc{"ipxt_bytes_forwarded_total", Counter, "Bytes of the packets forwarded to peers.", "Bps", nil},
+		scalar(func(s Stats) float64 { return float64(s.ForwardedBytes) })},
+	{MetricDesc{"ipxt_packets_dropped_total", Counter, "Packets dropped as duplicates, by rules or on full queues.", "pps", nil},
+		scalar(func(s Stats) float64 { return float64(s.TotalDropped) })},
+	{MetricDesc{"ipxt_errors_total", Counter, "Relay errors, including failed injections.", "short", nil},
+		scalar(func(s Stats) float64 { return float64(s.TotalErrors) })},
+	{MetricDesc{"ipxt_echo_suppressed_total", Counter, "Captured packets recognised as our own injected traffic.", "pps", nil},
+		scalar(func(s Stats) float64 { return float64(s.EchoSuppressed) })},
+	{MetricDesc{"ipxt_redirects_total", Counter, "Peers redirected to a child with spare capacity.", "short", nil},
+		scalar(func(s Stats) float64 { return float64(s.Redirects) })},
+	{MetricDesc{"ipxt_uptime_seconds", Gauge, "Seconds since the relay started.", "s", nil},
+		scalar(func(s Stats) float64 { return s.Uptime.Seconds() })},
+	{MetricDesc{"ipxt_peers", Gauge, "Connected peers, including emulated machines.", "short", nil},
+		scalar(func(s Stats) float64 { return float64(len(s.Peers)) })},
+	{MetricDesc{"ipxt_local_stations", Gauge, "IPX end-stations on the local segment that sent in the last 5 minutes.", "short", nil},
+		scalar(func(s Stats) float64 { return float64(s.LocalStations) })},
+	{MetricDesc{"ipxt_queue_depth", Gauge, "Packets waiting in each relay pipeline stage.", "short", []string{"queue"}},
+		func(s Stats, emit func(float64, ...string)) {
+			emit(float64(s.Queues.Capture), "capture")
+			emit(float64(s.Queues.PeerRelay), "peer_relay")
+			emit(float64(s.Queues.Broadcast), "broadcast")
+			emit(float64(s.Queues.Inject), "inject")
+			emit(float64(s.Queues.Retry), "retry")
+			emit(float64(s.Queues.PeerWrite), "peer_write")
+		}},
+	{MetricDesc{"ipxt_dedup_lookups_total", Counter, "Packets checked against the dedup cache.", "pps", nil},
+		scalar(func(s Stats) float64 { return float64(s.Dedup.Lookups) })},
+	{MetricDesc{"ipxt_dedup_hits_total", Counter, "Packets found in the dedup cache.", "pps", nil},
+		scalar(func(s Stats) float64 { return float64(s.Dedup.Hits) })},
+	{MetricDesc{"ipxt_dedup_hit_ratio", Gauge, "Share of checked packets that were duplicates since start.", "percentunit", nil},
+		scalar(func(s Stats) float64 {
+			if s.Dedup.Lookups == 0 {
+				return 0
+			}
+			return float64(s.Dedup.Hits) / float64(s.Dedup.Lookups)
+		})},
+	{MetricDesc{"ipxt_capture_packets_total", Counter, "Packets seen by the capture handle.", "pps", nil},
+		scalar(func(s Stats) float64 { return float64(s.Capture.Received) })},
+	{MetricDesc{"ipxt_capture_dropped_total", Counter, "Packets the capture handle missed, by where they were dropped.", "pps", []string{"where"}},
+		func(s Stats, emit func(float64, ...string)) {
+			emit(float64(s.Capture.Dropped), "kernel")
+			emit(float64(s.Capture.IfDropped), "interface")
+		}},
+	{MetricDesc{"ipxt_inject_errors_total", Counter, "Failed injections into the local segment, by class.", "short", []string{"class"}},
+		func(s Stats, emit func(float64, ...string)) {
+			emit(float64(s.Inject.HandleClosed), "handle_closed")
+			emit(float64(s.Inject.Transient), "transient")
+			emit(float64(s.Inject.Other), "other")
+		}},
+	{MetricDesc{"ipxt_api_limited_total", Counter, "API requests refused by the per-address rate limit.", "short", nil},
+		scalar(func(s Stats) float64 { return float64(s.API.Limited) })},
+	{MetricDesc{"ipxt_api_login_failures_total", Counter, "Failed API logins.", "short", nil},
+		scalar(func(s Stats) float64 { return float64(s.API.LoginFailures) })},
+	{MetricDesc{"ipxt_api_lockouts_total", Counter, "Addresses locked out of the API login after repeated failures.", "short", nil},
+		scalar(func(s Stats) float64 { return float64(s.API.Lockouts) })},
+	{MetricDesc{"ipxt_peer_bytes_total", Counter, "Bytes exchanged with each peer, by direction.", "Bps", peerDirLabels},
+		perPeer(func(p PeerStat) float64 { return float64(p.SentBytes) }, func(p PeerStat) float64 { return float64(p.RecvBytes) })},
+	{MetricDesc{"ipxt_peer_packets_total", Counter, "Packets exchanged with each peer, by direction.", "pps", peerDirLabels},
+		perPeer(func(p PeerStat) float64 { return float64(p.SentPkts) }, func(p PeerStat) float64 { return float64(p.RecvPkts) })},
+	{MetricDesc{"ipxt_peer_errors_total", Counter, "Link errors on each peer.", "short", peerLabels},
+		perPeer(func(p PeerStat) float64 { return float64(p.Errors) }, nil)},
+	{MetricDesc{"ipxt_peer_latency_seconds", Gauge, "Round-trip time to each peer.", "s", peerLabels},
+		perPeer(func(p PeerStat) float64 { return p.LatencyMs / 1000 }, nil)},
+	{MetricDesc{"ipxt_peer_queue_drops_total", Counter, "Packets dropped because a peer's send queue was over budget.", "pps", peerLabels},
+		perPeer(func(p PeerStat) float64 { return float64(p.QueueDrops) }, nil)},
+	{MetricDesc{"ipxt_peer_partial_frames_total", Counter, "Frames cut short on each peer link by a failed write or the stream ending, each aborting the link.", "short", peerLabels},
+		perPeer(func(p PeerStat) float64 { return float64(p.PartialFrames) }, nil)},
+	{MetricDesc{"ipxt_peer_pad_bytes_total", Counter, "Padding sent to each peer to hide packet sizes.", "Bps", peerLabels},
+		perPeer(func(p PeerStat) float64 { return float64(p.PadBytes) }, nil)},
+	{MetricDesc{"ipxt_peer_stations", Gauge, "IPX end-stations behind each peer that sent in the last 5 minutes.", "short", peerLabels},
+		perPeer(func(p PeerStat) float64 { return float64(p.Stations) }, nil)},
+	{MetricDesc{"ipxt_peer_memory_bytes", Gauge, "Approximate memory held by each peer link, send queue included.", "bytes", peerLabels},
+		perPeer(func(p PeerStat) float64 { return float64(p.MemBytes) }, nil)},
+}
+
+// Metrics returns the descriptors of every exported metric.
+func Metrics() []MetricDesc {
+	out := make([]MetricDesc, len(registry))
+	for i, m := range registry {
+		out[i] = m.MetricDesc
+	}
+	return out
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// WritePrometheus writes the statistics in the Prometheus text exposition
+// format.
+func WritePrometheus(w io.Writer, s Stats) error {
+	bw := bufio.NewWriter(w)
+	for _, m := range registry {
+		bw.WriteString("# HELP " + m.Name + " " + m.Help + "\n# TYPE " + m.Name + " " + m.Type + "\n")
+		m.collect(s, func(v float64, labels ...string) {
+			bw.WriteString(m.Name)
+			if len(labels) > 0 {
+				bw.WriteByte('{')
+				for i, l := range labels {
+					if i > 0 {
+						bw.WriteByte(',')
+					}
+					bw.WriteString(m.Labels[i] + `="` + labelEscaper.Replace(l) + `"`)
+				}
+				bw.WriteByte('}')
+			}
+			bw.WriteString(" " + strconv.FormatFloat(v, 'g', -1, 64) + "\n")
+		})
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,59 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Unit tests for the Prometheus exposition
+
+package stats
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	s := Stats{
+		TotalReceived: 42,
+		Queues:        QueueStats{Broadcast: 3},
+		Dedup:         DedupStats{Lookups: 8, Hits: 2},
+		Capture:       CaptureStats{Received: 50, Dropped: 1},
+		API:           APIStats{Lockouts: 2},
+		Peers: []PeerStat{
+			{ID: "192.0.2.1:50000", NodeID: "abcd", Hostname: `odd "host"`, SentBytes: 1000, LatencyMs: 25},
+		},
+	}
+	var buf bytes.Buffer
+	if err := WritePrometheus(&buf, s); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"# TYPE ipxt_packets_received_total counter\nipxt_packets_received_total 42\n",
+		`ipxt_queue_depth{queue="broadcast"} 3` + "\n",
+		"ipxt_dedup_hit_ratio 0.25\n",
+		`ipxt_capture_dropped_total{where="kernel"} 1` + "\n",
+		"ipxt_api_lockouts_total 2\n",
+		`ipxt_peer_bytes_total{peer="192.0.2.1:50000",node_id="abcd",hostname="odd \"host\"",dir="tx"} 1000` + "\n",
+		`ipxt_peer_bytes_total{peer="192.0.2.1:50000",node_id="abcd",hostname="odd \"host\"",dir="rx"} 0` + "\n",
+		`ipxt_peer_latency_seconds{peer="192.0.2.1:50000",node_id="abcd",hostname="odd \"host\""} 0.025` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected output to contain %q", want)
+		}
+	}
+}
+
+func TestMetricNames(t *testing.T) {
+	seen := map[string]bool{}
+	for _, m := range Metrics() {
+		if !strings.HasPrefix(m.Name, "ipxt_") {
+			t.Errorf("Expected %s to have the ipxt_ prefix", m.Name)
+		}
+		if (m.Type == Counter) != strings.HasSuffix(m.Name, "_total") {
+			t.Errorf("Expected only counters to end in _total, got %s %s", m.Type, m.Name)
+		}
+		if seen[m.Name] {
+			t.Errorf("Expected %s to be registered once", m.Name)
+		}
+		seen[m.Name] = true
+	}
+}
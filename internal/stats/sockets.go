@@ -0,0 +1,88 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Traffic counters by IPX socket, labelled with the protocol or game
+
+package stats
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/mlapointe/ipxtransporter/internal/ipx"
+)
+
+// SocketCount is the traffic seen for one IPX socket.
+type SocketCount struct {
+	Socket  string `json:"socket"` // hex, e.g. 0x869B
+	Label   string `json:"label"`  // protocol or game, empty if unknown
+	RxPkts  uint64 `json:"rx_pkts"`
+	RxBytes uint64 `json:"rx_bytes"`
+	TxPkts  uint64 `json:"tx_pkts"`
+	TxBytes uint64 `json:"tx_bytes"`
+}
+
+// SocketCounter accumulates traffic by the socket that identifies each
+// packet's protocol (see ipx.ClassifySocket).
+type SocketCounter struct {
+	mu     sync.Mutex
+	counts map[uint16]*SocketCount
+}
+
+// AddRx counts a received frame; frames that are not IPX are ignored.
+func (c *SocketCounter) AddRx(frame []byte) {
+	if sc := c.entry(frame); sc != nil {
+		sc.RxPkts++
+		sc.RxBytes += uint64(len(frame))
+		c.mu.Unlock()
+	}
+}
+
+// AddTx counts a sent frame; frames that are not IPX are ignored.
+func (c *SocketCounter) AddTx(frame []byte) {
+	if sc := c.entry(frame); sc != nil {
+		sc.TxPkts++
+		sc.TxBytes += uint64(len(frame))
+		c.mu.Unlock()
+	}
+}
+
+// entry returns the frame's counts with c.mu held, or nil.
+func (c *SocketCounter) entry(frame []byte) *SocketCount {
+	h, err := ipx.Parse(frame)
+	if err != nil {
+		return nil
+	}
+	socket := ipx.ClassifySocket(h)
+	c.mu.Lock()
+	if c.counts == nil {
+		c.counts = make(map[uint16]*SocketCount)
+	}
+	sc := c.counts[socket]
+	if sc == nil {
+		sc = &SocketCount{Socket: fmt.Sprintf("0x%04X", socket)}
+		c.counts[socket] = sc
+	}
+	return sc
+}
+
+// Snapshot returns the counts, busiest socket first, labelled with the
+// current socket names.
+func (c *SocketCounter) Snapshot() []SocketCount {
+	c.mu.Lock()
+	out := make([]SocketCount, 0, len(c.counts))
+	for socket, sc := range c.counts {
+		sc := *sc
+		sc.Label = ipx.SocketLabel(socket)
+		out = append(out, sc)
+	}
+	c.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i].RxPkts+out[i].TxPkts, out[j].RxPkts+out[j].TxPkts
+		if a != b {
+			return a > b
+		}
+		return out[i].Socket < out[j].Socket
+	})
+	return out
+}
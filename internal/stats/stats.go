@@ -6,31 +6,591 @@ package stats
 
 import (
 	"fmt"
+	"github.com/mlapointe/ipxtransporter/internal/ipx"
 	"github.com/mlapointe/ipxtransporter/internal/logger"
 	"net"
 	"sort"
+	"sync/atomic"
 	"time"
 )
 
 // Stats holds all metrics that the web API and TUI expose.
 type Stats struct {
-	TotalReceived     uint64              `json:"total_received"`
-	TotalForwarded    uint64              `json:"total_forwarded"`
-	TotalDropped      uint64              `json:"total_dropped"`
-	TotalErrors       uint64              `json:"total_errors"`
-	Uptime            time.Duration       `json:"uptime"`
-	UptimeStr         string              `json:"uptime_str"`
-	Peers             []PeerStat          `json:"peers"`
-	Logs              []logger.LogMessage `json:"logs"`
-	CaptureError      string              `json:"capture_error"`
-	SortField         string              `json:"sort_field"`
-	SortReverse       bool                `json:"sort_reverse"`
-	ListenAddr        string              `json:"listen_addr"`
-	MaxChildren       int                 `json:"max_children"`
-	NetworkKey        string              `json:"network_key"`
-	RebalanceEnabled  bool                `json:"rebalance_enabled"`
-	RebalanceInterval int                 `json:"rebalance_interval"`
-	DemoProps         *DemoProps          `json:"demo_props,omitzero"`
+	TotalReceived     uint64                    `json:"total_received"`
+	TotalForwarded    uint64                    `json:"total_forwarded"`
+	ReceivedBytes     uint64                    `json:"received_bytes"`  // of the packets in TotalReceived
+	ForwardedBytes    uint64                    `json:"forwarded_bytes"` // of the packets in TotalForwarded
+	TotalDropped      uint64                    `json:"total_dropped"`
+	TotalErrors       uint64                    `json:"total_errors"`
+	EchoSuppressed    uint64                    `json:"echo_suppressed"`
+	Redirects         uint64                    `json:"redirects"`
+	Uptime            time.Duration             `json:"uptime"`
+	UptimeStr         string                    `json:"uptime_str"`
+	Peers             []PeerStat                `json:"peers"`
+	PeerCount         int                       `json:"peer_count"` // all peers, also when Peers is one page
+	Logs              []logger.LogMessage       `json:"logs"`
+	CaptureError      string                    `json:"capture_error"`
+	ListenError       string                    `json:"listen_error,omitempty"` // why the peer listener is down
+	ListenRetry       time.Time                 `json:"listen_retry,omitzero"`  // when it is retried
+	SortField         string                    `json:"sort_field"`
+	SortReverse       bool                      `json:"sort_reverse"`
+	ListenAddr        string                    `json:"listen_addr"`
+	MaxChildren       int                       `json:"max_children"`
+	NetworkKey        string                    `json:"network_key"`
+	RebalanceEnabled  bool                      `json:"rebalance_enabled"`
+	RebalanceInterval int                       `json:"rebalance_interval"`
+	Queues            QueueStats                `json:"queues"`
+	Inject            InjectStats               `json:"inject"`
+	Dedup             DedupStats                `json:"dedup"`
+	Capture           CaptureStats              `json:"capture"`
+	MACTable          MACTableStats             `json:"mac_table"`
+	LocalStations     int                       `json:"local_stations"` // end-stations on the local segment that sent recently
+	RuleHits          []RuleHitStat             `json:"rule_hits"`
+	NetworkConflicts  []NetworkConflict         `json:"network_conflicts"`
+	NetworkAnomalies  []NetworkAnomaly          `json:"network_anomalies"` // traffic from networks a peer did not declare
+	Routes            []NetworkRoute            `json:"routes"`
+	NodeID            string                    `json:"node_id"`
+	KeyFingerprint    string                    `json:"key_fingerprint,omitempty"` // of the key signing our control metadata
+	Interface         string                    `json:"interface"`
+	FrameTypes        map[string]FrameTypeCount `json:"frame_types"` // captured/injected on Interface
+	Sockets           []SocketCount             `json:"sockets"`     // captured/injected by protocol or game
+	Topology          []TopologyNode            `json:"topology"`
+	Hub               string                    `json:"hub,omitempty"` // elected hub node ID
+	DiscoveredPeers   []DiscoveredPeer          `json:"discovered_peers"`
+	Relays            []RelayPath               `json:"relays"` // paths we forward as a rendezvous
+	Schedules         []ScheduleStat            `json:"schedules"`
+	VirtualClients    []VirtualClient           `json:"virtual_clients"`
+	LANNodes          []LANNode                 `json:"lan_nodes"`
+	Beacon            *BeaconStats              `json:"beacon,omitzero"`      // nil unless the beacon is on
+	FlowExport        *FlowExportStats          `json:"flow_export,omitzero"` // nil unless flows are exported
+	Captures          []MeshCapture             `json:"captures,omitempty"`
+	API               APIStats                  `json:"api"`
+	Traces            []PacketTrace             `json:"traces,omitempty"`     // recent traced packets received
+	Replication       *ReplicationStats         `json:"replication,omitzero"` // nil unless paired with a standby or primary
+	SelfHeal          SelfHealStats             `json:"self_heal"`
+	Operations        []Operation               `json:"operations"` // in flight, and those finished in the last half minute
+	Hosts             []IPXHost                 `json:"hosts"`
+	PortMapping       *PortMapping              `json:"port_mapping,omitzero"`
+	Certificate       *CertStats                `json:"certificate,omitzero"` // nil unless ACME obtains it
+	Room              *RoomStat                 `json:"room,omitzero"`
+	DemoProps         *DemoProps                `json:"demo_props,omitzero"`
+}
+
+// QueueStats reports the current depth of each relay pipeline stage.
+type QueueStats struct {
+	Capture   int `json:"capture"`
+	PeerRelay int `json:"peer_relay"`
+	Broadcast int `json:"broadcast"`
+	Inject    int `json:"inject"`
+	Retry     int `json:"retry"`
+	PeerWrite int `json:"peer_write"` // links waiting for a writer
+}
+
+// RuleHitStat reports how often a filter, priority or ban rule matched.
+type RuleHitStat struct {
+	Kind    string    `json:"kind"` // filter, priority, ban_id or ban_host
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Hits    uint64    `json:"hits"`
+	LastHit time.Time `json:"last_hit"`
+	Series  []uint64  `json:"series"` // hits per 10s bucket, oldest first
+}
+
+// FrameTypeCount counts traffic for one IPX frame type.
+type FrameTypeCount struct {
+	RxPkts  uint64 `json:"rx_pkts"`
+	RxBytes uint64 `json:"rx_bytes"`
+	TxPkts  uint64 `json:"tx_pkts"`
+	TxBytes uint64 `json:"tx_bytes"`
+}
+
+// FrameTypeCounter accumulates per-frame-type traffic without locking.
+type FrameTypeCounter struct {
+	rxPkts, rxBytes, txPkts, txBytes [ipx.FrameSNAP + 1]uint64
+}
+
+func (c *FrameTypeCounter) AddRx(ft ipx.FrameType, n int) {
+	atomic.AddUint64(&c.rxPkts[ft], 1)
+	atomic.AddUint64(&c.rxBytes[ft], uint64(n))
+}
+
+func (c *FrameTypeCounter) AddTx(ft ipx.FrameType, n int) {
+	atomic.AddUint64(&c.txPkts[ft], 1)
+	atomic.AddUint64(&c.txBytes[ft], uint64(n))
+}
+
+// Snapshot returns the counts keyed by frame type name, leaving out types
+// that have seen no traffic.
+func (c *FrameTypeCounter) Snapshot() map[string]FrameTypeCount {
+	out := make(map[string]FrameTypeCount)
+	for ft := range c.rxPkts {
+		fc := FrameTypeCount{
+			RxPkts:  atomic.LoadUint64(&c.rxPkts[ft]),
+			RxBytes: atomic.LoadUint64(&c.rxBytes[ft]),
+			TxPkts:  atomic.LoadUint64(&c.txPkts[ft]),
+			TxBytes: atomic.LoadUint64(&c.txBytes[ft]),
+		}
+		if fc.RxPkts+fc.TxPkts > 0 {
+			out[ipx.FrameType(ft).String()] = fc
+		}
+	}
+	return out
+}
+
+// DiscoveredPeer is a peer address learned through gossip.
+type DiscoveredPeer struct {
+	Addr     string    `json:"addr"`
+	From     string    `json:"from"` // peer that told us about it
+	LastSeen time.Time `json:"last_seen"`
+	Auto     bool      `json:"auto"`    // we are auto-connected or dialing
+	Failed   bool      `json:"failed"`  // an auto-connect attempt gave up
+	Pending  bool      `json:"pending"` // waiting for manual approval
+}
+
+// ScheduleStat reports a scheduled ban or access window and when it next
+// switches on or off.
+type ScheduleStat struct {
+	ID         string    `json:"id"`
+	Action     string    `json:"action"` // ban or allow
+	Target     string    `json:"target"`
+	When       string    `json:"when"`
+	Active     bool      `json:"active"`
+	NextChange time.Time `json:"next_change,omitzero"`
+}
+
+// RoomStat reports the room this node is in. The invite token is secret and
+// deliberately not part of the stats.
+type RoomStat struct {
+	Name    string       `json:"name"`
+	Public  bool         `json:"public"`
+	Tracker string       `json:"tracker,omitempty"`
+	Members []RoomMember `json:"members"`
+}
+
+// RoomMember is a node in the room. LastSeen and Packets are only known for
+// members we have a direct link to.
+type RoomMember struct {
+	ID       string    `json:"id"`
+	Hostname string    `json:"hostname"`
+	Local    bool      `json:"local"`
+	Direct   bool      `json:"direct"`
+	Hops     int       `json:"hops"`
+	LastSeen time.Time `json:"last_seen,omitzero"`
+	Packets  uint64    `json:"packets"`
+}
+
+// PortMapping reports the gateway port mapping for the peer listener.
+type PortMapping struct {
+	Method   string    `json:"method,omitempty"`   // nat-pmp or upnp
+	External string    `json:"external,omitempty"` // ip:port reachable from the internet
+	Expires  time.Time `json:"expires,omitzero"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// CertStats reports the certificate of the peer listener obtained over
+// ACME.
+type CertStats struct {
+	Domains   []string  `json:"domains"`
+	Challenge string    `json:"challenge"`
+	Expires   time.Time `json:"expires,omitzero"` // zero until one is obtained
+	Error     string    `json:"error,omitempty"`  // of the last attempt to obtain or renew it
+}
+
+// LANNode is an IPXTransporter instance discovered on the LAN over mDNS.
+type LANNode struct {
+	Instance  string    `json:"instance"`
+	ID        string    `json:"id"`
+	Hostname  string    `json:"hostname"`
+	Addr      string    `json:"addr"`
+	LastSeen  time.Time `json:"last_seen"`
+	Connected bool      `json:"connected"` // already part of our overlay tree
+}
+
+// TraceHop is a node a traced packet passed and when, by that node's clock,
+// it sent the packet on or, as the last hop, received it.
+type TraceHop struct {
+	Node string    `json:"node"`
+	At   time.Time `json:"at"`
+}
+
+// PacketTrace is the path a traced packet took through the mesh to us.
+type PacketTrace struct {
+	Received time.Time  `json:"received"`
+	Peer     string     `json:"peer"` // link it arrived on
+	Src      string     `json:"src"`
+	Dst      string     `json:"dst"`
+	Size     int        `json:"size"`
+	Hops     []TraceHop `json:"hops"` // from the origin to this node
+}
+
+// ConnAttempt is an inbound connection attempt and what became of it.
+type ConnAttempt struct {
+	Time    time.Time `json:"time"`
+	IP      string    `json:"ip"`
+	Host    string    `json:"host,omitempty"` // reverse lookup of IP
+	PeerID  string    `json:"peer_id"`
+	Outcome string    `json:"outcome"`          // accepted, banned, not-allowed, scheduled, max-children, draining or auth-failed
+	Detail  string    `json:"detail,omitempty"` // the ban matched, or why the handshake failed
+}
+
+// CommunityReport is what an opted-in node submits to the community
+// statistics endpoint: coarse buckets only, nothing that identifies the
+// node, its peers or their users.
+type CommunityReport struct {
+	Schema  int    `json:"schema"`
+	Nodes   string `json:"nodes"`             // bucket of the nodes in the mesh, e.g. "5-9"
+	Relayed string `json:"relayed"`           // bucket of the bytes sent since the last report, e.g. "1-10 GB"
+	Country string `json:"country,omitempty"` // community_country, as the operator set it
+}
+
+// CommunityStatus shows community statistics submission and previews the
+// next report exactly.
+type CommunityStatus struct {
+	Enabled   bool            `json:"enabled"`
+	URL       string          `json:"url"`
+	LastSent  time.Time       `json:"last_sent,omitzero"`
+	LastError string          `json:"last_error,omitempty"`
+	Preview   CommunityReport `json:"preview"`
+}
+
+// ReplicationStats reports warm standby config replication. A primary
+// lists its standbys, a standby its primary.
+type ReplicationStats struct {
+	Role     string           `json:"role"` // primary or standby
+	Partners []ReplicaPartner `json:"partners"`
+	Active   bool             `json:"active,omitempty"` // standby: the primary is lost, its peers are dialed
+	Error    string           `json:"error,omitempty"`
+}
+
+// ReplicaPartner is the other end of a replication pairing.
+type ReplicaPartner struct {
+	NodeID    string    `json:"node_id,omitempty"`
+	Addr      string    `json:"addr"`
+	Connected bool      `json:"connected"`
+	LastSync  time.Time `json:"last_sync,omitzero"` // config last sent or received
+	Version   string    `json:"version,omitempty"`  // hash of the config last sent or applied
+}
+
+// SelfHealStats reports what the self-healing policy did. Capture restarts
+// are counted as Inject.Reopens.
+type SelfHealStats struct {
+	DialerRestarts uint64    `json:"dialer_restarts"`
+	NextRestart    time.Time `json:"next_restart,omitzero"` // scheduled restart, if any
+	Draining       bool      `json:"draining,omitempty"`    // links are draining for a restart
+}
+
+// Operation is a long-running action of the relay, such as dialing a peer
+// or draining for a restart, so operators see it progress and end.
+type Operation struct {
+	ID      uint64    `json:"id"`
+	Kind    string    `json:"kind"` // dial, rotate_secret, drain, capture_restart or preflight
+	Target  string    `json:"target,omitempty"`
+	Started time.Time `json:"started"`
+	Ended   time.Time `json:"ended,omitzero"` // zero while in flight
+	Error   string    `json:"error,omitempty"`
+}
+
+// Ban is an entry of banned_ids or banned_hosts, or a scheduled ban.
+type Ban struct {
+	Target     string    `json:"target"`
+	Kind       string    `json:"kind"`                  // id, host or schedule
+	When       string    `json:"when,omitempty"`        // schedule: when it applies
+	Active     bool      `json:"active"`                // refusing links now
+	ScheduleID string    `json:"schedule_id,omitempty"` // schedule: the entry's ID
+	Reason     string    `json:"reason,omitempty"`      // schedule: why it was added, for automatic bans
+	Hits       uint64    `json:"hits"`                  // links refused, id and host bans
+	LastHit    time.Time `json:"last_hit,omitzero"`
+}
+
+// AllowList is the allow list of a closed network: with it enabled only
+// the hosts and IDs listed, and the configured peers, may link.
+type AllowList struct {
+	Enabled bool     `json:"enabled"`
+	Hosts   []string `json:"hosts"` // addresses, CIDR ranges and host names
+	IDs     []string `json:"ids"`   // peer IDs or node IDs
+}
+
+// Preflight is the outcome of a trial link to a peer address, made before
+// the address is added. Steps stop at the first that fails.
+type Preflight struct {
+	Addr           string          `json:"addr"`
+	OK             bool            `json:"ok"`
+	Steps          []PreflightStep `json:"steps"`
+	RTTMs          float64         `json:"rtt_ms,omitempty"` // TCP connect round trip
+	NodeID         string          `json:"node_id,omitempty"`
+	KeyFingerprint string          `json:"key_fingerprint,omitempty"` // of the key signing its hello
+	Trust          string          `json:"trust,omitempty"`           // trust level the peer would get
+}
+
+// PreflightStep is one step of a Preflight: resolve, connect, tls,
+// network_key or hello.
+type PreflightStep struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail"`
+}
+
+// PeerImportEntry is one peer of an imported relay list.
+type PeerImportEntry struct {
+	Addr      string   `json:"addr"`
+	Label     string   `json:"label,omitempty"`     // kept as the peer's note
+	Transport string   `json:"transport,omitempty"` // tls or tcp, empty for this node's
+	Groups    []string `json:"groups,omitempty"`
+}
+
+// PeerImportResult is what became of one entry of an imported list:
+// added, exists (already configured), valid (a dry run passed), skipped
+// or failed.
+type PeerImportResult struct {
+	PeerImportEntry
+	Status    string     `json:"status"`
+	Error     string     `json:"error,omitempty"` // why it was skipped or failed
+	Preflight *Preflight `json:"preflight,omitempty"`
+}
+
+// APIStats counts requests the HTTP API turned away.
+type APIStats struct {
+	Limited       uint64 `json:"limited"` // answered 429 by the request rate limit
+	LoginFailures uint64 `json:"login_failures"`
+	Lockouts      uint64 `json:"lockouts"`   // addresses locked out of /api/login
+	LockedOut     int    `json:"locked_out"` // addresses locked out now
+}
+
+// BeaconStats reports our presence beacon on the local segment and the
+// beacons of other relays heard there.
+type BeaconStats struct {
+	Socket   uint16       `json:"socket"`
+	Interval int          `json:"interval"` // seconds between announcements
+	Sent     uint64       `json:"sent"`
+	Queries  uint64       `json:"queries"` // answered on behalf of clients and relays
+	Beacons  []BeaconNode `json:"beacons"`
+}
+
+// FlowExportStats shows the flow records sent to the collector.
+type FlowExportStats struct {
+	Collector  string `json:"collector"`
+	Protocol   string `json:"protocol"`
+	Flows      int    `json:"flows"`   // being metered
+	Records    uint64 `json:"records"` // exported
+	Messages   uint64 `json:"messages"`
+	Overflows  uint64 `json:"overflows"` // frames not counted because the flow table was full
+	SendErrors uint64 `json:"send_errors"`
+	LastError  string `json:"last_error,omitempty"`
+}
+
+// BeaconNode is another relay announcing itself on our segment.
+type BeaconNode struct {
+	ID        string    `json:"id"` // node ID
+	Hostname  string    `json:"hostname"`
+	Listen    string    `json:"listen"` // its peer listen address
+	Addr      string    `json:"addr"`   // IPX source address of the beacon
+	Peers     int       `json:"peers"`
+	Via       string    `json:"via"` // interface or emulator
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	Count     uint64    `json:"count"`
+}
+
+// MeshCapture is a coordinated capture this node started or took part in.
+// Times are on the initiator's clock.
+type MeshCapture struct {
+	ID        string        `json:"id"`
+	Origin    string        `json:"origin"`
+	Nodes     []string      `json:"nodes,omitempty"` // all nodes if empty
+	Start     time.Time     `json:"start"`
+	Duration  int           `json:"duration"`  // seconds
+	OffsetMs  float64       `json:"offset_ms"` // our clock minus the initiator's
+	Pull      bool          `json:"pull"`
+	State     string        `json:"state"` // scheduled, recording, done, declined, failed, or relayed by a node not recording
+	Packets   uint64        `json:"packets"`
+	Truncated bool          `json:"truncated,omitempty"` // stopped at max_packets or max_bytes
+	File      string        `json:"file,omitempty"`
+	Error     string        `json:"error,omitempty"`
+	Pulled    []CaptureFile `json:"pulled,omitempty"` // at the initiator: files sent back
+}
+
+// CaptureFile is a recorded file pulled back from another node.
+type CaptureFile struct {
+	Node     string `json:"node"`
+	File     string `json:"file"`
+	Size     int64  `json:"size"`
+	Received int64  `json:"received"`
+	Error    string `json:"error,omitempty"`
+}
+
+// VirtualClient is an emulator attached over the IPXNET UDP protocol and
+// the IPX address it was given.
+type VirtualClient struct {
+	Addr      string    `json:"addr"` // UDP source address
+	Network   string    `json:"network"`
+	Node      string    `json:"node"`
+	Assigned  bool      `json:"assigned"` // address came from the coordinator
+	Connected time.Time `json:"connected"`
+	LastSeen  time.Time `json:"last_seen"`
+	RxPackets uint64    `json:"rx_packets"`
+	TxPackets uint64    `json:"tx_packets"`
+}
+
+// LegacyIPXNet is the bridge to a DOSBox IPXNET server whose users are
+// moving to the mesh. Address is ours on the server.
+type LegacyIPXNet struct {
+	Server     string         `json:"server"`
+	Registered bool           `json:"registered"`
+	Address    string         `json:"address,omitempty"`
+	Clients    []LegacyClient `json:"clients"`
+	Proxies    []LegacyProxy  `json:"proxies"`
+}
+
+// LegacyClient is a client of the legacy server and the pseudo-peer it is
+// listed as.
+type LegacyClient struct {
+	Address   string    `json:"address"` // network.node on the legacy server
+	PeerID    string    `json:"peer_id"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	RxPackets uint64    `json:"rx_packets"` // from the client to the mesh
+	TxPackets uint64    `json:"tx_packets"` // from the mesh to the client
+}
+
+// LegacyProxy is the address a mesh station has on the legacy server, its
+// clients' packets for which are passed on to the station.
+type LegacyProxy struct {
+	Station  string    `json:"station"` // network.node in the mesh
+	Proxy    string    `json:"proxy"`   // network.node on the legacy server
+	LastUsed time.Time `json:"last_used"`
+}
+
+// FeatureFlag is a feature that can be switched on and off without a
+// restart. Name is the setting that stores it.
+type FeatureFlag struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Enabled     bool   `json:"enabled"`
+}
+
+// FlagChange is a feature flag switched through the API or the TUI.
+type FlagChange struct {
+	Time    time.Time `json:"time"`
+	Flag    string    `json:"flag"`
+	Enabled bool      `json:"enabled"`
+	By      string    `json:"by"` // user, or "tui"
+}
+
+// IPXHost is an IPX address seen as the source of traffic, and where it
+// lives: on our interface, on an attached emulator or behind a peer.
+type IPXHost struct {
+	Address   string    `json:"address"` // network.node
+	Network   string    `json:"network"`
+	Node      string    `json:"node"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	Frames    uint64    `json:"frames"`
+	Via       string    `json:"via"`               // interface, emulator or peer
+	Behind    string    `json:"behind"`            // interface name or peer hostname/ID
+	PeerID    string    `json:"peer_id,omitempty"` // set when Via is peer
+}
+
+// TopologyNode is one node of the overlay tree. PeerID is set for nodes we
+// have a direct link to; Hops is 0 for the local node.
+type TopologyNode struct {
+	ID          string `json:"id"`
+	ParentID    string `json:"parent_id"`
+	Hostname    string `json:"hostname"`
+	PeerID      string `json:"peer_id,omitempty"`
+	NumChildren int    `json:"num_children"`
+	MaxChildren int    `json:"max_children"`
+	Hops        int    `json:"hops"`
+	RelayedVia  string `json:"relayed_via,omitempty"` // node forwarding our traffic to this one
+	Stations    int    `json:"stations"`              // end-stations seen behind it; ours on the local segment for the local node
+	Metric      int    `json:"metric,omitempty"`      // of its link to its parent, 0 if not measured yet
+
+	Segment *SegmentReport `json:"segment,omitempty"` // pushed up by the node, if it shares it
+}
+
+// SegmentReport summarizes a node's local segment for the nodes above it in
+// the tree, so a leaf behind NAT is more than one connection at its hub.
+// The traffic and interface fields are only filled in by nodes sharing
+// "full" reports.
+type SegmentReport struct {
+	NodeID       string    `json:"node_id"`
+	Stations     int       `json:"stations"` // IPX stations seen on the segment
+	CaptureOK    bool      `json:"capture_ok"`
+	CaptureError string    `json:"capture_error,omitempty"`
+	Interface    string    `json:"interface,omitempty"`
+	Received     uint64    `json:"received,omitempty"`
+	Forwarded    uint64    `json:"forwarded,omitempty"`
+	Dropped      uint64    `json:"dropped,omitempty"`
+	Updated      time.Time `json:"updated"` // when this node last received it
+}
+
+// RelayPath is a pair of peers that cannot link directly and whose traffic
+// this node forwards to each other. From and To are node IDs.
+type RelayPath struct {
+	From    string    `json:"from"`
+	To      string    `json:"to"`
+	Since   time.Time `json:"since"`
+	Packets uint64    `json:"packets"`
+}
+
+// NetworkConflict records an IPX network number claimed by more than one
+// segment. Sources are peer IDs, or "Local" for our own segment.
+type NetworkConflict struct {
+	Network    uint32    `json:"network"`
+	NetworkStr string    `json:"network_str"`
+	Sources    []string  `json:"sources"`
+	DetectedAt time.Time `json:"detected_at"`
+}
+
+// NetworkAnomaly is traffic from a peer whose source network the peer did
+// not declare reachable through its link.
+type NetworkAnomaly struct {
+	Network    uint32    `json:"network"`
+	NetworkStr string    `json:"network_str"`
+	Peer       string    `json:"peer"`
+	NodeID     string    `json:"node_id,omitempty"`
+	FirstSeen  time.Time `json:"first_seen"`
+	LastSeen   time.Time `json:"last_seen"`
+	Packets    uint64    `json:"packets"`
+	Dropped    bool      `json:"dropped,omitempty"` // strict_networks drops the traffic
+}
+
+// NetworkRoute is an IPX network and the link it is reached through.
+type NetworkRoute struct {
+	Network    uint32 `json:"network"`
+	NetworkStr string `json:"network_str"`
+	Via        string `json:"via"` // peer ID, or Local
+	NodeID     string `json:"node_id,omitempty"`
+	Source     string `json:"source"` // configured, learned, declared or observed
+}
+
+// MACTableStats counts learned stations by where they live.
+type MACTableStats struct {
+	Local  int `json:"local"`
+	Remote int `json:"remote"`
+}
+
+// InjectStats breaks down local segment injection failures by class.
+type InjectStats struct {
+	HandleClosed uint64 `json:"handle_closed"`
+	Transient    uint64 `json:"transient"`
+	Other        uint64 `json:"other"`
+	Retried      uint64 `json:"retried"`
+	RetryDropped uint64 `json:"retry_dropped"`
+	Reopens      uint64 `json:"reopens"`
+}
+
+// DedupStats counts packets checked against the dedup cache and how many
+// were duplicates.
+type DedupStats struct {
+	Lookups uint64 `json:"lookups"`
+	Hits    uint64 `json:"hits"`
+}
+
+// CaptureStats are the capture handle's counters as reported by pcap.
+type CaptureStats struct {
+	Received  uint64 `json:"received"`
+	Dropped   uint64 `json:"dropped"`    // by the kernel, buffer full
+	IfDropped uint64 `json:"if_dropped"` // by the interface or driver
 }
 
 type DemoProps struct {
@@ -39,6 +599,9 @@ type DemoProps struct {
 	ErrorRate  int `json:"error_rate"`
 	LatencyMs  int `json:"latency_ms"`
 	NumPeers   int `json:"num_peers"`
+
+	Pattern  string   `json:"pattern,omitempty"`  // traffic shape: flat, doom, sap, login or lan-party
+	Patterns []string `json:"patterns,omitempty"` // available patterns, reported only
 }
 
 func FormatDuration(d time.Duration) string {
@@ -90,6 +653,12 @@ func (s *Stats) SortPeers() {
 			less = p1.RecvPkts < p2.RecvPkts
 		case "errors":
 			less = p1.Errors < p2.Errors
+		case "stations":
+			less = p1.Stations < p2.Stations
+		case "latency":
+			less = p1.LatencyMs < p2.LatencyMs
+		case "country":
+			less = p1.Country < p2.Country
 		default:
 			less = p1.ID < p2.ID
 		}
@@ -100,6 +669,19 @@ func (s *Stats) SortPeers() {
 	})
 }
 
+// PagePeers keeps at most limit peers in ID order, starting at offset, so a
+// hub with many peers can be fetched a page at a time. A limit of 0 keeps
+// all peers from offset on.
+func (s *Stats) PagePeers(offset, limit int) {
+	sort.Slice(s.Peers, func(i, j int) bool { return s.Peers[i].ID < s.Peers[j].ID })
+	offset = min(offset, len(s.Peers))
+	end := len(s.Peers)
+	if limit > 0 {
+		end = min(offset+limit, end)
+	}
+	s.Peers = s.Peers[offset:end]
+}
+
 // PeerStat captures traffic & health for an individual peer.
 type PeerStat struct {
 	ID          string    `json:"id"`
@@ -121,4 +703,45 @@ type PeerStat struct {
 	Lon         float64   `json:"lon"`
 	Whois       string    `json:"whois"`
 	LatencyMs   float64   `json:"latency_ms"`
+	Metric      int       `json:"metric"` // Cost plus LatencyMs, lower is better
+	Inbound     bool      `json:"inbound"`
+	NodeID      string    `json:"node_id,omitempty"`
+	MutedIn     bool      `json:"muted_in"`
+	MutedOut    bool      `json:"muted_out"`
+	MutedPkts   uint64    `json:"muted_pkts"`
+	Emulated    bool      `json:"emulated,omitempty"` // pseudo-peer for a socket bridge machine
+
+	Trust          string `json:"trust,omitempty"`           // unknown, known or trusted
+	KeyFingerprint string `json:"key_fingerprint,omitempty"` // SHA-256 of the key signing its metadata
+
+	// Negotiated in the hellos: the protocol version both sides speak and
+	// the features the link uses. Downgrades counts the hellos from the
+	// peer's node that negotiated less than it did before.
+	Protocol   int      `json:"protocol,omitempty"`
+	Features   []string `json:"features,omitempty"`
+	Downgrades int      `json:"downgrades,omitempty"`
+
+	QueuedBytes uint64 `json:"queued_bytes"` // packets waiting to be sent
+	QueueDrops  uint64 `json:"queue_drops"`  // packets dropped on a full send queue
+	MemBytes    uint64 `json:"mem_bytes"`    // approximate memory held by the link
+
+	// Frames cut short by a failed write or by the peer's stream ending
+	// inside one; either aborts the link.
+	PartialFrames uint64 `json:"partial_frames"`
+
+	Padding  string `json:"padding,omitempty"` // padding mode in effect on the link
+	PadBytes uint64 `json:"pad_bytes"`         // sent to hide packet sizes
+
+	// Set by the peer's entry in the config: the name given to it, packets
+	// dropped by its rate_limit and filters, and bytes its compression
+	// saved.
+	Name        string `json:"name,omitempty"`
+	Cost        int    `json:"cost,omitempty"` // administrative cost, set by either end
+	RateDrops   uint64 `json:"rate_drops"`
+	FilterDrops uint64 `json:"filter_drops"`
+	Deflated    uint64 `json:"deflated_bytes"`
+
+	Stations int `json:"stations"` // IPX end-stations behind the peer that sent recently
+
+	FrameTypes map[string]FrameTypeCount `json:"frame_types,omitempty"`
 }
@@ -5,9 +5,12 @@
 package stats
 
 import (
+	"fmt"
 	"net"
 	"testing"
 	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/ipx"
 )
 
 func TestStats(t *testing.T) {
@@ -51,3 +54,87 @@ func TestStats(t *testing.T) {
 		t.Errorf("Expected peer IP %s, got %s", ip, stats.Peers[0].IP)
 	}
 }
+
+func TestFrameTypeCounter(t *testing.T) {
+	var c FrameTypeCounter
+	c.AddRx(ipx.FrameEthernetII, 100)
+	c.AddRx(ipx.FrameEthernetII, 60)
+	c.AddTx(ipx.Frame8023Raw, 80)
+
+	snap := c.Snapshot()
+	if len(snap) != 2 {
+		t.Fatalf("Expected 2 frame types, got %v", snap)
+	}
+	if got := snap["Ethernet_II"]; got.RxPkts != 2 || got.RxBytes != 160 {
+		t.Errorf("Expected 2 pkts/160 bytes Ethernet_II rx, got %+v", got)
+	}
+	if got := snap["802.3"]; got.TxPkts != 1 || got.TxBytes != 80 {
+		t.Errorf("Expected 1 pkt/80 bytes 802.3 tx, got %+v", got)
+	}
+}
+
+func socketFrame(t *testing.T, dst, src uint16) []byte {
+	packet := make([]byte, 30)
+	packet[0], packet[1] = 0xff, 0xff
+	packet[3] = 30
+	packet[16], packet[17] = byte(dst>>8), byte(dst)
+	packet[28], packet[29] = byte(src>>8), byte(src)
+	frame, err := ipx.EncapEthernetII(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return frame
+}
+
+func TestSocketCounter(t *testing.T) {
+	var c SocketCounter
+	c.AddRx(socketFrame(t, 0x869B, 0x869B))
+	c.AddTx(socketFrame(t, 0x869B, 0x869B))
+	c.AddRx(socketFrame(t, 0x4003, 0x0452))
+	c.AddRx([]byte("not ipx"))
+
+	snap := c.Snapshot()
+	if len(snap) != 2 {
+		t.Fatalf("Expected 2 sockets, got %+v", snap)
+	}
+	if got := snap[0]; got.Socket != "0x869B" || got.Label != "Doom" || got.RxPkts != 1 || got.TxPkts != 1 || got.TxBytes != 44 {
+		t.Errorf("Expected Doom first with 1 rx/1 tx, got %+v", got)
+	}
+	if got := snap[1]; got.Label != "SAP" || got.RxPkts != 1 {
+		t.Errorf("Expected the SAP reply under SAP, got %+v", got)
+	}
+
+	// Names the user sets label the counts already taken.
+	ipx.SetSocketNames(map[uint16]string{0x869B: "Heretic"})
+	t.Cleanup(func() { ipx.SetSocketNames(nil) })
+	if got := c.Snapshot()[0]; got.Label != "Heretic" {
+		t.Errorf("Expected the user's name, got %+v", got)
+	}
+}
+
+func TestPagePeers(t *testing.T) {
+	page := func(offset, limit int) []string {
+		s := Stats{Peers: []PeerStat{{ID: "c"}, {ID: "a"}, {ID: "e"}, {ID: "b"}, {ID: "d"}}}
+		s.PagePeers(offset, limit)
+		var ids []string
+		for _, p := range s.Peers {
+			ids = append(ids, p.ID)
+		}
+		return ids
+	}
+	cases := []struct {
+		offset, limit int
+		want          string
+	}{
+		{0, 2, "[a b]"},
+		{2, 2, "[c d]"},
+		{4, 2, "[e]"},
+		{1, 0, "[b c d e]"},
+		{9, 2, "[]"},
+	}
+	for _, c := range cases {
+		if got := fmt.Sprint(page(c.offset, c.limit)); got != c.want {
+			t.Errorf("Expected page(%d, %d) = %s, got %s", c.offset, c.limit, c.want, got)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Accessibility mode: text in place of colors and graphs for screen readers
+
+package tui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+	"github.com/rivo/tview"
+)
+
+// staleAfter is how long a peer may be silent before it shows as stale.
+const staleAfter = 10 * time.Second
+
+// accessibleRefresh replaces the 500ms refresh in accessibility mode, so a
+// screen reader is not made to re-read counters several times a second.
+const accessibleRefresh = 3 * time.Second
+
+// peerStatus is the state the peer table otherwise shows only by color.
+func peerStatus(p stats.PeerStat) string {
+	switch {
+	case time.Since(p.LastSeen) > staleAfter:
+		return "stale"
+	case p.Emulated:
+		return "emulated"
+	}
+	return "ok"
+}
+
+// pageTitles names the pages whose name does not read well on its own.
+var pageTitles = map[string]string{
+	"error":        "Message",
+	"iface_select": "Interface selection",
+	"lan_nodes":    "LAN nodes",
+	"main":         "Peers",
+}
+
+// pageTitle turns a page name such as "config_editor" into "Config editor".
+func pageTitle(name string) string {
+	if title, ok := pageTitles[name]; ok {
+		return title
+	}
+	name = strings.ReplaceAll(name, "_", " ")
+	if name == "" {
+		return name
+	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+// announcePage puts the title of the page in front into the terminal title,
+// which screen readers announce when it changes.
+func (t *TUI) announcePage() {
+	name, _ := t.pages.GetFrontPage()
+	t.app.SetTitle("IPXTransporter: " + pageTitle(name))
+}
+
+// updateSummary writes the state of the relay as plain sentences, in place
+// of the traffic graph and topology map.
+func (t *TUI) updateSummary(s stats.Stats) {
+	now := time.Now()
+	var lines []string
+
+	rates := "Traffic rates are measured from the next refresh."
+	if !t.prevAt.IsZero() && s.TotalReceived >= t.prevRx {
+		secs := now.Sub(t.prevAt).Seconds()
+		rates = fmt.Sprintf("Receiving %.1f packets per second, forwarding %.1f packets per second.",
+			float64(s.TotalReceived-t.prevRx)/secs, float64(s.TotalForwarded-t.prevTx)/secs)
+	}
+	t.prevRx, t.prevTx, t.prevAt = s.TotalReceived, s.TotalForwarded, now
+	lines = append(lines, rates)
+
+	stale, emulated := 0, 0
+	for _, p := range s.Peers {
+		switch peerStatus(p) {
+		case "stale":
+			stale++
+		case "emulated":
+			emulated++
+		}
+	}
+	lines = append(lines, fmt.Sprintf("%d peers connected, %d stale, %d emulated.", s.PeerCount, stale, emulated))
+	behind := 0
+	for _, p := range s.Peers {
+		behind += p.Stations
+	}
+	lines = append(lines, fmt.Sprintf("Serving about %d IPX stations: %d on the local segment, %d behind peers.", s.LocalStations+behind, s.LocalStations, behind))
+	lines = append(lines, fmt.Sprintf("Totals: %d received, %d forwarded, %d dropped, %d errors. Up %s.",
+		s.TotalReceived, s.TotalForwarded, s.TotalDropped, s.TotalErrors, s.UptimeStr))
+	if s.Hub != "" {
+		lines = append(lines, "Hub is "+s.Hub+".")
+	}
+	if b := s.Beacon; b != nil {
+		lines = append(lines, fmt.Sprintf("%d other relays heard on the local segment.", len(b.Beacons)))
+	}
+	for _, c := range s.Captures {
+		if c.State == "recording" {
+			lines = append(lines, fmt.Sprintf("Recording mesh capture %s: %d packets so far.", c.ID, c.Packets))
+		}
+	}
+	lines = append(lines, opsSentences(s.Operations, now)...)
+	if r := s.Replication; r != nil {
+		_, text := replicationStatus(r, now)
+		lines = append(lines, text+".")
+	}
+	if s.CaptureError != "" {
+		lines = append(lines, "Capture error: "+s.CaptureError)
+	}
+	if s.ListenError != "" {
+		lines = append(lines, fmt.Sprintf("Listener down, not accepting peer links: %s. Retrying in %s.", s.ListenError, retryIn(s.ListenRetry, time.Now())))
+	}
+	for _, c := range s.NetworkConflicts {
+		lines = append(lines, fmt.Sprintf("Warning: IPX network %s is claimed by %s.", c.NetworkStr, strings.Join(c.Sources, ", ")))
+	}
+	for _, a := range s.NetworkAnomalies {
+		lines = append(lines, fmt.Sprintf("Warning: peer %s sends from IPX network %s it did not declare.", a.Peer, a.NetworkStr))
+	}
+	t.summary.SetText(tview.Escape(strings.Join(lines, "\n")))
+}
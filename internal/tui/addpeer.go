@@ -0,0 +1,138 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Add Peer dialog: address checks, transport and key, and the first dial
+
+package tui
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+	"github.com/rivo/tview"
+)
+
+// SetAddConfig lets the Add Peer dialog add a peer with a transport or key
+// fingerprint of its own.
+func (t *TUI) SetAddConfig(add func(ctx context.Context, pc config.PeerConfig) error) {
+	t.onAddConfig = add
+}
+
+// showAddPeerDialog asks for the address of a peer, and its transport and
+// key fingerprint where those can be set. The address is checked before
+// anything is added, and the dialog then follows the first connection
+// attempt until it ends.
+func (t *TUI) showAddPeerDialog() {
+	if t.onAddPeer == nil {
+		return
+	}
+	var pc config.PeerConfig
+	status := tview.NewTextView().SetDynamicColors(true)
+	status.SetBorderPadding(0, 0, 1, 1)
+	form := tview.NewForm().
+		AddInputField("Peer Address", "", 40, nil, func(text string) { pc.Addr = text })
+	height := 9
+	if t.onAddConfig != nil {
+		byDefault := "Default (TLS)"
+		if t.cfg.DisableSSL {
+			byDefault = "Default (TCP)"
+		}
+		transports := []string{"", config.TransportTLS, config.TransportTCP}
+		form.AddDropDown("Transport", []string{byDefault, "TLS", "TCP"}, 0, func(_ string, i int) {
+			if i >= 0 {
+				pc.Transport = transports[i]
+			}
+		}).AddInputField("Fingerprint", "", 40, nil, func(text string) { pc.Fingerprint = text })
+		height = 13
+	}
+
+	// checked is the peer of the form if its fields are sound, and says
+	// what is wrong with them otherwise.
+	checked := func() (config.PeerConfig, bool) {
+		c := pc
+		if strings.TrimSpace(c.Addr) == "" {
+			status.SetText("[red]Enter the address of the peer, host or host:port")
+			return c, false
+		}
+		addr, err := config.PeerAddr(c.Addr)
+		if err == nil {
+			c.Addr = addr
+			err = c.Validate(t.cfg.DisableSSL)
+		}
+		if err != nil {
+			status.SetText("[red]" + tview.Escape(err.Error()))
+			return c, false
+		}
+		return c, true
+	}
+	closeDialog := func() {
+		t.dialRefresh = nil
+		t.pages.RemovePage("add_peer")
+	}
+
+	form.AddButton("Add", func() {
+		if c, ok := checked(); ok {
+			t.addPeer(c, status)
+		}
+	})
+	if t.onPreflight != nil {
+		// Try the address first, so a typo does not sit in the retry loop.
+		form.AddButton("Test", func() {
+			if c, ok := checked(); ok {
+				t.testPeer(c, false, status)
+			}
+		}).AddButton("Test and Add", func() {
+			if c, ok := checked(); ok {
+				t.testPeer(c, true, status)
+			}
+		})
+	}
+	form.AddButton("Close", closeDialog)
+	form.SetCancelFunc(closeDialog)
+
+	layout := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(form, 0, 1, true).
+		AddItem(status, 2, 0, false)
+	layout.SetBorder(true).SetTitle("Add New Peer")
+	t.pages.AddPage("add_peer", t.center(layout, 64, height), true, true)
+}
+
+// addPeer adds pc, with its settings if it has any, and has status follow
+// the first attempt to connect to it.
+func (t *TUI) addPeer(pc config.PeerConfig, status *tview.TextView) {
+	added := time.Now()
+	if pc.AddrOnly() || t.onAddConfig == nil {
+		t.onAddPeer(context.Background(), pc.Addr)
+	} else if err := t.onAddConfig(context.Background(), pc); err != nil {
+		status.SetText("[red]" + tview.Escape(err.Error()))
+		return
+	}
+	t.dialRefresh = func(ops []stats.Operation) {
+		status.SetText(dialStatus(ops, pc.Addr, added))
+	}
+	t.dialRefresh(t.statsFunc().Operations)
+}
+
+// dialStatus describes the first attempt to connect to addr begun since
+// added, as the relay reports it among its operations. A peer that was
+// already configured keeps its link, so no attempt may come.
+func dialStatus(ops []stats.Operation, addr string, added time.Time) string {
+	for _, op := range sortedOps(ops) {
+		if op.Kind != "dial" || op.Target != addr || op.Started.Before(added) {
+			continue
+		}
+		switch {
+		case op.Ended.IsZero():
+			return fmt.Sprintf("[yellow]Added %s, connecting...", addr)
+		case op.Error != "":
+			return fmt.Sprintf("[red]Added %s, but the first attempt failed: %s[-]\nThe relay keeps retrying every 5 seconds.",
+				addr, tview.Escape(op.Error))
+		default:
+			return fmt.Sprintf("[green]Added %s and connected.", addr)
+		}
+	}
+	return fmt.Sprintf("Added %s, waiting for a connection attempt.", addr)
+}
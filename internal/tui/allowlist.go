@@ -0,0 +1,122 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Allow list page: the hosts and IDs a closed network admits
+
+package tui
+
+import (
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+	"github.com/rivo/tview"
+)
+
+// AllowListManager is what the allow list page needs from the relay
+// server.
+type AllowListManager interface {
+	AllowList() stats.AllowList
+	SetAllowListMode(on bool)
+	AllowPeer(kind, target string) error
+	RemoveAllowed(target string) error
+}
+
+// SetAllowList enables the allow list page (Ctrl+W).
+func (t *TUI) SetAllowList(am AllowListManager) {
+	t.allowList = am
+}
+
+func (t *TUI) showAllowList() {
+	if t.allowList == nil {
+		return
+	}
+
+	list := tview.NewList().ShowSecondaryText(false)
+	status := tview.NewTextView().SetDynamicColors(true)
+	refresh := func(selected int) {
+		list.Clear()
+		al := t.allowList.AllowList()
+		for _, h := range al.Hosts {
+			list.AddItem("HOST     "+tview.Escape(h), h, 0, nil)
+		}
+		for _, id := range al.IDs {
+			list.AddItem("ID       "+tview.Escape(id), id, 0, nil)
+		}
+		if n := list.GetItemCount(); n > 0 {
+			list.SetCurrentItem(max(0, min(selected, n-1)))
+		}
+		if al.Enabled {
+			status.SetText("[green]Enforced[-]: only these hosts and IDs and the configured peers may link")
+		} else {
+			status.SetText("[gray]Not enforced[-]: every peer may link")
+		}
+	}
+	refresh(0)
+
+	list.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch {
+		case event.Key() == tcell.KeyEscape:
+			t.pages.RemovePage("allowlist")
+			return nil
+		case event.Rune() == 'e':
+			t.allowList.SetAllowListMode(!t.allowList.AllowList().Enabled)
+			refresh(list.GetCurrentItem())
+			return nil
+		case event.Rune() == 'a':
+			t.showAllowForm(func() { refresh(list.GetItemCount()) })
+			return nil
+		case event.Rune() == 'x' || event.Key() == tcell.KeyDelete:
+			if list.GetItemCount() > 0 {
+				cur := list.GetCurrentItem()
+				_, target := list.GetItemText(cur)
+				if err := t.allowList.RemoveAllowed(target); err != nil {
+					t.showError(err.Error())
+				}
+				refresh(cur)
+			}
+			return nil
+		}
+		return event
+	})
+
+	help := tview.NewTextView().
+		SetDynamicColors(true).
+		SetText(t.helpLine("allowlist"))
+
+	flex := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(status, 1, 0, false).
+		AddItem(list, 0, 1, true).
+		AddItem(help, 1, 0, false)
+	flex.SetBorder(true).SetTitle("Allow List")
+
+	t.pages.AddPage("allowlist", t.center(flex, 90, 20), true, true)
+	t.app.SetFocus(list)
+}
+
+// showAllowForm allows hosts when given an IP address, CIDR range or
+// *.domain pattern, and a peer or node ID otherwise.
+func (t *TUI) showAllowForm(onSaved func()) {
+	form := tview.NewForm().
+		AddInputField("Node ID, peer ID, IP, CIDR or *.domain", "", 40, nil, nil)
+	form.AddButton("Allow", func() {
+		target := strings.TrimSpace(form.GetFormItem(0).(*tview.InputField).GetText())
+		if target == "" {
+			return
+		}
+		kind := "id"
+		if config.IsHostPattern(target) {
+			kind = "host"
+		}
+		if err := t.allowList.AllowPeer(kind, target); err != nil {
+			t.showError(err.Error())
+			return
+		}
+		t.pages.RemovePage("allow_form")
+		onSaved()
+	}).AddButton("Cancel", func() {
+		t.pages.RemovePage("allow_form")
+	})
+	form.SetBorder(true).SetTitle("Allow")
+	t.pages.AddPage("allow_form", t.center(form, 60, 7), true, true)
+}
@@ -0,0 +1,83 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Audit page: inbound connection attempts and what became of them
+
+package tui
+
+import (
+	"github.com/gdamore/tcell/v2"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+	"github.com/rivo/tview"
+)
+
+// auditOutcomes are the filters the audit page cycles through.
+var auditOutcomes = []string{"", "accepted", "banned", "not-allowed", "scheduled", "max-children", "draining", "auth-failed"}
+
+// SetAudit enables the connection attempt audit page (Ctrl+O).
+func (t *TUI) SetAudit(attempts func(outcome string) []stats.ConnAttempt) {
+	t.audit = attempts
+}
+
+func (t *TUI) showAudit() {
+	if t.audit == nil {
+		return
+	}
+
+	filter := 0
+	table := tview.NewTable().SetFixed(1, 0).SetSelectable(true, false)
+	flex := tview.NewFlex().SetDirection(tview.FlexRow)
+	refresh := func() {
+		table.Clear()
+		for i, h := range []string{"Time", "IP", "Host", "Outcome", "Detail"} {
+			table.SetCell(0, i, tview.NewTableCell(h).SetTextColor(tcell.ColorYellow).SetSelectable(false))
+		}
+		attempts := t.audit(auditOutcomes[filter])
+		if len(attempts) == 0 {
+			table.SetCell(1, 0, tview.NewTableCell("No inbound connection attempts").SetTextColor(tcell.ColorGray))
+		}
+		for i, a := range attempts {
+			color := tcell.ColorRed
+			if a.Outcome == "accepted" {
+				color = tcell.ColorGreen
+			}
+			table.SetCell(i+1, 0, tview.NewTableCell(a.Time.Format("01-02 15:04:05")))
+			table.SetCell(i+1, 1, tview.NewTableCell(tview.Escape(a.IP)))
+			table.SetCell(i+1, 2, tview.NewTableCell(tview.Escape(a.Host)).SetExpansion(1))
+			table.SetCell(i+1, 3, tview.NewTableCell(a.Outcome).SetTextColor(color))
+			table.SetCell(i+1, 4, tview.NewTableCell(tview.Escape(a.Detail)).SetExpansion(1))
+		}
+		title := "Connection Attempts"
+		if auditOutcomes[filter] != "" {
+			title += ": " + auditOutcomes[filter]
+		}
+		flex.SetTitle(title)
+	}
+
+	table.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch {
+		case event.Key() == tcell.KeyEscape:
+			t.pages.RemovePage("audit")
+			return nil
+		case event.Rune() == 'f':
+			filter = (filter + 1) % len(auditOutcomes)
+			refresh()
+			return nil
+		case event.Rune() == 'r':
+			refresh()
+			return nil
+		}
+		return event
+	})
+
+	help := tview.NewTextView().
+		SetDynamicColors(true).
+		SetText(t.helpLine("audit"))
+
+	flex.AddItem(table, 0, 1, true).
+		AddItem(help, 1, 0, false)
+	flex.SetBorder(true)
+	refresh()
+
+	t.pages.AddPage("audit", t.center(flex, 120, 24), true, true)
+	t.app.SetFocus(table)
+}
@@ -0,0 +1,73 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Config backups page: restoring an earlier config file
+
+package tui
+
+import (
+	"fmt"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/rivo/tview"
+)
+
+// BackupManager is what the config backups page needs from the relay
+// server.
+type BackupManager interface {
+	ConfigBackups() ([]config.Backup, error)
+	RestoreConfig(name string) error
+}
+
+// SetBackups enables restoring config backups from the config editor.
+func (t *TUI) SetBackups(bm BackupManager) {
+	t.backups = bm
+}
+
+func (t *TUI) showBackups() {
+	backups, err := t.backups.ConfigBackups()
+	if err != nil {
+		t.showError("Failed to list the backups: " + err.Error())
+		return
+	}
+	if len(backups) == 0 {
+		t.showMessage("No backups of " + t.configPath + " yet. One is kept each time it is saved.")
+		return
+	}
+	done := func() {
+		t.pages.RemovePage("config_backups")
+	}
+
+	list := tview.NewList()
+	for _, b := range backups {
+		name := b.Name
+		list.AddItem(b.Time.Format("2006-01-02 15:04:05"), fmt.Sprintf("%s, %d bytes", name, b.Size), 0, func() {
+			t.confirmRestore(name, done)
+		})
+	}
+	list.AddItem("Cancel", "Go back", 'c', done)
+
+	list.SetBorder(true).SetTitle("Restore Previous Config")
+	t.pages.AddPage("config_backups", t.center(list, 60, 18), true, true)
+}
+
+// confirmRestore restores the backup name, which restarts the relay, if
+// the operator agrees.
+func (t *TUI) confirmRestore(name string, done func()) {
+	modal := tview.NewModal().
+		SetText(fmt.Sprintf("Restore %s? The current config is backed up and the relay restarts to read it.", name)).
+		AddButtons([]string{"Restore", "Cancel"}).
+		SetDoneFunc(func(buttonIndex int, buttonLabel string) {
+			t.pages.RemovePage("restore_confirm")
+			if buttonIndex != 0 {
+				return
+			}
+			if err := t.backups.RestoreConfig(name); err != nil {
+				t.showError("Failed to restore: " + err.Error())
+				return
+			}
+			done()
+			t.pages.RemovePage("config_editor")
+			t.showMessage("Restored " + name + ". The relay is restarting.")
+		})
+	t.pages.AddPage("restore_confirm", modal, true, true)
+}
@@ -0,0 +1,117 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Bans page: banned IDs and hosts and scheduled bans, and lifting them
+
+package tui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+	"github.com/rivo/tview"
+)
+
+// SetBans enables the bans page (Ctrl+B).
+func (t *TUI) SetBans(list func() []stats.Ban, unban func(target string) error) {
+	t.bans = list
+	t.onUnban = unban
+}
+
+func (t *TUI) showBans() {
+	if t.bans == nil {
+		return
+	}
+
+	list := tview.NewList().ShowSecondaryText(false)
+	refresh := func(selected int) {
+		list.Clear()
+		now := time.Now()
+		for _, b := range t.bans() {
+			list.AddItem(formatBan(b, now), b.Target, 0, nil)
+		}
+		if n := list.GetItemCount(); n > 0 {
+			list.SetCurrentItem(max(0, min(selected, n-1)))
+		}
+	}
+	refresh(0)
+
+	list.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch {
+		case event.Key() == tcell.KeyEscape:
+			t.pages.RemovePage("bans")
+			return nil
+		case event.Rune() == 'a' && t.onBan != nil:
+			t.showBanForm(func() { refresh(list.GetItemCount()) })
+			return nil
+		case event.Rune() == 'x' || event.Key() == tcell.KeyDelete:
+			if list.GetItemCount() > 0 {
+				cur := list.GetCurrentItem()
+				_, target := list.GetItemText(cur)
+				if err := t.onUnban(target); err != nil {
+					t.showError(err.Error())
+				}
+				refresh(cur)
+			}
+			return nil
+		}
+		return event
+	})
+
+	help := tview.NewTextView().
+		SetDynamicColors(true).
+		SetText(t.helpLine("bans"))
+
+	flex := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(list, 0, 1, true).
+		AddItem(help, 1, 0, false)
+	flex.SetBorder(true).SetTitle("Bans")
+
+	t.pages.AddPage("bans", t.center(flex, 90, 20), true, true)
+	t.app.SetFocus(list)
+}
+
+// showBanForm bans a peer ID, or hosts when given an IP address, CIDR
+// range or *.domain pattern.
+func (t *TUI) showBanForm(onSaved func()) {
+	form := tview.NewForm().
+		AddInputField("Peer ID, IP, CIDR or *.domain", "", 40, nil, nil)
+	form.AddButton("Ban", func() {
+		target := strings.TrimSpace(form.GetFormItem(0).(*tview.InputField).GetText())
+		if target == "" {
+			return
+		}
+		if config.IsHostPattern(target) {
+			t.onBan("", target)
+		} else {
+			t.onBan(target, "")
+		}
+		t.pages.RemovePage("ban_form")
+		onSaved()
+	}).AddButton("Cancel", func() {
+		t.pages.RemovePage("ban_form")
+	})
+	form.SetBorder(true).SetTitle("Ban")
+	t.pages.AddPage("ban_form", t.center(form, 60, 7), true, true)
+}
+
+func formatBan(b stats.Ban, now time.Time) string {
+	state := "[gray]inactive[-]"
+	if b.Active {
+		state = "[red]active[-]  "
+	}
+	detail := b.When
+	if b.Reason != "" {
+		detail += ", " + b.Reason
+	}
+	if b.Kind != "schedule" {
+		detail = fmt.Sprintf("%d links refused", b.Hits)
+		if !b.LastHit.IsZero() {
+			detail += ", last " + stats.FormatDuration(now.Sub(b.LastHit)) + " ago"
+		}
+	}
+	return fmt.Sprintf("%-8s %-22s %s  %s", strings.ToUpper(b.Kind), tview.Escape(b.Target), state, detail)
+}
@@ -0,0 +1,248 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Batch actions: marking peers in the table and acting on them at once
+
+package tui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+	"github.com/rivo/tview"
+)
+
+// SetLabel enables labelling marked peers, which sets the note the custom
+// columns show for them.
+func (t *TUI) SetLabel(fn func(peers []stats.PeerStat, note string)) {
+	t.onLabel = fn
+}
+
+// markKeys handles the keys of the peer table that mark peers: Space
+// marks or unmarks the selected row, f marks by a filter and Esc clears
+// the marks.
+func (t *TUI) markKeys(event *tcell.EventKey) *tcell.EventKey {
+	switch {
+	case event.Key() == tcell.KeyRune && event.Rune() == ' ':
+		row, _ := t.table.GetSelection()
+		if id, ok := t.table.GetCell(row, 0).GetReference().(string); ok {
+			if t.marked[id] {
+				delete(t.marked, id)
+			} else {
+				t.marked[id] = true
+			}
+			t.table.Select(min(row+1, t.table.GetRowCount()-1), 0)
+			t.showMarks()
+		}
+		return nil
+	case event.Key() == tcell.KeyRune && event.Rune() == 'f':
+		t.showMarkFilters()
+		return nil
+	case event.Key() == tcell.KeyEscape && len(t.marked) > 0:
+		clear(t.marked)
+		t.showMarks()
+		return nil
+	}
+	return event
+}
+
+// markPrefix flags the first column of a peer when it is marked.
+func (t *TUI) markPrefix(id string) string {
+	if t.marked[id] {
+		return "* "
+	}
+	return ""
+}
+
+// showMarks redraws the table without waiting for the next refresh.
+func (t *TUI) showMarks() {
+	t.drawPeers(t.statsFunc())
+}
+
+func (t *TUI) markedPeers() []stats.PeerStat {
+	var out []stats.PeerStat
+	for _, p := range t.statsFunc().Peers {
+		if t.marked[p.ID] {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// showMarkFilters marks every peer that matches a filter, in addition to
+// those marked already.
+func (t *TUI) showMarkFilters() {
+	done := func() {
+		t.pages.RemovePage("mark_filters")
+	}
+	mark := func(match func(p stats.PeerStat) bool) {
+		for _, p := range t.statsFunc().Peers {
+			if match(p) {
+				t.marked[p.ID] = true
+			}
+		}
+		t.showMarks()
+	}
+
+	list := tview.NewList()
+	list.AddItem("All Peers", "Every peer in the table", 'a', func() {
+		done()
+		mark(func(stats.PeerStat) bool { return true })
+	})
+	list.AddItem("Stale Peers", fmt.Sprintf("Silent for over %s", staleAfter), 's', func() {
+		done()
+		mark(func(p stats.PeerStat) bool { return time.Since(p.LastSeen) > staleAfter })
+	})
+	list.AddItem("Inbound Peers", "Links the peer dialed", 'i', func() {
+		done()
+		mark(func(p stats.PeerStat) bool { return p.Inbound })
+	})
+	list.AddItem("Muted Peers", "Muted either way", 'm', func() {
+		done()
+		mark(func(p stats.PeerStat) bool { return p.MutedIn || p.MutedOut })
+	})
+	list.AddItem("From a Country...", "As WHOIS gives it", 'o', func() {
+		done()
+		t.showMarkPrompt("Country", func(v string) {
+			mark(func(p stats.PeerStat) bool { return strings.EqualFold(p.Country, v) })
+		})
+	})
+	list.AddItem("Matching Text...", "In the ID, address, hostname or label", 't', func() {
+		done()
+		t.showMarkPrompt("Text", func(v string) {
+			v = strings.ToLower(v)
+			mark(func(p stats.PeerStat) bool {
+				for _, s := range []string{p.ID, p.IP.String(), p.Hostname, t.columns.Note(p)} {
+					if strings.Contains(strings.ToLower(s), v) {
+						return true
+					}
+				}
+				return false
+			})
+		})
+	})
+	list.AddItem("Clear Marks", "Unmark every peer", 'x', func() {
+		done()
+		clear(t.marked)
+		t.showMarks()
+	})
+	list.AddItem("Cancel", "Go back", 'c', done)
+
+	list.SetBorder(true).SetTitle("Mark Peers")
+	t.pages.AddPage("mark_filters", t.center(list, 50, 18), true, true)
+}
+
+// showMarkPrompt asks for the value of a filter and marks by it.
+func (t *TUI) showMarkPrompt(label string, fn func(v string)) {
+	form := tview.NewForm().AddInputField(label, "", 30, nil, nil)
+	form.AddButton("Mark", func() {
+		v := strings.TrimSpace(form.GetFormItem(0).(*tview.InputField).GetText())
+		t.pages.RemovePage("mark_prompt")
+		if v != "" {
+			fn(v)
+		}
+	}).AddButton("Cancel", func() {
+		t.pages.RemovePage("mark_prompt")
+	})
+	form.SetBorder(true).SetTitle("Mark Peers")
+	t.pages.AddPage("mark_prompt", t.center(form, 50, 7), true, true)
+}
+
+func (t *TUI) showBatchActions() {
+	peers := t.markedPeers()
+	if len(peers) == 0 {
+		return
+	}
+	done := func() {
+		t.pages.RemovePage("batch_actions")
+	}
+
+	list := tview.NewList()
+	list.AddItem("Disconnect", "Close every marked connection", 'd', func() {
+		done()
+		if t.onDisconnect != nil {
+			for _, p := range peers {
+				t.onDisconnect(p.ID)
+			}
+		}
+		clear(t.marked)
+	})
+	list.AddItem("Ban Hosts & IDs", "Disconnect and ban forever", 'b', func() {
+		done()
+		t.confirmBatch(fmt.Sprintf("Ban %d peers and their hosts forever?", len(peers)), func() {
+			if t.onBan != nil {
+				for _, p := range peers {
+					t.onBan(p.ID, p.IP.String())
+				}
+			}
+		})
+	})
+	if t.onBanFor != nil {
+		list.AddItem("Ban for 48 Hours", "Disconnect and ban, lifted automatically", 't', func() {
+			done()
+			t.confirmBatch(fmt.Sprintf("Ban %d peers and their hosts for 48 hours?", len(peers)), func() {
+				for _, p := range peers {
+					t.onBanFor(p.ID, p.IP.String(), 48*time.Hour)
+				}
+			})
+		})
+	}
+	if t.onLabel != nil {
+		list.AddItem("Label", "Set the note shown for them", 'l', func() {
+			done()
+			t.showLabelPrompt(peers)
+		})
+	}
+	list.AddItem("Export to CSV", "Write them to a CSV file", 'e', func() {
+		done()
+		t.savePeersCSV(peers)
+	})
+	list.AddItem("Clear Marks", "Unmark every peer", 'x', func() {
+		done()
+		clear(t.marked)
+		t.showMarks()
+	})
+	list.AddItem("Cancel", "Go back", 'c', done)
+
+	list.SetBorder(true).SetTitle(fmt.Sprintf("Actions for %d Marked Peers", len(peers)))
+	t.pages.AddPage("batch_actions", t.center(list, 48, 18), true, true)
+}
+
+// confirmBatch runs fn, then clears the marks, if the operator agrees.
+func (t *TUI) confirmBatch(text string, fn func()) {
+	modal := tview.NewModal().
+		SetText(text).
+		AddButtons([]string{"Yes", "Cancel"}).
+		SetDoneFunc(func(buttonIndex int, buttonLabel string) {
+			t.pages.RemovePage("batch_confirm")
+			if buttonIndex == 0 {
+				fn()
+				clear(t.marked)
+			}
+		})
+	t.pages.AddPage("batch_confirm", modal, true, true)
+}
+
+// showLabelPrompt sets the note of peers, offering the one they share.
+func (t *TUI) showLabelPrompt(peers []stats.PeerStat) {
+	common := t.columns.Note(peers[0])
+	for _, p := range peers[1:] {
+		if t.columns.Note(p) != common {
+			common = ""
+			break
+		}
+	}
+	form := tview.NewForm().AddInputField("Label", common, 40, nil, nil)
+	form.AddButton("Set", func() {
+		note := strings.TrimSpace(form.GetFormItem(0).(*tview.InputField).GetText())
+		t.pages.RemovePage("label_prompt")
+		t.onLabel(peers, note)
+		clear(t.marked)
+	}).AddButton("Cancel", func() {
+		t.pages.RemovePage("label_prompt")
+	})
+	form.SetBorder(true).SetTitle(fmt.Sprintf("Label %d Peers (empty removes)", len(peers)))
+	t.pages.AddPage("label_prompt", t.center(form, 60, 7), true, true)
+}
@@ -0,0 +1,257 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Built-in peer table columns and the chooser that shows, hides and orders them
+
+package tui
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+	"github.com/rivo/tview"
+)
+
+// peerColumn is a built-in column of the peer table: its key in
+// peer_table, its header, the sort_field that orders the table by it, if
+// any, and its value for a peer. A column with color may draw a value in
+// a color of its own rather than that of the row.
+type peerColumn struct {
+	key   string
+	name  string
+	sort  string
+	value func(t *TUI, p stats.PeerStat) string
+	color func(t *TUI, p stats.PeerStat) (tcell.Color, bool)
+}
+
+// peerColumns has a column for each of config.PeerTableColumns.
+var peerColumns = []peerColumn{
+	{"id", "ID", "id", func(_ *TUI, p stats.PeerStat) string { return p.ID }, nil},
+	{"ip", "IP", "ip", func(_ *TUI, p stats.PeerStat) string { return p.IP.String() }, nil},
+	{"hostname", "Hostname", "hostname", func(_ *TUI, p stats.PeerStat) string { return p.Hostname }, nil},
+	{"connected", "Connected", "connected", func(_ *TUI, p stats.PeerStat) string { return p.ConnectedAt.Format("15:04:05") }, nil},
+	{"last_seen", "Last Seen", "last_seen", func(_ *TUI, p stats.PeerStat) string {
+		return time.Since(p.LastSeen).Round(time.Second).String()
+	}, nil},
+	{"sent_bytes", "Sent", "sent_bytes", func(_ *TUI, p stats.PeerStat) string { return formatBytes(p.SentBytes) }, nil},
+	{"recv_bytes", "Recv", "recv_bytes", func(_ *TUI, p stats.PeerStat) string { return formatBytes(p.RecvBytes) }, nil},
+	{"sent_pkts", "Sent (Pkts)", "sent_pkts", func(_ *TUI, p stats.PeerStat) string { return formatPkts(p.SentPkts) }, nil},
+	{"recv_pkts", "Recv (Pkts)", "recv_pkts", func(_ *TUI, p stats.PeerStat) string { return formatPkts(p.RecvPkts) }, nil},
+	{"errors", "Errors", "errors", func(_ *TUI, p stats.PeerStat) string { return formatPkts(p.Errors) }, nil},
+	{"muted", "Muted", "", func(_ *TUI, p stats.PeerStat) string { return formatMuted(p) }, nil},
+	{"stations", "Stations", "stations", func(_ *TUI, p stats.PeerStat) string { return fmt.Sprint(p.Stations) }, nil},
+	{"latency", "Latency", "latency", func(_ *TUI, p stats.PeerStat) string { return fmt.Sprintf("%.1f ms", p.LatencyMs) }, nil},
+	{"country", "Country", "country", func(_ *TUI, p stats.PeerStat) string { return p.Country }, nil},
+	{"rx_rate", "RX Rate", "rx_rate", func(t *TUI, p stats.PeerStat) string { return t.liveRateText(p.ID, false) },
+		func(t *TUI, p stats.PeerStat) (tcell.Color, bool) { return t.rateColor(p.ID, false) }},
+	{"tx_rate", "TX Rate", "tx_rate", func(t *TUI, p stats.PeerStat) string { return t.liveRateText(p.ID, true) },
+		func(t *TUI, p stats.PeerStat) (tcell.Color, bool) { return t.rateColor(p.ID, true) }},
+}
+
+// liveRate is the RX or TX rate of the peer with id over the last refresh,
+// in bytes per second, once it has been measured.
+func (t *TUI) liveRate(id string, tx bool) (uint64, bool) {
+	h := t.peerHistory[id]
+	if h == nil || !h.measured {
+		return 0, false
+	}
+	if tx {
+		return h.txLive, true
+	}
+	return h.rxLive, true
+}
+
+func (t *TUI) liveRateText(id string, tx bool) string {
+	rate, ok := t.liveRate(id, tx)
+	if !ok {
+		return "-"
+	}
+	text := formatBytes(rate) + "/s"
+	if t.accessible {
+		// Said in words, as the color is not seen.
+		switch t.rateLevel(rate) {
+		case 2:
+			text += " (very high)"
+		case 1:
+			text += " (high)"
+		}
+	}
+	return text
+}
+
+// rateLevel is 2 for a rate at or over peer_rate_alert, 1 for one at or
+// over peer_rate_warn, and 0 otherwise.
+func (t *TUI) rateLevel(rate uint64) int {
+	switch {
+	case t.cfg.PeerRateAlert > 0 && rate >= uint64(t.cfg.PeerRateAlert):
+		return 2
+	case t.cfg.PeerRateWarn > 0 && rate >= uint64(t.cfg.PeerRateWarn):
+		return 1
+	}
+	return 0
+}
+
+// rateColor flags a peer sending or receiving over the thresholds, the
+// question most often asked of the table being which peer floods it.
+func (t *TUI) rateColor(id string, tx bool) (tcell.Color, bool) {
+	rate, _ := t.liveRate(id, tx)
+	switch t.rateLevel(rate) {
+	case 2:
+		return tcell.ColorRed, true
+	case 1:
+		return tcell.ColorYellow, true
+	}
+	return 0, false
+}
+
+// sortByRate orders peers by their live RX or TX rate for the sort_field
+// rx_rate or tx_rate, which the relay does not measure and leaves in ID
+// order.
+func (t *TUI) sortByRate(peers []stats.PeerStat) []stats.PeerStat {
+	tx := t.cfg.SortField == "tx_rate"
+	if !tx && t.cfg.SortField != "rx_rate" {
+		return peers
+	}
+	peers = slices.Clone(peers)
+	slices.SortStableFunc(peers, func(a, b stats.PeerStat) int {
+		ra, _ := t.liveRate(a.ID, tx)
+		rb, _ := t.liveRate(b.ID, tx)
+		if t.cfg.SortReverse {
+			ra, rb = rb, ra
+		}
+		return cmp.Compare(ra, rb)
+	})
+	return peers
+}
+
+func findColumn(key string) (peerColumn, bool) {
+	i := slices.IndexFunc(peerColumns, func(c peerColumn) bool { return c.key == key })
+	if i < 0 {
+		return peerColumn{}, false
+	}
+	return peerColumns[i], true
+}
+
+// tableLayout is the keys of the built-in columns the peer table shows,
+// in order.
+func (t *TUI) tableLayout() []string {
+	if len(t.cfg.PeerTable) == 0 {
+		return config.DefaultPeerTable
+	}
+	return t.cfg.PeerTable
+}
+
+// tableColumns are the built-in columns the peer table shows, in order.
+func (t *TUI) tableColumns() []peerColumn {
+	var cols []peerColumn
+	for _, key := range t.tableLayout() {
+		if c, ok := findColumn(key); ok {
+			cols = append(cols, c)
+		}
+	}
+	return cols
+}
+
+// setTableLayout shows the built-in columns keys in the peer table and
+// saves the layout.
+func (t *TUI) setTableLayout(keys []string) {
+	t.cfg.PeerTable = keys
+	if t.configPath != "" {
+		config.SaveConfig(t.configPath, t.cfg)
+	}
+	t.drawPeers(t.statsFunc())
+}
+
+// showColumnChooser lists the built-in columns of the peer table, those
+// shown first in their order, and lets them be shown, hidden and moved.
+func (t *TUI) showColumnChooser() {
+	list := tview.NewList().ShowSecondaryText(false)
+	var refresh func(selected int)
+	refresh = func(selected int) {
+		shown := t.tableLayout()
+		keys := slices.Clone(shown)
+		for _, c := range peerColumns {
+			if !slices.Contains(keys, c.key) {
+				keys = append(keys, c.key)
+			}
+		}
+		list.Clear()
+		for _, key := range keys {
+			c, _ := findColumn(key)
+			mark := " "
+			if slices.Contains(shown, key) {
+				mark = "x"
+			}
+			list.AddItem(tview.Escape(fmt.Sprintf("[%s] %s", mark, c.name)), key, 0, nil)
+		}
+		list.SetCurrentItem(max(0, min(selected, list.GetItemCount()-1)))
+	}
+	refresh(0)
+
+	// move moves the shown column at cur by delta among the shown ones.
+	move := func(cur, delta int) {
+		keys := slices.Clone(t.tableLayout())
+		if cur+delta < 0 || cur+delta >= len(keys) || cur >= len(keys) {
+			return
+		}
+		keys[cur], keys[cur+delta] = keys[cur+delta], keys[cur]
+		t.setTableLayout(keys)
+		refresh(cur + delta)
+	}
+
+	// toggle shows or hides the column at cur. The last shown column
+	// stays, as the table selects peers by its first.
+	toggle := func(cur int) {
+		_, key := list.GetItemText(cur)
+		keys := slices.Clone(t.tableLayout())
+		if i := slices.Index(keys, key); i < 0 {
+			keys = append(keys, key)
+		} else if len(keys) > 1 {
+			keys = slices.Delete(keys, i, i+1)
+		} else {
+			return
+		}
+		t.setTableLayout(keys)
+		refresh(cur)
+	}
+
+	list.SetSelectedFunc(func(index int, _, _ string, _ rune) { toggle(index) })
+	list.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		cur := list.GetCurrentItem()
+		switch {
+		case event.Key() == tcell.KeyEscape:
+			t.pages.RemovePage("columns")
+			return nil
+		case event.Rune() == ' ':
+			toggle(cur)
+			return nil
+		case event.Rune() == 'K' || (event.Key() == tcell.KeyUp && event.Modifiers()&tcell.ModShift != 0):
+			move(cur, -1)
+			return nil
+		case event.Rune() == 'J' || (event.Key() == tcell.KeyDown && event.Modifiers()&tcell.ModShift != 0):
+			move(cur, 1)
+			return nil
+		case event.Rune() == 'r':
+			t.setTableLayout([]string{})
+			refresh(0)
+			return nil
+		}
+		return event
+	})
+
+	help := tview.NewTextView().
+		SetDynamicColors(true).
+		SetText(t.helpLine("columns"))
+
+	flex := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(list, 0, 1, true).
+		AddItem(help, 1, 0, false)
+	flex.SetBorder(true).SetTitle("Peer Table Columns")
+
+	t.pages.AddPage("columns", t.center(flex, 60, len(peerColumns)+3), true, true)
+	t.app.SetFocus(list)
+}
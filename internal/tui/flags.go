@@ -0,0 +1,92 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Flags page: features switched on and off without a restart
+
+package tui
+
+import (
+	"github.com/gdamore/tcell/v2"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+	"github.com/rivo/tview"
+)
+
+// FlagManager is what the flags page needs from the relay server.
+type FlagManager interface {
+	Flags() []stats.FeatureFlag
+	SetFlag(name string, enabled bool, user string) error
+	FlagChanges() []stats.FlagChange
+}
+
+// SetFlags enables the feature flags page (Ctrl+G).
+func (t *TUI) SetFlags(fm FlagManager) {
+	t.flags = fm
+}
+
+func (t *TUI) showFlags() {
+	if t.flags == nil {
+		return
+	}
+
+	table := tview.NewTable().SetFixed(1, 0).SetSelectable(true, false)
+	changes := tview.NewTextView().SetDynamicColors(true)
+	refresh := func() {
+		table.Clear()
+		for i, h := range []string{"Flag", "State", "Description"} {
+			table.SetCell(0, i, tview.NewTableCell(h).SetTextColor(tcell.ColorYellow).SetSelectable(false))
+		}
+		for i, f := range t.flags.Flags() {
+			state, color := "off", tcell.ColorGray
+			if f.Enabled {
+				state, color = "on", tcell.ColorGreen
+			}
+			table.SetCell(i+1, 0, tview.NewTableCell(f.Name).SetReference(f))
+			table.SetCell(i+1, 1, tview.NewTableCell(state).SetTextColor(color))
+			table.SetCell(i+1, 2, tview.NewTableCell(tview.Escape(f.Description)).SetExpansion(1))
+		}
+		text := "[gray]No flags changed since the start"
+		if recent := t.flags.FlagChanges(); len(recent) > 0 {
+			c := recent[0]
+			state := "off"
+			if c.Enabled {
+				state = "on"
+			}
+			text = "Last change: " + c.Time.Format("01-02 15:04:05") + " " + c.Flag + " " + state + " by " + tview.Escape(c.By)
+		}
+		changes.SetText(text)
+	}
+	refresh()
+
+	table.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch {
+		case event.Key() == tcell.KeyEscape:
+			t.pages.RemovePage("flags")
+			return nil
+		case event.Key() == tcell.KeyEnter || event.Rune() == ' ':
+			row, _ := table.GetSelection()
+			if f, ok := table.GetCell(row, 0).GetReference().(stats.FeatureFlag); ok {
+				if err := t.flags.SetFlag(f.Name, !f.Enabled, "tui"); err != nil {
+					t.showError(err.Error())
+				}
+				refresh()
+			}
+			return nil
+		case event.Rune() == 'r':
+			refresh()
+			return nil
+		}
+		return event
+	})
+
+	help := tview.NewTextView().
+		SetDynamicColors(true).
+		SetText(t.helpLine("flags"))
+
+	flex := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(table, 0, 1, true).
+		AddItem(changes, 1, 0, false).
+		AddItem(help, 1, 0, false)
+	flex.SetBorder(true).SetTitle("Feature Flags")
+
+	t.pages.AddPage("flags", t.center(flex, 90, 14), true, true)
+	t.app.SetFocus(table)
+}
@@ -0,0 +1,231 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Traffic graph: RX and TX rates in braille with an autoscaled axis
+
+package tui
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+)
+
+// axisWidth is the width of the labels of the graph's Y axis and its line.
+const axisWidth = 12
+
+// graphPoints is how many 500ms points the graph keeps: an hour.
+const graphPoints = 7200
+
+// SetHistory gives the TUI the relay's traffic history, which the graph
+// starts from.
+func (t *TUI) SetHistory(history func(span time.Duration) ([]stats.Sample, time.Duration)) {
+	t.history = history
+}
+
+// seedTraffic fills the graph's points from the relay's history samples,
+// taken every minute or so, up to the totals in s now. The points between
+// two samples are interpolated.
+func (t *TUI) seedTraffic(samples []stats.Sample, s stats.Stats, now time.Time) {
+	knots := make([]stats.Sample, 0, len(samples)+1)
+	for _, k := range samples {
+		if k.Time.Before(now) && k.Received <= s.TotalReceived && k.Forwarded <= s.TotalForwarded {
+			knots = append(knots, k)
+		}
+	}
+	knots = append(knots, stats.Sample{Time: now, Received: s.TotalReceived, Forwarded: s.TotalForwarded,
+		ReceivedBytes: s.ReceivedBytes, ForwardedBytes: s.ForwardedBytes})
+
+	n := min(int(now.Sub(knots[0].Time)/(500*time.Millisecond))+1, graphPoints)
+	k := 0
+	for i := range n {
+		at := now.Add(-time.Duration(n-1-i) * 500 * time.Millisecond)
+		for k < len(knots)-1 && !knots[k+1].Time.After(at) {
+			k++
+		}
+		a, b := knots[k], knots[min(k+1, len(knots)-1)]
+		f := 0.0
+		if span := b.Time.Sub(a.Time); span > 0 && at.After(a.Time) {
+			f = min(float64(at.Sub(a.Time))/float64(span), 1)
+		}
+		t.rxHistory = append(t.rxHistory, lerp(a.Received, b.Received, f))
+		t.txHistory = append(t.txHistory, lerp(a.Forwarded, b.Forwarded, f))
+		t.rxBytes = append(t.rxBytes, lerp(a.ReceivedBytes, b.ReceivedBytes, f))
+		t.txBytes = append(t.txBytes, lerp(a.ForwardedBytes, b.ForwardedBytes, f))
+	}
+	t.sampledAt = now
+	t.trimTraffic()
+}
+
+// lerp is the total a fraction f of the way from a to b.
+func lerp(a, b uint64, f float64) uint64 {
+	if b < a {
+		return b
+	}
+	return a + uint64(float64(b-a)*f)
+}
+
+// trimTraffic drops the points older than an hour.
+func (t *TUI) trimTraffic() {
+	if n := len(t.rxHistory) - graphPoints; n > 0 {
+		t.rxHistory = t.rxHistory[n:]
+		t.txHistory = t.txHistory[n:]
+		t.rxBytes = t.rxBytes[n:]
+		t.txBytes = t.txBytes[n:]
+	}
+	t.panGraph(0)
+}
+
+// graphCols is the number of columns the graph has room for.
+func (t *TUI) graphCols() int {
+	_, _, width, _ := t.graphView.GetInnerRect()
+	return max(width-axisWidth, 0)
+}
+
+// panGraph moves the graph a quarter of its width back in time, or
+// forward for a negative dir, staying within the points kept.
+func (t *TUI) panGraph(dir int) {
+	cols := t.graphCols()
+	shown := cols * t.graphStep
+	t.graphOffset += dir * max(shown/4, 1)
+	t.graphOffset = max(0, min(t.graphOffset, len(t.rxHistory)-1-shown))
+	if dir != 0 {
+		t.redraw(stats.ChangeCounters)
+	}
+}
+
+// niceCeil rounds v up to 1, 2 or 5 times a power of ten, so the axis
+// reads in round numbers.
+func niceCeil(v float64) float64 {
+	if v <= 0 {
+		return 1
+	}
+	exp := math.Pow(10, math.Floor(math.Log10(v)))
+	for _, m := range []float64{1, 2, 5, 10} {
+		if v <= m*exp {
+			return m * exp
+		}
+	}
+	return 10 * exp
+}
+
+// formatRate labels a rate per second in packets or bytes.
+func formatRate(v float64, bytes bool) string {
+	if bytes {
+		return formatBytes(uint64(v)) + "/s"
+	}
+	return formatPkts(uint64(v)) + " pps"
+}
+
+// graphRates turns the totals sampled every 500ms into rates per second,
+// one per column of step points, the last column ending offset points
+// before the newest sample.
+func graphRates(totals []uint64, cols, step, offset int) []float64 {
+	rates := make([]float64, cols)
+	secs := float64(step) / 2
+	for i := range rates {
+		end := len(totals) - 1 - offset - (cols-1-i)*step
+		if end-step < 0 {
+			continue
+		}
+		rates[i] = float64(totals[end]-totals[end-step]) / secs
+	}
+	return rates
+}
+
+// plotGraph draws rx and tx as bars of braille dots, four rows to a line:
+// RX in the left column of dots of each cell and TX in the right, green,
+// blue and aqua where they meet. top is the rate at the top of the plot.
+func plotGraph(rx, tx []float64, top float64, height int) []string {
+	level := func(v float64) int {
+		if v <= 0 {
+			return 0
+		}
+		return max(1, int(math.Round(v/top*float64(height*4))))
+	}
+	lines := make([]string, height)
+	for row := range lines {
+		var b strings.Builder
+		for i := range rx {
+			rl, tl := level(rx[i]), level(tx[i])
+			var dots rune
+			for dy := range 4 {
+				d := (height-1-row)*4 + 3 - dy // dots from the bottom
+				if d < rl {
+					dots |= brailleDots[dy][0]
+				}
+				if d < tl {
+					dots |= brailleDots[dy][1]
+				}
+			}
+			if dots == 0 {
+				b.WriteByte(' ')
+				continue
+			}
+			color := "aqua"
+			switch {
+			case dots&0x47 == 0:
+				color = "blue"
+			case dots&0xb8 == 0:
+				color = "green"
+			}
+			fmt.Fprintf(&b, "[%s]%c[-]", color, 0x2800+dots)
+		}
+		lines[row] = b.String()
+	}
+	return lines
+}
+
+// updateGraph samples the totals and draws the last width columns of
+// them, as packets or bytes per second, with the Y axis on the left.
+func (t *TUI) updateGraph(s stats.Stats, now time.Time) {
+	t.sampleTraffic(s, now)
+
+	unit, rxTotals, txTotals := "packets/s", t.rxHistory, t.txHistory
+	if t.graphBytes {
+		unit, rxTotals, txTotals = "bytes/s", t.rxBytes, t.txBytes
+	}
+	cols := t.graphCols()
+	timeRange := time.Duration(cols*t.graphStep) * 500 * time.Millisecond
+	title := fmt.Sprintf("Traffic Graph (Last %v, %s)", timeRange.Round(time.Second), unit)
+
+	_, _, _, height := t.graphView.GetInnerRect()
+	if len(rxTotals) < 2 || cols == 0 || height <= 0 {
+		t.graphView.SetTitle(title)
+		return
+	}
+	rx := graphRates(rxTotals, cols, t.graphStep, t.graphOffset)
+	tx := graphRates(txTotals, cols, t.graphStep, t.graphOffset)
+	if t.graphOffset > 0 {
+		// Panned back: the span shown, and the rates at its right edge.
+		end := t.sampledAt.Add(-time.Duration(t.graphOffset) * 500 * time.Millisecond)
+		title = fmt.Sprintf("Traffic Graph (%s–%s, at %s: RX %s TX %s)",
+			end.Add(-timeRange).Format("15:04:05"), end.Format("15:04:05"), end.Format("15:04:05"),
+			formatRate(rx[cols-1], t.graphBytes), formatRate(tx[cols-1], t.graphBytes))
+	}
+	t.graphView.SetTitle(title + "  [green]⡇ RX  [blue]⢸ TX[-]")
+	var peak float64
+	for i := range rx {
+		peak = max(peak, rx[i], tx[i])
+	}
+	top := niceCeil(peak)
+
+	lines := plotGraph(rx, tx, top, height)
+	for row := range lines {
+		label := ""
+		switch row {
+		case 0:
+			label = formatRate(top, t.graphBytes)
+		case height / 2:
+			if height > 2 {
+				label = formatRate(top*float64(height-row)/float64(height), t.graphBytes)
+			}
+		case height - 1:
+			label = "0"
+		}
+		lines[row] = fmt.Sprintf("[gray]%10s ┤[-]", label) + lines[row]
+	}
+	t.graphView.SetText(strings.Join(lines, "\n"))
+}
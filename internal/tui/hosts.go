@@ -0,0 +1,68 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Hosts page: IPX addresses seen locally and behind peers
+
+package tui
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+func (t *TUI) showHosts() {
+	table := tview.NewTable().SetFixed(1, 0).SetSelectable(true, false)
+	refresh := func() {
+		table.Clear()
+		for i, h := range []string{"Network", "Node", "Behind", "Frames", "First Seen", "Last Seen"} {
+			table.SetCell(0, i, tview.NewTableCell(h).SetTextColor(tcell.ColorYellow).SetSelectable(false))
+		}
+		hosts := t.statsFunc().Hosts
+		if len(hosts) == 0 {
+			table.SetCell(1, 0, tview.NewTableCell("No IPX hosts seen yet").SetTextColor(tcell.ColorGray))
+		}
+		for i, h := range hosts {
+			behind := h.Via
+			if h.Behind != "" {
+				behind = fmt.Sprintf("%s %s", h.Via, h.Behind)
+			}
+			color := tcell.ColorWhite
+			if h.Via == "peer" {
+				color = tcell.ColorAqua
+			}
+			for col, v := range []string{
+				h.Network, h.Node, tview.Escape(behind), formatPkts(h.Frames),
+				h.FirstSeen.Format("01-02 15:04:05"), time.Since(h.LastSeen).Round(time.Second).String() + " ago",
+			} {
+				table.SetCell(i+1, col, tview.NewTableCell(v).SetTextColor(color).SetExpansion(1))
+			}
+		}
+	}
+	refresh()
+
+	table.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch {
+		case event.Key() == tcell.KeyEscape:
+			t.pages.RemovePage("hosts")
+			return nil
+		case event.Rune() == 'r':
+			refresh()
+			return nil
+		}
+		return event
+	})
+
+	help := tview.NewTextView().
+		SetDynamicColors(true).
+		SetText(t.helpLine("hosts") + "  [aqua]behind a peer[-]")
+
+	flex := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(table, 0, 1, true).
+		AddItem(help, 1, 0, false)
+	flex.SetBorder(true).SetTitle("IPX Hosts")
+
+	t.pages.AddPage("hosts", t.center(flex, 100, 24), true, true)
+	t.app.SetFocus(table)
+}
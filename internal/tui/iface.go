@@ -0,0 +1,215 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Capture interface page: link state, IPX seen on each, and the BPF filter
+
+package tui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/mlapointe/ipxtransporter/internal/capture"
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/rivo/tview"
+)
+
+// probeTime is how long each interface is watched for IPX frames.
+const probeTime = 2 * time.Second
+
+// SetCapture lets the interface page move the capture to another
+// interface or filter without a restart.
+func (t *TUI) SetCapture(restart func(iface, filter string) error) {
+	t.onCapture = restart
+}
+
+// linkCell is the link state of the interface named name.
+func linkCell(name string) (*tview.TableCell, string) {
+	link, err := capture.LinkState(name)
+	switch {
+	case err != nil:
+		return tview.NewTableCell("-").SetTextColor(tcell.ColorGray), "-"
+	case link.Running:
+		return tview.NewTableCell("up").SetTextColor(tcell.ColorGreen), orDash(link.MAC)
+	case link.Up:
+		return tview.NewTableCell("no carrier").SetTextColor(tcell.ColorYellow), orDash(link.MAC)
+	default:
+		return tview.NewTableCell("down").SetTextColor(tcell.ColorRed), orDash(link.MAC)
+	}
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// probeCell describes what a probe of an interface found.
+func probeCell(n int, err error) *tview.TableCell {
+	switch {
+	case err != nil:
+		return tview.NewTableCell(tview.Escape("failed: " + err.Error())).SetTextColor(tcell.ColorRed)
+	case n == 0:
+		return tview.NewTableCell("none").SetTextColor(tcell.ColorGray)
+	default:
+		return tview.NewTableCell(fmt.Sprintf("%d frames", n)).SetTextColor(tcell.ColorGreen)
+	}
+}
+
+// filterStatus says whether filter compiles.
+func filterStatus(filter string) (string, bool) {
+	if filter == "" {
+		return "Empty filter: the capture uses " + capture.DefaultFilter, true
+	}
+	if err := capture.CheckFilter(filter); err != nil {
+		return "[red]Filter does not compile: " + tview.Escape(err.Error()), false
+	}
+	return "[green]Filter compiles", true
+}
+
+// showInterfaceSelection lists the capture interfaces with their link
+// state, MAC address and the IPX frames a short probe sees on each, and
+// moves the capture to the one chosen with the filter below, at once
+// where the relay allows it.
+func (t *TUI) showInterfaceSelection() {
+	ifaces, err := capture.ListInterfaces()
+	if err != nil {
+		t.showError("Failed to list interfaces: " + err.Error())
+		return
+	}
+
+	table := tview.NewTable().SetFixed(1, 0).SetSelectable(true, false)
+	status := tview.NewTextView().SetDynamicColors(true)
+	filter := tview.NewInputField().SetLabel("BPF Filter: ")
+	filter.SetText(t.cfg.CaptureFilter)
+
+	// probe watches every interface for IPX at once, filling in the last
+	// column as each probe ends.
+	probe := func() {
+		for i, name := range ifaces {
+			table.SetCell(i+1, 3, tview.NewTableCell("probing...").SetTextColor(tcell.ColorYellow))
+			go func() {
+				n, err := capture.Probe(name, probeTime)
+				t.app.QueueUpdateDraw(func() {
+					table.SetCell(i+1, 3, probeCell(n, err))
+				})
+			}()
+		}
+	}
+	for i, h := range []string{"Interface", "Link", "MAC", fmt.Sprintf("IPX in %s", probeTime)} {
+		table.SetCell(0, i, tview.NewTableCell(h).SetTextColor(tcell.ColorYellow).SetSelectable(false))
+	}
+	markCurrent := func() {
+		for i, name := range ifaces {
+			text := "  " + name
+			if name == t.cfg.Interface {
+				text = "* " + name
+			}
+			table.SetCell(i+1, 0, tview.NewTableCell(text).SetReference(name))
+		}
+	}
+	markCurrent()
+	for i, name := range ifaces {
+		link, mac := linkCell(name)
+		table.SetCell(i+1, 1, link)
+		table.SetCell(i+1, 2, tview.NewTableCell(mac))
+	}
+	probe()
+
+	showStatus := func() {
+		text, _ := filterStatus(strings.TrimSpace(filter.GetText()))
+		if e := t.statsFunc().CaptureError; e != "" {
+			text = "[red]Capture: " + tview.Escape(e) + "[-]\n" + text
+		}
+		status.SetText(text)
+	}
+	showStatus()
+	filter.SetChangedFunc(func(string) { showStatus() })
+
+	closePage := func() {
+		t.pages.RemovePage("iface_select")
+	}
+	apply := func() {
+		row, _ := table.GetSelection()
+		iface, ok := table.GetCell(row, 0).GetReference().(string)
+		if !ok {
+			return
+		}
+		bpf := strings.TrimSpace(filter.GetText())
+		if text, ok := filterStatus(bpf); !ok {
+			status.SetText(text)
+			return
+		}
+		if t.onCapture == nil {
+			t.cfg.Interface, t.cfg.CaptureFilter = iface, bpf
+			markCurrent()
+			status.SetText("[yellow]Interface set to " + iface + ". Restart required for changes to take effect.")
+			return
+		}
+		status.SetText(fmt.Sprintf("[yellow]Restarting the capture on %s...", iface))
+		go func() {
+			err := t.onCapture(iface, bpf)
+			t.app.QueueUpdateDraw(func() {
+				if err != nil {
+					status.SetText("[red]" + tview.Escape(err.Error()) + "[-]\nThe capture goes on as it was.")
+					return
+				}
+				t.cfg.Interface, t.cfg.CaptureFilter = iface, bpf
+				if t.configPath != "" {
+					config.SaveConfig(t.configPath, t.cfg)
+				}
+				markCurrent()
+				status.SetText(fmt.Sprintf("[green]Capturing on %s with filter %q", iface, bpf))
+			})
+		}()
+	}
+
+	table.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch {
+		case event.Key() == tcell.KeyEscape:
+			closePage()
+			return nil
+		case event.Key() == tcell.KeyEnter:
+			apply()
+			return nil
+		case event.Key() == tcell.KeyTab:
+			t.app.SetFocus(filter)
+			return nil
+		case event.Rune() == 'p':
+			probe()
+			return nil
+		}
+		return event
+	})
+	filter.SetDoneFunc(func(key tcell.Key) {
+		switch key {
+		case tcell.KeyEscape:
+			closePage()
+		case tcell.KeyEnter:
+			apply()
+		case tcell.KeyTab, tcell.KeyBacktab:
+			t.app.SetFocus(table)
+		}
+	})
+
+	help := tview.NewTextView().
+		SetDynamicColors(true).
+		SetText(t.helpLine("iface_select"))
+
+	flex := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(table, 0, 1, true).
+		AddItem(filter, 1, 0, false).
+		AddItem(status, 2, 0, false).
+		AddItem(help, 1, 0, false)
+	flex.SetBorder(true).SetTitle("Capture Interface")
+
+	t.pages.AddPage("iface_select", t.center(flex, 100, 22), true, true)
+	for row := 1; row <= len(ifaces); row++ {
+		if name, _ := table.GetCell(row, 0).GetReference().(string); name == t.cfg.Interface {
+			table.Select(row, 0)
+		}
+	}
+	t.app.SetFocus(table)
+}
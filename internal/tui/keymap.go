@@ -0,0 +1,246 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Keymap: every key of the TUI by page, for the help overlay and help lines
+
+package tui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// binding is a key and what it does: briefly for the footer and the help
+// line of its page, in full for the help overlay. A binding with when
+// works only if the relay offers what it needs, and is listed only then.
+type binding struct {
+	key    string
+	short  string
+	long   string
+	footer bool // also in the footer of the main screen
+	when   func(t *TUI) bool
+}
+
+// keyGroup is the keys of one page, by its name in the pages of the TUI.
+// The keys of the first group work on every page.
+type keyGroup struct {
+	page  string
+	title string
+	keys  []binding
+}
+
+// keymap lists every key of the TUI. A key added to a page is added here
+// too, so the page's help line and the help overlay show it.
+var keymap = []keyGroup{
+	{"", "Everywhere", []binding{
+		{key: "?", short: "Help", long: "This list of keys", footer: true},
+		{key: "F1", short: "Config", long: "Configuration editor, with backups of earlier configs", footer: true},
+		{key: "F2", short: "Iface", long: "Capture interface: link state, IPX seen, BPF filter"},
+		{key: "F3", short: "Whois", long: "WHOIS details of the selected peer"},
+		{key: "F4", short: "Settings", long: "UI settings: sorting, theme and peer table columns", footer: true},
+		{key: "F5", short: "Demo", long: "Demo mode settings and traffic pattern",
+			when: func(t *TUI) bool { return t.statsFunc().DemoProps != nil }},
+		{key: "F6", short: "Add Peer", long: "Add a peer, with its transport and key fingerprint", footer: true},
+		{key: "F7", short: "Rules", long: "Filter and priority rules",
+			when: func(t *TUI) bool { return t.rules != nil }},
+		{key: "F8", short: "Schedule", long: "Scheduled bans and peer access windows",
+			when: func(t *TUI) bool { return t.schedule != nil }},
+		{key: "F9", short: "LAN", long: "Nodes found on the LAN over mDNS",
+			when: func(t *TUI) bool { return t.onLANRefresh != nil }},
+		{key: "F10", short: "Rooms", long: "Rooms: create, join by token, invite",
+			when: func(t *TUI) bool { return t.rooms != nil }},
+		{key: "F11", short: "Traffic", long: "Traffic by protocol or game (IPX socket)"},
+		{key: "F12", short: "Hosts", long: "IPX hosts seen locally and behind peers"},
+		{key: "Ctrl+L", short: "Logs", long: "Logs, with a filter, follow mode and pause"},
+		{key: "Ctrl+T", short: "Traces", long: "The paths traced packets took through the mesh"},
+		{key: "Ctrl+B", short: "Bans", long: "Bans"},
+		{key: "Ctrl+W", short: "Allow", long: "Allow list"},
+		{key: "Ctrl+O", short: "Audit", long: "Connection attempts"},
+		{key: "Ctrl+G", short: "Flags", long: "Feature flags"},
+		{key: "Ctrl+E", short: "CSV", long: "Export the peer table to CSV"},
+		{key: "Ctrl+P", short: "Map", long: "Topology tree or world map of the peers",
+			when: func(t *TUI) bool { return t.mapPane != nil }},
+		{key: "Ctrl+C", short: "Exit", long: "Exit", footer: true},
+	}},
+	{"main", "Peer table", []binding{
+		{key: "Enter", short: "Actions", long: "Actions on the selected peer, or on the marked peers", footer: true},
+		{key: "Double-click", short: "Details", long: "Detail page of the peer, updated live"},
+		{key: "/", short: "Search", long: "Search by ID, name, IP, hostname or country", footer: true},
+		{key: "Space", short: "Mark", long: "Mark or unmark the selected peer", footer: true},
+		{key: "f", short: "Mark By", long: "Mark peers by a filter"},
+		{key: "Esc", short: "Clear", long: "Clear the marks, or else the search"},
+		{key: "Click a header", short: "Sort", long: "Sort by the column, a second click reverses"},
+		{key: "+/-", short: "Zoom", long: "Zoom the traffic graph (also Right/Left)"},
+		{key: "Shift+←/→", short: "Pan", long: "Pan the traffic graph through the last hour"},
+		{key: "u", short: "Units", long: "Traffic graph in packets or bytes per second",
+			when: func(t *TUI) bool { return !t.accessible }},
+	}},
+	{"peer_detail", "Peer detail", []binding{
+		{key: "Enter", short: "Actions", long: "Actions on the peer"},
+		{key: "w", short: "WHOIS", long: "WHOIS details of the peer"},
+		{key: "Esc", short: "Close", long: "Close"},
+	}},
+	{"iface_select", "Capture interface", []binding{
+		{key: "Enter", short: "Capture", long: "Capture on the selected interface with the filter"},
+		{key: "Tab", short: "Filter", long: "Switch between the interfaces and the BPF filter"},
+		{key: "p", short: "Probe", long: "Watch every interface for IPX frames again"},
+		{key: "Esc", short: "Close", long: "Close"},
+	}},
+	{"logs", "Logs", []binding{
+		{key: "/", short: "Filter", long: "Show only lines containing the text"},
+		{key: "f", short: "Follow", long: "Follow new lines, or stay where scrolled"},
+		{key: "p", short: "Pause", long: "Pause, counting the lines held back"},
+		{key: "Esc", short: "Close", long: "Close"},
+	}},
+	{"rules", "Rules", []binding{
+		{key: "a", short: "Add", long: "Add a rule"},
+		{key: "Enter", short: "Edit", long: "Edit the selected rule"},
+		{key: "x", short: "Delete", long: "Delete the selected rule"},
+		{key: "J/K", short: "Move", long: "Move the rule down or up (also Shift+↓/↑)"},
+		{key: "Tab", short: "Switch list", long: "Switch between filter and priority rules"},
+		{key: "Esc", short: "Close", long: "Close"},
+	}},
+	{"schedule", "Schedule", []binding{
+		{key: "a", short: "Add", long: "Add a scheduled ban or access window"},
+		{key: "x", short: "Delete", long: "Delete the selected entry"},
+		{key: "Esc", short: "Close", long: "Close"},
+	}},
+	{"lan_nodes", "LAN", []binding{
+		{key: "Enter", short: "Add Peer", long: "Add the selected node as a peer"},
+		{key: "r", short: "Refresh", long: "Refresh"},
+		{key: "Esc", short: "Close", long: "Close"},
+	}},
+	{"rooms", "Rooms", []binding{
+		{key: "c", short: "Create", long: "Create a room"},
+		{key: "j", short: "Join by Token", long: "Join a room by its invite token"},
+		{key: "Enter", short: "Join Selected", long: "Join the selected room"},
+		{key: "l", short: "Leave", long: "Leave the room"},
+		{key: "i", short: "Invite", long: "Show an invite to the room"},
+		{key: "r", short: "Refresh", long: "Refresh"},
+		{key: "Esc", short: "Close", long: "Close"},
+	}},
+	{"sockets", "Traffic", []binding{
+		{key: "r", short: "Refresh", long: "Refresh"},
+		{key: "Esc", short: "Close", long: "Close"},
+	}},
+	{"hosts", "Hosts", []binding{
+		{key: "r", short: "Refresh", long: "Refresh"},
+		{key: "Esc", short: "Close", long: "Close"},
+	}},
+	{"traces", "Traces", []binding{
+		{key: "r", short: "Refresh", long: "Refresh"},
+		{key: "Esc", short: "Close", long: "Close"},
+	}},
+	{"bans", "Bans", []binding{
+		{key: "a", short: "Ban", long: "Ban an ID or host"},
+		{key: "x", short: "Unban (every ban of the target)", long: "Lift every ban of the selected target"},
+		{key: "Esc", short: "Close", long: "Close"},
+	}},
+	{"allowlist", "Allow list", []binding{
+		{key: "e", short: "Enforce on/off", long: "Enforce the allow list, or stop"},
+		{key: "a", short: "Allow", long: "Allow an ID or host"},
+		{key: "x", short: "Remove", long: "Remove the selected entry"},
+		{key: "Esc", short: "Close", long: "Close"},
+	}},
+	{"audit", "Connection attempts", []binding{
+		{key: "f", short: "Filter by outcome", long: "Show the attempts of the next outcome in turn"},
+		{key: "r", short: "Refresh", long: "Refresh"},
+		{key: "Esc", short: "Close", long: "Close"},
+	}},
+	{"flags", "Feature flags", []binding{
+		{key: "Enter/Space", short: "Toggle", long: "Turn the selected flag on or off"},
+		{key: "r", short: "Refresh", long: "Refresh"},
+		{key: "Esc", short: "Close", long: "Close"},
+	}},
+	{"columns", "Peer table columns", []binding{
+		{key: "Enter/Space", short: "Show/Hide", long: "Show or hide the selected column"},
+		{key: "J/K", short: "Move", long: "Move the column right or left (also Shift+↓/↑)"},
+		{key: "r", short: "Reset", long: "Back to the default columns"},
+		{key: "Esc", short: "Close", long: "Close"},
+	}},
+}
+
+// keys are the bindings of page that work in this relay.
+func (t *TUI) keys(page string) []binding {
+	var out []binding
+	for _, g := range keymap {
+		if g.page != page {
+			continue
+		}
+		for _, b := range g.keys {
+			if b.when == nil || b.when(t) {
+				out = append(out, b)
+			}
+		}
+	}
+	return out
+}
+
+func joinKeys(keys []binding) string {
+	parts := make([]string, len(keys))
+	for i, b := range keys {
+		parts[i] = b.key + ": " + b.short
+	}
+	return "[blue]" + strings.Join(parts, "  ")
+}
+
+// helpLine is the line of keys at the foot of page.
+func (t *TUI) helpLine(page string) string {
+	return joinKeys(t.keys(page))
+}
+
+// footer is the line of keys of the main screen: the few most used, and ?
+// for the rest.
+func (t *TUI) footer() string {
+	var keys []binding
+	for _, page := range []string{"", "main"} {
+		for _, b := range t.keys(page) {
+			if b.footer {
+				keys = append(keys, b)
+			}
+		}
+	}
+	return joinKeys(keys)
+}
+
+// showKeys opens the help overlay, listing every key by page, scrolled to
+// the keys of the page in front of the main screen, if any.
+func (t *TUI) showKeys() {
+	front, _ := t.pages.GetFrontPage()
+	var lines []string
+	at := 0
+	for _, g := range keymap {
+		keys := t.keys(g.page)
+		if len(keys) == 0 {
+			continue
+		}
+		if g.page == front && front != "main" {
+			// The main screen's keys follow those that work everywhere.
+			at = len(lines)
+		}
+		lines = append(lines, "[yellow]"+g.title+"[-]")
+		for _, b := range keys {
+			lines = append(lines, fmt.Sprintf("  %-16s %s", tview.Escape(b.key), b.long))
+		}
+		lines = append(lines, "")
+	}
+	text := strings.Join(lines, "\n")
+	if t.accessible {
+		text = strings.NewReplacer("[yellow]", "", "[-]", "").Replace(text)
+	}
+
+	view := tview.NewTextView().SetDynamicColors(!t.accessible).SetText(text)
+	view.SetBorder(true).SetTitle("Keys (Esc: Close)")
+	view.ScrollTo(at, 0)
+	view.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Key() == tcell.KeyEscape || event.Rune() == '?' {
+			t.pages.RemovePage("keys")
+			return nil
+		}
+		return event
+	})
+	t.pages.AddPage("keys", t.center(view, 80, 30), true, true)
+	t.app.SetFocus(view)
+}
@@ -0,0 +1,74 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// LAN discovery page: nodes found over mDNS, added as peers with one key
+
+package tui
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// SetLANDiscovery enables the LAN discovery page (F9). refresh asks the LAN
+// for nodes right away.
+func (t *TUI) SetLANDiscovery(refresh func()) {
+	t.onLANRefresh = refresh
+}
+
+func (t *TUI) showLANNodes() {
+	if t.onLANRefresh == nil {
+		return
+	}
+
+	list := tview.NewList().ShowSecondaryText(false)
+	refresh := func() {
+		cur := list.GetCurrentItem()
+		list.Clear()
+		for _, n := range t.statsFunc().LANNodes {
+			state := "[green]new[-]      "
+			if n.Connected {
+				state = "[gray]connected[-]"
+			}
+			list.AddItem(fmt.Sprintf("%s  %-21s %-20s %s", state, n.Addr, n.Hostname, n.ID), n.Addr, 0, nil)
+		}
+		if n := list.GetItemCount(); n > 0 {
+			list.SetCurrentItem(max(0, min(cur, n-1)))
+		}
+	}
+	refresh()
+
+	list.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch {
+		case event.Key() == tcell.KeyEscape:
+			t.pages.RemovePage("lan_nodes")
+			return nil
+		case event.Rune() == 'r':
+			t.onLANRefresh()
+			refresh()
+			return nil
+		case event.Key() == tcell.KeyEnter:
+			if list.GetItemCount() > 0 && t.onAddPeer != nil {
+				_, addr := list.GetItemText(list.GetCurrentItem())
+				t.onAddPeer(context.Background(), addr)
+				t.pages.RemovePage("lan_nodes")
+			}
+			return nil
+		}
+		return event
+	})
+
+	help := tview.NewTextView().
+		SetDynamicColors(true).
+		SetText(t.helpLine("lan_nodes"))
+
+	flex := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(list, 0, 1, true).
+		AddItem(help, 1, 0, false)
+	flex.SetBorder(true).SetTitle("IPXTransporter Nodes on the LAN")
+
+	t.pages.AddPage("lan_nodes", t.center(flex, 80, 16), true, true)
+	t.app.SetFocus(list)
+}
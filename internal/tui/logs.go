@@ -0,0 +1,155 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Logs page: the log buffer with filtering, follow mode and pause
+
+package tui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/mlapointe/ipxtransporter/internal/logger"
+	"github.com/rivo/tview"
+)
+
+// logLine formats a log message for a TextView with dynamic colors. With
+// level set, the level is spelled out rather than shown only by color.
+func logLine(l logger.LogMessage, level bool) string {
+	color := "white"
+	switch l.Level {
+	case "ERROR", "FATAL":
+		color = "red"
+	case "WARN":
+		color = "yellow"
+	case "INFO":
+		color = "green"
+	}
+	prefix := ""
+	if level {
+		prefix = l.Level + " "
+	}
+	if l.Subsystem != "" {
+		prefix += "[" + l.Subsystem + "[] "
+	}
+	return fmt.Sprintf("[%s]%s: %s%s[-]\n", color, l.Timestamp.Format("15:04:05"), prefix, tview.Escape(l.Message))
+}
+
+// matchLog reports whether a message's level, subsystem or text contains
+// filter, ignoring case.
+func matchLog(l logger.LogMessage, filter string) bool {
+	if filter == "" {
+		return true
+	}
+	filter = strings.ToLower(filter)
+	for _, s := range []string{l.Level, l.Subsystem, l.Message} {
+		if strings.Contains(strings.ToLower(s), filter) {
+			return true
+		}
+	}
+	return false
+}
+
+// showLogs opens the logs page. It is updated with every refresh of the
+// main screen until closed, unless paused.
+func (t *TUI) showLogs() {
+	view := tview.NewTextView().SetDynamicColors(true).SetWordWrap(true)
+	filter := tview.NewInputField().SetLabel("Filter: ").SetFieldWidth(30)
+	status := tview.NewTextView().SetDynamicColors(true)
+
+	follow, paused := true, false
+	pending := 0        // messages logged while paused
+	var shown time.Time // newest message shown
+	render := func(logs []logger.LogMessage) {
+		text, n := "", 0
+		for _, l := range logs {
+			if matchLog(l, filter.GetText()) {
+				text += logLine(l, t.accessible)
+				n++
+			}
+			shown = l.Timestamp
+		}
+		row, _ := view.GetScrollOffset()
+		view.SetText(text)
+		if follow {
+			view.ScrollToEnd()
+		} else {
+			view.ScrollTo(row, 0)
+		}
+		view.SetTitle(fmt.Sprintf("Logs (%d of %d)", n, len(logs)))
+	}
+	setStatus := func() {
+		state := "[green]following"
+		switch {
+		case paused && pending > 0:
+			state = fmt.Sprintf("[yellow]paused, %d new", pending)
+		case paused:
+			state = "[yellow]paused"
+		case !follow:
+			state = "[white]scrolled"
+		}
+		status.SetText(fmt.Sprintf("%s  %s", state, t.helpLine("logs")))
+	}
+	t.logsRefresh = func(logs []logger.LogMessage) {
+		pending = 0
+		if paused {
+			for _, l := range logs {
+				if l.Timestamp.After(shown) {
+					pending++
+				}
+			}
+		} else {
+			render(logs)
+		}
+		setStatus()
+	}
+	t.logsRefresh(logger.GetLogs())
+
+	filter.SetChangedFunc(func(string) {
+		render(logger.GetLogs())
+	})
+	filter.SetDoneFunc(func(tcell.Key) {
+		t.app.SetFocus(view)
+	})
+
+	closePage := func() {
+		t.logsRefresh = nil
+		t.pages.RemovePage("logs")
+	}
+	view.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch {
+		case event.Key() == tcell.KeyEscape:
+			closePage()
+			return nil
+		case event.Rune() == '/':
+			t.app.SetFocus(filter)
+			return nil
+		case event.Rune() == 'f' || event.Key() == tcell.KeyEnd:
+			follow = !follow || event.Key() == tcell.KeyEnd
+			if follow {
+				view.ScrollToEnd()
+			}
+		case event.Rune() == 'p' || event.Rune() == ' ':
+			paused = !paused
+			if !paused {
+				pending = 0
+				render(logger.GetLogs())
+			}
+		case event.Key() == tcell.KeyUp || event.Key() == tcell.KeyPgUp || event.Key() == tcell.KeyHome || event.Rune() == 'k':
+			// Scrolling back stops following new messages.
+			follow = false
+		}
+		setStatus()
+		return event
+	})
+
+	flex := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(view, 0, 1, true).
+		AddItem(filter, 1, 0, false).
+		AddItem(status, 1, 0, false)
+	view.SetBorder(true)
+
+	t.pages.AddPage("logs", flex, true, true)
+	t.app.SetFocus(view)
+}
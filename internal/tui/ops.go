@@ -0,0 +1,103 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Operations bar: long-running actions in flight and how they ended
+
+package tui
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+	"github.com/rivo/tview"
+)
+
+var spinner = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// describeOp says what an operation does, or did once it has ended.
+func describeOp(op stats.Operation) string {
+	running := op.Ended.IsZero()
+	switch op.Kind {
+	case "dial":
+		if running {
+			return "Dialing " + op.Target
+		}
+		return "Dial " + op.Target
+	case "rotate_secret":
+		if running {
+			return "Rotating the JWT secret"
+		}
+		return "JWT secret rotation"
+	case "drain":
+		if running {
+			return "Draining peer links"
+		}
+		return "Drain"
+	case "capture_restart":
+		if running {
+			return "Restarting capture after " + op.Target
+		}
+		return "Capture restart"
+	case "preflight":
+		if running {
+			return "Testing " + op.Target
+		}
+		return "Test of " + op.Target
+	}
+	return op.Kind + " " + op.Target
+}
+
+// sortedOps puts the operations in flight first, then the most recent.
+func sortedOps(ops []stats.Operation) []stats.Operation {
+	ops = slices.Clone(ops)
+	slices.SortStableFunc(ops, func(a, b stats.Operation) int {
+		if ar, br := a.Ended.IsZero(), b.Ended.IsZero(); ar != br {
+			if ar {
+				return -1
+			}
+			return 1
+		}
+		return b.Started.Compare(a.Started)
+	})
+	return ops
+}
+
+// opsLine is the operations bar: a spinner for each operation in flight
+// and the result of those just ended.
+func opsLine(ops []stats.Operation, now time.Time) string {
+	if len(ops) == 0 {
+		return "[gray]No operations in progress"
+	}
+	frame := spinner[int(now.UnixMilli()/500)%len(spinner)]
+	var parts []string
+	for _, op := range sortedOps(ops) {
+		text := tview.Escape(describeOp(op))
+		switch {
+		case op.Ended.IsZero():
+			parts = append(parts, fmt.Sprintf("[yellow]%s %s (%s)", frame, text, stats.FormatDuration(now.Sub(op.Started))))
+		case op.Error != "":
+			parts = append(parts, fmt.Sprintf("[red]✗ %s failed: %s", text, tview.Escape(op.Error)))
+		default:
+			parts = append(parts, fmt.Sprintf("[green]✓ %s done", text))
+		}
+	}
+	return strings.Join(parts, "  ")
+}
+
+// opsSentences describes the operations for the accessible summary.
+func opsSentences(ops []stats.Operation, now time.Time) []string {
+	var lines []string
+	for _, op := range sortedOps(ops) {
+		switch {
+		case op.Ended.IsZero():
+			lines = append(lines, fmt.Sprintf("%s, for %s.", describeOp(op), stats.FormatDuration(now.Sub(op.Started))))
+		case op.Error != "":
+			lines = append(lines, fmt.Sprintf("%s failed: %s.", describeOp(op), op.Error))
+		default:
+			lines = append(lines, describeOp(op)+" done.")
+		}
+	}
+	return lines
+}
@@ -0,0 +1,260 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Peer detail page: per-peer traffic history and link state, updated live
+
+package tui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+	"github.com/rivo/tview"
+)
+
+// peerHistoryLen is how many one-second rate points are kept per peer,
+// sparkWidth how many of them the detail page draws, and liveWindow the
+// least time the live rate of the table is measured over: a refresh.
+const (
+	peerHistoryLen = 120
+	sparkWidth     = 60
+	liveWindow     = 500 * time.Millisecond
+)
+
+// peerHistory is the recent RX/TX rate of a peer in bytes per second, one
+// point per second, and the totals the next point is measured from. The
+// live rate is measured the same way over the last refresh or so.
+type peerHistory struct {
+	at     time.Time
+	rx, tx uint64
+	rxRate []uint64
+	txRate []uint64
+
+	liveAt         time.Time
+	liveRx, liveTx uint64
+	rxLive, txLive uint64
+	measured       bool // whether rxLive and txLive were measured yet
+}
+
+// samplePeers adds a rate point per second since the last to the history
+// of each peer. A quiet spell gets the average rate over it, and peers
+// that left are forgotten.
+func (t *TUI) samplePeers(peers []stats.PeerStat, now time.Time) {
+	present := make(map[string]bool, len(peers))
+	for _, p := range peers {
+		present[p.ID] = true
+		h := t.peerHistory[p.ID]
+		if h == nil || p.RecvBytes < max(h.rx, h.liveRx) || p.SentBytes < max(h.tx, h.liveTx) {
+			// New, or reconnected under the same ID with fresh counters.
+			t.peerHistory[p.ID] = &peerHistory{at: now, rx: p.RecvBytes, tx: p.SentBytes,
+				liveAt: now, liveRx: p.RecvBytes, liveTx: p.SentBytes}
+			continue
+		}
+		if d := now.Sub(h.liveAt); d >= liveWindow {
+			h.rxLive = uint64(float64(p.RecvBytes-h.liveRx) / d.Seconds())
+			h.txLive = uint64(float64(p.SentBytes-h.liveTx) / d.Seconds())
+			h.liveAt, h.liveRx, h.liveTx, h.measured = now, p.RecvBytes, p.SentBytes, true
+		}
+		points := int(now.Sub(h.at) / time.Second)
+		if points == 0 {
+			continue
+		}
+		rx := (p.RecvBytes - h.rx) / uint64(points)
+		tx := (p.SentBytes - h.tx) / uint64(points)
+		for range min(points, peerHistoryLen) {
+			h.rxRate = append(h.rxRate, rx)
+			h.txRate = append(h.txRate, tx)
+		}
+		if n := len(h.rxRate) - peerHistoryLen; n > 0 {
+			h.rxRate = h.rxRate[n:]
+			h.txRate = h.txRate[n:]
+		}
+		h.at = h.at.Add(time.Duration(points) * time.Second)
+		h.rx, h.tx = p.RecvBytes, p.SentBytes
+	}
+	for id := range t.peerHistory {
+		if !present[id] {
+			delete(t.peerHistory, id)
+		}
+	}
+}
+
+// rateLine describes a rate history: the last point and a sparkline of the
+// last minute, or the peak and average when accessible.
+func (t *TUI) rateLine(rates []uint64) string {
+	if len(rates) == 0 {
+		return "measured from the next second"
+	}
+	last := formatBytes(rates[len(rates)-1]) + "/s"
+	if t.accessible {
+		var peak, sum uint64
+		for _, r := range rates {
+			peak = max(peak, r)
+			sum += r
+		}
+		return fmt.Sprintf("%s, peak %s/s, average %s/s over %d seconds",
+			last, formatBytes(peak), formatBytes(sum/uint64(len(rates))), len(rates))
+	}
+	return fmt.Sprintf("%-10s %s", last, sparkline(rates[max(0, len(rates)-sparkWidth):]))
+}
+
+// connState is the state of the link to p, in words.
+func connState(p stats.PeerStat, now time.Time) string {
+	dir := "outbound"
+	if p.Inbound {
+		dir = "inbound"
+	}
+	state := fmt.Sprintf("connected %s for %s", dir, now.Sub(p.ConnectedAt).Round(time.Second))
+	switch {
+	case now.Sub(p.LastSeen) > staleAfter:
+		state += fmt.Sprintf(", stale (silent for %s)", now.Sub(p.LastSeen).Round(time.Second))
+	case p.Emulated:
+		state += ", emulated socket bridge"
+	}
+	if p.MutedIn || p.MutedOut {
+		state += ", muted " + formatMuted(p)
+	}
+	return state
+}
+
+// peerDetail is the text of the detail page of p, its history h may be nil.
+func (t *TUI) peerDetail(p stats.PeerStat, h *peerHistory) string {
+	now := time.Now()
+	var rx, tx []uint64
+	if h != nil {
+		rx, tx = h.rxRate, h.txRate
+	}
+	key := p.KeyFingerprint
+	if key == "" {
+		key = "unsigned"
+	}
+	features := "none"
+	if len(p.Features) > 0 {
+		features = strings.Join(p.Features, ", ")
+	}
+	protocol := "no hello yet"
+	if p.Protocol > 0 {
+		protocol = fmt.Sprint(p.Protocol)
+		if p.Downgrades > 0 {
+			protocol += fmt.Sprintf(", negotiated down %d times", p.Downgrades)
+		}
+	}
+	parent := p.ParentID
+	if parent == "" {
+		parent = "none"
+	}
+	padding := "off"
+	if p.Padding != "" {
+		padding = fmt.Sprintf("%s (%s overhead)", p.Padding, formatBytes(p.PadBytes))
+	}
+
+	lines := []string{
+		fmt.Sprintf("[yellow]State:[white]        %s", connState(p, now)),
+		fmt.Sprintf("[yellow]Address:[white]      %s %s (%s, %s)", p.IP, tview.Escape(p.Hostname), p.City, p.Country),
+		fmt.Sprintf("[yellow]Tree:[white]         parent %s, %d/%d children", parent, p.NumChildren, p.MaxChildren),
+		"",
+		fmt.Sprintf("[yellow]RX:[white]           %s", t.rateLine(rx)),
+		fmt.Sprintf("[yellow]TX:[white]           %s", t.rateLine(tx)),
+		fmt.Sprintf("[yellow]Totals:[white]       %s in %s packets received, %s in %s packets sent, %s errors",
+			formatBytes(p.RecvBytes), formatPkts(p.RecvPkts), formatBytes(p.SentBytes), formatPkts(p.SentPkts), formatPkts(p.Errors)),
+		fmt.Sprintf("[yellow]Latency:[white]      %.1f ms, metric %d (cost %d)", p.LatencyMs, p.Metric, p.Cost),
+		"",
+		fmt.Sprintf("[yellow]Send queue:[white]   %s queued, %s dropped when full", formatBytes(p.QueuedBytes), formatPkts(p.QueueDrops)),
+		fmt.Sprintf("[yellow]Other drops:[white]  %s over the rate limit, %s filtered, %s partial frames",
+			formatPkts(p.RateDrops), formatPkts(p.FilterDrops), formatPkts(p.PartialFrames)),
+		fmt.Sprintf("[yellow]Memory:[white]       %s", formatBytes(p.MemBytes)),
+		"",
+		fmt.Sprintf("[yellow]Protocol:[white]     %s", protocol),
+		fmt.Sprintf("[yellow]Capabilities:[white] %s", features),
+		fmt.Sprintf("[yellow]Padding:[white]      %s", padding),
+		fmt.Sprintf("[yellow]Trust:[white]        %s", p.Trust),
+		fmt.Sprintf("[yellow]Key:[white]          %s", key),
+	}
+	if t.accessible {
+		for i, l := range lines {
+			lines[i] = strings.NewReplacer("[yellow]", "", "[white]", "").Replace(l)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// showPeerDetail opens the detail page of the peer at row of the table.
+// It follows the peer with each refresh until closed, and says so once the
+// peer has left.
+func (t *TUI) showPeerDetail(row int) {
+	p, ok := t.selectedPeer(row)
+	if !ok {
+		return
+	}
+	id := p.ID
+	view := tview.NewTextView().SetDynamicColors(!t.accessible).SetWrap(false)
+	view.SetBorder(true)
+	status := tview.NewTextView().SetDynamicColors(true).
+		SetText(t.helpLine("peer_detail"))
+
+	t.peerRefresh = func(s stats.Stats) {
+		title := id
+		if p.Name != "" {
+			title = fmt.Sprintf("%s (%s)", p.Name, id)
+		}
+		gone := true
+		for _, q := range s.Peers {
+			if q.ID == id {
+				p, gone = q, false
+				break
+			}
+		}
+		if gone {
+			view.SetTitle(fmt.Sprintf("Peer %s, disconnected", title))
+			return
+		}
+		view.SetTitle("Peer " + title)
+		view.SetText(t.peerDetail(p, t.peerHistory[id]))
+	}
+	t.peerRefresh(t.statsFunc())
+
+	closePage := func() {
+		t.peerRefresh = nil
+		t.pages.RemovePage("peer_detail")
+	}
+	view.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch {
+		case event.Key() == tcell.KeyEscape:
+			closePage()
+			return nil
+		case event.Key() == tcell.KeyEnter && t.selectPeer(id):
+			closePage()
+			t.showPeerActions(t.selectedRow())
+			return nil
+		case event.Rune() == 'w' && t.selectPeer(id):
+			closePage()
+			t.showWhois()
+			return nil
+		}
+		return event
+	})
+
+	layout := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(view, 0, 1, true).
+		AddItem(status, 1, 0, false)
+	t.pages.AddPage("peer_detail", layout, true, true)
+}
+
+// selectPeer selects the row of the peer with id in the table, which may
+// have moved since the detail page opened.
+func (t *TUI) selectPeer(id string) bool {
+	for row := 1; row < t.table.GetRowCount(); row++ {
+		if ref, _ := t.table.GetCell(row, 0).GetReference().(string); ref == id {
+			t.table.Select(row, 0)
+			return true
+		}
+	}
+	return false
+}
+
+func (t *TUI) selectedRow() int {
+	row, _ := t.table.GetSelection()
+	return row
+}
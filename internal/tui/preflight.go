@@ -0,0 +1,59 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Peer preflight results in the Add Peer dialog
+
+package tui
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+	"github.com/rivo/tview"
+)
+
+// SetPreflight lets the Add Peer dialog try a peer before adding it.
+func (t *TUI) SetPreflight(preflight func(ctx context.Context, pc config.PeerConfig) stats.Preflight) {
+	t.onPreflight = preflight
+}
+
+// preflightText lists the steps of a preflight, one per line.
+func preflightText(res stats.Preflight) string {
+	var b strings.Builder
+	if res.OK {
+		fmt.Fprintf(&b, "%s answers as node %s\n\n", res.Addr, res.NodeID)
+	} else {
+		fmt.Fprintf(&b, "%s failed the test\n\n", res.Addr)
+	}
+	for _, s := range res.Steps {
+		mark := "✓"
+		if !s.OK {
+			mark = "✗"
+		}
+		fmt.Fprintf(&b, "%s %s: %s\n", mark, strings.ReplaceAll(s.Name, "_", " "), s.Detail)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// testPeer runs a preflight of pc in the background and shows the result,
+// adding the peer too if add is set and the test passed.
+func (t *TUI) testPeer(pc config.PeerConfig, add bool, status *tview.TextView) {
+	status.SetText(fmt.Sprintf("[yellow]Testing %s...", pc.Addr))
+	go func() {
+		res := t.onPreflight(context.Background(), pc)
+		t.app.QueueUpdateDraw(func() {
+			status.Clear()
+			text := preflightText(res)
+			switch {
+			case add && res.OK:
+				t.addPeer(pc, status)
+				text += "\n\nPeer added."
+			case add:
+				text += "\n\nPeer not added."
+			}
+			t.showMessage(text)
+		})
+	}()
+}
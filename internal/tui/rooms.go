@@ -0,0 +1,200 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Rooms page: current room, its members and public rooms from the tracker
+
+package tui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/mlapointe/ipxtransporter/internal/rooms"
+	"github.com/rivo/tview"
+)
+
+// RoomManager is what the rooms page needs from the relay server.
+type RoomManager interface {
+	CreateRoom(name string, public bool) (string, error)
+	JoinRoom(token string) error
+	LeaveRoom()
+	RoomInvite() string
+	TrackerRooms() ([]rooms.Listing, error)
+}
+
+// SetRooms enables the rooms page (F10).
+func (t *TUI) SetRooms(rm RoomManager) {
+	t.rooms = rm
+}
+
+func (t *TUI) showRooms() {
+	if t.rooms == nil {
+		return
+	}
+
+	status := tview.NewTextView().SetDynamicColors(true)
+	status.SetBorder(true).SetTitle("Current Room")
+	list := tview.NewList().ShowSecondaryText(false)
+	list.SetBorder(true).SetTitle("Public Rooms (tracker)")
+
+	var listings []rooms.Listing
+	refresh := func(fetch bool) {
+		status.SetText(t.formatRoom())
+		if !fetch {
+			return
+		}
+		list.Clear()
+		var err error
+		listings, err = t.rooms.TrackerRooms()
+		if err != nil {
+			list.AddItem("[gray]"+tview.Escape(err.Error())+"[-]", "", 0, nil)
+			listings = nil
+			return
+		}
+		if len(listings) == 0 {
+			list.AddItem("[gray]No public rooms[-]", "", 0, nil)
+		}
+		for _, l := range listings {
+			list.AddItem(fmt.Sprintf("%-32s %3d members  %10s pkts  seen %s ago",
+				tview.Escape(l.Name), l.Members, formatPkts(l.Packets), time.Since(l.LastSeen).Round(time.Second)), "", 0, nil)
+		}
+	}
+	refresh(true)
+
+	list.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch {
+		case event.Key() == tcell.KeyEscape:
+			t.pages.RemovePage("rooms")
+			return nil
+		case event.Key() == tcell.KeyEnter:
+			if i := list.GetCurrentItem(); i < len(listings) {
+				if err := t.rooms.JoinRoom(listings[i].Token); err != nil {
+					t.showError(err.Error())
+				}
+				refresh(false)
+			}
+			return nil
+		case event.Rune() == 'c':
+			t.showCreateRoom(func() { refresh(false) })
+			return nil
+		case event.Rune() == 'j':
+			t.showJoinRoom(func() { refresh(false) })
+			return nil
+		case event.Rune() == 'l':
+			t.rooms.LeaveRoom()
+			refresh(false)
+			return nil
+		case event.Rune() == 'i':
+			t.showInvite(t.rooms.RoomInvite())
+			return nil
+		case event.Rune() == 'r':
+			refresh(true)
+			return nil
+		}
+		return event
+	})
+
+	help := tview.NewTextView().
+		SetDynamicColors(true).
+		SetText(t.helpLine("rooms"))
+
+	flex := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(status, 0, 1, false).
+		AddItem(list, 0, 1, true).
+		AddItem(help, 1, 0, false)
+	flex.SetBorder(true).SetTitle("Rooms")
+
+	t.pages.AddPage("rooms", t.center(flex, 96, 28), true, true)
+	t.app.SetFocus(list)
+}
+
+// formatRoom describes the current room and its members' activity.
+func (t *TUI) formatRoom() string {
+	r := t.statsFunc().Room
+	if r == nil {
+		return "[gray]Not in a room. Press c to create one or j to join with an invite token.[-]"
+	}
+	visibility := "private"
+	if r.Public {
+		visibility = "public"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "[yellow]%s[-] (%s, %d members)\n", tview.Escape(r.Name), visibility, len(r.Members))
+	for _, m := range r.Members {
+		name := m.Hostname
+		if name == "" {
+			name = m.ID
+		}
+		switch {
+		case m.Local:
+			fmt.Fprintf(&b, "  [green]%-24s[-] this node\n", tview.Escape(name))
+		case m.Direct:
+			fmt.Fprintf(&b, "  %-24s direct, %s pkts, seen %s ago\n", tview.Escape(name), formatPkts(m.Packets), time.Since(m.LastSeen).Round(time.Second))
+		default:
+			fmt.Fprintf(&b, "  [gray]%-24s %d hops away[-]\n", tview.Escape(name), m.Hops)
+		}
+	}
+	return b.String()
+}
+
+func (t *TUI) showCreateRoom(onDone func()) {
+	name, public := "", false
+	form := tview.NewForm().
+		AddInputField("Room Name", "", 32, nil, func(text string) { name = text }).
+		AddCheckbox("List on Tracker", false, func(checked bool) { public = checked })
+	form.AddButton("Create", func() {
+		invite, err := t.rooms.CreateRoom(name, public)
+		if err != nil {
+			t.showError(err.Error())
+			return
+		}
+		t.pages.RemovePage("room_create")
+		onDone()
+		t.showInvite(invite)
+	}).
+		AddButton("Cancel", func() {
+			t.pages.RemovePage("room_create")
+		})
+	form.SetBorder(true).SetTitle("Create Room")
+	t.pages.AddPage("room_create", t.center(form, 60, 10), true, true)
+}
+
+func (t *TUI) showJoinRoom(onDone func()) {
+	token := ""
+	form := tview.NewForm().
+		AddInputField("Invite Token", "", 60, nil, func(text string) { token = text })
+	form.AddButton("Join", func() {
+		if err := t.rooms.JoinRoom(token); err != nil {
+			t.showError(err.Error())
+			return
+		}
+		t.pages.RemovePage("room_join")
+		onDone()
+	}).
+		AddButton("Cancel", func() {
+			t.pages.RemovePage("room_join")
+		})
+	form.SetBorder(true).SetTitle("Join Room")
+	t.pages.AddPage("room_join", t.center(form, 80, 7), true, true)
+}
+
+func (t *TUI) showInvite(invite string) {
+	if invite == "" {
+		t.showError("Not in a room")
+		return
+	}
+	view := tview.NewTextView().
+		SetWrap(true).
+		SetText(invite + "\n\nShare this token with the people who should join. Esc to close.")
+	view.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Key() == tcell.KeyEscape || event.Key() == tcell.KeyEnter {
+			t.pages.RemovePage("room_invite")
+			return nil
+		}
+		return event
+	})
+	view.SetBorder(true).SetTitle("Room Invite Token")
+	t.pages.AddPage("room_invite", t.center(view, 80, 14), true, true)
+	t.app.SetFocus(view)
+}
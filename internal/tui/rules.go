@@ -0,0 +1,239 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Filter and priority rules editor
+
+package tui
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/mlapointe/ipxtransporter/internal/ipx"
+	"github.com/mlapointe/ipxtransporter/internal/rules"
+	"github.com/rivo/tview"
+)
+
+// SetRules enables the rules editor page (F7).
+func (t *TUI) SetRules(engine *rules.Engine, dryRun func(kind string, r rules.Rule) (matches, samples int, err error)) {
+	t.rules = engine
+	t.onDryRun = dryRun
+}
+
+func (t *TUI) showRulesEditor(kind string) {
+	if t.rules == nil {
+		return
+	}
+
+	list := tview.NewList().ShowSecondaryText(false)
+	var refresh func(selected int)
+	refresh = func(selected int) {
+		list.Clear()
+		rs, _ := t.rules.List(kind)
+		for _, r := range rs {
+			list.AddItem(formatRule(kind, r, t.rules.Hits(r.ID)), r.ID, 0, nil)
+		}
+		if n := list.GetItemCount(); n > 0 {
+			list.SetCurrentItem(max(0, min(selected, n-1)))
+		}
+	}
+	refresh(0)
+
+	selectedID := func() string {
+		if list.GetItemCount() == 0 {
+			return ""
+		}
+		_, id := list.GetItemText(list.GetCurrentItem())
+		return id
+	}
+
+	list.SetSelectedFunc(func(index int, mainText, secondaryText string, shortcut rune) {
+		rs, _ := t.rules.List(kind)
+		if index < len(rs) {
+			t.showRuleForm(kind, rs[index], func() { refresh(index) })
+		}
+	})
+
+	list.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		cur := list.GetCurrentItem()
+		switch {
+		case event.Key() == tcell.KeyEscape:
+			t.pages.RemovePage("rules")
+			return nil
+		case event.Key() == tcell.KeyTab:
+			t.pages.RemovePage("rules")
+			if kind == rules.KindFilter {
+				t.showRulesEditor(rules.KindPriority)
+			} else {
+				t.showRulesEditor(rules.KindFilter)
+			}
+			return nil
+		case event.Rune() == 'a':
+			r := rules.Rule{Direction: "any"}
+			if kind == rules.KindFilter {
+				r.Action = "drop"
+			} else {
+				r.Priority = 1
+			}
+			t.showRuleForm(kind, r, func() { refresh(list.GetItemCount()) })
+			return nil
+		case event.Rune() == 'x' || event.Key() == tcell.KeyDelete:
+			if id := selectedID(); id != "" {
+				if err := t.rules.Delete(kind, id); err != nil {
+					t.showError(err.Error())
+				}
+				refresh(cur)
+			}
+			return nil
+		case event.Rune() == 'K' || (event.Key() == tcell.KeyUp && event.Modifiers()&tcell.ModShift != 0):
+			if id := selectedID(); id != "" && cur > 0 {
+				_ = t.rules.Move(kind, id, cur-1)
+				refresh(cur - 1)
+			}
+			return nil
+		case event.Rune() == 'J' || (event.Key() == tcell.KeyDown && event.Modifiers()&tcell.ModShift != 0):
+			if id := selectedID(); id != "" && cur < list.GetItemCount()-1 {
+				_ = t.rules.Move(kind, id, cur+1)
+				refresh(cur + 1)
+			}
+			return nil
+		}
+		return event
+	})
+
+	title := "Filter Rules"
+	if kind == rules.KindPriority {
+		title = "Priority Rules"
+	}
+	help := tview.NewTextView().
+		SetDynamicColors(true).
+		SetText(t.helpLine("rules"))
+
+	flex := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(list, 0, 1, true).
+		AddItem(help, 1, 0, false)
+	flex.SetBorder(true).SetTitle(title + " (first match wins, hits over last 2m)")
+
+	t.pages.AddPage("rules", t.center(flex, 70, 20), true, true)
+	t.app.SetFocus(list)
+}
+
+func (t *TUI) showRuleForm(kind string, r rules.Rule, onSaved func()) {
+	socket := ""
+	if r.Socket != 0 {
+		socket = fmt.Sprintf("%04X", r.Socket)
+	}
+	directions := []string{"any", "src", "dst"}
+	actions := []string{"drop", "allow"}
+
+	form := tview.NewForm().
+		AddInputField("Name", r.Name, 30, nil, func(text string) { r.Name = text }).
+		AddInputField("Socket (hex or name)", socket, 6, nil, func(text string) { socket = text }).
+		AddDropDown("Direction", directions, indexOfString(directions, r.Direction), func(option string, _ int) { r.Direction = option })
+	if kind == rules.KindFilter {
+		form.AddDropDown("Action", actions, indexOfString(actions, r.Action), func(option string, _ int) { r.Action = option })
+	} else {
+		form.AddInputField("Priority (0-7)", strconv.Itoa(r.Priority), 3, tview.InputFieldInteger, func(text string) {
+			r.Priority, _ = strconv.Atoi(text)
+		})
+	}
+
+	parse := func() (rules.Rule, bool) {
+		sock, err := ipx.ParseSocket(socket)
+		if err != nil {
+			t.showError(err.Error())
+			return r, false
+		}
+		out := r
+		out.Socket = sock
+		return out, true
+	}
+
+	form.AddButton("Save", func() {
+		rule, ok := parse()
+		if !ok {
+			return
+		}
+		var err error
+		if rule.ID == "" {
+			_, err = t.rules.Add(kind, rule)
+		} else {
+			err = t.rules.Update(kind, rule)
+		}
+		if err != nil {
+			t.showError(err.Error())
+			return
+		}
+		t.pages.RemovePage("rule_form")
+		onSaved()
+	})
+	if t.onDryRun != nil {
+		form.AddButton("Dry Run", func() {
+			rule, ok := parse()
+			if !ok {
+				return
+			}
+			matches, samples, err := t.onDryRun(kind, rule)
+			if err != nil {
+				t.showError(err.Error())
+				return
+			}
+			form.SetTitle(fmt.Sprintf("Edit Rule – matches %d of %d recent frames", matches, samples))
+		})
+	}
+	form.AddButton("Cancel", func() {
+		t.pages.RemovePage("rule_form")
+	})
+
+	form.SetBorder(true).SetTitle("Edit Rule")
+	t.pages.AddPage("rule_form", t.center(form, 60, 15), true, true)
+}
+
+func formatRule(kind string, r rules.Rule, hits rules.HitSnapshot) string {
+	socket := "any"
+	if r.Socket != 0 {
+		socket = fmt.Sprintf("0x%04X", r.Socket)
+		if label := ipx.SocketLabel(r.Socket); label != "" {
+			socket += " " + label
+		}
+	}
+	target := fmt.Sprintf("%s socket %s", r.Direction, socket)
+	name := r.Name
+	if name == "" {
+		name = r.ID
+	}
+	recent := hits.Series[max(0, len(hits.Series)-12):]
+	usage := fmt.Sprintf("%6s %s", formatPkts(hits.Total), sparkline(recent))
+	if kind == rules.KindFilter {
+		return fmt.Sprintf("%-5s %-24s %s  %s", strings.ToUpper(r.Action), target, usage, name)
+	}
+	return fmt.Sprintf("P%d    %-24s %s  %s", r.Priority, target, usage, name)
+}
+
+// sparkline renders values as a row of block characters scaled to the maximum.
+func sparkline(values []uint64) string {
+	blocks := []rune(" ▁▂▃▄▅▆▇█")
+	var peak uint64
+	for _, v := range values {
+		peak = max(peak, v)
+	}
+	out := make([]rune, len(values))
+	for i, v := range values {
+		if peak == 0 {
+			out[i] = blocks[0]
+			continue
+		}
+		out[i] = blocks[(v*uint64(len(blocks)-1)+peak-1)/peak]
+	}
+	return string(out)
+}
+
+func indexOfString(options []string, value string) int {
+	for i, o := range options {
+		if o == value {
+			return i
+		}
+	}
+	return 0
+}
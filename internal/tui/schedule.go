@@ -0,0 +1,140 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Scheduled bans and peer access windows page
+
+package tui
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/mlapointe/ipxtransporter/internal/schedule"
+	"github.com/rivo/tview"
+)
+
+// SetSchedule enables the schedule page (F8) and the timed ban peer action.
+func (t *TUI) SetSchedule(sched *schedule.Scheduler, banFor func(id, ip string, d time.Duration)) {
+	t.schedule = sched
+	t.onBanFor = banFor
+}
+
+func (t *TUI) showSchedule() {
+	if t.schedule == nil {
+		return
+	}
+
+	list := tview.NewList().ShowSecondaryText(false)
+	refresh := func(selected int) {
+		list.Clear()
+		now := time.Now()
+		for _, e := range t.schedule.List() {
+			list.AddItem(formatScheduleEntry(e, now), e.ID, 0, nil)
+		}
+		if n := list.GetItemCount(); n > 0 {
+			list.SetCurrentItem(max(0, min(selected, n-1)))
+		}
+	}
+	refresh(0)
+
+	list.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch {
+		case event.Key() == tcell.KeyEscape:
+			t.pages.RemovePage("schedule")
+			return nil
+		case event.Rune() == 'a':
+			t.showScheduleForm(func() { refresh(list.GetItemCount()) })
+			return nil
+		case event.Rune() == 'x' || event.Key() == tcell.KeyDelete:
+			if list.GetItemCount() > 0 {
+				cur := list.GetCurrentItem()
+				_, id := list.GetItemText(cur)
+				if err := t.schedule.Delete(id); err != nil {
+					t.showError(err.Error())
+				}
+				refresh(cur)
+			}
+			return nil
+		}
+		return event
+	})
+
+	next := "no upcoming changes"
+	if n := t.schedule.NextTransition(time.Now()); !n.IsZero() {
+		next = "next change " + formatNextChange(n)
+	}
+	help := tview.NewTextView().
+		SetDynamicColors(true).
+		SetText(t.helpLine("schedule"))
+
+	flex := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(list, 0, 1, true).
+		AddItem(help, 1, 0, false)
+	flex.SetBorder(true).SetTitle("Scheduled Bans & Access Windows (" + next + ")")
+
+	t.pages.AddPage("schedule", t.center(flex, 90, 20), true, true)
+	t.app.SetFocus(list)
+}
+
+func (t *TUI) showScheduleForm(onSaved func()) {
+	actions := []string{schedule.ActionBan, schedule.ActionAllow}
+	e := schedule.Entry{Action: schedule.ActionBan}
+	days, hours := "", ""
+
+	form := tview.NewForm().
+		AddDropDown("Action", actions, 0, func(option string, _ int) { e.Action = option }).
+		AddInputField("Target (ID/IP/addr)", "", 30, nil, func(text string) { e.Target = text }).
+		AddInputField("Days (e.g. sat,sun)", "", 30, nil, func(text string) { days = text }).
+		AddInputField("From (HH:MM)", "", 6, nil, func(text string) { e.Start = text }).
+		AddInputField("To (HH:MM)", "", 6, nil, func(text string) { e.End = text }).
+		AddInputField("Expires in (hours)", "", 6, tview.InputFieldInteger, func(text string) { hours = text })
+
+	form.AddButton("Save", func() {
+		e.Days = nil
+		for _, d := range strings.Split(days, ",") {
+			if d = strings.TrimSpace(d); d != "" {
+				e.Days = append(e.Days, d)
+			}
+		}
+		if h, _ := strconv.Atoi(hours); h > 0 {
+			e.Until = time.Now().Add(time.Duration(h) * time.Hour)
+		}
+		if _, err := t.schedule.Add(e); err != nil {
+			t.showError(err.Error())
+			return
+		}
+		t.pages.RemovePage("schedule_form")
+		onSaved()
+	}).
+		AddButton("Cancel", func() {
+			t.pages.RemovePage("schedule_form")
+		})
+
+	form.SetBorder(true).SetTitle("Add Schedule Entry")
+	t.pages.AddPage("schedule_form", t.center(form, 60, 17), true, true)
+}
+
+func formatScheduleEntry(e schedule.Entry, now time.Time) string {
+	state := "[gray]inactive[-]"
+	if e.Active(now) {
+		state = "[green]active[-]  "
+		if e.Action == schedule.ActionBan {
+			state = "[red]active[-]  "
+		}
+	}
+	next := "-"
+	if n := e.Next(now); !n.IsZero() {
+		next = formatNextChange(n)
+	}
+	return fmt.Sprintf("%-5s %-22s %s  next %-14s %s", strings.ToUpper(e.Action), e.Target, state, next, e.Describe())
+}
+
+// formatNextChange shows a transition time, with the weekday if it is not today.
+func formatNextChange(t time.Time) string {
+	if y, m, d := t.Date(); y == time.Now().Year() && m == time.Now().Month() && d == time.Now().Day() {
+		return t.Format("15:04")
+	}
+	return t.Format("Mon 01-02 15:04")
+}
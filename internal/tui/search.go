@@ -0,0 +1,126 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Peer table search and sorting by a click on a column header
+
+package tui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+	"github.com/rivo/tview"
+)
+
+// headerCell is the header of a built-in column. The column the table is
+// sorted by is flagged, and a click on a sortable one sorts by it.
+func (t *TUI) headerCell(h peerColumn) *tview.TableCell {
+	text := h.name
+	if h.sort != "" && h.sort == t.cfg.SortField {
+		switch {
+		case t.accessible && t.cfg.SortReverse:
+			text += " (sorted, reversed)"
+		case t.accessible:
+			text += " (sorted)"
+		case t.cfg.SortReverse:
+			text += " ▼"
+		default:
+			text += " ▲"
+		}
+	}
+	cell := tview.NewTableCell(text).SetTextColor(tcell.ColorYellow).SetSelectable(false)
+	if h.sort != "" {
+		cell.SetClickedFunc(func() bool {
+			t.sortPeers(h.sort)
+			return true
+		})
+	}
+	return cell
+}
+
+// sortPeers sorts the peer table by field, or reverses the order if it is
+// sorted by field already, and saves the order as F4 does.
+func (t *TUI) sortPeers(field string) {
+	if t.cfg.SortField == field {
+		t.cfg.SortReverse = !t.cfg.SortReverse
+	} else {
+		t.cfg.SortField, t.cfg.SortReverse = field, false
+	}
+	if t.configPath != "" {
+		config.SaveConfig(t.configPath, t.cfg)
+	}
+	t.drawPeers(t.statsFunc())
+}
+
+// matchPeer reports whether the ID, name, IP, hostname or country of p
+// contains query, ignoring case.
+func matchPeer(p stats.PeerStat, query string) bool {
+	if query == "" {
+		return true
+	}
+	query = strings.ToLower(query)
+	for _, s := range []string{p.ID, p.Name, p.IP.String(), p.Hostname, p.Country} {
+		if strings.Contains(strings.ToLower(s), query) {
+			return true
+		}
+	}
+	return false
+}
+
+// tableKeys handles the keys of the peer table: / searches, Esc clears
+// the search once no peers are marked, and the rest mark peers.
+func (t *TUI) tableKeys(event *tcell.EventKey) *tcell.EventKey {
+	switch {
+	case event.Key() == tcell.KeyRune && event.Rune() == '/':
+		t.peerPane.ResizeItem(t.search, 1, 0)
+		t.app.SetFocus(t.search)
+		return nil
+	case event.Key() == tcell.KeyEscape && len(t.marked) == 0 && t.search.GetText() != "":
+		t.clearSearch()
+		return nil
+	}
+	return t.markKeys(event)
+}
+
+// searchDone leaves the search box: Enter keeps the search and Esc clears
+// it.
+func (t *TUI) searchDone(key tcell.Key) {
+	if key == tcell.KeyEscape || t.search.GetText() == "" {
+		t.clearSearch()
+	}
+	t.app.SetFocus(t.table)
+}
+
+func (t *TUI) clearSearch() {
+	t.search.SetText("")
+	t.peerPane.ResizeItem(t.search, 0, 0)
+}
+
+// showSearchCount labels the search box with how many peers match.
+func (t *TUI) showSearchCount(shown, total int) {
+	if t.search.GetText() == "" {
+		t.search.SetLabel("Search: ")
+		return
+	}
+	t.search.SetLabel(fmt.Sprintf("Search (%d of %d): ", shown, total))
+}
+
+// selectedPeer is the peer shown in row of the table. Rows follow the
+// search and sort order, so the peer is found by the ID in its row.
+func (t *TUI) selectedPeer(row int) (stats.PeerStat, bool) {
+	if row <= 0 {
+		return stats.PeerStat{}, false
+	}
+	id, ok := t.table.GetCell(row, 0).GetReference().(string)
+	if !ok {
+		return stats.PeerStat{}, false
+	}
+	for _, p := range t.statsFunc().Peers {
+		if p.ID == id {
+			return p, true
+		}
+	}
+	return stats.PeerStat{}, false
+}
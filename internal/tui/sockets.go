@@ -0,0 +1,58 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Traffic page: interface traffic broken down by IPX socket, protocol or game
+
+package tui
+
+import (
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+func (t *TUI) showSockets() {
+	table := tview.NewTable().SetFixed(1, 0).SetSelectable(true, false)
+	refresh := func() {
+		table.Clear()
+		for i, h := range []string{"Socket", "Protocol/Game", "RX Pkts", "RX Bytes", "TX Pkts", "TX Bytes"} {
+			table.SetCell(0, i, tview.NewTableCell(h).SetTextColor(tcell.ColorYellow).SetSelectable(false))
+		}
+		sockets := t.statsFunc().Sockets
+		if len(sockets) == 0 {
+			table.SetCell(1, 0, tview.NewTableCell("No IPX traffic yet").SetTextColor(tcell.ColorGray))
+		}
+		for i, sc := range sockets {
+			label := sc.Label
+			if label == "" {
+				label = "-"
+			}
+			for col, v := range []string{sc.Socket, label, formatPkts(sc.RxPkts), formatBytes(sc.RxBytes), formatPkts(sc.TxPkts), formatBytes(sc.TxBytes)} {
+				table.SetCell(i+1, col, tview.NewTableCell(v).SetExpansion(1))
+			}
+		}
+	}
+	refresh()
+
+	table.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch {
+		case event.Key() == tcell.KeyEscape:
+			t.pages.RemovePage("sockets")
+			return nil
+		case event.Rune() == 'r':
+			refresh()
+			return nil
+		}
+		return event
+	})
+
+	help := tview.NewTextView().
+		SetDynamicColors(true).
+		SetText(t.helpLine("sockets"))
+
+	flex := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(table, 0, 1, true).
+		AddItem(help, 1, 0, false)
+	flex.SetBorder(true).SetTitle("Traffic by Protocol/Game")
+
+	t.pages.AddPage("sockets", t.center(flex, 80, 20), true, true)
+	t.app.SetFocus(table)
+}
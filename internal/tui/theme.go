@@ -0,0 +1,149 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Color themes: the screen recolored after each draw
+
+package tui
+
+import (
+	"github.com/gdamore/tcell/v2"
+)
+
+// theme recolors what the TUI draws. The TUI is written in the colors of
+// the default theme, tview's and its own; after each draw the text and
+// background of every cell is looked up here, so the table, graph, map and
+// every dialog follow the theme without knowing about it.
+type theme struct {
+	fg, bg map[tcell.Color]tcell.Color
+	attrs  map[tcell.Color]tcell.AttrMask // added to text in that color
+}
+
+// themes by the names of config.Themes. The colors themes map to are RGB,
+// never the named colors they map from, so a cell drawn over is not mapped
+// twice.
+var themes = map[string]theme{
+	"default": {},
+	// For light terminals: dark text on white.
+	"light": {
+		fg: map[tcell.Color]tcell.Color{
+			tcell.ColorWhite:    tcell.NewHexColor(0x1c1c1c),
+			tcell.ColorBlack:    tcell.NewHexColor(0xffffff),
+			tcell.ColorYellow:   tcell.NewHexColor(0x875f00),
+			tcell.ColorGreen:    tcell.NewHexColor(0x005f00),
+			tcell.ColorRed:      tcell.NewHexColor(0xaf0000),
+			tcell.ColorBlue:     tcell.NewHexColor(0x0000af),
+			tcell.ColorAqua:     tcell.NewHexColor(0x005f87),
+			tcell.ColorGray:     tcell.NewHexColor(0x6c6c6c),
+			tcell.ColorDarkCyan: tcell.NewHexColor(0x5f8787),
+			tcell.ColorNavy:     tcell.NewHexColor(0x00005f),
+		},
+		bg: map[tcell.Color]tcell.Color{
+			tcell.ColorBlack:   tcell.NewHexColor(0xffffff),
+			tcell.ColorWhite:   tcell.NewHexColor(0x005faf),
+			tcell.ColorBlue:    tcell.NewHexColor(0xafd7ff),
+			tcell.ColorGreen:   tcell.NewHexColor(0xafd7af),
+			tcell.ColorDarkRed: tcell.NewHexColor(0xffd7d7),
+		},
+	},
+	// Shades of gray only; what was yellow or red is bold instead.
+	"monochrome": {
+		fg: map[tcell.Color]tcell.Color{
+			tcell.ColorWhite:    tcell.NewHexColor(0xffffff),
+			tcell.ColorBlack:    tcell.NewHexColor(0x000000),
+			tcell.ColorYellow:   tcell.NewHexColor(0xffffff),
+			tcell.ColorGreen:    tcell.NewHexColor(0xd0d0d0),
+			tcell.ColorRed:      tcell.NewHexColor(0xffffff),
+			tcell.ColorBlue:     tcell.NewHexColor(0xbcbcbc),
+			tcell.ColorAqua:     tcell.NewHexColor(0xe4e4e4),
+			tcell.ColorGray:     tcell.NewHexColor(0x808080),
+			tcell.ColorDarkCyan: tcell.NewHexColor(0x6c6c6c),
+			tcell.ColorNavy:     tcell.NewHexColor(0x000000),
+		},
+		bg: map[tcell.Color]tcell.Color{
+			tcell.ColorBlack:   tcell.NewHexColor(0x000000),
+			tcell.ColorWhite:   tcell.NewHexColor(0xffffff),
+			tcell.ColorBlue:    tcell.NewHexColor(0x4e4e4e),
+			tcell.ColorGreen:   tcell.NewHexColor(0x6c6c6c),
+			tcell.ColorDarkRed: tcell.NewHexColor(0x3a3a3a),
+		},
+		attrs: map[tcell.Color]tcell.AttrMask{
+			tcell.ColorYellow: tcell.AttrBold,
+			tcell.ColorRed:    tcell.AttrBold | tcell.AttrUnderline,
+		},
+	},
+	// Bright text on pure black, with green and red swapped for sky blue
+	// and pink, which color-blind users tell apart.
+	"high-contrast": {
+		fg: map[tcell.Color]tcell.Color{
+			tcell.ColorWhite:    tcell.NewHexColor(0xffffff),
+			tcell.ColorBlack:    tcell.NewHexColor(0x000000),
+			tcell.ColorYellow:   tcell.NewHexColor(0xffff5f),
+			tcell.ColorGreen:    tcell.NewHexColor(0x5fd7ff),
+			tcell.ColorRed:      tcell.NewHexColor(0xff87d7),
+			tcell.ColorBlue:     tcell.NewHexColor(0xffaf00),
+			tcell.ColorAqua:     tcell.NewHexColor(0xffffff),
+			tcell.ColorGray:     tcell.NewHexColor(0xd0d0d0),
+			tcell.ColorDarkCyan: tcell.NewHexColor(0x8a8a8a),
+			tcell.ColorNavy:     tcell.NewHexColor(0x000000),
+		},
+		bg: map[tcell.Color]tcell.Color{
+			tcell.ColorBlack:   tcell.NewHexColor(0x000000),
+			tcell.ColorWhite:   tcell.NewHexColor(0xffffff),
+			tcell.ColorBlue:    tcell.NewHexColor(0x0000d7),
+			tcell.ColorGreen:   tcell.NewHexColor(0x005f00),
+			tcell.ColorDarkRed: tcell.NewHexColor(0x870000),
+		},
+	},
+}
+
+// recolor maps the colors of every cell on screen, drawn but not yet
+// shown. Text the mapping leaves too close to its background, such as
+// the inverse text of a selected button, is drawn black or white instead.
+func (th theme) recolor(screen tcell.Screen) {
+	if th.fg == nil && th.bg == nil {
+		return
+	}
+	width, height := screen.Size()
+	for y := range height {
+		for x := 0; x < width; {
+			mainc, combc, style, w := screen.GetContent(x, y)
+			fg, bg, attrs := style.Decompose()
+			nfg, okFg := th.fg[fg]
+			nbg, okBg := th.bg[bg]
+			if okFg || okBg {
+				if !okFg {
+					nfg = fg
+				}
+				if !okBg {
+					nbg = bg
+				}
+				nfg = readable(nfg, nbg)
+				style = style.Foreground(nfg).Background(nbg).Attributes(attrs | th.attrs[fg])
+				screen.SetContent(x, y, mainc, combc, style)
+			}
+			x += max(w, 1)
+		}
+	}
+}
+
+// readable is fg, or black or white if fg is too close to bg in lightness
+// to be read on it.
+func readable(fg, bg tcell.Color) tcell.Color {
+	lf, okF := lightness(fg)
+	lb, okB := lightness(bg)
+	if !okF || !okB || lf-lb > 100 || lb-lf > 100 {
+		return fg
+	}
+	if lb > 128 {
+		return tcell.NewHexColor(0x000000)
+	}
+	return tcell.NewHexColor(0xffffff)
+}
+
+// lightness is the luma of c from 0 to 255, if c has an RGB value.
+func lightness(c tcell.Color) (int32, bool) {
+	r, g, b := c.RGB()
+	if r < 0 {
+		return 0, false
+	}
+	return (2126*r + 7152*g + 722*b) / 10000, true
+}
@@ -0,0 +1,74 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// Traces page: the path traced packets took through the mesh
+
+package tui
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+	"github.com/rivo/tview"
+)
+
+// tracePath formats the hops of a trace with the time since the origin
+// sent the packet. Each node stamps its hop by its own clock.
+func tracePath(tr stats.PacketTrace) string {
+	parts := make([]string, len(tr.Hops))
+	for i, h := range tr.Hops {
+		parts[i] = h.Node
+		if i > 0 {
+			parts[i] += fmt.Sprintf(" +%.1fms", float64(h.At.Sub(tr.Hops[0].At).Microseconds())/1000)
+		}
+	}
+	return strings.Join(parts, " → ")
+}
+
+func (t *TUI) showTraces() {
+	table := tview.NewTable().SetFixed(1, 0).SetSelectable(true, false)
+	refresh := func() {
+		table.Clear()
+		for i, h := range []string{"Received", "Source", "Destination", "Size", "Path"} {
+			table.SetCell(0, i, tview.NewTableCell(h).SetTextColor(tcell.ColorYellow).SetSelectable(false))
+		}
+		traces := t.statsFunc().Traces
+		if len(traces) == 0 {
+			table.SetCell(1, 0, tview.NewTableCell("No traced packets received; trace flows are set on the sending node").SetTextColor(tcell.ColorGray))
+		}
+		// Newest first.
+		for i, tr := range slices.Backward(traces) {
+			row := len(traces) - i
+			for col, v := range []string{tr.Received.Format("15:04:05.000"), tr.Src, tr.Dst, fmt.Sprint(tr.Size), tracePath(tr)} {
+				table.SetCell(row, col, tview.NewTableCell(tview.Escape(v)).SetExpansion(1))
+			}
+		}
+	}
+	refresh()
+
+	table.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch {
+		case event.Key() == tcell.KeyEscape:
+			t.pages.RemovePage("traces")
+			return nil
+		case event.Rune() == 'r':
+			refresh()
+			return nil
+		}
+		return event
+	})
+
+	help := tview.NewTextView().
+		SetDynamicColors(true).
+		SetText(t.helpLine("traces") + "  [gray]hop times are on each node's own clock")
+
+	flex := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(table, 0, 1, true).
+		AddItem(help, 1, 0, false)
+	flex.SetBorder(true).SetTitle("Packet Traces")
+
+	t.pages.AddPage("traces", t.center(flex, 120, 24), true, true)
+	t.app.SetFocus(table)
+}
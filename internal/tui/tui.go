@@ -9,13 +9,17 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/gdamore/tcell/v2"
-	"github.com/mlapointe/ipxtransporter/internal/capture"
 	"github.com/mlapointe/ipxtransporter/internal/config"
+	"github.com/mlapointe/ipxtransporter/internal/ipx"
 	"github.com/mlapointe/ipxtransporter/internal/logger"
+	"github.com/mlapointe/ipxtransporter/internal/rules"
+	"github.com/mlapointe/ipxtransporter/internal/schedule"
 	"github.com/mlapointe/ipxtransporter/internal/stats"
 	"github.com/rivo/tview"
 )
@@ -25,22 +29,65 @@ type TUI struct {
 	pages         *tview.Pages
 	mainFlex      *tview.Flex
 	table         *tview.Table
+	search        *tview.InputField // filters the peer table, shown by /
+	peerPane      *tview.Flex       // the search box above the table
 	mapView       *tview.TextView
+	mapPane       *tview.Pages // the topology tree or the world map
+	mapPeers      []stats.PeerStat
+	worldLayout   *worldLayout // land of the world map at the pane's size
 	graphView     *tview.TextView
 	logView       *tview.TextView
 	statCards     *tview.TextView
+	banner        *tview.TextView
+	opsBar        *tview.TextView // operations in flight, not when accessible
 	statsFunc     func() stats.Stats
+	history       func(span time.Duration) ([]stats.Sample, time.Duration)
 	cfg           *config.Config
 	configPath    string
 	fileList      *tview.List
 	currentDir    string
 	rxHistory     []uint64
 	txHistory     []uint64
-	graphStep     int // Number of 500ms intervals per column
-	onDemoUpdate  func(packetRate, dropRate, errorRate, numPeers int)
+	rxBytes       []uint64 // byte totals beside the packet totals in rxHistory
+	txBytes       []uint64
+	graphBytes    bool           // graph bytes rather than packets per second
+	graphOffset   int            // points the graph is panned back from the newest
+	graphStep     int            // Number of 500ms intervals per column
+	sampledAt     time.Time      // end of the last point in rxHistory
+	changes       *stats.Changes // nil redraws everything every 500ms
+	onDemoUpdate  func(packetRate, dropRate, errorRate, numPeers int, pattern string)
 	onDisconnect  func(id string)
 	onBan         func(id, ip string)
 	onAddPeer     func(ctx context.Context, addr string)
+	onAddConfig   func(ctx context.Context, pc config.PeerConfig) error
+	onPreflight   func(ctx context.Context, pc config.PeerConfig) stats.Preflight
+	onCapture     func(iface, filter string) error
+	bans          func() []stats.Ban
+	onUnban       func(target string) error
+	allowList     AllowListManager
+	audit         func(outcome string) []stats.ConnAttempt
+	flags         FlagManager
+	backups       BackupManager
+	onMute        func(id string, inbound, outbound bool)
+	onLabel       func(peers []stats.PeerStat, note string)
+	marked        map[string]bool // peer IDs marked for a batch action
+	rules         *rules.Engine
+	onDryRun      func(kind string, r rules.Rule) (matches, samples int, err error)
+	schedule      *schedule.Scheduler
+	onBanFor      func(id, ip string, d time.Duration)
+	onLANRefresh  func()
+	rooms         RoomManager
+	logsRefresh   func(logs []logger.LogMessage) // set while the logs page is open
+	peerRefresh   func(s stats.Stats)            // set while a peer detail page is open
+	dialRefresh   func(ops []stats.Operation)    // set while the Add Peer dialog follows a dial
+	peerHistory   map[string]*peerHistory        // recent traffic rates by peer ID
+	columns       *stats.Columns                 // operator-defined peer columns
+	accessible    bool                           // text instead of colors and graphs
+	theme         theme                          // colors the screen is drawn in
+	summary       *tview.TextView                // replaces graph and map when accessible
+	prevRx        uint64                         // totals at the last summary
+	prevTx        uint64
+	prevAt        time.Time
 	lastClickTime time.Time
 	lastClickRow  int
 }
@@ -49,7 +96,7 @@ func NewTUI(statsFunc func() stats.Stats, cfg *config.Config, configPath string)
 	return NewTUIWithDemo(statsFunc, cfg, configPath, nil, nil, nil, nil)
 }
 
-func NewTUIWithDemo(statsFunc func() stats.Stats, cfg *config.Config, configPath string, onDemoUpdate func(packetRate, dropRate, errorRate, numPeers int), onDisconnect func(id string), onBan func(id, ip string), onAddPeer func(ctx context.Context, addr string)) *TUI {
+func NewTUIWithDemo(statsFunc func() stats.Stats, cfg *config.Config, configPath string, onDemoUpdate func(packetRate, dropRate, errorRate, numPeers int, pattern string), onDisconnect func(id string), onBan func(id, ip string), onAddPeer func(ctx context.Context, addr string)) *TUI {
 	app := tview.NewApplication()
 	pages := tview.NewPages()
 
@@ -71,11 +118,14 @@ func NewTUIWithDemo(statsFunc func() stats.Stats, cfg *config.Config, configPath
 	logView := tview.NewTextView().
 		SetDynamicColors(true).
 		SetRegions(true).
-		SetWordWrap(true).
-		SetChangedFunc(func() {
+		SetWordWrap(true)
+	if !cfg.Accessible {
+		// In accessibility mode the log is drawn with the next refresh only.
+		logView.SetChangedFunc(func() {
 			app.Draw()
 		})
-	logView.SetBorder(true).SetTitle("System Logs")
+	}
+	logView.SetBorder(true).SetTitle("System Logs (Ctrl+L)")
 
 	graphView := tview.NewTextView().
 		SetDynamicColors(true).
@@ -100,19 +150,42 @@ func NewTUIWithDemo(statsFunc func() stats.Stats, cfg *config.Config, configPath
 		onDisconnect: onDisconnect,
 		onBan:        onBan,
 		onAddPeer:    onAddPeer,
+		accessible:   cfg.Accessible,
+		theme:        themes[cfg.Theme],
+		marked:       make(map[string]bool),
+		peerHistory:  make(map[string]*peerHistory),
 	}
+	// relay.NewServer has already rejected templates that do not compile.
+	tuiInstance.columns, _ = stats.NewColumns(cfg.PeerColumns, cfg.PeerNotes)
 
 	table.SetSelectedFunc(func(row, column int) {
 		tuiInstance.showPeerActions(row)
 	})
+	table.SetInputCapture(tuiInstance.tableKeys)
+	app.SetAfterDrawFunc(func(screen tcell.Screen) {
+		tuiInstance.theme.recolor(screen)
+	})
+
+	search := tview.NewInputField().SetLabel("Search: ")
+	search.SetChangedFunc(func(string) {
+		tuiInstance.drawPeers(tuiInstance.statsFunc())
+	})
+	search.SetDoneFunc(tuiInstance.searchDone)
+	tuiInstance.search = search
+	tuiInstance.peerPane = tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(search, 0, 0, false).
+		AddItem(table, 0, 1, true)
 
 	app.SetMouseCapture(func(event *tcell.EventMouse, action tview.MouseAction) (*tcell.EventMouse, tview.MouseAction) {
 		if action == tview.MouseLeftClick {
 			row, _ := table.GetSelection()
 			now := time.Now()
+			if front, _ := pages.GetFrontPage(); front != "main" {
+				return event, action
+			}
 			if row == tuiInstance.lastClickRow && now.Sub(tuiInstance.lastClickTime) < 500*time.Millisecond {
 				// Double click detected
-				tuiInstance.showPeerActions(row)
+				tuiInstance.showPeerDetail(row)
 				tuiInstance.lastClickRow = -1 // Reset
 			} else {
 				tuiInstance.lastClickRow = row
@@ -122,16 +195,45 @@ func NewTUIWithDemo(statsFunc func() stats.Stats, cfg *config.Config, configPath
 		return event, action
 	})
 
+	banner := tview.NewTextView().SetDynamicColors(true)
+	banner.SetBackgroundColor(tcell.ColorDarkRed)
+	tuiInstance.banner = banner
+
 	mainFlex := tview.NewFlex().
 		SetDirection(tview.FlexRow).
-		AddItem(tview.NewFlex().
-			AddItem(table, 0, 1, true).
+		AddItem(banner, 0, 0, false)
+	if tuiInstance.accessible {
+		// One column read top to bottom: the peers, a summary in words and
+		// the log, with nothing drawn as a picture.
+		summary := tview.NewTextView().SetWordWrap(true)
+		summary.SetBorder(true).SetTitle("Summary")
+		tuiInstance.summary = summary
+		mainFlex.AddItem(tuiInstance.peerPane, 0, 1, true).
+			AddItem(summary, 7, 0, false).
+			AddItem(logView, 8, 0, false)
+		pages.SetChangedFunc(tuiInstance.announcePage)
+	} else {
+		world := tview.NewBox()
+		world.SetBorder(true).SetTitle("World Map")
+		world.SetDrawFunc(func(screen tcell.Screen, x, y, width, height int) (int, int, int, int) {
+			x, y, width, height = world.GetInnerRect()
+			tuiInstance.drawWorld(screen, x, y, width, height)
+			return x, y, width, height
+		})
+		tuiInstance.mapPane = tview.NewPages().
+			AddPage("tree", mapView, true, true).
+			AddPage("world", world, true, false)
+		mainFlex.AddItem(tview.NewFlex().
+			AddItem(tuiInstance.peerPane, 0, 1, true).
 			AddItem(tview.NewFlex().
 				SetDirection(tview.FlexRow).
-				AddItem(mapView, 0, 1, false).
+				AddItem(tuiInstance.mapPane, 0, 1, false).
 				AddItem(logView, 10, 0, false), 66, 0, false), 0, 1, true).
-		AddItem(graphView, 10, 0, false).
-		AddItem(statCards, 2, 1, false)
+			AddItem(graphView, 10, 0, false)
+		tuiInstance.opsBar = tview.NewTextView().SetDynamicColors(true)
+		mainFlex.AddItem(tuiInstance.opsBar, 1, 0, false)
+	}
+	mainFlex.AddItem(statCards, 2, 1, false)
 
 	tuiInstance.mainFlex = mainFlex
 	pages.AddPage("main", mainFlex, true, true)
@@ -168,6 +270,74 @@ func NewTUIWithDemo(statsFunc func() stats.Stats, cfg *config.Config, configPath
 			tuiInstance.showAddPeerDialog()
 			return nil
 		}
+		if event.Key() == tcell.KeyF7 && tuiInstance.rules != nil {
+			tuiInstance.showRulesEditor(rules.KindFilter)
+			return nil
+		}
+		if event.Key() == tcell.KeyF8 && tuiInstance.schedule != nil {
+			tuiInstance.showSchedule()
+			return nil
+		}
+		if event.Key() == tcell.KeyF9 && tuiInstance.onLANRefresh != nil {
+			tuiInstance.showLANNodes()
+			return nil
+		}
+		if event.Key() == tcell.KeyF10 && tuiInstance.rooms != nil {
+			tuiInstance.showRooms()
+			return nil
+		}
+		if event.Key() == tcell.KeyF11 {
+			tuiInstance.showSockets()
+			return nil
+		}
+		if event.Key() == tcell.KeyF12 {
+			tuiInstance.showHosts()
+			return nil
+		}
+		if event.Key() == tcell.KeyCtrlL {
+			tuiInstance.showLogs()
+			return nil
+		}
+		if event.Key() == tcell.KeyCtrlT {
+			tuiInstance.showTraces()
+			return nil
+		}
+		if event.Key() == tcell.KeyCtrlE {
+			tuiInstance.exportPeersCSV()
+			return nil
+		}
+		if event.Key() == tcell.KeyCtrlB {
+			tuiInstance.showBans()
+			return nil
+		}
+		if event.Key() == tcell.KeyCtrlW {
+			tuiInstance.showAllowList()
+			return nil
+		}
+		if event.Key() == tcell.KeyCtrlO {
+			tuiInstance.showAudit()
+			return nil
+		}
+		if event.Key() == tcell.KeyCtrlG {
+			tuiInstance.showFlags()
+			return nil
+		}
+		if event.Key() == tcell.KeyCtrlP && tuiInstance.mapPane != nil {
+			tuiInstance.toggleWorldMap()
+			return nil
+		}
+		// Leave keys to a text field that has focus.
+		if _, ok := app.GetFocus().(*tview.InputField); ok {
+			return event
+		}
+		if event.Modifiers()&tcell.ModShift != 0 && event.Key() == tcell.KeyLeft {
+			tuiInstance.panGraph(1)
+			return nil
+		}
+		if event.Modifiers()&tcell.ModShift != 0 && event.Key() == tcell.KeyRight {
+			tuiInstance.panGraph(-1)
+			return nil
+		}
 		if event.Rune() == '+' || event.Key() == tcell.KeyRight {
 			tuiInstance.zoomGraph(-1)
 			return nil
@@ -176,15 +346,53 @@ func NewTUIWithDemo(statsFunc func() stats.Stats, cfg *config.Config, configPath
 			tuiInstance.zoomGraph(1)
 			return nil
 		}
+		if event.Rune() == '?' {
+			if front, _ := pages.GetFrontPage(); front != "keys" {
+				tuiInstance.showKeys()
+				return nil
+			}
+			return event
+		}
+		if front, _ := pages.GetFrontPage(); event.Rune() == 'u' && front == "main" && !tuiInstance.accessible {
+			tuiInstance.graphBytes = !tuiInstance.graphBytes
+			tuiInstance.redraw(stats.ChangeCounters)
+			return nil
+		}
 		return event
 	})
 
 	return tuiInstance
 }
 
+// SetChanges has the screen redrawn as the relay reports changes rather
+// than every 500ms.
+func (t *TUI) SetChanges(c *stats.Changes) {
+	t.changes = c
+}
+
+const (
+	// minRedraw is the least time between two redraws; the changes in
+	// between are drawn together.
+	minRedraw = 100 * time.Millisecond
+	// idleRedraw redraws a quiet screen, so peer ages, uptime and the
+	// graph move on.
+	idleRedraw = 5 * time.Second
+)
+
 func (t *TUI) Run(ctx context.Context) error {
-	go func() {
-		ticker := time.NewTicker(500 * time.Millisecond)
+	go t.refresh(ctx)
+	return t.app.Run()
+}
+
+// refresh redraws the screen as changes are reported, or without them, and
+// in accessibility mode, everything on a fixed interval.
+func (t *TUI) refresh(ctx context.Context) {
+	if t.changes == nil || t.accessible {
+		interval := 500 * time.Millisecond
+		if t.accessible {
+			interval = accessibleRefresh
+		}
+		ticker := time.NewTicker(interval)
 		defer ticker.Stop()
 		for {
 			select {
@@ -192,17 +400,53 @@ func (t *TUI) Run(ctx context.Context) error {
 				t.app.Stop()
 				return
 			case <-ticker.C:
-				t.app.QueueUpdateDraw(func() {
-					t.update()
-				})
+				t.redraw(stats.ChangeAll)
 			}
 		}
-	}()
+	}
 
-	return t.app.Run()
+	t.redraw(stats.ChangeAll)
+	idle := time.NewTicker(idleRedraw)
+	defer idle.Stop()
+	for {
+		var c stats.Change
+		select {
+		case <-ctx.Done():
+			t.app.Stop()
+			return
+		case <-t.changes.C():
+			c = t.changes.Take()
+		case <-idle.C:
+			c = stats.ChangeAll &^ stats.ChangeLogs
+		}
+		if c == 0 {
+			continue
+		}
+		t.redraw(c)
+		select {
+		case <-ctx.Done():
+		case <-time.After(minRedraw):
+		}
+	}
+}
+
+func (t *TUI) redraw(c stats.Change) {
+	t.app.QueueUpdateDraw(func() {
+		t.update(c)
+	})
 }
 
-func (t *TUI) update() {
+// update redraws the widgets showing what c says changed.
+func (t *TUI) update(c stats.Change) {
+	if c == stats.ChangeLogs {
+		// The log alone needs none of the stats collected.
+		logs := logger.GetLogs()
+		t.updateLogs(logs)
+		if t.logsRefresh != nil {
+			t.logsRefresh(logs)
+		}
+		return
+	}
 	s := t.statsFunc()
 
 	// Update stat cards
@@ -210,175 +454,328 @@ func (t *TUI) update() {
 	if s.CaptureError != "" {
 		errorMsg = fmt.Sprintf("  [red]Capture Error: %s", s.CaptureError)
 	}
+	if inj := s.Inject; inj.HandleClosed+inj.Transient+inj.Other > 0 {
+		errorMsg += fmt.Sprintf("  [red]Inject: closed %d  transient %d  other %d  [white]retried %d  retry-full %d  reopens %d",
+			inj.HandleClosed, inj.Transient, inj.Other, inj.Retried, inj.RetryDropped, inj.Reopens)
+	}
 
-	demoKey := ""
-	if s.DemoProps != nil {
-		demoKey = "F5: Demo  "
+	// More than one IPX frame type on the wire usually means some machines
+	// cannot see each other.
+	if len(s.FrameTypes) > 1 {
+		errorMsg += fmt.Sprintf("  [yellow]Mixed frame types on %s: %s", s.Interface, formatFrameTypes(s.FrameTypes))
 	}
 
 	listenInfo := ""
-	if s.ListenAddr != "" {
+	if s.ListenError != "" {
+		listenInfo = fmt.Sprintf("  [red]Listener down: %s (retry in %s)", tview.Escape(s.ListenError), retryIn(s.ListenRetry, time.Now()))
+	} else if s.ListenAddr != "" {
 		listenInfo = fmt.Sprintf("  [blue]Listen: %s", s.ListenAddr)
 	}
+	if s.Room != nil {
+		listenInfo += fmt.Sprintf("  [yellow]Room: %s (%d)", tview.Escape(s.Room.Name), len(s.Room.Members))
+	}
+	if b := s.Beacon; b != nil {
+		listenInfo += fmt.Sprintf("  [blue]Beacons: %d", len(b.Beacons))
+	}
+	for _, c := range s.Captures {
+		if c.State == "recording" {
+			listenInfo += fmt.Sprintf("  [red]● REC %d", c.Packets)
+			break
+		}
+	}
+	if r := s.Replication; r != nil {
+		color, text := replicationStatus(r, time.Now())
+		listenInfo += fmt.Sprintf("  [%s]%s", color, tview.Escape(text))
+	}
+	if n := len(t.marked); n > 0 {
+		listenInfo += fmt.Sprintf("  [yellow]Marked: %d (Enter: Batch, Esc: Clear)", n)
+	}
+	if pm := s.PortMapping; pm != nil {
+		if pm.External != "" {
+			listenInfo += fmt.Sprintf("  [blue]External: %s (%s)", pm.External, pm.Method)
+		} else if pm.Error != "" {
+			listenInfo += "  [yellow]No port mapping"
+		}
+	}
+	if c := s.Certificate; c != nil && c.Expires.IsZero() {
+		listenInfo += "  [yellow]No certificate yet"
+	}
 
 	t.statCards.SetText(fmt.Sprintf(
-		"[yellow]RX: [white]%-10s [yellow]TX: [white]%-10s [yellow]Drop: [white]%-10s [yellow]Err: [white]%-10s [yellow]Up: [white]%-10s%s%s\n[blue]F1: Config  F2: Iface  F3: Whois  F4: Settings  F6: Add Peer  %s+/-: Zoom  Enter: Actions  Ctrl+C: Exit",
-		formatPkts(s.TotalReceived), formatPkts(s.TotalForwarded), formatPkts(s.TotalDropped), formatPkts(s.TotalErrors), s.UptimeStr, errorMsg, listenInfo, demoKey,
+		"[yellow]RX: [white]%-10s [yellow]TX: [white]%-10s [yellow]Drop: [white]%-10s [yellow]Err: [white]%-10s [yellow]Up: [white]%-10s%s%s\n%s",
+		formatPkts(s.TotalReceived), formatPkts(s.TotalForwarded), formatPkts(s.TotalDropped), formatPkts(s.TotalErrors), s.UptimeStr, errorMsg, listenInfo, t.footer(),
 	))
 
-	// Update Graph
-	t.updateGraph(s)
+	t.updateBanner(s.NetworkConflicts, s.NetworkAnomalies)
 
-	// Update Map
-	t.drawMap(s.Peers)
+	if t.accessible {
+		t.updateSummary(s)
+	} else {
+		if c&stats.ChangeCounters != 0 {
+			t.updateGraph(s, time.Now())
+		}
+		if c&stats.ChangePeers != 0 {
+			t.drawMap(s)
+		}
+		if c&(stats.ChangePeers|stats.ChangeCounters) != 0 {
+			t.mapPeers = s.Peers
+		}
+		if c&stats.ChangeOps != 0 {
+			t.opsBar.SetText(opsLine(s.Operations, time.Now()))
+		}
+	}
+	if c&stats.ChangeOps != 0 && t.dialRefresh != nil {
+		t.dialRefresh(s.Operations)
+	}
 
-	// Update Logs
-	t.updateLogs(s.Logs)
+	if c&stats.ChangeLogs != 0 {
+		t.updateLogs(s.Logs)
+		if t.logsRefresh != nil {
+			t.logsRefresh(s.Logs)
+		}
+	}
+
+	if c&(stats.ChangePeers|stats.ChangeCounters) != 0 {
+		t.samplePeers(s.Peers, time.Now())
+		t.drawPeers(s)
+		if t.peerRefresh != nil {
+			t.peerRefresh(s)
+		}
+	}
+}
 
-	// Update table
+// drawPeers fills the peer table with the peers matching the search,
+// keeping the selected peer selected as rows move.
+func (t *TUI) drawPeers(s stats.Stats) {
+	prev, _ := t.table.GetSelection()
+	selected, _ := t.table.GetCell(prev, 0).GetReference().(string)
 	t.table.Clear()
-	headers := []string{"ID", "IP", "Hostname", "Connected", "Last Seen", "Sent", "Recv", "Sent (Pkts)", "Recv (Pkts)", "Errors"}
+	cols := t.tableColumns()
+	for i, c := range cols {
+		t.table.SetCell(0, i, t.headerCell(c))
+	}
+	var headers []string
+	if t.accessible {
+		headers = append(headers, "Status")
+	}
+	headers = append(headers, t.columns.Names()...)
 	for i, h := range headers {
-		t.table.SetCell(0, i, tview.NewTableCell(h).SetTextColor(tcell.ColorYellow).SetSelectable(false))
+		t.table.SetCell(0, len(cols)+i, tview.NewTableCell(h).SetTextColor(tcell.ColorYellow).SetSelectable(false))
 	}
 
 	// s.SortPeers() is now called in CollectStats()
-	for i, p := range s.Peers {
-		row := i + 1
+	present := make(map[string]bool, len(s.Peers))
+	sel, shown := 0, 0
+	for _, p := range t.sortByRate(s.Peers) {
+		present[p.ID] = true
+		if !matchPeer(p, t.search.GetText()) {
+			continue
+		}
+		shown++
+		row := shown
+		if p.ID == selected {
+			sel = row
+		}
 		color := tcell.ColorWhite
-		if time.Since(p.LastSeen) > 10*time.Second {
+		if time.Since(p.LastSeen) > staleAfter {
 			color = tcell.ColorRed
+		} else if p.Emulated {
+			color = tcell.ColorAqua
 		} else {
 			color = tcell.ColorGreen
 		}
 
-		t.table.SetCell(row, 0, tview.NewTableCell(p.ID).SetTextColor(color))
-		t.table.SetCell(row, 1, tview.NewTableCell(p.IP.String()).SetTextColor(color))
-		t.table.SetCell(row, 2, tview.NewTableCell(p.Hostname).SetTextColor(color))
-		t.table.SetCell(row, 3, tview.NewTableCell(p.ConnectedAt.Format("15:04:05")).SetTextColor(color))
-		t.table.SetCell(row, 4, tview.NewTableCell(time.Since(p.LastSeen).Round(time.Second).String()).SetTextColor(color))
-		t.table.SetCell(row, 5, tview.NewTableCell(formatBytes(p.SentBytes)).SetTextColor(color))
-		t.table.SetCell(row, 6, tview.NewTableCell(formatBytes(p.RecvBytes)).SetTextColor(color))
-		t.table.SetCell(row, 7, tview.NewTableCell(formatPkts(p.SentPkts)).SetTextColor(color))
-		t.table.SetCell(row, 8, tview.NewTableCell(formatPkts(p.RecvPkts)).SetTextColor(color))
-		t.table.SetCell(row, 9, tview.NewTableCell(formatPkts(p.Errors)).SetTextColor(color))
+		for i, c := range cols {
+			cell := tview.NewTableCell(c.value(t, p)).SetTextColor(color)
+			if c.color != nil {
+				if own, ok := c.color(t, p); ok {
+					cell.SetTextColor(own)
+				}
+			}
+			if i == 0 {
+				// The first column carries the peer and its mark.
+				cell.SetText(t.markPrefix(p.ID) + cell.Text).SetReference(p.ID)
+			}
+			t.table.SetCell(row, i, cell)
+		}
+		col := len(cols)
+		if t.accessible {
+			t.table.SetCell(row, col, tview.NewTableCell(peerStatus(p)).SetTextColor(color))
+			col++
+		}
+		for j, v := range t.columns.Values(p) {
+			t.table.SetCell(row, col+j, tview.NewTableCell(tview.Escape(v)).SetTextColor(color))
+		}
+	}
+	// Peers that left are no longer marked.
+	for id := range t.marked {
+		if !present[id] {
+			delete(t.marked, id)
+		}
+	}
+	t.showSearchCount(shown, len(s.Peers))
+	if sel == 0 {
+		// The selected peer left or no longer matches: stay on its row.
+		sel = max(1, min(prev, shown))
+	}
+	if sel != prev {
+		t.table.Select(sel, 0)
 	}
 }
 
-func (t *TUI) updateGraph(s stats.Stats) {
-	t.rxHistory = append(t.rxHistory, s.TotalReceived)
-	t.txHistory = append(t.txHistory, s.TotalForwarded)
-	if len(t.rxHistory) > 7200 {
-		t.rxHistory = t.rxHistory[1:]
-		t.txHistory = t.txHistory[1:]
+// SetColumns shares the relay's peer columns, whose notes can change when
+// they are replicated from a primary hub.
+func (t *TUI) SetColumns(cols *stats.Columns) {
+	t.columns = cols
+}
+
+// retryIn is how long until a retry at t, in whole seconds.
+func retryIn(t, now time.Time) time.Duration {
+	return max(t.Sub(now), 0).Round(time.Second)
+}
+
+// replicationStatus describes warm standby replication in a few words,
+// with the color to show them in.
+func replicationStatus(r *stats.ReplicationStats, now time.Time) (string, string) {
+	if r.Role == "standby" {
+		p := r.Partners[0]
+		switch {
+		case r.Active:
+			return "red", "Standby active, primary " + p.Addr + " lost"
+		case r.Error != "":
+			return "yellow", "Standby: " + r.Error
+		case !p.Connected:
+			return "yellow", "Standby: primary " + p.Addr + " down"
+		case p.LastSync.IsZero():
+			return "yellow", "Standby: waiting for the primary's config"
+		}
+		return "green", fmt.Sprintf("Standby synced %s ago", stats.FormatDuration(now.Sub(p.LastSync)))
 	}
+	if r.Error != "" {
+		return "yellow", "Replication: " + r.Error
+	}
+	synced := 0
+	for _, p := range r.Partners {
+		if p.Connected && !p.LastSync.IsZero() {
+			synced++
+		}
+	}
+	color := "blue"
+	if synced < len(r.Partners) || synced == 0 {
+		color = "yellow"
+	}
+	return color, fmt.Sprintf("Standbys: %d/%d synced", synced, len(r.Partners))
+}
+
+// exportPeersCSV writes the peer table, custom columns included, to a
+// timestamped CSV file in the working directory.
+func (t *TUI) exportPeersCSV() {
+	t.savePeersCSV(t.statsFunc().Peers)
+}
 
-	if len(t.rxHistory) < 2 {
+func (t *TUI) savePeersCSV(peers []stats.PeerStat) {
+	path := fmt.Sprintf("ipxtransporter-peers-%s.csv", time.Now().Format("20060102-150405"))
+	f, err := os.Create(path)
+	if err != nil {
+		t.showError("Failed to export: " + err.Error())
 		return
 	}
-
-	_, _, width, height := t.graphView.GetInnerRect()
-	if width <= 0 || height <= 0 {
+	err = stats.WritePeersCSV(f, peers, t.columns)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		t.showError("Failed to export: " + err.Error())
 		return
 	}
+	abs, _ := filepath.Abs(path)
+	t.showMessage("Peers exported to " + abs)
+}
 
-	// Calculate points per column based on graphStep
-	// Each point in history is 500ms
-	// graphStep 1 = 500ms per column
-	// graphStep 2 = 1s per column
-	// etc.
-	pointsNeeded := width * t.graphStep
-	if pointsNeeded < 120 { // Ensure we always try to show at least 60s if possible (120 points)
-		pointsNeeded = 120
+func formatMuted(p stats.PeerStat) string {
+	switch {
+	case p.MutedIn && p.MutedOut:
+		return "in/out"
+	case p.MutedIn:
+		return "in"
+	case p.MutedOut:
+		return "out"
 	}
+	return "-"
+}
 
-	// Calculate actual rates to display
-	numCols := width
-	displayRX := make([]uint64, numCols)
-	displayTX := make([]uint64, numCols)
-	var maxRate uint64 = 1
-
-	for i := 0; i < numCols; i++ {
-		// Calculate the range of history indices for this column
-		// We work backwards from the end
-		endIdx := len(t.rxHistory) - 1 - (numCols-1-i)*t.graphStep
-		startIdx := endIdx - t.graphStep
-
-		if startIdx < 0 {
-			continue
-		}
-
-		// Sum the rates in this interval
-		var rxSum, txSum uint64
-		for j := startIdx; j < endIdx; j++ {
-			rxSum += t.rxHistory[j+1] - t.rxHistory[j]
-			txSum += t.txHistory[j+1] - t.txHistory[j]
+// formatFrameTypes summarises per-frame-type packet counts in a fixed order.
+func formatFrameTypes(counts map[string]stats.FrameTypeCount) string {
+	var parts []string
+	order := append([]ipx.FrameType{}, ipx.FrameTypes...)
+	for _, ft := range append(order, ipx.FrameUnknown) {
+		if c, ok := counts[ft.String()]; ok {
+			parts = append(parts, fmt.Sprintf("%s rx %s/tx %s", ft, formatPkts(c.RxPkts), formatPkts(c.TxPkts)))
 		}
-		displayRX[i] = rxSum
-		displayTX[i] = txSum
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, ", ")
+}
 
-		if rxSum > maxRate {
-			maxRate = rxSum
-		}
-		if txSum > maxRate {
-			maxRate = txSum
+// updateBanner shows a warning line above the peer table for each IPX
+// network number claimed by more than one segment or sent from by a peer
+// that did not declare it, and hides it otherwise.
+func (t *TUI) updateBanner(conflicts []stats.NetworkConflict, anomalies []stats.NetworkAnomaly) {
+	var lines []string
+	for _, c := range conflicts {
+		lines = append(lines, fmt.Sprintf("[yellow::b] WARNING:[white::-] IPX network %s is claimed by multiple segments: %s (since %s)",
+			c.NetworkStr, strings.Join(c.Sources, ", "), c.DetectedAt.Format("15:04:05")))
+	}
+	for _, a := range anomalies {
+		action := "relayed"
+		if a.Dropped {
+			action = "dropped"
 		}
+		lines = append(lines, fmt.Sprintf("[yellow::b] ANOMALY:[white::-] IPX network %s from peer %s (node %s) was not declared, %d packets %s (since %s)",
+			a.NetworkStr, a.Peer, a.NodeID, a.Packets, action, a.FirstSeen.Format("15:04:05")))
 	}
+	t.banner.SetText(strings.Join(lines, "\n"))
+	t.mainFlex.ResizeItem(t.banner, len(lines), 0)
+}
 
-	// Update title with time range
-	timeRange := time.Duration(numCols*t.graphStep) * 500 * time.Millisecond
-	t.graphView.SetTitle(fmt.Sprintf("Traffic Graph (Last %v)", timeRange.Round(time.Second)))
-
-	// Plot graph
-	graph := ""
-	for h := height - 1; h >= 0; h-- {
-		line := ""
-		for i := 0; i < numCols; i++ {
-			rxVal := displayRX[i]
-			txVal := displayTX[i]
-
-			if rxVal == 0 && txVal == 0 {
-				line += " "
-				continue
-			}
-
-			rxLevel := int(rxVal * uint64(height) / maxRate)
-			txLevel := int(txVal * uint64(height) / maxRate)
-
-			char := " "
-			color := ""
-			if h < rxLevel && h < txLevel {
-				char = "•"
-				if rxVal+txVal > maxRate*2/3 {
-					color = "magenta"
-				} else {
-					color = "darkmagenta"
-				}
-			} else if h < rxLevel {
-				char = "•"
-				if rxVal > maxRate*2/3 {
-					color = "green"
-				} else {
-					color = "darkgreen"
-				}
-			} else if h < txLevel {
-				char = "•"
-				if txVal > maxRate*2/3 {
-					color = "blue"
-				} else {
-					color = "darkblue"
-				}
-			}
-
-			if color != "" {
-				line += fmt.Sprintf("[%s]%s[-]", color, char)
-			} else {
-				line += " "
-			}
+// sampleTraffic adds the totals to the history, one point per 500ms since
+// the last. Redraws come as traffic does, so a quiet spell is filled with
+// the totals it started with. The first sample brings in the relay's own
+// history, so a new TUI does not start with an empty graph.
+func (t *TUI) sampleTraffic(s stats.Stats, now time.Time) {
+	if t.sampledAt.IsZero() && t.history != nil {
+		samples, _ := t.history(graphPoints * 500 * time.Millisecond)
+		t.seedTraffic(samples, s, now)
+		return
+	}
+	points := 1
+	if !t.sampledAt.IsZero() {
+		points = int(now.Sub(t.sampledAt) / (500 * time.Millisecond))
+		if points == 0 {
+			return
 		}
-		graph += line + "\n"
+		t.sampledAt = t.sampledAt.Add(time.Duration(points) * 500 * time.Millisecond)
+	} else {
+		t.sampledAt = now
+	}
+	points = min(points, graphPoints)
+	if t.graphOffset > 0 {
+		// Keep a panned graph still as points come in.
+		t.graphOffset += points
 	}
-	t.graphView.SetText(graph)
+	for i := 1; i < points && len(t.rxHistory) > 0; i++ {
+		t.rxHistory = append(t.rxHistory, t.rxHistory[len(t.rxHistory)-1])
+		t.txHistory = append(t.txHistory, t.txHistory[len(t.txHistory)-1])
+		t.rxBytes = append(t.rxBytes, t.rxBytes[len(t.rxBytes)-1])
+		t.txBytes = append(t.txBytes, t.txBytes[len(t.txBytes)-1])
+	}
+	t.rxHistory = append(t.rxHistory, s.TotalReceived)
+	t.txHistory = append(t.txHistory, s.TotalForwarded)
+	t.rxBytes = append(t.rxBytes, s.ReceivedBytes)
+	t.txBytes = append(t.txBytes, s.ForwardedBytes)
+	t.trimTraffic()
 }
 
 func (t *TUI) zoomGraph(delta int) {
@@ -389,9 +786,8 @@ func (t *TUI) zoomGraph(delta int) {
 	if t.graphStep > 120 { // Max 1 minute per column (1 hour total view approx if width is 60)
 		t.graphStep = 120
 	}
-	t.app.QueueUpdateDraw(func() {
-		t.update()
-	})
+	t.panGraph(0)
+	t.redraw(stats.ChangeCounters)
 }
 
 func formatBytes(b uint64) string {
@@ -417,38 +813,34 @@ func formatPkts(p uint64) string {
 	return fmt.Sprintf("%.1fM", float64(p)/1000000)
 }
 
-func (t *TUI) showInterfaceSelection() {
-	ifaces, err := capture.ListInterfaces()
-	if err != nil {
-		t.showError("Failed to list interfaces: " + err.Error())
-		return
+// formatSegment describes the local segment a node reported upstream.
+func formatSegment(seg *stats.SegmentReport) string {
+	out := fmt.Sprintf("[blue]%d stations", seg.Stations)
+	if seg.Interface != "" {
+		out += " on " + tview.Escape(seg.Interface)
 	}
-
-	list := tview.NewList()
-	for _, iface := range ifaces {
-		name := iface
-		list.AddItem(name, "", 0, func() {
-			t.cfg.Interface = name
-			t.pages.RemovePage("iface_select")
-			t.showError("Interface set to " + name + ". Restart required for changes to take effect.")
-		})
+	if seg.Received > 0 || seg.Forwarded > 0 {
+		out += fmt.Sprintf(", RX %s TX %s", formatPkts(seg.Received), formatPkts(seg.Forwarded))
 	}
-	list.AddItem("Cancel", "Go back", 'c', func() {
-		t.pages.RemovePage("iface_select")
-	})
-
-	list.SetBorder(true).SetTitle("Select Interface")
-	t.pages.AddPage("iface_select", t.center(list, 40, 20), true, true)
+	out += "[-]"
+	if !seg.CaptureOK {
+		if seg.CaptureError != "" {
+			return out + " [red]capture: " + tview.Escape(seg.CaptureError) + "[-]"
+		}
+		return out + " [red]capture failing[-]"
+	}
+	return out
 }
 
 func (t *TUI) showConfigEditor() {
+	newPass := "" // left empty, the password is kept
 	form := tview.NewForm().
 		AddInputField("Interface", t.cfg.Interface, 20, nil, func(text string) { t.cfg.Interface = text }).
 		AddInputField("Listen Addr", t.cfg.ListenAddr, 20, nil, func(text string) { t.cfg.ListenAddr = text }).
 		AddInputField("HTTP Listen", t.cfg.HTTPListenAddr, 20, nil, func(text string) { t.cfg.HTTPListenAddr = text }).
 		AddCheckbox("Enable HTTP", t.cfg.EnableHTTP, func(checked bool) { t.cfg.EnableHTTP = checked }).
 		AddCheckbox("Disable SSL", t.cfg.DisableSSL, func(checked bool) { t.cfg.DisableSSL = checked }).
-		AddPasswordField("Admin Password", t.cfg.AdminPass, 20, '*', func(text string) { t.cfg.AdminPass = text }).
+		AddPasswordField("Admin Password", "", 20, '*', func(text string) { newPass = text }).
 		AddInputField("Network Key", t.cfg.NetworkKey, 20, nil, func(text string) { t.cfg.NetworkKey = text }).
 		AddInputField("Max Children", fmt.Sprintf("%d", t.cfg.MaxChildren), 5, tview.InputFieldInteger, func(text string) {
 			fmt.Sscanf(text, "%d", &t.cfg.MaxChildren)
@@ -458,11 +850,21 @@ func (t *TUI) showConfigEditor() {
 			fmt.Sscanf(text, "%d", &t.cfg.RebalanceInterval)
 		}).
 		AddButton("Save", func() {
+			if newPass != "" {
+				if err := t.cfg.SetAdminPass(newPass); err != nil {
+					t.showError("Failed to set the password: " + err.Error())
+					return
+				}
+				newPass = ""
+			}
 			t.showSaveDialog()
 		}).
 		AddButton("Cancel", func() {
 			t.pages.RemovePage("config_editor")
 		})
+	if t.backups != nil {
+		form.AddButton("Backups", t.showBackups)
+	}
 
 	form.SetBorder(true).SetTitle("Edit Configuration")
 	t.pages.AddPage("config_editor", t.center(form, 60, 15), true, true)
@@ -589,6 +991,11 @@ func (t *TUI) showFilenamePrompt(dir string) {
 }
 
 func (t *TUI) showError(msg string) {
+	t.showMessage(msg)
+}
+
+// showMessage shows msg until dismissed.
+func (t *TUI) showMessage(msg string) {
 	modal := tview.NewModal().
 		SetText(msg).
 		AddButtons([]string{"OK"}).
@@ -600,26 +1007,52 @@ func (t *TUI) showError(msg string) {
 
 func (t *TUI) showWhois() {
 	row, _ := t.table.GetSelection()
-	if row <= 0 {
+	p, ok := t.selectedPeer(row)
+	if !ok {
 		return
 	}
-	s := t.statsFunc()
-	if row > len(s.Peers) {
-		return
-	}
-	// Peers were sorted by ID in update()
-	sort.Slice(s.Peers, func(i, j int) bool {
-		return s.Peers[i].ID < s.Peers[j].ID
-	})
-	p := s.Peers[row-1]
 
 	childConsumption := 0.0
 	if p.MaxChildren > 0 {
 		childConsumption = float64(p.NumChildren) / float64(p.MaxChildren) * 100
 	}
 
-	whoisText := fmt.Sprintf("ID: %s\nIP: %s\nHostname: %s\nLocation: %s, %s\nLat/Lon: %.2f, %.2f\n\nLatency: %.1f ms\nConnections: %d/%d (%.1f%%)\n\n%s",
-		p.ID, p.IP, p.Hostname, p.City, p.Country, p.Lat, p.Lon, p.LatencyMs, p.NumChildren, p.MaxChildren, childConsumption, p.Whois)
+	key := p.KeyFingerprint
+	if key == "" {
+		key = "unsigned"
+	}
+
+	padding := "off"
+	if p.Padding != "" {
+		padding = fmt.Sprintf("%s (%s overhead)", p.Padding, formatBytes(p.PadBytes))
+	}
+
+	protocol := "no hello yet"
+	if p.Protocol > 0 {
+		protocol = fmt.Sprintf("%d (%s)", p.Protocol, strings.Join(p.Features, ", "))
+		if p.Downgrades > 0 {
+			protocol += fmt.Sprintf(", negotiated down %d times", p.Downgrades)
+		}
+	}
+
+	id := p.ID
+	if p.Name != "" {
+		id = fmt.Sprintf("%s (%s)", p.Name, p.ID)
+	}
+	var limits []string
+	if p.Deflated > 0 {
+		limits = append(limits, fmt.Sprintf("%s saved by compression", formatBytes(p.Deflated)))
+	}
+	if p.RateDrops > 0 || p.FilterDrops > 0 {
+		limits = append(limits, fmt.Sprintf("%d over the rate limit, %d filtered", p.RateDrops, p.FilterDrops))
+	}
+	if len(limits) > 0 {
+		padding += "\nLink: " + strings.Join(limits, "; ")
+	}
+
+	whoisText := fmt.Sprintf("ID: %s\nIP: %s\nHostname: %s\nLocation: %s, %s\nLat/Lon: %.2f, %.2f\n\nLatency: %.1f ms\nConnections: %d/%d (%.1f%%)\nFrame types: %s\nTrust: %s\nKey: %s\nProtocol: %s\nMemory: %s (%s queued, %d dropped)\nPadding: %s\n\n%s",
+		id, p.IP, p.Hostname, p.City, p.Country, p.Lat, p.Lon, p.LatencyMs, p.NumChildren, p.MaxChildren, childConsumption, formatFrameTypes(p.FrameTypes),
+		p.Trust, key, protocol, formatBytes(p.MemBytes), formatBytes(p.QueuedBytes), p.QueueDrops, padding, p.Whois)
 
 	modal := tview.NewModal().
 		SetText(whoisText).
@@ -632,7 +1065,7 @@ func (t *TUI) showWhois() {
 }
 
 func (t *TUI) showSettings() {
-	options := []string{"id", "ip", "hostname", "connected", "last_seen", "children", "sent_bytes", "recv_bytes", "sent_pkts", "recv_pkts", "errors"}
+	options := []string{"id", "ip", "hostname", "connected", "last_seen", "children", "sent_bytes", "recv_bytes", "sent_pkts", "recv_pkts", "errors", "stations", "latency", "country", "rx_rate", "tx_rate"}
 	currentIndex := 0
 	for i, opt := range options {
 		if opt == t.cfg.SortField {
@@ -655,12 +1088,23 @@ func (t *TUI) showSettings() {
 				config.SaveConfig(t.configPath, t.cfg)
 			}
 		}).
+		AddDropDown("Theme", config.Themes, max(slices.Index(config.Themes, t.cfg.Theme), 0), func(option string, _ int) {
+			if option == t.cfg.Theme {
+				return
+			}
+			t.cfg.Theme = option
+			t.theme = themes[option]
+			if t.configPath != "" {
+				config.SaveConfig(t.configPath, t.cfg)
+			}
+		}).
+		AddButton("Columns", t.showColumnChooser).
 		AddButton("Close", func() {
 			t.pages.RemovePage("settings")
 		})
 
 	form.SetBorder(true).SetTitle("UI Settings")
-	t.pages.AddPage("settings", t.center(form, 40, 10), true, true)
+	t.pages.AddPage("settings", t.center(form, 40, 12), true, true)
 }
 
 func (t *TUI) showDemoSettings() {
@@ -673,8 +1117,13 @@ func (t *TUI) showDemoSettings() {
 	dropRate := s.DemoProps.DropRate
 	errorRate := s.DemoProps.ErrorRate
 	numPeers := s.DemoProps.NumPeers
+	pattern := s.DemoProps.Pattern
+	current := max(slices.Index(s.DemoProps.Patterns, pattern), 0)
 
 	form := tview.NewForm().
+		AddDropDown("Pattern", s.DemoProps.Patterns, current, func(option string, index int) {
+			pattern = option
+		}).
 		AddInputField("Packet Rate", fmt.Sprintf("%d", packetRate), 5, tview.InputFieldInteger, func(text string) {
 			fmt.Sscanf(text, "%d", &packetRate)
 		}).
@@ -688,7 +1137,7 @@ func (t *TUI) showDemoSettings() {
 			fmt.Sscanf(text, "%d", &numPeers)
 		}).
 		AddButton("Apply", func() {
-			t.onDemoUpdate(packetRate, dropRate, errorRate, numPeers)
+			t.onDemoUpdate(packetRate, dropRate, errorRate, numPeers, pattern)
 			t.pages.RemovePage("demo_settings")
 		}).
 		AddButton("Cancel", func() {
@@ -696,21 +1145,31 @@ func (t *TUI) showDemoSettings() {
 		})
 
 	form.SetBorder(true).SetTitle("Demo Mode Settings")
-	t.pages.AddPage("demo_settings", t.center(form, 40, 15), true, true)
+	t.pages.AddPage("demo_settings", t.center(form, 40, 17), true, true)
 }
 
+// SetMute enables the mute inbound/outbound peer actions.
+func (t *TUI) SetMute(fn func(id string, inbound, outbound bool)) {
+	t.onMute = fn
+}
+
+// showPeerActions opens the actions for the peer at row, or for the
+// marked peers if there are any.
 func (t *TUI) showPeerActions(row int) {
-	if row <= 0 {
+	if len(t.marked) > 0 {
+		t.showBatchActions()
 		return
 	}
-	s := t.statsFunc()
-	if row > len(s.Peers) {
+	p, ok := t.selectedPeer(row)
+	if !ok {
 		return
 	}
 
-	p := s.Peers[row-1]
-
 	list := tview.NewList()
+	list.AddItem("Details", "Traffic history and link state, live", 'v', func() {
+		t.pages.RemovePage("peer_actions")
+		t.showPeerDetail(row)
+	})
 	list.AddItem("Disconnect", "Close connection", 'd', func() {
 		if t.onDisconnect != nil {
 			t.onDisconnect(p.ID)
@@ -723,6 +1182,29 @@ func (t *TUI) showPeerActions(row int) {
 		}
 		t.pages.RemovePage("peer_actions")
 	})
+	if t.onBanFor != nil {
+		list.AddItem("Ban for 48 Hours", "Disconnect and ban, lifted automatically", 't', func() {
+			t.onBanFor(p.ID, p.IP.String(), 48*time.Hour)
+			t.pages.RemovePage("peer_actions")
+		})
+	}
+	if t.onMute != nil {
+		inLabel, outLabel := "Mute Inbound", "Mute Outbound"
+		if p.MutedIn {
+			inLabel = "Unmute Inbound"
+		}
+		if p.MutedOut {
+			outLabel = "Unmute Outbound"
+		}
+		list.AddItem(inLabel, "Stop injecting traffic from this peer", 'i', func() {
+			t.onMute(p.ID, !p.MutedIn, p.MutedOut)
+			t.pages.RemovePage("peer_actions")
+		})
+		list.AddItem(outLabel, "Stop forwarding traffic to this peer", 'o', func() {
+			t.onMute(p.ID, p.MutedIn, !p.MutedOut)
+			t.pages.RemovePage("peer_actions")
+		})
+	}
 	list.AddItem("WHOIS Info", "Show detailed information", 'w', func() {
 		t.pages.RemovePage("peer_actions")
 		t.showWhois()
@@ -732,80 +1214,106 @@ func (t *TUI) showPeerActions(row int) {
 	})
 
 	list.SetBorder(true).SetTitle(fmt.Sprintf("Actions for %s", p.ID))
-	t.pages.AddPage("peer_actions", t.center(list, 40, 12), true, true)
+	t.pages.AddPage("peer_actions", t.center(list, 44, 20), true, true)
 }
 
-func (t *TUI) showAddPeerDialog() {
-	if t.onAddPeer == nil {
-		return
+func (t *TUI) drawMap(s stats.Stats) {
+	// Node Topology Map: the overlay tree as reported by our peers, drawn
+	// from its root(s). Nodes without a direct link are shown in gray.
+	known := make(map[string]bool, len(s.Topology))
+	for _, n := range s.Topology {
+		known[n.ID] = true
 	}
-	var form *tview.Form
-	form = tview.NewForm().
-		AddInputField("Peer Address", "", 40, nil, nil).
-		AddButton("Add", func() {
-			item := form.GetFormItem(0)
-			if input, ok := item.(*tview.InputField); ok {
-				addr := input.GetText()
-				if addr != "" {
-					t.onAddPeer(context.Background(), addr)
-				}
-			}
-			t.pages.RemovePage("add_peer")
-		}).
-		AddButton("Cancel", func() {
-			t.pages.RemovePage("add_peer")
-		})
-	form.SetBorder(true).SetTitle("Add New Peer")
-	t.pages.AddPage("add_peer", t.center(form, 60, 9), true, true)
-}
-
-func (t *TUI) drawMap(peers []stats.PeerStat) {
-	// Node Topology Map
-	byParent := make(map[string][]stats.PeerStat)
-	for _, p := range peers {
-		parent := p.ParentID
-		if parent == "" {
-			parent = "Local"
+	byParent := make(map[string][]stats.TopologyNode)
+	var roots []stats.TopologyNode
+	for _, n := range s.Topology {
+		if n.ParentID == "" || !known[n.ParentID] {
+			roots = append(roots, n)
+			continue
 		}
-		byParent[parent] = append(byParent[parent], p)
+		byParent[n.ParentID] = append(byParent[n.ParentID], n)
 	}
 
-	var buildTree func(string, string) string
-	buildTree = func(id string, indent string) string {
-		label := id
-		for _, p := range peers {
-			if p.ID == id {
-				if p.Hostname != "" {
-					label = p.Hostname
-				}
-				break
+	hostnames := make(map[string]string, len(s.Peers))
+	for _, p := range s.Peers {
+		hostnames[p.ID] = p.Hostname
+	}
+	nodeName := func(id string) string {
+		for _, n := range s.Topology {
+			if n.ID == id && n.Hostname != "" {
+				return n.Hostname
 			}
 		}
-		if id == "Local" {
+		return id
+	}
+
+	visited := make(map[string]bool)
+	var buildTree func(stats.TopologyNode, string) string
+	buildTree = func(n stats.TopologyNode, indent string) string {
+		if visited[n.ID] {
+			return ""
+		}
+		visited[n.ID] = true
+
+		label := n.ID
+		switch {
+		case n.ID == s.NodeID:
 			label = "[green]Local Node[-]"
+		case n.PeerID != "":
+			if h := hostnames[n.PeerID]; h != "" {
+				label = h
+			} else if n.Hostname != "" {
+				label = n.Hostname
+			}
+		default:
+			if n.Hostname != "" {
+				label = n.Hostname
+			}
+			label = "[gray]" + label + "[-]"
+		}
+
+		if n.ID == s.Hub {
+			label += " [yellow](hub)[-]"
+		}
+		if n.Metric > 0 && known[n.ParentID] {
+			// The metric of the link up to the node drawn above.
+			label += fmt.Sprintf(" [gray]metric %d[-]", n.Metric)
+		}
+		if n.RelayedVia != "" {
+			label += " [aqua](relayed via " + nodeName(n.RelayedVia) + ")[-]"
+		}
+		if seg := n.Segment; seg != nil {
+			label += " " + formatSegment(seg)
+		} else if n.Stations > 0 {
+			label += fmt.Sprintf(" [blue]%d stations[-]", n.Stations)
 		}
 
 		res := indent + "• " + label + "\n"
-		children := byParent[id]
-		for _, child := range children {
-			res += buildTree(child.ID, indent+"  ")
+		if n.ID == s.NodeID {
+			for _, c := range s.VirtualClients {
+				res += fmt.Sprintf("%s  ◦ [aqua]%s:%s[-] (%s)\n", indent, c.Network, c.Node, c.Addr)
+			}
+			for _, r := range s.Relays {
+				res += fmt.Sprintf("%s  ⇄ [aqua]%s ⇄ %s[-] (%s pkts relayed)\n", indent, nodeName(r.From), nodeName(r.To), formatPkts(r.Packets))
+			}
+		}
+		for _, child := range byParent[n.ID] {
+			res += buildTree(child, indent+"  ")
 		}
 		return res
 	}
 
-	t.mapView.SetText(buildTree("Local", ""))
+	text := ""
+	for _, r := range roots {
+		text += buildTree(r, "")
+	}
+	t.mapView.SetText(text)
 }
 
 func (t *TUI) updateLogs(logs []logger.LogMessage) {
 	text := ""
 	for _, l := range logs {
-		color := "white"
-		if l.Level == "ERROR" || l.Level == "FATAL" {
-			color = "red"
-		} else if l.Level == "INFO" {
-			color = "green"
-		}
-		text += fmt.Sprintf("[%s]%s: %s[-]\n", color, l.Timestamp.Format("15:04:05"), l.Message)
+		text += logLine(l, t.accessible)
 	}
 	t.logView.SetText(text)
 	t.logView.ScrollToEnd()
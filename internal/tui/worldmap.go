@@ -0,0 +1,240 @@
+// SPDX-License-Identifier: BSD-3-Clause
+// IPXTransporter – Author: Mark LaPointe <mark@cloudbsd.org>
+// World map mode of the topology pane: peers plotted by GeoIP on a braille map
+
+package tui
+
+import (
+	"fmt"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/mlapointe/ipxtransporter/internal/stats"
+	"github.com/rivo/tview"
+)
+
+// The map is an equirectangular projection cut to the latitudes people
+// live at.
+const (
+	mapNorth = 84.0
+	mapSouth = -58.0
+)
+
+// landOutlines are rough coastlines as longitude, latitude points: enough
+// to recognise the continents in a pane 64 characters wide.
+var landOutlines = [][][2]float64{
+	// North and Central America
+	{{-168, 66}, {-162, 70}, {-156, 71}, {-140, 70}, {-128, 70}, {-115, 68}, {-95, 72}, {-82, 73}, {-80, 64},
+		{-94, 58}, {-90, 56}, {-82, 52}, {-78, 55}, {-76, 62}, {-65, 60}, {-61, 56}, {-56, 52}, {-60, 47}, {-66, 44},
+		{-70, 42}, {-76, 38}, {-76, 35}, {-81, 31}, {-80, 25}, {-82, 27}, {-84, 30}, {-90, 29}, {-97, 27}, {-97, 22},
+		{-94, 18}, {-88, 21}, {-87, 16}, {-83, 15}, {-83, 10}, {-79, 9}, {-77, 8}, {-80, 7}, {-86, 11}, {-92, 14},
+		{-97, 16}, {-105, 20}, {-106, 23}, {-109, 26}, {-112, 29}, {-114.5, 31.5}, {-112, 28}, {-110, 23}, {-112, 25},
+		{-115, 28}, {-117, 32}, {-121, 35}, {-124, 40}, {-124, 47}, {-128, 51}, {-133, 55}, {-140, 59}, {-150, 60},
+		{-153, 57}, {-158, 56}, {-165, 54}, {-158, 58}, {-162, 60}, {-166, 62}},
+	// Arctic Canada
+	{{-125, 72}, {-115, 76}, {-95, 80}, {-75, 83}, {-62, 82}, {-70, 78}, {-80, 74}, {-90, 74}, {-100, 70}, {-110, 70}},
+	// Greenland
+	{{-73, 78}, {-60, 82}, {-35, 83}, {-20, 82}, {-18, 77}, {-22, 70}, {-32, 68}, {-40, 65}, {-43, 60}, {-50, 62},
+		{-53, 67}, {-55, 71}, {-60, 76}},
+	// Cuba
+	{{-85, 22}, {-80, 23}, {-74, 20}, {-78, 20}},
+	// South America
+	{{-77, 8}, {-72, 12}, {-63, 11}, {-60, 8}, {-52, 5}, {-50, 0}, {-44, -2}, {-35, -5}, {-35, -9}, {-39, -14},
+		{-40, -22}, {-48, -26}, {-53, -34}, {-58, -38}, {-62, -40}, {-65, -45}, {-67, -50}, {-69, -55}, {-74, -52},
+		{-75, -46}, {-73, -38}, {-71, -30}, {-70, -18}, {-76, -14}, {-81, -6}, {-80, -1}, {-78, 2}},
+	// Iceland, Great Britain and Ireland
+	{{-24, 65}, {-22, 66.5}, {-14, 66.5}, {-13, 65}, {-18, 63.5}, {-22, 64}},
+	{{-5, 50}, {1, 51}, {2, 53}, {-2, 56}, {-2, 58}, {-5, 58.5}, {-6, 56}, {-3, 54.5}, {-5, 52}},
+	{{-10, 52}, {-6, 52}, {-6, 55}, {-8, 55}, {-10, 54}},
+	// Europe and Asia
+	{{-9, 37}, {-9, 43}, {-2, 43.5}, {-4.5, 48}, {2, 51}, {5, 53}, {8, 54}, {9, 57}, {8, 58}, {5, 59}, {5, 62},
+		{10, 64}, {14, 68}, {20, 70}, {28, 71}, {31, 70}, {40, 68}, {44, 68}, {54, 69}, {60, 70}, {69, 73}, {80, 73},
+		{88, 76}, {100, 78}, {112, 75}, {130, 72}, {140, 72}, {152, 71}, {162, 70}, {170, 70}, {180, 69}, {180, 65},
+		{178, 64}, {173, 61}, {163, 60}, {162, 56}, {156, 51}, {156, 57}, {150, 59}, {143, 59}, {137, 54}, {140, 48},
+		{135, 43}, {130, 42}, {129, 35}, {126, 35}, {126, 38}, {125, 40}, {121, 40}, {122, 37}, {119, 35}, {121, 31},
+		{122, 28}, {119, 25}, {114, 22}, {108, 21}, {106, 17}, {109, 12}, {105, 9}, {103, 10}, {100, 13}, {100, 8},
+		{104, 1}, {101, 3}, {98, 8}, {98, 16}, {94, 17}, {92, 22}, {87, 22}, {80, 15}, {80, 10}, {77, 8}, {73, 16},
+		{72, 21}, {67, 24}, {62, 25}, {57, 25}, {56, 27}, {51, 28}, {48, 30}, {50, 25}, {52, 24}, {56, 26}, {57, 23},
+		{59, 22}, {55, 17}, {52, 16}, {45, 13}, {43, 13}, {40, 16}, {35, 28}, {34, 28}, {32, 31}, {35, 33}, {36, 36},
+		{30, 36}, {27, 37}, {26, 40}, {23, 40}, {24, 38}, {22, 37}, {21, 39}, {19, 42}, {14, 45}, {12, 44}, {16, 41},
+		{18, 40}, {16, 38}, {15, 40}, {12, 42}, {10, 44}, {7, 43.5}, {3, 43}, {3, 42}, {0, 39}, {-2, 37}, {-5, 36}},
+	// Japan
+	{{130, 31}, {131, 34}, {135, 34}, {140, 36}, {140, 41}, {142, 45}, {145, 44}, {142, 40}, {141, 36}, {138, 34}, {132, 33}},
+	// Africa and Madagascar
+	{{-17, 21}, {-17, 15}, {-15, 11}, {-12, 7}, {-8, 4.5}, {-2, 5}, {5, 6}, {9, 4}, {10, 2}, {9, -1}, {12, -5},
+		{13, -12}, {12, -17}, {15, -27}, {18, -32}, {20, -35}, {26, -34}, {33, -28}, {35, -24}, {35, -18}, {40, -15},
+		{40, -10}, {39, -5}, {42, -1}, {48, 5}, {51, 11}, {44, 11}, {43, 12}, {38, 18}, {35, 24}, {33, 28}, {32, 31},
+		{29, 31}, {25, 32}, {20, 31}, {20, 33}, {15, 32}, {10, 34}, {11, 37}, {10, 37}, {3, 37}, {-2, 35}, {-6, 36},
+		{-9, 32}, {-10, 29}, {-13, 27}},
+	{{44, -25}, {47, -25}, {50, -15}, {49, -12}, {44, -17}},
+	// Southeast Asia
+	{{120, 18}, {122, 18}, {126, 7}, {125, 6}, {122, 7}, {120, 14}},
+	{{95, 5}, {98, 4}, {104, -2}, {106, -6}, {101, -3}},
+	{{106, -6}, {114, -7}, {114, -8.5}, {106, -7}},
+	{{109, 1}, {110, -3}, {116, -4}, {119, 1}, {117, 7}, {113, 3}},
+	{{131, -1}, {138, -2}, {146, -6}, {151, -10}, {141, -9}, {138, -8}, {132, -4}},
+	// Australia and New Zealand
+	{{114, -22}, {114, -26}, {115, -34}, {118, -35}, {124, -34}, {129, -32}, {135, -35}, {138, -35}, {140, -38},
+		{146, -39}, {150, -37}, {153, -32}, {153, -25}, {149, -20}, {146, -19}, {142, -11}, {141, -17}, {136, -15},
+		{137, -12}, {132, -11}, {129, -15}, {126, -14}, {122, -18}},
+	{{172, -34}, {178, -38}, {174, -41}, {171, -44}, {167, -46}, {170, -46}, {174, -41}},
+}
+
+// onLand reports whether a point falls inside one of the outlines.
+func onLand(lon, lat float64) bool {
+	for _, poly := range landOutlines {
+		in := false
+		for i, j := 0, len(poly)-1; i < len(poly); j, i = i, i+1 {
+			a, b := poly[i], poly[j]
+			if (a[1] > lat) != (b[1] > lat) && lon < (b[0]-a[0])*(lat-a[1])/(b[1]-a[1])+a[0] {
+				in = !in
+			}
+		}
+		if in {
+			return true
+		}
+	}
+	return false
+}
+
+// brailleDots are the bits of the dots of a braille cell, by row and column.
+var brailleDots = [4][2]rune{{0x01, 0x08}, {0x02, 0x10}, {0x04, 0x20}, {0x40, 0x80}}
+
+// worldLayout is the land drawn in braille for a pane of a given size, and
+// where the map sits in it.
+type worldLayout struct {
+	width, height int // of the pane
+	dotsW, dotsH  int // of the map, keeping the projection's aspect
+	offX, offY    int // cells from the corner of the pane to the map
+	cells         [][]rune
+}
+
+// layoutWorld fits the map into a pane of w by h cells. A cell holds two
+// by four braille dots, which are about square.
+func layoutWorld(w, h int) *worldLayout {
+	l := &worldLayout{width: w, height: h}
+	ratio := 360 / (mapNorth - mapSouth)
+	l.dotsW, l.dotsH = 2*w, 4*h
+	if float64(l.dotsW) > float64(l.dotsH)*ratio {
+		l.dotsW = int(float64(l.dotsH) * ratio)
+	} else {
+		l.dotsH = int(float64(l.dotsW) / ratio)
+	}
+	cw, ch := (l.dotsW+1)/2, (l.dotsH+3)/4
+	l.offX, l.offY = (w-cw)/2, (h-ch)/2
+	l.cells = make([][]rune, ch)
+	for cy := range l.cells {
+		l.cells[cy] = make([]rune, cw)
+		for cx := range l.cells[cy] {
+			r := rune(0x2800)
+			for dy := range 4 {
+				for dx := range 2 {
+					px, py := cx*2+dx, cy*4+dy
+					if px >= l.dotsW || py >= l.dotsH {
+						continue
+					}
+					lon := -180 + (float64(px)+0.5)/float64(l.dotsW)*360
+					lat := mapNorth - (float64(py)+0.5)/float64(l.dotsH)*(mapNorth-mapSouth)
+					if onLand(lon, lat) {
+						r |= brailleDots[dy][dx]
+					}
+				}
+			}
+			l.cells[cy][cx] = r
+		}
+	}
+	return l
+}
+
+// cell is the cell of the map a point falls in.
+func (l *worldLayout) cell(lon, lat float64) (int, int) {
+	px := int((lon + 180) / 360 * float64(l.dotsW))
+	py := int((mapNorth - lat) / (mapNorth - mapSouth) * float64(l.dotsH))
+	px = max(0, min(px, l.dotsW-1))
+	py = max(0, min(py, l.dotsH-1))
+	return px / 2, py / 4
+}
+
+// located reports whether GeoIP placed p; unplaced peers are at 0, 0.
+func located(p stats.PeerStat) bool {
+	return p.Lat != 0 || p.Lon != 0
+}
+
+// trafficColor shades a peer by its share of the busiest peer's traffic.
+func trafficColor(bytes, peak uint64) tcell.Color {
+	switch {
+	case peak == 0 || bytes*3 < peak:
+		return tcell.ColorGreen
+	case bytes*3 < peak*2:
+		return tcell.ColorYellow
+	}
+	return tcell.ColorRed
+}
+
+// drawWorld draws the land and the peers at their locations into the
+// inner rectangle of the world map pane. Peers sharing a cell show as
+// their number, in the color of the busiest.
+func (t *TUI) drawWorld(screen tcell.Screen, x, y, w, h int) {
+	if w < 8 || h < 4 {
+		return
+	}
+	h-- // the legend
+	if l := t.worldLayout; l == nil || l.width != w || l.height != h {
+		t.worldLayout = layoutWorld(w, h)
+	}
+	l := t.worldLayout
+	land := tcell.StyleDefault.Foreground(tcell.ColorDarkCyan)
+	for cy, row := range l.cells {
+		for cx, r := range row {
+			screen.SetContent(x+l.offX+cx, y+l.offY+cy, r, nil, land)
+		}
+	}
+
+	type spot struct {
+		n     int
+		bytes uint64
+	}
+	spots := make(map[[2]int]*spot)
+	var peak uint64
+	unplaced := 0
+	for _, p := range t.mapPeers {
+		if !located(p) {
+			unplaced++
+			continue
+		}
+		cx, cy := l.cell(p.Lon, p.Lat)
+		s := spots[[2]int{cx, cy}]
+		if s == nil {
+			s = &spot{}
+			spots[[2]int{cx, cy}] = s
+		}
+		s.n++
+		s.bytes = max(s.bytes, p.SentBytes+p.RecvBytes)
+		peak = max(peak, s.bytes)
+	}
+	for at, s := range spots {
+		mark := '●'
+		if s.n > 1 {
+			mark = '+'
+			if s.n < 10 {
+				mark = rune('0' + s.n)
+			}
+		}
+		style := tcell.StyleDefault.Foreground(trafficColor(s.bytes, peak)).Bold(true)
+		screen.SetContent(x+l.offX+at[0], y+l.offY+at[1], mark, nil, style)
+	}
+
+	legend := fmt.Sprintf("[green]●[-] quiet [yellow]●[-] busy [red]●[-] busiest  %d peers", len(t.mapPeers))
+	if unplaced > 0 {
+		legend += fmt.Sprintf(", %d not located", unplaced)
+	}
+	tview.Print(screen, legend, x, y+h, w, tview.AlignLeft, tcell.ColorWhite)
+}
+
+// toggleWorldMap switches the topology pane between the tree and the map.
+func (t *TUI) toggleWorldMap() {
+	if name, _ := t.mapPane.GetFrontPage(); name == "world" {
+		t.mapPane.SwitchToPage("tree")
+		return
+	}
+	t.mapPane.SwitchToPage("world")
+}